@@ -0,0 +1,108 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command kadsim runs the kademlia topology simulation and prints the report.
+// The report can be saved as JSON and compared with a report of another
+// code revision:
+//
+//	kadsim -nodes 1000 -ticks 20 -churn 0.01 -out base.json
+//	kadsim -nodes 1000 -ticks 20 -churn 0.01 -compare base.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/topology/kademlia/simulation"
+)
+
+func main() {
+	var (
+		o       simulation.Options
+		out     string
+		compare string
+	)
+	flag.IntVar(&o.Nodes, "nodes", 100, "number of nodes joining at the start")
+	flag.IntVar(&o.Bootnodes, "bootnodes", 1, "number of bootnodes")
+	flag.IntVar(&o.Ticks, "ticks", 10, "number of ticks after the initial join")
+	flag.Float64Var(&o.ChurnRate, "churn", 0, "fraction of nodes replaced in every tick")
+	flag.IntVar(&o.MeasureInterval, "measure-interval", 1, "number of ticks between measurements")
+	flag.IntVar(&o.Lookups, "lookups", 100, "number of routing lookups in every measurement")
+	flag.IntVar(&o.MaxHops, "max-hops", 32, "number of hops after which a lookup fails")
+	flag.Int64Var(&o.Seed, "seed", 0, "random seed")
+	flag.DurationVar(&o.SettleInterval, "settle-interval", 100*time.Millisecond, "duration without activity after which the network is settled")
+	flag.DurationVar(&o.SettleTimeout, "settle-timeout", time.Minute, "maximal duration of waiting for the network to settle")
+	flag.IntVar(&o.Kademlia.BitSuffixLength, "bit-suffix-length", 0, "kademlia bit suffix length, 0 for the default")
+	flag.StringVar(&out, "out", "", "file to write the JSON report to")
+	flag.StringVar(&compare, "compare", "", "JSON report to compare the results with")
+	flag.Parse()
+
+	if err := run(o, out, compare); err != nil {
+		fmt.Fprintln(os.Stderr, "kadsim:", err)
+		os.Exit(1)
+	}
+}
+
+func run(o simulation.Options, out, compare string) error {
+	var base *simulation.Report
+	if compare != "" {
+		b, err := ioutil.ReadFile(compare)
+		if err != nil {
+			return err
+		}
+		base = new(simulation.Report)
+		if err := json.Unmarshal(b, base); err != nil {
+			return fmt.Errorf("%s: %w", compare, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	report, err := simulation.New(o).Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := report.WriteText(os.Stdout); err != nil {
+		return err
+	}
+
+	if out != "" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(out, b, 0644); err != nil {
+			return err
+		}
+	}
+
+	if base != nil {
+		fmt.Println()
+		if base.Options != report.Options {
+			fmt.Printf("warning: options differ from %s: %+v\n\n", compare, base.Options)
+		}
+		return report.WriteComparison(os.Stdout, base)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/penguintop/penguin/pkg/crypto"
+	"github.com/penguintop/penguin/pkg/node"
+	"github.com/penguintop/penguin/pkg/property"
+	"github.com/penguintop/penguin/pkg/xwcfmt"
+	"github.com/spf13/cobra"
+)
+
+const (
+	optionNameNewKeyFile = "new-key-file"
+	optionNameDryRun     = "dry-run"
+)
+
+func (c *command) initChequebookCmd() {
+	cmd := &cobra.Command{
+		Use:   "chequebook",
+		Short: "Perform chequebook related operations",
+	}
+
+	c.chequebookTransferOwnershipCmd(cmd)
+
+	c.root.AddCommand(cmd)
+}
+
+func (c *command) chequebookTransferOwnershipCmd(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "transfer-ownership",
+		Short: "Transfer the chequebooks to a new node key",
+		Long: `Transfer the chequebooks to a new node key.
+
+The chequebook contracts of the default token and of the additional tokens
+are handed over to the owner of the new key, the last issued cheques are
+re-signed with the new key and the state store is updated, so that the node
+finds its chequebooks once it is started with the new key. The re-signed
+cheques are sent to the beneficiaries when they connect to the node again.
+The new key file holds the private key in WIF format as printed by dumpkey.`,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) > 0 {
+				return cmd.Help()
+			}
+
+			v := strings.ToLower(c.config.GetString(optionNameVerbosity))
+			logger, err := newLogger(cmd, v)
+			if err != nil {
+				return fmt.Errorf("new logger: %v", err)
+			}
+
+			newKeyFile := c.config.GetString(optionNameNewKeyFile)
+			if newKeyFile == "" {
+				return errors.New("no new key file provided")
+			}
+			dryRun := c.config.GetBool(optionNameDryRun)
+
+			newKeyWif, err := ioutil.ReadFile(newKeyFile)
+			if err != nil {
+				return fmt.Errorf("read new key: %w", err)
+			}
+			newKeyHex, err := xwcfmt.WifKeyToHexKey(string(bytes.TrimSpace(newKeyWif)))
+			if err != nil {
+				return fmt.Errorf("parse new key: %w", err)
+			}
+			newKeyBytes, err := hex.DecodeString(newKeyHex)
+			if err != nil {
+				return fmt.Errorf("parse new key: %w", err)
+			}
+			newKey, err := crypto.DecodeSecp256k1PrivateKey(newKeyBytes)
+			if err != nil {
+				return fmt.Errorf("parse new key: %w", err)
+			}
+			newSigner := crypto.NewDefaultSigner(newKey)
+
+			dataDir := c.config.GetString(optionNameDataDir)
+			swapEndpoint := c.config.GetString(optionNameSwapEndpoint)
+
+			stateStore, err := node.InitStateStore(logger, dataDir, c.config.GetString(optionNameStateStoreBackend))
+			if err != nil {
+				return err
+			}
+
+			defer stateStore.Close()
+
+			signerConfig, err := c.configureSigner(cmd, logger)
+			if err != nil {
+				return err
+			}
+			signer := signerConfig.signer
+
+			err = node.CheckOverlayWithStore(signerConfig.address, stateStore)
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+
+			swapBackend, overlayXwcAddress, _, chainID, transactionMonitor, transactionService, err := node.InitChain(
+				ctx,
+				logger,
+				stateStore,
+				swapEndpoint,
+				signer,
+				blocktime,
+			)
+			if err != nil {
+				return err
+			}
+			defer swapBackend.Close()
+			defer transactionMonitor.Close()
+
+			chequebookFactory, err := node.InitChequebookFactory(
+				logger,
+				swapBackend,
+				chainID,
+				transactionService,
+				"",
+				nil,
+			)
+			if err != nil {
+				return err
+			}
+
+			tokenFactories, err := node.InitTokenChequebookFactories(
+				logger,
+				swapBackend,
+				chainID,
+				transactionService,
+				c.config.GetStringSlice(optionNameSwapTokenFactoryAddresses),
+			)
+			if err != nil {
+				return err
+			}
+
+			transfers, err := node.TransferChequebookOwnership(
+				ctx,
+				stateStore,
+				signer,
+				newSigner,
+				chainID,
+				swapBackend,
+				overlayXwcAddress,
+				transactionService,
+				chequebookFactory,
+				tokenFactories,
+				dryRun,
+			)
+			// report the transfers which were completed before a failure
+			cheques := 0
+			for _, transfer := range transfers {
+				chequebookAddr, _ := xwcfmt.HexAddrToXwcConAddr(hex.EncodeToString(transfer.Chequebook[:]))
+				oldOwnerAddr, _ := xwcfmt.HexAddrToXwcAddr(hex.EncodeToString(transfer.OldOwner[:]))
+				newOwnerAddr, _ := xwcfmt.HexAddrToXwcAddr(hex.EncodeToString(transfer.NewOwner[:]))
+
+				logger.Infof("chequebook %s: owner %s -> %s", chequebookAddr, oldOwnerAddr, newOwnerAddr)
+				for _, cheque := range transfer.Cheques {
+					beneficiaryAddr, _ := xwcfmt.HexAddrToXwcAddr(hex.EncodeToString(cheque.Beneficiary[:]))
+					if dryRun {
+						logger.Infof("would re-sign cheque for %s with cumulative payout %d", beneficiaryAddr, cheque.CumulativePayout)
+					} else {
+						logger.Infof("re-signed cheque for %s with cumulative payout %d", beneficiaryAddr, cheque.CumulativePayout)
+					}
+				}
+				if !dryRun && transfer.TxHash != (common.Hash{}) {
+					logger.Infof("ownership transferred in transaction %x", transfer.TxHash[12:])
+				} else if !dryRun {
+					logger.Infof("ownership transferred by a previous run")
+				}
+				cheques += len(transfer.Cheques)
+			}
+			if err != nil {
+				return err
+			}
+
+			if dryRun {
+				logger.Infof("dry run: %d chequebooks would be transferred and %d cheques re-signed, nothing was changed", len(transfers), cheques)
+				return nil
+			}
+
+			newOverlay, err := crypto.NewOverlayAddress(newKey.PublicKey, uint64(property.CHAIN_ID_NUM))
+			if err != nil {
+				return err
+			}
+			err = node.ReplaceOverlayInStore(newOverlay, stateStore)
+			if err != nil {
+				return err
+			}
+
+			logger.Infof("replace the penguin key in the keystore with the new key before starting the node with overlay %s", newOverlay)
+			return nil
+		},
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.config.BindPFlags(cmd.Flags())
+		},
+	}
+
+	c.setAllFlags(cmd)
+	cmd.Flags().String(optionNameNewKeyFile, "", "file with the new private key in WIF format")
+	cmd.Flags().Bool(optionNameDryRun, false, "show the planned transfer without changing anything")
+
+	parent.AddCommand(cmd)
+}
@@ -12,7 +12,9 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/penguintop/penguin/pkg/localstore"
 	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/node"
 	"github.com/penguintop/penguin/pkg/penguin"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -26,6 +28,11 @@ const (
 	optionNameDBBlockCacheCapacity     = "db-block-cache-capacity"
 	optionNameDBWriteBufferSize        = "db-write-buffer-size"
 	optionNameDBDisableSeeksCompaction = "db-disable-seeks-compaction"
+	optionNameDBBlobStore              = "db-blob-store"
+	optionNameGCPolicy                 = "gc-policy"
+	optionNameDBHotCacheSize           = "db-hot-cache-size"
+	optionNameDBDurability             = "db-durability"
+	optionNameStateStoreBackend        = "statestore-backend"
 	optionNamePassword                 = "password"
 	optionNamePasswordFile             = "password-file"
 	optionNameAPIAddr                  = "api-addr"
@@ -33,6 +40,14 @@ const (
 	optionNameNATAddr                  = "nat-addr"
 	optionNameP2PWSEnable              = "p2p-ws-enable"
 	optionNameP2PQUICEnable            = "p2p-quic-enable"
+	optionNameP2PRelayEnable           = "p2p-relay-enable"
+	optionNameP2PRelayServer           = "p2p-relay-server"
+	optionNameP2PRelays                = "p2p-relays"
+	optionNameP2PBandwidthLimit        = "p2p-bandwidth-limit"
+	optionNameP2PProtocolLimits        = "p2p-protocol-bandwidth-limits"
+	optionNameP2PMaxInbound            = "p2p-max-inbound-connections"
+	optionNameP2PMaxOutbound           = "p2p-max-outbound-connections"
+	optionNameP2PMaxConnections        = "p2p-max-connections"
 	optionNameDebugAPIEnable           = "debug-api-enable"
 	optionNameDebugAPIAddr             = "debug-api-addr"
 	optionNameBootnodes                = "bootnode"
@@ -45,6 +60,7 @@ const (
 	optionNameTracingServiceName        = "tracing-service-name"
 	optionNameVerbosity                 = "verbosity"
 	optionNameGlobalPinningEnabled      = "global-pinning-enable"
+	optionNameRetrievalLatency          = "retrieval-latency-candidates"
 	optionNamePaymentThreshold          = "payment-threshold"
 	optionNamePaymentTolerance          = "payment-tolerance"
 	optionNamePaymentEarly              = "payment-early"
@@ -57,7 +73,9 @@ const (
 	optionNameSwapEndpoint              = "swap-endpoint"
 	//optionNameSwapFactoryAddress         = "swap-factory-address"
 	optionNameSwapLegacyFactoryAddresses = "swap-legacy-factory-addresses"
+	optionNameSwapTokenFactoryAddresses  = "swap-token-factory-addresses"
 	optionNameSwapInitialDeposit         = "swap-initial-deposit"
+	optionNameSwapMaxExposure            = "swap-max-exposure"
 	optionNameSwapEnable                 = "swap-enable"
 	optionNameTransactionHash            = "transaction"
 	optionNameSwapDeploymentGasPrice     = "swap-deployment-gas-price"
@@ -129,6 +147,8 @@ func newCommand(opts ...option) (c *command, err error) {
 
 	c.initVersionCmd()
 	c.initDBCmd()
+	c.initStateStoreCmd()
+	c.initChequebookCmd()
 
 	if err := c.initConfigurateOptionsCmd(); err != nil {
 		return nil, err
@@ -206,6 +226,11 @@ func (c *command) setAllFlags(cmd *cobra.Command) {
 	cmd.Flags().Uint64(optionNameDBBlockCacheCapacity, 32*1024*1024, "size of block cache of the database in bytes")
 	cmd.Flags().Uint64(optionNameDBWriteBufferSize, 32*1024*1024, "size of the database write buffer in bytes")
 	cmd.Flags().Bool(optionNameDBDisableSeeksCompaction, false, "disables db compactions triggered by seeks")
+	cmd.Flags().String(optionNameDBBlobStore, node.BlobStoreLevelDB, "storage for chunk data, leveldb or flatfile")
+	cmd.Flags().String(optionNameGCPolicy, localstore.GCPolicyLRU, "garbage collection policy, lru or cost-aware")
+	cmd.Flags().Uint64(optionNameDBHotCacheSize, 0, "size of the memory cache of requested chunks in bytes, 0 disables it")
+	cmd.Flags().String(optionNameDBDurability, "", "database write durability, sync, batched or unsafe, empty writes without syncing")
+	cmd.Flags().String(optionNameStateStoreBackend, "", "backend of a new statestore, leveldb or bolt, an existing statestore is migrated with the statestore migrate command")
 	cmd.Flags().String(optionNamePassword, "", "password for decrypting keys")
 	cmd.Flags().String(optionNamePasswordFile, "", "path to a file that contains password for decrypting keys")
 	cmd.Flags().String(optionNameAPIAddr, ":1633", "HTTP API listen address")
@@ -213,6 +238,14 @@ func (c *command) setAllFlags(cmd *cobra.Command) {
 	cmd.Flags().String(optionNameNATAddr, "", "NAT exposed address")
 	cmd.Flags().Bool(optionNameP2PWSEnable, false, "enable P2P WebSocket transport")
 	cmd.Flags().Bool(optionNameP2PQUICEnable, false, "enable P2P QUIC transport")
+	cmd.Flags().Bool(optionNameP2PRelayEnable, false, "enable P2P circuit relay and, with QUIC enabled, hole punching of relayed connections")
+	cmd.Flags().Bool(optionNameP2PRelayServer, false, "relay connections of other peers, requires P2P relay to be enabled")
+	cmd.Flags().StringSlice(optionNameP2PRelays, []string{}, "relay nodes to advertise relayed addresses through when not publicly reachable")
+	cmd.Flags().Int(optionNameP2PBandwidthLimit, 0, "limit of the P2P traffic of all protocols in bytes per second, 0 is unlimited; pull syncing yields to the other protocols only if it is set")
+	cmd.Flags().StringSlice(optionNameP2PProtocolLimits, []string{}, "limits of the P2P traffic per protocol in bytes per second, in the form of protocol=rate or protocol=rate:burst")
+	cmd.Flags().Int(optionNameP2PMaxInbound, 0, "maximum number of inbound connections to full nodes, 0 is unlimited")
+	cmd.Flags().Int(optionNameP2PMaxOutbound, 0, "maximum number of outbound connections to full nodes, 0 is unlimited")
+	cmd.Flags().Int(optionNameP2PMaxConnections, 0, "maximum number of connections to full nodes, 0 is unlimited")
 	cmd.Flags().StringSlice(optionNameBootnodes, []string{"/dnsaddr/penguin.top"}, "initial nodes to connect to")
 	cmd.Flags().Bool(optionNameDebugAPIEnable, false, "enable debug HTTP API")
 	cmd.Flags().String(optionNameDebugAPIAddr, ":1635", "debug HTTP API listen address")
@@ -225,6 +258,7 @@ func (c *command) setAllFlags(cmd *cobra.Command) {
 	cmd.Flags().String(optionNameVerbosity, "info", "log verbosity level 0=silent, 1=error, 2=warn, 3=info, 4=debug, 5=trace")
 	cmd.Flags().String(optionWelcomeMessage, "", "send a welcome message string during handshakes")
 	cmd.Flags().Bool(optionNameGlobalPinningEnabled, false, "enable global pinning")
+	cmd.Flags().Int(optionNameRetrievalLatency, 0, "number of the closest peers in the same proximity order to the chunk among which the one with the lowest latency is selected for retrieval, 0 or 1 selects the closest peer")
 	cmd.Flags().String(optionNamePaymentThreshold, "10000", "threshold in PEN where you expect to get paid from your peers")
 	cmd.Flags().String(optionNamePaymentTolerance, "100000", "excess debt above payment threshold in PEN where you disconnect from your peer")
 	cmd.Flags().String(optionNamePaymentEarly, "100000", "amount in PEN below the peers payment threshold when we initiate settlement")
@@ -237,7 +271,9 @@ func (c *command) setAllFlags(cmd *cobra.Command) {
 	cmd.Flags().String(optionNameSwapEndpoint, "ws://localhost:8546", "swap xwc blockchain endpoint")
 	//cmd.Flags().String(optionNameSwapFactoryAddress, "", "swap factory addresses")
 	cmd.Flags().StringSlice(optionNameSwapLegacyFactoryAddresses, nil, "legacy swap factory addresses")
+	cmd.Flags().StringSlice(optionNameSwapTokenFactoryAddresses, nil, "swap factory addresses of additional tokens to settle in")
 	cmd.Flags().String(optionNameSwapInitialDeposit, "100000000", "initial deposit if deploying a new chequebook")
+	cmd.Flags().String(optionNameSwapMaxExposure, "1000000000", "maximal amount of received cheques not cashed yet over all peers, 0 disables the limit")
 	cmd.Flags().Bool(optionNameSwapEnable, true, "enable swap")
 	cmd.Flags().Bool(optionNameFullNode, false, "cause the node to start in full mode")
 	cmd.Flags().String(optionNamePostageContractAddress, "", "postage stamp contract address")
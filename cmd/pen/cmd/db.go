@@ -5,6 +5,7 @@
 package cmd
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -13,9 +14,26 @@ import (
 	"strings"
 
 	"github.com/penguintop/penguin/pkg/localstore"
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/node"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/postage"
+	"github.com/penguintop/penguin/pkg/postage/batchstore"
 	"github.com/spf13/cobra"
 )
 
+const (
+	optionNameExportBatch    = "batch"
+	optionNameExportBin      = "bin"
+	optionNameExportPinned   = "pinned"
+	optionNameExportRoot     = "root"
+	optionNameExportCursor   = "cursor"
+	optionNameExportLimit    = "limit"
+	optionNameExportCompress = "compress"
+	optionNameCheckRepair    = "repair"
+	optionNameCheckRate      = "rate"
+)
+
 func (c *command) initDBCmd() {
 	cmd := &cobra.Command{
 		Use:   "db",
@@ -24,6 +42,7 @@ func (c *command) initDBCmd() {
 
 	dbExportCmd(cmd)
 	dbImportCmd(cmd)
+	dbCheckCmd(cmd)
 
 	c.root.AddCommand(cmd)
 }
@@ -56,12 +75,11 @@ func dbExportCmd(cmd *cobra.Command) {
 
 			logger.Infof("starting export process with data-dir at %s", dataDir)
 
-			path := filepath.Join(dataDir, "localstore")
-
-			storer, err := localstore.New(path, nil, nil, logger)
+			storer, err := openLocalstore(cmd, dataDir, logger)
 			if err != nil {
-				return fmt.Errorf("localstore: %w", err)
+				return err
 			}
+			defer storer.Close()
 
 			var out io.Writer
 			if args[0] == "-" {
@@ -74,18 +92,33 @@ func dbExportCmd(cmd *cobra.Command) {
 				defer f.Close()
 				out = f
 			}
-			c, err := storer.Export(out)
+			opts, err := exportOptions(cmd)
+			if err != nil {
+				return err
+			}
+			result, err := storer.Export(cmd.Context(), out, opts)
 			if err != nil {
 				return fmt.Errorf("error exporting database: %v", err)
 			}
 
-			logger.Infof("database exported %d records successfully", c)
+			logger.Infof("database exported %d records successfully", result.Count)
+			if !result.Complete {
+				logger.Infof("export limit reached, continue with --%s %s", optionNameExportCursor, result.Cursor)
+			}
 
 			return nil
 		},
 	}
 	c.Flags().String(optionNameDataDir, "", "data directory")
 	c.Flags().String(optionNameVerbosity, "info", "verbosity level")
+	c.Flags().String(optionNameDBBlobStore, node.BlobStoreLevelDB, "storage for chunk data, leveldb or flatfile")
+	c.Flags().String(optionNameExportBatch, "", "export only chunks stamped by the postage batch")
+	c.Flags().UintSlice(optionNameExportBin, nil, "export only chunks in the proximity order bins")
+	c.Flags().Bool(optionNameExportPinned, false, "export only pinned chunks")
+	c.Flags().String(optionNameExportRoot, "", "export only chunks reachable from the reference")
+	c.Flags().String(optionNameExportCursor, "", "resume the export after the chunk address")
+	c.Flags().Int64(optionNameExportLimit, 0, "maximal number of exported chunks")
+	c.Flags().Bool(optionNameExportCompress, false, "compress the export with zstd")
 	cmd.AddCommand(c)
 }
 
@@ -116,12 +149,11 @@ func dbImportCmd(cmd *cobra.Command) {
 
 			fmt.Printf("starting import process with data-dir at %s\n", dataDir)
 
-			path := filepath.Join(dataDir, "localstore")
-
-			storer, err := localstore.New(path, nil, nil, logger)
+			storer, err := openLocalstore(cmd, dataDir, logger)
 			if err != nil {
-				return fmt.Errorf("localstore: %w", err)
+				return err
 			}
+			defer storer.Close()
 
 			var in io.Reader
 			if args[0] == "-" {
@@ -134,17 +166,218 @@ func dbImportCmd(cmd *cobra.Command) {
 				defer f.Close()
 				in = f
 			}
-			c, err := storer.Import(cmd.Context(), in)
+			cursor, err := cursorFlag(cmd)
+			if err != nil {
+				return err
+			}
+			result, err := storer.Import(cmd.Context(), in, &localstore.ImportOptions{Cursor: cursor})
 			if err != nil {
+				if result != nil && !result.Cursor.IsZero() {
+					fmt.Printf("imported %d records, resume with --%s %s\n", result.Count, optionNameExportCursor, result.Cursor)
+				}
 				return fmt.Errorf("error importing database: %v", err)
 			}
 
-			fmt.Printf("database imported %d records successfully\n", c)
+			fmt.Printf("database imported %d records successfully, %d were already stored\n", result.Count, result.Existing)
+			if !result.Verified {
+				fmt.Println("import has no manifest and could not be verified")
+			}
 
 			return nil
 		},
 	}
 	c.Flags().String(optionNameDataDir, "", "data directory")
 	c.Flags().String(optionNameVerbosity, "info", "verbosity level")
+	c.Flags().String(optionNameDBBlobStore, node.BlobStoreLevelDB, "storage for chunk data, leveldb or flatfile")
+	c.Flags().String(optionNameExportCursor, "", "resume the import after the chunk address")
+	c.Flags().String(optionNameDBDurability, string(localstore.DurabilityDefault), "database write durability, sync, batched or unsafe for bulk loading, empty writes without syncing")
 	cmd.AddCommand(c)
 }
+
+func dbCheckCmd(cmd *cobra.Command) {
+	c := &cobra.Command{
+		Use:   "check",
+		Short: "Check the consistency of the DB and optionally repair it",
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			v, err := cmd.Flags().GetString(optionNameVerbosity)
+			if err != nil {
+				return fmt.Errorf("get verbosity: %v", err)
+			}
+			v = strings.ToLower(v)
+			logger, err := newLogger(cmd, v)
+			if err != nil {
+				return fmt.Errorf("new logger: %v", err)
+			}
+			dataDir, err := cmd.Flags().GetString(optionNameDataDir)
+			if err != nil {
+				return fmt.Errorf("get data-dir: %v", err)
+			}
+			if dataDir == "" {
+				return errors.New("no data-dir provided")
+			}
+			repair, err := cmd.Flags().GetBool(optionNameCheckRepair)
+			if err != nil {
+				return fmt.Errorf("get repair: %v", err)
+			}
+			rate, err := cmd.Flags().GetInt(optionNameCheckRate)
+			if err != nil {
+				return fmt.Errorf("get rate: %v", err)
+			}
+
+			logger.Infof("starting database check with data-dir at %s", dataDir)
+
+			stateStore, err := node.InitStateStore(logger, dataDir, "")
+			if err != nil {
+				return fmt.Errorf("statestore: %w", err)
+			}
+			defer stateStore.Close()
+
+			storer, err := openLocalstore(cmd, dataDir, logger)
+			if err != nil {
+				return err
+			}
+			defer storer.Close()
+
+			batchStore, err := batchstore.New(stateStore, storer.UnreserveBatch)
+			if err != nil {
+				return fmt.Errorf("batchstore: %w", err)
+			}
+
+			report, err := storer.Check(cmd.Context(), &localstore.CheckOptions{
+				Repair:     repair,
+				ValidStamp: postage.ValidStamp(batchStore),
+				Rate:       rate,
+			})
+			if err != nil {
+				return fmt.Errorf("error checking database: %v", err)
+			}
+
+			fmt.Printf("checked %d chunks\n", report.Chunks)
+			fmt.Printf("invalid chunks: %d\n", report.InvalidChunks)
+			fmt.Printf("invalid stamps: %d\n", report.InvalidStamps)
+			for name, n := range report.OrphanedEntries {
+				fmt.Printf("orphaned %s entries: %d\n", name, n)
+			}
+			for name, n := range report.MissingEntries {
+				fmt.Printf("missing %s entries: %d\n", name, n)
+			}
+			fmt.Printf("orphaned chunk data: %d\n", report.OrphanedData)
+			fmt.Printf("gc size: %d, gc index entries: %d\n", report.GCSize, report.GCIndexSize)
+			if repair {
+				fmt.Printf("repaired problems: %d\n", report.Repaired)
+			} else if !report.Consistent() {
+				fmt.Printf("database is not consistent, run with --%s to repair it\n", optionNameCheckRepair)
+			}
+
+			return nil
+		},
+	}
+	c.Flags().String(optionNameDataDir, "", "data directory")
+	c.Flags().String(optionNameVerbosity, "info", "verbosity level")
+	c.Flags().String(optionNameDBBlobStore, node.BlobStoreLevelDB, "storage for chunk data, leveldb or flatfile")
+	c.Flags().Bool(optionNameCheckRepair, false, "repair the found problems")
+	c.Flags().Int(optionNameCheckRate, 0, "maximal number of checked entries per second, 0 for no limit")
+	cmd.AddCommand(c)
+}
+
+// openLocalstore opens the localstore in the data directory with the blob
+// store selected by the command flags.
+func openLocalstore(cmd *cobra.Command, dataDir string, logger logging.Logger) (*localstore.DB, error) {
+	kind, err := cmd.Flags().GetString(optionNameDBBlobStore)
+	if err != nil {
+		return nil, fmt.Errorf("get db-blob-store: %v", err)
+	}
+	blobStore, err := node.InitChunkBlobStore(kind, dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("blob store: %w", err)
+	}
+
+	var durability localstore.DurabilityMode
+	if cmd.Flags().Lookup(optionNameDBDurability) != nil {
+		name, err := cmd.Flags().GetString(optionNameDBDurability)
+		if err != nil {
+			return nil, fmt.Errorf("get db-durability: %v", err)
+		}
+		if durability, err = localstore.ParseDurabilityMode(name); err != nil {
+			return nil, err
+		}
+	}
+
+	path := filepath.Join(dataDir, "localstore")
+	storer, err := localstore.New(path, nil, &localstore.Options{BlobStore: blobStore, Durability: durability}, logger)
+	if err != nil {
+		if blobStore != nil {
+			_ = blobStore.Close()
+		}
+		return nil, fmt.Errorf("localstore: %w", err)
+	}
+	return storer, nil
+}
+
+// exportOptions builds the export options from the command flags.
+func exportOptions(cmd *cobra.Command) (*localstore.ExportOptions, error) {
+	o := new(localstore.ExportOptions)
+
+	batch, err := cmd.Flags().GetString(optionNameExportBatch)
+	if err != nil {
+		return nil, err
+	}
+	if batch != "" {
+		o.BatchID, err = hex.DecodeString(batch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid batch id: %w", err)
+		}
+	}
+
+	bins, err := cmd.Flags().GetUintSlice(optionNameExportBin)
+	if err != nil {
+		return nil, err
+	}
+	for _, bin := range bins {
+		if bin > uint(penguin.MaxPO) {
+			return nil, fmt.Errorf("invalid bin %d", bin)
+		}
+		o.Bins = append(o.Bins, uint8(bin))
+	}
+
+	if o.Pinned, err = cmd.Flags().GetBool(optionNameExportPinned); err != nil {
+		return nil, err
+	}
+
+	root, err := cmd.Flags().GetString(optionNameExportRoot)
+	if err != nil {
+		return nil, err
+	}
+	if root != "" {
+		o.Root, err = penguin.ParseHexAddress(root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root reference: %w", err)
+		}
+	}
+
+	if o.Cursor, err = cursorFlag(cmd); err != nil {
+		return nil, err
+	}
+	if o.Limit, err = cmd.Flags().GetInt64(optionNameExportLimit); err != nil {
+		return nil, err
+	}
+	if o.Compress, err = cmd.Flags().GetBool(optionNameExportCompress); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func cursorFlag(cmd *cobra.Command) (penguin.Address, error) {
+	cursor, err := cmd.Flags().GetString(optionNameExportCursor)
+	if err != nil {
+		return penguin.ZeroAddress, err
+	}
+	if cursor == "" {
+		return penguin.ZeroAddress, nil
+	}
+	addr, err := penguin.ParseHexAddress(cursor)
+	if err != nil {
+		return penguin.ZeroAddress, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return addr, nil
+}
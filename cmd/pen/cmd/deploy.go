@@ -37,7 +37,7 @@ func (c *command) initDeployCmd() error {
 			swapEndpoint := c.config.GetString(optionNameSwapEndpoint)
 			deployGasPrice := c.config.GetString(optionNameSwapDeploymentGasPrice)
 
-			stateStore, err := node.InitStateStore(logger, dataDir)
+			stateStore, err := node.InitStateStore(logger, dataDir, c.config.GetString(optionNameStateStoreBackend))
 			if err != nil {
 				return err
 			}
@@ -133,12 +133,25 @@ HHHHHHHHHHHHHHHHHHHHH
 				DBBlockCacheCapacity:     c.config.GetUint64(optionNameDBBlockCacheCapacity),
 				DBWriteBufferSize:        c.config.GetUint64(optionNameDBWriteBufferSize),
 				DBDisableSeeksCompaction: c.config.GetBool(optionNameDBDisableSeeksCompaction),
+				DBBlobStore:              c.config.GetString(optionNameDBBlobStore),
+				GCPolicy:                 c.config.GetString(optionNameGCPolicy),
+				DBHotCacheSize:           c.config.GetUint64(optionNameDBHotCacheSize),
+				DBDurability:             c.config.GetString(optionNameDBDurability),
+				StateStoreBackend:        c.config.GetString(optionNameStateStoreBackend),
 				APIAddr:                  c.config.GetString(optionNameAPIAddr),
 				DebugAPIAddr:             debugAPIAddr,
 				Addr:                     c.config.GetString(optionNameP2PAddr),
 				NATAddr:                  c.config.GetString(optionNameNATAddr),
 				EnableWS:                 c.config.GetBool(optionNameP2PWSEnable),
 				EnableQUIC:               c.config.GetBool(optionNameP2PQUICEnable),
+				EnableRelay:              c.config.GetBool(optionNameP2PRelayEnable),
+				RelayServer:              c.config.GetBool(optionNameP2PRelayServer),
+				Relays:                   c.config.GetStringSlice(optionNameP2PRelays),
+				BandwidthLimit:           c.config.GetInt(optionNameP2PBandwidthLimit),
+				BandwidthProtocolLimits:  c.config.GetStringSlice(optionNameP2PProtocolLimits),
+				MaxInboundConnections:    c.config.GetInt(optionNameP2PMaxInbound),
+				MaxOutboundConnections:   c.config.GetInt(optionNameP2PMaxOutbound),
+				MaxConnections:           c.config.GetInt(optionNameP2PMaxConnections),
 				WelcomeMessage:           c.config.GetString(optionWelcomeMessage),
 				Bootnodes:                c.config.GetStringSlice(optionNameBootnodes),
 				CORSAllowedOrigins:       c.config.GetStringSlice(optionCORSAllowedOrigins),
@@ -148,6 +161,7 @@ HHHHHHHHHHHHHHHHHHHHH
 				TracingServiceName:       c.config.GetString(optionNameTracingServiceName),
 				Logger:                   logger,
 				GlobalPinningEnabled:     c.config.GetBool(optionNameGlobalPinningEnabled),
+				RetrievalLatencyPeers:    c.config.GetInt(optionNameRetrievalLatency),
 				PaymentThreshold:         c.config.GetString(optionNamePaymentThreshold),
 				PaymentTolerance:         c.config.GetString(optionNamePaymentTolerance),
 				PaymentEarly:             c.config.GetString(optionNamePaymentEarly),
@@ -158,7 +172,9 @@ HHHHHHHHHHHHHHHHHHHHH
 				//SwapFactoryAddress:         c.config.GetString(optionNameSwapFactoryAddress),
 				SwapFactoryAddress:         "",
 				SwapLegacyFactoryAddresses: c.config.GetStringSlice(optionNameSwapLegacyFactoryAddresses),
+				SwapTokenFactoryAddresses:  c.config.GetStringSlice(optionNameSwapTokenFactoryAddresses),
 				SwapInitialDeposit:         c.config.GetString(optionNameSwapInitialDeposit),
+				SwapMaxExposure:            c.config.GetString(optionNameSwapMaxExposure),
 				SwapEnable:                 c.config.GetBool(optionNameSwapEnable),
 				FullNodeMode:               fullNode,
 				Transaction:                c.config.GetString(optionNameTransactionHash),
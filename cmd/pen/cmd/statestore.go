@@ -0,0 +1,184 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/node"
+	"github.com/penguintop/penguin/pkg/statestore"
+	"github.com/spf13/cobra"
+)
+
+const optionNameStateStoreTo = "to"
+
+func (c *command) initStateStoreCmd() {
+	cmd := &cobra.Command{
+		Use:   "statestore",
+		Short: "Perform statestore related operations",
+	}
+
+	stateStoreMigrateCmd(cmd)
+	stateStoreDumpCmd(cmd)
+	stateStoreRestoreCmd(cmd)
+
+	c.root.AddCommand(cmd)
+}
+
+func stateStoreMigrateCmd(cmd *cobra.Command) {
+	c := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate the statestore to another backend. The migrated statestore is kept as a backup",
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			logger, dataDir, err := stateStoreFlags(cmd)
+			if err != nil {
+				return err
+			}
+			to, err := cmd.Flags().GetString(optionNameStateStoreTo)
+			if err != nil {
+				return fmt.Errorf("get to: %v", err)
+			}
+			if to == "" {
+				return errors.New("no target backend provided")
+			}
+
+			logger.Infof("migrating statestore with data-dir at %s to %s", dataDir, to)
+
+			entries, backupPath, err := node.MigrateStateStore(logger, dataDir, to)
+			if err != nil {
+				return fmt.Errorf("error migrating statestore: %v", err)
+			}
+
+			logger.Infof("statestore migrated %d entries successfully, previous statestore is kept at %s", entries, backupPath)
+			return nil
+		},
+	}
+	c.Flags().String(optionNameDataDir, "", "data directory")
+	c.Flags().String(optionNameVerbosity, "info", "verbosity level")
+	c.Flags().String(optionNameStateStoreTo, "", "target backend, leveldb or bolt")
+	cmd.AddCommand(c)
+}
+
+func stateStoreDumpCmd(cmd *cobra.Command) {
+	c := &cobra.Command{
+		Use:   "dump <filename>",
+		Short: "Dump the statestore entries to a JSON file. Use \"-\" as filename in order to write to STDOUT",
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if (len(args)) != 1 {
+				return cmd.Help()
+			}
+			logger, dataDir, err := stateStoreFlags(cmd)
+			if err != nil {
+				return err
+			}
+
+			stateStore, err := node.InitStateStore(logger, dataDir, "")
+			if err != nil {
+				return fmt.Errorf("statestore: %w", err)
+			}
+			defer stateStore.Close()
+
+			var out io.Writer
+			if args[0] == "-" {
+				out = os.Stdout
+			} else {
+				f, err := os.Create(args[0])
+				if err != nil {
+					return fmt.Errorf("error opening output file: %s", err)
+				}
+				defer f.Close()
+				out = f
+			}
+
+			entries, err := statestore.Dump(out, stateStore)
+			if err != nil {
+				return fmt.Errorf("error dumping statestore: %v", err)
+			}
+
+			logger.Infof("statestore dumped %d entries successfully", entries)
+			return nil
+		},
+	}
+	c.Flags().String(optionNameDataDir, "", "data directory")
+	c.Flags().String(optionNameVerbosity, "info", "verbosity level")
+	cmd.AddCommand(c)
+}
+
+func stateStoreRestoreCmd(cmd *cobra.Command) {
+	c := &cobra.Command{
+		Use:   "restore <filename>",
+		Short: "Restore the statestore entries from a JSON file. Use \"-\" as filename in order to feed from STDIN",
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if (len(args)) != 1 {
+				return cmd.Help()
+			}
+			logger, dataDir, err := stateStoreFlags(cmd)
+			if err != nil {
+				return err
+			}
+			backend, err := cmd.Flags().GetString(optionNameStateStoreBackend)
+			if err != nil {
+				return fmt.Errorf("get statestore-backend: %v", err)
+			}
+
+			stateStore, err := node.InitStateStore(logger, dataDir, backend)
+			if err != nil {
+				return fmt.Errorf("statestore: %w", err)
+			}
+			defer stateStore.Close()
+
+			var in io.Reader
+			if args[0] == "-" {
+				in = os.Stdin
+			} else {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("error opening input file: %s", err)
+				}
+				defer f.Close()
+				in = f
+			}
+
+			entries, err := statestore.Restore(in, stateStore)
+			if err != nil {
+				return fmt.Errorf("error restoring statestore: %v", err)
+			}
+
+			logger.Infof("statestore restored %d entries successfully", entries)
+			return nil
+		},
+	}
+	c.Flags().String(optionNameDataDir, "", "data directory")
+	c.Flags().String(optionNameVerbosity, "info", "verbosity level")
+	c.Flags().String(optionNameStateStoreBackend, "", "backend of a new statestore, leveldb or bolt")
+	cmd.AddCommand(c)
+}
+
+// stateStoreFlags returns the logger and the data directory
+// from the flags of the statestore commands.
+func stateStoreFlags(cmd *cobra.Command) (logging.Logger, string, error) {
+	v, err := cmd.Flags().GetString(optionNameVerbosity)
+	if err != nil {
+		return nil, "", fmt.Errorf("get verbosity: %v", err)
+	}
+	v = strings.ToLower(v)
+	logger, err := newLogger(cmd, v)
+	if err != nil {
+		return nil, "", fmt.Errorf("new logger: %v", err)
+	}
+	dataDir, err := cmd.Flags().GetString(optionNameDataDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("get data-dir: %v", err)
+	}
+	if dataDir == "" {
+		return nil, "", errors.New("no data-dir provided")
+	}
+	return logger, dataDir, nil
+}
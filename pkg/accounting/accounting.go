@@ -26,6 +26,7 @@ var (
 	_                     Interface = (*Accounting)(nil)
 	balancesPrefix        string    = "accounting_balance_"
 	balancesSurplusPrefix string    = "accounting_surplusbalance_"
+	paymentsSentPrefix    string    = "accounting_paymentsent_"
 	// fraction of the refresh rate that is the minimum for monetary settlement
 	// this value is chosen so that tiny payments are prevented while still allowing small payments in environments with lower payment thresholds
 	minimumPaymentDivisor = int64(5)
@@ -88,6 +89,7 @@ type accountingPeer struct {
 	paymentThreshold      *big.Int   // the threshold at which the peer expects us to pay
 	refreshTimestamp      int64      // last time we attempted time-based settlement
 	paymentOngoing        bool       // indicate if we are currently settling with the peer
+	paymentTolerance      *big.Int   // tolerance for the peer overriding the default, nil if not set
 }
 
 // Accounting is the main implementation of the accounting interface.
@@ -140,7 +142,7 @@ func NewAccounting(
 	Pricing pricing.Interface,
 	refreshRate *big.Int,
 ) (*Accounting, error) {
-	return &Accounting{
+	a := &Accounting{
 		accountingPeers:  make(map[string]*accountingPeer),
 		paymentThreshold: new(big.Int).Set(PaymentThreshold),
 		paymentTolerance: new(big.Int).Set(PaymentTolerance),
@@ -153,7 +155,12 @@ func NewAccounting(
 		refreshRate:      refreshRate,
 		timeNow:          time.Now,
 		minimumPayment:   new(big.Int).Div(refreshRate, big.NewInt(minimumPaymentDivisor)),
-	}, nil
+	}
+
+	if err := a.applyPaymentsSent(); err != nil {
+		return nil, fmt.Errorf("apply payments sent: %w", err)
+	}
+	return a, nil
 }
 
 // Reserve reserves a portion of the balance for peer and attempts settlements if necessary.
@@ -396,6 +403,12 @@ func peerSurplusBalanceKey(peer penguin.Address) string {
 	return fmt.Sprintf("%s%s", balancesSurplusPrefix, peer.String())
 }
 
+// peerPaymentSentKey returns the storage key of the payment sent to the
+// given peer which is not yet applied to its balance.
+func peerPaymentSentKey(peer penguin.Address) string {
+	return fmt.Sprintf("%s%s", paymentsSentPrefix, peer.String())
+}
+
 // getAccountingPeer returns the accountingPeer for a given penguin address.
 // If not found in memory it will initialize it.
 func (a *Accounting) getAccountingPeer(peer penguin.Address) *accountingPeer {
@@ -512,6 +525,23 @@ func balanceKeyPeer(key []byte) (penguin.Address, error) {
 	return addr, nil
 }
 
+// paymentSentKeyPeer returns the embedded peer from the sent payment storage key.
+func paymentSentKeyPeer(key []byte) (penguin.Address, error) {
+	k := string(key)
+
+	split := strings.SplitAfter(k, paymentsSentPrefix)
+	if len(split) != 2 {
+		return penguin.ZeroAddress, errors.New("no peer in key")
+	}
+
+	addr, err := penguin.ParseHexAddress(split[1])
+	if err != nil {
+		return penguin.ZeroAddress, err
+	}
+
+	return addr, nil
+}
+
 func surplusBalanceKeyPeer(key []byte) (penguin.Address, error) {
 	k := string(key)
 
@@ -621,13 +651,71 @@ func (a *Accounting) NotifyPaymentSent(peer penguin.Address, amount *big.Int, re
 
 	a.logger.Tracef("registering payment sent to peer %v with amount %d, new balance is %d", peer, amount, nextBalance)
 
-	err = a.store.Put(peerBalanceKey(peer), nextBalance)
+	// the payment recorded by PersistPaymentSent is applied now
+	err = a.store.Batch(func(b storage.StateBatch) error {
+		if err := b.Put(peerBalanceKey(peer), nextBalance); err != nil {
+			return err
+		}
+		return b.Delete(peerPaymentSentKey(peer))
+	})
 	if err != nil {
 		a.logger.Errorf("accounting: notifypaymentsent failed to persist balance: %v", err)
 		return
 	}
 }
 
+// PersistPaymentSent returns the function which records the payment sent to
+// the peer in the batch which persists the payment, so that the payment is
+// applied to the balance even if the node stops before NotifyPaymentSent.
+func (a *Accounting) PersistPaymentSent(peer penguin.Address, amount *big.Int) func(b storage.StateBatch) error {
+	return func(b storage.StateBatch) error {
+		return b.Put(peerPaymentSentKey(peer), amount)
+	}
+}
+
+// applyPaymentsSent applies the recorded payments to the balances
+// of the peers, which were not notified before the node stopped.
+func (a *Accounting) applyPaymentsSent() error {
+	var peers []penguin.Address
+	err := a.store.Iterate(paymentsSentPrefix, func(key, val []byte) (stop bool, err error) {
+		addr, err := paymentSentKeyPeer(key)
+		if err != nil {
+			return false, fmt.Errorf("parse address from key: %s: %v", string(key), err)
+		}
+		peers = append(peers, addr)
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range peers {
+		var amount *big.Int
+		if err := a.store.Get(peerPaymentSentKey(peer), &amount); err != nil {
+			return fmt.Errorf("failed to load payment: %w", err)
+		}
+		currentBalance, err := a.Balance(peer)
+		if err != nil {
+			if !errors.Is(err, ErrPeerNoBalance) {
+				return fmt.Errorf("failed to load balance: %w", err)
+			}
+		}
+		nextBalance := new(big.Int).Add(currentBalance, amount)
+
+		err = a.store.Batch(func(b storage.StateBatch) error {
+			if err := b.Put(peerBalanceKey(peer), nextBalance); err != nil {
+				return err
+			}
+			return b.Delete(peerPaymentSentKey(peer))
+		})
+		if err != nil {
+			return fmt.Errorf("failed to persist balance: %w", err)
+		}
+		a.logger.Debugf("accounting: applied payment sent to peer %v with amount %d, new balance is %d", peer, amount, nextBalance)
+	}
+	return nil
+}
+
 // NotifyPaymentThreshold should be called to notify accounting of changes in the payment threshold
 func (a *Accounting) NotifyPaymentThreshold(peer penguin.Address, paymentThreshold *big.Int) error {
 	accountingPeer := a.getAccountingPeer(peer)
@@ -639,6 +727,26 @@ func (a *Accounting) NotifyPaymentThreshold(peer penguin.Address, paymentThresho
 	return nil
 }
 
+// SetPaymentTolerance overrides the amount the peer may exceed the payment
+// threshold before it is disconnected. A nil tolerance restores the default.
+func (a *Accounting) SetPaymentTolerance(peer penguin.Address, paymentTolerance *big.Int) error {
+	accountingPeer := a.getAccountingPeer(peer)
+
+	accountingPeer.lock.Lock()
+	defer accountingPeer.lock.Unlock()
+
+	if paymentTolerance == nil {
+		accountingPeer.paymentTolerance = nil
+		return nil
+	}
+	if paymentTolerance.Sign() < 0 {
+		return ErrInvalidValue
+	}
+
+	accountingPeer.paymentTolerance = new(big.Int).Set(paymentTolerance)
+	return nil
+}
+
 // NotifyPayment is called by Settlement when we receive a payment.
 func (a *Accounting) NotifyPaymentReceived(peer penguin.Address, amount *big.Int) error {
 	accountingPeer := a.getAccountingPeer(peer)
@@ -684,14 +792,10 @@ func (a *Accounting) NotifyPaymentReceived(peer penguin.Address, amount *big.Int
 
 	a.logger.Tracef("crediting peer %v with amount %d due to payment, new balance is %d", peer, amount, nextBalance)
 
-	err = a.store.Put(peerBalanceKey(peer), nextBalance)
-	if err != nil {
-		return fmt.Errorf("failed to persist balance: %w", err)
-	}
-
 	// If payment would have put us into debt, rather, let's add to surplusBalance,
 	// so as that an oversettlement attempt creates balance for future forwarding services
 	// charges to be deducted of
+	var increasedSurplus *big.Int
 	if newBalance.Cmp(big.NewInt(0)) < 0 {
 		surplusGrowth := new(big.Int).Sub(amount, currentBalance)
 
@@ -699,17 +803,23 @@ func (a *Accounting) NotifyPaymentReceived(peer penguin.Address, amount *big.Int
 		if err != nil {
 			return fmt.Errorf("failed to get surplus balance: %w", err)
 		}
-		increasedSurplus := new(big.Int).Add(surplus, surplusGrowth)
+		increasedSurplus = new(big.Int).Add(surplus, surplusGrowth)
 
 		a.logger.Tracef("surplus crediting peer %v with amount %d due to refreshment, new surplus balance is %d", peer, surplusGrowth, increasedSurplus)
-
-		err = a.store.Put(peerSurplusBalanceKey(peer), increasedSurplus)
-		if err != nil {
-			return fmt.Errorf("failed to persist surplus balance: %w", err)
-		}
 	}
 
-	return nil
+	// the balance and the surplus balance are persisted together
+	return a.store.Batch(func(b storage.StateBatch) error {
+		if err := b.Put(peerBalanceKey(peer), nextBalance); err != nil {
+			return fmt.Errorf("failed to persist balance: %w", err)
+		}
+		if increasedSurplus != nil {
+			if err := b.Put(peerSurplusBalanceKey(peer), increasedSurplus); err != nil {
+				return fmt.Errorf("failed to persist surplus balance: %w", err)
+			}
+		}
+		return nil
+	})
 }
 
 // NotifyRefreshmentReceived is called by pseudosettle when we receive a time based settlement.
@@ -846,10 +956,15 @@ func (d *debitAction) Apply() error {
 	a.metrics.TotalDebitedAmount.Add(tot)
 	a.metrics.DebitEventsCount.Inc()
 
-	if nextBalance.Cmp(a.disconnectLimit) >= 0 {
+	disconnectLimit := a.disconnectLimit
+	if d.accountingPeer.paymentTolerance != nil {
+		disconnectLimit = new(big.Int).Add(a.paymentThreshold, d.accountingPeer.paymentTolerance)
+	}
+
+	if nextBalance.Cmp(disconnectLimit) >= 0 {
 		// peer too much in debt
 		a.metrics.AccountingDisconnectsCount.Inc()
-		return p2p.NewBlockPeerError(24*time.Hour, ErrDisconnectThresholdExceeded)
+		return p2p.NewBlockPeerReasonError(24*time.Hour, "accounting: debt above the disconnect threshold", ErrDisconnectThresholdExceeded)
 	}
 
 	return nil
@@ -871,3 +986,20 @@ func (a *Accounting) SetRefreshFunc(f RefreshFunc) {
 func (a *Accounting) SetPayFunc(f PayFunc) {
 	a.payFunction = f
 }
+
+// SetTimeNow replaces the clock used for time-based settlement. It is meant
+// for simulations and tests driving accounting with a virtual clock.
+func (a *Accounting) SetTimeNow(f func() time.Time) {
+	a.timeNow = f
+}
+
+// IsPaymentOngoing reports whether a monetary settlement with the peer has
+// been started and not yet reported through NotifyPaymentSent.
+func (a *Accounting) IsPaymentOngoing(peer penguin.Address) bool {
+	accountingPeer := a.getAccountingPeer(peer)
+
+	accountingPeer.lock.Lock()
+	defer accountingPeer.lock.Unlock()
+
+	return accountingPeer.paymentOngoing
+}
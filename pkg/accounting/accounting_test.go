@@ -230,6 +230,68 @@ func TestAccountingDisconnect(t *testing.T) {
 	if !errors.As(err, &e) {
 		t.Fatalf("expected BlockPeerError, got %v", err)
 	}
+	if reason := e.Reason(); reason != "accounting: debt above the disconnect threshold" {
+		t.Fatalf("got block reason %q", reason)
+	}
+}
+
+// TestAccountingDisconnectPeerTolerance tests that a peer specific tolerance
+// replaces the default one for the disconnect limit
+func TestAccountingDisconnectPeerTolerance(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+
+	store := mock.NewStateStore()
+	defer store.Close()
+
+	acc, err := accounting.NewAccounting(testPaymentThreshold, testPaymentTolerance, testPaymentEarly, logger, store, nil, big.NewInt(testRefreshRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer1Addr, err := penguin.ParseHexAddress("00112233")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = acc.SetPaymentTolerance(peer1Addr, big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// put the peer 1 unit away from disconnect without tolerance
+	debitAction := acc.PrepareDebit(peer1Addr, testPaymentThreshold.Uint64()-1)
+	err = debitAction.Apply()
+	if err != nil {
+		t.Fatal("expected no error while still below threshold")
+	}
+	debitAction.Cleanup()
+
+	debitAction = acc.PrepareDebit(peer1Addr, 1)
+	err = debitAction.Apply()
+	debitAction.Cleanup()
+
+	var e *p2p.BlockPeerError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected BlockPeerError, got %v", err)
+	}
+
+	// restoring the default tolerance accepts further debt
+	err = acc.SetPaymentTolerance(peer1Addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	debitAction = acc.PrepareDebit(peer1Addr, 1)
+	err = debitAction.Apply()
+	if err != nil {
+		t.Fatal("expected no error while within default tolerance")
+	}
+	debitAction.Cleanup()
+
+	err = acc.SetPaymentTolerance(peer1Addr, big.NewInt(-1))
+	if !errors.Is(err, accounting.ErrInvalidValue) {
+		t.Fatalf("expected %v, got %v", accounting.ErrInvalidValue, err)
+	}
 }
 
 // TestAccountingCallSettlement tests that settlement is called correctly if the payment threshold is hit
@@ -826,6 +888,61 @@ func TestAccountingNotifyPaymentReceived(t *testing.T) {
 	}
 }
 
+// TestAccountingPaymentSentPersisted tests that a recorded payment is applied
+// to the balance once, even if the node stopped before being notified of it.
+func TestAccountingPaymentSentPersisted(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+
+	store := mock.NewStateStore()
+	defer store.Close()
+
+	acc, err := accounting.NewAccounting(testPaymentThreshold, testPaymentTolerance, testPaymentEarly, logger, store, nil, big.NewInt(testRefreshRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer1Addr, err := penguin.ParseHexAddress("00112233")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer2Addr, err := penguin.ParseHexAddress("00112244")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the payment to the first peer is notified, the payment
+	// to the second one only recorded before the restart
+	if err := store.Batch(acc.PersistPaymentSent(peer1Addr, big.NewInt(100))); err != nil {
+		t.Fatal(err)
+	}
+	acc.NotifyPaymentSent(peer1Addr, big.NewInt(100), nil)
+
+	if err := store.Batch(acc.PersistPaymentSent(peer2Addr, big.NewInt(50))); err != nil {
+		t.Fatal(err)
+	}
+
+	acc, err = accounting.NewAccounting(testPaymentThreshold, testPaymentTolerance, testPaymentEarly, logger, store, nil, big.NewInt(testRefreshRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		peer penguin.Address
+		want int64
+	}{
+		{peer: peer1Addr, want: 100},
+		{peer: peer2Addr, want: 50},
+	} {
+		balance, err := acc.Balance(tc.peer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance.Int64() != tc.want {
+			t.Fatalf("got balance %d for peer %s, want %d", balance, tc.peer, tc.want)
+		}
+	}
+}
+
 type pricingMock struct {
 	called           bool
 	peer             penguin.Address
@@ -0,0 +1,650 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package simulation runs a deterministic, in-process network of nodes with
+// real accounting instances, mock time-based and monetary settlement and
+// synthetic retrieval and push traffic. It is used to evaluate the effect of
+// payment thresholds, tolerances and refresh rates on the network as a whole.
+package simulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/accounting"
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/pricer"
+	"github.com/penguintop/penguin/pkg/statestore/mock"
+)
+
+var (
+	// ErrInvalidConfig is returned by New if the configuration can not be
+	// simulated.
+	ErrInvalidConfig = errors.New("invalid simulation config")
+
+	errInsufficientFunds = errors.New("insufficient chequebook balance")
+)
+
+// Config holds the parameters of a simulation run.
+type Config struct {
+	// Number of simulated nodes.
+	Nodes int
+	// Number of peers every node connects to. Connections are symmetric,
+	// so nodes may end up with more peers than this.
+	Peers int
+	// Number of nodes which consume services but never reserve or settle.
+	Freeriders int
+	// Number of simulation steps.
+	Steps int
+	// Virtual time passing in every step. Must be at least one second as
+	// time-based settlement works in full seconds.
+	StepDuration time.Duration
+	// Number of requests originated in every step.
+	RequestsPerStep int
+	// Fraction of requests which are pushes instead of retrievals.
+	PushRatio float64
+	// Price per proximity order used by every node's pricer.
+	PoPrice uint64
+	// Accounting parameters shared by all nodes.
+	PaymentThreshold *big.Int
+	PaymentTolerance *big.Int
+	EarlyPayment     *big.Int
+	RefreshRate      *big.Int
+	// Number of steps between issuing a monetary payment and its arrival.
+	SettlementDelay int
+	// Funds available to every node for monetary settlement. Nil means
+	// unlimited funds.
+	ChequebookBalance *big.Int
+	// Seed of the random source; runs with the same config and seed produce
+	// the same report.
+	Seed int64
+}
+
+// DefaultConfig returns a small simulation configuration using the node
+// default accounting parameters.
+func DefaultConfig() Config {
+	return Config{
+		Nodes:            50,
+		Peers:            8,
+		Steps:            600,
+		StepDuration:     time.Second,
+		RequestsPerStep:  100,
+		PushRatio:        0.3,
+		PoPrice:          10000,
+		PaymentThreshold: big.NewInt(10000000),
+		PaymentTolerance: big.NewInt(5000000),
+		EarlyPayment:     big.NewInt(1000000),
+		RefreshRate:      big.NewInt(4500000),
+		SettlementDelay:  2,
+	}
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.Nodes < 2:
+		return fmt.Errorf("%w: at least two nodes are required", ErrInvalidConfig)
+	case c.Peers < 1 || c.Peers >= c.Nodes:
+		return fmt.Errorf("%w: peers must be between 1 and %d", ErrInvalidConfig, c.Nodes-1)
+	case c.Freeriders < 0 || c.Freeriders >= c.Nodes:
+		return fmt.Errorf("%w: freeriders must be between 0 and %d", ErrInvalidConfig, c.Nodes-1)
+	case c.Steps < 0 || c.RequestsPerStep < 0 || c.SettlementDelay < 0:
+		return fmt.Errorf("%w: negative step or request count", ErrInvalidConfig)
+	case c.StepDuration < time.Second:
+		return fmt.Errorf("%w: step duration must be at least one second", ErrInvalidConfig)
+	case c.PushRatio < 0 || c.PushRatio > 1:
+		return fmt.Errorf("%w: push ratio must be between 0 and 1", ErrInvalidConfig)
+	case c.PaymentThreshold == nil || c.PaymentTolerance == nil || c.EarlyPayment == nil:
+		return fmt.Errorf("%w: payment threshold, tolerance and early payment are required", ErrInvalidConfig)
+	case c.RefreshRate == nil || c.RefreshRate.Sign() <= 0:
+		return fmt.Errorf("%w: refresh rate must be positive", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// Report summarises the outcome of a simulation run.
+type Report struct {
+	Steps      int
+	Requests   int
+	Retrievals int
+	Pushes     int
+	// Requests served by the originating node itself.
+	LocalRequests int
+	// Requests which could not be forwarded because a reserve would have
+	// exceeded the payment threshold.
+	OverdraftRequests int
+	// Number of times a node disconnected a peer for exceeding the
+	// disconnect threshold.
+	Disconnects    int
+	PaymentsIssued int
+	PaymentsFailed int
+	// Total amount charged for forwarded requests.
+	TrafficVolume *big.Int
+	// Total amount settled through monetary payments.
+	SettlementVolume *big.Int
+	// Total amount settled through time-based refreshments.
+	RefreshmentVolume *big.Int
+	// Distribution of the debt between connected nodes at the end of the
+	// run, in both directions of every connection.
+	Debt Distribution
+}
+
+// Distribution describes a set of amounts.
+type Distribution struct {
+	Count  int
+	Min    *big.Int
+	Max    *big.Int
+	Mean   *big.Int
+	Median *big.Int
+	P90    *big.Int
+	P99    *big.Int
+}
+
+func newDistribution(values []*big.Int) Distribution {
+	d := Distribution{
+		Count:  len(values),
+		Min:    big.NewInt(0),
+		Max:    big.NewInt(0),
+		Mean:   big.NewInt(0),
+		Median: big.NewInt(0),
+		P90:    big.NewInt(0),
+		P99:    big.NewInt(0),
+	}
+	if len(values) == 0 {
+		return d
+	}
+
+	sorted := make([]*big.Int, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Cmp(sorted[j]) < 0
+	})
+
+	percentile := func(p int) *big.Int {
+		return new(big.Int).Set(sorted[(len(sorted)-1)*p/100])
+	}
+
+	sum := big.NewInt(0)
+	for _, v := range sorted {
+		sum.Add(sum, v)
+	}
+
+	d.Min.Set(sorted[0])
+	d.Max.Set(sorted[len(sorted)-1])
+	d.Mean.Div(sum, big.NewInt(int64(len(sorted))))
+	d.Median = percentile(50)
+	d.P90 = percentile(90)
+	d.P99 = percentile(99)
+	return d
+}
+
+func (d Distribution) String() string {
+	return fmt.Sprintf("count=%d min=%s median=%s mean=%s p90=%s p99=%s max=%s", d.Count, d.Min, d.Median, d.Mean, d.P90, d.P99, d.Max)
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "steps: %d\n", r.Steps)
+	fmt.Fprintf(&b, "requests: %d (retrievals %d, pushes %d, local %d, overdraft %d)\n", r.Requests, r.Retrievals, r.Pushes, r.LocalRequests, r.OverdraftRequests)
+	fmt.Fprintf(&b, "disconnects: %d\n", r.Disconnects)
+	fmt.Fprintf(&b, "payments: %d issued, %d failed\n", r.PaymentsIssued, r.PaymentsFailed)
+	fmt.Fprintf(&b, "traffic volume: %s\n", r.TrafficVolume)
+	fmt.Fprintf(&b, "settlement volume: %s\n", r.SettlementVolume)
+	fmt.Fprintf(&b, "refreshment volume: %s\n", r.RefreshmentVolume)
+	fmt.Fprintf(&b, "debt: %s\n", r.Debt)
+	return b.String()
+}
+
+type node struct {
+	index      int
+	overlay    penguin.Address
+	accounting *accounting.Accounting
+	pricer     *pricer.FixedPricer
+	peers      []*node
+	freerider  bool
+	// remaining funds for monetary settlement, nil if unlimited
+	funds *big.Int
+	// last time-based settlement accepted from each peer, by peer index
+	lastRefresh map[int]int64
+}
+
+// link identifies the direction of a connection from a payer to a payee.
+type link struct {
+	from, to int
+}
+
+type payment struct {
+	from   *node
+	to     *node
+	amount *big.Int
+	due    int
+}
+
+// Simulation is a single simulation run.
+type Simulation struct {
+	config   Config
+	rand     *rand.Rand
+	nodes    []*node
+	byAddr   map[string]*node
+	now      time.Time
+	step     int
+	payments chan payment
+	pending  []payment
+	inflight map[link]bool
+	// step until which a connection is blocked, by unordered link
+	blocked map[link]int
+	report  Report
+}
+
+// New creates a simulation with the given config. Nodes and their
+// connections are derived from the config seed.
+func New(config Config) (*Simulation, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	s := &Simulation{
+		config:   config,
+		rand:     rand.New(rand.NewSource(config.Seed)),
+		byAddr:   make(map[string]*node),
+		now:      time.Unix(1600000000, 0),
+		payments: make(chan payment),
+		inflight: make(map[link]bool),
+		blocked:  make(map[link]int),
+		report: Report{
+			TrafficVolume:     big.NewInt(0),
+			SettlementVolume:  big.NewInt(0),
+			RefreshmentVolume: big.NewInt(0),
+		},
+	}
+
+	logger := logging.New(ioutil.Discard, 0)
+	for i := 0; i < config.Nodes; i++ {
+		n, err := s.newNode(i, logger)
+		if err != nil {
+			return nil, err
+		}
+		s.nodes = append(s.nodes, n)
+		s.byAddr[n.overlay.ByteString()] = n
+	}
+
+	// the last nodes are the freeriders so that they don't depend on the
+	// order in which addresses are generated
+	for _, n := range s.nodes[config.Nodes-config.Freeriders:] {
+		n.freerider = true
+	}
+
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Simulation) newNode(index int, logger logging.Logger) (*node, error) {
+	b := make([]byte, penguin.HashSize)
+	_, _ = s.rand.Read(b)
+	overlay := penguin.NewAddress(b)
+
+	acc, err := accounting.NewAccounting(
+		s.config.PaymentThreshold,
+		s.config.PaymentTolerance,
+		s.config.EarlyPayment,
+		logger,
+		mock.NewStateStore(),
+		nil,
+		s.config.RefreshRate,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &node{
+		index:       index,
+		overlay:     overlay,
+		accounting:  acc,
+		pricer:      pricer.NewFixedPricer(overlay, s.config.PoPrice),
+		lastRefresh: make(map[int]int64),
+	}
+	if s.config.ChequebookBalance != nil {
+		n.funds = new(big.Int).Set(s.config.ChequebookBalance)
+	}
+
+	acc.SetTimeNow(func() time.Time {
+		return s.now
+	})
+	acc.SetRefreshFunc(s.refreshFunc(n))
+	acc.SetPayFunc(func(_ context.Context, peer penguin.Address, amount *big.Int) {
+		s.payments <- payment{
+			from:   n,
+			to:     s.byAddr[peer.ByteString()],
+			amount: amount,
+		}
+	})
+
+	return n, nil
+}
+
+// connect builds a random symmetric topology and lets all connected nodes
+// announce their payment threshold to each other.
+func (s *Simulation) connect() error {
+	connected := make([]map[int]bool, len(s.nodes))
+	for i := range connected {
+		connected[i] = make(map[int]bool)
+	}
+
+	for i := range s.nodes {
+		for len(connected[i]) < s.config.Peers {
+			j := s.rand.Intn(len(s.nodes))
+			if j == i {
+				continue
+			}
+			connected[i][j] = true
+			connected[j][i] = true
+		}
+	}
+
+	for i, n := range s.nodes {
+		var peers []int
+		for j := range connected[i] {
+			peers = append(peers, j)
+		}
+		sort.Ints(peers)
+
+		for _, j := range peers {
+			peer := s.nodes[j]
+			n.peers = append(n.peers, peer)
+			n.lastRefresh[j] = s.now.Unix()
+			if err := n.accounting.NotifyPaymentThreshold(peer.overlay, s.config.PaymentThreshold); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// refreshFunc returns the time-based settlement function of node n. It
+// mirrors pseudosettle: the peer accepts at most the allowance accumulated
+// since its last accepted refreshment and never more than the debt it sees.
+func (s *Simulation) refreshFunc(n *node) accounting.RefreshFunc {
+	return func(_ context.Context, peer penguin.Address, amount, _ *big.Int) (*big.Int, int64, error) {
+		p := s.byAddr[peer.ByteString()]
+		now := s.now.Unix()
+
+		elapsed := now - p.lastRefresh[n.index]
+		if elapsed <= 0 {
+			return big.NewInt(0), now, nil
+		}
+
+		accepted := new(big.Int).Mul(s.config.RefreshRate, big.NewInt(elapsed))
+		if accepted.Cmp(amount) > 0 {
+			accepted.Set(amount)
+		}
+
+		debt, err := p.accounting.Balance(n.overlay)
+		if err != nil && !errors.Is(err, accounting.ErrPeerNoBalance) {
+			return nil, 0, err
+		}
+		if debt == nil || debt.Sign() <= 0 {
+			return big.NewInt(0), now, nil
+		}
+		if accepted.Cmp(debt) > 0 {
+			accepted.Set(debt)
+		}
+
+		if err := p.accounting.NotifyRefreshmentReceived(n.overlay, accepted); err != nil {
+			return nil, 0, err
+		}
+		p.lastRefresh[n.index] = now
+		s.report.RefreshmentVolume.Add(s.report.RefreshmentVolume, accepted)
+
+		return accepted, now, nil
+	}
+}
+
+// Run executes all configured steps and returns the resulting report.
+func (s *Simulation) Run(ctx context.Context) (*Report, error) {
+	for s.step < s.config.Steps {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := s.runStep(ctx); err != nil {
+			return nil, fmt.Errorf("step %d: %w", s.step, err)
+		}
+	}
+
+	var debts []*big.Int
+	for _, n := range s.nodes {
+		for _, peer := range n.peers {
+			balance, err := n.accounting.Balance(peer.overlay)
+			if err != nil && !errors.Is(err, accounting.ErrPeerNoBalance) {
+				return nil, err
+			}
+			debt := big.NewInt(0)
+			if balance != nil && balance.Sign() < 0 {
+				debt.Neg(balance)
+			}
+			debts = append(debts, debt)
+		}
+	}
+
+	report := s.report
+	report.Steps = s.step
+	report.Debt = newDistribution(debts)
+	return &report, nil
+}
+
+func (s *Simulation) runStep(ctx context.Context) error {
+	s.now = s.now.Add(s.config.StepDuration)
+
+	if err := s.deliverPayments(); err != nil {
+		return err
+	}
+
+	for i := 0; i < s.config.RequestsPerStep; i++ {
+		origin := s.nodes[s.rand.Intn(len(s.nodes))]
+		b := make([]byte, penguin.HashSize)
+		_, _ = s.rand.Read(b)
+		chunk := penguin.NewAddress(b)
+
+		s.report.Requests++
+		if s.rand.Float64() < s.config.PushRatio {
+			s.report.Pushes++
+		} else {
+			s.report.Retrievals++
+		}
+
+		if err := s.request(ctx, origin, chunk); err != nil {
+			return err
+		}
+	}
+
+	s.step++
+	return nil
+}
+
+// deliverPayments applies all monetary payments which are due in the current
+// step in the order they were issued.
+func (s *Simulation) deliverPayments() error {
+	var remaining []payment
+	for _, p := range s.pending {
+		if p.due > s.step {
+			remaining = append(remaining, p)
+			continue
+		}
+
+		delete(s.inflight, link{from: p.from.index, to: p.to.index})
+
+		if p.from.funds != nil && p.from.funds.Cmp(p.amount) < 0 {
+			s.report.PaymentsFailed++
+			p.from.accounting.NotifyPaymentSent(p.to.overlay, p.amount, errInsufficientFunds)
+			continue
+		}
+		if p.from.funds != nil {
+			p.from.funds.Sub(p.from.funds, p.amount)
+		}
+
+		if err := p.to.accounting.NotifyPaymentReceived(p.from.overlay, p.amount); err != nil {
+			return err
+		}
+		p.from.accounting.NotifyPaymentSent(p.to.overlay, p.amount, nil)
+		s.report.SettlementVolume.Add(s.report.SettlementVolume, p.amount)
+	}
+	s.pending = remaining
+	return nil
+}
+
+// route returns the path a request for chunk takes from origin. Every node
+// forwards to its connected peer closest to the chunk as long as that peer
+// is closer than the node itself.
+func (s *Simulation) route(origin *node, chunk penguin.Address) ([]*node, error) {
+	path := []*node{origin}
+	current := origin
+	for {
+		var next *node
+		closest := current
+		for _, peer := range current.peers {
+			if s.isBlocked(current, peer) {
+				continue
+			}
+			cmp, err := penguin.DistanceCmp(chunk.Bytes(), peer.overlay.Bytes(), closest.overlay.Bytes())
+			if err != nil {
+				return nil, err
+			}
+			if cmp > 0 {
+				next = peer
+				closest = peer
+			}
+		}
+		if next == nil {
+			return path, nil
+		}
+		path = append(path, next)
+		current = next
+	}
+}
+
+// request routes a request for chunk from origin and performs the accounting
+// for every hop: all hops are reserved first, then the payees debit and the
+// payers credit their peer.
+func (s *Simulation) request(ctx context.Context, origin *node, chunk penguin.Address) error {
+	path, err := s.route(origin, chunk)
+	if err != nil {
+		return err
+	}
+	if len(path) == 1 {
+		s.report.LocalRequests++
+		return nil
+	}
+
+	hops := len(path) - 1
+	prices := make([]uint64, hops)
+	for i := 0; i < hops; i++ {
+		prices[i] = path[i].pricer.PeerPrice(path[i+1].overlay, chunk)
+	}
+
+	for i := 0; i < hops; i++ {
+		payer, payee := path[i], path[i+1]
+		if payer.freerider {
+			continue
+		}
+
+		err := payer.accounting.Reserve(ctx, payee.overlay, prices[i])
+		if perr := s.collectPayment(payer, payee); perr != nil {
+			return perr
+		}
+		if err != nil {
+			if !errors.Is(err, accounting.ErrOverdraft) {
+				return err
+			}
+			for j := 0; j < i; j++ {
+				if !path[j].freerider {
+					path[j].accounting.Release(path[j+1].overlay, prices[j])
+				}
+			}
+			s.report.OverdraftRequests++
+			return nil
+		}
+	}
+
+	for i := 0; i < hops; i++ {
+		payer, payee := path[i], path[i+1]
+
+		debit := payee.accounting.PrepareDebit(payer.overlay, prices[i])
+		if err := payer.accounting.Credit(payee.overlay, prices[i]); err != nil {
+			debit.Cleanup()
+			return err
+		}
+		err := debit.Apply()
+		debit.Cleanup()
+		if !payer.freerider {
+			payer.accounting.Release(payee.overlay, prices[i])
+		}
+
+		s.report.TrafficVolume.Add(s.report.TrafficVolume, new(big.Int).SetUint64(prices[i]))
+
+		if err != nil {
+			var blockErr *p2p.BlockPeerError
+			if !errors.As(err, &blockErr) {
+				return err
+			}
+			s.block(payer, payee, blockErr.Duration())
+		}
+	}
+
+	return nil
+}
+
+// collectPayment picks up the monetary payment the payer may have started
+// towards payee during a reserve. Accounting issues payments on a separate
+// goroutine; waiting for it here keeps the simulation deterministic.
+func (s *Simulation) collectPayment(payer, payee *node) error {
+	l := link{from: payer.index, to: payee.index}
+	if s.inflight[l] || !payer.accounting.IsPaymentOngoing(payee.overlay) {
+		return nil
+	}
+
+	p := <-s.payments
+	if p.from != payer || p.to != payee {
+		return fmt.Errorf("unexpected payment from node %d to node %d", p.from.index, p.to.index)
+	}
+
+	p.due = s.step + s.config.SettlementDelay
+	s.pending = append(s.pending, p)
+	s.inflight[l] = true
+	s.report.PaymentsIssued++
+	return nil
+}
+
+func blockKey(a, b *node) link {
+	if a.index > b.index {
+		a, b = b, a
+	}
+	return link{from: a.index, to: b.index}
+}
+
+// block disconnects the two nodes for the given duration, where zero means
+// for the rest of the simulation.
+func (s *Simulation) block(a, b *node, duration time.Duration) {
+	s.report.Disconnects++
+
+	until := s.config.Steps
+	if duration > 0 {
+		until = s.step + int(duration/s.config.StepDuration) + 1
+	}
+	s.blocked[blockKey(a, b)] = until
+}
+
+func (s *Simulation) isBlocked(a, b *node) bool {
+	until, ok := s.blocked[blockKey(a, b)]
+	return ok && s.step < until
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package simulation_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/penguintop/penguin/pkg/accounting/simulation"
+)
+
+func testConfig() simulation.Config {
+	c := simulation.DefaultConfig()
+	c.Nodes = 20
+	c.Peers = 4
+	c.Steps = 60
+	c.RequestsPerStep = 50
+	c.PoPrice = 1000
+	c.PaymentThreshold = big.NewInt(200000)
+	c.PaymentTolerance = big.NewInt(100000)
+	c.EarlyPayment = big.NewInt(20000)
+	c.RefreshRate = big.NewInt(10000)
+	c.Seed = 1
+	return c
+}
+
+func run(t *testing.T, c simulation.Config) *simulation.Report {
+	t.Helper()
+
+	s, err := simulation.New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestDeterministic(t *testing.T) {
+	c := testConfig()
+
+	first := run(t, c)
+	second := run(t, c)
+
+	if first.String() != second.String() {
+		t.Fatalf("got different reports for the same seed:\n%s\n%s", first, second)
+	}
+
+	c.Seed = 2
+	third := run(t, c)
+	if first.String() == third.String() {
+		t.Fatal("got the same report for different seeds")
+	}
+}
+
+func TestSettlement(t *testing.T) {
+	c := testConfig()
+
+	report := run(t, c)
+
+	if report.Steps != c.Steps {
+		t.Fatalf("got %d steps, want %d", report.Steps, c.Steps)
+	}
+	if report.Requests != c.Steps*c.RequestsPerStep {
+		t.Fatalf("got %d requests, want %d", report.Requests, c.Steps*c.RequestsPerStep)
+	}
+	if report.Retrievals+report.Pushes != report.Requests {
+		t.Fatalf("got %d retrievals and %d pushes for %d requests", report.Retrievals, report.Pushes, report.Requests)
+	}
+	if report.Disconnects != 0 {
+		t.Fatalf("got %d disconnects, want none", report.Disconnects)
+	}
+	if report.PaymentsIssued == 0 || report.SettlementVolume.Sign() <= 0 {
+		t.Fatal("expected monetary settlement")
+	}
+	if report.RefreshmentVolume.Sign() <= 0 {
+		t.Fatal("expected time-based settlement")
+	}
+	if report.Debt.Max.Cmp(c.PaymentThreshold) > 0 {
+		t.Fatalf("got debt %d above payment threshold %d", report.Debt.Max, c.PaymentThreshold)
+	}
+}
+
+func TestInsufficientFunds(t *testing.T) {
+	c := testConfig()
+	c.RefreshRate = big.NewInt(100)
+	c.ChequebookBalance = big.NewInt(0)
+
+	report := run(t, c)
+
+	if report.PaymentsFailed == 0 {
+		t.Fatal("expected failed payments")
+	}
+	if report.SettlementVolume.Sign() != 0 {
+		t.Fatalf("got settlement volume %d, want 0", report.SettlementVolume)
+	}
+	if report.OverdraftRequests == 0 {
+		t.Fatal("expected requests blocked by overdraft")
+	}
+}
+
+func TestFreeriders(t *testing.T) {
+	c := testConfig()
+	c.Freeriders = 4
+
+	report := run(t, c)
+
+	if report.Disconnects == 0 {
+		t.Fatal("expected freeriders to be disconnected")
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(c *simulation.Config)
+	}{
+		{
+			name:   "single node",
+			modify: func(c *simulation.Config) { c.Nodes = 1 },
+		},
+		{
+			name:   "too many peers",
+			modify: func(c *simulation.Config) { c.Peers = c.Nodes },
+		},
+		{
+			name:   "short step",
+			modify: func(c *simulation.Config) { c.StepDuration = 0 },
+		},
+		{
+			name:   "no refresh rate",
+			modify: func(c *simulation.Config) { c.RefreshRate = big.NewInt(0) },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := testConfig()
+			tc.modify(&c)
+			if _, err := simulation.New(c); !errors.Is(err, simulation.ErrInvalidConfig) {
+				t.Fatalf("got error %v, want %v", err, simulation.ErrInvalidConfig)
+			}
+		})
+	}
+}
@@ -8,18 +8,27 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/penguintop/penguin/pkg/pen"
 	"github.com/penguintop/penguin/pkg/storage"
 	"github.com/penguintop/penguin/pkg/penguin"
 )
 
-const keyPrefix = "addressbook_entry_"
+const (
+	keyPrefix         = "addressbook_entry_"
+	metadataKeyPrefix = "addressbook_metadata_"
+	prunedKeyPrefix   = "addressbook_pruned_"
+)
 
 var _ Interface = (*store)(nil)
 
 var ErrNotFound = errors.New("addressbook: not found")
 
+// ErrPruned is returned if the gossiped address was pruned
+// recently and it is not saved until the prune backoff expires.
+var ErrPruned = errors.New("addressbook: pruned")
+
 // Interface is the AddressBook interface.
 type Interface interface {
 	GetPutter
@@ -28,6 +37,15 @@ type Interface interface {
 	Overlays() ([]penguin.Address, error)
 	// Addresses returns a list of all pen.Address-es saved in addressbook.
 	Addresses() ([]pen.Address, error)
+	// Entries returns all saved addresses with their metadata.
+	Entries() ([]Entry, error)
+	// Metadata returns the metadata of the saved overlay address.
+	Metadata(overlay penguin.Address) (*Metadata, error)
+	// RecordDial records the outcome of a dial to the overlay address.
+	RecordDial(overlay penguin.Address, success bool) error
+	// Prune removes the entries which are considered dead by the
+	// options and returns their overlay addresses.
+	Prune(o PruneOptions) ([]penguin.Address, error)
 }
 
 type GetPutter interface {
@@ -43,6 +61,11 @@ type Getter interface {
 type Putter interface {
 	// Put saves relation between peer overlay address and pen.Address address.
 	Put(overlay penguin.Address, addr pen.Address) (err error)
+	// PutWithSource saves the address as Put does, recording
+	// the source of the discovery for the new entries. The addresses
+	// from the hive are not saved while the entry is in the prune
+	// backoff, ErrPruned is returned instead.
+	PutWithSource(overlay penguin.Address, addr pen.Address, source Source) (err error)
 }
 
 type Remover interface {
@@ -50,14 +73,59 @@ type Remover interface {
 	Remove(overlay penguin.Address) error
 }
 
+// Source is the way how the address was discovered.
+type Source string
+
+const (
+	SourceUnknown   Source = ""
+	SourceHandshake Source = "handshake"
+	SourceHive      Source = "hive"
+)
+
+// Metadata is the bookkeeping of an address used to detect dead entries.
+// The LastSeen time is refreshed whenever the address is saved, but the
+// entries age only by the outcome of the dials, as gossip about a peer
+// does not prove that it is reachable.
+type Metadata struct {
+	FirstSeen           time.Time `json:"firstSeen"`
+	LastSeen            time.Time `json:"lastSeen"`
+	LastSuccessfulDial  time.Time `json:"lastSuccessfulDial"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Source              Source    `json:"source,omitempty"`
+}
+
+// Entry is an address with its metadata.
+type Entry struct {
+	Address  pen.Address
+	Metadata Metadata
+}
+
+// PruneOptions are the conditions under which the entries are pruned.
+type PruneOptions struct {
+	// MaxFailures is the number of consecutive failed dials
+	// after which the entry is pruned, zero disables the check.
+	MaxFailures int
+	// MaxAge is the period after which the entry is pruned if the peer
+	// was not dialed successfully within it since it was first seen,
+	// zero disables the check.
+	MaxAge time.Duration
+	// Backoff is the period after the entry is pruned in which it is
+	// not saved again from the hive, zero disables the backoff.
+	Backoff time.Duration
+	// Keep reports whether the entry must be kept regardless of its metadata.
+	Keep func(overlay penguin.Address) bool
+}
+
 type store struct {
 	store storage.StateStorer
+	now   func() time.Time
 }
 
 // New creates new addressbook for state storer.
 func New(storer storage.StateStorer) Interface {
 	return &store{
 		store: storer,
+		now:   time.Now,
 	}
 }
 
@@ -76,12 +144,198 @@ func (s *store) Get(overlay penguin.Address) (*pen.Address, error) {
 }
 
 func (s *store) Put(overlay penguin.Address, addr pen.Address) (err error) {
+	return s.PutWithSource(overlay, addr, SourceUnknown)
+}
+
+func (s *store) PutWithSource(overlay penguin.Address, addr pen.Address, source Source) (err error) {
+	pruned, err := s.pruned(overlay, source)
+	if err != nil {
+		return err
+	}
+	if pruned {
+		return ErrPruned
+	}
+
 	key := keyPrefix + overlay.String()
-	return s.store.Put(key, &addr)
+	if err := s.store.Put(key, &addr); err != nil {
+		return err
+	}
+
+	return s.updateMetadata(overlay, func(m *Metadata) {
+		m.LastSeen = s.now()
+		if m.Source == SourceUnknown {
+			m.Source = source
+		}
+	})
+}
+
+// pruned reports whether the address from the source must not be saved
+// as the entry is in the prune backoff. The addresses of the peers which
+// connected in the handshake are always saved and end the backoff.
+func (s *store) pruned(overlay penguin.Address, source Source) (bool, error) {
+	key := prunedKeyPrefix + overlay.String()
+	var until time.Time
+	switch err := s.store.Get(key, &until); {
+	case errors.Is(err, storage.ErrNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	if source == SourceHive && s.now().Before(until) {
+		return true, nil
+	}
+	return false, s.store.Delete(key)
 }
 
 func (s *store) Remove(overlay penguin.Address) error {
-	return s.store.Delete(keyPrefix + overlay.String())
+	if err := s.store.Delete(keyPrefix + overlay.String()); err != nil {
+		return err
+	}
+	return s.store.Delete(metadataKeyPrefix + overlay.String())
+}
+
+func (s *store) Metadata(overlay penguin.Address) (*Metadata, error) {
+	m := &Metadata{}
+	err := s.store.Get(metadataKeyPrefix+overlay.String(), m)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *store) RecordDial(overlay penguin.Address, success bool) error {
+	if _, err := s.Get(overlay); err != nil {
+		return err
+	}
+
+	return s.updateMetadata(overlay, func(m *Metadata) {
+		if success {
+			m.LastSuccessfulDial = s.now()
+			m.ConsecutiveFailures = 0
+		} else {
+			m.ConsecutiveFailures++
+		}
+	})
+}
+
+// updateMetadata applies the change to the metadata of the overlay
+// address, creating it for the entries which do not have it yet.
+func (s *store) updateMetadata(overlay penguin.Address, f func(m *Metadata)) error {
+	m, err := s.Metadata(overlay)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		m = &Metadata{FirstSeen: s.now()}
+	case err != nil:
+		return err
+	}
+
+	f(m)
+
+	return s.store.Put(metadataKeyPrefix+overlay.String(), m)
+}
+
+func (s *store) Entries() (entries []Entry, err error) {
+	addresses, err := s.Addresses()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addresses {
+		m, err := s.Metadata(addr.Overlay)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			m = &Metadata{}
+		case err != nil:
+			return nil, err
+		}
+		entries = append(entries, Entry{Address: addr, Metadata: *m})
+	}
+
+	return entries, nil
+}
+
+func (s *store) Prune(o PruneOptions) (pruned []penguin.Address, err error) {
+	entries, err := s.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	if err := s.removeExpiredBackoffs(now); err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		overlay := e.Address.Overlay
+		if o.Keep != nil && o.Keep(overlay) {
+			continue
+		}
+
+		m := e.Metadata
+		if m.FirstSeen.IsZero() {
+			// the entries saved before the metadata
+			// was introduced start to age from now on
+			if err := s.updateMetadata(overlay, func(*Metadata) {}); err != nil {
+				return pruned, err
+			}
+			continue
+		}
+
+		last := m.FirstSeen
+		if m.LastSuccessfulDial.After(last) {
+			last = m.LastSuccessfulDial
+		}
+
+		failed := o.MaxFailures > 0 && m.ConsecutiveFailures >= o.MaxFailures
+		aged := o.MaxAge > 0 && now.Sub(last) > o.MaxAge
+		if !failed && !aged {
+			continue
+		}
+
+		if err := s.Remove(overlay); err != nil {
+			return pruned, err
+		}
+		if o.Backoff > 0 {
+			if err := s.store.Put(prunedKeyPrefix+overlay.String(), now.Add(o.Backoff)); err != nil {
+				return pruned, err
+			}
+		}
+		pruned = append(pruned, overlay)
+	}
+
+	return pruned, nil
+}
+
+// removeExpiredBackoffs removes the prune backoffs which expired before now.
+func (s *store) removeExpiredBackoffs(now time.Time) error {
+	var expired []string
+	err := s.store.Iterate(prunedKeyPrefix, func(key, value []byte) (stop bool, err error) {
+		if !strings.HasPrefix(string(key), prunedKeyPrefix) {
+			return true, nil
+		}
+		var until time.Time
+		if err := until.UnmarshalBinary(value); err != nil {
+			return true, err
+		}
+		if !now.Before(until) {
+			expired = append(expired, string(key))
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range expired {
+		if err := s.store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *store) Overlays() (overlays []penguin.Address, err error) {
@@ -0,0 +1,12 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package addressbook
+
+import "time"
+
+// SetNow replaces the clock of the address book.
+func SetNow(i Interface, now func() time.Time) {
+	i.(*store).now = now
+}
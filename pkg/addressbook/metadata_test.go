@@ -0,0 +1,215 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package addressbook_test
+
+import (
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/penguintop/penguin/pkg/addressbook"
+	"github.com/penguintop/penguin/pkg/crypto"
+	"github.com/penguintop/penguin/pkg/pen"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/statestore/mock"
+)
+
+func newPenAddress(t *testing.T) pen.Address {
+	t.Helper()
+
+	pk, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay, err := crypto.NewOverlayAddress(pk.PublicKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	underlay, err := ma.NewMultiaddr("/ip4/1.1.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := pen.NewAddress(crypto.NewDefaultSigner(pk), underlay, overlay, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *addr
+}
+
+func TestMetadata(t *testing.T) {
+	book := addressbook.New(mock.NewStateStore())
+	now := time.Unix(1000, 0)
+	addressbook.SetNow(book, func() time.Time { return now })
+
+	addr := newPenAddress(t)
+	if err := book.PutWithSource(addr.Overlay, addr, addressbook.SourceHive); err != nil {
+		t.Fatal(err)
+	}
+
+	firstSeen := now
+	now = now.Add(time.Minute)
+
+	// the source of the discovery is not overwritten
+	if err := book.PutWithSource(addr.Overlay, addr, addressbook.SourceHandshake); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.RecordDial(addr.Overlay, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.RecordDial(addr.Overlay, false); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := book.Metadata(addr.Overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := addressbook.Metadata{
+		FirstSeen:           firstSeen,
+		LastSeen:            now,
+		ConsecutiveFailures: 2,
+		Source:              addressbook.SourceHive,
+	}
+	if !m.FirstSeen.Equal(want.FirstSeen) || !m.LastSeen.Equal(want.LastSeen) || m.ConsecutiveFailures != want.ConsecutiveFailures || m.Source != want.Source {
+		t.Fatalf("got metadata %+v, want %+v", m, want)
+	}
+
+	if err := book.RecordDial(addr.Overlay, true); err != nil {
+		t.Fatal(err)
+	}
+	m, err = book.Metadata(addr.Overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ConsecutiveFailures != 0 || !m.LastSuccessfulDial.Equal(now) {
+		t.Fatalf("got metadata %+v, want reset failures and last successful dial %s", m, now)
+	}
+
+	entries, err := book.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[0].Address.Equal(&addr) || entries[0].Metadata.Source != addressbook.SourceHive {
+		t.Fatalf("got entries %+v", entries)
+	}
+
+	if err := book.RecordDial(newPenAddress(t).Overlay, true); err != addressbook.ErrNotFound {
+		t.Fatalf("got error %v, want %v", err, addressbook.ErrNotFound)
+	}
+
+	if err := book.Remove(addr.Overlay); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := book.Metadata(addr.Overlay); err != addressbook.ErrNotFound {
+		t.Fatalf("got error %v, want %v", err, addressbook.ErrNotFound)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	store := mock.NewStateStore()
+	book := addressbook.New(store)
+	now := time.Unix(1000, 0)
+	addressbook.SetNow(book, func() time.Time { return now })
+
+	var (
+		failed    = newPenAddress(t)
+		aged      = newPenAddress(t)
+		dialed    = newPenAddress(t)
+		kept      = newPenAddress(t)
+		gossiped  = newPenAddress(t)
+		legacy    = newPenAddress(t)
+		maxAge    = time.Hour
+		pruneOpts = addressbook.PruneOptions{
+			MaxFailures: 3,
+			MaxAge:      maxAge,
+			Backoff:     maxAge,
+			Keep:        func(overlay penguin.Address) bool { return overlay.Equal(kept.Overlay) },
+		}
+	)
+
+	for _, addr := range []pen.Address{failed, aged, dialed, kept, gossiped} {
+		if err := book.Put(addr.Overlay, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an entry without metadata, as saved by the previous versions
+	if err := store.Put("addressbook_entry_"+legacy.Overlay.String(), &legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := book.RecordDial(failed.Overlay, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now = now.Add(maxAge)
+	if err := book.RecordDial(dialed.Overlay, true); err != nil {
+		t.Fatal(err)
+	}
+	// the gossip does not prove that the peer is reachable
+	if err := book.PutWithSource(gossiped.Overlay, gossiped, addressbook.SourceHive); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(time.Minute)
+	pruned, err := book.Prune(pruneOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantPruned := []penguin.Address{failed.Overlay, aged.Overlay, gossiped.Overlay}
+	if len(pruned) != len(wantPruned) {
+		t.Fatalf("got %d pruned entries, want %d", len(pruned), len(wantPruned))
+	}
+	for _, want := range wantPruned {
+		var found bool
+		for _, p := range pruned {
+			if p.Equal(want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("entry %s not pruned", want)
+		}
+		if _, err := book.Get(want); err != addressbook.ErrNotFound {
+			t.Fatalf("got error %v, want %v", err, addressbook.ErrNotFound)
+		}
+	}
+
+	// the pruned entries are not added again from the hive
+	// within the backoff, but they are from the handshake
+	if err := book.PutWithSource(failed.Overlay, failed, addressbook.SourceHive); err != addressbook.ErrPruned {
+		t.Fatalf("got error %v, want %v", err, addressbook.ErrPruned)
+	}
+	if _, err := book.Get(failed.Overlay); err != addressbook.ErrNotFound {
+		t.Fatalf("got error %v, want %v", err, addressbook.ErrNotFound)
+	}
+	if err := book.PutWithSource(aged.Overlay, aged, addressbook.SourceHandshake); err != nil {
+		t.Fatal(err)
+	}
+
+	// the legacy entry ages from the first pruning on
+	now = now.Add(2 * maxAge)
+	pruned, err = book.Prune(pruneOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 3 {
+		t.Fatalf("got %d pruned entries, want 3", len(pruned))
+	}
+	overlays, err := book.Overlays()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlays) != 1 || !overlays[0].Equal(kept.Overlay) {
+		t.Fatalf("got overlays %v, want %v", overlays, []penguin.Address{kept.Overlay})
+	}
+
+	// the expired backoff is removed
+	if err := book.PutWithSource(failed.Overlay, failed, addressbook.SourceHive); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,12 +5,14 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/penguintop/penguin/pkg/jsonhttp"
 	"github.com/penguintop/penguin/pkg/sctx"
+	"github.com/penguintop/penguin/pkg/storage"
 	"github.com/penguintop/penguin/pkg/penguin"
 	"github.com/penguintop/penguin/pkg/tags"
 	"github.com/penguintop/penguin/pkg/tracing"
@@ -70,6 +72,10 @@ func (s *server) bytesUploadHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Debugf("Bytes upload: split write all: %v", err)
 		logger.Error("Bytes upload: split write all")
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			jsonhttp.RequestEntityTooLarge(w, "quota exceeded")
+			return
+		}
 		jsonhttp.InternalServerError(w, nil)
 		return
 	}
@@ -88,6 +94,10 @@ func (s *server) bytesUploadHandler(w http.ResponseWriter, r *http.Request) {
 		if err := s.pinning.CreatePin(ctx, address, false); err != nil {
 			logger.Debugf("Bytes upload: creation of pin for %q failed: %v", address, err)
 			logger.Error("Bytes upload: creation of pin failed")
+			if errors.Is(err, storage.ErrQuotaExceeded) {
+				jsonhttp.RequestEntityTooLarge(w, "quota exceeded")
+				return
+			}
 			jsonhttp.InternalServerError(w, nil)
 			return
 		}
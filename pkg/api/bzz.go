@@ -120,6 +120,10 @@ func (s *server) fileUploadHandler(w http.ResponseWriter, r *http.Request, store
 	if err != nil {
 		logger.Debugf("Pen upload file: file store, file %q: %v", fileName, err)
 		logger.Errorf("Pen upload file: file store, file %q", fileName)
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			jsonhttp.RequestEntityTooLarge(w, "quota exceeded")
+			return
+		}
 		jsonhttp.InternalServerError(w, errFileStore)
 		return
 	}
@@ -206,6 +210,10 @@ func (s *server) fileUploadHandler(w http.ResponseWriter, r *http.Request, store
 		if err := s.pinning.CreatePin(ctx, manifestReference, false); err != nil {
 			logger.Debugf("Pen upload file: creation of pin for %q failed: %v", manifestReference, err)
 			logger.Error("Pen upload file: creation of pin failed")
+			if errors.Is(err, storage.ErrQuotaExceeded) {
+				jsonhttp.RequestEntityTooLarge(w, "quota exceeded")
+				return
+			}
 			jsonhttp.InternalServerError(w, nil)
 			return
 		}
@@ -104,6 +104,10 @@ func (s *server) chunkUploadHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		s.logger.Debugf("Chunk upload: chunk write error: %v, addr %s", err, chunk.Address())
 		s.logger.Error("Chunk upload: chunk write error")
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			jsonhttp.RequestEntityTooLarge(w, "quota exceeded")
+			return
+		}
 		jsonhttp.BadRequest(w, "chunk write error")
 		return
 	} else if len(seen) > 0 && seen[0] && tag != nil {
@@ -132,6 +136,10 @@ func (s *server) chunkUploadHandler(w http.ResponseWriter, r *http.Request) {
 		if err := s.pinning.CreatePin(ctx, chunk.Address(), false); err != nil {
 			s.logger.Debugf("Chunk upload: creation of pin for %q failed: %v", chunk.Address(), err)
 			s.logger.Error("Chunk upload: creation of pin failed")
+			if errors.Is(err, storage.ErrQuotaExceeded) {
+				jsonhttp.RequestEntityTooLarge(w, "quota exceeded")
+				return
+			}
 			jsonhttp.InternalServerError(w, nil)
 			return
 		}
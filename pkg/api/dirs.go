@@ -86,6 +86,10 @@ func (s *server) dirUploadHandler(w http.ResponseWriter, r *http.Request, storer
 	if err != nil {
 		logger.Debugf("Pen upload dir: store dir err: %v", err)
 		logger.Errorf("Pen upload dir: store dir")
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			jsonhttp.RequestEntityTooLarge(w, "quota exceeded")
+			return
+		}
 		jsonhttp.InternalServerError(w, errDirectoryStore)
 		return
 	}
@@ -103,6 +107,10 @@ func (s *server) dirUploadHandler(w http.ResponseWriter, r *http.Request, storer
 		if err := s.pinning.CreatePin(r.Context(), reference, false); err != nil {
 			logger.Debugf("Pen upload dir: creation of pin for %q failed: %v", reference, err)
 			logger.Error("Pen upload dir: creation of pin failed")
+			if errors.Is(err, storage.ErrQuotaExceeded) {
+				jsonhttp.RequestEntityTooLarge(w, "quota exceeded")
+				return
+			}
 			jsonhttp.InternalServerError(w, nil)
 			return
 		}
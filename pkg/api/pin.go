@@ -41,6 +41,9 @@ func (s *server) pinRootHash(w http.ResponseWriter, r *http.Request) {
 	case errors.Is(err, storage.ErrNotFound):
 		jsonhttp.NotFound(w, nil)
 		return
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		jsonhttp.RequestEntityTooLarge(w, "quota exceeded")
+		return
 	case err != nil:
 		s.logger.Debugf("Pin root hash: creation of tracking pin for %q failed: %v", ref, err)
 		s.logger.Error("Pin root hash: creation of tracking pin failed")
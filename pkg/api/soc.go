@@ -15,6 +15,7 @@ import (
 	"github.com/penguintop/penguin/pkg/jsonhttp"
 	"github.com/penguintop/penguin/pkg/postage"
 	"github.com/penguintop/penguin/pkg/soc"
+	"github.com/penguintop/penguin/pkg/storage"
 	"github.com/penguintop/penguin/pkg/penguin"
 	"github.com/gorilla/mux"
 )
@@ -155,6 +156,10 @@ func (s *server) socUploadHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		s.logger.Debugf("soc upload: chunk write error: %v", err)
 		s.logger.Error("soc upload: chunk write error")
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			jsonhttp.RequestEntityTooLarge(w, "quota exceeded")
+			return
+		}
 		jsonhttp.BadRequest(w, "chunk write error")
 		return
 	}
@@ -163,6 +168,10 @@ func (s *server) socUploadHandler(w http.ResponseWriter, r *http.Request) {
 		if err := s.pinning.CreatePin(ctx, sch.Address(), false); err != nil {
 			s.logger.Debugf("soc upload: creation of pin for %q failed: %v", sch.Address(), err)
 			s.logger.Error("soc upload: creation of pin failed")
+			if errors.Is(err, storage.ErrQuotaExceeded) {
+				jsonhttp.RequestEntityTooLarge(w, "quota exceeded")
+				return
+			}
 			jsonhttp.InternalServerError(w, nil)
 			return
 		}
@@ -0,0 +1,134 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debugapi
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/penguintop/penguin/pkg/addressbook"
+	"github.com/penguintop/penguin/pkg/jsonhttp"
+	"github.com/penguintop/penguin/pkg/penguin"
+)
+
+type addressBookEntry struct {
+	Overlay             penguin.Address    `json:"overlay"`
+	Underlay            string             `json:"underlay"`
+	FirstSeen           *time.Time         `json:"firstSeen,omitempty"`
+	LastSeen            *time.Time         `json:"lastSeen,omitempty"`
+	LastSuccessfulDial  *time.Time         `json:"lastSuccessfulDial,omitempty"`
+	ConsecutiveFailures int                `json:"consecutiveFailures"`
+	Source              addressbook.Source `json:"source,omitempty"`
+}
+
+type addressBookResponse struct {
+	Entries []addressBookEntry `json:"entries"`
+}
+
+func newAddressBookEntry(e addressbook.Entry) addressBookEntry {
+	optionalTime := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+
+	return addressBookEntry{
+		Overlay:             e.Address.Overlay,
+		Underlay:            e.Address.Underlay.String(),
+		FirstSeen:           optionalTime(e.Metadata.FirstSeen),
+		LastSeen:            optionalTime(e.Metadata.LastSeen),
+		LastSuccessfulDial:  optionalTime(e.Metadata.LastSuccessfulDial),
+		ConsecutiveFailures: e.Metadata.ConsecutiveFailures,
+		Source:              e.Metadata.Source,
+	}
+}
+
+func (s *Service) addressBookHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.addressBook.Entries()
+	if err != nil {
+		s.logger.Debugf("Debug api: address book: %v", err)
+		s.logger.Error("Debug api: address book")
+		jsonhttp.InternalServerError(w, "address book")
+		return
+	}
+
+	resp := addressBookResponse{
+		Entries: make([]addressBookEntry, 0, len(entries)),
+	}
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, newAddressBookEntry(e))
+	}
+
+	jsonhttp.OK(w, resp)
+}
+
+func (s *Service) addressBookEntryHandler(w http.ResponseWriter, r *http.Request) {
+	addr := mux.Vars(r)["address"]
+	overlay, err := penguin.ParseHexAddress(addr)
+	if err != nil {
+		s.logger.Debugf("Debug api: address book: parse peer address %s: %v", addr, err)
+		jsonhttp.BadRequest(w, "invalid peer address")
+		return
+	}
+
+	penAddr, err := s.addressBook.Get(overlay)
+	if err != nil {
+		if errors.Is(err, addressbook.ErrNotFound) {
+			jsonhttp.NotFound(w, nil)
+			return
+		}
+		s.logger.Debugf("Debug api: address book: get %s: %v", addr, err)
+		s.logger.Errorf("Debug api: address book: get %s", addr)
+		jsonhttp.InternalServerError(w, "address book entry")
+		return
+	}
+
+	entry := addressbook.Entry{Address: *penAddr}
+	m, err := s.addressBook.Metadata(overlay)
+	switch {
+	case err == nil:
+		entry.Metadata = *m
+	case !errors.Is(err, addressbook.ErrNotFound):
+		s.logger.Debugf("Debug api: address book: metadata %s: %v", addr, err)
+		s.logger.Errorf("Debug api: address book: metadata %s", addr)
+		jsonhttp.InternalServerError(w, "address book entry")
+		return
+	}
+
+	jsonhttp.OK(w, newAddressBookEntry(entry))
+}
+
+func (s *Service) addressBookRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	addr := mux.Vars(r)["address"]
+	overlay, err := penguin.ParseHexAddress(addr)
+	if err != nil {
+		s.logger.Debugf("Debug api: address book: parse peer address %s: %v", addr, err)
+		jsonhttp.BadRequest(w, "invalid peer address")
+		return
+	}
+
+	if _, err := s.addressBook.Get(overlay); err != nil {
+		if errors.Is(err, addressbook.ErrNotFound) {
+			jsonhttp.NotFound(w, nil)
+			return
+		}
+		s.logger.Debugf("Debug api: address book: get %s: %v", addr, err)
+		s.logger.Errorf("Debug api: address book: get %s", addr)
+		jsonhttp.InternalServerError(w, "address book remove")
+		return
+	}
+
+	if err := s.addressBook.Remove(overlay); err != nil {
+		s.logger.Debugf("Debug api: address book: remove %s: %v", addr, err)
+		s.logger.Errorf("Debug api: address book: remove %s", addr)
+		jsonhttp.InternalServerError(w, "address book remove")
+		return
+	}
+
+	jsonhttp.OK(w, nil)
+}
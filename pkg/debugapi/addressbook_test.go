@@ -0,0 +1,113 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debugapi_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/penguintop/penguin/pkg/addressbook"
+	"github.com/penguintop/penguin/pkg/crypto"
+	"github.com/penguintop/penguin/pkg/debugapi"
+	"github.com/penguintop/penguin/pkg/jsonhttp"
+	"github.com/penguintop/penguin/pkg/jsonhttp/jsonhttptest"
+	"github.com/penguintop/penguin/pkg/pen"
+	"github.com/penguintop/penguin/pkg/statestore/mock"
+)
+
+func TestAddressBook(t *testing.T) {
+	pk, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay, err := crypto.NewOverlayAddress(pk.PublicKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	underlay := mustMultiaddr(t, "/ip4/127.0.0.1/tcp/7071/p2p/16Uiu2HAmTBuJT9LvNmBiQiNoTsxE5mtNy6YG3paw79m94CRa9sRb")
+	penAddr, err := pen.NewAddress(crypto.NewDefaultSigner(pk), underlay, overlay, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	book := addressbook.New(mock.NewStateStore())
+	if err := book.PutWithSource(overlay, *penAddr, addressbook.SourceHive); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.RecordDial(overlay, false); err != nil {
+		t.Fatal(err)
+	}
+
+	testServer := newTestServer(t, testServerOptions{
+		AddressBook: book,
+	})
+
+	checkEntry := func(t *testing.T, e debugapi.AddressBookEntry) {
+		t.Helper()
+
+		if !e.Overlay.Equal(overlay) || e.Underlay != underlay.String() {
+			t.Fatalf("got entry %s %s, want %s %s", e.Overlay, e.Underlay, overlay, underlay)
+		}
+		if e.Source != addressbook.SourceHive || e.ConsecutiveFailures != 1 {
+			t.Fatalf("got source %q and failures %d, want %q and 1", e.Source, e.ConsecutiveFailures, addressbook.SourceHive)
+		}
+		if e.FirstSeen == nil || e.LastSeen == nil || e.LastSuccessfulDial != nil {
+			t.Fatalf("got times %v %v %v", e.FirstSeen, e.LastSeen, e.LastSuccessfulDial)
+		}
+	}
+
+	t.Run("list", func(t *testing.T) {
+		var resp debugapi.AddressBookResponse
+		jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/addressbook", http.StatusOK,
+			jsonhttptest.WithUnmarshalJSONResponse(&resp),
+		)
+		if len(resp.Entries) != 1 {
+			t.Fatalf("got %d entries, want 1", len(resp.Entries))
+		}
+		checkEntry(t, resp.Entries[0])
+	})
+
+	t.Run("get", func(t *testing.T) {
+		var resp debugapi.AddressBookEntry
+		jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/addressbook/"+overlay.String(), http.StatusOK,
+			jsonhttptest.WithUnmarshalJSONResponse(&resp),
+		)
+		checkEntry(t, resp)
+	})
+
+	t.Run("invalid address", func(t *testing.T) {
+		jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/addressbook/invalid", http.StatusBadRequest,
+			jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+				Code:    http.StatusBadRequest,
+				Message: "invalid peer address",
+			}),
+		)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		jsonhttptest.Request(t, testServer.Client, http.MethodDelete, "/addressbook/"+overlay.String(), http.StatusOK,
+			jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+				Code:    http.StatusOK,
+				Message: http.StatusText(http.StatusOK),
+			}),
+		)
+		if _, err := book.Get(overlay); err != addressbook.ErrNotFound {
+			t.Fatalf("got error %v, want %v", err, addressbook.ErrNotFound)
+		}
+
+		jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/addressbook/"+overlay.String(), http.StatusNotFound,
+			jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+				Code:    http.StatusNotFound,
+				Message: http.StatusText(http.StatusNotFound),
+			}),
+		)
+		jsonhttptest.Request(t, testServer.Client, http.MethodDelete, "/addressbook/"+overlay.String(), http.StatusNotFound,
+			jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+				Code:    http.StatusNotFound,
+				Message: http.StatusText(http.StatusNotFound),
+			}),
+		)
+	})
+}
@@ -0,0 +1,18 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debugapi
+
+import (
+	"net/http"
+
+	"github.com/penguintop/penguin/pkg/jsonhttp"
+	"github.com/penguintop/penguin/pkg/p2p/bandwidth"
+)
+
+type bandwidthResponse bandwidth.Snapshot
+
+func (s *Service) bandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	jsonhttp.OK(w, bandwidthResponse(s.bandwidth.Snapshot()))
+}
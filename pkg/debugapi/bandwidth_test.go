@@ -0,0 +1,85 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debugapi_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/penguintop/penguin/pkg/debugapi"
+	"github.com/penguintop/penguin/pkg/jsonhttp/jsonhttptest"
+	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/p2p/bandwidth"
+	"github.com/penguintop/penguin/pkg/p2p/streamtest"
+	"github.com/penguintop/penguin/pkg/penguin/test"
+)
+
+func TestBandwidth(t *testing.T) {
+	accountant := bandwidth.New(bandwidth.Options{
+		Total: bandwidth.Limit{Rate: 1000000},
+		Protocols: map[string]bandwidth.Limit{
+			"pullsync": {Rate: 1000, Burst: 2000},
+		},
+		LowPriority: []string{"pullsync"},
+	})
+
+	peer := test.RandomAddress()
+	done := make(chan struct{})
+	recorder := streamtest.New(
+		streamtest.WithProtocols(p2p.ProtocolSpec{
+			Name:    "pullsync",
+			Version: "1.0.0",
+			StreamSpecs: []p2p.StreamSpec{
+				{
+					Name: "pullsync",
+					Handler: func(_ context.Context, _ p2p.Peer, stream p2p.Stream) error {
+						defer close(done)
+						_, err := ioutil.ReadAll(stream)
+						return err
+					},
+				},
+			},
+		}),
+	)
+	stream, err := recorder.NewStream(context.Background(), peer, nil, "pullsync", "1.0.0", "pullsync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream = accountant.Stream("pullsync", peer, stream)
+	if _, err := stream.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	testServer := newTestServer(t, testServerOptions{
+		Bandwidth: accountant,
+	})
+
+	jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/p2p/bandwidth", http.StatusOK,
+		jsonhttptest.WithExpectedJSONResponse(debugapi.BandwidthResponse{
+			Total: bandwidth.Stats{BytesOut: 100},
+			Limit: bandwidth.Limit{Rate: 1000000},
+			Protocols: []bandwidth.ProtocolStats{
+				{
+					Protocol:    "pullsync",
+					Stats:       bandwidth.Stats{BytesOut: 100},
+					Limit:       bandwidth.Limit{Rate: 1000, Burst: 2000},
+					LowPriority: true,
+				},
+			},
+			Peers: []bandwidth.PeerStats{
+				{
+					Address: peer,
+					Stats:   bandwidth.Stats{BytesOut: 100},
+				},
+			},
+		}),
+	)
+}
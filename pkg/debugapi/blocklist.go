@@ -0,0 +1,177 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debugapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/penguintop/penguin/pkg/jsonhttp"
+	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/penguin"
+)
+
+type blocklistedPeer struct {
+	Address  penguin.Address `json:"address"`
+	Reason   string          `json:"reason,omitempty"`
+	Duration float64         `json:"duration"` // in seconds, zero is permanent
+}
+
+type blocklistedNetwork struct {
+	Network  string  `json:"network"`
+	Reason   string  `json:"reason,omitempty"`
+	Duration float64 `json:"duration"` // in seconds, zero is permanent
+}
+
+type blocklistResponse struct {
+	Peers    []blocklistedPeer    `json:"peers"`
+	Networks []blocklistedNetwork `json:"networks"`
+}
+
+func (s *Service) blocklistedPeersHandler(w http.ResponseWriter, r *http.Request) {
+	peers, err := s.p2p.BlocklistedPeers()
+	if err != nil {
+		s.logger.Debugf("Debug api: blocklisted peers: %v", err)
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	networks, err := s.p2p.BlocklistedNetworks()
+	if err != nil {
+		s.logger.Debugf("Debug api: blocklisted networks: %v", err)
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	resp := blocklistResponse{
+		Peers:    make([]blocklistedPeer, 0, len(peers)),
+		Networks: make([]blocklistedNetwork, 0, len(networks)),
+	}
+	for _, p := range peers {
+		resp.Peers = append(resp.Peers, blocklistedPeer{
+			Address:  p.Address,
+			Reason:   p.Reason,
+			Duration: p.Duration.Seconds(),
+		})
+	}
+	for _, n := range networks {
+		resp.Networks = append(resp.Networks, blocklistedNetwork{
+			Network:  n.Network.String(),
+			Reason:   n.Reason,
+			Duration: n.Duration.Seconds(),
+		})
+	}
+
+	jsonhttp.OK(w, resp)
+}
+
+type blocklistRequest struct {
+	Duration string `json:"duration"` // time.ParseDuration format, empty is permanent
+	Reason   string `json:"reason"`
+}
+
+func (s *Service) blocklistHandler(w http.ResponseWriter, r *http.Request) {
+	addr := mux.Vars(r)["address"]
+	overlay, network, err := parseBlocklistAddress(addr)
+	if err != nil {
+		s.logger.Debugf("Debug api: blocklist: parse address %s: %v", addr, err)
+		jsonhttp.BadRequest(w, "invalid address")
+		return
+	}
+
+	var req blocklistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.logger.Debugf("Debug api: blocklist: read request: %v", err)
+		jsonhttp.BadRequest(w, "invalid request")
+		return
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		duration, err = time.ParseDuration(req.Duration)
+		if err != nil || duration < 0 {
+			s.logger.Debugf("Debug api: blocklist: parse duration %q: %v", req.Duration, err)
+			jsonhttp.BadRequest(w, "invalid duration")
+			return
+		}
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = "api"
+	}
+
+	if network != nil {
+		err = s.p2p.BlocklistNetwork(network, duration, reason)
+	} else {
+		err = s.p2p.Blocklist(overlay, duration, reason)
+	}
+	if err != nil {
+		s.logger.Debugf("Debug api: blocklist %s: %v", addr, err)
+		s.logger.Errorf("Debug api: blocklist %s", addr)
+		jsonhttp.InternalServerError(w, err)
+		return
+	}
+
+	jsonhttp.OK(w, nil)
+}
+
+func (s *Service) unblocklistHandler(w http.ResponseWriter, r *http.Request) {
+	addr := mux.Vars(r)["address"]
+	overlay, network, err := parseBlocklistAddress(addr)
+	if err != nil {
+		s.logger.Debugf("Debug api: unblocklist: parse address %s: %v", addr, err)
+		jsonhttp.BadRequest(w, "invalid address")
+		return
+	}
+
+	if network != nil {
+		err = s.p2p.UnblocklistNetwork(network)
+	} else {
+		err = s.p2p.Unblocklist(overlay)
+	}
+	if err != nil {
+		if errors.Is(err, p2p.ErrNotBlocklisted) {
+			jsonhttp.NotFound(w, "not blocklisted")
+			return
+		}
+		s.logger.Debugf("Debug api: unblocklist %s: %v", addr, err)
+		s.logger.Errorf("Debug api: unblocklist %s", addr)
+		jsonhttp.InternalServerError(w, err)
+		return
+	}
+
+	jsonhttp.OK(w, nil)
+}
+
+// parseBlocklistAddress parses either an IP address, a CIDR network or
+// an overlay address. Single IP addresses are returned as host networks.
+func parseBlocklistAddress(s string) (penguin.Address, *net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return penguin.ZeroAddress, nil, err
+		}
+		return penguin.ZeroAddress, network, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return penguin.ZeroAddress, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	overlay, err := penguin.ParseHexAddress(s)
+	if err != nil {
+		return penguin.ZeroAddress, nil, err
+	}
+	return overlay, nil, nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debugapi_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/debugapi"
+	"github.com/penguintop/penguin/pkg/jsonhttp"
+	"github.com/penguintop/penguin/pkg/jsonhttp/jsonhttptest"
+	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/p2p/mock"
+	"github.com/penguintop/penguin/pkg/penguin"
+)
+
+func TestBlocklistedPeers(t *testing.T) {
+	overlay := penguin.MustParseHexAddress("ca1e9f3938cc1425c6061b96ad9eb93e134dfe8734ad490164ef20af9d1cf59c")
+	_, network, err := net.ParseCIDR("10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testServer := newTestServer(t, testServerOptions{
+		P2P: mock.New(
+			mock.WithBlocklistedPeersFunc(func() ([]p2p.BlocklistedPeer, error) {
+				return []p2p.BlocklistedPeer{{
+					Peer:     p2p.Peer{Address: overlay},
+					Reason:   "pushsync: accounting: debt above the disconnect threshold",
+					Duration: 10 * time.Minute,
+				}}, nil
+			}),
+			mock.WithBlocklistedNetworksFunc(func() ([]p2p.BlocklistedNetwork, error) {
+				return []p2p.BlocklistedNetwork{{
+					Network: network,
+					Reason:  "api",
+				}}, nil
+			}),
+		),
+	})
+
+	jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/blocklist", http.StatusOK,
+		jsonhttptest.WithExpectedJSONResponse(debugapi.BlocklistResponse{
+			Peers: []debugapi.BlocklistedPeer{{
+				Address:  overlay,
+				Reason:   "pushsync: accounting: debt above the disconnect threshold",
+				Duration: 600,
+			}},
+			Networks: []debugapi.BlocklistedNetwork{{
+				Network: "10.1.0.0/16",
+				Reason:  "api",
+			}},
+		}),
+	)
+}
+
+func TestBlocklistedPeersErr(t *testing.T) {
+	overlay := penguin.MustParseHexAddress("ca1e9f3938cc1425c6061b96ad9eb93e134dfe8734ad490164ef20af9d1cf59c")
+	testServer := newTestServer(t, testServerOptions{
+		P2P: mock.New(mock.WithBlocklistedPeersFunc(func() ([]p2p.BlocklistedPeer, error) {
+			return []p2p.BlocklistedPeer{{Peer: p2p.Peer{Address: overlay}}}, errors.New("some error")
+		})),
+	})
+
+	jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/blocklist", http.StatusInternalServerError,
+		jsonhttptest.WithExpectedJSONResponse(
+			jsonhttp.StatusResponse{
+				Code:    http.StatusInternalServerError,
+				Message: http.StatusText(http.StatusInternalServerError),
+			}),
+	)
+}
+
+func TestBlocklist(t *testing.T) {
+	overlay := penguin.MustParseHexAddress("ca1e9f3938cc1425c6061b96ad9eb93e134dfe8734ad490164ef20af9d1cf59c")
+
+	type call struct {
+		overlay  penguin.Address
+		network  string
+		duration time.Duration
+		reason   string
+	}
+	var got call
+
+	testServer := newTestServer(t, testServerOptions{
+		P2P: mock.New(
+			mock.WithBlocklistFunc(func(addr penguin.Address, d time.Duration, reason string) error {
+				got = call{overlay: addr, duration: d, reason: reason}
+				return nil
+			}),
+			mock.WithBlocklistNetworkFunc(func(n *net.IPNet, d time.Duration, reason string) error {
+				got = call{network: n.String(), duration: d, reason: reason}
+				return nil
+			}),
+		),
+	})
+
+	for _, tc := range []struct {
+		name    string
+		address string
+		request interface{}
+		want    call
+	}{
+		{
+			name:    "overlay",
+			address: overlay.String(),
+			request: debugapi.BlocklistRequest{Duration: "1h", Reason: "manual"},
+			want:    call{overlay: overlay, duration: time.Hour, reason: "manual"},
+		},
+		{
+			name:    "ip",
+			address: "10.1.2.3",
+			request: debugapi.BlocklistRequest{},
+			want:    call{network: "10.1.2.3/32", reason: "api"},
+		},
+		{
+			name:    "cidr",
+			address: "10.1.0.0/16",
+			request: debugapi.BlocklistRequest{Duration: "30m", Reason: "spam"},
+			want:    call{network: "10.1.0.0/16", duration: 30 * time.Minute, reason: "spam"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got = call{}
+			jsonhttptest.Request(t, testServer.Client, http.MethodPost, "/blocklist/"+tc.address, http.StatusOK,
+				jsonhttptest.WithJSONRequestBody(tc.request),
+				jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+					Code:    http.StatusOK,
+					Message: http.StatusText(http.StatusOK),
+				}),
+			)
+			if !got.overlay.Equal(tc.want.overlay) || got.network != tc.want.network || got.duration != tc.want.duration || got.reason != tc.want.reason {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("invalid duration", func(t *testing.T) {
+		jsonhttptest.Request(t, testServer.Client, http.MethodPost, "/blocklist/"+overlay.String(), http.StatusBadRequest,
+			jsonhttptest.WithJSONRequestBody(debugapi.BlocklistRequest{Duration: "forever"}),
+			jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+				Code:    http.StatusBadRequest,
+				Message: "invalid duration",
+			}),
+		)
+	})
+
+	t.Run("invalid address", func(t *testing.T) {
+		jsonhttptest.Request(t, testServer.Client, http.MethodPost, "/blocklist/not-an-address", http.StatusBadRequest,
+			jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+				Code:    http.StatusBadRequest,
+				Message: "invalid address",
+			}),
+		)
+	})
+}
+
+func TestUnblocklist(t *testing.T) {
+	overlay := penguin.MustParseHexAddress("ca1e9f3938cc1425c6061b96ad9eb93e134dfe8734ad490164ef20af9d1cf59c")
+	unknown := penguin.MustParseHexAddress("ca1e9f3938cc1425c6061b96ad9eb93e134dfe8734ad490164ef20af9d1cf59a")
+
+	var gotNetwork string
+	testServer := newTestServer(t, testServerOptions{
+		P2P: mock.New(
+			mock.WithUnblocklistFunc(func(addr penguin.Address) error {
+				if !addr.Equal(overlay) {
+					return p2p.ErrNotBlocklisted
+				}
+				return nil
+			}),
+			mock.WithUnblocklistNetworkFunc(func(n *net.IPNet) error {
+				gotNetwork = n.String()
+				return nil
+			}),
+		),
+	})
+
+	jsonhttptest.Request(t, testServer.Client, http.MethodDelete, "/blocklist/"+overlay.String(), http.StatusOK,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Code:    http.StatusOK,
+			Message: http.StatusText(http.StatusOK),
+		}),
+	)
+
+	jsonhttptest.Request(t, testServer.Client, http.MethodDelete, "/blocklist/"+unknown.String(), http.StatusNotFound,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Code:    http.StatusNotFound,
+			Message: "not blocklisted",
+		}),
+	)
+
+	jsonhttptest.Request(t, testServer.Client, http.MethodDelete, "/blocklist/2001:db8::/32", http.StatusOK,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Code:    http.StatusOK,
+			Message: http.StatusText(http.StatusOK),
+		}),
+	)
+	if gotNetwork != "2001:db8::/32" {
+		t.Fatalf("got network %q, want %q", gotNetwork, "2001:db8::/32")
+	}
+}
@@ -36,6 +36,8 @@ var (
 	errNoCheque                    = "no prior cheque"
 	errBadGasPrice                 = "bad gas price"
 	errBadGasLimit                 = "bad gas limit"
+	errInvalidToken                = "invalid token address"
+	errUnsupportedToken            = "unsupported token"
 
 	gasPriceHeader = "Gas-Price"
 	gasLimitHeader = "Gas-Limit"
@@ -50,6 +52,17 @@ type chequebookAddressResponse struct {
 	Address string `json:"chequebookAddress"`
 }
 
+type chequebookTokenResponse struct {
+	Token            string   `json:"token"`
+	Address          string   `json:"chequebookAddress"`
+	TotalBalance     *big.Int `json:"totalBalance"`
+	AvailableBalance *big.Int `json:"availableBalance"`
+}
+
+type chequebookTokensResponse struct {
+	Tokens []chequebookTokenResponse `json:"tokens"`
+}
+
 type chequebookLastChequePeerResponse struct {
 	Beneficiary string   `json:"beneficiary"`
 	Chequebook  string   `json:"chequebook"`
@@ -58,6 +71,7 @@ type chequebookLastChequePeerResponse struct {
 
 type chequebookLastChequesPeerResponse struct {
 	Peer         string                            `json:"peer"`
+	Token        string                            `json:"token,omitempty"`
 	LastReceived *chequebookLastChequePeerResponse `json:"lastreceived"`
 	LastSent     *chequebookLastChequePeerResponse `json:"lastsent"`
 }
@@ -66,8 +80,94 @@ type chequebookLastChequesResponse struct {
 	LastCheques []chequebookLastChequesPeerResponse `json:"lastcheques"`
 }
 
+// requestChequebook returns the chequebook for the token given in the token
+// query parameter, or the chequebook of the default token if there is none.
+// If it returns false the response has already been written.
+func (s *Service) requestChequebook(w http.ResponseWriter, r *http.Request) (chequebook.Service, bool) {
+	tokenStr := r.URL.Query().Get("token")
+	if tokenStr == "" {
+		return s.chequebooks.Default(), true
+	}
+
+	tokenHex, err := xwcfmt.XwcConAddrToHexAddr(tokenStr)
+	if err != nil {
+		s.logger.Debugf("Debug api: chequebook: invalid token address %s: %v", tokenStr, err)
+		s.logger.Errorf("Debug api: chequebook: invalid token address %s", tokenStr)
+		jsonhttp.BadRequest(w, errInvalidToken)
+		return nil, false
+	}
+	tokenBytes, _ := hex.DecodeString(tokenHex)
+
+	c, err := s.chequebooks.Chequebook(common.BytesToAddress(tokenBytes))
+	if err != nil {
+		s.logger.Debugf("Debug api: chequebook: token %s: %v", tokenStr, err)
+		s.logger.Errorf("Debug api: chequebook: unsupported token %s", tokenStr)
+		jsonhttp.BadRequest(w, errUnsupportedToken)
+		return nil, false
+	}
+	return c, true
+}
+
+// peerToken returns the contract address of the token we settle in with the
+// peer, or an empty string if it is not known.
+func (s *Service) peerToken(peer penguin.Address) (string, error) {
+	token, known, err := s.swap.PeerToken(peer)
+	if err != nil || !known {
+		return "", err
+	}
+	conAddr, _ := xwcfmt.HexAddrToXwcConAddr(hex.EncodeToString(token[:]))
+	return conAddr, nil
+}
+
+func (s *Service) chequebookTokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens := s.chequebooks.Tokens()
+	responses := make([]chequebookTokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		c, err := s.chequebooks.Chequebook(token)
+		if err != nil {
+			jsonhttp.InternalServerError(w, errChequebookBalance)
+			s.logger.Debugf("Debug api: chequebook tokens: token %x: %v", token, err)
+			s.logger.Error("Debug api: cannot get chequebook tokens")
+			return
+		}
+
+		balance, err := c.Balance(r.Context())
+		if err != nil {
+			jsonhttp.InternalServerError(w, errChequebookBalance)
+			s.logger.Debugf("Debug api: chequebook tokens: token %x balance: %v", token, err)
+			s.logger.Error("Debug api: cannot get chequebook balance")
+			return
+		}
+
+		availableBalance, err := c.AvailableBalance(r.Context())
+		if err != nil {
+			jsonhttp.InternalServerError(w, errChequebookBalance)
+			s.logger.Debugf("Debug api: chequebook tokens: token %x availableBalance: %v", token, err)
+			s.logger.Error("Debug api: cannot get chequebook availableBalance")
+			return
+		}
+
+		address := c.Address()
+		tokenConAddr, _ := xwcfmt.HexAddrToXwcConAddr(hex.EncodeToString(token[:]))
+		conAddr, _ := xwcfmt.HexAddrToXwcConAddr(hex.EncodeToString(address[:]))
+		responses = append(responses, chequebookTokenResponse{
+			Token:            tokenConAddr,
+			Address:          conAddr,
+			TotalBalance:     balance,
+			AvailableBalance: availableBalance,
+		})
+	}
+
+	jsonhttp.OK(w, chequebookTokensResponse{Tokens: responses})
+}
+
 func (s *Service) chequebookBalanceHandler(w http.ResponseWriter, r *http.Request) {
-	balance, err := s.chequebook.Balance(r.Context())
+	c, ok := s.requestChequebook(w, r)
+	if !ok {
+		return
+	}
+
+	balance, err := c.Balance(r.Context())
 	if err != nil {
 		jsonhttp.InternalServerError(w, errChequebookBalance)
 		s.logger.Debugf("Debug api: chequebook balance: %v", err)
@@ -75,7 +175,7 @@ func (s *Service) chequebookBalanceHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	availableBalance, err := s.chequebook.AvailableBalance(r.Context())
+	availableBalance, err := c.AvailableBalance(r.Context())
 	if err != nil {
 		jsonhttp.InternalServerError(w, errChequebookBalance)
 		s.logger.Debugf("Debug api: chequebook availableBalance: %v", err)
@@ -87,7 +187,12 @@ func (s *Service) chequebookBalanceHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (s *Service) chequebookAddressHandler(w http.ResponseWriter, r *http.Request) {
-	address := s.chequebook.Address()
+	c, ok := s.requestChequebook(w, r)
+	if !ok {
+		return
+	}
+
+	address := c.Address()
 	conAddr, _ := xwcfmt.HexAddrToXwcConAddr(hex.EncodeToString(address[:]))
 	jsonhttp.OK(w, chequebookAddressResponse{Address: conAddr})
 }
@@ -138,8 +243,17 @@ func (s *Service) chequebookLastPeerHandler(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	tokenResponse, err := s.peerToken(peer)
+	if err != nil {
+		s.logger.Debugf("Debug api: chequebook cheque peer: get peer %s token: %v", peer.String(), err)
+		s.logger.Errorf("Debug api: chequebook cheque peer: can't get peer %s token", peer.String())
+		jsonhttp.InternalServerError(w, errCantLastChequePeer)
+		return
+	}
+
 	jsonhttp.OK(w, chequebookLastChequesPeerResponse{
 		Peer:         addr,
+		Token:        tokenResponse,
 		LastReceived: lastReceivedResponse,
 		LastSent:     lastSentResponse,
 	})
@@ -202,7 +316,22 @@ func (s *Service) chequebookAllLastHandler(w http.ResponseWriter, r *http.Reques
 	lcresponses := make([]chequebookLastChequesPeerResponse, len(lcr))
 	i := 0
 	for k := range lcr {
-		lcresponses[i] = lcr[k]
+		peer, err := penguin.ParseHexAddress(k)
+		if err != nil {
+			s.logger.Debugf("Debug api: chequebook cheque all: invalid peer address %s: %v", k, err)
+			s.logger.Errorf("Debug api: chequebook cheque all: can't get all last cheques")
+			jsonhttp.InternalServerError(w, errCantLastCheque)
+			return
+		}
+		lc := lcr[k]
+		lc.Token, err = s.peerToken(peer)
+		if err != nil {
+			s.logger.Debugf("Debug api: chequebook cheque all: get peer %s token: %v", k, err)
+			s.logger.Errorf("Debug api: chequebook cheque all: can't get all last cheques")
+			jsonhttp.InternalServerError(w, errCantLastCheque)
+			return
+		}
+		lcresponses[i] = lc
 		i++
 	}
 
@@ -264,6 +393,7 @@ type swapCashoutStatusResult struct {
 
 type swapCashoutStatusResponse struct {
 	Peer            penguin.Address                   `json:"peer"`
+	Token           string                            `json:"token,omitempty"`
 	Cheque          *chequebookLastChequePeerResponse `json:"lastCashedCheque"`
 	TransactionHash *common.Hash                      `json:"transactionHash"`
 	Result          *swapCashoutStatusResult          `json:"result"`
@@ -322,8 +452,17 @@ func (s *Service) swapCashoutStatusHandler(w http.ResponseWriter, r *http.Reques
 		txHash = &status.Last.TxHash
 	}
 
+	token, err := s.peerToken(peer)
+	if err != nil {
+		s.logger.Debugf("Debug api: cashout status peer: get peer %s token: %v", addr, err)
+		s.logger.Errorf("Debug api: cashout status peer: cannot get status %s", addr)
+		jsonhttp.InternalServerError(w, errCannotCashStatus)
+		return
+	}
+
 	jsonhttp.OK(w, swapCashoutStatusResponse{
 		Peer:            peer,
+		Token:           token,
 		TransactionHash: txHash,
 		Cheque:          chequeResponse,
 		Result:          result,
@@ -365,7 +504,12 @@ func (s *Service) chequebookWithdrawHandler(w http.ResponseWriter, r *http.Reque
 		ctx = sctx.SetGasPrice(ctx, p)
 	}
 
-	txHash, err := s.chequebook.Withdraw(ctx, amount)
+	c, ok := s.requestChequebook(w, r)
+	if !ok {
+		return
+	}
+
+	txHash, err := c.Withdraw(ctx, amount)
 	if errors.Is(err, chequebook.ErrInsufficientFunds) {
 		jsonhttp.BadRequest(w, errChequebookInsufficientFunds)
 		s.logger.Debugf("Debug api: chequebook withdraw: %v", err)
@@ -409,7 +553,12 @@ func (s *Service) chequebookDepositHandler(w http.ResponseWriter, r *http.Reques
 		ctx = sctx.SetGasPrice(ctx, p)
 	}
 
-	txHash, err := s.chequebook.Deposit(ctx, amount)
+	c, ok := s.requestChequebook(w, r)
+	if !ok {
+		return
+	}
+
+	txHash, err := c.Deposit(ctx, amount)
 	if errors.Is(err, chequebook.ErrInsufficientFunds) {
 		jsonhttp.BadRequest(w, errChequebookInsufficientFunds)
 		s.logger.Debugf("Debug api: chequebook deposit: %v", err)
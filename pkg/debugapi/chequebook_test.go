@@ -6,6 +6,7 @@ package debugapi_test
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"math/big"
 	"net/http"
@@ -20,6 +21,7 @@ import (
 	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook"
 	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook/mock"
 	swapmock "github.com/penguintop/penguin/pkg/settlement/swap/mock"
+	"github.com/penguintop/penguin/pkg/xwcfmt"
 
 	"github.com/penguintop/penguin/pkg/penguin"
 )
@@ -101,6 +103,85 @@ func TestChequebookAvailableBalanceError(t *testing.T) {
 	)
 }
 
+func TestChequebookTokens(t *testing.T) {
+	defaultToken := common.HexToAddress("0xaa")
+	otherToken := common.HexToAddress("0xbb")
+	defaultChequebook := common.HexToAddress("0xfffff")
+	otherChequebook := common.HexToAddress("0xeeeee")
+
+	balanceFunc := func(balance int64) func(context.Context) (*big.Int, error) {
+		return func(context.Context) (*big.Int, error) {
+			return big.NewInt(balance), nil
+		}
+	}
+	addressFunc := func(address common.Address) func() common.Address {
+		return func() common.Address {
+			return address
+		}
+	}
+
+	testServer := newTestServer(t, testServerOptions{
+		ChequebookOpts: []mock.Option{
+			mock.WithChequebookTokenFunc(addressFunc(defaultToken)),
+			mock.WithChequebookAddressFunc(addressFunc(defaultChequebook)),
+			mock.WithChequebookBalanceFunc(balanceFunc(9000)),
+			mock.WithChequebookAvailableBalanceFunc(balanceFunc(1000)),
+		},
+		TokenChequebooks: [][]mock.Option{
+			{
+				mock.WithChequebookTokenFunc(addressFunc(otherToken)),
+				mock.WithChequebookAddressFunc(addressFunc(otherChequebook)),
+				mock.WithChequebookBalanceFunc(balanceFunc(500)),
+				mock.WithChequebookAvailableBalanceFunc(balanceFunc(50)),
+			},
+		},
+	})
+
+	conAddr := func(address common.Address) string {
+		a, _ := xwcfmt.HexAddrToXwcConAddr(hex.EncodeToString(address[:]))
+		return a
+	}
+
+	jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/chequebook/tokens", http.StatusOK,
+		jsonhttptest.WithExpectedJSONResponse(debugapi.ChequebookTokensResponse{
+			Tokens: []debugapi.ChequebookTokenResponse{
+				{
+					Token:            conAddr(defaultToken),
+					Address:          conAddr(defaultChequebook),
+					TotalBalance:     big.NewInt(9000),
+					AvailableBalance: big.NewInt(1000),
+				},
+				{
+					Token:            conAddr(otherToken),
+					Address:          conAddr(otherChequebook),
+					TotalBalance:     big.NewInt(500),
+					AvailableBalance: big.NewInt(50),
+				},
+			},
+		}),
+	)
+
+	jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/chequebook/balance?token="+conAddr(otherToken), http.StatusOK,
+		jsonhttptest.WithExpectedJSONResponse(debugapi.ChequebookBalanceResponse{
+			TotalBalance:     big.NewInt(500),
+			AvailableBalance: big.NewInt(50),
+		}),
+	)
+
+	jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/chequebook/address?token="+conAddr(otherToken), http.StatusOK,
+		jsonhttptest.WithExpectedJSONResponse(debugapi.ChequebookAddressResponse{
+			Address: conAddr(otherChequebook),
+		}),
+	)
+
+	jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/chequebook/balance?token="+conAddr(common.HexToAddress("0xcc")), http.StatusBadRequest,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: debugapi.ErrUnsupportedToken,
+			Code:    http.StatusBadRequest,
+		}),
+	)
+}
+
 func TestChequebookAddress(t *testing.T) {
 	chequebookAddressFunc := func() common.Address {
 		return common.HexToAddress("0xfffff")
@@ -111,9 +192,13 @@ func TestChequebookAddress(t *testing.T) {
 	})
 
 	address := common.HexToAddress("0xfffff")
+	conAddr, err := xwcfmt.HexAddrToXwcConAddr(hex.EncodeToString(address[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	expected := &debugapi.ChequebookAddressResponse{
-		Address: address.String(),
+		Address: conAddr,
 	}
 
 	var got *debugapi.ChequebookAddressResponse
@@ -142,7 +227,7 @@ func TestChequebookWithdraw(t *testing.T) {
 			ChequebookOpts: []mock.Option{mock.WithChequebookWithdrawFunc(chequebookWithdrawFunc)},
 		})
 
-		expected := &debugapi.ChequebookTxResponse{TransactionHash: txHash}
+		expected := &debugapi.ChequebookTxResponse{TransactionHash: hex.EncodeToString(txHash[12:])}
 
 		var got *debugapi.ChequebookTxResponse
 		jsonhttptest.Request(t, testServer.Client, http.MethodPost, "/chequebook/withdraw?amount=500", http.StatusOK,
@@ -169,7 +254,7 @@ func TestChequebookWithdraw(t *testing.T) {
 			ChequebookOpts: []mock.Option{mock.WithChequebookWithdrawFunc(chequebookWithdrawFunc)},
 		})
 
-		expected := &debugapi.ChequebookTxResponse{TransactionHash: txHash}
+		expected := &debugapi.ChequebookTxResponse{TransactionHash: hex.EncodeToString(txHash[12:])}
 
 		var got *debugapi.ChequebookTxResponse
 		jsonhttptest.Request(t, testServer.Client, http.MethodPost, "/chequebook/withdraw?amount=500", http.StatusOK,
@@ -199,7 +284,7 @@ func TestChequebookDeposit(t *testing.T) {
 			ChequebookOpts: []mock.Option{mock.WithChequebookDepositFunc(chequebookDepositFunc)},
 		})
 
-		expected := &debugapi.ChequebookTxResponse{TransactionHash: txHash}
+		expected := &debugapi.ChequebookTxResponse{TransactionHash: hex.EncodeToString(txHash[12:])}
 
 		var got *debugapi.ChequebookTxResponse
 		jsonhttptest.Request(t, testServer.Client, http.MethodPost, "/chequebook/deposit?amount=700", http.StatusOK,
@@ -227,7 +312,7 @@ func TestChequebookDeposit(t *testing.T) {
 			ChequebookOpts: []mock.Option{mock.WithChequebookDepositFunc(chequebookDepositFunc)},
 		})
 
-		expected := &debugapi.ChequebookTxResponse{TransactionHash: txHash}
+		expected := &debugapi.ChequebookTxResponse{TransactionHash: hex.EncodeToString(txHash[12:])}
 
 		var got *debugapi.ChequebookTxResponse
 		jsonhttptest.Request(t, testServer.Client, http.MethodPost, "/chequebook/deposit?amount=700", http.StatusOK,
@@ -329,21 +414,27 @@ func TestChequebookLastCheques(t *testing.T) {
 		return lastReceivedCheques, nil
 	}
 
+	token := common.HexToAddress("0xaaaa")
+	peerTokenFunc := func(peer penguin.Address) (common.Address, bool, error) {
+		return token, peer.Equal(addr1), nil
+	}
+
 	testServer := newTestServer(t, testServerOptions{
-		SwapOpts: []swapmock.Option{swapmock.WithLastReceivedChequesFunc(lastReceivedChequesFunc), swapmock.WithLastSentChequesFunc(lastSentChequesFunc)},
+		SwapOpts: []swapmock.Option{swapmock.WithLastReceivedChequesFunc(lastReceivedChequesFunc), swapmock.WithLastSentChequesFunc(lastSentChequesFunc), swapmock.WithPeerTokenFunc(peerTokenFunc)},
 	})
 
 	lastchequesexpected := []debugapi.ChequebookLastChequesPeerResponse{
 		{
-			Peer: addr1.String(),
+			Peer:  addr1.String(),
+			Token: xwcContractAddress(token),
 			LastReceived: &debugapi.ChequebookLastChequePeerResponse{
-				Beneficiary: beneficiary.String(),
-				Chequebook:  chequebookAddress1.String(),
+				Beneficiary: xwcAddress(beneficiary),
+				Chequebook:  xwcContractAddress(chequebookAddress1),
 				Payout:      cumulativePayout4,
 			},
 			LastSent: &debugapi.ChequebookLastChequePeerResponse{
-				Beneficiary: beneficiary1.String(),
-				Chequebook:  chequebookAddress1.String(),
+				Beneficiary: xwcAddress(beneficiary1),
+				Chequebook:  xwcContractAddress(chequebookAddress1),
 				Payout:      cumulativePayout1,
 			},
 		},
@@ -351,8 +442,8 @@ func TestChequebookLastCheques(t *testing.T) {
 			Peer:         addr2.String(),
 			LastReceived: nil,
 			LastSent: &debugapi.ChequebookLastChequePeerResponse{
-				Beneficiary: beneficiary2.String(),
-				Chequebook:  chequebookAddress2.String(),
+				Beneficiary: xwcAddress(beneficiary2),
+				Chequebook:  xwcContractAddress(chequebookAddress2),
 				Payout:      cumulativePayout2,
 			},
 		},
@@ -360,16 +451,16 @@ func TestChequebookLastCheques(t *testing.T) {
 			Peer:         addr3.String(),
 			LastReceived: nil,
 			LastSent: &debugapi.ChequebookLastChequePeerResponse{
-				Beneficiary: beneficiary3.String(),
-				Chequebook:  chequebookAddress3.String(),
+				Beneficiary: xwcAddress(beneficiary3),
+				Chequebook:  xwcContractAddress(chequebookAddress3),
 				Payout:      cumulativePayout3,
 			},
 		},
 		{
 			Peer: addr4.String(),
 			LastReceived: &debugapi.ChequebookLastChequePeerResponse{
-				Beneficiary: beneficiary.String(),
-				Chequebook:  chequebookAddress4.String(),
+				Beneficiary: xwcAddress(beneficiary),
+				Chequebook:  xwcContractAddress(chequebookAddress4),
 				Payout:      cumulativePayout5,
 			},
 			LastSent: nil,
@@ -377,8 +468,8 @@ func TestChequebookLastCheques(t *testing.T) {
 		{
 			Peer: addr5.String(),
 			LastReceived: &debugapi.ChequebookLastChequePeerResponse{
-				Beneficiary: beneficiary.String(),
-				Chequebook:  chequebookAddress5.String(),
+				Beneficiary: xwcAddress(beneficiary),
+				Chequebook:  xwcContractAddress(chequebookAddress5),
 				Payout:      cumulativePayout6,
 			},
 			LastSent: nil,
@@ -448,13 +539,13 @@ func TestChequebookLastChequesPeer(t *testing.T) {
 	expected := &debugapi.ChequebookLastChequesPeerResponse{
 		Peer: addr.String(),
 		LastReceived: &debugapi.ChequebookLastChequePeerResponse{
-			Beneficiary: beneficiary0.String(),
-			Chequebook:  chequebookAddress.String(),
+			Beneficiary: xwcAddress(beneficiary0),
+			Chequebook:  xwcContractAddress(chequebookAddress),
 			Payout:      cumulativePayout2,
 		},
 		LastSent: &debugapi.ChequebookLastChequePeerResponse{
-			Beneficiary: beneficiary1.String(),
-			Chequebook:  chequebookAddress.String(),
+			Beneficiary: xwcAddress(beneficiary1),
+			Chequebook:  xwcContractAddress(chequebookAddress),
 			Payout:      cumulativePayout1,
 		},
 	}
@@ -580,17 +671,23 @@ func TestChequebookCashoutStatus(t *testing.T) {
 			return status, nil
 		}
 
+		token := common.HexToAddress("0xaaaa")
+		peerTokenFunc := func(penguin.Address) (common.Address, bool, error) {
+			return token, true, nil
+		}
+
 		testServer := newTestServer(t, testServerOptions{
-			SwapOpts: []swapmock.Option{swapmock.WithCashoutStatusFunc(cashoutStatusFunc)},
+			SwapOpts: []swapmock.Option{swapmock.WithCashoutStatusFunc(cashoutStatusFunc), swapmock.WithPeerTokenFunc(peerTokenFunc)},
 		})
 
 		expected := &debugapi.SwapCashoutStatusResponse{
 			Peer:            peer,
+			Token:           xwcContractAddress(token),
 			TransactionHash: &actionTxHash,
 			Cheque: &debugapi.ChequebookLastChequePeerResponse{
-				Chequebook:  chequebookAddress.String(),
+				Chequebook:  xwcContractAddress(chequebookAddress),
 				Payout:      cumulativePayout,
-				Beneficiary: cheque.Beneficiary.String(),
+				Beneficiary: xwcAddress(cheque.Beneficiary),
 			},
 			Result: &debugapi.SwapCashoutStatusResult{
 				Recipient:  recipientAddress,
@@ -632,9 +729,9 @@ func TestChequebookCashoutStatus(t *testing.T) {
 			Peer:            peer,
 			TransactionHash: &actionTxHash,
 			Cheque: &debugapi.ChequebookLastChequePeerResponse{
-				Chequebook:  chequebookAddress.String(),
+				Chequebook:  xwcContractAddress(chequebookAddress),
 				Payout:      cumulativePayout,
-				Beneficiary: cheque.Beneficiary.String(),
+				Beneficiary: xwcAddress(cheque.Beneficiary),
 			},
 			Result:         nil,
 			UncashedAmount: uncashedAmount,
@@ -714,3 +811,15 @@ func LastChequesEqual(a, b *debugapi.ChequebookLastChequesResponse) bool {
 
 	return true
 }
+
+// xwcAddress returns the address in the format the debug api reports it.
+func xwcAddress(a common.Address) string {
+	addr, _ := xwcfmt.HexAddrToXwcAddr(hex.EncodeToString(a[:]))
+	return addr
+}
+
+// xwcContractAddress returns the contract address in the format the debug api reports it.
+func xwcContractAddress(a common.Address) string {
+	addr, _ := xwcfmt.HexAddrToXwcConAddr(hex.EncodeToString(a[:]))
+	return addr
+}
@@ -5,9 +5,14 @@
 package debugapi
 
 import (
+	"encoding/hex"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/penguintop/penguin/pkg/jsonhttp"
+	"github.com/penguintop/penguin/pkg/localstore"
+	"github.com/penguintop/penguin/pkg/shed"
 	"github.com/penguintop/penguin/pkg/storage"
 	"github.com/penguintop/penguin/pkg/penguin"
 	"github.com/gorilla/mux"
@@ -63,3 +68,165 @@ func (s *Service) removeChunk(w http.ResponseWriter, r *http.Request) {
 	}
 	jsonhttp.OK(w, nil)
 }
+
+type chunkLister interface {
+	ListChunks(o *localstore.ListOptions) (*localstore.ChunkList, error)
+	ListBinChunks(bin uint8, o *localstore.ListOptions) (*localstore.ChunkList, error)
+	ListBatchChunks(batchID []byte, o *localstore.ListOptions) (*localstore.ChunkList, error)
+	ListPinnedChunks(o *localstore.ListOptions) (*localstore.ChunkList, error)
+	ListPushChunks(o *localstore.ListOptions) (*localstore.ChunkList, error)
+	ChunkInfo(addr penguin.Address) (*localstore.ChunkInfo, error)
+}
+
+type chunkListResponse struct {
+	Chunks []penguin.Address `json:"chunks"`
+	Cursor string            `json:"cursor,omitempty"`
+}
+
+// listChunksHandler returns a page of addresses of locally stored chunks.
+// Chunks can be filtered by one of the bin, batch or state query parameters.
+func (s *Service) listChunksHandler(w http.ResponseWriter, r *http.Request) {
+	lister, ok := s.storer.(chunkLister)
+	if !ok {
+		jsonhttp.NotImplemented(w, nil)
+		return
+	}
+	o, ok := s.parseListOptions(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	var filters int
+	for _, name := range []string{"bin", "batch", "state"} {
+		if q.Get(name) != "" {
+			filters++
+		}
+	}
+	if filters > 1 {
+		jsonhttp.BadRequest(w, "only one of bin, batch and state can be set")
+		return
+	}
+
+	var (
+		list *localstore.ChunkList
+		err  error
+	)
+	switch {
+	case q.Get("bin") != "":
+		bin, perr := strconv.ParseUint(q.Get("bin"), 10, 8)
+		if perr != nil || bin > uint64(penguin.MaxPO) {
+			s.logger.Debugf("Debug api: list chunks: invalid bin %q", q.Get("bin"))
+			jsonhttp.BadRequest(w, "invalid bin")
+			return
+		}
+		list, err = lister.ListBinChunks(uint8(bin), o)
+	case q.Get("batch") != "":
+		batchID, perr := hex.DecodeString(q.Get("batch"))
+		if perr != nil || len(batchID) != 32 {
+			s.logger.Debugf("Debug api: list chunks: invalid batch id %q", q.Get("batch"))
+			jsonhttp.BadRequest(w, errBadBatchID)
+			return
+		}
+		list, err = lister.ListBatchChunks(batchID, o)
+	case q.Get("state") == "pinned":
+		list, err = lister.ListPinnedChunks(o)
+	case q.Get("state") == "unsynced":
+		list, err = lister.ListPushChunks(o)
+	case q.Get("state") != "":
+		s.logger.Debugf("Debug api: list chunks: invalid state %q", q.Get("state"))
+		jsonhttp.BadRequest(w, "invalid state")
+		return
+	default:
+		list, err = lister.ListChunks(o)
+	}
+	if err != nil {
+		if errors.Is(err, shed.ErrInvalidCursor) {
+			jsonhttp.BadRequest(w, "invalid cursor")
+			return
+		}
+		s.logger.Debugf("Debug api: list chunks: %v", err)
+		s.logger.Error("Debug api: list chunks failed")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, chunkListResponse{
+		Chunks: list.Addresses,
+		Cursor: list.Cursor,
+	})
+}
+
+type chunkInfoResponse struct {
+	Address         penguin.Address `json:"address"`
+	BatchID         string          `json:"batchID"`
+	Signature       string          `json:"signature"`
+	StoreTimestamp  int64           `json:"storeTimestamp"`
+	AccessTimestamp int64           `json:"accessTimestamp"`
+	Bin             uint8           `json:"bin"`
+	BinID           uint64          `json:"binID"`
+	PinCounter      uint64          `json:"pinCounter"`
+	Reserved        bool            `json:"reserved"`
+}
+
+// chunkInfoHandler returns the metadata of a locally stored chunk.
+func (s *Service) chunkInfoHandler(w http.ResponseWriter, r *http.Request) {
+	lister, ok := s.storer.(chunkLister)
+	if !ok {
+		jsonhttp.NotImplemented(w, nil)
+		return
+	}
+	addr, err := penguin.ParseHexAddress(mux.Vars(r)["address"])
+	if err != nil {
+		s.logger.Debugf("Debug api: parse chunk address: %v", err)
+		jsonhttp.BadRequest(w, "bad address")
+		return
+	}
+
+	info, err := lister.ChunkInfo(addr)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			jsonhttp.NotFound(w, nil)
+			return
+		}
+		s.logger.Debugf("Debug api: chunk info %s: %v", addr, err)
+		s.logger.Error("Debug api: chunk info failed")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, chunkInfoResponse{
+		Address:         info.Address,
+		BatchID:         hex.EncodeToString(info.BatchID),
+		Signature:       hex.EncodeToString(info.Sig),
+		StoreTimestamp:  info.StoreTimestamp,
+		AccessTimestamp: info.AccessTimestamp,
+		Bin:             info.Bin,
+		BinID:           info.BinID,
+		PinCounter:      info.PinCounter,
+		Reserved:        info.Reserved,
+	})
+}
+
+// parseListOptions parses the cursor, limit and reverse query parameters.
+func (s *Service) parseListOptions(w http.ResponseWriter, r *http.Request) (*localstore.ListOptions, bool) {
+	q := r.URL.Query()
+	o := &localstore.ListOptions{Cursor: q.Get("cursor")}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > localstore.MaxListLimit {
+			s.logger.Debugf("Debug api: list chunks: invalid limit %q", v)
+			jsonhttp.BadRequest(w, "invalid limit")
+			return nil, false
+		}
+		o.Limit = limit
+	}
+	if v := q.Get("reverse"); v != "" {
+		reverse, err := strconv.ParseBool(v)
+		if err != nil {
+			s.logger.Debugf("Debug api: list chunks: invalid reverse %q", v)
+			jsonhttp.BadRequest(w, "invalid reverse")
+			return nil, false
+		}
+		o.Reverse = reverse
+	}
+	return o, true
+}
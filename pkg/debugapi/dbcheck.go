@@ -0,0 +1,115 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debugapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/penguintop/penguin/pkg/jsonhttp"
+	"github.com/penguintop/penguin/pkg/localstore"
+	"github.com/penguintop/penguin/pkg/postage"
+)
+
+// defaultDBCheckRate is the number of database entries checked per second
+// if no rate is requested, so that the check does not starve the node.
+const defaultDBCheckRate = 5000
+
+var (
+	errDBCheckBadRate   = errors.New("invalid rate")
+	errDBCheckBadRepair = errors.New("invalid repair")
+	errDBCheckNotFound  = errors.New("no database check started")
+)
+
+type dbChecker interface {
+	StartCheck(o *localstore.CheckOptions) error
+	CheckStatus() *localstore.CheckStatus
+}
+
+type dbCheckResponse struct {
+	*localstore.CheckStatus
+	// Consistent is set once the check finished.
+	Consistent *bool `json:"consistent,omitempty"`
+}
+
+func newDBCheckResponse(status *localstore.CheckStatus) dbCheckResponse {
+	r := dbCheckResponse{CheckStatus: status}
+	if status.Report != nil && status.Error == "" {
+		consistent := status.Report.Consistent()
+		r.Consistent = &consistent
+	}
+	return r
+}
+
+// dbCheckHandler starts the database consistency check in the background.
+// The found problems are repaired if requested.
+func (s *Service) dbCheckHandler(w http.ResponseWriter, r *http.Request) {
+	checker, ok := s.storer.(dbChecker)
+	if !ok {
+		jsonhttp.NotImplemented(w, nil)
+		return
+	}
+
+	rate := defaultDBCheckRate
+	if rateStr := r.URL.Query().Get("rate"); rateStr != "" {
+		var err error
+		rate, err = strconv.Atoi(rateStr)
+		if err != nil || rate < 0 {
+			s.logger.Debugf("Debug api: db check: invalid rate %q", rateStr)
+			jsonhttp.BadRequest(w, errDBCheckBadRate)
+			return
+		}
+	}
+
+	var repair bool
+	if repairStr := r.URL.Query().Get("repair"); repairStr != "" {
+		var err error
+		repair, err = strconv.ParseBool(repairStr)
+		if err != nil {
+			s.logger.Debugf("Debug api: db check: invalid repair %q", repairStr)
+			jsonhttp.BadRequest(w, errDBCheckBadRepair)
+			return
+		}
+	}
+
+	o := &localstore.CheckOptions{
+		Repair: repair,
+		Rate:   rate,
+	}
+	if s.batchStore != nil {
+		o.ValidStamp = postage.ValidStamp(s.batchStore)
+	}
+
+	if err := checker.StartCheck(o); err != nil {
+		if errors.Is(err, localstore.ErrCheckRunning) {
+			jsonhttp.Conflict(w, err)
+			return
+		}
+		s.logger.Debugf("Debug api: db check: %v", err)
+		s.logger.Error("Debug api: db check failed")
+		jsonhttp.InternalServerError(w, err)
+		return
+	}
+
+	jsonhttp.Accepted(w, newDBCheckResponse(checker.CheckStatus()))
+}
+
+// dbCheckStatusHandler returns the status of the last database check.
+func (s *Service) dbCheckStatusHandler(w http.ResponseWriter, r *http.Request) {
+	checker, ok := s.storer.(dbChecker)
+	if !ok {
+		jsonhttp.NotImplemented(w, nil)
+		return
+	}
+
+	status := checker.CheckStatus()
+	if status == nil {
+		jsonhttp.NotFound(w, errDBCheckNotFound)
+		return
+	}
+
+	jsonhttp.OK(w, newDBCheckResponse(status))
+}
@@ -13,12 +13,15 @@ import (
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/penguintop/penguin/pkg/addressbook"
 	"github.com/penguintop/penguin/pkg/accounting"
 	"github.com/penguintop/penguin/pkg/logging"
 	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/p2p/bandwidth"
 	"github.com/penguintop/penguin/pkg/pingpong"
 	"github.com/penguintop/penguin/pkg/postage"
-	"github.com/penguintop/penguin/pkg/settlement"
+	"github.com/penguintop/penguin/pkg/reputation"
+	"github.com/penguintop/penguin/pkg/settlement/pseudosettle"
 	"github.com/penguintop/penguin/pkg/settlement/swap"
 	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook"
 	"github.com/penguintop/penguin/pkg/storage"
@@ -45,14 +48,17 @@ type Service struct {
 	tracer             *tracing.Tracer
 	tags               *tags.Tags
 	accounting         accounting.Interface
-	pseudosettle       settlement.Interface
+	pseudosettle       pseudosettle.Interface
 	chequebookEnabled  bool
-	chequebook         chequebook.Service
+	chequebooks        chequebook.Chequebooks
 	swap               swap.Interface
 	batchStore         postage.Storer
 	corsAllowedOrigins []string
 	metricsRegistry    *prometheus.Registry
 	lightNodes         *lightnode.Container
+	reputation         reputation.Interface
+	addressBook        addressbook.Interface
+	bandwidth          bandwidth.Interface
 	// handler is changed in the Configure method
 	handler   http.Handler
 	handlerMu sync.RWMutex
@@ -83,7 +89,7 @@ func New(overlay penguin.Address, publicKey, pssPublicKey ecdsa.PublicKey, xwcAd
 // Configure injects required dependencies and configuration parameters and
 // constructs HTTP routes that depend on them. It is intended and safe to call
 // this method only once.
-func (s *Service) Configure(p2p p2p.DebugService, pingpong pingpong.Interface, topologyDriver topology.Driver, lightNodes *lightnode.Container, storer storage.Storer, tags *tags.Tags, accounting accounting.Interface, pseudosettle settlement.Interface, chequebookEnabled bool, swap swap.Interface, chequebook chequebook.Service, batchStore postage.Storer) {
+func (s *Service) Configure(p2p p2p.DebugService, pingpong pingpong.Interface, topologyDriver topology.Driver, lightNodes *lightnode.Container, storer storage.Storer, tags *tags.Tags, accounting accounting.Interface, pseudosettle pseudosettle.Interface, chequebookEnabled bool, swap swap.Interface, chequebooks chequebook.Chequebooks, batchStore postage.Storer, reputation reputation.Interface, addressBook addressbook.Interface, bandwidth bandwidth.Interface) {
 	s.p2p = p2p
 	s.pingpong = pingpong
 	s.topologyDriver = topologyDriver
@@ -91,11 +97,14 @@ func (s *Service) Configure(p2p p2p.DebugService, pingpong pingpong.Interface, t
 	s.tags = tags
 	s.accounting = accounting
 	s.chequebookEnabled = chequebookEnabled
-	s.chequebook = chequebook
+	s.chequebooks = chequebooks
 	s.swap = swap
 	s.lightNodes = lightNodes
 	s.batchStore = batchStore
 	s.pseudosettle = pseudosettle
+	s.reputation = reputation
+	s.addressBook = addressBook
+	s.bandwidth = bandwidth
 
 	s.setRouter(s.newRouter())
 }
@@ -8,7 +8,7 @@ import (
 	"crypto/ecdsa"
 	"encoding/hex"
 	pen "github.com/penguintop/penguin"
-	"github.com/penguintop/penguin/pkg/property"
+	"github.com/penguintop/penguin/pkg/xwcfmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -17,15 +17,20 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	accountingmock "github.com/penguintop/penguin/pkg/accounting/mock"
+	"github.com/penguintop/penguin/pkg/addressbook"
 	"github.com/penguintop/penguin/pkg/crypto"
 	"github.com/penguintop/penguin/pkg/debugapi"
 	"github.com/penguintop/penguin/pkg/jsonhttp"
 	"github.com/penguintop/penguin/pkg/jsonhttp/jsonhttptest"
 	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/p2p/bandwidth"
 	p2pmock "github.com/penguintop/penguin/pkg/p2p/mock"
 	"github.com/penguintop/penguin/pkg/pingpong"
 	"github.com/penguintop/penguin/pkg/postage"
+	"github.com/penguintop/penguin/pkg/reputation"
 	"github.com/penguintop/penguin/pkg/resolver"
+	pseudosettlemock "github.com/penguintop/penguin/pkg/settlement/pseudosettle/mock"
+	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook"
 	chequebookmock "github.com/penguintop/penguin/pkg/settlement/swap/chequebook/mock"
 	swapmock "github.com/penguintop/penguin/pkg/settlement/swap/mock"
 	"github.com/penguintop/penguin/pkg/storage"
@@ -50,10 +55,14 @@ type testServerOptions struct {
 	TopologyOpts       []topologymock.Option
 	Tags               *tags.Tags
 	AccountingOpts     []accountingmock.Option
-	SettlementOpts     []swapmock.Option
+	SettlementOpts     []pseudosettlemock.Option
 	ChequebookOpts     []chequebookmock.Option
+	TokenChequebooks   [][]chequebookmock.Option
 	SwapOpts           []swapmock.Option
 	BatchStore         postage.Storer
+	Reputation         reputation.Interface
+	AddressBook        addressbook.Interface
+	Bandwidth          bandwidth.Interface
 }
 
 type testServer struct {
@@ -61,15 +70,28 @@ type testServer struct {
 	P2PMock *p2pmock.Service
 }
 
+func newChequebooks(t *testing.T, o testServerOptions) chequebook.Chequebooks {
+	t.Helper()
+	var tokenChequebooks []chequebook.Service
+	for _, opts := range o.TokenChequebooks {
+		tokenChequebooks = append(tokenChequebooks, chequebookmock.NewChequebook(opts...))
+	}
+	chequebooks, err := chequebook.NewChequebooks(chequebookmock.NewChequebook(o.ChequebookOpts...), tokenChequebooks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chequebooks
+}
+
 func newTestServer(t *testing.T, o testServerOptions) *testServer {
 	topologyDriver := topologymock.NewTopologyDriver(o.TopologyOpts...)
 	acc := accountingmock.NewAccounting(o.AccountingOpts...)
-	settlement := swapmock.New(o.SettlementOpts...)
-	chequebook := chequebookmock.NewChequebook(o.ChequebookOpts...)
+	settlement := pseudosettlemock.New(o.SettlementOpts...)
+	chequebooks := newChequebooks(t, o)
 	swapserv := swapmock.New(o.SwapOpts...)
 	ln := lightnode.NewContainer(o.Overlay)
 	s := debugapi.New(o.Overlay, o.PublicKey, o.PSSPublicKey, o.EthereumAddress, logging.New(ioutil.Discard, 0), nil, o.CORSAllowedOrigins)
-	s.Configure(o.P2P, o.Pingpong, topologyDriver, ln, o.Storer, o.Tags, acc, settlement, true, swapserv, chequebook, o.BatchStore)
+	s.Configure(o.P2P, o.Pingpong, topologyDriver, ln, o.Storer, o.Tags, acc, settlement, true, swapserv, chequebooks, o.BatchStore, o.Reputation, o.AddressBook, o.Bandwidth)
 	ts := httptest.NewServer(s)
 	t.Cleanup(ts.Close)
 
@@ -131,8 +153,8 @@ func TestServer_Configure(t *testing.T) {
 	}
 	topologyDriver := topologymock.NewTopologyDriver(o.TopologyOpts...)
 	acc := accountingmock.NewAccounting(o.AccountingOpts...)
-	settlement := swapmock.New(o.SettlementOpts...)
-	chequebook := chequebookmock.NewChequebook(o.ChequebookOpts...)
+	settlement := pseudosettlemock.New(o.SettlementOpts...)
+	chequebooks := newChequebooks(t, o)
 	swapserv := swapmock.New(o.SwapOpts...)
 	ln := lightnode.NewContainer(o.Overlay)
 	s := debugapi.New(o.Overlay, o.PublicKey, o.PSSPublicKey, o.EthereumAddress, logging.New(ioutil.Discard, 0), nil, nil)
@@ -162,13 +184,13 @@ func TestServer_Configure(t *testing.T) {
 			Overlay:  o.Overlay,
 			Underlay: make([]multiaddr.Multiaddr, 0),
 			//Ethereum:     o.EthereumAddress,
-			Xwc:          property.Address{},
+			Xwc:          xwcAddressOf(ethereumAddress),
 			PublicKey:    hex.EncodeToString(crypto.EncodeSecp256k1PublicKey(&o.PublicKey)),
 			PSSPublicKey: hex.EncodeToString(crypto.EncodeSecp256k1PublicKey(&o.PSSPublicKey)),
 		}),
 	)
 
-	s.Configure(o.P2P, o.Pingpong, topologyDriver, ln, o.Storer, o.Tags, acc, settlement, true, swapserv, chequebook, nil)
+	s.Configure(o.P2P, o.Pingpong, topologyDriver, ln, o.Storer, o.Tags, acc, settlement, true, swapserv, chequebooks, nil, nil, nil, nil)
 
 	testBasicRouter(t, client)
 	jsonhttptest.Request(t, client, http.MethodGet, "/readiness", http.StatusOK,
@@ -182,7 +204,7 @@ func TestServer_Configure(t *testing.T) {
 			Overlay:  o.Overlay,
 			Underlay: addresses,
 			//Ethereum:     o.EthereumAddress,
-			Xwc:          property.Address{},
+			Xwc:          xwcAddressOf(ethereumAddress),
 			PublicKey:    hex.EncodeToString(crypto.EncodeSecp256k1PublicKey(&o.PublicKey)),
 			PSSPublicKey: hex.EncodeToString(crypto.EncodeSecp256k1PublicKey(&o.PSSPublicKey)),
 		}),
@@ -211,3 +233,10 @@ func testBasicRouter(t *testing.T, client *http.Client) {
 		jsonhttptest.Request(t, client, http.MethodGet, path, http.StatusOK)
 	}
 }
+
+// xwcAddressOf returns the node address as reported by the addresses endpoint.
+func xwcAddressOf(a common.Address) xwcfmt.Address {
+	var addr xwcfmt.Address
+	addr.SetBytes(a[:])
+	return addr
+}
@@ -8,7 +8,15 @@ type (
 	StatusResponse                    = statusResponse
 	PingpongResponse                  = pingpongResponse
 	PeerConnectResponse               = peerConnectResponse
+	PeerScoreResponse                 = peerScoreResponse
 	PeersResponse                     = peersResponse
+	BlocklistResponse                 = blocklistResponse
+	BlocklistedPeer                   = blocklistedPeer
+	BlocklistedNetwork                = blocklistedNetwork
+	BlocklistRequest                  = blocklistRequest
+	AddressBookResponse               = addressBookResponse
+	AddressBookEntry                  = addressBookEntry
+	BandwidthResponse                 = bandwidthResponse
 	AddressesResponse                 = addressesResponse
 	WelcomeMessageRequest             = welcomeMessageRequest
 	WelcomeMessageResponse            = welcomeMessageResponse
@@ -16,8 +24,12 @@ type (
 	BalanceResponse                   = balanceResponse
 	SettlementResponse                = settlementResponse
 	SettlementsResponse               = settlementsResponse
+	TimeSettlementResponse            = timeSettlementResponse
+	TimeSettlementsResponse           = timeSettlementsResponse
 	ChequebookBalanceResponse         = chequebookBalanceResponse
 	ChequebookAddressResponse         = chequebookAddressResponse
+	ChequebookTokenResponse           = chequebookTokenResponse
+	ChequebookTokensResponse          = chequebookTokensResponse
 	ChequebookLastChequePeerResponse  = chequebookLastChequePeerResponse
 	ChequebookLastChequesResponse     = chequebookLastChequesResponse
 	ChequebookLastChequesPeerResponse = chequebookLastChequesPeerResponse
@@ -36,4 +48,5 @@ var (
 	ErrCantSettlements     = errCantSettlements
 	ErrChequebookBalance   = errChequebookBalance
 	ErrInvalidAddress      = errInvalidAddress
+	ErrUnsupportedToken    = errUnsupportedToken
 )
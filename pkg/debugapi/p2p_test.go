@@ -7,7 +7,6 @@ package debugapi_test
 import (
 	"encoding/hex"
 	"errors"
-	"github.com/penguintop/penguin/pkg/property"
 	"net/http"
 	"testing"
 
@@ -55,7 +54,7 @@ func TestAddresses(t *testing.T) {
 				Overlay:  overlay,
 				Underlay: addresses,
 				//Ethereum:     ethereumAddress,
-				Xwc:          property.Address{},
+				Xwc:          xwcAddressOf(ethereumAddress),
 				PublicKey:    hex.EncodeToString(crypto.EncodeSecp256k1PublicKey(&privateKey.PublicKey)),
 				PSSPublicKey: hex.EncodeToString(crypto.EncodeSecp256k1PublicKey(&pssPrivateKey.PublicKey)),
 			}),
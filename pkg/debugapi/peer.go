@@ -11,6 +11,7 @@ import (
 	"github.com/penguintop/penguin/pkg/jsonhttp"
 	"github.com/penguintop/penguin/pkg/p2p"
 	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/reputation"
 	"github.com/gorilla/mux"
 	"github.com/multiformats/go-multiaddr"
 )
@@ -73,15 +74,54 @@ func (s *Service) peersHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (s *Service) blocklistedPeersHandler(w http.ResponseWriter, r *http.Request) {
-	peers, err := s.p2p.BlocklistedPeers()
+type peerScoreResponse struct {
+	Address   penguin.Address                `json:"address"`
+	Score     float64                        `json:"score"`
+	Successes float64                        `json:"successes"`
+	Failures  float64                        `json:"failures"`
+	Latency   float64                        `json:"latency"` // in seconds
+	Protocols map[string]reputation.Counters `json:"protocols"`
+	Updated   int64                          `json:"updated,omitempty"`
+}
+
+func (s *Service) peerScoreHandler(w http.ResponseWriter, r *http.Request) {
+	addr := mux.Vars(r)["address"]
+	peer, err := penguin.ParseHexAddress(addr)
 	if err != nil {
-		s.logger.Debugf("Debug api: blocklisted peers: %v", err)
-		jsonhttp.InternalServerError(w, nil)
+		s.logger.Debugf("Debug api: peer score: parse peer address %s: %v", addr, err)
+		jsonhttp.BadRequest(w, "invalid peer address")
 		return
 	}
 
-	jsonhttp.OK(w, peersResponse{
-		Peers: peers,
+	if s.reputation == nil {
+		jsonhttp.NotImplemented(w, "peer reputation not available")
+		return
+	}
+
+	ps, err := s.reputation.PeerScore(peer)
+	if err != nil {
+		// peers without recorded events have a neutral score
+		if errors.Is(err, reputation.ErrNotFound) {
+			jsonhttp.OK(w, peerScoreResponse{
+				Address:   peer,
+				Score:     reputation.NeutralScore,
+				Protocols: map[string]reputation.Counters{},
+			})
+			return
+		}
+		s.logger.Debugf("Debug api: peer score %s: %v", addr, err)
+		s.logger.Errorf("Debug api: peer score %s", addr)
+		jsonhttp.InternalServerError(w, "peer score")
+		return
+	}
+
+	jsonhttp.OK(w, peerScoreResponse{
+		Address:   peer,
+		Score:     ps.Score,
+		Successes: ps.Successes,
+		Failures:  ps.Failures,
+		Latency:   ps.Latency.Seconds(),
+		Protocols: ps.Protocols,
+		Updated:   ps.Updated.Unix(),
 	})
 }
@@ -7,6 +7,7 @@ package debugapi_test
 import (
 	"context"
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"testing"
 
@@ -14,10 +15,13 @@ import (
 	"github.com/penguintop/penguin/pkg/debugapi"
 	"github.com/penguintop/penguin/pkg/jsonhttp"
 	"github.com/penguintop/penguin/pkg/jsonhttp/jsonhttptest"
+	"github.com/penguintop/penguin/pkg/logging"
 	"github.com/penguintop/penguin/pkg/p2p"
 	"github.com/penguintop/penguin/pkg/p2p/mock"
 	"github.com/penguintop/penguin/pkg/pen"
 	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/reputation"
+	mockstate "github.com/penguintop/penguin/pkg/statestore/mock"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
@@ -182,34 +186,58 @@ func TestPeer(t *testing.T) {
 	})
 }
 
-func TestBlocklistedPeers(t *testing.T) {
-	overlay := penguin.MustParseHexAddress("ca1e9f3938cc1425c6061b96ad9eb93e134dfe8734ad490164ef20af9d1cf59c")
-	testServer := newTestServer(t, testServerOptions{
-		P2P: mock.New(mock.WithBlocklistedPeersFunc(func() ([]p2p.Peer, error) {
-			return []p2p.Peer{{Address: overlay}}, nil
-		})),
+func TestPeerScore(t *testing.T) {
+	rep, err := reputation.New(mockstate.NewStateStore(), logging.New(ioutil.Discard, 0), reputation.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = rep.Close()
 	})
 
-	jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/blocklist", http.StatusOK,
-		jsonhttptest.WithExpectedJSONResponse(debugapi.PeersResponse{
-			Peers: []p2p.Peer{{Address: overlay}},
-		}),
-	)
-}
+	peer := penguin.MustParseHexAddress("ca1e9f3938cc1425c6061b96ad9eb93e134dfe8734ad490164ef20af9d1cf59c")
+	unknown := penguin.MustParseHexAddress("0100000000000000000000000000000000000000000000000000000000000000")
+	rep.Success(peer, reputation.ProtocolRetrieval, 0)
+	rep.Success(peer, reputation.ProtocolRetrieval, 0)
+	rep.Failure(peer, reputation.ProtocolPushSync)
 
-func TestBlocklistedPeersErr(t *testing.T) {
-	overlay := penguin.MustParseHexAddress("ca1e9f3938cc1425c6061b96ad9eb93e134dfe8734ad490164ef20af9d1cf59c")
 	testServer := newTestServer(t, testServerOptions{
-		P2P: mock.New(mock.WithBlocklistedPeersFunc(func() ([]p2p.Peer, error) {
-			return []p2p.Peer{{Address: overlay}}, errors.New("some error")
-		})),
+		P2P:        mock.New(),
+		Reputation: rep,
 	})
 
-	jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/blocklist", http.StatusInternalServerError,
-		jsonhttptest.WithExpectedJSONResponse(
-			jsonhttp.StatusResponse{
-				Code:    http.StatusInternalServerError,
-				Message: http.StatusText(http.StatusInternalServerError),
+	t.Run("ok", func(t *testing.T) {
+		var got debugapi.PeerScoreResponse
+		jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/peers/"+peer.String()+"/score", http.StatusOK,
+			jsonhttptest.WithUnmarshalJSONResponse(&got),
+		)
+		if !got.Address.Equal(peer) {
+			t.Fatalf("got address %s, want %s", got.Address, peer)
+		}
+		if got.Score <= reputation.NeutralScore {
+			t.Fatalf("got score %v, want more than %v", got.Score, reputation.NeutralScore)
+		}
+		if c := got.Protocols[reputation.ProtocolPushSync]; c.Failures == 0 {
+			t.Fatalf("got pushsync counters %+v", c)
+		}
+	})
+
+	t.Run("unknown peer", func(t *testing.T) {
+		jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/peers/"+unknown.String()+"/score", http.StatusOK,
+			jsonhttptest.WithExpectedJSONResponse(debugapi.PeerScoreResponse{
+				Address:   unknown,
+				Score:     reputation.NeutralScore,
+				Protocols: map[string]reputation.Counters{},
 			}),
-	)
+		)
+	})
+
+	t.Run("invalid address", func(t *testing.T) {
+		jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/peers/invalid-address/score", http.StatusBadRequest,
+			jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+				Code:    http.StatusBadRequest,
+				Message: "invalid peer address",
+			}),
+		)
+	})
 }
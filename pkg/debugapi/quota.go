@@ -0,0 +1,173 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debugapi
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/penguintop/penguin/pkg/jsonhttp"
+	"github.com/penguintop/penguin/pkg/localstore"
+)
+
+var (
+	errBadBatchID = errors.New("invalid batch id")
+	errBadQuota   = errors.New("invalid quota")
+)
+
+type batchQuotaStore interface {
+	SetBatchQuota(batchID []byte, quota localstore.BatchQuota) error
+	BatchUsage(batchID []byte) (*localstore.BatchUsage, error)
+	BatchUsages() ([]localstore.BatchUsage, error)
+}
+
+type batchUsageResponse struct {
+	BatchID string                 `json:"batchID"`
+	Stored  uint64                 `json:"stored"`
+	Pinned  uint64                 `json:"pinned"`
+	Quota   *localstore.BatchQuota `json:"quota,omitempty"`
+}
+
+type batchUsagesResponse struct {
+	Batches []batchUsageResponse `json:"batches"`
+}
+
+func newBatchUsageResponse(u *localstore.BatchUsage) batchUsageResponse {
+	return batchUsageResponse{
+		BatchID: hex.EncodeToString(u.BatchID),
+		Stored:  u.Stored,
+		Pinned:  u.Pinned,
+		Quota:   u.Quota,
+	}
+}
+
+// batchUsagesHandler returns the stored and pinned bytes
+// of all postage batches with their quotas.
+func (s *Service) batchUsagesHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.storer.(batchQuotaStore)
+	if !ok {
+		jsonhttp.NotImplemented(w, nil)
+		return
+	}
+
+	usages, err := store.BatchUsages()
+	if err != nil {
+		s.logger.Debugf("Debug api: batch usages: %v", err)
+		s.logger.Error("Debug api: batch usages failed")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	resp := batchUsagesResponse{Batches: make([]batchUsageResponse, 0, len(usages))}
+	for i := range usages {
+		resp.Batches = append(resp.Batches, newBatchUsageResponse(&usages[i]))
+	}
+	jsonhttp.OK(w, resp)
+}
+
+// batchUsageHandler returns the stored and pinned bytes
+// of the postage batch with its quota.
+func (s *Service) batchUsageHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.storer.(batchQuotaStore)
+	if !ok {
+		jsonhttp.NotImplemented(w, nil)
+		return
+	}
+	batchID, ok := s.parseQuotaBatchID(w, r)
+	if !ok {
+		return
+	}
+
+	usage, err := store.BatchUsage(batchID)
+	if err != nil {
+		s.logger.Debugf("Debug api: batch usage %x: %v", batchID, err)
+		s.logger.Error("Debug api: batch usage failed")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, newBatchUsageResponse(usage))
+}
+
+// setBatchQuotaHandler sets the stored and pinned bytes quota of the
+// postage batch from the query parameters. Omitted limits are not set.
+func (s *Service) setBatchQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.storer.(batchQuotaStore)
+	if !ok {
+		jsonhttp.NotImplemented(w, nil)
+		return
+	}
+	batchID, ok := s.parseQuotaBatchID(w, r)
+	if !ok {
+		return
+	}
+
+	var quota localstore.BatchQuota
+	for _, q := range []struct {
+		name  string
+		value *uint64
+	}{
+		{"stored", &quota.Stored},
+		{"pinned", &quota.Pinned},
+	} {
+		v := r.URL.Query().Get(q.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			s.logger.Debugf("Debug api: set batch quota: invalid %s quota %q", q.name, v)
+			jsonhttp.BadRequest(w, errBadQuota)
+			return
+		}
+		*q.value = n
+	}
+	if quota == (localstore.BatchQuota{}) {
+		s.logger.Debug("Debug api: set batch quota: no quota")
+		jsonhttp.BadRequest(w, errBadQuota)
+		return
+	}
+
+	if err := store.SetBatchQuota(batchID, quota); err != nil {
+		s.logger.Debugf("Debug api: set batch quota %x: %v", batchID, err)
+		s.logger.Error("Debug api: set batch quota failed")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, nil)
+}
+
+// removeBatchQuotaHandler removes the quota of the postage batch.
+func (s *Service) removeBatchQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.storer.(batchQuotaStore)
+	if !ok {
+		jsonhttp.NotImplemented(w, nil)
+		return
+	}
+	batchID, ok := s.parseQuotaBatchID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := store.SetBatchQuota(batchID, localstore.BatchQuota{}); err != nil {
+		s.logger.Debugf("Debug api: remove batch quota %x: %v", batchID, err)
+		s.logger.Error("Debug api: remove batch quota failed")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, nil)
+}
+
+func (s *Service) parseQuotaBatchID(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	batchID, err := hex.DecodeString(mux.Vars(r)["batch_id"])
+	if err != nil || len(batchID) != 32 {
+		s.logger.Debugf("Debug api: batch quota: invalid batch id %q", mux.Vars(r)["batch_id"])
+		jsonhttp.BadRequest(w, errBadBatchID)
+		return nil, false
+	}
+	return batchID, true
+}
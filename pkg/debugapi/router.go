@@ -94,14 +94,51 @@ func (s *Service) newRouter() *mux.Router {
 	router.Handle("/blocklist", jsonhttp.MethodHandler{
 		"GET": http.HandlerFunc(s.blocklistedPeersHandler),
 	})
+	router.Handle("/blocklist/{address:.+}", jsonhttp.MethodHandler{
+		"POST":   http.HandlerFunc(s.blocklistHandler),
+		"DELETE": http.HandlerFunc(s.unblocklistHandler),
+	})
+
+	router.Handle("/addressbook", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.addressBookHandler),
+	})
+	router.Handle("/addressbook/{address}", jsonhttp.MethodHandler{
+		"GET":    http.HandlerFunc(s.addressBookEntryHandler),
+		"DELETE": http.HandlerFunc(s.addressBookRemoveHandler),
+	})
+
+	router.Handle("/p2p/bandwidth", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.bandwidthHandler),
+	})
 
 	router.Handle("/peers/{address}", jsonhttp.MethodHandler{
 		"DELETE": http.HandlerFunc(s.peerDisconnectHandler),
 	})
+	router.Handle("/peers/{address}/score", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.peerScoreHandler),
+	})
+	router.Handle("/chunks", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.listChunksHandler),
+	})
 	router.Handle("/chunks/{address}", jsonhttp.MethodHandler{
 		"GET":    http.HandlerFunc(s.hasChunkHandler),
 		"DELETE": http.HandlerFunc(s.removeChunk),
 	})
+	router.Handle("/chunks/{address}/info", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.chunkInfoHandler),
+	})
+	router.Handle("/db/check", jsonhttp.MethodHandler{
+		"GET":  http.HandlerFunc(s.dbCheckStatusHandler),
+		"POST": http.HandlerFunc(s.dbCheckHandler),
+	})
+	router.Handle("/quotas", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.batchUsagesHandler),
+	})
+	router.Handle("/quotas/{batch_id}", jsonhttp.MethodHandler{
+		"GET":    http.HandlerFunc(s.batchUsageHandler),
+		"PUT":    http.HandlerFunc(s.setBatchQuotaHandler),
+		"DELETE": http.HandlerFunc(s.removeBatchQuotaHandler),
+	})
 	router.Handle("/topology", jsonhttp.MethodHandler{
 		"GET": http.HandlerFunc(s.topologyHandler),
 	})
@@ -149,6 +186,10 @@ func (s *Service) newRouter() *mux.Router {
 			"GET": http.HandlerFunc(s.chequebookAddressHandler),
 		})
 
+		router.Handle("/chequebook/tokens", jsonhttp.MethodHandler{
+			"GET": http.HandlerFunc(s.chequebookTokensHandler),
+		})
+
 		router.Handle("/chequebook/deposit", jsonhttp.MethodHandler{
 			"POST": http.HandlerFunc(s.chequebookDepositHandler),
 		})
@@ -32,6 +32,22 @@ type settlementsResponse struct {
 	Settlements             []settlementResponse `json:"settlements"`
 }
 
+type timeSettlementResponse struct {
+	Peer               string   `json:"peer"`
+	SettlementReceived *big.Int `json:"received"`
+	SettlementSent     *big.Int `json:"sent"`
+	RefreshRate        *big.Int `json:"refreshRate"`
+	LastRefresh        int64    `json:"lastRefresh"`
+	Refused            *big.Int `json:"refused"`
+}
+
+type timeSettlementsResponse struct {
+	TotalSettlementReceived *big.Int                 `json:"totalReceived"`
+	TotalSettlementSent     *big.Int                 `json:"totalSent"`
+	TotalRefused            *big.Int                 `json:"totalRefused"`
+	Settlements             []timeSettlementResponse `json:"settlements"`
+}
+
 func (s *Service) settlementsHandler(w http.ResponseWriter, r *http.Request) {
 
 	settlementsSent, err := s.swap.SettlementsSent()
@@ -160,42 +176,63 @@ func (s *Service) settlementsHandlerPseudosettle(w http.ResponseWriter, r *http.
 		s.logger.Error("Debug api: can not get received settlements")
 		return
 	}
+	allowances, err := s.pseudosettle.PeerAllowances()
+	if err != nil {
+		jsonhttp.InternalServerError(w, errCantSettlements)
+		s.logger.Debugf("Debug api: settlement allowances: %v", err)
+		s.logger.Error("Debug api: can not get settlement allowances")
+		return
+	}
 
 	totalReceived := big.NewInt(0)
 	totalSent := big.NewInt(0)
+	totalRefused := big.NewInt(0)
 
-	settlementResponses := make(map[string]settlementResponse)
+	settlementResponses := make(map[string]timeSettlementResponse)
 
-	for a, b := range settlementsSent {
-		settlementResponses[a] = settlementResponse{
-			Peer:               a,
-			SettlementSent:     b,
+	peerResponse := func(peer string) timeSettlementResponse {
+		if t, ok := settlementResponses[peer]; ok {
+			return t
+		}
+		return timeSettlementResponse{
+			Peer:               peer,
+			SettlementSent:     big.NewInt(0),
 			SettlementReceived: big.NewInt(0),
+			RefreshRate:        big.NewInt(0),
+			Refused:            big.NewInt(0),
 		}
+	}
+
+	for a, b := range settlementsSent {
+		t := peerResponse(a)
+		t.SettlementSent = b
+		settlementResponses[a] = t
 		totalSent.Add(b, totalSent)
 	}
 
 	for a, b := range settlementsReceived {
-		if _, ok := settlementResponses[a]; ok {
-			t := settlementResponses[a]
-			t.SettlementReceived = b
-			settlementResponses[a] = t
-		} else {
-			settlementResponses[a] = settlementResponse{
-				Peer:               a,
-				SettlementSent:     big.NewInt(0),
-				SettlementReceived: b,
-			}
-		}
+		t := peerResponse(a)
+		t.SettlementReceived = b
+		settlementResponses[a] = t
 		totalReceived.Add(b, totalReceived)
 	}
 
-	settlementResponsesArray := make([]settlementResponse, len(settlementResponses))
+	for _, allowance := range allowances {
+		a := allowance.Peer.String()
+		t := peerResponse(a)
+		t.RefreshRate = allowance.RefreshRate
+		t.LastRefresh = allowance.LastRefresh
+		t.Refused = allowance.TotalRefused
+		settlementResponses[a] = t
+		totalRefused.Add(allowance.TotalRefused, totalRefused)
+	}
+
+	settlementResponsesArray := make([]timeSettlementResponse, len(settlementResponses))
 	i := 0
 	for k := range settlementResponses {
 		settlementResponsesArray[i] = settlementResponses[k]
 		i++
 	}
 
-	jsonhttp.OK(w, settlementsResponse{TotalSettlementReceived: totalReceived, TotalSettlementSent: totalSent, Settlements: settlementResponsesArray})
+	jsonhttp.OK(w, timeSettlementsResponse{TotalSettlementReceived: totalReceived, TotalSettlementSent: totalSent, TotalRefused: totalRefused, Settlements: settlementResponsesArray})
 }
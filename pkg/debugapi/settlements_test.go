@@ -15,6 +15,8 @@ import (
 	"github.com/penguintop/penguin/pkg/jsonhttp"
 	"github.com/penguintop/penguin/pkg/jsonhttp/jsonhttptest"
 	"github.com/penguintop/penguin/pkg/settlement"
+	"github.com/penguintop/penguin/pkg/settlement/pseudosettle"
+	pseudosettlemock "github.com/penguintop/penguin/pkg/settlement/pseudosettle/mock"
 	"github.com/penguintop/penguin/pkg/settlement/swap/mock"
 	"github.com/penguintop/penguin/pkg/penguin"
 )
@@ -188,6 +190,77 @@ func TestSettlementsInvalidAddress(t *testing.T) {
 	)
 }
 
+func TestTimeSettlements(t *testing.T) {
+	peer := penguin.MustParseHexAddress("dead")
+
+	settlementsSentFunc := func() (ret map[string]*big.Int, err error) {
+		ret = make(map[string]*big.Int)
+		ret[peer.String()] = big.NewInt(10000)
+		return ret, err
+	}
+
+	settlementsRecvFunc := func() (ret map[string]*big.Int, err error) {
+		ret = make(map[string]*big.Int)
+		ret[peer.String()] = big.NewInt(5000)
+		return ret, err
+	}
+
+	peerAllowancesFunc := func() ([]pseudosettle.PeerAllowance, error) {
+		return []pseudosettle.PeerAllowance{
+			{
+				Peer:         peer,
+				RefreshRate:  big.NewInt(100),
+				LastRefresh:  1617000000,
+				TotalRefused: big.NewInt(300),
+			},
+		}, nil
+	}
+
+	testServer := newTestServer(t, testServerOptions{
+		SettlementOpts: []pseudosettlemock.Option{
+			pseudosettlemock.WithSettlementsSentFunc(settlementsSentFunc),
+			pseudosettlemock.WithSettlementsRecvFunc(settlementsRecvFunc),
+			pseudosettlemock.WithPeerAllowancesFunc(peerAllowancesFunc),
+		},
+	})
+
+	jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/timesettlements", http.StatusOK,
+		jsonhttptest.WithExpectedJSONResponse(debugapi.TimeSettlementsResponse{
+			TotalSettlementReceived: big.NewInt(5000),
+			TotalSettlementSent:     big.NewInt(10000),
+			TotalRefused:            big.NewInt(300),
+			Settlements: []debugapi.TimeSettlementResponse{
+				{
+					Peer:               peer.String(),
+					SettlementReceived: big.NewInt(5000),
+					SettlementSent:     big.NewInt(10000),
+					RefreshRate:        big.NewInt(100),
+					LastRefresh:        1617000000,
+					Refused:            big.NewInt(300),
+				},
+			},
+		}),
+	)
+}
+
+func TestTimeSettlementsError(t *testing.T) {
+	wantErr := errors.New("New errors")
+	peerAllowancesFunc := func() ([]pseudosettle.PeerAllowance, error) {
+		return nil, wantErr
+	}
+
+	testServer := newTestServer(t, testServerOptions{
+		SettlementOpts: []pseudosettlemock.Option{pseudosettlemock.WithPeerAllowancesFunc(peerAllowancesFunc)},
+	})
+
+	jsonhttptest.Request(t, testServer.Client, http.MethodGet, "/timesettlements", http.StatusInternalServerError,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: debugapi.ErrCantSettlements,
+			Code:    http.StatusInternalServerError,
+		}),
+	)
+}
+
 func equalSettlements(a, b *debugapi.SettlementsResponse) bool {
 	var state bool
 
@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/penguintop/penguin/pkg/debugapi"
@@ -53,7 +54,7 @@ func TestSetWelcomeMessage(t *testing.T) {
 		{
 			desc:       "error - request entity too large",
 			wantFail:   true,
-			message:    ``, //
+			message:    strings.Repeat("a", 600),
 			wantStatus: http.StatusRequestEntityTooLarge,
 		},
 	}
@@ -4,5 +4,42 @@
 
 package hive
 
+import (
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/penguintop/penguin/pkg/crypto"
+	"github.com/penguintop/penguin/pkg/hive/pb"
+	"github.com/penguintop/penguin/pkg/penguin"
+)
+
 var MaxBatchSize = maxBatchSize
 var LimitBurst = limitBurst
+
+// NewRecord returns the signed record of the overlay and underlay addresses.
+func NewRecord(signer crypto.Signer, overlay penguin.Address, underlay ma.Multiaddr, sequence uint64, expiry int64, networkID uint64) (*pb.PeerRecord, error) {
+	r, err := newRecord(signer, overlay, underlay, sequence, expiry, networkID)
+	if err != nil {
+		return nil, err
+	}
+	return r.toProto(), nil
+}
+
+func SetRecordPruneInterval(d time.Duration) (reset func()) {
+	current := recordPruneInterval
+	recordPruneInterval = d
+	return func() { recordPruneInterval = current }
+}
+
+// Records returns the number of held records.
+func (s *Service) Records() int {
+	return s.records.len()
+}
+
+// SentRecords returns the number of record sequences known to the peer.
+func (s *Service) SentRecords(peer penguin.Address) int {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	return len(s.sentSeqs[peer.ByteString()])
+}
@@ -8,9 +8,16 @@
 // about all peers by default and performs no specific
 // prioritization about which peers are gossipped to
 // others.
+//
+// The version 2 of the protocol gossips signed peer records instead
+// of bare addresses: every peer signs its overlay and underlay addresses
+// with a sequence number and an expiry, so that the receivers can drop
+// stale records and skip the gossip they have already seen. The
+// version 1 is kept for the peers which do not support records.
 package hive
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -18,20 +25,25 @@ import (
 	"time"
 
 	"github.com/penguintop/penguin/pkg/addressbook"
+	"github.com/penguintop/penguin/pkg/crypto"
 	"github.com/penguintop/penguin/pkg/hive/pb"
 	"github.com/penguintop/penguin/pkg/logging"
 	"github.com/penguintop/penguin/pkg/p2p"
 	"github.com/penguintop/penguin/pkg/p2p/protobuf"
 	"github.com/penguintop/penguin/pkg/pen"
+	"github.com/penguintop/penguin/pkg/reputation"
     "github.com/penguintop/penguin/pkg/penguin"
 
+	ma "github.com/multiformats/go-multiaddr"
 	"golang.org/x/time/rate"
 )
 
 const (
-	protocolName    = "hive"
-	protocolVersion = "1.0.0"
-	peersStreamName = "peers"
+	protocolName      = "hive"
+	protocolVersion   = "1.0.0"
+	protocolVersionV2 = "2.0.0"
+	peersStreamName   = "peers"
+	recordsStreamName = "records"
 	messageTimeout  = 1 * time.Minute // maximum allowed time for a message to be read or written.
 	maxBatchSize    = 30
 )
@@ -40,6 +52,9 @@ var (
 	ErrRateLimitExceeded = errors.New("rate limit exceeded")
 	limitBurst           = 4 * int(penguin.MaxBins)
 	limitRate            = rate.Every(time.Minute)
+	// recordPruneInterval is the period of removing the records and the
+	// sent record sequences of the peers which left the address book.
+	recordPruneInterval = 10 * time.Minute
 )
 
 type Service struct {
@@ -51,9 +66,31 @@ type Service struct {
 	metrics         metrics
 	limiter         map[string]*rate.Limiter
 	limiterLock     sync.Mutex
+	reputation      reputation.Recorder
+
+	overlay   penguin.Address
+	signer    crypto.Signer
+	underlays AdvertisedUnderlayer
+	records   *recordStore
+	self      map[string]record // records of this node by the underlay, guarded by selfMu
+	selfMu    sync.Mutex
+	peersMu   sync.Mutex
+	v1Peers   map[string]struct{}          // peers which do not support records
+	sentSeqs  map[string]map[string]uint64 // record sequences known to the peers
+	pruneMu   sync.Mutex
+	lastPrune time.Time
 }
 
-func New(streamer p2p.Streamer, addressbook addressbook.GetPutter, networkID uint64, logger logging.Logger) *Service {
+// AdvertisedUnderlayer returns the underlay address of this node which was
+// advertised to the connected peer. The records of this node are signed for
+// that underlay, so that they match the address the peer gossips.
+type AdvertisedUnderlayer interface {
+	AdvertisedUnderlay(peer penguin.Address) (ma.Multiaddr, bool)
+}
+
+// New creates the hive service. The records of the node are signed with
+// the signer; if it is nil, the node only relays the records of others.
+func New(streamer p2p.Streamer, addressbook addressbook.GetPutter, networkID uint64, overlay penguin.Address, signer crypto.Signer, logger logging.Logger) *Service {
 	return &Service{
 		streamer:    streamer,
 		logger:      logger,
@@ -61,6 +98,13 @@ func New(streamer p2p.Streamer, addressbook addressbook.GetPutter, networkID uin
 		networkID:   networkID,
 		metrics:     newMetrics(),
 		limiter:     make(map[string]*rate.Limiter),
+		reputation:  reputation.Noop,
+		overlay:     overlay,
+		signer:      signer,
+		records:     newRecordStore(),
+		self:        make(map[string]record),
+		v1Peers:     make(map[string]struct{}),
+		sentSeqs:    make(map[string]map[string]uint64),
 	}
 }
 
@@ -79,11 +123,37 @@ func (s *Service) Protocol() p2p.ProtocolSpec {
 	}
 }
 
+// ProtocolV2 returns the specification of the hive protocol
+// version which gossips signed peer records.
+func (s *Service) ProtocolV2() p2p.ProtocolSpec {
+	return p2p.ProtocolSpec{
+		Name:    protocolName,
+		Version: protocolVersionV2,
+		StreamSpecs: []p2p.StreamSpec{
+			{
+				Name:    recordsStreamName,
+				Handler: s.recordsHandler,
+			},
+		},
+		DisconnectIn:  s.disconnect,
+		DisconnectOut: s.disconnect,
+	}
+}
+
 func (s *Service) BroadcastPeers(ctx context.Context, addressee penguin.Address, peers ...penguin.Address) error {
 	max := maxBatchSize
 	s.metrics.BroadcastPeers.Inc()
 	s.metrics.BroadcastPeersPeers.Add(float64(len(peers)))
 
+	if !s.isV1Peer(addressee) {
+		err := s.broadcastRecords(ctx, addressee, peers)
+		var incompatible *p2p.IncompatibleStreamError
+		if !errors.As(err, &incompatible) {
+			return err
+		}
+		s.setV1Peer(addressee)
+	}
+
 	for len(peers) > 0 {
 		if max > len(peers) {
 			max = len(peers)
@@ -102,8 +172,28 @@ func (s *Service) SetAddPeersHandler(h func(addr ...penguin.Address)) {
 	s.addPeersHandler = h
 }
 
+// SetReputation sets the recorder of the peer interaction outcomes.
+func (s *Service) SetReputation(r reputation.Recorder) {
+	s.reputation = r
+}
+
+// SetAdvertisedUnderlayer sets the source of the underlay addresses which
+// the records of this node are signed for. Without it the node only relays
+// the records of others.
+func (s *Service) SetAdvertisedUnderlayer(u AdvertisedUnderlayer) {
+	s.underlays = u
+}
+
 func (s *Service) sendPeers(ctx context.Context, peer penguin.Address, peers []penguin.Address) (err error) {
 	s.metrics.BroadcastPeersSends.Inc()
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			s.reputation.Failure(peer, reputation.ProtocolHive)
+		} else {
+			s.reputation.Success(peer, reputation.ProtocolHive, time.Since(start))
+		}
+	}()
 	stream, err := s.streamer.NewStream(ctx, peer, nil, protocolName, protocolVersion, peersStreamName)
 	if err != nil {
 		return fmt.Errorf("new stream: %w", err)
@@ -149,6 +239,7 @@ func (s *Service) peersHandler(ctx context.Context, peer p2p.Peer, stream p2p.St
 	var peersReq pb.Peers
 	if err := r.ReadMsgWithContext(ctx, &peersReq); err != nil {
 		_ = stream.Reset()
+		s.reputation.Failure(peer.Address, reputation.ProtocolHive)
 		return fmt.Errorf("read requestPeers message: %w", err)
 	}
 
@@ -156,6 +247,7 @@ func (s *Service) peersHandler(ctx context.Context, peer p2p.Peer, stream p2p.St
 
 	if err := s.rateLimitPeer(peer.Address, len(peersReq.Peers)); err != nil {
 		_ = stream.Reset()
+		s.reputation.Failure(peer.Address, reputation.ProtocolHive)
 		return err
 	}
 
@@ -164,23 +256,36 @@ func (s *Service) peersHandler(ctx context.Context, peer p2p.Peer, stream p2p.St
 	// but we still want to handle not closed stream from the other side to avoid zombie stream
 	go stream.FullClose()
 
-	var peers []penguin.Address
+	var (
+		peers   []penguin.Address
+		invalid bool
+	)
 	for _, newPeer := range peersReq.Peers {
 		penAddress, err := pen.ParseAddress(newPeer.Underlay, newPeer.Overlay, newPeer.Signature, s.networkID)
 		if err != nil {
 			s.logger.Warningf("skipping peer in response %s: %v", newPeer.String(), err)
+			invalid = true
 			continue
 		}
 
-		err = s.addressBook.Put(penAddress.Overlay, *penAddress)
+		err = s.addressBook.PutWithSource(penAddress.Overlay, *penAddress, addressbook.SourceHive)
 		if err != nil {
-			s.logger.Warningf("skipping peer in response %s: %v", newPeer.String(), err)
+			if !errors.Is(err, addressbook.ErrPruned) {
+				s.logger.Warningf("skipping peer in response %s: %v", newPeer.String(), err)
+			}
 			continue
 		}
 
 		peers = append(peers, penAddress.Overlay)
 	}
 
+	// gossiping invalid peer records is counted as a failure
+	if invalid {
+		s.reputation.Failure(peer.Address, reputation.ProtocolHive)
+	} else {
+		s.reputation.Success(peer.Address, reputation.ProtocolHive, 0)
+	}
+
 	if s.addPeersHandler != nil {
 		s.addPeersHandler(peers...)
 	}
@@ -188,6 +293,366 @@ func (s *Service) peersHandler(ctx context.Context, peer p2p.Peer, stream p2p.St
 	return nil
 }
 
+// broadcastRecords sends the peers with their records to the addressee,
+// together with the record of this node. The records which the addressee
+// already knows, because they were exchanged before, are not sent again.
+func (s *Service) broadcastRecords(ctx context.Context, addressee penguin.Address, peers []penguin.Address) error {
+	now := time.Now()
+	s.pruneRecords(now)
+
+	var self *pb.PeerRecord
+	if r, ok := s.ownRecord(addressee, now); ok && !s.isSent(addressee, s.overlay, r.sequence) {
+		self = r.toProto()
+	}
+
+	var entries []*pb.SignedPeer
+	for _, p := range peers {
+		addr, err := s.addressBook.Get(p)
+		if err != nil {
+			if err == addressbook.ErrNotFound {
+				s.logger.Debugf("hive broadcast records: peer not found in the addressbook. Skipping peer %s", p)
+				continue
+			}
+			return err
+		}
+
+		// the record is gossiped only with the address it is signed for
+		var sequence uint64
+		r, hasRecord := s.records.get(p, now)
+		if hasRecord && !bytes.Equal(r.underlay, addr.Underlay.Bytes()) {
+			hasRecord = false
+		}
+		if hasRecord {
+			sequence = r.sequence
+		}
+		if s.isSent(addressee, p, sequence) {
+			s.metrics.DuplicateRecords.Inc()
+			continue
+		}
+
+		entry := &pb.SignedPeer{
+			Address: &pb.PenAddress{
+				Overlay:   addr.Overlay.Bytes(),
+				Underlay:  addr.Underlay.Bytes(),
+				Signature: addr.Signature,
+			},
+		}
+		if hasRecord {
+			entry.Record = r.toProto()
+		}
+		entries = append(entries, entry)
+	}
+
+	for self != nil || len(entries) > 0 {
+		max := maxBatchSize
+		if max > len(entries) {
+			max = len(entries)
+		}
+		msg := &pb.PeerRecords{
+			Record: self,
+			Peers:  entries[:max],
+		}
+		if err := s.sendRecords(ctx, addressee, msg); err != nil {
+			return err
+		}
+
+		if self != nil {
+			s.setSent(addressee, s.overlay, self.Sequence)
+		}
+		for _, e := range msg.Peers {
+			var sequence uint64
+			if e.Record != nil {
+				sequence = e.Record.Sequence
+			}
+			s.setSent(addressee, penguin.NewAddress(e.Address.Overlay), sequence)
+		}
+
+		self = nil
+		entries = entries[max:]
+	}
+
+	return nil
+}
+
+func (s *Service) sendRecords(ctx context.Context, peer penguin.Address, msg *pb.PeerRecords) (err error) {
+	stream, err := s.streamer.NewStream(ctx, peer, nil, protocolName, protocolVersionV2, recordsStreamName)
+	if err != nil {
+		var incompatible *p2p.IncompatibleStreamError
+		if !errors.As(err, &incompatible) {
+			s.reputation.Failure(peer, reputation.ProtocolHive)
+		}
+		return fmt.Errorf("new stream: %w", err)
+	}
+
+	s.metrics.BroadcastPeersSends.Inc()
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			_ = stream.Reset()
+			s.reputation.Failure(peer, reputation.ProtocolHive)
+		} else {
+			_ = stream.FullClose()
+			s.reputation.Success(peer, reputation.ProtocolHive, time.Since(start))
+		}
+	}()
+
+	w, _ := protobuf.NewWriterAndReader(stream)
+	if err := w.WriteMsgWithContext(ctx, msg); err != nil {
+		return fmt.Errorf("write PeerRecords message: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Service) recordsHandler(ctx context.Context, peer p2p.Peer, stream p2p.Stream) error {
+	s.metrics.RecordsHandler.Inc()
+	_, r := protobuf.NewWriterAndReader(stream)
+	ctx, cancel := context.WithTimeout(ctx, messageTimeout)
+	defer cancel()
+	var msg pb.PeerRecords
+	if err := r.ReadMsgWithContext(ctx, &msg); err != nil {
+		_ = stream.Reset()
+		s.reputation.Failure(peer.Address, reputation.ProtocolHive)
+		return fmt.Errorf("read PeerRecords message: %w", err)
+	}
+
+	s.metrics.PeersHandlerPeers.Add(float64(len(msg.Peers)))
+
+	if err := s.rateLimitPeer(peer.Address, len(msg.Peers)); err != nil {
+		_ = stream.Reset()
+		s.reputation.Failure(peer.Address, reputation.ProtocolHive)
+		return err
+	}
+
+	// close the stream before processing in order to unblock the sending side
+	go stream.FullClose()
+
+	var (
+		now     = time.Now()
+		peers   []penguin.Address
+		invalid bool
+	)
+
+	if msg.Record != nil {
+		r, err := parseRecord(msg.Record, s.networkID, now)
+		switch {
+		case err != nil:
+			s.logger.Debugf("hive: invalid record of peer %s: %v", peer.Address, err)
+			s.metrics.InvalidRecords.Inc()
+			invalid = true
+		case !r.overlay.Equal(peer.Address):
+			s.logger.Debugf("hive: record of peer %s signed for %s", peer.Address, r.overlay)
+			s.metrics.InvalidRecords.Inc()
+			invalid = true
+		case !s.isKnownUnderlay(peer.Address, r.underlay):
+			// the record does not match the address from the handshake,
+			// which is gossiped to the other peers
+			s.logger.Debugf("hive: record of peer %s signed for another underlay", peer.Address)
+			s.metrics.StaleRecords.Inc()
+		default:
+			s.records.add(r, now)
+			s.setSent(peer.Address, peer.Address, r.sequence)
+		}
+	}
+
+	for _, newPeer := range msg.Peers {
+		if newPeer.Address == nil {
+			s.metrics.InvalidRecords.Inc()
+			invalid = true
+			continue
+		}
+		penAddress, err := pen.ParseAddress(newPeer.Address.Underlay, newPeer.Address.Overlay, newPeer.Address.Signature, s.networkID)
+		if err != nil {
+			s.logger.Warningf("skipping peer in response %s: %v", newPeer.String(), err)
+			s.metrics.InvalidRecords.Inc()
+			invalid = true
+			continue
+		}
+
+		var sequence uint64
+		if newPeer.Record == nil {
+			// peers without records are accepted for the compatibility with
+			// the nodes which do not sign them, unless a record is held
+			if _, ok := s.records.get(penAddress.Overlay, now); ok {
+				s.metrics.DuplicateRecords.Inc()
+				continue
+			}
+		} else {
+			r, err := parseRecord(newPeer.Record, s.networkID, now)
+			if errors.Is(err, ErrRecordExpired) {
+				s.metrics.StaleRecords.Inc()
+				continue
+			}
+			if err != nil || !r.overlay.Equal(penAddress.Overlay) || !bytes.Equal(r.underlay, penAddress.Underlay.Bytes()) {
+				s.logger.Warningf("skipping peer in response %s: %v", newPeer.String(), ErrInvalidRecord)
+				s.metrics.InvalidRecords.Inc()
+				invalid = true
+				continue
+			}
+
+			sequence = r.sequence
+			switch s.records.add(r, now) {
+			case recordStale:
+				s.metrics.StaleRecords.Inc()
+				continue
+			case recordDuplicate:
+				s.metrics.DuplicateRecords.Inc()
+				s.setSent(peer.Address, penAddress.Overlay, sequence)
+				continue
+			}
+		}
+
+		// the sender knows the record, there is no need to gossip it back
+		s.setSent(peer.Address, penAddress.Overlay, sequence)
+
+		if err := s.addressBook.PutWithSource(penAddress.Overlay, *penAddress, addressbook.SourceHive); err != nil {
+			if !errors.Is(err, addressbook.ErrPruned) {
+				s.logger.Warningf("skipping peer in response %s: %v", newPeer.String(), err)
+			}
+			continue
+		}
+
+		peers = append(peers, penAddress.Overlay)
+	}
+
+	// gossiping invalid peer records is counted as a failure
+	if invalid {
+		s.reputation.Failure(peer.Address, reputation.ProtocolHive)
+	} else {
+		s.reputation.Success(peer.Address, reputation.ProtocolHive, 0)
+	}
+
+	if s.addPeersHandler != nil && len(peers) > 0 {
+		s.addPeersHandler(peers...)
+	}
+
+	return nil
+}
+
+// ownRecord returns the record of this node for the underlay advertised
+// to the peer, signing a new one when the current record is past the half
+// of its validity.
+func (s *Service) ownRecord(peer penguin.Address, now time.Time) (record, bool) {
+	if s.signer == nil || s.underlays == nil {
+		return record{}, false
+	}
+	underlay, ok := s.underlays.AdvertisedUnderlay(peer)
+	if !ok {
+		return record{}, false
+	}
+
+	s.selfMu.Lock()
+	defer s.selfMu.Unlock()
+
+	key := string(underlay.Bytes())
+	if r, ok := s.self[key]; ok && time.Unix(r.expiry, 0).Sub(now) > recordTTL/2 {
+		return r, true
+	}
+
+	// the timestamp is used as the sequence number,
+	// so that it increases across the node restarts
+	r, err := newRecord(s.signer, s.overlay, underlay, uint64(now.UnixNano()), now.Add(recordTTL).Unix(), s.networkID)
+	if err != nil {
+		s.logger.Errorf("hive: sign peer record: %v", err)
+		return record{}, false
+	}
+	for k, held := range s.self {
+		if held.expired(now) {
+			delete(s.self, k)
+		}
+	}
+	s.self[key] = r
+	return r, true
+}
+
+// isKnownUnderlay reports whether the underlay matches the address of
+// the peer in the address book, if the peer is there.
+func (s *Service) isKnownUnderlay(peer penguin.Address, underlay []byte) bool {
+	addr, err := s.addressBook.Get(peer)
+	if err != nil {
+		return true
+	}
+	return bytes.Equal(addr.Underlay.Bytes(), underlay)
+}
+
+// pruneRecords periodically removes the records and the sent record
+// sequences of the peers which are no longer in the address book.
+func (s *Service) pruneRecords(now time.Time) {
+	s.pruneMu.Lock()
+	if now.Sub(s.lastPrune) < recordPruneInterval {
+		s.pruneMu.Unlock()
+		return
+	}
+	s.lastPrune = now
+	s.pruneMu.Unlock()
+
+	known := make(map[string]bool)
+	isKnown := func(overlay penguin.Address) bool {
+		if overlay.Equal(s.overlay) {
+			return true
+		}
+		ok, checked := known[overlay.ByteString()]
+		if !checked {
+			_, err := s.addressBook.Get(overlay)
+			ok = err == nil
+			known[overlay.ByteString()] = ok
+		}
+		return ok
+	}
+
+	s.records.prune(now, isKnown)
+
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	for _, sent := range s.sentSeqs {
+		for key := range sent {
+			if !isKnown(penguin.NewAddress([]byte(key))) {
+				delete(sent, key)
+			}
+		}
+	}
+}
+
+func (s *Service) isV1Peer(peer penguin.Address) bool {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	_, ok := s.v1Peers[peer.ByteString()]
+	return ok
+}
+
+func (s *Service) setV1Peer(peer penguin.Address) {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	s.v1Peers[peer.ByteString()] = struct{}{}
+}
+
+// isSent reports whether the peer already knows the record
+// of the overlay address with the sequence number.
+func (s *Service) isSent(peer, overlay penguin.Address, sequence uint64) bool {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	seq, ok := s.sentSeqs[peer.ByteString()][overlay.ByteString()]
+	return ok && seq >= sequence
+}
+
+func (s *Service) setSent(peer, overlay penguin.Address, sequence uint64) {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	sent, ok := s.sentSeqs[peer.ByteString()]
+	if !ok {
+		sent = make(map[string]uint64)
+		s.sentSeqs[peer.ByteString()] = sent
+	}
+	if seq, ok := sent[overlay.ByteString()]; !ok || seq < sequence {
+		sent[overlay.ByteString()] = sequence
+	}
+}
+
 func (s *Service) rateLimitPeer(peer penguin.Address, count int) error {
 
 	s.limiterLock.Lock()
@@ -214,5 +679,10 @@ func (s *Service) disconnect(peer p2p.Peer) error {
 
 	delete(s.limiter, peer.Address.String())
 
+	s.peersMu.Lock()
+	delete(s.v1Peers, peer.Address.ByteString())
+	delete(s.sentSeqs, peer.Address.ByteString())
+	s.peersMu.Unlock()
+
 	return nil
 }
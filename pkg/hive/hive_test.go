@@ -41,7 +41,7 @@ func TestHandlerRateLimit(t *testing.T) {
 	addressbookclean := ab.New(mock.NewStateStore())
 
 	// create a hive server that handles the incoming stream
-	server := hive.New(nil, addressbookclean, networkID, logger)
+	server := hive.New(nil, addressbookclean, networkID, penguin.ZeroAddress, nil, logger)
 
 	serverAddress := test.RandomAddress()
 
@@ -80,7 +80,7 @@ func TestHandlerRateLimit(t *testing.T) {
 	}
 
 	// create a hive client that will do broadcast
-	client := hive.New(serverRecorder, addressbook, networkID, logger)
+	client := hive.New(serverRecorder, addressbook, networkID, penguin.ZeroAddress, nil, logger)
 	err := client.BroadcastPeers(context.Background(), serverAddress, peers...)
 	if err != nil {
 		t.Fatal(err)
@@ -197,7 +197,7 @@ func TestBroadcastPeers(t *testing.T) {
 			addressbookclean := ab.New(mock.NewStateStore())
 
 			// create a hive server that handles the incoming stream
-			server := hive.New(nil, addressbookclean, networkID, logger)
+			server := hive.New(nil, addressbookclean, networkID, penguin.ZeroAddress, nil, logger)
 
 			// setup the stream recorder to record stream data
 			recorder := streamtest.New(
@@ -205,7 +205,7 @@ func TestBroadcastPeers(t *testing.T) {
 			)
 
 			// create a hive client that will do broadcast
-			client := hive.New(recorder, addressbook, networkID, logger)
+			client := hive.New(recorder, addressbook, networkID, penguin.ZeroAddress, nil, logger)
 			if err := client.BroadcastPeers(context.Background(), tc.addresee, tc.peers...); err != nil {
 				t.Fatal(err)
 			}
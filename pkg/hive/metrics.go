@@ -16,6 +16,11 @@ type metrics struct {
 
 	PeersHandler      prometheus.Counter
 	PeersHandlerPeers prometheus.Counter
+
+	RecordsHandler   prometheus.Counter
+	StaleRecords     prometheus.Counter
+	DuplicateRecords prometheus.Counter
+	InvalidRecords   prometheus.Counter
 }
 
 func newMetrics() metrics {
@@ -52,6 +57,30 @@ func newMetrics() metrics {
 			Name:      "peers_handler_peers_count",
 			Help:      "Number of peers received in peer messages.",
 		}),
+		RecordsHandler: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "records_handler_count",
+			Help:      "Number of peer record messages received.",
+		}),
+		StaleRecords: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "stale_records_count",
+			Help:      "Number of received peer records which are older than the held ones or expired.",
+		}),
+		DuplicateRecords: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "duplicate_records_count",
+			Help:      "Number of peer records which were skipped as already known.",
+		}),
+		InvalidRecords: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "invalid_records_count",
+			Help:      "Number of received peer records with invalid signatures.",
+		}),
 	}
 }
 
@@ -0,0 +1,187 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/penguintop/penguin/pkg/crypto"
+	"github.com/penguintop/penguin/pkg/hive/pb"
+	"github.com/penguintop/penguin/pkg/penguin"
+)
+
+var (
+	// ErrInvalidRecord is returned when the peer record signature
+	// does not match the overlay address of the record.
+	ErrInvalidRecord = errors.New("invalid peer record")
+	// ErrRecordExpired is returned when the peer record is expired.
+	ErrRecordExpired = errors.New("peer record expired")
+
+	// recordTTL is the validity period of the records signed by the node.
+	recordTTL = 24 * time.Hour
+)
+
+// recordResult is the outcome of adding a record to the record store.
+type recordResult int
+
+const (
+	recordNew recordResult = iota
+	recordDuplicate
+	recordStale
+)
+
+// record is the verified peer record: the overlay and underlay addresses
+// of the peer signed by the peer's own key together with a sequence number
+// and an expiry. A record with a higher sequence number replaces the
+// older one, so that stale addresses can not be spread by the gossip.
+type record struct {
+	overlay   penguin.Address
+	underlay  []byte
+	sequence  uint64
+	expiry    int64 // unix timestamp in seconds
+	signature []byte
+}
+
+func (r record) expired(now time.Time) bool {
+	return r.expiry <= now.Unix()
+}
+
+func (r record) toProto() *pb.PeerRecord {
+	return &pb.PeerRecord{
+		Overlay:   r.overlay.Bytes(),
+		Underlay:  r.underlay,
+		Sequence:  r.sequence,
+		Expiry:    r.expiry,
+		Signature: r.signature,
+	}
+}
+
+func recordSignData(overlay, underlay []byte, sequence uint64, expiry int64, networkID uint64) []byte {
+	b := make([]byte, 24)
+	binary.BigEndian.PutUint64(b, networkID)
+	binary.BigEndian.PutUint64(b[8:], sequence)
+	binary.BigEndian.PutUint64(b[16:], uint64(expiry))
+	data := append([]byte("pen-peer-record-"), overlay...)
+	data = append(data, underlay...)
+	return append(data, b...)
+}
+
+// newRecord creates a record of the overlay and underlay addresses signed
+// with the signer.
+func newRecord(signer crypto.Signer, overlay penguin.Address, underlay ma.Multiaddr, sequence uint64, expiry int64, networkID uint64) (record, error) {
+	signature, err := signer.Sign(recordSignData(overlay.Bytes(), underlay.Bytes(), sequence, expiry, networkID))
+	if err != nil {
+		return record{}, err
+	}
+	return record{
+		overlay:   overlay,
+		underlay:  underlay.Bytes(),
+		sequence:  sequence,
+		expiry:    expiry,
+		signature: signature,
+	}, nil
+}
+
+// parseRecord verifies that the record is signed by the key
+// of its overlay address and that it is not expired.
+func parseRecord(r *pb.PeerRecord, networkID uint64, now time.Time) (record, error) {
+	if _, err := ma.NewMultiaddrBytes(r.Underlay); err != nil {
+		return record{}, ErrInvalidRecord
+	}
+	recoveredPK, err := crypto.Recover(r.Signature, recordSignData(r.Overlay, r.Underlay, r.Sequence, r.Expiry, networkID))
+	if err != nil {
+		return record{}, ErrInvalidRecord
+	}
+	recoveredOverlay, err := crypto.NewOverlayAddress(*recoveredPK, networkID)
+	if err != nil {
+		return record{}, ErrInvalidRecord
+	}
+	if !bytes.Equal(recoveredOverlay.Bytes(), r.Overlay) {
+		return record{}, ErrInvalidRecord
+	}
+
+	rec := record{
+		overlay:   recoveredOverlay,
+		underlay:  r.Underlay,
+		sequence:  r.Sequence,
+		expiry:    r.Expiry,
+		signature: r.Signature,
+	}
+	if rec.expired(now) {
+		return record{}, ErrRecordExpired
+	}
+	return rec, nil
+}
+
+// recordStore holds the newest known record of every peer.
+type recordStore struct {
+	mu      sync.Mutex
+	records map[string]record // records by overlay
+}
+
+func newRecordStore() *recordStore {
+	return &recordStore{
+		records: make(map[string]record),
+	}
+}
+
+// get returns the unexpired record of the overlay address.
+func (rs *recordStore) get(overlay penguin.Address, now time.Time) (record, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	r, ok := rs.records[overlay.ByteString()]
+	if !ok {
+		return record{}, false
+	}
+	if r.expired(now) {
+		delete(rs.records, overlay.ByteString())
+		return record{}, false
+	}
+	return r, true
+}
+
+// add stores the record if it is newer than the held one.
+func (rs *recordStore) add(r record, now time.Time) recordResult {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	key := r.overlay.ByteString()
+	if held, ok := rs.records[key]; ok && !held.expired(now) {
+		switch {
+		case r.sequence < held.sequence:
+			return recordStale
+		case r.sequence == held.sequence:
+			return recordDuplicate
+		}
+	}
+	rs.records[key] = r
+	return recordNew
+}
+
+// prune removes the expired records and the records of the
+// overlay addresses which are not known.
+func (rs *recordStore) prune(now time.Time, known func(overlay penguin.Address) bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for key, r := range rs.records {
+		if r.expired(now) || !known(r.overlay) {
+			delete(rs.records, key)
+		}
+	}
+}
+
+func (rs *recordStore) len() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	return len(rs.records)
+}
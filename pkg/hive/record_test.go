@@ -0,0 +1,423 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hive_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strconv"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	ab "github.com/penguintop/penguin/pkg/addressbook"
+	"github.com/penguintop/penguin/pkg/crypto"
+	"github.com/penguintop/penguin/pkg/hive"
+	"github.com/penguintop/penguin/pkg/hive/pb"
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/p2p/protobuf"
+	"github.com/penguintop/penguin/pkg/p2p/streamtest"
+	"github.com/penguintop/penguin/pkg/pen"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/statestore/mock"
+)
+
+const networkID = uint64(1)
+
+type signedPeer struct {
+	signer  crypto.Signer
+	overlay penguin.Address
+}
+
+func newSignedPeer(t *testing.T) signedPeer {
+	t.Helper()
+
+	pk, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay, err := crypto.NewOverlayAddress(pk.PublicKey, networkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signedPeer{signer: crypto.NewDefaultSigner(pk), overlay: overlay}
+}
+
+func underlay(t *testing.T, port int) ma.Multiaddr {
+	t.Helper()
+
+	underlay, err := ma.NewMultiaddr("/ip4/127.0.0.1/udp/" + strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return underlay
+}
+
+func (p signedPeer) address(t *testing.T, port int) *pen.Address {
+	t.Helper()
+
+	addr, err := pen.NewAddress(p.signer, underlay(t, port), p.overlay, networkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+func (p signedPeer) record(t *testing.T, port int, sequence uint64, expiry time.Time) *pb.PeerRecord {
+	t.Helper()
+
+	r, err := hive.NewRecord(p.signer, p.overlay, underlay(t, port), sequence, expiry.Unix(), networkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func (p signedPeer) signedPeer(t *testing.T, port int, sequence uint64, expiry time.Time) *pb.SignedPeer {
+	t.Helper()
+
+	addr := p.address(t, port)
+	return &pb.SignedPeer{
+		Address: &pb.PenAddress{
+			Overlay:   addr.Overlay.Bytes(),
+			Underlay:  addr.Underlay.Bytes(),
+			Signature: addr.Signature,
+		},
+		Record: p.record(t, port, sequence, expiry),
+	}
+}
+
+// advertisedUnderlays advertises the same underlay to all peers.
+type advertisedUnderlays struct {
+	underlay ma.Multiaddr
+}
+
+func (a advertisedUnderlays) AdvertisedUnderlay(_ penguin.Address) (ma.Multiaddr, bool) {
+	return a.underlay, true
+}
+
+func TestBroadcastRecords(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+	addressbook := ab.New(mock.NewStateStore())
+	serverAddressbook := ab.New(mock.NewStateStore())
+
+	client := newSignedPeer(t)
+	serverAddress := newSignedPeer(t).overlay
+
+	var peers []penguin.Address
+	for i := 0; i < 3; i++ {
+		addr := newSignedPeer(t).address(t, i)
+		if err := addressbook.Put(addr.Overlay, *addr); err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, addr.Overlay)
+	}
+
+	server := hive.New(nil, serverAddressbook, networkID, serverAddress, nil, logger)
+	recorder := streamtest.New(
+		streamtest.WithProtocols(server.Protocol(), server.ProtocolV2()),
+		streamtest.WithBaseAddr(client.overlay),
+	)
+	service := hive.New(recorder, addressbook, networkID, client.overlay, client.signer, logger)
+	service.SetAdvertisedUnderlayer(advertisedUnderlays{underlay: underlay(t, 100)})
+
+	if err := service.BroadcastPeers(context.Background(), serverAddress, peers...); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := recorder.Records(serverAddress, "hive", "2.0.0", "records")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(records); l != 1 {
+		t.Fatalf("got %d records, want 1", l)
+	}
+	messages, err := protobuf.ReadMessages(bytes.NewReader(records[0].In()), func() protobuf.Message {
+		return new(pb.PeerRecords)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := messages[0].(*pb.PeerRecords)
+	if msg.Record == nil || !bytes.Equal(msg.Record.Overlay, client.overlay.Bytes()) || !bytes.Equal(msg.Record.Underlay, underlay(t, 100).Bytes()) {
+		t.Fatalf("got record %v, want record of %s", msg.Record, client.overlay)
+	}
+	if l := len(msg.Peers); l != len(peers) {
+		t.Fatalf("got %d peers, want %d", l, len(peers))
+	}
+	expectOverlaysEventually(t, serverAddressbook, peers)
+
+	if _, err := recorder.Records(serverAddress, "hive", "1.0.0", "peers"); err != streamtest.ErrRecordsNotFound {
+		t.Fatalf("got error %v, want %v", err, streamtest.ErrRecordsNotFound)
+	}
+
+	// the gossip which the server already knows is not sent again
+	if err := service.BroadcastPeers(context.Background(), serverAddress, peers...); err != nil {
+		t.Fatal(err)
+	}
+	records, err = recorder.Records(serverAddress, "hive", "2.0.0", "records")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(records); l != 1 {
+		t.Fatalf("got %d records, want 1", l)
+	}
+}
+
+func TestBroadcastRecordsFallback(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+	addressbook := ab.New(mock.NewStateStore())
+	serverAddressbook := ab.New(mock.NewStateStore())
+
+	client := newSignedPeer(t)
+	serverAddress := newSignedPeer(t).overlay
+
+	addr := newSignedPeer(t).address(t, 0)
+	if err := addressbook.Put(addr.Overlay, *addr); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server supports only the first protocol version
+	server := hive.New(nil, serverAddressbook, networkID, serverAddress, nil, logger)
+	recorder := streamtest.New(
+		streamtest.WithProtocols(server.Protocol()),
+	)
+	service := hive.New(recorder, addressbook, networkID, client.overlay, client.signer, logger)
+
+	for i := 0; i < 2; i++ {
+		if err := service.BroadcastPeers(context.Background(), serverAddress, addr.Overlay); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := recorder.Records(serverAddress, "hive", "1.0.0", "peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(records); l != 2 {
+		t.Fatalf("got %d records, want 2", l)
+	}
+	expectOverlaysEventually(t, serverAddressbook, []penguin.Address{addr.Overlay})
+}
+
+func TestRecordsHandler(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+	addressbook := ab.New(mock.NewStateStore())
+
+	sender := newSignedPeer(t)
+	serverAddress := newSignedPeer(t).overlay
+
+	server := hive.New(nil, addressbook, networkID, serverAddress, nil, logger)
+	added := make(chan []penguin.Address, 10)
+	server.SetAddPeersHandler(func(addrs ...penguin.Address) {
+		added <- addrs
+	})
+	recorder := streamtest.New(
+		streamtest.WithProtocols(server.ProtocolV2()),
+		streamtest.WithBaseAddr(sender.overlay),
+	)
+
+	send := func(t *testing.T, msg *pb.PeerRecords) {
+		t.Helper()
+
+		stream, err := recorder.NewStream(context.Background(), serverAddress, nil, "hive", "2.0.0", "records")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+		w := protobuf.NewWriter(stream)
+		if err := w.WriteMsg(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectAdded := func(t *testing.T, want ...penguin.Address) {
+		t.Helper()
+
+		select {
+		case got := <-added:
+			if len(got) != len(want) {
+				t.Fatalf("got %d added peers, want %d", len(got), len(want))
+			}
+			for i := range got {
+				if !got[i].Equal(want[i]) {
+					t.Fatalf("got added peer %s, want %s", got[i], want[i])
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for added peers")
+		}
+	}
+
+	expectUnderlay := func(t *testing.T, p signedPeer, port int) {
+		t.Helper()
+
+		addr, err := addressbook.Get(p.overlay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := p.address(t, port).Underlay; !addr.Underlay.Equal(want) {
+			t.Fatalf("got underlay %s, want %s", addr.Underlay, want)
+		}
+	}
+
+	var (
+		expiry   = time.Now().Add(time.Hour)
+		peer     = newSignedPeer(t)
+		other    = newSignedPeer(t)
+		another  = newSignedPeer(t)
+		unsigned = newSignedPeer(t)
+	)
+
+	send(t, &pb.PeerRecords{
+		Record: sender.record(t, 0, 1, expiry),
+		Peers:  []*pb.SignedPeer{peer.signedPeer(t, 1, 2, expiry)},
+	})
+	expectAdded(t, peer.overlay)
+	expectUnderlay(t, peer, 1)
+
+	t.Run("stale", func(t *testing.T) {
+		send(t, &pb.PeerRecords{
+			Peers: []*pb.SignedPeer{
+				peer.signedPeer(t, 2, 1, expiry),
+				other.signedPeer(t, 1, 1, expiry),
+			},
+		})
+		expectAdded(t, other.overlay)
+		expectUnderlay(t, peer, 1)
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		send(t, &pb.PeerRecords{
+			Peers: []*pb.SignedPeer{
+				peer.signedPeer(t, 3, 2, expiry),
+				another.signedPeer(t, 1, 1, expiry),
+			},
+		})
+		expectAdded(t, another.overlay)
+		expectUnderlay(t, peer, 1)
+	})
+
+	t.Run("newer", func(t *testing.T) {
+		send(t, &pb.PeerRecords{
+			Peers: []*pb.SignedPeer{peer.signedPeer(t, 4, 3, expiry)},
+		})
+		expectAdded(t, peer.overlay)
+		expectUnderlay(t, peer, 4)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expired := newSignedPeer(t)
+		send(t, &pb.PeerRecords{
+			Peers: []*pb.SignedPeer{
+				expired.signedPeer(t, 1, 1, time.Now().Add(-time.Minute)),
+				other.signedPeer(t, 2, 2, expiry),
+			},
+		})
+		expectAdded(t, other.overlay)
+		if _, err := addressbook.Get(expired.overlay); err != ab.ErrNotFound {
+			t.Fatalf("got error %v, want %v", err, ab.ErrNotFound)
+		}
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		forged := other.signedPeer(t, 5, 3, expiry)
+		forged.Record.Signature = another.record(t, 5, 3, expiry).Signature
+		send(t, &pb.PeerRecords{
+			Peers: []*pb.SignedPeer{
+				forged,
+				another.signedPeer(t, 2, 2, expiry),
+			},
+		})
+		expectAdded(t, another.overlay)
+		expectUnderlay(t, other, 2)
+	})
+
+	t.Run("other underlay", func(t *testing.T) {
+		// the record must be signed for the gossiped underlay
+		moved := other.signedPeer(t, 6, 4, expiry)
+		moved.Record = other.record(t, 7, 4, expiry)
+		send(t, &pb.PeerRecords{
+			Peers: []*pb.SignedPeer{
+				moved,
+				another.signedPeer(t, 3, 3, expiry),
+			},
+		})
+		expectAdded(t, another.overlay)
+		expectUnderlay(t, other, 2)
+	})
+
+	t.Run("without record", func(t *testing.T) {
+		// a peer without a record is accepted, but it can
+		// not replace an address which is held with a record
+		withRecord := peer.signedPeer(t, 5, 0, expiry)
+		withRecord.Record = nil
+		withoutRecord := unsigned.signedPeer(t, 1, 0, expiry)
+		withoutRecord.Record = nil
+		send(t, &pb.PeerRecords{
+			Peers: []*pb.SignedPeer{withRecord, withoutRecord},
+		})
+		expectAdded(t, unsigned.overlay)
+		expectUnderlay(t, peer, 4)
+	})
+}
+
+// TestPruneRecords validates that the records and the sent record sequences
+// of the peers which are removed from the address book are dropped.
+func TestPruneRecords(t *testing.T) {
+	t.Cleanup(hive.SetRecordPruneInterval(0))
+
+	logger := logging.New(ioutil.Discard, 0)
+	addressbook := ab.New(mock.NewStateStore())
+
+	sender := newSignedPeer(t)
+	serverAddress := newSignedPeer(t).overlay
+	expiry := time.Now().Add(time.Hour)
+
+	server := hive.New(nil, addressbook, networkID, serverAddress, nil, logger)
+	recorder := streamtest.New(
+		streamtest.WithProtocols(server.ProtocolV2()),
+		streamtest.WithBaseAddr(sender.overlay),
+	)
+
+	peers := []signedPeer{newSignedPeer(t), newSignedPeer(t)}
+	stream, err := recorder.NewStream(context.Background(), serverAddress, nil, "hive", "2.0.0", "records")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = protobuf.NewWriter(stream).WriteMsg(&pb.PeerRecords{
+		Peers: []*pb.SignedPeer{
+			peers[0].signedPeer(t, 1, 1, expiry),
+			peers[1].signedPeer(t, 2, 1, expiry),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = stream.Close()
+
+	expectOverlaysEventually(t, addressbook, []penguin.Address{peers[0].overlay, peers[1].overlay})
+	if n := server.Records(); n != 2 {
+		t.Fatalf("got %d records, want 2", n)
+	}
+	if n := server.SentRecords(sender.overlay); n != 2 {
+		t.Fatalf("got %d sent records, want 2", n)
+	}
+
+	if err := addressbook.Remove(peers[0].overlay); err != nil {
+		t.Fatal(err)
+	}
+	// the records are pruned on the next broadcast
+	if err := server.BroadcastPeers(context.Background(), sender.overlay); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.Records(); n != 1 {
+		t.Fatalf("got %d records, want 1", n)
+	}
+	if n := server.SentRecords(sender.overlay); n != 1 {
+		t.Fatalf("got %d sent records, want 1", n)
+	}
+}
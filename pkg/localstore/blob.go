@@ -0,0 +1,177 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// ErrBlobStoreRequired is returned if the database keeps chunk data in a
+// ChunkBlobStore but none is configured.
+var ErrBlobStoreRequired = errors.New("chunk data is kept in a blob store but none is configured")
+
+// number of chunks moved to the blob store in a single batch
+const blobMigrationBatchSize = 10000
+
+// ChunkBlobStore stores chunk payloads outside of the leveldb database.
+// The retrieval data index then only keeps the chunk metadata.
+type ChunkBlobStore interface {
+	// Get returns the data of the chunk or storage.ErrNotFound.
+	Get(addr penguin.Address) ([]byte, error)
+	// Has reports whether the data of the chunk is stored.
+	Has(addr penguin.Address) (bool, error)
+	// Put stores the data of the chunk. Storing existing data is a no-op.
+	Put(addr penguin.Address, data []byte) error
+	// Delete removes the data of the chunk. Deleting missing data is a no-op.
+	Delete(addr penguin.Address) error
+	// Iterate calls fn for the address of every stored chunk.
+	Iterate(fn func(addr penguin.Address) (stop bool, err error)) error
+	// Sync makes all stored and deleted data durable.
+	Sync() error
+	// Close closes the store.
+	Close() error
+}
+
+// putBlob stores the data of the item in the blob store if there is one.
+// Blobs are written before the batch updating the indexes so that an indexed
+// chunk always has its data. Blobs of batches which fail to be written are
+// left for the database check to collect.
+func (db *DB) putBlob(item shed.Item) error {
+	if db.blobs == nil {
+		return nil
+	}
+	return db.blobs.Put(penguin.NewAddress(item.Address), item.Data)
+}
+
+// deleteChunkData removes the data of chunks which were removed from the
+// indexes from the hot cache and the blob store.
+func (db *DB) deleteChunkData(addrs []penguin.Address) {
+	if db.hot != nil {
+		db.hot.remove(addrs)
+	}
+	if db.blobs == nil {
+		return
+	}
+	for _, addr := range addrs {
+		if err := db.blobs.Delete(addr); err != nil {
+			db.logger.Errorf("localstore: delete chunk data %s: %v", addr, err)
+		}
+	}
+}
+
+// getBlob loads the data of a chunk whose retrieval data index value holds
+// no data.
+func (db *DB) getBlob(addr []byte) ([]byte, error) {
+	if db.blobs == nil {
+		return nil, ErrBlobStoreRequired
+	}
+	data, err := db.blobs.Get(penguin.NewAddress(addr))
+	if err != nil {
+		return nil, fmt.Errorf("chunk data %x: %w", addr, err)
+	}
+	return data, nil
+}
+
+// initChunkData moves the chunk data kept in the retrieval data index to the
+// blob store, when one is configured for the first time, so that all chunk
+// data is kept in it. It returns ErrBlobStoreRequired if chunk data is kept
+// in a blob store but none is configured.
+func (db *DB) initChunkData() error {
+	used, err := db.blobStoreUsed.Get()
+	if err != nil {
+		return err
+	}
+	if db.blobs == nil {
+		if used != 0 {
+			return ErrBlobStoreRequired
+		}
+		return nil
+	}
+	if used != 0 {
+		return nil
+	}
+	if err := db.moveChunkData(); err != nil {
+		return err
+	}
+	return db.blobStoreUsed.Put(1)
+}
+
+// moveChunkData moves the data of all chunks from the retrieval data index
+// to the blob store. The move can be interrupted and resumed, as data is put
+// to the blob store before it is removed from the index.
+func (db *DB) moveChunkData() error {
+	// the index keeps the raw values, as the data is
+	// appended to the header of the retrieval data index
+	retrievalDataIndex, err := db.shed.NewIndex("Address->StoreTimestamp|BinID|BatchID|Sig|Data", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			return fields.Address, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			return fields.Data, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.Data = value
+			return e, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var (
+		batch = new(leveldb.Batch)
+		count int
+		total int
+	)
+	err = retrievalDataIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		if len(item.Data) <= retrievalDataHeaderSize {
+			return false, nil
+		}
+		data := item.Data[retrievalDataHeaderSize:]
+		if err := db.blobs.Put(penguin.NewAddress(item.Address), data); err != nil {
+			return true, err
+		}
+		item.Data = item.Data[:retrievalDataHeaderSize]
+		if err := retrievalDataIndex.PutInBatch(batch, item); err != nil {
+			return true, err
+		}
+		count++
+		if count >= blobMigrationBatchSize {
+			// the data must be durable before it is removed from the index
+			if err := db.blobs.Sync(); err != nil {
+				return true, err
+			}
+			if err := db.shed.WriteBatch(batch); err != nil {
+				return true, err
+			}
+			total += count
+			db.logger.Debugf("localstore: moved data of %d chunks to blob store", total)
+			batch = new(leveldb.Batch)
+			count = 0
+		}
+		return false, nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := db.blobs.Sync(); err != nil {
+		return err
+	}
+	if err := db.shed.WriteBatch(batch); err != nil {
+		return err
+	}
+	total += count
+	db.logger.Infof("localstore: moved data of %d chunks to blob store", total)
+	return nil
+}
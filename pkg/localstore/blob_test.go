@@ -0,0 +1,214 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/localstore/blobstore"
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/penguintop/penguin/pkg/storage"
+)
+
+func newTestBlobStore(t *testing.T, dir string) ChunkBlobStore {
+	t.Helper()
+
+	blobs, err := blobstore.NewSharded(dir, &blobstore.Options{CompactionInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blobs
+}
+
+// TestBlobStore validates that chunk data is kept in the blob store
+// and removed from it with the chunk.
+func TestBlobStore(t *testing.T) {
+	blobs := newTestBlobStore(t, t.TempDir())
+	db := newTestDB(t, &Options{BlobStore: blobs})
+
+	chunks := generateTestRandomChunks(10)
+	_, err := db.Put(context.Background(), storage.ModePutUpload, chunks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ch := range chunks {
+		got, err := db.Get(context.Background(), storage.ModeGetRequest, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Data(), ch.Data()) {
+			t.Fatalf("got data %x, want %x", got.Data(), ch.Data())
+		}
+		has, err := blobs.Has(ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Fatalf("chunk %s data not in blob store", ch.Address())
+		}
+	}
+
+	removed := chunks[0].Address()
+	err = db.Set(context.Background(), storage.ModeSetRemove, removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	has, err := blobs.Has(removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Fatalf("removed chunk %s data still in blob store", removed)
+	}
+}
+
+// TestBlobStoreMigration validates that chunk data stored in leveldb is
+// moved to the blob store when one is configured, also after the schema
+// was migrated without one.
+func TestBlobStoreMigration(t *testing.T) {
+	defer func(s string) {
+		DbSchemaCurrent = s
+	}(DbSchemaCurrent)
+	DbSchemaCurrent = DbSchemaYuj
+
+	dir := t.TempDir()
+	path := dir + "/localstore"
+	blobsDir := dir + "/blobs"
+	baseKey := make([]byte, 32)
+	logger := logging.New(ioutil.Discard, 0)
+
+	db, err := New(path, baseKey, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := generateTestRandomChunks(10)
+	_, err = db.Put(context.Background(), storage.ModePutUpload, chunks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	DbSchemaCurrent = DbSchemaBlobs
+	db, err = New(path, baseKey, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	blobs := newTestBlobStore(t, blobsDir)
+	db, err = New(path, baseKey, &Options{BlobStore: blobs}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range chunks {
+		data, err := blobs.Get(ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, ch.Data()) {
+			t.Fatalf("got blob %x, want %x", data, ch.Data())
+		}
+		got, err := db.Get(context.Background(), storage.ModeGetLookup, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Data(), ch.Data()) {
+			t.Fatalf("got data %x, want %x", got.Data(), ch.Data())
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = New(path, baseKey, nil, logger)
+	if !errors.Is(err, ErrBlobStoreRequired) {
+		t.Fatalf("got error %v, want %v", err, ErrBlobStoreRequired)
+	}
+}
+
+// TestBlobStoreGC validates that garbage collected chunks
+// are removed from the blob store.
+func TestBlobStoreGC(t *testing.T) {
+	var closed chan struct{}
+	testHookCollectGarbageChan := make(chan uint64)
+	t.Cleanup(setTestHookCollectGarbage(func(collectedCount uint64) {
+		if collectedCount == 0 {
+			return
+		}
+		select {
+		case testHookCollectGarbageChan <- collectedCount:
+		case <-closed:
+		}
+	}))
+
+	t.Cleanup(setWithinRadiusFunc(func(_ *DB, _ shed.Item) bool { return false }))
+	blobs := newTestBlobStore(t, t.TempDir())
+	db := newTestDB(t, &Options{
+		Capacity:  100,
+		BlobStore: blobs,
+	})
+	closed = db.close
+
+	ctx := context.Background()
+	for i := 0; i < 150; i++ {
+		ch := generateTestRandomChunk()
+		unreserveChunkBatch(t, db, 0, ch)
+		_, err := db.Put(ctx, storage.ModePutUpload, ch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Set(ctx, storage.ModeSetSync, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gcTarget := db.gcTarget()
+	for {
+		select {
+		case <-testHookCollectGarbageChan:
+		case <-time.After(10 * time.Second):
+			t.Fatal("collect garbage timeout")
+		}
+		gcSize, err := db.gcSize.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gcSize == gcTarget {
+			break
+		}
+	}
+
+	var stored uint64
+	err := blobs.Iterate(func(addr penguin.Address) (bool, error) {
+		has, err := db.Has(ctx, addr)
+		if err != nil {
+			return true, err
+		}
+		if !has {
+			t.Fatalf("data of collected chunk %s not removed", addr)
+		}
+		stored++
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != gcTarget {
+		t.Fatalf("got data of %d chunks, want %d", stored, gcTarget)
+	}
+}
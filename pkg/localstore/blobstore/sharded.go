@@ -0,0 +1,851 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package blobstore provides storage for chunk payloads outside of the
+// leveldb database of the localstore.
+//
+// The Sharded store groups chunks by the prefix of their address into shards.
+// Every shard appends chunk records to segment files of bounded size. Records
+// are never modified in place. The location of every record and the number of
+// live bytes of every segment are kept in a leveldb index next to the shards,
+// so a deletion only removes the index entry and opening the store does not
+// scan the segments. Segments which mostly hold deleted records are compacted
+// by copying the records the index still points to to the active segment.
+package blobstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/storage"
+	"github.com/syndtr/goleveldb/leveldb"
+	ldberr "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// recordHeaderSize is the size of the header preceding the data of a
+	// record: address, data length and checksum.
+	recordHeaderSize = penguin.HashSize + 4 + 4
+	// maxDataLength is the largest data length a record can hold.
+	maxDataLength = ^uint32(0) - 1
+
+	segmentSuffix = ".seg"
+	indexDir      = "index"
+
+	// number of records moved to the active segment under a single lock
+	compactionBatchSize = 64
+)
+
+// Key prefixes of the leveldb index. Locations are keyed by the chunk
+// address, live bytes by the shard and segment.
+const (
+	locationKeyPrefix byte = iota + 1
+	liveKeyPrefix
+)
+
+var (
+	// DefaultShardBits is the number of leading address bits used to pick the shard.
+	DefaultShardBits uint8 = 4
+	// DefaultSegmentSize is the size at which a new segment is started.
+	DefaultSegmentSize int64 = 64 * 1024 * 1024
+	// DefaultGarbageRatio is the ratio of deleted data at which a segment
+	// is compacted.
+	DefaultGarbageRatio = 0.5
+	// DefaultCompactionInterval is the period of background compactions.
+	DefaultCompactionInterval = 10 * time.Minute
+)
+
+var (
+	// ErrCorrupted is returned if a record does not match its checksum.
+	ErrCorrupted = errors.New("blobstore: corrupted record")
+	// ErrClosed is returned if the store is used after it was closed.
+	ErrClosed = errors.New("blobstore: closed")
+)
+
+// Options configures the Sharded store. Zero values are replaced by defaults.
+type Options struct {
+	// ShardBits is the number of leading address bits selecting the shard.
+	ShardBits uint8
+	// SegmentSize is the size at which a new segment is started.
+	SegmentSize int64
+	// GarbageRatio is the ratio of deleted data at which a segment is compacted.
+	GarbageRatio float64
+	// CompactionInterval is the period of background compactions. Negative
+	// values disable background compactions.
+	CompactionInterval time.Duration
+	// SyncWrites makes every Put and Delete sync the segment and the index
+	// before returning. Otherwise writes are durable after Sync or Close.
+	SyncWrites bool
+}
+
+// Sharded stores chunk payloads in sharded append-only segment files.
+type Sharded struct {
+	db           *leveldb.DB
+	shardBits    uint8
+	segmentSize  int64
+	garbageRatio float64
+	shards       []*shard
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	done bool
+}
+
+type location struct {
+	segment uint32
+	offset  int64
+	length  uint32
+}
+
+func (l location) size() int64 {
+	return int64(recordHeaderSize) + int64(l.length)
+}
+
+func encodeLocation(l location) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint32(b, l.segment)
+	binary.BigEndian.PutUint64(b[4:], uint64(l.offset))
+	binary.BigEndian.PutUint32(b[12:], l.length)
+	return b
+}
+
+func decodeLocation(b []byte) (location, error) {
+	if len(b) != 16 {
+		return location{}, fmt.Errorf("%w: invalid location of %d bytes", ErrCorrupted, len(b))
+	}
+	return location{
+		segment: binary.BigEndian.Uint32(b),
+		offset:  int64(binary.BigEndian.Uint64(b[4:])),
+		length:  binary.BigEndian.Uint32(b[12:]),
+	}, nil
+}
+
+func locationKey(addr []byte) []byte {
+	return append([]byte{locationKeyPrefix}, addr...)
+}
+
+type segment struct {
+	id   uint32
+	file *os.File
+	size int64 // bytes written to the segment
+	live int64 // bytes of records which are not deleted
+}
+
+func (s *segment) garbageRatio() float64 {
+	if s.size == 0 {
+		return 0
+	}
+	return 1 - float64(s.live)/float64(s.size)
+}
+
+type shard struct {
+	// mu guards the segments and the index entries of the shard
+	mu sync.RWMutex
+	// compactMu serializes compactions of the shard
+	compactMu   sync.Mutex
+	id          uint8
+	db          *leveldb.DB
+	dir         string
+	segmentSize int64
+	syncWrites  bool
+	segments    map[uint32]*segment
+	active      *segment
+}
+
+// NewSharded opens or creates a Sharded store in the directory.
+func NewSharded(dir string, o *Options) (*Sharded, error) {
+	if o == nil {
+		o = new(Options)
+	}
+	s := &Sharded{
+		shardBits:    o.ShardBits,
+		segmentSize:  o.SegmentSize,
+		garbageRatio: o.GarbageRatio,
+		quit:         make(chan struct{}),
+	}
+	if s.shardBits == 0 {
+		s.shardBits = DefaultShardBits
+	}
+	if s.shardBits > 8 {
+		return nil, fmt.Errorf("blobstore: shard bits %d exceed 8", s.shardBits)
+	}
+	if s.segmentSize <= 0 {
+		s.segmentSize = DefaultSegmentSize
+	}
+	if s.garbageRatio <= 0 {
+		s.garbageRatio = DefaultGarbageRatio
+	}
+	compactionInterval := o.CompactionInterval
+	if compactionInterval == 0 {
+		compactionInterval = DefaultCompactionInterval
+	}
+
+	db, err := openIndex(filepath.Join(dir, indexDir))
+	if err != nil {
+		return nil, fmt.Errorf("blobstore: open index: %w", err)
+	}
+	s.db = db
+
+	s.shards = make([]*shard, 1<<s.shardBits)
+	for i := range s.shards {
+		sh, err := openShard(db, uint8(i), filepath.Join(dir, fmt.Sprintf("%02x", i)), s.segmentSize, o.SyncWrites)
+		if err != nil {
+			for _, sh := range s.shards[:i] {
+				_ = sh.close()
+			}
+			_ = db.Close()
+			return nil, fmt.Errorf("blobstore: open shard %d: %w", i, err)
+		}
+		s.shards[i] = sh
+	}
+
+	if compactionInterval > 0 {
+		s.wg.Add(1)
+		go s.compactionWorker(compactionInterval)
+	}
+	return s, nil
+}
+
+func openIndex(path string) (*leveldb.DB, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if ldberr.IsCorrupted(err) {
+		db, err = leveldb.RecoverFile(path, nil)
+	}
+	return db, err
+}
+
+func (s *Sharded) shard(addr penguin.Address) *shard {
+	b := addr.Bytes()
+	if len(b) == 0 {
+		return s.shards[0]
+	}
+	return s.shards[b[0]>>(8-s.shardBits)]
+}
+
+// Get returns the data of the chunk with the address.
+func (s *Sharded) Get(addr penguin.Address) ([]byte, error) {
+	return s.shard(addr).get(addr)
+}
+
+// Has reports whether the data of the chunk with the address is stored.
+func (s *Sharded) Has(addr penguin.Address) (bool, error) {
+	return s.shard(addr).has(addr)
+}
+
+// Put stores the data of the chunk with the address. Storing a chunk which
+// is already stored is a no-op.
+func (s *Sharded) Put(addr penguin.Address, data []byte) error {
+	if uint64(len(data)) > uint64(maxDataLength) {
+		return fmt.Errorf("blobstore: data of %d bytes too large", len(data))
+	}
+	return s.shard(addr).put(addr, data)
+}
+
+// Delete removes the data of the chunk with the address. Deleting a chunk
+// which is not stored is a no-op.
+func (s *Sharded) Delete(addr penguin.Address) error {
+	return s.shard(addr).delete(addr)
+}
+
+// Iterate calls fn for the address of every stored chunk in address order
+// until it returns true or an error. Chunks stored or deleted during the
+// iteration are not visited.
+func (s *Sharded) Iterate(fn func(addr penguin.Address) (stop bool, err error)) error {
+	it := s.db.NewIterator(util.BytesPrefix([]byte{locationKeyPrefix}), nil)
+	defer it.Release()
+
+	for it.Next() {
+		addr := append([]byte(nil), it.Key()[1:]...)
+		stop, err := fn(penguin.NewAddress(addr))
+		if err != nil {
+			return err
+		}
+		if stop {
+			return nil
+		}
+	}
+	return it.Error()
+}
+
+// Sync makes all stored and deleted chunks durable.
+func (s *Sharded) Sync() error {
+	for i, sh := range s.shards {
+		// a synced index write covers the writes of all shards
+		if err := sh.sync(i == len(s.shards)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compact compacts all segments which exceed the garbage ratio. It returns
+// the number of compacted segments.
+func (s *Sharded) Compact() (int, error) {
+	compacted := 0
+	for _, sh := range s.shards {
+		n, err := sh.compact(s.garbageRatio)
+		compacted += n
+		if err != nil {
+			return compacted, err
+		}
+	}
+	return compacted, nil
+}
+
+func (s *Sharded) compactionWorker(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// errors are retried on the next tick
+			_, _ = s.Compact()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Close stops background compactions, syncs and closes all segments and
+// the index.
+func (s *Sharded) Close() (err error) {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	s.done = true
+	s.mu.Unlock()
+
+	close(s.quit)
+	s.wg.Wait()
+
+	err = s.Sync()
+	for _, sh := range s.shards {
+		if e := sh.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if e := s.db.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+func segmentName(id uint32) string {
+	return fmt.Sprintf("%08d%s", id, segmentSuffix)
+}
+
+func openShard(db *leveldb.DB, id uint8, dir string, segmentSize int64, syncWrites bool) (*shard, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	sh := &shard{
+		id:          id,
+		db:          db,
+		dir:         dir,
+		segmentSize: segmentSize,
+		syncWrites:  syncWrites,
+		segments:    make(map[uint32]*segment),
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), segmentSuffix) {
+			continue
+		}
+		var id uint32
+		if _, err := fmt.Sscanf(e.Name(), "%08d"+segmentSuffix, &id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+		f, err := os.OpenFile(filepath.Join(dir, e.Name()), os.O_RDWR, 0o644)
+		if err != nil {
+			_ = sh.close()
+			return nil, err
+		}
+		sh.segments[id] = &segment{id: id, file: f, size: e.Size()}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	if err := sh.loadLive(); err != nil {
+		_ = sh.close()
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		if err := sh.roll(); err != nil {
+			return nil, err
+		}
+		return sh, nil
+	}
+
+	// only the active segment may end with a partially written record,
+	// the others were synced when the next segment was started
+	sh.active = sh.segments[ids[len(ids)-1]]
+	size, err := validSize(sh.active.file, sh.active.size)
+	if err != nil {
+		_ = sh.close()
+		return nil, fmt.Errorf("scan segment %d: %w", sh.active.id, err)
+	}
+	if size != sh.active.size {
+		if err := sh.active.file.Truncate(size); err != nil {
+			_ = sh.close()
+			return nil, err
+		}
+		sh.active.size = size
+	}
+	return sh, nil
+}
+
+func (sh *shard) liveKey(segment uint32) []byte {
+	b := make([]byte, 6)
+	b[0] = liveKeyPrefix
+	b[1] = sh.id
+	binary.BigEndian.PutUint32(b[2:], segment)
+	return b
+}
+
+// loadLive reads the live bytes of the segments from the index.
+func (sh *shard) loadLive() error {
+	it := sh.db.NewIterator(util.BytesPrefix([]byte{liveKeyPrefix, sh.id}), nil)
+	defer it.Release()
+
+	for it.Next() {
+		if len(it.Key()) != 6 || len(it.Value()) != 8 {
+			return fmt.Errorf("%w: invalid live bytes entry", ErrCorrupted)
+		}
+		if seg, ok := sh.segments[binary.BigEndian.Uint32(it.Key()[2:])]; ok {
+			seg.live = int64(binary.BigEndian.Uint64(it.Value()))
+		}
+	}
+	return it.Error()
+}
+
+// putLive adds the live bytes of the segment to the batch.
+func (sh *shard) putLive(batch *leveldb.Batch, segment uint32, live int64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(live))
+	batch.Put(sh.liveKey(segment), b)
+}
+
+func (sh *shard) writeOptions() *opt.WriteOptions {
+	return &opt.WriteOptions{Sync: sh.syncWrites}
+}
+
+// validSize returns the size of the valid records at the start of the segment.
+func validSize(r io.ReaderAt, segmentSize int64) (size int64, err error) {
+	err = scanSegment(r, segmentSize, func(_ []byte, offset int64, data []byte) error {
+		size = offset + recordHeaderSize + int64(len(data))
+		return nil
+	})
+	return size, err
+}
+
+// scanSegment calls fn for every valid record of the segment of the size.
+// Scanning stops at the first incomplete or corrupted record, including a
+// record whose header holds a length larger than the rest of the segment.
+func scanSegment(r io.ReaderAt, size int64, fn func(addr []byte, offset int64, data []byte) error) error {
+	var offset int64
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return nil
+		}
+		addr := append([]byte(nil), header[:penguin.HashSize]...)
+		length := binary.BigEndian.Uint32(header[penguin.HashSize:])
+		checksum := binary.BigEndian.Uint32(header[penguin.HashSize+4:])
+		if length > maxDataLength || int64(length) > size-offset-recordHeaderSize {
+			// the header of a torn record must not cause a large allocation
+			return nil
+		}
+
+		data := make([]byte, length)
+		if _, err := r.ReadAt(data, offset+recordHeaderSize); err != nil {
+			return nil
+		}
+		if recordChecksum(addr, data) != checksum {
+			return nil
+		}
+		if err := fn(addr, offset, data); err != nil {
+			return err
+		}
+		offset += recordHeaderSize + int64(length)
+	}
+}
+
+func recordChecksum(addr, data []byte) uint32 {
+	c := crc32.NewIEEE()
+	_, _ = c.Write(addr)
+	_, _ = c.Write(data)
+	return c.Sum32()
+}
+
+func encodeRecord(addr, data []byte) []byte {
+	b := make([]byte, recordHeaderSize, recordHeaderSize+len(data))
+	copy(b, addr)
+	binary.BigEndian.PutUint32(b[penguin.HashSize:], uint32(len(data)))
+	binary.BigEndian.PutUint32(b[penguin.HashSize+4:], recordChecksum(addr, data))
+	return append(b, data...)
+}
+
+// location returns the indexed location of the address. It must be called
+// with the lock held.
+func (sh *shard) location(addr []byte) (loc location, ok bool, err error) {
+	v, err := sh.db.Get(locationKey(addr), nil)
+	if err != nil {
+		if errors.Is(err, leveldb.ErrNotFound) {
+			return location{}, false, nil
+		}
+		return location{}, false, err
+	}
+	loc, err = decodeLocation(v)
+	if err != nil {
+		return location{}, false, err
+	}
+	return loc, true, nil
+}
+
+// roll starts a new active segment. It must be called with the write lock held.
+func (sh *shard) roll() error {
+	var id uint32
+	if sh.active != nil {
+		id = sh.active.id + 1
+		if err := sh.active.file.Sync(); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(filepath.Join(sh.dir, segmentName(id)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	seg := &segment{id: id, file: f}
+	sh.segments[id] = seg
+	sh.active = seg
+	return nil
+}
+
+// append writes the record to the active segment. It must be called with the
+// write lock held.
+func (sh *shard) append(record []byte) (location, error) {
+	if sh.active.size > 0 && sh.active.size+int64(len(record)) > sh.segmentSize {
+		if err := sh.roll(); err != nil {
+			return location{}, err
+		}
+	}
+	seg := sh.active
+	if _, err := seg.file.WriteAt(record, seg.size); err != nil {
+		return location{}, err
+	}
+	loc := location{
+		segment: seg.id,
+		offset:  seg.size,
+		length:  uint32(len(record) - recordHeaderSize),
+	}
+	seg.size += int64(len(record))
+	return loc, nil
+}
+
+func (sh *shard) get(addr penguin.Address) ([]byte, error) {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if sh.segments == nil {
+		return nil, ErrClosed
+	}
+	loc, ok, err := sh.location(addr.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	seg, ok := sh.segments[loc.segment]
+	if !ok {
+		return nil, fmt.Errorf("%w: chunk %s in missing segment %d", ErrCorrupted, addr, loc.segment)
+	}
+	record := make([]byte, loc.size())
+	if _, err := seg.file.ReadAt(record, loc.offset); err != nil {
+		return nil, err
+	}
+	data := record[recordHeaderSize:]
+	if recordChecksum(record[:penguin.HashSize], data) != binary.BigEndian.Uint32(record[penguin.HashSize+4:]) {
+		return nil, fmt.Errorf("%w: chunk %s", ErrCorrupted, addr)
+	}
+	return data, nil
+}
+
+func (sh *shard) has(addr penguin.Address) (bool, error) {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if sh.segments == nil {
+		return false, ErrClosed
+	}
+	return sh.db.Has(locationKey(addr.Bytes()), nil)
+}
+
+func (sh *shard) put(addr penguin.Address, data []byte) error {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.segments == nil {
+		return ErrClosed
+	}
+	key := locationKey(addr.Bytes())
+	if has, err := sh.db.Has(key, nil); err != nil || has {
+		return err
+	}
+	loc, err := sh.append(encodeRecord(addr.Bytes(), data))
+	if err != nil {
+		return err
+	}
+	seg := sh.segments[loc.segment]
+	if sh.syncWrites {
+		if err := seg.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	// a record which is written but not indexed is garbage
+	live := seg.live + loc.size()
+	batch := new(leveldb.Batch)
+	batch.Put(key, encodeLocation(loc))
+	sh.putLive(batch, seg.id, live)
+	if err := sh.db.Write(batch, sh.writeOptions()); err != nil {
+		return err
+	}
+	seg.live = live
+	return nil
+}
+
+func (sh *shard) delete(addr penguin.Address) error {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.segments == nil {
+		return ErrClosed
+	}
+	loc, ok, err := sh.location(addr.Bytes())
+	if err != nil || !ok {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Delete(locationKey(addr.Bytes()))
+	seg, ok := sh.segments[loc.segment]
+	if ok {
+		sh.putLive(batch, seg.id, seg.live-loc.size())
+	}
+	if err := sh.db.Write(batch, sh.writeOptions()); err != nil {
+		return err
+	}
+	if ok {
+		seg.live -= loc.size()
+	}
+	return nil
+}
+
+// sync syncs the active segment and, if requested, the index.
+func (sh *shard) sync(index bool) error {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if sh.segments == nil {
+		return ErrClosed
+	}
+	if err := sh.active.file.Sync(); err != nil {
+		return err
+	}
+	if !index {
+		return nil
+	}
+	// rewriting the live bytes of the active segment with sync also
+	// syncs all preceding index writes
+	batch := new(leveldb.Batch)
+	sh.putLive(batch, sh.active.id, sh.active.live)
+	return sh.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// compact compacts the inactive segments exceeding the garbage ratio.
+func (sh *shard) compact(garbageRatio float64) (int, error) {
+	sh.compactMu.Lock()
+	defer sh.compactMu.Unlock()
+
+	sh.mu.RLock()
+	var ids []uint32
+	for id, seg := range sh.segments {
+		if seg != sh.active && seg.garbageRatio() >= garbageRatio {
+			ids = append(ids, id)
+		}
+	}
+	sh.mu.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	compacted := 0
+	for _, id := range ids {
+		ok, err := sh.compactSegment(id)
+		if err != nil {
+			return compacted, err
+		}
+		if ok {
+			compacted++
+		}
+	}
+	return compacted, nil
+}
+
+type record struct {
+	addr   []byte
+	offset int64
+	data   []byte
+}
+
+// compactSegment copies the records of the segment which the index still
+// points to to the active segment and removes the segment. Inactive segments
+// are never written, so they are scanned without the lock, which is only
+// held to move batches of records and to remove the segment. It reports
+// false if the segment is kept because some of its indexed records could
+// not be read.
+func (sh *shard) compactSegment(id uint32) (bool, error) {
+	sh.mu.RLock()
+	seg, ok := sh.segments[id]
+	active := sh.active
+	sh.mu.RUnlock()
+	if !ok || seg == active {
+		return false, nil
+	}
+
+	var pending []record
+	// the size of segments other than the active one does not change
+	err := scanSegment(seg.file, seg.size, func(addr []byte, offset int64, data []byte) error {
+		pending = append(pending, record{addr: addr, offset: offset, data: data})
+		if len(pending) < compactionBatchSize {
+			return nil
+		}
+		err := sh.move(seg, pending)
+		pending = pending[:0]
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := sh.move(seg, pending); err != nil {
+		return false, err
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.segments == nil {
+		return false, ErrClosed
+	}
+	if seg.live > 0 {
+		return false, nil
+	}
+	batch := new(leveldb.Batch)
+	batch.Delete(sh.liveKey(id))
+	if err := sh.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return false, err
+	}
+	if err := seg.file.Close(); err != nil {
+		return false, err
+	}
+	delete(sh.segments, id)
+	if err := os.Remove(filepath.Join(sh.dir, segmentName(id))); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// move copies the records to the active segment and points the index to the
+// copies, skipping the records which are no longer indexed at their offset
+// in the segment.
+func (sh *shard) move(seg *segment, records []record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.segments == nil {
+		return ErrClosed
+	}
+	var (
+		batch = new(leveldb.Batch)
+		live  = make(map[*segment]int64)
+	)
+	for _, r := range records {
+		loc, ok, err := sh.location(r.addr)
+		if err != nil {
+			return err
+		}
+		if !ok || loc.segment != seg.id || loc.offset != r.offset {
+			continue
+		}
+		moved, err := sh.append(encodeRecord(r.addr, r.data))
+		if err != nil {
+			return err
+		}
+		batch.Put(locationKey(r.addr), encodeLocation(moved))
+		live[seg] -= loc.size()
+		live[sh.segments[moved.segment]] += moved.size()
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	// the copies must be persisted before the index points to them
+	if err := sh.active.file.Sync(); err != nil {
+		return err
+	}
+	for s, delta := range live {
+		sh.putLive(batch, s.id, s.live+delta)
+	}
+	if err := sh.db.Write(batch, nil); err != nil {
+		return err
+	}
+	for s, delta := range live {
+		s.live += delta
+	}
+	return nil
+}
+
+func (sh *shard) close() (err error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	for _, seg := range sh.segments {
+		if e := seg.file.Sync(); e != nil && err == nil {
+			err = e
+		}
+		if e := seg.file.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	sh.segments = nil
+	return err
+}
@@ -0,0 +1,346 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blobstore_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/penguintop/penguin/pkg/localstore/blobstore"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/storage"
+	chunktesting "github.com/penguintop/penguin/pkg/storage/testing"
+)
+
+func newTestStore(t *testing.T, dir string, segmentSize int64) *blobstore.Sharded {
+	t.Helper()
+
+	s, err := blobstore.NewSharded(dir, &blobstore.Options{
+		ShardBits:          1,
+		SegmentSize:        segmentSize,
+		CompactionInterval: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func checkData(t *testing.T, s *blobstore.Sharded, ch penguin.Chunk) {
+	t.Helper()
+
+	data, err := s.Get(ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, ch.Data()) {
+		t.Fatalf("got data %x, want %x", data, ch.Data())
+	}
+}
+
+func checkDeleted(t *testing.T, s *blobstore.Sharded, addr penguin.Address) {
+	t.Helper()
+
+	_, err := s.Get(addr)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func TestSharded(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, 16*1024)
+
+	chunks := chunktesting.GenerateTestRandomChunks(20)
+	for _, ch := range chunks {
+		if err := s.Put(ch.Address(), ch.Data()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// storing the same chunk again is a no-op
+	if err := s.Put(chunks[0].Address(), chunks[0].Data()); err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range chunks[:5] {
+		if err := s.Delete(ch.Address()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, ch := range chunks[:5] {
+		checkDeleted(t, s, ch.Address())
+	}
+	for _, ch := range chunks[5:] {
+		checkData(t, s, ch)
+	}
+
+	var count int
+	err := s.Iterate(func(addr penguin.Address) (bool, error) {
+		count++
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 15 {
+		t.Fatalf("iterated %d chunks, want 15", count)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index is kept across restarts
+	s = newTestStore(t, dir, 16*1024)
+	defer s.Close()
+
+	for _, ch := range chunks[:5] {
+		checkDeleted(t, s, ch.Address())
+	}
+	for _, ch := range chunks[5:] {
+		checkData(t, s, ch)
+	}
+}
+
+func TestShardedCompact(t *testing.T) {
+	dir := t.TempDir()
+	// small segments so that every shard has several of them
+	s := newTestStore(t, dir, 8*1024)
+
+	chunks := chunktesting.GenerateTestRandomChunks(40)
+	for _, ch := range chunks {
+		if err := s.Put(ch.Address(), ch.Data()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ch := range chunks[:30] {
+		if err := s.Delete(ch.Address()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := segmentsSize(t, dir)
+	compacted, err := s.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted == 0 {
+		t.Fatal("no segments compacted")
+	}
+	if after := segmentsSize(t, dir); after >= before {
+		t.Fatalf("compaction did not free space: %d bytes before, %d after", before, after)
+	}
+
+	for _, ch := range chunks[:30] {
+		checkDeleted(t, s, ch.Address())
+	}
+	for _, ch := range chunks[30:] {
+		checkData(t, s, ch)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// deleted chunks must not come back after compaction
+	s = newTestStore(t, dir, 8*1024)
+	defer s.Close()
+
+	for _, ch := range chunks[:30] {
+		checkDeleted(t, s, ch.Address())
+	}
+	for _, ch := range chunks[30:] {
+		checkData(t, s, ch)
+	}
+}
+
+// TestShardedCompactStoredAgain validates that compaction keeps the data of
+// chunks which were stored again after they were deleted.
+func TestShardedCompactStoredAgain(t *testing.T) {
+	dir := t.TempDir()
+	s, err := blobstore.NewSharded(dir, &blobstore.Options{
+		ShardBits:          1,
+		SegmentSize:        8 * 1024,
+		CompactionInterval: -1,
+		SyncWrites:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the chunks of every step are stored in the same shard
+	// so that they end up in consecutive segments
+	var chunks []penguin.Chunk
+	for len(chunks) < 5 {
+		ch := chunktesting.GenerateTestRandomChunk()
+		if ch.Address().Bytes()[0]&0x80 == 0 {
+			chunks = append(chunks, ch)
+		}
+	}
+	stored, others := chunks[0], chunks[1:]
+
+	put := func(chs ...penguin.Chunk) {
+		t.Helper()
+		for _, ch := range chs {
+			if err := s.Put(ch.Address(), ch.Data()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	del := func(chs ...penguin.Chunk) {
+		t.Helper()
+		for _, ch := range chs {
+			if err := s.Delete(ch.Address()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	put(stored, others[0], others[1])
+	del(stored, others[1])
+	put(stored, others[2], others[3])
+
+	if _, err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	checkData(t, s, stored)
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = newTestStore(t, dir, 8*1024)
+	defer s.Close()
+
+	checkData(t, s, stored)
+	checkData(t, s, others[0])
+	checkDeleted(t, s, others[1].Address())
+	checkData(t, s, others[2])
+	checkData(t, s, others[3])
+}
+
+func TestShardedTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, 1024*1024)
+
+	chunks := chunktesting.GenerateTestRandomChunks(10)
+	for _, ch := range chunks {
+		if err := s.Put(ch.Address(), ch.Data()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a partial write by appending garbage to every segment
+	segments, err := filepath.Glob(filepath.Join(dir, "*", "*.seg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, segment := range segments {
+		f, err := os.OpenFile(segment, os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte{1, 2, 3}); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s = newTestStore(t, dir, 1024*1024)
+	defer s.Close()
+
+	for _, ch := range chunks {
+		checkData(t, s, ch)
+	}
+
+	// new records are appended after the valid ones
+	ch := chunktesting.GenerateTestRandomChunk()
+	if err := s.Put(ch.Address(), ch.Data()); err != nil {
+		t.Fatal(err)
+	}
+	checkData(t, s, ch)
+}
+
+// TestShardedTornRecordHeader validates that a torn record header with
+// a large data length is treated as the end of the valid records
+// without allocating the length.
+func TestShardedTornRecordHeader(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, 1024*1024)
+
+	chunks := chunktesting.GenerateTestRandomChunks(10)
+	for _, ch := range chunks {
+		if err := s.Put(ch.Address(), ch.Data()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	size := segmentsSize(t, dir)
+
+	// a record header with an address, a length of almost
+	// 4GB and a checksum, followed by a part of the data
+	header := make([]byte, penguin.HashSize+4+4+100)
+	binary.BigEndian.PutUint32(header[penguin.HashSize:], 0xfffffff0)
+	segments, err := filepath.Glob(filepath.Join(dir, "*", "*.seg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, segment := range segments {
+		f, err := os.OpenFile(segment, os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(header); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	s = newTestStore(t, dir, 1024*1024)
+	defer s.Close()
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 100*1024*1024 {
+		t.Fatalf("allocated %d bytes to open the store", alloc)
+	}
+
+	if got := segmentsSize(t, dir); got != size {
+		t.Fatalf("got segments size %d, want %d", got, size)
+	}
+	for _, ch := range chunks {
+		checkData(t, s, ch)
+	}
+}
+
+func segmentsSize(t *testing.T, dir string) (size int64) {
+	t.Helper()
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*", "*.seg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, segment := range segments {
+		info, err := os.Stat(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+	return size
+}
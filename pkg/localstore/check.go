@@ -0,0 +1,758 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/cac"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/postage"
+	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/penguintop/penguin/pkg/soc"
+	"github.com/penguintop/penguin/pkg/storage"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// ErrCheckRunning is returned if a database check is started while
+// another one is still running.
+var ErrCheckRunning = errors.New("database check already running")
+
+// number of repairs written to the database in a single batch
+const checkRepairBatchSize = 1000
+
+// CheckOptions configures the database check.
+type CheckOptions struct {
+	// Repair fixes the found problems. Chunks with invalid or missing
+	// data are removed, orphaned index entries and chunk data are deleted,
+	// missing index entries are added and the gc size is recounted.
+	// Chunks with invalid stamps are only reported.
+	Repair bool
+	// ValidStamp validates chunk stamps if set.
+	ValidStamp func(chunk penguin.Chunk, stampBytes []byte) (penguin.Chunk, error)
+	// Rate limits the number of checked entries per second, so that the
+	// check can run on a live node. Zero means no limit.
+	Rate int
+}
+
+// CheckReport holds the results of the database check.
+type CheckReport struct {
+	// number of checked chunks
+	Chunks int64 `json:"chunks"`
+	// chunks whose data is missing or does not match their address
+	InvalidChunks int64 `json:"invalidChunks"`
+	// chunks whose stamp is not valid
+	InvalidStamps int64 `json:"invalidStamps"`
+	// entries per index which refer to chunks that are not stored
+	OrphanedEntries map[string]int64 `json:"orphanedEntries"`
+	// entries per index which are missing for stored chunks
+	MissingEntries map[string]int64 `json:"missingEntries"`
+	// chunk data in the blob store without a stored chunk
+	OrphanedData int64 `json:"orphanedData"`
+	// gc size counter and the actual number of gc index entries
+	GCSize      uint64 `json:"gcSize"`
+	GCIndexSize uint64 `json:"gcIndexSize"`
+	// number of fixed problems
+	Repaired int64 `json:"repaired"`
+}
+
+// Consistent reports whether the check found no problems.
+// Invalid stamps are not taken into account.
+func (r *CheckReport) Consistent() bool {
+	if r.InvalidChunks > 0 || r.OrphanedData > 0 || r.GCSize != r.GCIndexSize {
+		return false
+	}
+	for _, n := range r.OrphanedEntries {
+		if n > 0 {
+			return false
+		}
+	}
+	for _, n := range r.MissingEntries {
+		if n > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckStatus describes the last database check started in the background.
+type CheckStatus struct {
+	Running  bool       `json:"running"`
+	Repair   bool       `json:"repair"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// Error is set if the check failed.
+	Error string `json:"error,omitempty"`
+	// Report is set once the check finished, it holds the results up to
+	// the failure of a failed check.
+	Report *CheckReport `json:"report,omitempty"`
+}
+
+// StartCheck starts the database check in the background. Its progress can
+// be followed with CheckStatus. The check is stopped when the database is
+// closed.
+func (db *DB) StartCheck(o *CheckOptions) error {
+	if !atomic.CompareAndSwapInt32(&db.checkRunning, 0, 1) {
+		return ErrCheckRunning
+	}
+	if o == nil {
+		o = new(CheckOptions)
+	}
+
+	db.checkMu.Lock()
+	db.checkStatus = &CheckStatus{
+		Running: true,
+		Repair:  o.Repair,
+		Started: time.Now(),
+	}
+	db.checkMu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	db.checkWG.Add(1)
+	go func() {
+		defer db.checkWG.Done()
+		defer cancel()
+		go func() {
+			select {
+			case <-db.close:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		report, err := db.check(ctx, o)
+
+		db.checkMu.Lock()
+		defer db.checkMu.Unlock()
+		finished := time.Now()
+		db.checkStatus.Running = false
+		db.checkStatus.Finished = &finished
+		db.checkStatus.Report = report
+		if err != nil {
+			db.logger.Debugf("localstore check: %v", err)
+			db.checkStatus.Error = err.Error()
+		}
+		// allow the next check only once the status is final
+		atomic.StoreInt32(&db.checkRunning, 0)
+	}()
+	return nil
+}
+
+// CheckStatus returns the status of the last database check started in the
+// background, or nil if no check was started.
+func (db *DB) CheckStatus() *CheckStatus {
+	db.checkMu.Lock()
+	defer db.checkMu.Unlock()
+
+	if db.checkStatus == nil {
+		return nil
+	}
+	status := *db.checkStatus
+	return &status
+}
+
+// Check verifies the consistency of the database and optionally repairs it.
+// Chunk addresses are recomputed from their data, all indexes are checked
+// against the retrieval data index and the gc size is compared with the
+// number of gc index entries. The check may run while the database is in
+// use, repairs are done under the batch lock after the problem is confirmed
+// again.
+func (db *DB) Check(ctx context.Context, o *CheckOptions) (*CheckReport, error) {
+	if !atomic.CompareAndSwapInt32(&db.checkRunning, 0, 1) {
+		return nil, ErrCheckRunning
+	}
+	defer atomic.StoreInt32(&db.checkRunning, 0)
+
+	if o == nil {
+		o = new(CheckOptions)
+	}
+	return db.check(ctx, o)
+}
+
+func (db *DB) check(ctx context.Context, o *CheckOptions) (*CheckReport, error) {
+	c := &checker{
+		db:       db,
+		ctx:      ctx,
+		o:        o,
+		throttle: newCheckThrottle(o.Rate),
+		report: &CheckReport{
+			OrphanedEntries: make(map[string]int64),
+			MissingEntries:  make(map[string]int64),
+		},
+	}
+
+	if err := c.checkChunks(); err != nil {
+		return c.report, err
+	}
+	for _, i := range []struct {
+		name  string
+		index shed.Index
+	}{
+		{"retrievalAccessIndex", db.retrievalAccessIndex},
+		{"pushIndex", db.pushIndex},
+		{"pullIndex", db.pullIndex},
+		{"gcIndex", db.gcIndex},
+		{"pinIndex", db.pinIndex},
+		{"userPinIndex", db.userPinIndex},
+		{"postageChunksIndex", db.postageChunksIndex},
+		{"accessStatsIndex", db.accessStatsIndex},
+	} {
+		if err := c.checkIndex(i.name, i.index); err != nil {
+			return c.report, err
+		}
+	}
+	if err := c.checkBlobs(); err != nil {
+		return c.report, err
+	}
+	if err := c.checkGCSize(); err != nil {
+		return c.report, err
+	}
+	return c.report, nil
+}
+
+// decodeRetrievalDataHeader decodes the retrieval data index value.
+// The chunk data is only set if it is kept inline.
+func decodeRetrievalDataHeader(value []byte) (e shed.Item, err error) {
+	if len(value) < retrievalDataHeaderSize {
+		return e, errors.New("retrieval data too short")
+	}
+	e.StoreTimestamp = int64(binary.BigEndian.Uint64(value[8:16]))
+	e.BinID = binary.BigEndian.Uint64(value[:8])
+	stamp := new(postage.Stamp)
+	if err = stamp.UnmarshalBinary(value[16:retrievalDataHeaderSize]); err != nil {
+		return e, err
+	}
+	e.BatchID = stamp.BatchID()
+	e.Sig = stamp.Sig()
+	if len(value) > retrievalDataHeaderSize {
+		e.Data = value[retrievalDataHeaderSize:]
+	}
+	return e, nil
+}
+
+type checker struct {
+	db       *DB
+	ctx      context.Context
+	o        *CheckOptions
+	throttle *checkThrottle
+	report   *CheckReport
+}
+
+// checkChunks validates the data and stamps of all stored chunks and
+// checks that they are in the postage chunks index.
+func (c *checker) checkChunks() error {
+	var invalid, missing []shed.Item
+
+	err := c.db.retrievalHeaderIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		if err := c.throttle.wait(c.ctx); err != nil {
+			return true, err
+		}
+		c.report.Chunks++
+
+		data := item.Data
+		if data == nil && c.db.blobs != nil {
+			data, err = c.db.blobs.Get(penguin.NewAddress(item.Address))
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return true, err
+			}
+		}
+		ch := penguin.NewChunk(penguin.NewAddress(item.Address), data)
+		if !cac.Valid(ch) && !soc.Valid(ch) {
+			c.report.InvalidChunks++
+			c.db.logger.Debugf("localstore check: invalid chunk %x", item.Address)
+			if c.o.Repair {
+				invalid = append(invalid, item)
+				if len(invalid) >= checkRepairBatchSize {
+					if err := c.removeChunks(invalid); err != nil {
+						return true, err
+					}
+					invalid = nil
+				}
+			}
+			return false, nil
+		}
+
+		if c.o.ValidStamp != nil {
+			stamp, err := postage.NewStamp(item.BatchID, item.Sig).MarshalBinary()
+			if err != nil {
+				return true, err
+			}
+			if _, err := c.o.ValidStamp(ch, stamp); err != nil {
+				c.report.InvalidStamps++
+				c.db.logger.Debugf("localstore check: invalid stamp of chunk %x: %v", item.Address, err)
+			}
+		}
+
+		m, _, err := c.missingEntries(item)
+		if err != nil {
+			return true, err
+		}
+		if m.any() {
+			m.count(c.report.MissingEntries)
+			if c.o.Repair {
+				missing = append(missing, item)
+				if len(missing) >= checkRepairBatchSize {
+					if err := c.addEntries(missing); err != nil {
+						return true, err
+					}
+					missing = nil
+				}
+			}
+		}
+		return false, nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := c.removeChunks(invalid); err != nil {
+		return err
+	}
+	return c.addEntries(missing)
+}
+
+// missingChunkEntries marks the index entries missing for a stored chunk.
+type missingChunkEntries struct {
+	postage bool
+	pull    bool
+	access  bool
+	gc      bool
+}
+
+func (m missingChunkEntries) any() bool {
+	return m.postage || m.pull || m.access || m.gc
+}
+
+// count adds the missing entries to the counts per index.
+func (m missingChunkEntries) count(counts map[string]int64) {
+	for _, e := range []struct {
+		name    string
+		missing bool
+	}{
+		{"postageChunksIndex", m.postage},
+		{"pullIndex", m.pull},
+		{"retrievalAccessIndex", m.access},
+		{"gcIndex", m.gc},
+	} {
+		if e.missing {
+			counts[e.name]++
+		}
+	}
+}
+
+// missingEntries finds the index entries missing for the stored chunk and
+// returns its access timestamp if it has one. Chunks which are not synced
+// yet are in the push index and have no access and gc entries. Pinned
+// chunks are not garbage collected. Only chunks which are not synced yet or
+// preserved in the reserve are known to be in the pull index, as chunks
+// stored in the cache are not. The gc entry is keyed by the access
+// timestamp, so it is not checked if the access entry is missing.
+func (c *checker) missingEntries(item shed.Item) (m missingChunkEntries, accessTimestamp int64, err error) {
+	db := c.db
+
+	has, err := db.postageChunksIndex.Has(item)
+	if err != nil {
+		return m, 0, err
+	}
+	m.postage = !has
+
+	unsynced, err := db.pushIndex.Has(item)
+	if err != nil {
+		return m, 0, err
+	}
+	// chunks with more pins than the user pins have the pin of the reserve
+	pin, err := db.pinIndex.Get(item)
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return m, 0, err
+	}
+	userPin, err := db.userPinIndex.Get(item)
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return m, 0, err
+	}
+
+	if unsynced || pin.PinCounter > userPin.PinCounter {
+		has, err := db.pullIndex.Has(item)
+		if err != nil {
+			return m, 0, err
+		}
+		m.pull = !has
+	}
+	if unsynced {
+		return m, 0, nil
+	}
+
+	i, err := db.retrievalAccessIndex.Get(item)
+	if err != nil {
+		if !errors.Is(err, leveldb.ErrNotFound) {
+			return m, 0, err
+		}
+		m.access = true
+		return m, 0, nil
+	}
+	if pin.PinCounter == 0 {
+		item.AccessTimestamp = i.AccessTimestamp
+		has, err := db.gcIndex.Has(item)
+		if err != nil {
+			return m, 0, err
+		}
+		m.gc = !has
+	}
+	return m, i.AccessTimestamp, nil
+}
+
+// addEntries adds the missing index entries of chunks which are still
+// stored. Missing access entries get the timestamp of the gc entry of the
+// chunk, or the current time and a new gc entry if it has none. The gc size
+// is recounted by the check afterwards.
+func (c *checker) addEntries(items []shed.Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	gcTimestamps, err := c.gcAccessTimestamps(items)
+	if err != nil {
+		return err
+	}
+
+	db := c.db
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+	defer db.discardQuotas()
+
+	batch := new(leveldb.Batch)
+	var count int64
+	for _, item := range items {
+		has, err := c.db.retrievalHeaderIndex.Has(item)
+		if err != nil {
+			return err
+		}
+		if !has {
+			continue
+		}
+		m, accessTimestamp, err := c.missingEntries(item)
+		if err != nil {
+			return err
+		}
+		if m.postage {
+			if err := db.postageChunksIndex.PutInBatch(batch, item); err != nil {
+				return err
+			}
+			if err := db.quotaStore(item.BatchID, false); err != nil {
+				return err
+			}
+			count++
+		}
+		if m.pull {
+			if err := db.pullIndex.PutInBatch(batch, item); err != nil {
+				return err
+			}
+			count++
+		}
+		if m.access {
+			accessTimestamp = now()
+			pinned, err := db.pinIndex.Has(item)
+			if err != nil {
+				return err
+			}
+			m.gc = !pinned
+			if ts, ok := gcTimestamps[string(item.Address)]; ok && !pinned {
+				item.AccessTimestamp = ts
+				has, err := db.gcIndex.Has(item)
+				if err != nil {
+					return err
+				}
+				if has {
+					accessTimestamp = ts
+					m.gc = false
+				}
+			}
+			item.AccessTimestamp = accessTimestamp
+			if err := db.retrievalAccessIndex.PutInBatch(batch, item); err != nil {
+				return err
+			}
+			count++
+		}
+		if m.gc {
+			item.AccessTimestamp = accessTimestamp
+			if err := db.gcIndex.PutInBatch(batch, item); err != nil {
+				return err
+			}
+			count++
+		}
+	}
+	if err := db.shed.WriteBatch(batch); err != nil {
+		return err
+	}
+	db.commitQuotas()
+	c.report.Repaired += count
+	return nil
+}
+
+// removeChunks removes chunks which are still stored from all indexes.
+func (c *checker) removeChunks(items []shed.Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	db := c.db
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+	defer db.discardQuotas()
+
+	batch := new(leveldb.Batch)
+	var gcSizeChange int64
+	var removed []penguin.Address
+	for _, item := range items {
+		has, err := c.db.retrievalHeaderIndex.Has(item)
+		if err != nil {
+			return err
+		}
+		if !has {
+			continue
+		}
+		change, err := db.setRemove(batch, item, true)
+		if err != nil {
+			return err
+		}
+		// unsynced chunks would otherwise leave orphaned push index entries
+		if err := db.pushIndex.DeleteInBatch(batch, item); err != nil {
+			return err
+		}
+		gcSizeChange += change
+		removed = append(removed, penguin.NewAddress(item.Address))
+	}
+	if db.gcRunning {
+		db.dirtyAddresses = append(db.dirtyAddresses, removed...)
+	}
+	if err := db.incGCSizeInBatch(batch, gcSizeChange); err != nil {
+		return err
+	}
+	if err := db.shed.WriteBatch(batch); err != nil {
+		return err
+	}
+	db.commitQuotas()
+	db.deleteChunkData(removed)
+	c.report.Repaired += int64(len(removed))
+	return nil
+}
+
+// gcAccessTimestamps finds the access timestamps in the gc index entries of
+// the chunks whose access entries are missing.
+func (c *checker) gcAccessTimestamps(items []shed.Item) (map[string]int64, error) {
+	missing := make(map[string]struct{})
+	for _, item := range items {
+		has, err := c.db.retrievalAccessIndex.Has(item)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			missing[string(item.Address)] = struct{}{}
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+
+	timestamps := make(map[string]int64)
+	err := c.db.gcIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		if _, ok := missing[string(item.Address)]; ok {
+			timestamps[string(item.Address)] = item.AccessTimestamp
+		}
+		return false, nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return timestamps, nil
+}
+
+// checkIndex finds index entries of chunks which are not stored.
+func (c *checker) checkIndex(name string, index shed.Index) error {
+	var orphaned []shed.Item
+
+	err := index.Iterate(func(item shed.Item) (stop bool, err error) {
+		if err := c.throttle.wait(c.ctx); err != nil {
+			return true, err
+		}
+		has, err := c.db.retrievalHeaderIndex.Has(item)
+		if err != nil {
+			return true, err
+		}
+		if has {
+			return false, nil
+		}
+		c.report.OrphanedEntries[name]++
+		if c.o.Repair {
+			orphaned = append(orphaned, item)
+			if len(orphaned) >= checkRepairBatchSize {
+				if err := c.deleteEntries(index, orphaned); err != nil {
+					return true, err
+				}
+				orphaned = nil
+			}
+		}
+		return false, nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return c.deleteEntries(index, orphaned)
+}
+
+// deleteEntries deletes index entries of chunks which are still not stored.
+func (c *checker) deleteEntries(index shed.Index, items []shed.Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	db := c.db
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	batch := new(leveldb.Batch)
+	var count int64
+	for _, item := range items {
+		has, err := c.db.retrievalHeaderIndex.Has(item)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if err := index.DeleteInBatch(batch, item); err != nil {
+			return err
+		}
+		count++
+	}
+	if err := db.shed.WriteBatch(batch); err != nil {
+		return err
+	}
+	c.report.Repaired += count
+	return nil
+}
+
+// checkBlobs finds chunk data in the blob store without a stored chunk.
+// Such data is left behind if a batch fails after the data is stored.
+func (c *checker) checkBlobs() error {
+	if c.db.blobs == nil {
+		return nil
+	}
+	var orphaned []penguin.Address
+	err := c.db.blobs.Iterate(func(addr penguin.Address) (stop bool, err error) {
+		if err := c.throttle.wait(c.ctx); err != nil {
+			return true, err
+		}
+		has, err := c.db.retrievalHeaderIndex.Has(addressToItem(addr))
+		if err != nil {
+			return true, err
+		}
+		if !has {
+			c.report.OrphanedData++
+			if c.o.Repair {
+				orphaned = append(orphaned, addr)
+			}
+		}
+		return false, nil
+	})
+	if err != nil || len(orphaned) == 0 {
+		return err
+	}
+
+	// blobs are put under the batch lock before the chunk is indexed
+	c.db.batchMu.Lock()
+	defer c.db.batchMu.Unlock()
+	for _, addr := range orphaned {
+		has, err := c.db.retrievalHeaderIndex.Has(addressToItem(addr))
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if err := c.db.blobs.Delete(addr); err != nil {
+			return err
+		}
+		c.report.Repaired++
+	}
+	return nil
+}
+
+// checkGCSize compares the gc size counter with the number of gc index
+// entries and resets the counter if they differ.
+func (c *checker) checkGCSize() error {
+	gcSize, indexSize, err := c.countGC()
+	if err != nil {
+		return err
+	}
+	c.report.GCSize, c.report.GCIndexSize = gcSize, indexSize
+	if gcSize == indexSize || !c.o.Repair {
+		return nil
+	}
+
+	// recount under the lock, as the gc index may have changed
+	c.db.batchMu.Lock()
+	defer c.db.batchMu.Unlock()
+	gcSize, indexSize, err = c.countGC()
+	if err != nil {
+		return err
+	}
+	if gcSize == indexSize {
+		return nil
+	}
+	if err := c.db.gcSize.Put(indexSize); err != nil {
+		return err
+	}
+	c.db.metrics.GCSize.Set(float64(indexSize))
+	c.report.Repaired++
+	return nil
+}
+
+func (c *checker) countGC() (gcSize, indexSize uint64, err error) {
+	gcSize, err = c.db.gcSize.Get()
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return 0, 0, err
+	}
+	count, err := c.db.gcIndex.Count()
+	if err != nil {
+		return 0, 0, err
+	}
+	return gcSize, uint64(count), nil
+}
+
+// checkThrottle spreads the checked entries evenly over time.
+type checkThrottle struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newCheckThrottle(rate int) *checkThrottle {
+	t := new(checkThrottle)
+	if rate > 0 {
+		t.interval = time.Second / time.Duration(rate)
+		t.next = time.Now()
+	}
+	return t
+}
+
+func (t *checkThrottle) wait(ctx context.Context) error {
+	if t.interval == 0 {
+		return ctx.Err()
+	}
+	t.next = t.next.Add(t.interval)
+	d := time.Until(t.next)
+	if d <= 0 {
+		// do not catch up after slow entries
+		t.next = time.Now()
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
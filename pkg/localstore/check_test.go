@@ -0,0 +1,302 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/penguintop/penguin/pkg/storage"
+	chunktesting "github.com/penguintop/penguin/pkg/storage/testing"
+)
+
+// TestCheck validates that the database check finds and repairs
+// invalid chunks, orphaned and missing index entries and gc size drift.
+func TestCheck(t *testing.T) {
+	db := newTestDB(t, nil)
+	ctx := context.Background()
+
+	chunks := generateValidTestChunks(10)
+	invalid := chunktesting.GenerateTestRandomInvalidChunk()
+	_, err := db.Put(ctx, storage.ModePutUpload, append(chunks, invalid)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := db.Check(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.InvalidChunks != 1 || report.Chunks != 11 {
+		t.Fatalf("got %d invalid of %d chunks, want 1 of 11", report.InvalidChunks, report.Chunks)
+	}
+
+	orphan := shed.Item{Address: generateTestRandomChunk().Address().Bytes(), BinID: 1000}
+	if err := db.pullIndex.Put(orphan); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.postageChunksIndex.Delete(chunkToItem(chunks[0])); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.gcSize.Put(100); err != nil {
+		t.Fatal(err)
+	}
+
+	stampErr := errors.New("invalid stamp")
+	o := &CheckOptions{
+		ValidStamp: func(ch penguin.Chunk, stamp []byte) (penguin.Chunk, error) {
+			if ch.Address().Equal(chunks[1].Address()) {
+				return nil, stampErr
+			}
+			return ch, nil
+		},
+	}
+	report, err = db.Check(ctx, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Consistent() {
+		t.Fatal("inconsistent database reported as consistent")
+	}
+	if report.InvalidStamps != 1 {
+		t.Fatalf("got %d invalid stamps, want 1", report.InvalidStamps)
+	}
+	if n := report.OrphanedEntries["pullIndex"]; n != 1 {
+		t.Fatalf("got %d orphaned pull index entries, want 1", n)
+	}
+	if n := report.MissingEntries["postageChunksIndex"]; n != 1 {
+		t.Fatalf("got %d missing postage chunks index entries, want 1", n)
+	}
+	if report.GCSize != 100 || report.GCIndexSize != 0 {
+		t.Fatalf("got gc size %d with %d entries, want 100 with 0", report.GCSize, report.GCIndexSize)
+	}
+	if report.Repaired != 0 {
+		t.Fatalf("got %d repairs without repair", report.Repaired)
+	}
+
+	o.Repair = true
+	report, err = db.Check(ctx, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// invalid chunk, orphaned entry, missing entry and gc size
+	if report.Repaired != 4 {
+		t.Fatalf("got %d repairs, want 4", report.Repaired)
+	}
+
+	report, err = db.Check(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Consistent() {
+		t.Fatalf("database not consistent after repair: %+v", report)
+	}
+	if report.Chunks != 10 {
+		t.Fatalf("got %d chunks, want 10", report.Chunks)
+	}
+	_, err = db.Get(ctx, storage.ModeGetRequest, invalid.Address())
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+// TestCheckMissingEntries validates that the database check finds and
+// repairs missing pull, retrieval access and gc index entries.
+func TestCheckMissingEntries(t *testing.T) {
+	t.Cleanup(setWithinRadiusFunc(func(_ *DB, _ shed.Item) bool { return false }))
+	db := newTestDB(t, nil)
+	ctx := context.Background()
+
+	chunks := generateValidTestChunks(4)
+	unreserveChunkBatch(t, db, 0, chunks...)
+	_, err := db.Put(ctx, storage.ModePutUpload, chunks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := make([]penguin.Address, 3)
+	for i := range addrs {
+		addrs[i] = chunks[i].Address()
+	}
+	if err := db.Set(ctx, storage.ModeSetSync, addrs...); err != nil {
+		t.Fatal(err)
+	}
+
+	// the synced chunk has no gc entry
+	item, err := db.retrievalAccessIndex.Get(addressToItem(chunks[0].Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := db.retrievalHeaderIndex.Get(addressToItem(chunks[0].Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	item.BinID = header.BinID
+	if err := db.gcIndex.Delete(item); err != nil {
+		t.Fatal(err)
+	}
+	// the synced chunk has no access entry
+	if err := db.retrievalAccessIndex.Delete(addressToItem(chunks[1].Address())); err != nil {
+		t.Fatal(err)
+	}
+	// the unsynced chunk has no pull entry
+	header, err = db.retrievalHeaderIndex.Get(addressToItem(chunks[3].Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.pullIndex.Delete(header); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := db.Check(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"gcIndex", "retrievalAccessIndex", "pullIndex"} {
+		if n := report.MissingEntries[name]; n != 1 {
+			t.Errorf("got %d missing %s entries, want 1", n, name)
+		}
+	}
+
+	report, err = db.Check(ctx, &CheckOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the missing access entry is restored from the gc entry
+	if report.Repaired != 3 {
+		t.Fatalf("got %d repairs, want 3", report.Repaired)
+	}
+
+	report, err = db.Check(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Consistent() {
+		t.Fatalf("database not consistent after repair: %+v", report)
+	}
+	if report.GCIndexSize != 3 {
+		t.Fatalf("got %d gc index entries, want 3", report.GCIndexSize)
+	}
+}
+
+// TestStartCheck validates that the database check runs in the background
+// and reports its status.
+func TestStartCheck(t *testing.T) {
+	db, err := New("", make([]byte, 32), nil, logging.New(ioutil.Discard, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if status := db.CheckStatus(); status != nil {
+		t.Fatalf("got status %+v before a check was started", status)
+	}
+
+	_, err = db.Put(ctx, storage.ModePutUpload, generateValidTestChunks(10)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.StartCheck(&CheckOptions{Repair: true}); err != nil {
+		t.Fatal(err)
+	}
+	var status *CheckStatus
+	for i := 0; ; i++ {
+		status = db.CheckStatus()
+		if !status.Running {
+			break
+		}
+		if i == 100 {
+			t.Fatal("check still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Error != "" || status.Finished == nil || !status.Repair {
+		t.Fatalf("got status %+v", status)
+	}
+	if status.Report.Chunks != 10 || !status.Report.Consistent() {
+		t.Fatalf("got report %+v", status.Report)
+	}
+
+	// a slow check is stopped when the database is closed
+	if err := db.StartCheck(&CheckOptions{Rate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.StartCheck(nil); !errors.Is(err, ErrCheckRunning) {
+		t.Fatalf("got error %v, want %v", err, ErrCheckRunning)
+	}
+	if _, err := db.Check(ctx, nil); !errors.Is(err, ErrCheckRunning) {
+		t.Fatalf("got error %v, want %v", err, ErrCheckRunning)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	status = db.CheckStatus()
+	if status.Running || status.Error == "" {
+		t.Fatalf("got status %+v after close", status)
+	}
+}
+
+// TestCheckBlobStore validates that the database check finds chunks with
+// missing data and data without chunks in the blob store.
+func TestCheckBlobStore(t *testing.T) {
+	blobs := newTestBlobStore(t, t.TempDir())
+	db := newTestDB(t, &Options{BlobStore: blobs})
+	ctx := context.Background()
+
+	chunks := generateValidTestChunks(10)
+	_, err := db.Put(ctx, storage.ModePutUpload, chunks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Delete(chunks[0].Address()); err != nil {
+		t.Fatal(err)
+	}
+	orphan := generateTestRandomChunk()
+	if err := blobs.Put(orphan.Address(), orphan.Data()); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := db.Check(ctx, &CheckOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.InvalidChunks != 1 || report.OrphanedData != 1 {
+		t.Fatalf("got %d invalid chunks and %d orphaned data, want 1 and 1", report.InvalidChunks, report.OrphanedData)
+	}
+	if report.Repaired != 2 {
+		t.Fatalf("got %d repairs, want 2", report.Repaired)
+	}
+
+	report, err = db.Check(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Consistent() {
+		t.Fatalf("database not consistent after repair: %+v", report)
+	}
+	has, err := blobs.Has(orphan.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Fatal("orphaned chunk data not removed")
+	}
+}
+
+// generateValidTestChunks generates content addressed chunks, as
+// generateTestRandomChunks returns chunks with random addresses.
+func generateValidTestChunks(count int) []penguin.Chunk {
+	chunks := make([]penguin.Chunk, count)
+	for i := range chunks {
+		chunks[i] = generateTestRandomChunk()
+	}
+	return chunks
+}
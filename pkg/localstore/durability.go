@@ -0,0 +1,261 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/storage"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// DurabilityMode defines when writes of Put and Set calls are synced
+// to the disk.
+type DurabilityMode string
+
+const (
+	// DurabilityDefault writes every Put and Set call without syncing.
+	// Writes survive a process crash, but not a system crash.
+	DurabilityDefault DurabilityMode = ""
+	// DurabilitySync syncs every Put and Set call before it returns.
+	DurabilitySync DurabilityMode = "sync"
+	// DurabilityBatched syncs Put and Set calls before they return, but
+	// concurrent calls are written together with a single sync.
+	DurabilityBatched DurabilityMode = "batched"
+	// DurabilityUnsafe never syncs to the disk and stores imported chunks
+	// in larger batches. It is meant for bulk loading of data, as the
+	// database may be corrupted if the system crashes.
+	DurabilityUnsafe DurabilityMode = "unsafe"
+)
+
+// ParseDurabilityMode returns the durability mode with the name.
+func ParseDurabilityMode(name string) (DurabilityMode, error) {
+	switch m := DurabilityMode(name); m {
+	case DurabilityDefault, DurabilitySync, DurabilityBatched, DurabilityUnsafe:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown durability mode %q", name)
+}
+
+// writeBatch writes the batch of a Put or Set call as required by the
+// durability mode. In the batched mode the writes are synced by the
+// group commit. This function must be called under batchMu lock.
+func (db *DB) writeBatch(batch *leveldb.Batch) error {
+	if db.durability == DurabilitySync {
+		// chunk data is put to the blob store before the batch
+		// is written, so it must be synced first
+		if db.blobs != nil {
+			if err := db.blobs.Sync(); err != nil {
+				return err
+			}
+		}
+		return db.shed.WriteBatchSync(batch)
+	}
+	return db.shed.WriteBatch(batch)
+}
+
+// syncWrites syncs all writes to the disk by syncing the blob store and
+// writing a synced batch, as the LevelDB journal is synced up to its last
+// record.
+func (db *DB) syncWrites() error {
+	db.metrics.GroupCommit.Inc()
+	defer totalTimeMetric(db.metrics.TotalTimeGroupCommit, time.Now())
+
+	if db.blobs != nil {
+		if err := db.blobs.Sync(); err != nil {
+			return err
+		}
+	}
+	batch := new(leveldb.Batch)
+	db.syncMarker.PutInBatch(batch, uint64(now()))
+	return db.shed.WriteBatchSync(batch)
+}
+
+// commitRequest is a Put or Set call waiting for the group commit.
+type commitRequest struct {
+	set     bool
+	putMode storage.ModePut
+	setMode storage.ModeSet
+	chs     []penguin.Chunk
+	addrs   []penguin.Address
+
+	exist []bool
+	err   error
+	// signals that the request is committed or that
+	// it should commit the queued requests
+	signal    chan struct{}
+	committed bool
+}
+
+// groupCommit queues concurrent Put and Set calls. The first queued call
+// commits the calls queued before it with a single sync, while the calls
+// queued in the meantime are committed by the next one.
+type groupCommit struct {
+	mu      sync.Mutex
+	queue   []*commitRequest
+	leading bool
+}
+
+func newGroupCommit() *groupCommit {
+	return new(groupCommit)
+}
+
+// groupPut stores the chunks with the group commit.
+func (db *DB) groupPut(mode storage.ModePut, chs ...penguin.Chunk) (exist []bool, err error) {
+	r := &commitRequest{putMode: mode, chs: chs}
+	db.commits.submit(r, db.commitGroup)
+	return r.exist, r.err
+}
+
+// groupSet updates the chunks with the group commit.
+func (db *DB) groupSet(mode storage.ModeSet, addrs ...penguin.Address) (err error) {
+	r := &commitRequest{set: true, setMode: mode, addrs: addrs}
+	db.commits.submit(r, db.commitGroup)
+	return r.err
+}
+
+// submit queues the request and returns when it is committed.
+func (g *groupCommit) submit(r *commitRequest, commit func([]*commitRequest)) {
+	r.signal = make(chan struct{}, 1)
+
+	g.mu.Lock()
+	g.queue = append(g.queue, r)
+	if g.leading {
+		g.mu.Unlock()
+		<-r.signal
+		if r.committed {
+			return
+		}
+		// promoted to commit the queued requests
+		g.mu.Lock()
+	}
+	g.leading = true
+	reqs := g.queue
+	g.queue = nil
+	g.mu.Unlock()
+
+	commit(reqs)
+
+	g.mu.Lock()
+	for _, q := range reqs {
+		q.committed = true
+		if q != r {
+			q.signal <- struct{}{}
+		}
+	}
+	if len(g.queue) > 0 {
+		// the first request queued during the commit is the next leader
+		g.queue[0].signal <- struct{}{}
+	} else {
+		g.leading = false
+	}
+	g.mu.Unlock()
+}
+
+// commitGroup writes the requests and syncs all their writes at once.
+// Adjacent requests of the same mode and with distinct chunks are written
+// in a single batch. Requests of a failed batch are written separately,
+// so that the error is returned only to the requests which caused it.
+func (db *DB) commitGroup(reqs []*commitRequest) {
+	for i := 0; i < len(reqs); {
+		addrs := make(map[string]struct{})
+		addCommitAddresses(addrs, reqs[i])
+		j := i + 1
+		for j < len(reqs) && sameCommitMode(reqs[i], reqs[j]) && !hasCommitAddress(addrs, reqs[j]) {
+			addCommitAddresses(addrs, reqs[j])
+			j++
+		}
+		if j-i == 1 || db.commitMerged(reqs[i:j]) != nil {
+			for _, r := range reqs[i:j] {
+				db.commitRequest(r)
+			}
+		}
+		i = j
+	}
+
+	if err := db.syncWrites(); err != nil {
+		for _, r := range reqs {
+			if r.err == nil {
+				r.err = err
+			}
+		}
+	}
+}
+
+func sameCommitMode(a, b *commitRequest) bool {
+	if a.set != b.set {
+		return false
+	}
+	if a.set {
+		return a.setMode == b.setMode
+	}
+	return a.putMode == b.putMode
+}
+
+// commitAddresses returns the addresses of the chunks of the request.
+func (r *commitRequest) commitAddresses() []penguin.Address {
+	if r.set {
+		return r.addrs
+	}
+	addrs := make([]penguin.Address, len(r.chs))
+	for i, ch := range r.chs {
+		addrs[i] = ch.Address()
+	}
+	return addrs
+}
+
+func addCommitAddresses(addrs map[string]struct{}, r *commitRequest) {
+	for _, addr := range r.commitAddresses() {
+		addrs[addr.ByteString()] = struct{}{}
+	}
+}
+
+// hasCommitAddress reports whether the request has any of the addresses.
+// Requests with the same chunk are not merged, as a single batch does not
+// behave like the requests written one after another, for example two pins
+// would increment the pin counter only once.
+func hasCommitAddress(addrs map[string]struct{}, r *commitRequest) bool {
+	for _, addr := range r.commitAddresses() {
+		if _, ok := addrs[addr.ByteString()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// commitMerged writes the requests of the same mode in a single batch.
+func (db *DB) commitMerged(reqs []*commitRequest) error {
+	if reqs[0].set {
+		var addrs []penguin.Address
+		for _, r := range reqs {
+			addrs = append(addrs, r.addrs...)
+		}
+		return db.set(reqs[0].setMode, addrs...)
+	}
+
+	var chs []penguin.Chunk
+	for _, r := range reqs {
+		chs = append(chs, r.chs...)
+	}
+	exist, err := db.put(reqs[0].putMode, chs...)
+	if err != nil {
+		return err
+	}
+	for _, r := range reqs {
+		r.exist, exist = exist[:len(r.chs)], exist[len(r.chs):]
+	}
+	return nil
+}
+
+func (db *DB) commitRequest(r *commitRequest) {
+	if r.set {
+		r.err = db.set(r.setMode, r.addrs...)
+		return
+	}
+	r.exist, r.err = db.put(r.putMode, r.chs...)
+}
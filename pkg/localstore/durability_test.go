@@ -0,0 +1,336 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/storage"
+)
+
+var durabilityModes = []DurabilityMode{
+	DurabilityDefault,
+	DurabilitySync,
+	DurabilityBatched,
+	DurabilityUnsafe,
+}
+
+// TestDurabilityModes validates that chunks put in every durability
+// mode are stored when the database is opened again.
+func TestDurabilityModes(t *testing.T) {
+	for _, mode := range durabilityModes {
+		t.Run(fmt.Sprintf("mode %q", mode), func(t *testing.T) {
+			dir := t.TempDir()
+			baseKey := make([]byte, 32)
+			logger := logging.New(ioutil.Discard, 0)
+			ctx := context.Background()
+
+			db, err := New(dir, baseKey, &Options{Durability: mode}, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunks := generateTestRandomChunks(10)
+			var wg sync.WaitGroup
+			errs := make(chan error, len(chunks))
+			for _, ch := range chunks {
+				wg.Add(1)
+				go func(ch penguin.Chunk) {
+					defer wg.Done()
+					_, err := db.Put(ctx, storage.ModePutUpload, ch)
+					errs <- err
+				}(ch)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := db.Set(ctx, storage.ModeSetPin, chunks[0].Address()); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			db, err = New(dir, baseKey, &Options{Durability: mode}, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			for _, ch := range chunks {
+				got, err := db.Get(ctx, storage.ModeGetLookup, ch.Address())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Data(), ch.Data()) {
+					t.Fatalf("got chunk %s data %x, want %x", ch.Address(), got.Data(), ch.Data())
+				}
+			}
+			if _, err := db.Get(ctx, storage.ModeGetPin, chunks[0].Address()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	if _, err := New("", nil, &Options{Durability: "unknown"}, logging.New(ioutil.Discard, 0)); err == nil {
+		t.Fatal("database opened with an unknown durability mode")
+	}
+}
+
+// TestGroupCommit validates that requests queued during a commit
+// are committed together by the next commit.
+func TestGroupCommit(t *testing.T) {
+	g := newGroupCommit()
+
+	var groups [][]*commitRequest
+	started := make(chan struct{})
+	release := make(chan struct{})
+	commit := func(reqs []*commitRequest) {
+		groups = append(groups, reqs)
+		if len(groups) == 1 {
+			close(started)
+			<-release
+		}
+	}
+
+	leaderDone := make(chan struct{})
+	go func() {
+		g.submit(new(commitRequest), commit)
+		close(leaderDone)
+	}()
+	<-started
+
+	const followers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < followers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.submit(new(commitRequest), commit)
+		}()
+	}
+	// wait for the followers to be queued
+	for {
+		g.mu.Lock()
+		n := len(g.queue)
+		g.mu.Unlock()
+		if n == followers {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-leaderDone
+	wg.Wait()
+
+	if len(groups) != 2 {
+		t.Fatalf("got %d commits, want 2", len(groups))
+	}
+	if n := len(groups[1]); n != followers {
+		t.Fatalf("got %d requests in the second commit, want %d", n, followers)
+	}
+}
+
+// TestGroupCommitPut validates that chunks put concurrently in the
+// batched durability mode are written with less syncs than puts and
+// that errors are returned only to the failed puts.
+func TestGroupCommitPut(t *testing.T) {
+	db := newTestDB(t, &Options{Durability: DurabilityBatched})
+	ctx := context.Background()
+
+	chunks := generateTestRandomChunks(100)
+	var wg sync.WaitGroup
+	errs := make(chan error, len(chunks))
+	for _, ch := range chunks {
+		wg.Add(1)
+		go func(ch penguin.Chunk) {
+			defer wg.Done()
+			_, err := db.Put(ctx, storage.ModePutUpload, ch)
+			errs <- err
+		}(ch)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ch := range chunks {
+		if _, err := db.Get(ctx, storage.ModeGetLookup, ch.Address()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a failed request does not fail the requests committed with it
+	reqs := []*commitRequest{
+		{chs: chunks[:1]},
+		{set: true, setMode: storage.ModeSetRemove, addrs: []penguin.Address{generateTestRandomChunk().Address()}},
+		{set: true, setMode: storage.ModeSetRemove, addrs: []penguin.Address{chunks[1].Address()}},
+	}
+	db.commitGroup(reqs)
+	if reqs[0].err != nil || !reqs[0].exist[0] {
+		t.Fatalf("got put error %v and exist %v, want no error and existing chunk", reqs[0].err, reqs[0].exist)
+	}
+	if reqs[1].err == nil {
+		t.Fatal("removal of a missing chunk succeeded")
+	}
+	if reqs[2].err != nil {
+		t.Fatal(reqs[2].err)
+	}
+	_, err := db.Get(ctx, storage.ModeGetLookup, chunks[1].Address())
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+// TestGroupCommitSameChunk validates that requests of the same chunk
+// committed together behave as if they were committed one after another.
+func TestGroupCommitSameChunk(t *testing.T) {
+	db := newTestDB(t, &Options{Durability: DurabilityBatched})
+	ctx := context.Background()
+
+	ch := generateTestRandomChunk()
+	other := generateTestRandomChunk()
+	reqs := []*commitRequest{
+		{chs: []penguin.Chunk{ch}},
+		{chs: []penguin.Chunk{ch, other}},
+	}
+	db.commitGroup(reqs)
+	for i, r := range reqs {
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if r.exist[0] != (i == 1) {
+			t.Fatalf("got exist %v for put %d", r.exist[0], i)
+		}
+	}
+
+	reqs = []*commitRequest{
+		{set: true, setMode: storage.ModeSetPin, addrs: []penguin.Address{ch.Address()}},
+		{set: true, setMode: storage.ModeSetPin, addrs: []penguin.Address{other.Address()}},
+		{set: true, setMode: storage.ModeSetPin, addrs: []penguin.Address{ch.Address()}},
+	}
+	db.commitGroup(reqs)
+	for _, r := range reqs {
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+	}
+	if _, err := db.Get(ctx, storage.ModeGetLookup, ch.Address()); err != nil {
+		t.Fatal(err)
+	}
+	pinCounter, err := db.pinCounter(ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinCounter != 2 {
+		t.Fatalf("got pin counter %d, want 2", pinCounter)
+	}
+}
+
+// BenchmarkPutDurability compares uploading chunks to a database on the
+// disk in durability modes with different numbers of parallel uploads.
+//
+// go test -benchmem -run=none github.com/penguintop/penguin/pkg/localstore -bench BenchmarkPutDurability -v
+func BenchmarkPutDurability(b *testing.B) {
+	for _, mode := range durabilityModes {
+		for _, maxParallelUploads := range []int{1, 16, 64} {
+			name := fmt.Sprintf("mode %q parallel %v", mode, maxParallelUploads)
+			b.Run(name, func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					benchmarkPutDurability(b, mode, 1000, maxParallelUploads)
+				}
+			})
+		}
+	}
+}
+
+// benchmarkPutDurability uploads a number of chunks with specified
+// max parallel uploads to a database on the disk.
+func benchmarkPutDurability(b *testing.B, mode DurabilityMode, count, maxParallelUploads int) {
+	b.StopTimer()
+	db, err := New(b.TempDir(), make([]byte, 32), &Options{Durability: mode}, logging.New(ioutil.Discard, 0))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	chunks := generateTestRandomChunks(count)
+	errs := make(chan error)
+	b.StartTimer()
+
+	go func() {
+		sem := make(chan struct{}, maxParallelUploads)
+		for i := 0; i < count; i++ {
+			sem <- struct{}{}
+
+			go func(i int) {
+				defer func() { <-sem }()
+
+				_, err := db.Put(context.Background(), storage.ModePutUpload, chunks[i])
+				errs <- err
+			}(i)
+		}
+	}()
+
+	for i := 0; i < count; i++ {
+		if err := <-errs; err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+}
+
+// BenchmarkImportDurability compares importing chunks to a database on
+// the disk in durability modes.
+//
+// go test -benchmem -run=none github.com/penguintop/penguin/pkg/localstore -bench BenchmarkImportDurability -v
+func BenchmarkImportDurability(b *testing.B) {
+	ctx := context.Background()
+
+	src := newTestDB(b, nil)
+	if _, err := src.Put(ctx, storage.ModePutUpload, generateTestRandomChunks(10000)...); err != nil {
+		b.Fatal(err)
+	}
+	var export bytes.Buffer
+	if _, err := src.Export(ctx, &export, nil); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, mode := range durabilityModes {
+		b.Run(fmt.Sprintf("mode %q", mode), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				db, err := New(b.TempDir(), make([]byte, 32), &Options{Durability: mode}, logging.New(ioutil.Discard, 0))
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				if _, err := db.Import(ctx, bytes.NewReader(export.Bytes()), nil); err != nil {
+					b.Fatal(err)
+				}
+
+				b.StopTimer()
+				if err := db.Close(); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -18,16 +18,26 @@ package localstore
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
-	"sync"
+	"sort"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/penguintop/penguin/pkg/cac"
 	"github.com/penguintop/penguin/pkg/postage"
 	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/penguintop/penguin/pkg/soc"
 	"github.com/penguintop/penguin/pkg/storage"
+	"github.com/penguintop/penguin/pkg/traversal"
     "github.com/penguintop/penguin/pkg/penguin"
 )
 
@@ -35,179 +45,519 @@ const (
 	// filename in tar archive that holds the information
 	// about exported data format version
 	exportVersionFilename = ".penguin-export-version"
+	// filename in tar archive that holds the export manifest
+	exportManifestFilename = ".penguin-export-manifest"
 	// current export format version
-	currentExportVersion = "2"
+	currentExportVersion = "3"
+	// export format version without checksums and manifest
+	legacyExportVersion = "2"
+
+	// tar header record holding the checksum of a chunk entry
+	exportChecksumRecord = "PENGUIN.sha256"
+
+	// number of chunks stored in a single put during import
+	importBatchSize = 100
+	// number of chunks stored in a single put during import
+	// in the unsafe durability mode
+	importBulkBatchSize = 5000
 )
 
-// Export writes a tar structured data to the writer of
-// all chunks in the retrieval data index. It returns the
-// number of chunks exported.
-func (db *DB) Export(w io.Writer) (count int64, err error) {
-	tw := tar.NewWriter(w)
-	defer tw.Close()
+// zstdMagic are the first bytes of a zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
 
-	if err := tw.WriteHeader(&tar.Header{
-		Name: exportVersionFilename,
-		Mode: 0644,
-		Size: int64(len(currentExportVersion)),
-	}); err != nil {
-		return 0, err
+var (
+	// ErrInvalidChecksum is returned by Import if an exported
+	// chunk does not match its checksum.
+	ErrInvalidChecksum = errors.New("invalid chunk checksum")
+	// ErrInvalidExport is returned by Import if the imported
+	// chunks do not match the export manifest.
+	ErrInvalidExport = errors.New("export does not match manifest")
+	// ErrCursorNotFound is returned by Import if the
+	// import cursor is not one of the imported chunks.
+	ErrCursorNotFound = errors.New("import cursor not found")
+
+	// errStopExport stops the traversal of the chunks
+	// reachable from the root once the limit is reached.
+	errStopExport = errors.New("stop export")
+)
+
+// ExportOptions selects the chunks written by Export. Chunks are
+// exported in the order of their addresses, chunks of the bins in
+// the order of the bins and of their bin IDs, and chunks reachable
+// from the root in the order of the traversal.
+type ExportOptions struct {
+	// BatchID limits the export to chunks stamped by the postage batch.
+	BatchID []byte
+	// Bins limits the export to chunks in the pull index, which holds
+	// the reserve, in the proximity order bins.
+	Bins []uint8
+	// Pinned limits the export to pinned chunks.
+	Pinned bool
+	// Root limits the export to chunks reachable from the reference.
+	Root penguin.Address
+	// Cursor resumes an export after the chunk with the address.
+	Cursor penguin.Address
+	// Limit is the maximal number of exported chunks, 0 means no limit.
+	Limit int64
+	// Compress compresses the export with zstd.
+	Compress bool
+}
+
+// ExportResult describes a finished export.
+type ExportResult struct {
+	// Count is the number of exported chunks.
+	Count int64
+	// Cursor is the address of the last exported chunk. It is passed
+	// to the next export if the export is not complete.
+	Cursor penguin.Address
+	// Complete is false if the export stopped at the limit.
+	Complete bool
+}
+
+// exportManifest is written as the last file of an export. Checksum is the
+// hash of the checksums of all exported chunks in order.
+type exportManifest struct {
+	Version  string `json:"version"`
+	Count    int64  `json:"count"`
+	Cursor   string `json:"cursor,omitempty"`
+	Complete bool   `json:"complete"`
+	Checksum string `json:"checksum"`
+}
+
+// Export writes a tar structured data to the writer of the chunks
+// in the retrieval data index selected by the options. Every chunk
+// entry holds the checksum of its content and a manifest of the
+// whole export is written last.
+func (db *DB) Export(ctx context.Context, w io.Writer, o *ExportOptions) (result *ExportResult, err error) {
+	if o == nil {
+		o = new(ExportOptions)
 	}
-	if _, err := tw.Write([]byte(currentExportVersion)); err != nil {
-		return 0, err
+
+	if o.Compress {
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if e := zw.Close(); e != nil && err == nil {
+				err = e
+			}
+		}()
+		w = zw
 	}
 
-	err = db.retrievalDataIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+	tw := tar.NewWriter(w)
+	defer func() {
+		if e := tw.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	if err := writeTarFile(tw, exportVersionFilename, []byte(currentExportVersion)); err != nil {
+		return nil, err
+	}
 
-		hdr := &tar.Header{
-			Name: hex.EncodeToString(item.Address),
-			Mode: 0644,
-			Size: int64(postage.StampSize + len(item.Data)),
+	var (
+		manifest = exportManifest{
+			Version:  currentExportVersion,
+			Complete: true,
 		}
+		digest = sha256.New()
+		bins   = make(map[uint8]struct{}, len(o.Bins))
+		cursor penguin.Address
+	)
+	for _, bin := range o.Bins {
+		bins[bin] = struct{}{}
+	}
 
-		if err := tw.WriteHeader(hdr); err != nil {
-			return false, err
+	exportItem := func(item shed.Item) (stop bool, err error) {
+		if err := ctx.Err(); err != nil {
+			return true, err
+		}
+		if o.BatchID != nil && !bytes.Equal(item.BatchID, o.BatchID) {
+			return false, nil
 		}
-		if _, err := tw.Write(item.BatchID); err != nil {
-			return false, err
+		if len(bins) > 0 {
+			if _, ok := bins[db.po(penguin.NewAddress(item.Address))]; !ok {
+				return false, nil
+			}
+		}
+		if o.Pinned {
+			pinned, err := db.pinIndex.Has(item)
+			if err != nil {
+				return true, err
+			}
+			if !pinned {
+				return false, nil
+			}
 		}
-		if _, err := tw.Write(item.Sig); err != nil {
-			return false, err
+		if o.Limit > 0 && manifest.Count >= o.Limit {
+			manifest.Complete = false
+			return true, nil
 		}
-		if _, err := tw.Write(item.Data); err != nil {
-			return false, err
+
+		content := make([]byte, 0, postage.StampSize+len(item.Data))
+		content = append(content, item.BatchID...)
+		content = append(content, item.Sig...)
+		content = append(content, item.Data...)
+		checksum := sha256.Sum256(content)
+
+		if err := tw.WriteHeader(&tar.Header{
+			Name:       hex.EncodeToString(item.Address),
+			Mode:       0644,
+			Size:       int64(len(content)),
+			Format:     tar.FormatPAX,
+			PAXRecords: map[string]string{exportChecksumRecord: hex.EncodeToString(checksum[:])},
+		}); err != nil {
+			return true, err
 		}
-		count++
+		if _, err := tw.Write(content); err != nil {
+			return true, err
+		}
+		_, _ = digest.Write(checksum[:])
+
+		manifest.Count++
+		cursor = penguin.NewAddress(item.Address)
 		return false, nil
-	}, nil)
+	}
 
-	return count, err
+	switch {
+	case !o.Root.IsZero():
+		err = db.exportReachable(ctx, o.Root, o.Cursor, exportItem)
+	case len(o.Bins) > 0:
+		err = db.exportBins(o.Bins, o.Cursor, exportItem)
+	default:
+		var iterOpts *shed.IterateOptions
+		if !o.Cursor.IsZero() {
+			iterOpts = &shed.IterateOptions{
+				StartFrom:         &shed.Item{Address: o.Cursor.Bytes()},
+				SkipStartFromItem: true,
+			}
+		}
+		err = db.retrievalDataIndex.Iterate(exportItem, iterOpts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !cursor.IsZero() {
+		manifest.Cursor = cursor.String()
+	}
+	manifest.Checksum = hex.EncodeToString(digest.Sum(nil))
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTarFile(tw, exportManifestFilename, manifestData); err != nil {
+		return nil, err
+	}
+
+	return &ExportResult{
+		Count:    manifest.Count,
+		Cursor:   cursor,
+		Complete: manifest.Complete,
+	}, nil
 }
 
-// Import reads a tar structured data from the reader and
-// stores chunks in the database. It returns the number of
-// chunks imported.
-func (db *DB) Import(ctx context.Context, r io.Reader) (count int64, err error) {
-	tr := tar.NewReader(r)
-
-	errC := make(chan error)
-	doneC := make(chan struct{})
-	tokenPool := make(chan struct{}, 100)
-	var wg sync.WaitGroup
-	go func() {
-		var (
-			firstFile = true
-
-			// if exportVersionFilename file is not present
-			// assume current version
-			version = currentExportVersion
-		)
-		for {
-			hdr, err := tr.Next()
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				select {
-				case errC <- err:
-				case <-ctx.Done():
-				}
+// exportReachable calls fn in the traversal order for the chunks which can
+// be reached from the root reference, without keeping their addresses. The
+// order of the traversal does not change, so the export is resumed after
+// the first occurrence of the cursor. Consecutive references to the same
+// chunk, like the ones to the chunks of equal data, are exported once, the
+// chunks referenced from elsewhere again.
+func (db *DB) exportReachable(ctx context.Context, root, cursor penguin.Address, fn shed.IndexIterFunc) error {
+	resumed := cursor.IsZero()
+	last := cursor
+	err := traversal.New(db).Traverse(ctx, root, func(addr penguin.Address) error {
+		if !resumed {
+			resumed = addr.Equal(cursor)
+			return nil
+		}
+		if addr.Equal(last) {
+			return nil
+		}
+		last = addr
+
+		item, err := db.retrievalDataIndex.Get(addressToItem(addr))
+		if err != nil {
+			return fmt.Errorf("chunk %s: %w", addr, err)
+		}
+		stop, err := fn(item)
+		if err != nil {
+			return err
+		}
+		if stop {
+			return errStopExport
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopExport) {
+		return fmt.Errorf("traverse %s: %w", root, err)
+	}
+	return nil
+}
+
+// exportBins calls fn for the chunks in the pull index of the bins, in the
+// order of the bins and of the bin IDs, after the chunk with the cursor
+// address.
+func (db *DB) exportBins(bins []uint8, cursor penguin.Address, fn shed.IndexIterFunc) error {
+	bins = append([]uint8(nil), bins...)
+	sort.Slice(bins, func(i, j int) bool {
+		return bins[i] < bins[j]
+	})
+
+	var start *shed.Item
+	if !cursor.IsZero() {
+		item, err := db.retrievalDataIndex.Get(addressToItem(cursor))
+		if err != nil {
+			return fmt.Errorf("cursor chunk %s: %w", cursor, err)
+		}
+		start = &shed.Item{Address: item.Address, BinID: item.BinID}
+	}
+
+	var stopped bool
+	for i, bin := range bins {
+		if stopped {
+			return nil
+		}
+		if i > 0 && bin == bins[i-1] {
+			continue
+		}
+		iterOpts := &shed.IterateOptions{Prefix: []byte{bin}}
+		if start != nil {
+			switch po := db.po(penguin.NewAddress(start.Address)); {
+			case bin < po:
+				continue
+			case bin == po:
+				iterOpts.StartFrom = start
+				iterOpts.SkipStartFromItem = true
 			}
-			if firstFile {
-				firstFile = false
-				if hdr.Name == exportVersionFilename {
-					data, err := ioutil.ReadAll(tr)
-					if err != nil {
-						select {
-						case errC <- err:
-						case <-ctx.Done():
-						}
-					}
-					version = string(data)
-					continue
-				}
+		}
+		err := db.pullIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+			item, err = db.retrievalDataIndex.Get(item)
+			if err != nil {
+				return true, fmt.Errorf("chunk %s: %w", penguin.NewAddress(item.Address), err)
 			}
+			stopped, err = fn(item)
+			return stopped, err
+		}, iterOpts)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			if len(hdr.Name) != 64 {
-				db.logger.Warningf("localstore export: ignoring non-chunk file: %s", hdr.Name)
-				continue
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: int64(len(data)),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// ImportOptions configures Import.
+type ImportOptions struct {
+	// Cursor resumes an import after the chunk with the address. Chunks
+	// are skipped in the order of the imported data until the chunk with
+	// the cursor address, as exports are not always ordered by address.
+	Cursor penguin.Address
+}
+
+// ImportResult describes an import.
+type ImportResult struct {
+	// Count is the number of imported chunks, including chunks which
+	// were already stored.
+	Count int64
+	// Existing is the number of imported chunks which were already stored.
+	Existing int64
+	// Cursor is the address of the last stored chunk. It is passed to the
+	// next import to resume an interrupted one.
+	Cursor penguin.Address
+	// Verified is true if the chunks matched the export manifest.
+	Verified bool
+}
+
+// Import reads a tar structured data, optionally compressed with zstd,
+// from the reader and stores chunks in the database. Chunks which are
+// already stored are skipped, so an interrupted import can be repeated
+// or resumed from the cursor of the returned result, which is also
+// returned together with an error.
+func (db *DB) Import(ctx context.Context, r io.Reader, o *ImportOptions) (result *ImportResult, err error) {
+	if o == nil {
+		o = new(ImportOptions)
+	}
+	result = new(ImportResult)
+
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return result, err
+	}
+	var in io.Reader = br
+	if bytes.Equal(magic, zstdMagic) {
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return result, err
+		}
+		defer zr.Close()
+		in = zr
+	}
+	tr := tar.NewReader(in)
+
+	batchSize := importBatchSize
+	if db.durability == DurabilityUnsafe {
+		batchSize = importBulkBatchSize
+	}
+
+	var (
+		// if exportVersionFilename file is not present
+		// assume legacy version
+		version   = legacyExportVersion
+		firstFile = true
+		resumed   = o.Cursor.IsZero()
+		digest    = sha256.New()
+		count     int64
+		batch     = make([]penguin.Chunk, 0, batchSize)
+		manifest  *exportManifest
+	)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		exist, err := db.Put(ctx, storage.ModePutUpload, batch...)
+		if err != nil {
+			return err
+		}
+		for _, e := range exist {
+			if e {
+				result.Existing++
 			}
+		}
+		result.Count += int64(len(batch))
+		result.Cursor = batch[len(batch)-1].Address()
+		batch = batch[:0]
+		return nil
+	}
 
-			keybytes, err := hex.DecodeString(hdr.Name)
-			if err != nil {
-				db.logger.Warningf("localstore export: ignoring invalid chunk file %s: %v", hdr.Name, err)
-				continue
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
 			}
+			return result, err
+		}
 
-			rawdata, err := ioutil.ReadAll(tr)
-			if err != nil {
-				select {
-				case errC <- err:
-				case <-ctx.Done():
+		if firstFile {
+			firstFile = false
+			if hdr.Name == exportVersionFilename {
+				data, err := ioutil.ReadAll(tr)
+				if err != nil {
+					return result, err
+				}
+				version = string(data)
+				if version != currentExportVersion && version != legacyExportVersion {
+					return result, fmt.Errorf("unsupported export data version %q", version)
 				}
+				continue
 			}
-			stamp := new(postage.Stamp)
-			err = stamp.UnmarshalBinary(rawdata[:postage.StampSize])
+		}
+
+		if hdr.Name == exportManifestFilename {
+			data, err := ioutil.ReadAll(tr)
 			if err != nil {
-				select {
-				case errC <- err:
-				case <-ctx.Done():
-				}
+				return result, err
 			}
-			data := rawdata[postage.StampSize:]
-			key := penguin.NewAddress(keybytes)
-
-			var ch penguin.Chunk
-			switch version {
-			case currentExportVersion:
-				ch = penguin.NewChunk(key, data).WithStamp(stamp)
-			default:
-				select {
-				case errC <- fmt.Errorf("unsupported export data version %q", version):
-				case <-ctx.Done():
-				}
+			manifest = new(exportManifest)
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return result, fmt.Errorf("decode export manifest: %w", err)
 			}
-			tokenPool <- struct{}{}
-			wg.Add(1)
-
-			go func() {
-				_, err := db.Put(ctx, storage.ModePutUpload, ch)
-				select {
-				case errC <- err:
-				case <-ctx.Done():
-					wg.Done()
-					<-tokenPool
-				default:
-					_, err := db.Put(ctx, storage.ModePutUpload, ch)
-					if err != nil {
-						errC <- err
-					}
-					wg.Done()
-					<-tokenPool
-				}
-			}()
+			continue
+		}
 
-			count++
+		if len(hdr.Name) != 64 {
+			db.logger.Warningf("localstore import: ignoring non-chunk file: %s", hdr.Name)
+			continue
 		}
-		wg.Wait()
-		close(doneC)
-	}()
 
-	// wait for all chunks to be stored
-	for {
-		select {
-		case err := <-errC:
-			if err != nil {
-				return count, err
+		keybytes, err := hex.DecodeString(hdr.Name)
+		if err != nil {
+			db.logger.Warningf("localstore import: ignoring invalid chunk file %s: %v", hdr.Name, err)
+			continue
+		}
+		key := penguin.NewAddress(keybytes)
+
+		rawdata, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return result, err
+		}
+		if len(rawdata) < postage.StampSize {
+			return result, fmt.Errorf("chunk %s: %w", key, ErrInvalidChecksum)
+		}
+
+		if version == currentExportVersion {
+			if err := verifyChecksum(hdr, rawdata, digest); err != nil {
+				return result, fmt.Errorf("chunk %s: %w", key, err)
 			}
-		case <-ctx.Done():
-			return count, ctx.Err()
-		default:
-			select {
-			case <-doneC:
-				return count, nil
-			default:
+		}
+		count++
+
+		if !resumed {
+			resumed = key.Equal(o.Cursor)
+			continue
+		}
+
+		stamp := new(postage.Stamp)
+		if err := stamp.UnmarshalBinary(rawdata[:postage.StampSize]); err != nil {
+			return result, fmt.Errorf("chunk %s: %w", key, err)
+		}
+		ch := penguin.NewChunk(key, rawdata[postage.StampSize:]).WithStamp(stamp)
+		if !cac.Valid(ch) && !soc.Valid(ch) {
+			return result, fmt.Errorf("chunk %s: %w", key, penguin.ErrInvalidChunk)
+		}
+		batch = append(batch, ch)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return result, err
 			}
 		}
 	}
+	if err := flush(); err != nil {
+		return result, err
+	}
+	if !resumed {
+		return result, fmt.Errorf("cursor %s: %w", o.Cursor, ErrCursorNotFound)
+	}
+
+	if manifest != nil {
+		if manifest.Count != count || manifest.Checksum != hex.EncodeToString(digest.Sum(nil)) {
+			return result, ErrInvalidExport
+		}
+		result.Verified = true
+	}
+	return result, nil
+}
+
+// verifyChecksum checks the chunk content against the checksum in the tar
+// header and adds the checksum to the export digest.
+func verifyChecksum(hdr *tar.Header, content []byte, digest hash.Hash) error {
+	want, err := hex.DecodeString(hdr.PAXRecords[exportChecksumRecord])
+	if err != nil {
+		return err
+	}
+	got := sha256.Sum256(content)
+	if !bytes.Equal(got[:], want) {
+		return ErrInvalidChecksum
+	}
+	_, _ = digest.Write(got[:])
+	return nil
 }
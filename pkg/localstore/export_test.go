@@ -17,11 +17,20 @@
 package localstore
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"testing"
 
+	"github.com/penguintop/penguin/pkg/file/pipeline/builder"
+	postagetesting "github.com/penguintop/penguin/pkg/postage/testing"
 	"github.com/penguintop/penguin/pkg/storage"
+	"github.com/penguintop/penguin/pkg/traversal"
     "github.com/penguintop/penguin/pkg/penguin"
 )
 
@@ -50,23 +59,29 @@ func TestExportImport(t *testing.T) {
 
 	var buf bytes.Buffer
 
-	c, err := db1.Export(&buf)
+	exported, err := db1.Export(context.Background(), &buf, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	wantChunksCount := int64(len(chunks))
-	if c != wantChunksCount {
-		t.Errorf("got export count %v, want %v", c, wantChunksCount)
+	if exported.Count != wantChunksCount {
+		t.Errorf("got export count %v, want %v", exported.Count, wantChunksCount)
+	}
+	if !exported.Complete {
+		t.Error("export not complete")
 	}
 
 	db2 := newTestDB(t, nil)
 
-	c, err = db2.Import(context.Background(), &buf)
+	imported, err := db2.Import(context.Background(), &buf, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if c != wantChunksCount {
-		t.Errorf("got import count %v, want %v", c, wantChunksCount)
+	if imported.Count != wantChunksCount {
+		t.Errorf("got import count %v, want %v", imported.Count, wantChunksCount)
+	}
+	if !imported.Verified {
+		t.Error("import not verified against manifest")
 	}
 
 	for a, want := range chunks {
@@ -85,3 +100,351 @@ func TestExportImport(t *testing.T) {
 		}
 	}
 }
+
+// TestExportResume validates that an export split by a limit can be
+// imported piece by piece and that repeated imports are idempotent.
+func TestExportResume(t *testing.T) {
+	db1 := newTestDB(t, nil)
+
+	chunks := generateValidTestChunks(25)
+	_, err := db1.Put(context.Background(), storage.ModePutUpload, chunks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db2 := newTestDB(t, nil)
+
+	testExportResume(t, db1, db2, &ExportOptions{Limit: 10, Compress: true}, int64(len(chunks)))
+
+	for _, ch := range chunks {
+		has, err := db2.Has(context.Background(), ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Fatalf("chunk %s not imported", ch.Address())
+		}
+	}
+}
+
+// TestExportResumeRoot validates that the chunks reachable from
+// the root are exported in pieces and that the consecutive
+// references to the same chunk are exported once.
+func TestExportResumeRoot(t *testing.T) {
+	db1 := newTestDB(t, nil)
+
+	// six equal chunks of zeros and a chunk of random data
+	data := make([]byte, 6*penguin.ChunkSize+100)
+	_, _ = rand.Read(data[6*penguin.ChunkSize:])
+	pipe := builder.NewPipelineBuilder(context.Background(), stampPutter{db1}, storage.ModePutUpload, false)
+	root, err := builder.FeedPipeline(context.Background(), pipe, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unrelated chunks must not be exported
+	_, err = db1.Put(context.Background(), storage.ModePutUpload, generateValidTestChunks(5)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db2 := newTestDB(t, nil)
+
+	// the root, the chunk of zeros and the chunk of random data
+	testExportResume(t, db1, db2, &ExportOptions{Root: root, Limit: 1}, 3)
+
+	err = traversal.New(db2).Traverse(context.Background(), root, func(addr penguin.Address) error {
+		has, err := db2.Has(context.Background(), addr)
+		if err != nil {
+			return err
+		}
+		if !has {
+			return fmt.Errorf("chunk %s not imported", addr)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testExportResume exports the chunks selected by the options from db1
+// with the options limit until the export is complete, imports every
+// piece twice into db2 and validates the total number of chunks.
+func testExportResume(t *testing.T, db1, db2 *DB, opts *ExportOptions, want int64) {
+	t.Helper()
+
+	var (
+		cursor penguin.Address
+		total  int64
+	)
+	for i := int64(0); ; i++ {
+		if i > want/opts.Limit+1 {
+			t.Fatal("export did not complete")
+		}
+		o := *opts
+		o.Cursor = cursor
+		var buf bytes.Buffer
+		exported, err := db1.Export(context.Background(), &buf, &o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+
+		for j := 0; j < 2; j++ {
+			imported, err := db2.Import(context.Background(), bytes.NewReader(data), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if imported.Count != exported.Count {
+				t.Fatalf("got import count %v, want %v", imported.Count, exported.Count)
+			}
+			wantExisting := int64(0)
+			if j == 1 {
+				wantExisting = exported.Count
+			}
+			if imported.Existing != wantExisting {
+				t.Fatalf("got %v existing chunks, want %v", imported.Existing, wantExisting)
+			}
+		}
+
+		total += exported.Count
+		cursor = exported.Cursor
+		if exported.Complete {
+			break
+		}
+	}
+	if total != want {
+		t.Fatalf("exported %d chunks, want %d", total, want)
+	}
+}
+
+// TestExportResumeBins validates that the chunks of the bins are
+// exported in pieces across the bins.
+func TestExportResumeBins(t *testing.T) {
+	db1 := newTestDB(t, nil)
+
+	chunks := generateValidTestChunks(20)
+	_, err := db1.Put(context.Background(), storage.ModePutUpload, chunks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bins := []uint8{db1.po(chunks[0].Address()), db1.po(chunks[1].Address())}
+	var want int64
+	for _, ch := range chunks {
+		if po := db1.po(ch.Address()); po == bins[0] || po == bins[1] {
+			want++
+		}
+	}
+
+	db2 := newTestDB(t, nil)
+
+	testExportResume(t, db1, db2, &ExportOptions{Bins: bins, Limit: 1}, want)
+}
+
+// TestImportResume validates that an import is resumed after the cursor
+// in the order of the exported bins and of the exported traversal.
+func TestImportResume(t *testing.T) {
+	db := newTestDB(t, nil)
+
+	chunks := generateValidTestChunks(20)
+	_, err := db.Put(context.Background(), storage.ModePutUpload, chunks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bins := []uint8{db.po(chunks[0].Address()), db.po(chunks[1].Address())}
+
+	data := make([]byte, 10*penguin.ChunkSize)
+	_, _ = rand.Read(data)
+	pipe := builder.NewPipelineBuilder(context.Background(), stampPutter{db}, storage.ModePutUpload, false)
+	root, err := builder.FeedPipeline(context.Background(), pipe, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts *ExportOptions
+	}{
+		{name: "bins", opts: &ExportOptions{Bins: bins}},
+		{name: "root", opts: &ExportOptions{Root: root}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := db.Export(context.Background(), &buf, tc.opts); err != nil {
+				t.Fatal(err)
+			}
+			addrs := exportedAddresses(t, buf.Bytes())
+			if len(addrs) < 3 {
+				t.Fatalf("got %d exported chunks, want at least 3", len(addrs))
+			}
+			cursor := len(addrs) / 2
+
+			db2 := newTestDB(t, nil)
+			imported, err := db2.Import(context.Background(), bytes.NewReader(buf.Bytes()), &ImportOptions{Cursor: addrs[cursor]})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := int64(len(addrs) - cursor - 1); imported.Count != want {
+				t.Fatalf("got import count %v, want %v", imported.Count, want)
+			}
+			for i, addr := range addrs {
+				has, err := db2.Has(context.Background(), addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if has != (i > cursor) {
+					t.Fatalf("chunk %d of %d: got imported %v, want %v", i, len(addrs), has, i > cursor)
+				}
+			}
+		})
+	}
+
+	t.Run("cursor not found", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := db.Export(context.Background(), &buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		cursor := generateTestRandomChunk().Address()
+		_, err := newTestDB(t, nil).Import(context.Background(), &buf, &ImportOptions{Cursor: cursor})
+		if !errors.Is(err, ErrCursorNotFound) {
+			t.Fatalf("got error %v, want %v", err, ErrCursorNotFound)
+		}
+	})
+}
+
+// exportedAddresses returns the addresses of the
+// chunks in the uncompressed export data in order.
+func exportedAddresses(t *testing.T, data []byte) (addrs []penguin.Address) {
+	t.Helper()
+
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return addrs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hdr.Name) == 64 {
+			addrs = append(addrs, penguin.MustParseHexAddress(hdr.Name))
+		}
+	}
+}
+
+// stampPutter stamps the chunks put by the file pipeline.
+type stampPutter struct {
+	*DB
+}
+
+func (p stampPutter) Put(ctx context.Context, mode storage.ModePut, chs ...penguin.Chunk) ([]bool, error) {
+	for i, ch := range chs {
+		chs[i] = ch.WithStamp(postagetesting.MustNewStamp())
+	}
+	return p.DB.Put(ctx, mode, chs...)
+}
+
+// TestExportFilter validates that only chunks selected
+// by the export options are exported.
+func TestExportFilter(t *testing.T) {
+	db := newTestDB(t, nil)
+
+	chunks := generateValidTestChunks(20)
+	_, err := db.Put(context.Background(), storage.ModePutUpload, chunks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cached chunks are not in the pull index
+	// and must not be exported with the bins
+	cached := generateTestRandomChunkAt(penguin.NewAddress(db.baseKey), int(db.po(chunks[2].Address())))
+	_, err = db.Put(context.Background(), storage.ModePutRequestCache, cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Set(context.Background(), storage.ModeSetPin, chunks[0].Address(), chunks[1].Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bin := db.po(chunks[2].Address())
+	var inBin int64
+	for _, ch := range chunks {
+		if db.po(ch.Address()) == bin {
+			inBin++
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts *ExportOptions
+		want int64
+	}{
+		{name: "batch", opts: &ExportOptions{BatchID: chunks[3].Stamp().BatchID()}, want: 1},
+		{name: "pinned", opts: &ExportOptions{Pinned: true}, want: 2},
+		{name: "bin", opts: &ExportOptions{Bins: []uint8{bin}}, want: inBin},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := db.Export(context.Background(), ioutil.Discard, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exported.Count != tc.want {
+				t.Fatalf("got export count %v, want %v", exported.Count, tc.want)
+			}
+		})
+	}
+}
+
+// TestImportInvalidChunk validates that a chunk with
+// the address not matching its data is rejected on import.
+func TestImportInvalidChunk(t *testing.T) {
+	db1 := newTestDB(t, nil)
+
+	_, err := db1.Put(context.Background(), storage.ModePutUpload, generateTestRandomChunks(1)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	_, err = db1.Export(context.Background(), &buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db2 := newTestDB(t, nil)
+	_, err = db2.Import(context.Background(), &buf, nil)
+	if !errors.Is(err, penguin.ErrInvalidChunk) {
+		t.Fatalf("got error %v, want %v", err, penguin.ErrInvalidChunk)
+	}
+}
+
+// TestImportInvalidChecksum validates that corrupted
+// chunk data is rejected on import.
+func TestImportInvalidChecksum(t *testing.T) {
+	db1 := newTestDB(t, nil)
+
+	ch := generateTestRandomChunk()
+	_, err := db1.Put(context.Background(), storage.ModePutUpload, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	_, err = db1.Export(context.Background(), &buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	i := bytes.Index(data, ch.Data())
+	if i < 0 {
+		t.Fatal("chunk data not found in export")
+	}
+	data[i] ^= 0xff
+
+	db2 := newTestDB(t, nil)
+	_, err = db2.Import(context.Background(), bytes.NewReader(data), nil)
+	if !errors.Is(err, ErrInvalidChecksum) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidChecksum)
+	}
+}
@@ -107,32 +107,39 @@ func (db *DB) collectGarbage() (collectedCount uint64, done bool, err error) {
 	db.metrics.GCSize.Set(float64(gcSize))
 
 	done = true
+	var n uint64
+	if gcSize > target {
+		n = gcSize - target
+	}
+	if n > gcBatchSize {
+		// batch size limit reached, however we don't
+		// know whether another gc run is needed until
+		// we weed out the dirty entries below
+		n = gcBatchSize
+	}
+	window := db.gcWindow(int(n))
+
 	first := true
 	start := time.Now()
-	candidates := make([]shed.Item, 0)
-	err = db.gcIndex.Iterate(func(item shed.Item) (stop bool, err error) {
-		if first {
-			totalTimeMetric(db.metrics.TotalTimeGCFirstItem, start)
-			first = false
-		}
-		if gcSize-collectedCount <= target {
-			return true, nil
-		}
-
-		candidates = append(candidates, item)
-
-		collectedCount++
-		if collectedCount >= gcBatchSize {
-			// batch size limit reached, however we don't
-			// know whether another gc run is needed until
-			// we weed out the dirty entries below
-			return true, nil
+	items := make([]shed.Item, 0, window)
+	if window > 0 {
+		err = db.gcIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+			if first {
+				totalTimeMetric(db.metrics.TotalTimeGCFirstItem, start)
+				first = false
+			}
+			items = append(items, item)
+			return len(items) >= window, nil
+		}, nil)
+		if err != nil {
+			return 0, false, err
 		}
-		return false, nil
-	}, nil)
+	}
+	candidates, err := db.selectGarbage(items, int(n))
 	if err != nil {
 		return 0, false, err
 	}
+	collectedCount = uint64(len(candidates))
 	db.metrics.GCCollectedCounter.Add(float64(collectedCount))
 	if testHookGCIteratorDone != nil {
 		testHookGCIteratorDone()
@@ -142,6 +149,7 @@ func (db *DB) collectGarbage() (collectedCount uint64, done bool, err error) {
 	db.batchMu.Lock()
 	defer totalTimeMetric(db.metrics.TotalTimeGCLock, time.Now())
 	defer db.batchMu.Unlock()
+	defer db.discardQuotas()
 
 	// refresh gcSize value, since it might have
 	// changed in the meanwhile
@@ -151,6 +159,7 @@ func (db *DB) collectGarbage() (collectedCount uint64, done bool, err error) {
 	}
 
 	// get rid of dirty entries
+	removed := make([]penguin.Address, 0, len(candidates))
 	for _, item := range candidates {
 		if penguin.NewAddress(item.Address).MemberOf(db.dirtyAddresses) {
 			collectedCount--
@@ -181,6 +190,12 @@ func (db *DB) collectGarbage() (collectedCount uint64, done bool, err error) {
 		if err != nil {
 			return 0, false, err
 		}
+		err = db.accessStatsIndex.DeleteInBatch(batch, item)
+		if err != nil {
+			return 0, false, err
+		}
+		db.quotaRelease(item.BatchID, true, false)
+		removed = append(removed, penguin.NewAddress(item.Address))
 	}
 	if gcSize-collectedCount > target {
 		done = false
@@ -194,6 +209,9 @@ func (db *DB) collectGarbage() (collectedCount uint64, done bool, err error) {
 		db.metrics.GCErrorCounter.Inc()
 		return 0, false, err
 	}
+	db.commitQuotas()
+	db.deleteChunkData(removed)
+	db.gcStats.evict(db.gcPolicy.Name(), removed)
 	return collectedCount, done, nil
 }
 
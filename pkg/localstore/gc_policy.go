@@ -0,0 +1,502 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// GCPolicyLRU is the name of the least recently used policy.
+	GCPolicyLRU = "lru"
+	// GCPolicyCostAware is the name of the cost aware policy.
+	GCPolicyCostAware = "cost-aware"
+
+	// defaultCostAwareWindowFactor is the default number of candidates
+	// considered by the cost aware policy for every removed chunk.
+	defaultCostAwareWindowFactor = 4
+)
+
+var (
+	// gcGhostCapacity limits the number of recently removed chunks that are
+	// remembered for every policy in order to compute their cache hit rates.
+	gcGhostCapacity = 50000
+	// accessStatsHalfLife is the period after which the access counts and
+	// the revenue of chunks are halved, so that the policies favour the
+	// chunks which are requested now over the ones requested in the past.
+	accessStatsHalfLife = 24 * time.Hour
+	// revenueWriteBackInterval is the period after which the recorded
+	// revenue is written to the access stats index.
+	revenueWriteBackInterval = time.Second
+	// revenueWriteBackSize is the number of chunks with pending revenue
+	// which triggers a write back before the interval passes.
+	revenueWriteBackSize = 1000
+)
+
+// GCCandidate is a chunk in the garbage collection index which
+// may be removed.
+type GCCandidate struct {
+	Address         penguin.Address
+	BatchID         []byte
+	AccessTimestamp int64
+	// Proximity is the proximity order of the chunk to the node overlay.
+	Proximity uint8
+	// AccessCount is the number of times the chunk was requested.
+	AccessCount uint64
+	// Revenue is the amount earned from serving the chunk.
+	Revenue uint64
+}
+
+// GCPolicy selects the chunks which are removed by garbage collection.
+type GCPolicy interface {
+	// Name identifies the policy in metrics.
+	Name() string
+	// Window returns the number of least recently accessed chunks from
+	// which n chunks are selected for removal.
+	Window(n int) int
+	// Select returns the indexes of at most n candidates which are
+	// removed. Candidates are ordered from the least recently accessed.
+	Select(candidates []GCCandidate, n int) []int
+}
+
+type lruPolicy struct{}
+
+// NewLRUPolicy returns a policy which removes the least recently
+// accessed chunks.
+func NewLRUPolicy() GCPolicy {
+	return lruPolicy{}
+}
+
+func (lruPolicy) Name() string { return GCPolicyLRU }
+
+func (lruPolicy) Window(n int) int { return n }
+
+func (lruPolicy) Select(candidates []GCCandidate, n int) []int {
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	selected := make([]int, n)
+	for i := range selected {
+		selected[i] = i
+	}
+	return selected
+}
+
+// CostAwarePolicyOptions configures the cost aware policy. If all weights
+// are zero, every factor is weighted equally.
+type CostAwarePolicyOptions struct {
+	FrequencyWeight float64
+	ValueWeight     float64
+	ProximityWeight float64
+	RevenueWeight   float64
+	// WindowFactor is the number of candidates considered for
+	// every removed chunk.
+	WindowFactor int
+	// BatchValue returns the value of a postage batch. Chunks of
+	// batches whose value is unknown are considered worthless.
+	BatchValue func(batchID []byte) (*big.Int, error)
+}
+
+type costAwarePolicy struct {
+	o CostAwarePolicyOptions
+}
+
+// NewCostAwarePolicy returns a policy which scores the least recently
+// accessed chunks by their access frequency, the value of their postage
+// batch, their proximity to the node overlay and the revenue earned from
+// serving them, and removes the chunks with the lowest score.
+func NewCostAwarePolicy(o *CostAwarePolicyOptions) GCPolicy {
+	p := &costAwarePolicy{}
+	if o != nil {
+		p.o = *o
+	}
+	if p.o.FrequencyWeight == 0 && p.o.ValueWeight == 0 && p.o.ProximityWeight == 0 && p.o.RevenueWeight == 0 {
+		p.o.FrequencyWeight = 1
+		p.o.ValueWeight = 1
+		p.o.ProximityWeight = 1
+		p.o.RevenueWeight = 1
+	}
+	if p.o.WindowFactor <= 0 {
+		p.o.WindowFactor = defaultCostAwareWindowFactor
+	}
+	return p
+}
+
+func (p *costAwarePolicy) Name() string { return GCPolicyCostAware }
+
+func (p *costAwarePolicy) Window(n int) int { return n * p.o.WindowFactor }
+
+func (p *costAwarePolicy) Select(candidates []GCCandidate, n int) []int {
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+
+	values := p.batchValues(candidates)
+	var maxCount, maxRevenue uint64
+	maxValue := new(big.Int)
+	for i, c := range candidates {
+		if c.AccessCount > maxCount {
+			maxCount = c.AccessCount
+		}
+		if c.Revenue > maxRevenue {
+			maxRevenue = c.Revenue
+		}
+		if values[i].Cmp(maxValue) > 0 {
+			maxValue = values[i]
+		}
+	}
+
+	scores := make([]float64, len(candidates))
+	for i, c := range candidates {
+		var score float64
+		if maxCount > 0 {
+			score += p.o.FrequencyWeight * float64(c.AccessCount) / float64(maxCount)
+		}
+		if maxRevenue > 0 {
+			score += p.o.RevenueWeight * float64(c.Revenue) / float64(maxRevenue)
+		}
+		if maxValue.Sign() > 0 {
+			v, _ := new(big.Float).Quo(new(big.Float).SetInt(values[i]), new(big.Float).SetInt(maxValue)).Float64()
+			score += p.o.ValueWeight * v
+		}
+		score += p.o.ProximityWeight * float64(c.Proximity) / float64(penguin.MaxPO)
+		scores[i] = score
+	}
+
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	// equal scores are removed in the least recently accessed order
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] < scores[order[j]]
+	})
+	return order[:n]
+}
+
+// batchValues returns the values of the candidate batches, looking up
+// every batch once.
+func (p *costAwarePolicy) batchValues(candidates []GCCandidate) []*big.Int {
+	values := make([]*big.Int, len(candidates))
+	cache := make(map[string]*big.Int)
+	for i, c := range candidates {
+		v, ok := cache[string(c.BatchID)]
+		if !ok {
+			v = new(big.Int)
+			if p.o.BatchValue != nil {
+				if bv, err := p.o.BatchValue(c.BatchID); err == nil && bv != nil {
+					v = bv
+				}
+			}
+			cache[string(c.BatchID)] = v
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// gcCandidates converts gc index items to policy candidates.
+func (db *DB) gcCandidates(items []shed.Item) ([]GCCandidate, error) {
+	candidates := make([]GCCandidate, len(items))
+	t := now()
+	for i, item := range items {
+		stats, err := db.accessStatsIndex.Get(item)
+		if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+			return nil, err
+		}
+		stats = decayAccessStats(stats, t)
+		addr := penguin.NewAddress(item.Address)
+		candidates[i] = GCCandidate{
+			Address:         addr,
+			BatchID:         item.BatchID,
+			AccessTimestamp: item.AccessTimestamp,
+			Proximity:       db.po(addr),
+			AccessCount:     stats.AccessCount,
+			Revenue:         stats.Revenue,
+		}
+	}
+	return candidates, nil
+}
+
+// gcWindow returns the number of gc index items needed by the policies
+// to select n chunks.
+func (db *DB) gcWindow(n int) int {
+	window := db.gcPolicy.Window(n)
+	for _, p := range db.gcShadowPolicies {
+		if w := p.Window(n); w > window {
+			window = w
+		}
+	}
+	if window < n {
+		window = n
+	}
+	return window
+}
+
+// selectGarbage returns the items the garbage collection policy selected
+// for removal and records the selections of the shadow policies.
+func (db *DB) selectGarbage(items []shed.Item, n int) ([]shed.Item, error) {
+	candidates, err := db.gcCandidates(items)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := db.gcPolicy.Select(candidates, n)
+	if len(selected) == 0 && n > 0 {
+		// a policy must not stall garbage collection
+		selected = lruPolicy{}.Select(candidates, n)
+	}
+	garbage := make([]shed.Item, 0, len(selected))
+	for _, i := range selected {
+		garbage = append(garbage, items[i])
+	}
+
+	for _, p := range db.gcShadowPolicies {
+		addrs := make([]penguin.Address, 0, n)
+		for _, i := range p.Select(candidates, n) {
+			addrs = append(addrs, candidates[i].Address)
+		}
+		db.gcStats.evict(p.Name(), addrs)
+	}
+	return garbage, nil
+}
+
+// incAccessCountInBatch counts the requests of the chunk held by the item
+// AccessCount field, or a single request if it is not set.
+// This function must be called under batchMu lock.
+func (db *DB) incAccessCountInBatch(batch *leveldb.Batch, item shed.Item) error {
+	stats, err := db.accessStatsIndex.Get(item)
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return err
+	}
+	stats = decayAccessStats(stats, now())
+	stats.Address = item.Address
+	if item.AccessCount > 0 {
+		stats.AccessCount += item.AccessCount
+	} else {
+		stats.AccessCount++
+	}
+	return db.accessStatsIndex.PutInBatch(batch, stats)
+}
+
+// decayAccessStats halves the access count and the revenue of the stats
+// for every half-life passed from their AccessTimestamp until t. The
+// timestamp is advanced by whole half-lives only, so that frequent updates
+// do not defer the decay.
+func decayAccessStats(stats shed.Item, t int64) shed.Item {
+	if stats.AccessTimestamp == 0 {
+		stats.AccessTimestamp = t
+		return stats
+	}
+	halfLife := int64(accessStatsHalfLife)
+	halvings := (t - stats.AccessTimestamp) / halfLife
+	if halvings <= 0 {
+		return stats
+	}
+	if halvings >= 64 {
+		stats.AccessCount, stats.Revenue = 0, 0
+	} else {
+		stats.AccessCount >>= uint(halvings)
+		stats.Revenue >>= uint(halvings)
+	}
+	stats.AccessTimestamp += halvings * halfLife
+	return stats
+}
+
+// RecordRevenue adds the amount earned from serving the chunk
+// to its revenue considered by garbage collection policies.
+// The revenue is written to the database in batches.
+func (db *DB) RecordRevenue(addr penguin.Address, amount uint64) error {
+	db.revenueMu.Lock()
+	db.revenue[addr.ByteString()] += amount
+	pending := len(db.revenue)
+	db.revenueMu.Unlock()
+
+	if pending >= revenueWriteBackSize {
+		select {
+		case db.revenueWriteBackTrigger <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// revenueWriteBackWorker periodically writes the recorded revenue
+// and writes the remaining revenue when the database is closed.
+func (db *DB) revenueWriteBackWorker() {
+	defer close(db.revenueWorkerDone)
+
+	ticker := time.NewTicker(revenueWriteBackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-db.revenueWriteBackTrigger:
+		case <-db.close:
+			if err := db.writeBackRevenue(); err != nil {
+				db.logger.Errorf("localstore: revenue write back: %v", err)
+			}
+			return
+		}
+		if err := db.writeBackRevenue(); err != nil {
+			db.logger.Errorf("localstore: revenue write back: %v", err)
+		}
+	}
+}
+
+// writeBackRevenue adds the revenue recorded since the last write back
+// to the access stats of the stored chunks in a single batch.
+func (db *DB) writeBackRevenue() error {
+	db.revenueMu.Lock()
+	revenue := db.revenue
+	db.revenue = make(map[string]uint64)
+	db.revenueMu.Unlock()
+
+	if len(revenue) == 0 {
+		return nil
+	}
+
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	batch := new(leveldb.Batch)
+	t := now()
+	for addr, amount := range revenue {
+		item := shed.Item{Address: []byte(addr)}
+		has, err := db.retrievalDataIndex.Has(item)
+		if err != nil {
+			return err
+		}
+		if !has {
+			// the chunk was removed since it was served
+			continue
+		}
+		stats, err := db.accessStatsIndex.Get(item)
+		if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+			return err
+		}
+		stats = decayAccessStats(stats, t)
+		stats.Address = item.Address
+		stats.Revenue += amount
+		if err := db.accessStatsIndex.PutInBatch(batch, stats); err != nil {
+			return err
+		}
+	}
+	return db.shed.WriteBatch(batch)
+}
+
+// recordGCRequest updates the cache hit rate metrics of the garbage
+// collection policies for a requested chunk.
+func (db *DB) recordGCRequest(addr penguin.Address, found bool) {
+	db.metrics.GCPolicyRequests.Inc()
+	for _, name := range db.gcStats.hits(addr, found) {
+		db.metrics.GCPolicyHits.WithLabelValues(name).Inc()
+	}
+}
+
+// gcPolicyStats remembers the chunks recently removed by every policy.
+// Shadow policies would have found a chunk if it was found and they did
+// not select it, or if it was removed only by the active policy.
+type gcPolicyStats struct {
+	mu      sync.Mutex
+	active  string
+	evicted map[string]*ghostSet
+}
+
+func newGCPolicyStats(active GCPolicy, shadows []GCPolicy) *gcPolicyStats {
+	s := &gcPolicyStats{
+		active:  active.Name(),
+		evicted: map[string]*ghostSet{active.Name(): newGhostSet(gcGhostCapacity)},
+	}
+	for _, p := range shadows {
+		if _, ok := s.evicted[p.Name()]; !ok {
+			s.evicted[p.Name()] = newGhostSet(gcGhostCapacity)
+		}
+	}
+	return s
+}
+
+func (s *gcPolicyStats) evict(name string, addrs []penguin.Address) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.evicted[name]
+	if !ok {
+		return
+	}
+	for _, addr := range addrs {
+		g.add(addr.ByteString())
+	}
+}
+
+// hits returns the names of the policies under which the chunk would
+// have been found.
+func (s *gcPolicyStats) hits(addr penguin.Address, found bool) (names []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := addr.ByteString()
+	evictedByActive := s.evicted[s.active].has(key)
+	for name, g := range s.evicted {
+		if name == s.active {
+			if found {
+				names = append(names, name)
+			}
+			continue
+		}
+		if g.has(key) {
+			continue
+		}
+		if found || evictedByActive {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ghostSet is a set of addresses which forgets the oldest
+// address when its capacity is reached.
+type ghostSet struct {
+	keys  map[string]struct{}
+	order []string
+	next  int
+}
+
+func newGhostSet(capacity int) *ghostSet {
+	return &ghostSet{
+		keys:  make(map[string]struct{}),
+		order: make([]string, 0, capacity),
+	}
+}
+
+func (g *ghostSet) add(key string) {
+	if _, ok := g.keys[key]; ok {
+		return
+	}
+	if len(g.order) < cap(g.order) {
+		g.order = append(g.order, key)
+	} else if len(g.order) > 0 {
+		delete(g.keys, g.order[g.next])
+		g.order[g.next] = key
+		g.next = (g.next + 1) % len(g.order)
+	} else {
+		return
+	}
+	g.keys[key] = struct{}{}
+}
+
+func (g *ghostSet) has(key string) bool {
+	_, ok := g.keys[key]
+	return ok
+}
@@ -0,0 +1,263 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/penguintop/penguin/pkg/storage"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// TestCostAwarePolicySelect validates that the cost aware policy
+// selects the chunks with the lowest score.
+func TestCostAwarePolicySelect(t *testing.T) {
+	valuable := []byte{1}
+	p := NewCostAwarePolicy(&CostAwarePolicyOptions{
+		BatchValue: func(batchID []byte) (*big.Int, error) {
+			if string(batchID) == string(valuable) {
+				return big.NewInt(100), nil
+			}
+			return big.NewInt(1), nil
+		},
+	})
+
+	candidates := []GCCandidate{
+		{BatchID: valuable},              // valuable batch
+		{BatchID: []byte{2}, Revenue: 5}, // earns revenue
+		{BatchID: []byte{2}},             // worthless
+		{BatchID: []byte{2}, AccessCount: 10},
+		{BatchID: []byte{2}, Proximity: 8},
+	}
+	got := p.Select(candidates, 2)
+	if len(got) != 2 {
+		t.Fatalf("got %d selected, want 2", len(got))
+	}
+	if got[0] != 2 || got[1] != 4 {
+		t.Fatalf("got selected %v, want [2 4]", got)
+	}
+
+	got = NewLRUPolicy().Select(candidates, 2)
+	if len(got) != 2 || got[0] != 0 || got[1] != 1 {
+		t.Fatalf("got lru selected %v, want [0 1]", got)
+	}
+}
+
+// TestGCPolicyStats validates that shadow policies hit chunks which
+// only the active policy removed and miss chunks they would have removed.
+func TestGCPolicyStats(t *testing.T) {
+	active := NewLRUPolicy()
+	shadow := NewCostAwarePolicy(nil)
+	s := newGCPolicyStats(active, []GCPolicy{shadow})
+
+	a := penguin.MustParseHexAddress("0100000000000000000000000000000000000000000000000000000000000000")
+	b := penguin.MustParseHexAddress("0200000000000000000000000000000000000000000000000000000000000000")
+	c := penguin.MustParseHexAddress("0300000000000000000000000000000000000000000000000000000000000000")
+	s.evict(GCPolicyLRU, []penguin.Address{a})
+	s.evict(GCPolicyCostAware, []penguin.Address{b})
+
+	for _, tc := range []struct {
+		addr  penguin.Address
+		found bool
+		want  []string
+	}{
+		{addr: a, found: false, want: []string{GCPolicyCostAware}},
+		{addr: b, found: true, want: []string{GCPolicyLRU}},
+		{addr: c, found: true, want: []string{GCPolicyLRU, GCPolicyCostAware}},
+		{addr: c, found: false, want: nil},
+	} {
+		got := s.hits(tc.addr, tc.found)
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s found %v: got hits %v, want %v", tc.addr, tc.found, got, tc.want)
+		}
+		for _, name := range tc.want {
+			var ok bool
+			for _, g := range got {
+				ok = ok || g == name
+			}
+			if !ok {
+				t.Fatalf("%s found %v: got hits %v, want %v", tc.addr, tc.found, got, tc.want)
+			}
+		}
+	}
+}
+
+// TestDB_collectGarbageWorker_costAwarePolicy validates that garbage
+// collection keeps the least recently accessed chunks of valuable batches
+// with the cost aware policy.
+func TestDB_collectGarbageWorker_costAwarePolicy(t *testing.T) {
+	chunkCount := 150
+	valuableCount := 10
+
+	var closed chan struct{}
+	testHookCollectGarbageChan := make(chan uint64)
+	t.Cleanup(setTestHookCollectGarbage(func(collectedCount uint64) {
+		if collectedCount == 0 {
+			return
+		}
+		select {
+		case testHookCollectGarbageChan <- collectedCount:
+		case <-closed:
+		}
+	}))
+	t.Cleanup(setWithinRadiusFunc(func(_ *DB, _ shed.Item) bool { return false }))
+
+	valuable := make(map[string]bool)
+	db := newTestDB(t, &Options{
+		Capacity: 100,
+		GCPolicy: NewCostAwarePolicy(&CostAwarePolicyOptions{
+			ValueWeight: 1,
+			BatchValue: func(batchID []byte) (*big.Int, error) {
+				if valuable[string(batchID)] {
+					return big.NewInt(1000), nil
+				}
+				return big.NewInt(1), nil
+			},
+		}),
+		GCShadowPolicies: []GCPolicy{NewLRUPolicy()},
+	})
+	closed = db.close
+
+	addrs := make([]penguin.Address, chunkCount)
+	ctx := context.Background()
+	for i := 0; i < chunkCount; i++ {
+		ch := generateTestRandomChunk()
+		if i < valuableCount {
+			valuable[string(ch.Stamp().BatchID())] = true
+		}
+		unreserveChunkBatch(t, db, 0, ch)
+		_, err := db.Put(ctx, storage.ModePutUpload, ch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Set(ctx, storage.ModeSetSync, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs[i] = ch.Address()
+	}
+
+	gcTarget := db.gcTarget()
+	for {
+		select {
+		case <-testHookCollectGarbageChan:
+		case <-time.After(10 * time.Second):
+			t.Fatal("collect garbage timeout")
+		}
+		gcSize, err := db.gcSize.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gcSize == gcTarget {
+			break
+		}
+	}
+
+	t.Run("gc index count", newItemsCountTest(db.gcIndex, int(gcTarget)))
+
+	t.Run("valuable chunks are kept", func(t *testing.T) {
+		for _, addr := range addrs[:valuableCount] {
+			has, err := db.Has(ctx, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !has {
+				t.Errorf("valuable chunk %s removed", addr)
+			}
+		}
+	})
+}
+
+// TestRecordRevenue validates that the recorded revenue of stored chunks
+// is written in batches and that it decays with the access count.
+func TestRecordRevenue(t *testing.T) {
+	db := newTestDB(t, nil)
+
+	ch := generateTestRandomChunk()
+	_, err := db.Put(context.Background(), storage.ModePutUpload, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := generateTestRandomChunk().Address()
+
+	for _, r := range []struct {
+		addr   penguin.Address
+		amount uint64
+	}{
+		{ch.Address(), 12},
+		{missing, 7},
+		{ch.Address(), 8},
+	} {
+		if err := db.RecordRevenue(r.addr, r.amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.writeBackRevenue(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := db.accessStatsIndex.Get(addressToItem(ch.Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Revenue != 20 {
+		t.Fatalf("got revenue %d, want 20", stats.Revenue)
+	}
+	if _, err := db.accessStatsIndex.Get(addressToItem(missing)); !errors.Is(err, leveldb.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, leveldb.ErrNotFound)
+	}
+
+	t.Cleanup(setNow(func() int64 {
+		return stats.AccessTimestamp + 2*int64(accessStatsHalfLife)
+	}))
+	candidates, err := db.gcCandidates([]shed.Item{addressToItem(ch.Address())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if candidates[0].Revenue != 5 {
+		t.Fatalf("got decayed revenue %d, want 5", candidates[0].Revenue)
+	}
+}
+
+// TestDecayAccessStats validates that the access stats are
+// halved for every half-life passed.
+func TestDecayAccessStats(t *testing.T) {
+	halfLife := int64(accessStatsHalfLife)
+	start := time.Now().UnixNano()
+
+	for _, tc := range []struct {
+		name          string
+		t             int64
+		wantCount     uint64
+		wantTimestamp int64
+	}{
+		{name: "within half-life", t: start + halfLife - 1, wantCount: 100, wantTimestamp: start},
+		{name: "half-life", t: start + halfLife, wantCount: 50, wantTimestamp: start + halfLife},
+		{name: "partial half-life", t: start + 3*halfLife/2, wantCount: 50, wantTimestamp: start + halfLife},
+		{name: "three half-lives", t: start + 3*halfLife, wantCount: 12, wantTimestamp: start + 3*halfLife},
+		{name: "expired", t: start + 100*halfLife, wantCount: 0, wantTimestamp: start + 100*halfLife},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := decayAccessStats(shed.Item{AccessCount: 100, Revenue: 100, AccessTimestamp: start}, tc.t)
+			if got.AccessCount != tc.wantCount || got.Revenue != tc.wantCount {
+				t.Errorf("got access count %d and revenue %d, want %d", got.AccessCount, got.Revenue, tc.wantCount)
+			}
+			if got.AccessTimestamp != tc.wantTimestamp {
+				t.Errorf("got timestamp %d, want %d", got.AccessTimestamp, tc.wantTimestamp)
+			}
+		})
+	}
+
+	got := decayAccessStats(shed.Item{AccessCount: 1}, start)
+	if got.AccessCount != 1 || got.AccessTimestamp != start {
+		t.Errorf("got access count %d and timestamp %d, want 1 and %d", got.AccessCount, got.AccessTimestamp, start)
+	}
+}
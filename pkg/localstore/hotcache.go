@@ -0,0 +1,229 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+var (
+	// hotCacheWriteBackInterval is the period after which requests of
+	// chunks served from the hot cache are written to the gc indexes.
+	hotCacheWriteBackInterval = time.Second
+	// hotCacheWriteBackSize is the number of chunks with pending requests
+	// which triggers a write back before the interval passes.
+	hotCacheWriteBackSize = 1000
+)
+
+// hotCacheItemOverhead approximates the memory used by a cached item
+// besides its data.
+const hotCacheItemOverhead = 256
+
+// hotCache keeps recently requested chunks in memory. Requests of cached
+// chunks are collected and written to the gc indexes in batches.
+type hotCache struct {
+	mu       sync.Mutex
+	lru      *simplelru.LRU
+	size     uint64
+	capacity uint64
+	// generation is incremented on every removal, so that chunks read from
+	// the database before they were removed are not cached
+	generation uint64
+	// number of requests per chunk which are not written to the gc indexes
+	pending map[string]uint64
+}
+
+func newHotCache(capacity uint64) (*hotCache, error) {
+	c := &hotCache{
+		capacity: capacity,
+		pending:  make(map[string]uint64),
+	}
+	// the number of items is limited by their size
+	lru, err := simplelru.NewLRU(math.MaxInt32, func(_, value interface{}) {
+		c.size -= hotCacheItemSize(value.(shed.Item))
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.lru = lru
+	return c, nil
+}
+
+func hotCacheItemSize(item shed.Item) uint64 {
+	return uint64(len(item.Data)) + hotCacheItemOverhead
+}
+
+// get returns the cached chunk and counts its request.
+func (c *hotCache) get(addr penguin.Address) (item shed.Item, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.lru.Get(addr.ByteString())
+	if !ok {
+		return item, false
+	}
+	c.pending[addr.ByteString()]++
+	return v.(shed.Item), true
+}
+
+// gen returns the current removal generation.
+func (c *hotCache) gen() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// add caches the chunk if no chunk was removed since the generation.
+func (c *hotCache) add(item shed.Item, generation uint64) {
+	size := hotCacheItemSize(item)
+	if size > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := string(item.Address)
+	if generation != c.generation || c.lru.Contains(key) {
+		return
+	}
+	c.lru.Add(key, item)
+	c.size += size
+	for c.size > c.capacity {
+		c.lru.RemoveOldest()
+	}
+}
+
+// remove drops the chunks from the cache together with their pending
+// requests.
+func (c *hotCache) remove(addrs []penguin.Address) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, addr := range addrs {
+		c.lru.Remove(addr.ByteString())
+		delete(c.pending, addr.ByteString())
+	}
+}
+
+// takePending returns the chunks with requests which are not written
+// to the gc indexes, with the number of requests as their AccessCount.
+func (c *hotCache) takePending() []shed.Item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.pending) == 0 {
+		return nil
+	}
+	items := make([]shed.Item, 0, len(c.pending))
+	for key, count := range c.pending {
+		items = append(items, shed.Item{
+			Address:     []byte(key),
+			AccessCount: count,
+		})
+	}
+	c.pending = make(map[string]uint64)
+	return items
+}
+
+func (c *hotCache) pendingCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.pending)
+}
+
+func (c *hotCache) bytes() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// getHot returns the chunk from the hot cache if it is there.
+func (db *DB) getHot(addr penguin.Address) (item shed.Item, ok bool) {
+	item, ok = db.hot.get(addr)
+	if !ok {
+		db.metrics.HotCacheMisses.Inc()
+		return item, false
+	}
+	db.metrics.HotCacheHits.Inc()
+	if db.hot.pendingCount() >= hotCacheWriteBackSize {
+		select {
+		case db.hotWriteBackTrigger <- struct{}{}:
+		default:
+		}
+	}
+	return item, true
+}
+
+// hotCacheWriteBackWorker periodically writes the requests of chunks
+// served from the hot cache to the gc indexes. Pending requests are
+// written when the database is closed.
+func (db *DB) hotCacheWriteBackWorker() {
+	defer close(db.hotCacheWorkerDone)
+
+	ticker := time.NewTicker(hotCacheWriteBackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-db.hotWriteBackTrigger:
+		case <-db.close:
+			if err := db.writeBackHotCache(); err != nil {
+				db.logger.Errorf("localstore: hot cache write back: %v", err)
+			}
+			return
+		}
+		if err := db.writeBackHotCache(); err != nil {
+			db.logger.Errorf("localstore: hot cache write back: %v", err)
+		}
+	}
+}
+
+// writeBackHotCache updates the gc indexes of all chunks requested from
+// the hot cache in a single batch.
+func (db *DB) writeBackHotCache() error {
+	db.metrics.HotCacheSize.Set(float64(db.hot.bytes()))
+
+	items := db.hot.takePending()
+	if len(items) == 0 {
+		return nil
+	}
+	db.metrics.HotCacheWriteBack.Inc()
+	defer totalTimeMetric(db.metrics.TotalTimeHotCacheWriteBack, time.Now())
+
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	batch := new(leveldb.Batch)
+	for _, item := range items {
+		// the gc index key requires the bin id of the chunk
+		header, err := db.retrievalHeaderIndex.Get(item)
+		if err != nil {
+			if errors.Is(err, leveldb.ErrNotFound) {
+				// the chunk was removed since it was requested
+				continue
+			}
+			return err
+		}
+		header.AccessCount = item.AccessCount
+		if err := db.updateGCInBatch(batch, header); err != nil {
+			return err
+		}
+	}
+	return db.shed.WriteBatch(batch)
+}
@@ -0,0 +1,167 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/penguintop/penguin/pkg/storage"
+)
+
+// TestHotCache validates that requested chunks are served from the hot
+// cache, that their requests are written back to the database and that
+// removed chunks are dropped from the cache.
+func TestHotCache(t *testing.T) {
+	// the interval is restored after the database
+	// and its write back worker are closed
+	t.Cleanup(func(d time.Duration) func() {
+		return func() { hotCacheWriteBackInterval = d }
+	}(hotCacheWriteBackInterval))
+	hotCacheWriteBackInterval = time.Hour
+
+	updated := make(chan struct{}, 1)
+	t.Cleanup(setTestHookUpdateGC(func() {
+		updated <- struct{}{}
+	}))
+
+	var timestamp int64 = 1
+	t.Cleanup(setNow(func() int64 { return timestamp }))
+	t.Cleanup(setWithinRadiusFunc(func(_ *DB, _ shed.Item) bool { return false }))
+
+	db := newTestDB(t, &Options{HotCacheSize: 1024 * 1024})
+	ctx := context.Background()
+
+	ch := generateTestRandomChunk()
+	unreserveChunkBatch(t, db, 0, ch)
+	if _, err := db.Put(ctx, storage.ModePutUpload, ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(ctx, storage.ModeSetSync, ch.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first request is served from the database
+	if _, err := db.Get(ctx, storage.ModeGetRequest, ch.Address()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-updated:
+	case <-time.After(10 * time.Second):
+		t.Fatal("gc update timeout")
+	}
+	if !db.hot.lru.Contains(ch.Address().ByteString()) {
+		t.Fatal("requested chunk not in hot cache")
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := db.Get(ctx, storage.ModeGetRequest, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Data(), ch.Data()) {
+			t.Fatalf("got data %x, want %x", got.Data(), ch.Data())
+		}
+		if !bytes.Equal(got.Stamp().BatchID(), ch.Stamp().BatchID()) {
+			t.Fatalf("got batch %x, want %x", got.Stamp().BatchID(), ch.Stamp().BatchID())
+		}
+	}
+	select {
+	case <-updated:
+		t.Fatal("hot cache request updated gc index")
+	default:
+	}
+
+	timestamp = 2
+	if err := db.writeBackHotCache(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the gc index entry of the chunk is moved to the time of the write back
+	data, err := db.retrievalDataIndex.Get(addressToItem(ch.Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gcItems []shed.Item
+	err = db.gcIndex.Iterate(func(item shed.Item) (bool, error) {
+		gcItems = append(gcItems, item)
+		return false, nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gcItems) != 1 {
+		t.Fatalf("got %d gc index entries, want 1", len(gcItems))
+	}
+	if gcItems[0].AccessTimestamp != timestamp || gcItems[0].BinID != data.BinID {
+		t.Fatalf("got gc index entry with access timestamp %d and bin id %d, want %d and %d", gcItems[0].AccessTimestamp, gcItems[0].BinID, timestamp, data.BinID)
+	}
+
+	stats, err := db.accessStatsIndex.Get(addressToItem(ch.Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.AccessCount != 3 {
+		t.Fatalf("got access count %d, want 3", stats.AccessCount)
+	}
+
+	if err := db.Set(ctx, storage.ModeSetRemove, ch.Address()); err != nil {
+		t.Fatal(err)
+	}
+	if db.hot.lru.Contains(ch.Address().ByteString()) {
+		t.Fatal("removed chunk in hot cache")
+	}
+	_, err = db.Get(ctx, storage.ModeGetRequest, ch.Address())
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+// TestHotCacheSize validates that the hot cache is limited
+// by the size of the cached chunks.
+func TestHotCacheSize(t *testing.T) {
+	chunks := generateValidTestChunks(3)
+	c, err := newHotCache(2 * hotCacheItemSize(chunkToItem(chunks[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ch := range chunks {
+		c.add(chunkToItem(ch), c.gen())
+	}
+	if c.lru.Len() != 2 {
+		t.Fatalf("got %d cached chunks, want 2", c.lru.Len())
+	}
+	if _, ok := c.get(chunks[0].Address()); ok {
+		t.Fatal("least recently added chunk not evicted")
+	}
+
+	// chunks read before a removal are not cached
+	item := chunkToItem(generateTestRandomChunk())
+	gen := c.gen()
+	c.remove([]penguin.Address{chunks[1].Address()})
+	c.add(item, gen)
+	if _, ok := c.get(penguin.NewAddress(item.Address)); ok {
+		t.Fatal("chunk read before removal cached")
+	}
+
+	items := c.takePending()
+	if len(items) != 0 {
+		t.Fatalf("got %d pending requests, want 0", len(items))
+	}
+	if _, ok := c.get(chunks[2].Address()); !ok {
+		t.Fatal("chunk not cached")
+	}
+	items = c.takePending()
+	want := shed.Item{Address: chunks[2].Address().Bytes(), AccessCount: 1}
+	if len(items) != 1 || !bytes.Equal(items[0].Address, want.Address) || items[0].AccessCount != 1 {
+		t.Fatalf("got pending requests %+v, want %+v", items, want)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"errors"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/penguintop/penguin/pkg/storage"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// DefaultListLimit is the number of chunks listed
+	// if the limit of ListOptions is not set.
+	DefaultListLimit = 100
+	// MaxListLimit is the maximal number of chunks listed in one call.
+	MaxListLimit = 1000
+)
+
+// ListOptions define a page of listed chunks.
+type ListOptions struct {
+	// Cursor continues the listing after the last
+	// chunk of the page with the cursor.
+	Cursor string
+	// Limit is the maximal number of chunks on the page.
+	Limit int
+	// Reverse lists the chunks in descending order.
+	Reverse bool
+}
+
+// ChunkList is a page of listed chunk addresses.
+type ChunkList struct {
+	Addresses []penguin.Address
+	// Cursor is set if there may be more chunks
+	// to list after the last chunk of the page.
+	Cursor string
+}
+
+// ListChunks returns a page of addresses of stored chunks ordered by address.
+// An error wrapping shed.ErrInvalidCursor is returned for an invalid cursor.
+// Chunk data is not loaded, so chunks with missing data are listed too.
+func (db *DB) ListChunks(o *ListOptions) (*ChunkList, error) {
+	return listIndex(db.retrievalHeaderIndex, nil, o)
+}
+
+// ListBinChunks returns a page of addresses of stored chunks in the
+// proximity order bin, ordered by their bin IDs.
+func (db *DB) ListBinChunks(bin uint8, o *ListOptions) (*ChunkList, error) {
+	return listIndex(db.pullIndex, []byte{bin}, o)
+}
+
+// ListBatchChunks returns a page of addresses of stored chunks stamped
+// by the postage batch, ordered by proximity order and address.
+func (db *DB) ListBatchChunks(batchID []byte, o *ListOptions) (*ChunkList, error) {
+	return listIndex(db.postageChunksIndex, batchID, o)
+}
+
+// ListPinnedChunks returns a page of addresses of pinned chunks
+// ordered by address.
+func (db *DB) ListPinnedChunks(o *ListOptions) (*ChunkList, error) {
+	return listIndex(db.pinIndex, nil, o)
+}
+
+// ListPushChunks returns a page of addresses of chunks which are not yet
+// push synced, ordered by their store timestamps.
+func (db *DB) ListPushChunks(o *ListOptions) (*ChunkList, error) {
+	return listIndex(db.pushIndex, nil, o)
+}
+
+// ChunkInfo holds the metadata of a stored chunk.
+type ChunkInfo struct {
+	Address         penguin.Address
+	BatchID         []byte
+	Sig             []byte
+	StoreTimestamp  int64
+	AccessTimestamp int64
+	Bin             uint8
+	BinID           uint64
+	PinCounter      uint64
+	// Reserved is true if the chunk is inside the
+	// reserve radius of its postage batch.
+	Reserved bool
+}
+
+// ChunkInfo returns the metadata of the stored chunk without its data.
+// If the chunk is not stored, storage.ErrNotFound is returned.
+func (db *DB) ChunkInfo(addr penguin.Address) (*ChunkInfo, error) {
+	item := addressToItem(addr)
+
+	header, err := db.retrievalHeaderIndex.Get(item)
+	if err != nil {
+		if errors.Is(err, leveldb.ErrNotFound) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	info := &ChunkInfo{
+		Address:        addr,
+		BatchID:        header.BatchID,
+		Sig:            header.Sig,
+		StoreTimestamp: header.StoreTimestamp,
+		Bin:            db.po(addr),
+		BinID:          header.BinID,
+	}
+
+	access, err := db.retrievalAccessIndex.Get(item)
+	if err == nil {
+		info.AccessTimestamp = access.AccessTimestamp
+	} else if !errors.Is(err, leveldb.ErrNotFound) {
+		return nil, err
+	}
+	pin, err := db.pinIndex.Get(item)
+	if err == nil {
+		info.PinCounter = pin.PinCounter
+	} else if !errors.Is(err, leveldb.ErrNotFound) {
+		return nil, err
+	}
+	radius, err := db.postageRadiusIndex.Get(shed.Item{BatchID: header.BatchID})
+	if err == nil {
+		info.Reserved = info.Bin >= radius.Radius
+	} else if !errors.Is(err, leveldb.ErrNotFound) {
+		return nil, err
+	}
+	return info, nil
+}
+
+// listIndex returns a page of chunk addresses of items in the index
+// with keys starting with the prefix.
+func listIndex(index shed.Index, prefix []byte, o *ListOptions) (list *ChunkList, err error) {
+	if o == nil {
+		o = new(ListOptions)
+	}
+	limit := o.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+	var cursor shed.Cursor
+	if o.Cursor != "" {
+		cursor, err = shed.ParseCursor(o.Cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	list = &ChunkList{Addresses: make([]penguin.Address, 0)}
+	next, err := index.IterateCursor(func(item shed.Item) (stop bool, err error) {
+		list.Addresses = append(list.Addresses, penguin.NewAddress(item.Address))
+		return len(list.Addresses) == limit, nil
+	}, &shed.IterateOptions{
+		Prefix:  prefix,
+		Cursor:  cursor,
+		Reverse: o.Reverse,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if next != nil {
+		list.Cursor = next.String()
+	}
+	return list, nil
+}
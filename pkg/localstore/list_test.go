@@ -0,0 +1,244 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	postagetesting "github.com/penguintop/penguin/pkg/postage/testing"
+	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/penguintop/penguin/pkg/storage"
+)
+
+// TestListChunks validates that pages of listed chunks
+// contain all stored chunks in both orders.
+func TestListChunks(t *testing.T) {
+	db := newTestDB(t, nil)
+
+	chunks := generateTestRandomChunks(25)
+	if _, err := db.Put(context.Background(), storage.ModePutUpload, chunks...); err != nil {
+		t.Fatal(err)
+	}
+	want := make([]penguin.Address, 0, len(chunks))
+	for _, ch := range chunks {
+		want = append(want, ch.Address())
+	}
+	sort.Slice(want, func(i, j int) bool {
+		return bytes.Compare(want[i].Bytes(), want[j].Bytes()) < 0
+	})
+
+	for _, reverse := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reverse %v", reverse), func(t *testing.T) {
+			var got []penguin.Address
+			o := &ListOptions{Limit: 10, Reverse: reverse}
+			for pages := 0; ; pages++ {
+				if pages > len(chunks) {
+					t.Fatal("listing did not end")
+				}
+				list, err := db.ListChunks(o)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(list.Addresses) > o.Limit {
+					t.Fatalf("got %d chunks on a page, want at most %d", len(list.Addresses), o.Limit)
+				}
+				got = append(got, list.Addresses...)
+				if list.Cursor == "" {
+					break
+				}
+				o.Cursor = list.Cursor
+			}
+			if len(got) != len(want) {
+				t.Fatalf("got %d chunks, want %d", len(got), len(want))
+			}
+			for i, addr := range got {
+				w := want[i]
+				if reverse {
+					w = want[len(want)-1-i]
+				}
+				if !addr.Equal(w) {
+					t.Fatalf("got chunk %s at %d, want %s", addr, i, w)
+				}
+			}
+		})
+	}
+
+	_, err := db.ListChunks(&ListOptions{Cursor: "invalid"})
+	if !errors.Is(err, shed.ErrInvalidCursor) {
+		t.Fatalf("got error %v, want %v", err, shed.ErrInvalidCursor)
+	}
+}
+
+// TestListChunksMissingData validates that chunks are listed
+// without loading their data from the blob store.
+func TestListChunksMissingData(t *testing.T) {
+	blobs := newTestBlobStore(t, t.TempDir())
+	db := newTestDB(t, &Options{BlobStore: blobs})
+
+	chunks := generateTestRandomChunks(5)
+	if _, err := db.Put(context.Background(), storage.ModePutUpload, chunks...); err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Delete(chunks[0].Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := db.ListChunks(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Addresses) != len(chunks) {
+		t.Fatalf("got %d chunks, want %d", len(list.Addresses), len(chunks))
+	}
+}
+
+// TestListIndexChunks validates listing of chunks by
+// proximity order bin, postage batch, pin and push state.
+func TestListIndexChunks(t *testing.T) {
+	db := newTestDB(t, nil)
+	ctx := context.Background()
+
+	// chunks of the batch are not reserved when they are synced
+	batchID := postagetesting.MustNewID()
+	if err := db.UnreserveBatch(batchID, penguin.MaxPO+1); err != nil {
+		t.Fatal(err)
+	}
+	chunks := generateQuotaTestChunks(batchID, 10)
+	if _, err := db.Put(ctx, storage.ModePutUpload, chunks...); err != nil {
+		t.Fatal(err)
+	}
+	other := generateTestRandomChunks(5)
+	if _, err := db.Put(ctx, storage.ModePutUpload, other...); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(ctx, storage.ModeSetPin, chunks[0].Address(), chunks[1].Address()); err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range chunks[:4] {
+		if err := db.Set(ctx, storage.ModeSetSync, ch.Address()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var binCount int
+	for bin := uint8(0); bin <= penguin.MaxPO; bin++ {
+		bin := bin
+		got := listAllChunks(t, func(o *ListOptions) (*ChunkList, error) {
+			return db.ListBinChunks(bin, o)
+		})
+		for _, addr := range got {
+			if po := db.po(addr); po != bin {
+				t.Fatalf("got chunk %s with po %d in bin %d", addr, po, bin)
+			}
+		}
+		binCount += len(got)
+	}
+	if binCount != len(chunks)+len(other) {
+		t.Fatalf("got %d chunks in bins, want %d", binCount, len(chunks)+len(other))
+	}
+
+	checkListedChunks(t, chunks, listAllChunks(t, func(o *ListOptions) (*ChunkList, error) {
+		return db.ListBatchChunks(batchID, o)
+	}))
+	checkListedChunks(t, chunks[:2], listAllChunks(t, db.ListPinnedChunks))
+	checkListedChunks(t, append(append([]penguin.Chunk(nil), chunks[4:]...), other...), listAllChunks(t, db.ListPushChunks))
+}
+
+// TestChunkInfo validates the metadata of stored chunks.
+func TestChunkInfo(t *testing.T) {
+	db := newTestDB(t, nil)
+	ctx := context.Background()
+
+	batchID := postagetesting.MustNewID()
+	if err := db.UnreserveBatch(batchID, 0); err != nil {
+		t.Fatal(err)
+	}
+	ch := generateQuotaTestChunks(batchID, 1)[0]
+	otherCh := generateTestRandomChunk()
+
+	defer setNow(func() int64 { return 100 })()
+	if _, err := db.Put(ctx, storage.ModePutUpload, ch, otherCh); err != nil {
+		t.Fatal(err)
+	}
+	// the synced chunk is pinned by the reserve
+	defer setNow(func() int64 { return 200 })()
+	if err := db.Set(ctx, storage.ModeSetSync, ch.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := db.ChunkInfo(ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(info.BatchID, batchID) || !bytes.Equal(info.Sig, ch.Stamp().Sig()) {
+		t.Fatalf("got stamp %x %x, want %x %x", info.BatchID, info.Sig, batchID, ch.Stamp().Sig())
+	}
+	if info.StoreTimestamp != 100 || info.AccessTimestamp != 200 {
+		t.Fatalf("got store timestamp %d and access timestamp %d, want 100 and 200", info.StoreTimestamp, info.AccessTimestamp)
+	}
+	if info.Bin != db.po(ch.Address()) || info.BinID == 0 {
+		t.Fatalf("got bin %d and bin id %d", info.Bin, info.BinID)
+	}
+	if info.PinCounter != 1 || !info.Reserved {
+		t.Fatalf("got pin counter %d and reserved %v, want 1 and true", info.PinCounter, info.Reserved)
+	}
+
+	info, err = db.ChunkInfo(otherCh.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.AccessTimestamp != 0 || info.PinCounter != 0 || info.Reserved {
+		t.Fatalf("got access timestamp %d, pin counter %d and reserved %v", info.AccessTimestamp, info.PinCounter, info.Reserved)
+	}
+
+	_, err = db.ChunkInfo(generateTestRandomChunk().Address())
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+// listAllChunks returns the addresses of all pages listed by the list function.
+func listAllChunks(t *testing.T, list func(o *ListOptions) (*ChunkList, error)) (addrs []penguin.Address) {
+	t.Helper()
+
+	o := &ListOptions{Limit: 3}
+	for {
+		l, err := list(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, l.Addresses...)
+		if l.Cursor == "" {
+			return addrs
+		}
+		o.Cursor = l.Cursor
+	}
+}
+
+// checkListedChunks validates that the listed addresses are
+// the addresses of the chunks in any order.
+func checkListedChunks(t *testing.T, chunks []penguin.Chunk, got []penguin.Address) {
+	t.Helper()
+
+	if len(got) != len(chunks) {
+		t.Fatalf("got %d listed chunks, want %d", len(got), len(chunks))
+	}
+	want := make(map[string]struct{})
+	for _, ch := range chunks {
+		want[ch.Address().ByteString()] = struct{}{}
+	}
+	for _, addr := range got {
+		if _, ok := want[addr.ByteString()]; !ok {
+			t.Fatalf("got unexpected chunk %s", addr)
+		}
+		delete(want, addr.ByteString())
+	}
+}
@@ -54,6 +54,9 @@ var (
 	// buffer time.
 	flipFlopBufferDuration    = 150 * time.Millisecond
 	flipFlopWorstCaseDuration = 20 * time.Second
+
+	// size of the retrieval data index value without the chunk data
+	retrievalDataHeaderSize = 16 + postage.StampSize
 )
 
 // DB is the local store implementation and holds
@@ -68,6 +71,14 @@ type DB struct {
 	// retrieval indexes
 	retrievalDataIndex   shed.Index
 	retrievalAccessIndex shed.Index
+	// retrieval data index which decodes entries
+	// without loading the chunk data
+	retrievalHeaderIndex shed.Index
+	// optional store of chunk data, if set the
+	// retrieval data index does not hold data
+	blobs ChunkBlobStore
+	// field that is set once chunk data is kept in a blob store
+	blobStoreUsed shed.Uint64Field
 	// push syncing index
 	pushIndex shed.Index
 	// push syncing subscriptions triggers
@@ -89,6 +100,9 @@ type DB struct {
 
 	// pin files Index
 	pinIndex shed.Index
+	// user pin counters of chunks by postage batch, unlike pinIndex
+	// without the pins of chunks preserved in the reserve
+	userPinIndex shed.Index
 
 	// postage chunks index
 	postageChunksIndex shed.Index
@@ -96,6 +110,29 @@ type DB struct {
 	// postage chunks index
 	postageRadiusIndex shed.Index
 
+	// access count and revenue of chunks
+	accessStatsIndex shed.Index
+
+	// revenue of chunks not yet written to accessStatsIndex
+	revenue                 map[string]uint64
+	revenueMu               sync.Mutex
+	revenueWriteBackTrigger chan struct{}
+	revenueWorkerDone       chan struct{}
+
+	// garbage collection policy and the policies
+	// evaluated against it for metrics
+	gcPolicy         GCPolicy
+	gcShadowPolicies []GCPolicy
+	gcStats          *gcPolicyStats
+
+	// stored and pinned bytes limits per postage batch
+	quotas *quotas
+
+	// optional memory cache of requested chunks
+	hot                 *hotCache
+	hotWriteBackTrigger chan struct{}
+	hotCacheWorkerDone  chan struct{}
+
 	// field that stores number of intems in gc index
 	gcSize shed.Uint64Field
 
@@ -119,6 +156,20 @@ type DB struct {
 
 	batchMu sync.Mutex
 
+	// durability mode of Put and Set calls, with the writes
+	// waiting for a sync in the batched mode
+	durability DurabilityMode
+	commits    *groupCommit
+	// field written by syncs of grouped writes
+	syncMarker shed.Uint64Field
+
+	// checkRunning is set while the database check is running
+	checkRunning int32
+	// status of the last database check started in the background
+	checkMu     sync.Mutex
+	checkStatus *CheckStatus
+	checkWG     sync.WaitGroup
+
 	// gcRunning is true while GC is running. it is
 	// used to avoid touching dirty gc index entries
 	// while garbage collecting.
@@ -165,6 +216,27 @@ type Options struct {
 	// and is passed on to shed.
 	DisableSeeksCompaction bool
 
+	// BlobStore keeps chunk data outside of leveldb if set. Existing
+	// chunk data is moved to it by the DbSchemaBlobs migration and it
+	// is closed with the DB.
+	BlobStore ChunkBlobStore
+
+	// GCPolicy selects the chunks removed by garbage collection.
+	// Least recently used chunks are removed if it is not set.
+	GCPolicy GCPolicy
+	// GCShadowPolicies are not used for garbage collection, but their
+	// cache hit rate is reported in metrics for comparison.
+	GCShadowPolicies []GCPolicy
+
+	// HotCacheSize is the number of bytes of requested chunks kept in
+	// memory. Requests of cached chunks are written to the database in
+	// batches. The cache is disabled if it is zero.
+	HotCacheSize uint64
+
+	// Durability defines when writes of Put and Set calls are synced
+	// to the disk. Writes are not synced by default.
+	Durability DurabilityMode
+
 	// MetricsPrefix defines a prefix for metrics names.
 	MetricsPrefix string
 	Tags          *tags.Tags
@@ -185,6 +257,8 @@ func New(path string, baseKey []byte, o *Options, logger logging.Logger) (db *DB
 		cacheCapacity: o.Capacity,
 		baseKey:       baseKey,
 		tags:          o.Tags,
+		blobs:         o.BlobStore,
+		durability:    o.Durability,
 		// channel collectGarbageTrigger
 		// needs to be buffered with the size of 1
 		// to signal another event if it
@@ -192,12 +266,35 @@ func New(path string, baseKey []byte, o *Options, logger logging.Logger) (db *DB
 		collectGarbageTrigger:    make(chan struct{}, 1),
 		close:                    make(chan struct{}),
 		collectGarbageWorkerDone: make(chan struct{}),
+		revenue:                  make(map[string]uint64),
+		revenueWriteBackTrigger:  make(chan struct{}, 1),
+		revenueWorkerDone:        make(chan struct{}),
 		metrics:                  newMetrics(),
 		logger:                   logger,
 	}
 	if db.cacheCapacity == 0 {
 		db.cacheCapacity = defaultCacheCapacity
 	}
+	if _, err := ParseDurabilityMode(string(db.durability)); err != nil {
+		return nil, err
+	}
+	if db.durability == DurabilityBatched {
+		db.commits = newGroupCommit()
+	}
+	db.gcPolicy = o.GCPolicy
+	if db.gcPolicy == nil {
+		db.gcPolicy = NewLRUPolicy()
+	}
+	db.gcShadowPolicies = o.GCShadowPolicies
+	db.gcStats = newGCPolicyStats(db.gcPolicy, db.gcShadowPolicies)
+	if o.HotCacheSize > 0 {
+		db.hot, err = newHotCache(o.HotCacheSize)
+		if err != nil {
+			return nil, err
+		}
+		db.hotWriteBackTrigger = make(chan struct{}, 1)
+		db.hotCacheWorkerDone = make(chan struct{})
+	}
 
 	capacityMB := float64((db.cacheCapacity+uint64(batchstore.Capacity))*penguin.ChunkSize) * 9.5367431640625e-7
 
@@ -216,6 +313,7 @@ func New(path string, baseKey []byte, o *Options, logger logging.Logger) (db *DB
 		BlockCacheCapacity:     o.BlockCacheCapacity,
 		WriteBufferSize:        o.WriteBufferSize,
 		DisableSeeksCompaction: o.DisableSeeksCompaction,
+		NoSync:                 db.durability == DurabilityUnsafe,
 	}
 
 	if withinRadiusFn == nil {
@@ -250,6 +348,11 @@ func New(path string, baseKey []byte, o *Options, logger logging.Logger) (db *DB
 		}
 	}
 
+	db.syncMarker, err = db.shed.NewUint64Field("sync-marker")
+	if err != nil {
+		return nil, err
+	}
+
 	// Persist gc size.
 	db.gcSize, err = db.shed.NewUint64Field("gc-size")
 	if err != nil {
@@ -257,7 +360,7 @@ func New(path string, baseKey []byte, o *Options, logger logging.Logger) (db *DB
 	}
 
 	// Index storing actual chunk address, data and bin id.
-	headerSize := 16 + postage.StampSize
+	headerSize := retrievalDataHeaderSize
 	db.retrievalDataIndex, err = db.shed.NewIndex("Address->StoreTimestamp|BinID|BatchID|Sig|Data", shed.IndexFuncs{
 		EncodeKey: func(fields shed.Item) (key []byte, err error) {
 			return fields.Address, nil
@@ -275,6 +378,9 @@ func New(path string, baseKey []byte, o *Options, logger logging.Logger) (db *DB
 				return nil, err
 			}
 			copy(b[16:], stamp)
+			if db.blobs != nil {
+				return b, nil
+			}
 			value = append(b, fields.Data...)
 			return value, nil
 		},
@@ -287,6 +393,10 @@ func New(path string, baseKey []byte, o *Options, logger logging.Logger) (db *DB
 			}
 			e.BatchID = stamp.BatchID()
 			e.Sig = stamp.Sig()
+			if len(value) == headerSize {
+				e.Data, err = db.getBlob(keyItem.Address)
+				return e, err
+			}
 			e.Data = value[headerSize:]
 			return e, nil
 		},
@@ -294,6 +404,26 @@ func New(path string, baseKey []byte, o *Options, logger logging.Logger) (db *DB
 	if err != nil {
 		return nil, err
 	}
+	// Retrieval data index entries are decoded without loading the chunk
+	// data, so that chunks with missing data can be inspected.
+	db.retrievalHeaderIndex, err = db.shed.NewIndex("Address->StoreTimestamp|BinID|BatchID|Sig|Data", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			return fields.Address, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			return nil, errors.New("read only index")
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			return decodeRetrievalDataHeader(value)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
 	// Index storing access timestamp for a particular address.
 	// It is needed in order to update gc index keys for iteration order.
 	db.retrievalAccessIndex, err = db.shed.NewIndex("Address->AccessTimestamp", shed.IndexFuncs{
@@ -435,6 +565,34 @@ func New(path string, baseKey []byte, o *Options, logger logging.Logger) (db *DB
 		return nil, err
 	}
 
+	// Create a index structure for storing the pin counts of chunks pinned
+	// by the user, keyed by their postage batch
+	db.userPinIndex, err = db.shed.NewIndex("BatchID|Hash->PinCounter", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			key = make([]byte, 64)
+			copy(key[:32], fields.BatchID)
+			copy(key[32:], fields.Address)
+			return key, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.BatchID = key[:32]
+			e.Address = key[32:64]
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b[:8], fields.PinCounter)
+			return b, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.PinCounter = binary.BigEndian.Uint64(value[:8])
+			return e, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	db.postageChunksIndex, err = db.shed.NewIndex("BatchID|PO|Hash->nil", shed.IndexFuncs{
 		EncodeKey: func(fields shed.Item) (key []byte, err error) {
 			key = make([]byte, 65)
@@ -481,8 +639,56 @@ func New(path string, baseKey []byte, o *Options, logger logging.Logger) (db *DB
 		return nil, err
 	}
 
+	// Index storing the number of requests and the revenue
+	// earned from serving a chunk for garbage collection policies.
+	// Both are halved every accessStatsHalfLife from the AccessTimestamp.
+	db.accessStatsIndex, err = db.shed.NewIndex("Address->AccessCount|Revenue", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			return fields.Address, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			b := make([]byte, 24)
+			binary.BigEndian.PutUint64(b[:8], fields.AccessCount)
+			binary.BigEndian.PutUint64(b[8:16], fields.Revenue)
+			binary.BigEndian.PutUint64(b[16:24], uint64(fields.AccessTimestamp))
+			return b, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.AccessCount = binary.BigEndian.Uint64(value[:8])
+			e.Revenue = binary.BigEndian.Uint64(value[8:16])
+			// values written before the decay have no timestamp
+			if len(value) >= 24 {
+				e.AccessTimestamp = int64(binary.BigEndian.Uint64(value[16:24]))
+			}
+			return e, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db.blobStoreUsed, err = db.shed.NewUint64Field("blob-store-used")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.initChunkData(); err != nil {
+		return nil, err
+	}
+
+	if err := db.initQuotas(); err != nil {
+		return nil, err
+	}
+
 	// start garbage collection worker
 	go db.collectGarbageWorker()
+	go db.revenueWriteBackWorker()
+	if db.hot != nil {
+		go db.hotCacheWriteBackWorker()
+	}
 	return db, nil
 }
 
@@ -495,9 +701,18 @@ func (db *DB) Close() (err error) {
 	go func() {
 		db.updateGCWG.Wait()
 		db.subscritionsWG.Wait()
+		db.checkWG.Wait()
 		// wait for gc worker to
 		// return before closing the shed
 		<-db.collectGarbageWorkerDone
+		// wait for the recorded
+		// revenue to be written
+		<-db.revenueWorkerDone
+		// wait for pending hot cache
+		// requests to be written
+		if db.hot != nil {
+			<-db.hotCacheWorkerDone
+		}
 		close(done)
 	}()
 	select {
@@ -512,6 +727,11 @@ func (db *DB) Close() (err error) {
 			return err
 		}
 	}
+	if db.blobs != nil {
+		if err := db.blobs.Close(); err != nil {
+			return err
+		}
+	}
 	return db.shed.Close()
 }
 
@@ -554,6 +774,7 @@ func (db *DB) DebugIndices() (indexInfo map[string]int, err error) {
 		"pullIndex":            db.pullIndex,
 		"gcIndex":              db.gcIndex,
 		"pinIndex":             db.pinIndex,
+		"userPinIndex":         db.userPinIndex,
 		"postageChunksIndex":   db.postageChunksIndex,
 		"postageRadiusIndex":   db.postageRadiusIndex,
 	} {
@@ -16,6 +16,8 @@ type metrics struct {
 	TotalTimeGCExclude              prometheus.Counter
 	TotalTimeGet                    prometheus.Counter
 	TotalTimeUpdateGC               prometheus.Counter
+	TotalTimeHotCacheWriteBack      prometheus.Counter
+	TotalTimeGroupCommit            prometheus.Counter
 	TotalTimeGetMulti               prometheus.Counter
 	TotalTimeHas                    prometheus.Counter
 	TotalTimeHasMulti               prometheus.Counter
@@ -33,6 +35,12 @@ type metrics struct {
 	GCExcludeWriteBatchError prometheus.Counter
 	GCUpdate                 prometheus.Counter
 	GCUpdateError            prometheus.Counter
+	GCPolicyRequests         prometheus.Counter
+	GCPolicyHits             *prometheus.CounterVec
+	HotCacheHits             prometheus.Counter
+	HotCacheMisses           prometheus.Counter
+	HotCacheWriteBack        prometheus.Counter
+	GroupCommit              prometheus.Counter
 
 	ModeGet                       prometheus.Counter
 	ModeGetFailure                prometheus.Counter
@@ -57,6 +65,7 @@ type metrics struct {
 	SubscribePushIterationFailure prometheus.Counter
 
 	GCSize                  prometheus.Gauge
+	HotCacheSize            prometheus.Gauge
 	GCStoreTimeStamps       prometheus.Gauge
 	GCStoreAccessTimeStamps prometheus.Gauge
 }
@@ -325,6 +334,60 @@ func newMetrics() metrics {
 			Help:      "Number of times SUBSCRIBE_PUSH_ITERATION_FAILURE is invoked.",
 		}),
 
+		GCPolicyRequests: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "gc_policy_requests_count",
+			Help:      "Number of chunk requests considered for gc policy cache hit rates.",
+		}),
+		GCPolicyHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "gc_policy_hits_count",
+			Help:      "Number of requested chunks which are or would be stored under a gc policy.",
+		}, []string{"policy"}),
+		TotalTimeHotCacheWriteBack: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "hot_cache_write_back_time",
+			Help:      "Total time taken writing hot cache requests to the database.",
+		}),
+		HotCacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "hot_cache_hits_count",
+			Help:      "Number of requested chunks served from the hot cache.",
+		}),
+		HotCacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "hot_cache_misses_count",
+			Help:      "Number of requested chunks not in the hot cache.",
+		}),
+		HotCacheWriteBack: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "hot_cache_write_back_count",
+			Help:      "Number of batches of hot cache requests written to the database.",
+		}),
+		TotalTimeGroupCommit: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "group_commit_time",
+			Help:      "Total time taken syncing grouped writes to the disk.",
+		}),
+		GroupCommit: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "group_commit_count",
+			Help:      "Number of syncs of grouped writes to the disk.",
+		}),
+		HotCacheSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "hot_cache_size",
+			Help:      "Number of bytes of chunks in the hot cache.",
+		}),
 		GCSize: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: m.Namespace,
 			Subsystem: subsystem,
@@ -40,6 +40,9 @@ type migration struct {
 var schemaMigrations = []migration{
 	{name: DbSchemaCode, fn: func(_ *DB) error { return nil }},
 	{name: DbSchemaYuj, fn: migrateYuj},
+	// chunk data is moved when a blob store is opened, see initChunkData
+	{name: DbSchemaBlobs, fn: func(_ *DB) error { return nil }},
+	{name: DbSchemaUserPins, fn: migrateUserPins},
 }
 
 func (db *DB) migrate(schemaName string) error {
@@ -369,3 +372,213 @@ func migrateYuj(db *DB) error {
 	db.logger.Debugf("done truncating indexes. took %s", time.Since(start))
 	return nil
 }
+
+// migrateUserPins adds the pins of the user to the user pin index, which
+// accounts them in the pinned quotas of the batches. The pin index counts
+// the pins of the user together with the pin of the reserve, which is set
+// for the synced chunks in the pull index within the radius of their batch,
+// so that one pin of such chunks is left to the reserve.
+func migrateUserPins(db *DB) error {
+	pinIndex, err := db.shed.NewIndex("Hash->PinCounter", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			return fields.Address, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b[:8], fields.PinCounter)
+			return b, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.PinCounter = binary.BigEndian.Uint64(value[:8])
+			return e, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	userPinIndex, err := db.shed.NewIndex("BatchID|Hash->PinCounter", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			key = make([]byte, 64)
+			copy(key[:32], fields.BatchID)
+			copy(key[32:], fields.Address)
+			return key, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.BatchID = key[:32]
+			e.Address = key[32:64]
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b[:8], fields.PinCounter)
+			return b, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.PinCounter = binary.BigEndian.Uint64(value[:8])
+			return e, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	// only the header of the retrieval data is decoded, the chunk
+	// data may be in a blob store
+	retrievalHeaderIndex, err := db.shed.NewIndex("Address->StoreTimestamp|BinID|BatchID|Sig|Data", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			return fields.Address, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			return nil, errors.New("read only index")
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			return decodeRetrievalDataHeader(value)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	pullIndex, err := db.shed.NewIndex("PO|BinID->Hash", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			key = make([]byte, 9)
+			key[0] = db.po(penguin.NewAddress(fields.Address))
+			binary.BigEndian.PutUint64(key[1:9], fields.BinID)
+			return key, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.BinID = binary.BigEndian.Uint64(key[1:9])
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			value = make([]byte, 64)
+			copy(value, fields.Address)
+			copy(value[32:], fields.BatchID)
+			return value, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.Address = value[:32]
+			e.BatchID = value[32:64]
+			return e, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	pushIndex, err := db.shed.NewIndex("StoreTimestamp|Hash->Tags", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			key = make([]byte, 40)
+			binary.BigEndian.PutUint64(key[:8], uint64(fields.StoreTimestamp))
+			copy(key[8:], fields.Address)
+			return key, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key[8:]
+			e.StoreTimestamp = int64(binary.BigEndian.Uint64(key[:8]))
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			tag := make([]byte, 4)
+			binary.BigEndian.PutUint32(tag, fields.Tag)
+			return tag, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			if len(value) == 4 { // only values with tag should be decoded
+				e.Tag = binary.BigEndian.Uint32(value)
+			}
+			return e, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	postageRadiusIndex, err := db.shed.NewIndex("BatchID->Radius", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			key = make([]byte, 32)
+			copy(key[:32], fields.BatchID)
+			return key, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.BatchID = key[:32]
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			return []byte{fields.Radius}, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.Radius = value[0]
+			return e, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// reservePinned reports whether the chunk of the item with the
+	// header fields has the reserve pin
+	reservePinned := func(item shed.Item) (bool, error) {
+		inPull, err := pullIndex.Has(item)
+		if err != nil || !inPull {
+			return false, err
+		}
+		unsynced, err := pushIndex.Has(item)
+		if err != nil || unsynced {
+			return false, err
+		}
+		r, err := postageRadiusIndex.Get(item)
+		if err != nil {
+			if errors.Is(err, leveldb.ErrNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		return db.po(penguin.NewAddress(item.Address)) >= r.Radius, nil
+	}
+
+	var (
+		batch = new(leveldb.Batch)
+		count int
+	)
+	err = pinIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		header, err := retrievalHeaderIndex.Get(item)
+		if err != nil {
+			if errors.Is(err, leveldb.ErrNotFound) {
+				// pins of chunks which are not stored are not accounted
+				return false, nil
+			}
+			return true, err
+		}
+		item.StoreTimestamp = header.StoreTimestamp
+		item.BinID = header.BinID
+		item.BatchID = header.BatchID
+		reserve, err := reservePinned(item)
+		if err != nil {
+			return true, err
+		}
+		if reserve {
+			item.PinCounter--
+		}
+		if item.PinCounter == 0 {
+			return false, nil
+		}
+		if err := userPinIndex.PutInBatch(batch, item); err != nil {
+			return true, err
+		}
+		count++
+		return false, nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := db.shed.WriteBatch(batch); err != nil {
+		return err
+	}
+	db.logger.Infof("localstore migration: added %d pinned chunks to the user pin index", count)
+	return nil
+}
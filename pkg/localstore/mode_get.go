@@ -43,7 +43,23 @@ func (db *DB) Get(ctx context.Context, mode storage.ModeGet, addr penguin.Addres
 		}
 	}()
 
+	var generation uint64
+	if mode == storage.ModeGetRequest && db.hot != nil {
+		if out, ok := db.getHot(addr); ok {
+			db.recordGCRequest(addr, true)
+			return penguin.NewChunk(addr, out.Data).
+				WithStamp(postage.NewStamp(out.BatchID, out.Sig)), nil
+		}
+		generation = db.hot.gen()
+	}
+
 	out, err := db.get(mode, addr)
+	if mode == storage.ModeGetRequest && (err == nil || errors.Is(err, leveldb.ErrNotFound)) {
+		db.recordGCRequest(addr, err == nil)
+	}
+	if err == nil && mode == storage.ModeGetRequest && db.hot != nil {
+		db.hot.add(out, generation)
+	}
 	if err != nil {
 		if errors.Is(err, leveldb.ErrNotFound) {
 			return nil, storage.ErrNotFound
@@ -126,11 +142,34 @@ func (db *DB) updateGCItems(items ...shed.Item) {
 func (db *DB) updateGC(item shed.Item) (err error) {
 	db.batchMu.Lock()
 	defer db.batchMu.Unlock()
+
+	batch := new(leveldb.Batch)
+	err = db.updateGCInBatch(batch, item)
+	if err != nil {
+		return err
+	}
+	return db.shed.WriteBatch(batch)
+}
+
+// updateGCInBatch updates access timestamp and gc indexes for a single
+// requested item. The AccessCount field of the item may hold the number
+// of requests, otherwise a single request is counted. This function must
+// be called under batchMu lock.
+func (db *DB) updateGCInBatch(batch *leveldb.Batch, item shed.Item) (err error) {
+	// the chunk may have been removed since it was requested
+	has, err := db.retrievalDataIndex.Has(item)
+	if err != nil || !has {
+		return err
+	}
 	if db.gcRunning {
 		db.dirtyAddresses = append(db.dirtyAddresses, penguin.NewAddress(item.Address))
 	}
 
-	batch := new(leveldb.Batch)
+	// count the request for garbage collection policies
+	err = db.incAccessCountInBatch(batch, item)
+	if err != nil {
+		return err
+	}
 
 	// update accessTimeStamp in retrieve, gc
 
@@ -171,12 +210,7 @@ func (db *DB) updateGC(item shed.Item) (err error) {
 	// in the reserve.
 
 	// update retrieve access index
-	err = db.retrievalAccessIndex.PutInBatch(batch, item)
-	if err != nil {
-		return err
-	}
-
-	return db.shed.WriteBatch(batch)
+	return db.retrievalAccessIndex.PutInBatch(batch, item)
 }
 
 // testHookUpdateGC is a hook that can provide
@@ -36,7 +36,11 @@ func (db *DB) Put(ctx context.Context, mode storage.ModePut, chs ...penguin.Chun
 	db.metrics.ModePut.Inc()
 	defer totalTimeMetric(db.metrics.TotalTimePut, time.Now())
 
-	exist, err = db.put(mode, chs...)
+	if db.commits != nil {
+		exist, err = db.groupPut(mode, chs...)
+	} else {
+		exist, err = db.put(mode, chs...)
+	}
 	if err != nil {
 		db.metrics.ModePutFailure.Inc()
 	}
@@ -68,6 +72,7 @@ func (db *DB) put(mode storage.ModePut, chs ...penguin.Chunk) (exist []bool, err
 	// protect parallel updates
 	db.batchMu.Lock()
 	defer db.batchMu.Unlock()
+	defer db.discardQuotas()
 	if db.gcRunning {
 		for _, ch := range chs {
 			db.dirtyAddresses = append(db.dirtyAddresses, ch.Address())
@@ -128,7 +133,7 @@ func (db *DB) put(mode storage.ModePut, chs ...penguin.Chunk) (exist []bool, err
 			}
 			gcSizeChange += c
 			if mode == storage.ModePutUploadPin {
-				c, err = db.setPin(batch, item)
+				c, err = db.setPin(batch, item, true)
 				if err != nil {
 					return nil, err
 				}
@@ -168,10 +173,11 @@ func (db *DB) put(mode storage.ModePut, chs ...penguin.Chunk) (exist []bool, err
 		return nil, err
 	}
 
-	err = db.shed.WriteBatch(batch)
+	err = db.writeBatch(batch)
 	if err != nil {
 		return nil, err
 	}
+	db.commitQuotas()
 
 	for po := range triggerPullFeed {
 		db.triggerPullSubscriptions(po)
@@ -201,6 +207,14 @@ func (db *DB) putRequest(batch *leveldb.Batch, binIDs map[uint8]uint64, item she
 	if err != nil {
 		return false, 0, err
 	}
+	err = db.quotaStore(item.BatchID, false)
+	if err != nil {
+		return false, 0, err
+	}
+	err = db.putBlob(item)
+	if err != nil {
+		return false, 0, err
+	}
 	err = db.retrievalDataIndex.PutInBatch(batch, item)
 	if err != nil {
 		return false, 0, err
@@ -251,6 +265,14 @@ func (db *DB) putUpload(batch *leveldb.Batch, binIDs map[uint8]uint64, item shed
 	if err != nil {
 		return false, 0, err
 	}
+	err = db.quotaStore(item.BatchID, true)
+	if err != nil {
+		return false, 0, err
+	}
+	err = db.putBlob(item)
+	if err != nil {
+		return false, 0, err
+	}
 	err = db.retrievalDataIndex.PutInBatch(batch, item)
 	if err != nil {
 		return false, 0, err
@@ -289,6 +311,14 @@ func (db *DB) putSync(batch *leveldb.Batch, binIDs map[uint8]uint64, item shed.I
 	if err != nil {
 		return false, 0, err
 	}
+	err = db.quotaStore(item.BatchID, false)
+	if err != nil {
+		return false, 0, err
+	}
+	err = db.putBlob(item)
+	if err != nil {
+		return false, 0, err
+	}
 	err = db.retrievalDataIndex.PutInBatch(batch, item)
 	if err != nil {
 		return false, 0, err
@@ -329,7 +359,7 @@ func (db *DB) preserveOrCache(batch *leveldb.Batch, item shed.Item, forcePin, fo
 	}
 
 	if !forceCache && (withinRadiusFn(db, item) || forcePin) {
-		return db.setPin(batch, item)
+		return db.setPin(batch, item, false)
 	}
 
 	// add new entry to gc index ONLY if it is not present in pinIndex
@@ -36,7 +36,11 @@ import (
 func (db *DB) Set(ctx context.Context, mode storage.ModeSet, addrs ...penguin.Address) (err error) {
 	db.metrics.ModeSet.Inc()
 	defer totalTimeMetric(db.metrics.TotalTimeSet, time.Now())
-	err = db.set(mode, addrs...)
+	if db.commits != nil {
+		err = db.groupSet(mode, addrs...)
+	} else {
+		err = db.set(mode, addrs...)
+	}
 	if err != nil {
 		db.metrics.ModeSetFailure.Inc()
 	}
@@ -49,6 +53,7 @@ func (db *DB) set(mode storage.ModeSet, addrs ...penguin.Address) (err error) {
 	// protect parallel updates
 	db.batchMu.Lock()
 	defer db.batchMu.Unlock()
+	defer db.discardQuotas()
 	if db.gcRunning {
 		db.dirtyAddresses = append(db.dirtyAddresses, addrs...)
 	}
@@ -58,6 +63,7 @@ func (db *DB) set(mode storage.ModeSet, addrs ...penguin.Address) (err error) {
 	// variables that provide information for operations
 	// to be done after write batch function successfully executes
 	var gcSizeChange int64                      // number to add or subtract from gcSize
+	var removed []penguin.Address               // chunks which data is deleted
 	triggerPullFeed := make(map[uint8]struct{}) // signal pull feed subscriptions to iterate
 
 	switch mode {
@@ -79,12 +85,13 @@ func (db *DB) set(mode storage.ModeSet, addrs ...penguin.Address) (err error) {
 				return err
 			}
 			gcSizeChange += c
+			removed = append(removed, addr)
 		}
 
 	case storage.ModeSetPin:
 		for _, addr := range addrs {
 			item := addressToItem(addr)
-			c, err := db.setPin(batch, item)
+			c, err := db.setPin(batch, item, true)
 			if err != nil {
 				return err
 			}
@@ -92,6 +99,10 @@ func (db *DB) set(mode storage.ModeSet, addrs ...penguin.Address) (err error) {
 		}
 	case storage.ModeSetUnpin:
 		for _, addr := range addrs {
+			err := db.userUnpin(batch, addressToItem(addr))
+			if err != nil {
+				return err
+			}
 			c, err := db.setUnpin(batch, addr)
 			if err != nil {
 				return err
@@ -107,10 +118,12 @@ func (db *DB) set(mode storage.ModeSet, addrs ...penguin.Address) (err error) {
 		return err
 	}
 
-	err = db.shed.WriteBatch(batch)
+	err = db.writeBatch(batch)
 	if err != nil {
 		return err
 	}
+	db.commitQuotas()
+	db.deleteChunkData(removed)
 	for po := range triggerPullFeed {
 		db.triggerPullSubscriptions(po)
 	}
@@ -240,6 +253,11 @@ func (db *DB) setRemove(batch *leveldb.Batch, item shed.Item, check bool) (gcSiz
 	if err != nil {
 		return 0, err
 	}
+	err = db.accessStatsIndex.DeleteInBatch(batch, item)
+	if err != nil {
+		return 0, err
+	}
+	db.quotaRelease(item.BatchID, true, false)
 	// unless called by GC which iterates through the gcIndex
 	// a check is needed for decrementing gcSize
 	// as delete is not reporting if the key/value pair is deleted or not
@@ -249,7 +267,7 @@ func (db *DB) setRemove(batch *leveldb.Batch, item shed.Item, check bool) (gcSiz
 			if !errors.Is(err, leveldb.ErrNotFound) {
 				return 0, err
 			}
-			return 0, db.pinIndex.DeleteInBatch(batch, item)
+			return 0, db.deletePinInBatch(batch, item)
 		}
 	}
 	err = db.gcIndex.DeleteInBatch(batch, item)
@@ -261,8 +279,17 @@ func (db *DB) setRemove(batch *leveldb.Batch, item shed.Item, check bool) (gcSiz
 
 // setPin increments pin counter for the chunk by updating
 // pin index and sets the chunk to be excluded from garbage collection.
+// If userPin is true, the pin is counted against the pinned quota of the
+// batch of the chunk and storage.ErrQuotaExceeded is returned over it.
 // Provided batch is updated.
-func (db *DB) setPin(batch *leveldb.Batch, item shed.Item) (gcSizeChange int64, err error) {
+func (db *DB) setPin(batch *leveldb.Batch, item shed.Item, userPin bool) (gcSizeChange int64, err error) {
+	if userPin {
+		err = db.userPin(batch, item)
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	// Get the existing pin counter of the chunk
 	i, err := db.pinIndex.Get(item)
 	item.PinCounter = i.PinCounter
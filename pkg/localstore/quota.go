@@ -0,0 +1,377 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/penguintop/penguin/pkg/storage"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// quotaChunkSize is the number of bytes accounted for every chunk. Chunks
+// are accounted at their maximal size, as postage stamps pay for chunk
+// slots regardless of the chunk size.
+const quotaChunkSize = penguin.ChunkWithSpanSize
+
+// BatchQuota limits the number of bytes stored and pinned with chunks
+// stamped by a postage batch. Zero values mean no limit.
+type BatchQuota struct {
+	Stored uint64 `json:"stored"`
+	Pinned uint64 `json:"pinned"`
+}
+
+// BatchUsage holds the number of bytes stored and pinned with chunks
+// stamped by a postage batch.
+type BatchUsage struct {
+	BatchID []byte
+	Stored  uint64
+	Pinned  uint64
+	// Quota is nil if the batch has no quota.
+	Quota *BatchQuota
+}
+
+// quotas enforces batch quotas. Usage is tracked in chunks only for
+// batches with a quota. All fields are protected by the batchMu lock.
+type quotas struct {
+	field  shed.StructField
+	limits map[string]BatchQuota
+	stored map[string]int64
+	pinned map[string]int64
+	// changes of the batch which is not yet written
+	pendingStored map[string]int64
+	pendingPinned map[string]int64
+}
+
+// initQuotas loads the batch quotas and counts the usage of their batches.
+func (db *DB) initQuotas() (err error) {
+	q := &quotas{
+		limits:        make(map[string]BatchQuota),
+		stored:        make(map[string]int64),
+		pinned:        make(map[string]int64),
+		pendingStored: make(map[string]int64),
+		pendingPinned: make(map[string]int64),
+	}
+	q.field, err = db.shed.NewStructField("batch-quotas")
+	if err != nil {
+		return err
+	}
+	limits := make(map[string]BatchQuota)
+	if err := q.field.Get(&limits); err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return err
+	}
+	db.quotas = q
+
+	for id, quota := range limits {
+		batchID, err := hex.DecodeString(id)
+		if err != nil {
+			return fmt.Errorf("batch quota %s: %w", id, err)
+		}
+		if err := db.trackQuota(batchID, quota); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackQuota sets the quota of the batch and counts its usage.
+// This function must be called under batchMu lock.
+func (db *DB) trackQuota(batchID []byte, quota BatchQuota) error {
+	stored, pinned, err := db.countBatchUsage(batchID)
+	if err != nil {
+		return err
+	}
+	key := string(batchID)
+	db.quotas.limits[key] = quota
+	db.quotas.stored[key] = int64(stored)
+	db.quotas.pinned[key] = int64(pinned)
+	return nil
+}
+
+// countBatchUsage counts the stored and user pinned chunks of the batch.
+func (db *DB) countBatchUsage(batchID []byte) (stored, pinned uint64, err error) {
+	err = db.postageChunksIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		stored++
+		return false, nil
+	}, &shed.IterateOptions{Prefix: batchID})
+	if err != nil {
+		return 0, 0, err
+	}
+	err = db.userPinIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		pinned++
+		return false, nil
+	}, &shed.IterateOptions{Prefix: batchID})
+	if err != nil {
+		return 0, 0, err
+	}
+	return stored, pinned, nil
+}
+
+// SetBatchQuota sets the quota of the postage batch. A zero quota
+// removes the limits of the batch.
+func (db *DB) SetBatchQuota(batchID []byte, quota BatchQuota) error {
+	db.batchMu.Lock()
+	defer db.batchMu.Unlock()
+
+	limits := make(map[string]BatchQuota)
+	for id, q := range db.quotas.limits {
+		limits[hex.EncodeToString([]byte(id))] = q
+	}
+	key := hex.EncodeToString(batchID)
+	if quota == (BatchQuota{}) {
+		delete(limits, key)
+	} else {
+		limits[key] = quota
+	}
+	if err := db.quotas.field.Put(limits); err != nil {
+		return err
+	}
+
+	if quota == (BatchQuota{}) {
+		delete(db.quotas.limits, string(batchID))
+		delete(db.quotas.stored, string(batchID))
+		delete(db.quotas.pinned, string(batchID))
+		return nil
+	}
+	if _, ok := db.quotas.limits[string(batchID)]; ok {
+		db.quotas.limits[string(batchID)] = quota
+		return nil
+	}
+	return db.trackQuota(batchID, quota)
+}
+
+// BatchUsage returns the usage and quota of the postage batch.
+func (db *DB) BatchUsage(batchID []byte) (*BatchUsage, error) {
+	db.batchMu.Lock()
+	quota, ok := db.quotas.limits[string(batchID)]
+	if ok {
+		defer db.batchMu.Unlock()
+		return &BatchUsage{
+			BatchID: batchID,
+			Stored:  uint64(db.quotas.stored[string(batchID)]) * quotaChunkSize,
+			Pinned:  uint64(db.quotas.pinned[string(batchID)]) * quotaChunkSize,
+			Quota:   &quota,
+		}, nil
+	}
+	db.batchMu.Unlock()
+
+	stored, pinned, err := db.countBatchUsage(batchID)
+	if err != nil {
+		return nil, err
+	}
+	return &BatchUsage{
+		BatchID: batchID,
+		Stored:  stored * quotaChunkSize,
+		Pinned:  pinned * quotaChunkSize,
+	}, nil
+}
+
+// BatchUsages returns the usage of all postage batches with stored chunks
+// or a quota, ordered by batch ID. The usage is counted without blocking
+// writes and may not reflect concurrent changes.
+func (db *DB) BatchUsages() ([]BatchUsage, error) {
+	usage := make(map[string]*BatchUsage)
+	get := func(batchID []byte) *BatchUsage {
+		u, ok := usage[string(batchID)]
+		if !ok {
+			u = &BatchUsage{BatchID: append([]byte(nil), batchID...)}
+			usage[string(batchID)] = u
+		}
+		return u
+	}
+
+	err := db.postageChunksIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		get(item.BatchID).Stored += quotaChunkSize
+		return false, nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.userPinIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		get(item.BatchID).Pinned += quotaChunkSize
+		return false, nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	db.batchMu.Lock()
+	for id, quota := range db.quotas.limits {
+		quota := quota
+		get([]byte(id)).Quota = &quota
+	}
+	db.batchMu.Unlock()
+
+	usages := make([]BatchUsage, 0, len(usage))
+	for _, u := range usage {
+		usages = append(usages, *u)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return string(usages[i].BatchID) < string(usages[j].BatchID)
+	})
+	return usages, nil
+}
+
+// quotaStore accounts a new stored chunk of the batch. If enforce is true,
+// storage.ErrQuotaExceeded is returned if the chunk exceeds the quota.
+// This function must be called under batchMu lock.
+func (db *DB) quotaStore(batchID []byte, enforce bool) error {
+	q := db.quotas
+	key := string(batchID)
+	quota, ok := q.limits[key]
+	if !ok {
+		return nil
+	}
+	stored := q.stored[key] + q.pendingStored[key] + 1
+	if enforce && quota.Stored > 0 && uint64(stored)*quotaChunkSize > quota.Stored {
+		return fmt.Errorf("stored quota of batch %x: %w", batchID, storage.ErrQuotaExceeded)
+	}
+	q.pendingStored[key]++
+	return nil
+}
+
+// quotaPin accounts a newly user pinned chunk of the batch. It returns
+// storage.ErrQuotaExceeded if the chunk exceeds the quota.
+// This function must be called under batchMu lock.
+func (db *DB) quotaPin(batchID []byte) error {
+	q := db.quotas
+	key := string(batchID)
+	quota, ok := q.limits[key]
+	if !ok {
+		return nil
+	}
+	pinned := q.pinned[key] + q.pendingPinned[key] + 1
+	if quota.Pinned > 0 && uint64(pinned)*quotaChunkSize > quota.Pinned {
+		return fmt.Errorf("pinned quota of batch %x: %w", batchID, storage.ErrQuotaExceeded)
+	}
+	q.pendingPinned[key]++
+	return nil
+}
+
+// userPinItem returns the item of the user pin index for the chunk. The
+// batch of the chunk is looked up if the item does not have it. It returns
+// false if the chunk is not stored.
+func (db *DB) userPinItem(item shed.Item) (shed.Item, bool, error) {
+	if len(item.BatchID) == 0 {
+		header, err := db.retrievalHeaderIndex.Get(item)
+		if err != nil {
+			if errors.Is(err, leveldb.ErrNotFound) {
+				return item, false, nil
+			}
+			return item, false, err
+		}
+		item.BatchID = header.BatchID
+	}
+	i, err := db.userPinIndex.Get(item)
+	if err != nil {
+		if errors.Is(err, leveldb.ErrNotFound) {
+			item.PinCounter = 0
+			return item, true, nil
+		}
+		return item, false, err
+	}
+	item.PinCounter = i.PinCounter
+	return item, true, nil
+}
+
+// userPin increments the user pin counter of the chunk. The first pin is
+// accounted in the pinned quota of the batch of the chunk.
+// This function must be called under batchMu lock.
+func (db *DB) userPin(batch *leveldb.Batch, item shed.Item) error {
+	item, ok, err := db.userPinItem(item)
+	if err != nil || !ok {
+		return err
+	}
+	if item.PinCounter == 0 {
+		if err := db.quotaPin(item.BatchID); err != nil {
+			return err
+		}
+	}
+	item.PinCounter++
+	return db.userPinIndex.PutInBatch(batch, item)
+}
+
+// userUnpin decrements the user pin counter of the chunk. The last unpin
+// releases the pinned quota of the batch of the chunk.
+// This function must be called under batchMu lock.
+func (db *DB) userUnpin(batch *leveldb.Batch, item shed.Item) error {
+	item, ok, err := db.userPinItem(item)
+	if err != nil || !ok || item.PinCounter == 0 {
+		return err
+	}
+	if item.PinCounter > 1 {
+		item.PinCounter--
+		return db.userPinIndex.PutInBatch(batch, item)
+	}
+	db.quotaRelease(item.BatchID, false, true)
+	return db.userPinIndex.DeleteInBatch(batch, item)
+}
+
+// quotaRelease accounts a removed stored or unpinned chunk of the batch.
+// This function must be called under batchMu lock.
+func (db *DB) quotaRelease(batchID []byte, stored, pinned bool) {
+	q := db.quotas
+	key := string(batchID)
+	if _, ok := q.limits[key]; !ok {
+		return
+	}
+	if stored {
+		q.pendingStored[key]--
+	}
+	if pinned {
+		q.pendingPinned[key]--
+	}
+}
+
+// deletePinInBatch deletes the pin index entries of the removed chunk
+// and releases its pinned quota.
+// This function must be called under batchMu lock.
+func (db *DB) deletePinInBatch(batch *leveldb.Batch, item shed.Item) error {
+	pinned, err := db.userPinIndex.Has(item)
+	if err != nil {
+		return err
+	}
+	if pinned {
+		db.quotaRelease(item.BatchID, false, true)
+		if err := db.userPinIndex.DeleteInBatch(batch, item); err != nil {
+			return err
+		}
+	}
+	return db.pinIndex.DeleteInBatch(batch, item)
+}
+
+// commitQuotas applies the usage changes of a written batch.
+// This function must be called under batchMu lock.
+func (db *DB) commitQuotas() {
+	q := db.quotas
+	for key, n := range q.pendingStored {
+		if _, ok := q.limits[key]; ok {
+			q.stored[key] += n
+		}
+	}
+	for key, n := range q.pendingPinned {
+		if _, ok := q.limits[key]; ok {
+			q.pinned[key] += n
+		}
+	}
+	db.discardQuotas()
+}
+
+// discardQuotas drops the usage changes of a batch which was not written.
+// This function must be called under batchMu lock.
+func (db *DB) discardQuotas() {
+	q := db.quotas
+	if len(q.pendingStored) > 0 {
+		q.pendingStored = make(map[string]int64)
+	}
+	if len(q.pendingPinned) > 0 {
+		q.pendingPinned = make(map[string]int64)
+	}
+}
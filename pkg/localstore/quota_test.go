@@ -0,0 +1,329 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localstore
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/postage"
+	postagetesting "github.com/penguintop/penguin/pkg/postage/testing"
+	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/penguintop/penguin/pkg/storage"
+)
+
+// TestBatchQuotaStored validates that uploads over the stored quota of
+// a batch are rejected and that removed chunks release the quota.
+func TestBatchQuotaStored(t *testing.T) {
+	db := newTestDB(t, nil)
+	ctx := context.Background()
+
+	batchID := postagetesting.MustNewID()
+	chunks := generateQuotaTestChunks(batchID, 4)
+
+	if err := db.SetBatchQuota(batchID, BatchQuota{Stored: 3 * quotaChunkSize}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Put(ctx, storage.ModePutUpload, chunks[:3]...); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Put(ctx, storage.ModePutUpload, chunks[3])
+	if !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrQuotaExceeded)
+	}
+	_, err = db.Get(ctx, storage.ModeGetLookup, chunks[3].Address())
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+
+	// chunks stored over a rejected batch of puts are not accounted
+	_, err = db.Put(ctx, storage.ModePutUpload, chunks[2:]...)
+	if !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrQuotaExceeded)
+	}
+	checkBatchUsage(t, db, batchID, 3, 0)
+
+	if err := db.Set(ctx, storage.ModeSetRemove, chunks[0].Address()); err != nil {
+		t.Fatal(err)
+	}
+	checkBatchUsage(t, db, batchID, 2, 0)
+	if _, err := db.Put(ctx, storage.ModePutUpload, chunks[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	// other batches are not limited
+	other := generateQuotaTestChunks(postagetesting.MustNewID(), 4)
+	if _, err := db.Put(ctx, storage.ModePutUpload, other...); err != nil {
+		t.Fatal(err)
+	}
+
+	// removing the quota lifts the limit
+	if err := db.SetBatchQuota(batchID, BatchQuota{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Put(ctx, storage.ModePutUpload, chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := db.BatchUsage(batchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Quota != nil {
+		t.Fatalf("got quota %+v, want none", usage.Quota)
+	}
+	if usage.Stored != 4*quotaChunkSize {
+		t.Fatalf("got stored %d, want %d", usage.Stored, 4*quotaChunkSize)
+	}
+}
+
+// TestBatchQuotaPinned validates that pinning over the pinned quota of
+// a batch is rejected and that unpinned chunks release the quota.
+func TestBatchQuotaPinned(t *testing.T) {
+	db := newTestDB(t, nil)
+	ctx := context.Background()
+
+	batchID := postagetesting.MustNewID()
+	chunks := generateQuotaTestChunks(batchID, 3)
+	if _, err := db.Put(ctx, storage.ModePutUpload, chunks...); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(ctx, storage.ModeSetPin, chunks[0].Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	// usage of existing chunks is counted when the quota is set
+	if err := db.SetBatchQuota(batchID, BatchQuota{Pinned: 2 * quotaChunkSize}); err != nil {
+		t.Fatal(err)
+	}
+	checkBatchUsage(t, db, batchID, 3, 1)
+
+	if err := db.Set(ctx, storage.ModeSetPin, chunks[1].Address()); err != nil {
+		t.Fatal(err)
+	}
+	// pinning a pinned chunk again does not use the quota
+	if err := db.Set(ctx, storage.ModeSetPin, chunks[1].Address()); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Set(ctx, storage.ModeSetPin, chunks[2].Address())
+	if !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrQuotaExceeded)
+	}
+	checkBatchUsage(t, db, batchID, 3, 2)
+
+	if err := db.Set(ctx, storage.ModeSetUnpin, chunks[0].Address()); err != nil {
+		t.Fatal(err)
+	}
+	checkBatchUsage(t, db, batchID, 3, 1)
+	if err := db.Set(ctx, storage.ModeSetPin, chunks[2].Address()); err != nil {
+		t.Fatal(err)
+	}
+	checkBatchUsage(t, db, batchID, 3, 2)
+
+	usages, err := db.BatchUsages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 1 {
+		t.Fatalf("got %d batch usages, want 1", len(usages))
+	}
+	if u := usages[0]; u.Stored != 3*quotaChunkSize || u.Pinned != 2*quotaChunkSize || u.Quota == nil {
+		t.Fatalf("got usage %+v", u)
+	}
+}
+
+// TestBatchQuotaPinnedReserve validates that chunks preserved in the
+// reserve do not use the pinned quota of their batch.
+func TestBatchQuotaPinnedReserve(t *testing.T) {
+	t.Cleanup(setWithinRadiusFunc(func(_ *DB, _ shed.Item) bool { return true }))
+	db := newTestDB(t, nil)
+	ctx := context.Background()
+
+	batchID := postagetesting.MustNewID()
+	chunks := generateQuotaTestChunks(batchID, 2)
+	if err := db.UnreserveBatch(batchID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Put(ctx, storage.ModePutRequest, chunks...); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetBatchQuota(batchID, BatchQuota{Pinned: quotaChunkSize}); err != nil {
+		t.Fatal(err)
+	}
+	checkBatchUsage(t, db, batchID, 2, 0)
+
+	if err := db.Set(ctx, storage.ModeSetPin, chunks[0].Address()); err != nil {
+		t.Fatal(err)
+	}
+	checkBatchUsage(t, db, batchID, 2, 1)
+	err := db.Set(ctx, storage.ModeSetPin, chunks[1].Address())
+	if !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrQuotaExceeded)
+	}
+
+	// the user unpin keeps the chunk preserved in the reserve
+	if err := db.Set(ctx, storage.ModeSetUnpin, chunks[0].Address()); err != nil {
+		t.Fatal(err)
+	}
+	checkBatchUsage(t, db, batchID, 2, 0)
+	pinned, err := db.pinIndex.Has(addressToItem(chunks[0].Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pinned {
+		t.Fatal("reserve pin removed by the user unpin")
+	}
+
+	// removed chunks release the quota
+	if err := db.Set(ctx, storage.ModeSetPin, chunks[1].Address()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(ctx, storage.ModeSetRemove, chunks[1].Address()); err != nil {
+		t.Fatal(err)
+	}
+	checkBatchUsage(t, db, batchID, 1, 0)
+}
+
+// TestBatchQuotaPersistence validates that quotas are loaded
+// when the database is opened.
+func TestBatchQuotaPersistence(t *testing.T) {
+	dir := t.TempDir()
+	baseKey := make([]byte, 32)
+	ctx := context.Background()
+
+	db, err := New(dir, baseKey, nil, logging.New(ioutil.Discard, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batchID := postagetesting.MustNewID()
+	chunks := generateQuotaTestChunks(batchID, 2)
+	if _, err := db.Put(ctx, storage.ModePutUpload, chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetBatchQuota(batchID, BatchQuota{Stored: quotaChunkSize}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = New(dir, baseKey, nil, logging.New(ioutil.Discard, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	checkBatchUsage(t, db, batchID, 1, 0)
+	_, err = db.Put(ctx, storage.ModePutUpload, chunks[1])
+	if !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrQuotaExceeded)
+	}
+}
+
+// TestBatchQuotaUserPinsMigration validates that the pins of the user
+// which existed before the user pin index are added to it by the
+// migration, leaving the pin of the reserve out.
+func TestBatchQuotaUserPinsMigration(t *testing.T) {
+	defer func(s string) {
+		DbSchemaCurrent = s
+	}(DbSchemaCurrent)
+	DbSchemaCurrent = DbSchemaBlobs
+	t.Cleanup(setWithinRadiusFunc(func(_ *DB, _ shed.Item) bool { return true }))
+
+	dir := t.TempDir()
+	baseKey := make([]byte, 32)
+	ctx := context.Background()
+
+	db, err := New(dir, baseKey, nil, logging.New(ioutil.Discard, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batchID := postagetesting.MustNewID()
+	chunks := generateQuotaTestChunks(batchID, 2)
+	if err := db.UnreserveBatch(batchID, 0); err != nil {
+		t.Fatal(err)
+	}
+	// the synced chunk is preserved in the reserve, the cached one is not
+	if _, err := db.Put(ctx, storage.ModePutUpload, chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(ctx, storage.ModeSetSync, chunks[0].Address()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Put(ctx, storage.ModePutRequestCache, chunks[1]); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []penguin.Address{chunks[0].Address(), chunks[1].Address(), chunks[1].Address()} {
+		if err := db.Set(ctx, storage.ModeSetPin, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SetBatchQuota(batchID, BatchQuota{Pinned: 10 * quotaChunkSize}); err != nil {
+		t.Fatal(err)
+	}
+	// pins of the schema before the user pin index
+	for _, ch := range chunks {
+		if err := db.userPinIndex.Delete(shed.Item{Address: ch.Address().Bytes(), BatchID: batchID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	DbSchemaCurrent = DbSchemaUserPins
+	db, err = New(dir, baseKey, nil, logging.New(ioutil.Discard, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	checkBatchUsage(t, db, batchID, 2, 2)
+	for i, want := range []uint64{1, 2} {
+		item, err := db.userPinIndex.Get(shed.Item{Address: chunks[i].Address().Bytes(), BatchID: batchID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.PinCounter != want {
+			t.Errorf("chunk %d: got %d user pins, want %d", i, item.PinCounter, want)
+		}
+	}
+
+	// the pinned cached chunk is not taken for a reserve chunk
+	report, err := db.Check(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Consistent() {
+		t.Fatalf("database not consistent after migration: %+v", report)
+	}
+}
+
+func checkBatchUsage(t *testing.T, db *DB, batchID []byte, stored, pinned uint64) {
+	t.Helper()
+
+	usage, err := db.BatchUsage(batchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Stored != stored*quotaChunkSize {
+		t.Errorf("got stored %d, want %d", usage.Stored, stored*quotaChunkSize)
+	}
+	if usage.Pinned != pinned*quotaChunkSize {
+		t.Errorf("got pinned %d, want %d", usage.Pinned, pinned*quotaChunkSize)
+	}
+}
+
+// generateQuotaTestChunks generates chunks stamped by the batch.
+func generateQuotaTestChunks(batchID []byte, count int) []penguin.Chunk {
+	chunks := generateValidTestChunks(count)
+	for i, ch := range chunks {
+		chunks[i] = ch.WithStamp(postage.NewStamp(batchID, postagetesting.MustNewSignature()))
+	}
+	return chunks
+}
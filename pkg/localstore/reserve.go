@@ -19,6 +19,7 @@ import (
 func (db *DB) UnreserveBatch(id []byte, radius uint8) error {
 	db.batchMu.Lock()
 	defer db.batchMu.Unlock()
+	defer db.discardQuotas()
 	var (
 		item = shed.Item{
 			BatchID: id,
@@ -78,6 +79,7 @@ func (db *DB) UnreserveBatch(id []byte, radius uint8) error {
 		if err := db.shed.WriteBatch(batch); err != nil {
 			return err
 		}
+		db.commitQuotas()
 		batch = new(leveldb.Batch)
 		gcSizeChange = 0
 	}
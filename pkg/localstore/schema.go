@@ -18,7 +18,7 @@ package localstore
 
 // The DB schema we want to use. The actual/current DB schema might differ
 // until migrations are run.
-var DbSchemaCurrent = DbSchemaYuj
+var DbSchemaCurrent = DbSchemaUserPins
 
 // There was a time when we had no schema at all.
 const DbSchemaNone = ""
@@ -29,3 +29,11 @@ const DbSchemaCode = "code"
 // DbSchemaYuj is the pen schema indentifier for storage incentives
 // initial iteration.
 const DbSchemaYuj = "yuj"
+
+// DbSchemaBlobs is the pen schema identifier for chunk data kept
+// in a ChunkBlobStore instead of the retrieval data index.
+const DbSchemaBlobs = "blobs"
+
+// DbSchemaUserPins is the pen schema identifier for the pins of the user
+// kept by postage batch apart from the pins of the reserve.
+const DbSchemaUserPins = "user-pins"
@@ -0,0 +1,41 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package node
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/penguintop/penguin/pkg/localstore"
+	"github.com/penguintop/penguin/pkg/localstore/blobstore"
+)
+
+const (
+	// BlobStoreLevelDB keeps chunk data in the localstore leveldb.
+	BlobStoreLevelDB = "leveldb"
+	// BlobStoreFlatFile keeps chunk data in sharded segment files.
+	BlobStoreFlatFile = "flatfile"
+)
+
+// InitChunkBlobStore creates the store for chunk data of the given kind in
+// the data directory. It returns nil if chunk data is kept in leveldb.
+func InitChunkBlobStore(kind, dataDir string) (localstore.ChunkBlobStore, error) {
+	switch kind {
+	case "", BlobStoreLevelDB:
+		return nil, nil
+	case BlobStoreFlatFile:
+		if dataDir == "" {
+			return nil, errors.New("flatfile blob store requires a data directory")
+		}
+		store, err := blobstore.NewSharded(filepath.Join(dataDir, "localstore-blobs"), nil)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", kind)
+	}
+}
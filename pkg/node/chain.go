@@ -153,6 +153,52 @@ func InitChequebookFactory(
 	return factory, nil
 }
 
+// InitTokenChequebookFactories will initialize the chequebook factories of the
+// additional tokens the node settles in.
+func InitTokenChequebookFactories(
+	logger logging.Logger,
+	backend *xwcclient.Client,
+	chainID int64,
+	transactionService transaction.Service,
+	factoryAddresses []string,
+) ([]chequebook.Factory, error) {
+	if len(factoryAddresses) == 0 {
+		return nil, nil
+	}
+
+	foundEntrance, found := chequebook.DiscoverEntranceAddress(chainID)
+	if !found {
+		return nil, errors.New("no known entrance address for this network")
+	}
+
+	factories := make([]chequebook.Factory, 0, len(factoryAddresses))
+	for _, factoryAddress := range factoryAddresses {
+		factoryAddressHex, err := xwcfmt.XwcConAddrToHexAddr(factoryAddress)
+		if err != nil {
+			return nil, errors.New("malformed token factory address")
+		}
+		factoryAddressBytes, _ := hex.DecodeString(factoryAddressHex)
+		var addr common.Address
+		addr.SetBytes(factoryAddressBytes)
+
+		logger.Infof("using token factory address: %s", factoryAddress)
+
+		factory := chequebook.NewFactory(
+			backend,
+			transactionService,
+			addr,
+			nil,
+		)
+		factory.SetEntranceAddress(foundEntrance)
+
+		factories = append(factories, factory)
+	}
+
+	return factories, nil
+}
+
+type chequebookInitFunc func(ctx context.Context, chequebookFactory chequebook.Factory, stateStore storage.StateStorer, logger logging.Logger, swapInitialDeposit *big.Int, transactionService transaction.Service, swapBackend transaction.Backend, chainId int64, overlayXwcAddress common.Address, chequeSigner chequebook.ChequeSigner) (chequebook.Service, error)
+
 // InitChequebookService will initialize the chequebook service with the given
 // chequebook factory and chain backend.
 func InitChequebookService(
@@ -167,6 +213,117 @@ func InitChequebookService(
 	chequebookFactory chequebook.Factory,
 	initialDeposit string,
 	deployGasPrice string,
+) (chequebook.Service, error) {
+	return initChequebookService(ctx, logger, stateStore, signer, chainID, backend, overlayXwcAddress, transactionService, chequebookFactory, initialDeposit, deployGasPrice, chequebook.Init)
+}
+
+// InitChequebooks will initialize the chequebooks of the additional tokens
+// with the given factories and collect them with the chequebook of the default
+// token.
+func InitChequebooks(
+	ctx context.Context,
+	logger logging.Logger,
+	stateStore storage.StateStorer,
+	signer crypto.Signer,
+	chainID int64,
+	backend *xwcclient.Client,
+	overlayXwcAddress common.Address,
+	transactionService transaction.Service,
+	defaultChequebook chequebook.Service,
+	tokenFactories []chequebook.Factory,
+	initialDeposit string,
+	deployGasPrice string,
+) (chequebook.Chequebooks, error) {
+	tokenChequebooks := make([]chequebook.Service, 0, len(tokenFactories))
+	for _, factory := range tokenFactories {
+		if err := factory.VerifyBytecode(ctx); err != nil {
+			return nil, fmt.Errorf("token factory fail: %w", err)
+		}
+
+		tokenChequebook, err := initChequebookService(ctx, logger, stateStore, signer, chainID, backend, overlayXwcAddress, transactionService, factory, initialDeposit, deployGasPrice, chequebook.InitForToken)
+		if err != nil {
+			return nil, err
+		}
+		tokenChequebooks = append(tokenChequebooks, tokenChequebook)
+	}
+
+	return chequebook.NewChequebooks(defaultChequebook, tokenChequebooks...)
+}
+
+// TransferChequebookOwnership transfers the existing chequebooks of the node
+// for the default token and the additional tokens to the owner of newSigner.
+// Outstanding cheques are re-signed with newSigner. All chequebooks are opened
+// before the first one is transferred. Tokens the node has no chequebook for
+// and chequebooks transferred to the new owner by a previous run are skipped,
+// so that an interrupted transfer can be completed by running it again. In a
+// dry run nothing is changed and only the planned transfers are returned.
+func TransferChequebookOwnership(
+	ctx context.Context,
+	stateStore storage.StateStorer,
+	signer crypto.Signer,
+	newSigner crypto.Signer,
+	chainID int64,
+	backend *xwcclient.Client,
+	overlayXwcAddress common.Address,
+	transactionService transaction.Service,
+	chequebookFactory chequebook.Factory,
+	tokenFactories []chequebook.Factory,
+	dryRun bool,
+) ([]*chequebook.OwnershipTransfer, error) {
+	newOwner, err := newSigner.XwcAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	chequeSigner := chequebook.NewChequeSigner(signer, chainID)
+
+	chequebookService, err := chequebook.Open(ctx, chequebookFactory, stateStore, transactionService, backend, overlayXwcAddress, chequeSigner)
+	if err != nil {
+		return nil, fmt.Errorf("chequebook open: %w", err)
+	}
+
+	chequebookServices := []chequebook.Service{chequebookService}
+	for _, factory := range tokenFactories {
+		tokenChequebook, err := chequebook.OpenForToken(ctx, factory, stateStore, transactionService, backend, overlayXwcAddress, chequeSigner)
+		if err != nil {
+			if errors.Is(err, chequebook.ErrNoChequebook) {
+				continue
+			}
+			return nil, fmt.Errorf("token chequebook open: %w", err)
+		}
+		chequebookServices = append(chequebookServices, tokenChequebook)
+	}
+
+	newChequeSigner := chequebook.NewChequeSigner(newSigner, chainID)
+
+	transfers := make([]*chequebook.OwnershipTransfer, 0, len(chequebookServices))
+	for _, chequebookService := range chequebookServices {
+		transfer, err := chequebookService.TransferOwnership(ctx, newOwner, newChequeSigner, dryRun)
+		if errors.Is(err, chequebook.ErrAlreadyTransferred) {
+			continue
+		}
+		if err != nil {
+			return transfers, fmt.Errorf("transfer chequebook %x: %w", chequebookService.Address(), err)
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, nil
+}
+
+func initChequebookService(
+	ctx context.Context,
+	logger logging.Logger,
+	stateStore storage.StateStorer,
+	signer crypto.Signer,
+	chainID int64,
+	backend *xwcclient.Client,
+	overlayXwcAddress common.Address,
+	transactionService transaction.Service,
+	chequebookFactory chequebook.Factory,
+	initialDeposit string,
+	deployGasPrice string,
+	initChequebook chequebookInitFunc,
 ) (chequebook.Service, error) {
 	chequeSigner := chequebook.NewChequeSigner(signer, chainID)
 
@@ -190,7 +347,7 @@ func InitChequebookService(
 	}
 
 	// modify to transfer to factory address
-	chequebookService, err := chequebook.Init(
+	chequebookService, err := initChequebook(
 		ctx,
 		chequebookFactory,
 		stateStore,
@@ -213,6 +370,7 @@ func initChequeStoreCashout(
 	stateStore storage.StateStorer,
 	swapBackend transaction.Backend,
 	chequebookFactory chequebook.Factory,
+	tokenFactories []chequebook.Factory,
 	chainID int64,
 	overlayEthAddress common.Address,
 	transactionService transaction.Service,
@@ -225,6 +383,7 @@ func initChequeStoreCashout(
 		transactionService,
 		//TODO
 		chequebook.RecoverCheque,
+		tokenFactories...,
 	)
 
 	cashout := chequebook.NewCashoutService(
@@ -244,19 +403,29 @@ func InitSwap(
 	stateStore storage.StateStorer,
 	networkID uint64,
 	overlayEthAddress common.Address,
-	chequebookService chequebook.Service,
+	chequebooks chequebook.Chequebooks,
 	chequeStore chequebook.ChequeStore,
 	cashoutService chequebook.CashoutService,
 	accounting settlement.Accounting,
+	transactionService transaction.Service,
+	maxExposure string,
 ) (*swap.Service, error) {
-	swapProtocol := swapprotocol.New(p2ps, logger, overlayEthAddress)
+	maxTotalExposure, ok := new(big.Int).SetString(maxExposure, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid swap max exposure: %s", maxExposure)
+	}
+	if maxTotalExposure.Sign() == 0 {
+		maxTotalExposure = nil
+	}
+
+	swapProtocol := swapprotocol.New(p2ps, logger, overlayEthAddress, chequebooks.Tokens())
 	swapAddressBook := swap.NewAddressbook(stateStore)
 
 	swapService := swap.New(
 		swapProtocol,
 		logger,
 		stateStore,
-		chequebookService,
+		chequebooks,
 		chequeStore,
 		swapAddressBook,
 		networkID,
@@ -265,6 +434,8 @@ func InitSwap(
 		accounting,
 	)
 
+	swapService.SetRiskEngine(chequebook.NewRiskEngine(stateStore, transactionService, overlayEthAddress, chequebook.DefaultCollateralFactor, maxTotalExposure))
+
 	swapProtocol.SetSwap(swapService)
 
 	err := p2ps.AddProtocol(swapProtocol.Protocol())
@@ -0,0 +1,43 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package node
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/penguintop/penguin/pkg/localstore"
+)
+
+// InitGCPolicies returns the garbage collection policy of the given name.
+// The other policies are returned as shadow policies, so that their cache
+// hit rates can be compared in metrics.
+func InitGCPolicies(name string, batchValue func(batchID []byte) (*big.Int, error)) (localstore.GCPolicy, []localstore.GCPolicy, error) {
+	policies := []localstore.GCPolicy{
+		localstore.NewLRUPolicy(),
+		localstore.NewCostAwarePolicy(&localstore.CostAwarePolicyOptions{
+			BatchValue: batchValue,
+		}),
+	}
+	if name == "" {
+		name = localstore.GCPolicyLRU
+	}
+
+	var (
+		active  localstore.GCPolicy
+		shadows []localstore.GCPolicy
+	)
+	for _, p := range policies {
+		if p.Name() == name {
+			active = p
+		} else {
+			shadows = append(shadows, p)
+		}
+	}
+	if active == nil {
+		return nil, nil, fmt.Errorf("unknown gc policy %q", name)
+	}
+	return active, shadows, nil
+}
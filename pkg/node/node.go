@@ -43,6 +43,7 @@ import (
 	"github.com/penguintop/penguin/pkg/metrics"
 	"github.com/penguintop/penguin/pkg/netstore"
 	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/p2p/bandwidth"
 	"github.com/penguintop/penguin/pkg/p2p/libp2p"
 	"github.com/penguintop/penguin/pkg/pingpong"
 	"github.com/penguintop/penguin/pkg/pinning"
@@ -60,6 +61,7 @@ import (
 	"github.com/penguintop/penguin/pkg/pusher"
 	"github.com/penguintop/penguin/pkg/pushsync"
 	"github.com/penguintop/penguin/pkg/recovery"
+	"github.com/penguintop/penguin/pkg/reputation"
 	"github.com/penguintop/penguin/pkg/resolver/multiresolver"
 	"github.com/penguintop/penguin/pkg/retrieval"
 	"github.com/penguintop/penguin/pkg/settlement/pseudosettle"
@@ -94,6 +96,8 @@ type Pen struct {
 	tracerCloser             io.Closer
 	tagsCloser               io.Closer
 	stateStoreCloser         io.Closer
+	kademliaMetricsCloser    io.Closer
+	reputationCloser         io.Closer
 	localstoreCloser         io.Closer
 	topologyCloser           io.Closer
 	topologyHalter           topology.Halter
@@ -106,6 +110,7 @@ type Pen struct {
 	recoveryHandleCleanup    func()
 	listenerCloser           io.Closer
 	postageServiceCloser     io.Closer
+	swapCloser               io.Closer
 }
 
 type Options struct {
@@ -115,12 +120,25 @@ type Options struct {
 	DBWriteBufferSize          uint64
 	DBBlockCacheCapacity       uint64
 	DBDisableSeeksCompaction   bool
+	DBBlobStore                string
+	GCPolicy                   string
+	DBHotCacheSize             uint64
+	DBDurability               string
+	StateStoreBackend          string
 	APIAddr                    string
 	DebugAPIAddr               string
 	Addr                       string
 	NATAddr                    string
 	EnableWS                   bool
 	EnableQUIC                 bool
+	EnableRelay                bool
+	RelayServer                bool
+	Relays                     []string
+	BandwidthLimit             int
+	BandwidthProtocolLimits    []string
+	MaxInboundConnections      int
+	MaxOutboundConnections     int
+	MaxConnections             int
 	WelcomeMessage             string
 	Bootnodes                  []string
 	CORSAllowedOrigins         []string
@@ -130,6 +148,7 @@ type Options struct {
 	TracingEndpoint            string
 	TracingServiceName         string
 	GlobalPinningEnabled       bool
+	RetrievalLatencyPeers      int
 	PaymentThreshold           string
 	PaymentTolerance           string
 	PaymentEarly               string
@@ -139,7 +158,9 @@ type Options struct {
 	SwapEndpoint               string
 	SwapFactoryAddress         string
 	SwapLegacyFactoryAddresses []string
+	SwapTokenFactoryAddresses  []string
 	SwapInitialDeposit         string
+	SwapMaxExposure            string
 	SwapEnable                 bool
 	FullNodeMode               bool
 	Transaction                string
@@ -217,7 +238,7 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 		b.debugAPIServer = debugAPIServer
 	}
 
-	stateStore, err := InitStateStore(logger, o.DataDir)
+	stateStore, err := InitStateStore(logger, o.DataDir, o.StateStoreBackend)
 	if err != nil {
 		return nil, err
 	}
@@ -239,6 +260,8 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 		transactionMonitor transaction.Monitor
 		chequebookFactory  chequebook.Factory
 		chequebookService  chequebook.Service
+		chequebooks        chequebook.Chequebooks
+		tokenFactories     []chequebook.Factory
 		chequeStore        chequebook.ChequeStore
 		cashoutService     chequebook.CashoutService
 	)
@@ -292,10 +315,40 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 			return nil, err
 		}
 
+		tokenFactories, err = InitTokenChequebookFactories(
+			logger,
+			swapBackend,
+			chainID,
+			transactionService,
+			o.SwapTokenFactoryAddresses,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		chequebooks, err = InitChequebooks(
+			p2pCtx,
+			logger,
+			stateStore,
+			signer,
+			chainID,
+			swapBackend,
+			overlayXwcAddress,
+			transactionService,
+			chequebookService,
+			tokenFactories,
+			o.SwapInitialDeposit,
+			o.DeployGasPrice,
+		)
+		if err != nil {
+			return nil, err
+		}
+
 		chequeStore, cashoutService = initChequeStoreCashout(
 			stateStore,
 			swapBackend,
 			chequebookFactory,
+			tokenFactories,
 			chainID,
 			overlayXwcAddress,
 			transactionService,
@@ -304,6 +357,16 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 
 	lightNodes := lightnode.NewContainer(penguinAddress)
 
+	bandwidthLimits, err := bandwidth.ParseLimits(o.BandwidthProtocolLimits)
+	if err != nil {
+		return nil, fmt.Errorf("bandwidth limits: %w", err)
+	}
+	bandwidthAccountant := bandwidth.New(bandwidth.Options{
+		Total:       bandwidth.Limit{Rate: o.BandwidthLimit},
+		Protocols:   bandwidthLimits,
+		LowPriority: bandwidth.DefaultLowPriorityProtocols,
+	})
+
 	txHash, err := getTxHash(stateStore, logger, o)
 	if err != nil {
 		return nil, fmt.Errorf("invalid transaction hash: %w", err)
@@ -316,10 +379,18 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 		NATAddr:        o.NATAddr,
 		EnableWS:       o.EnableWS,
 		EnableQUIC:     o.EnableQUIC,
+		EnableRelay:    o.EnableRelay,
+		RelayServer:    o.RelayServer,
+		Relays:         o.Relays,
+		Bandwidth:      bandwidthAccountant,
 		Standalone:     o.Standalone,
 		WelcomeMessage: o.WelcomeMessage,
 		FullNode:       o.FullNodeMode,
 		Transaction:    txHash,
+
+		MaxInboundConnections:  o.MaxInboundConnections,
+		MaxOutboundConnections: o.MaxOutboundConnections,
+		MaxConnections:         o.MaxConnections,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("p2p service: %w", err)
@@ -334,21 +405,53 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 		logger.Infof("using datadir in: '%s'", o.DataDir)
 		path = filepath.Join(o.DataDir, "localstore")
 	}
+	durability, err := localstore.ParseDurabilityMode(o.DBDurability)
+	if err != nil {
+		return nil, fmt.Errorf("db durability: %w", err)
+	}
+	blobStore, err := InitChunkBlobStore(o.DBBlobStore, o.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("blob store: %w", err)
+	}
+	// the batch store depends on the localstore, batch values
+	// are looked up once it is created
+	var batchStore postage.Storer
+	gcPolicy, gcShadowPolicies, err := InitGCPolicies(o.GCPolicy, func(batchID []byte) (*big.Int, error) {
+		if batchStore == nil {
+			return nil, errors.New("batch store not initialized")
+		}
+		b, err := batchStore.Get(batchID)
+		if err != nil {
+			return nil, err
+		}
+		return b.Value, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gc policy: %w", err)
+	}
 	lo := &localstore.Options{
 		Capacity:               o.CacheCapacity,
 		OpenFilesLimit:         o.DBOpenFilesLimit,
 		BlockCacheCapacity:     o.DBBlockCacheCapacity,
 		WriteBufferSize:        o.DBWriteBufferSize,
 		DisableSeeksCompaction: o.DBDisableSeeksCompaction,
+		BlobStore:              blobStore,
+		GCPolicy:               gcPolicy,
+		GCShadowPolicies:       gcShadowPolicies,
+		HotCacheSize:           o.DBHotCacheSize,
+		Durability:             durability,
 	}
 
 	storer, err := localstore.New(path, penguinAddress.Bytes(), lo, logger)
 	if err != nil {
+		if blobStore != nil {
+			_ = blobStore.Close()
+		}
 		return nil, fmt.Errorf("localstore: %w", err)
 	}
 	b.localstoreCloser = storer
 
-	batchStore, err := batchstore.New(stateStore, storer.UnreserveBatch)
+	batchStore, err = batchstore.New(stateStore, storer.UnreserveBatch)
 	if err != nil {
 		return nil, fmt.Errorf("batchstore: %w", err)
 	}
@@ -493,10 +596,14 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 		return nil, fmt.Errorf("pingpong service: %w", err)
 	}
 
-	hive := hive.New(p2ps, addressbook, networkID, logger)
+	hive := hive.New(p2ps, addressbook, networkID, penguinAddress, signer, logger)
+	hive.SetAdvertisedUnderlayer(p2ps)
 	if err = p2ps.AddProtocol(hive.Protocol()); err != nil {
 		return nil, fmt.Errorf("hive service: %w", err)
 	}
+	if err = p2ps.AddProtocol(hive.ProtocolV2()); err != nil {
+		return nil, fmt.Errorf("hive v2 service: %w", err)
+	}
 
 	var bootnodes []ma.Multiaddr
 	if o.Standalone {
@@ -516,16 +623,39 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 
 	var swapService *swap.Service
 
-	metricsDB, err := shed.NewDBWrap(stateStore.DB())
+	var metricsDB *shed.DB
+	if ldb := stateStore.DB(); ldb != nil {
+		metricsDB, err = shed.NewDBWrap(ldb)
+	} else {
+		// the statestore is not backed by leveldb,
+		// so kademlia metrics are kept in a separate database
+		var path string
+		if o.DataDir != "" {
+			path = filepath.Join(o.DataDir, "kademlia-metrics")
+		}
+		metricsDB, err = shed.NewDB(path, nil)
+		if err == nil {
+			b.kademliaMetricsCloser = metricsDB
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to create metrics storage for kademlia: %w", err)
 	}
 
-	kad := kademlia.New(penguinAddress, addressbook, hive, p2ps, metricsDB, logger, kademlia.Options{Bootnodes: bootnodes, StandaloneMode: o.Standalone, BootnodeMode: o.BootnodeMode})
+	reputationService, err := reputation.New(stateStore, logger, reputation.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("reputation service: %w", err)
+	}
+	b.reputationCloser = reputationService
+
+	kad := kademlia.New(penguinAddress, addressbook, hive, p2ps, metricsDB, logger, kademlia.Options{Bootnodes: bootnodes, StandaloneMode: o.Standalone, BootnodeMode: o.BootnodeMode, PeerScorer: reputationService, StateStore: stateStore, ConnectionStats: p2ps.ConnectionStats, ConnectionAllowed: p2ps.ConnectionAllowed, Pinger: pingPong})
 	b.topologyCloser = kad
 	b.topologyHalter = kad
 	hive.SetAddPeersHandler(kad.AddPeers)
+	hive.SetReputation(reputationService)
 	p2ps.SetPickyNotifier(kad)
+	p2ps.SetConnectionProtector(kad.IsWithinDepth)
+	p2ps.SetConnectionScorer(reputationService)
 	batchStore.SetRadiusSetter(kad)
 
 	if batchSvc != nil {
@@ -594,6 +724,8 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 		return nil, fmt.Errorf("pseudosettle service: %w", err)
 	}
 
+	pseudosettleService.SetReputation(reputationService)
+	pseudosettleService.SetScorer(reputationService)
 	acc.SetRefreshFunc(pseudosettleService.Pay)
 
 	if o.SwapEnable {
@@ -603,20 +735,28 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 			stateStore,
 			networkID,
 			overlayXwcAddress,
-			chequebookService,
+			chequebooks,
 			chequeStore,
 			cashoutService,
 			acc,
+			transactionService,
+			o.SwapMaxExposure,
 		)
 		if err != nil {
 			return nil, err
 		}
+		swapService.SetReputation(reputationService)
 		acc.SetPayFunc(swapService.Pay)
+		b.swapCloser = swapService
 	}
 
 	pricing.SetPaymentThresholdObserver(acc)
 
 	retrieve := retrieval.New(penguinAddress, storer, p2ps, kad, logger, acc, pricer, tracer)
+	retrieve.SetReputation(reputationService)
+	if o.RetrievalLatencyPeers > 1 {
+		retrieve.SetPeerLatencies(kad, o.RetrievalLatencyPeers)
+	}
 	tagService := tags.NewTags(stateStore, logger)
 	b.tagsCloser = tagService
 
@@ -637,6 +777,7 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 	pinningService := pinning.NewService(storer, stateStore, traversalService)
 
 	pushSyncProtocol := pushsync.New(penguinAddress, p2ps, storer, kad, tagService, o.FullNodeMode, pssService.TryUnwrap, validStamp, logger, acc, pricer, signer, tracer)
+	pushSyncProtocol.SetReputation(reputationService)
 
 	// set the pushSyncer in the PSS
 	pssService.SetPushSyncer(pushSyncProtocol)
@@ -653,6 +794,7 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 	pullStorage := pullstorage.New(storer)
 
 	pullSyncProtocol := pullsync.New(p2ps, pullStorage, pssService.TryUnwrap, validStamp, logger)
+	pullSyncProtocol.SetReputation(reputationService)
 	b.pullSyncCloser = pullSyncProtocol
 
 	var pullerService *puller.Puller
@@ -729,6 +871,7 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 	if debugAPIService != nil {
 		// register metrics from components
 		debugAPIService.MustRegisterMetrics(p2ps.Metrics()...)
+		debugAPIService.MustRegisterMetrics(bandwidthAccountant.Metrics()...)
 		debugAPIService.MustRegisterMetrics(pingPong.Metrics()...)
 		debugAPIService.MustRegisterMetrics(acc.Metrics()...)
 		debugAPIService.MustRegisterMetrics(storer.Metrics()...)
@@ -771,7 +914,7 @@ func NewPen(addr string, penguinAddress penguin.Address, publicKey ecdsa.PublicK
 		}
 
 		// inject dependencies and configure full debug api http path routes
-		debugAPIService.Configure(p2ps, pingPong, kad, lightNodes, storer, tagService, acc, pseudosettleService, o.SwapEnable, swapService, chequebookService, batchStore)
+		debugAPIService.Configure(p2ps, pingPong, kad, lightNodes, storer, tagService, acc, pseudosettleService, o.SwapEnable, swapService, chequebooks, batchStore, reputationService, addressbook, bandwidthAccountant)
 	}
 
 	if err := kad.Start(p2pCtx); err != nil {
@@ -855,6 +998,7 @@ func (b *Pen) Shutdown(ctx context.Context) error {
 	wg.Wait()
 
 	tryClose(b.p2pService, "p2p server")
+	tryClose(b.swapCloser, "swap")
 
 	wg.Add(3)
 	go func() {
@@ -879,7 +1023,9 @@ func (b *Pen) Shutdown(ctx context.Context) error {
 	tryClose(b.tracerCloser, "tracer")
 	tryClose(b.tagsCloser, "tag persistence")
 	tryClose(b.topologyCloser, "topology driver")
+	tryClose(b.reputationCloser, "reputation")
 	tryClose(b.stateStoreCloser, "statestore")
+	tryClose(b.kademliaMetricsCloser, "kademlia metrics")
 	tryClose(b.localstoreCloser, "localstore")
 	tryClose(b.errorLogWriter, "error log writer")
 	tryClose(b.resolverCloser, "resolver service")
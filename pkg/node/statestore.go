@@ -7,25 +7,154 @@ package node
 import (
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/statestore"
+	"github.com/penguintop/penguin/pkg/statestore/bolt"
 	"github.com/penguintop/penguin/pkg/statestore/leveldb"
 	"github.com/penguintop/penguin/pkg/statestore/mock"
 	"github.com/penguintop/penguin/pkg/storage"
     "github.com/penguintop/penguin/pkg/penguin"
 )
 
+const (
+	// StateStoreLevelDB keeps the node state in a LevelDB database.
+	StateStoreLevelDB = "leveldb"
+	// StateStoreBolt keeps the node state in a BoltDB file.
+	StateStoreBolt = "bolt"
+)
+
 // InitStateStore will initialize the stateStore with the given path to the
 // data directory. When given an empty directory path, the function will instead
-// initialize an in-memory state store that will not be persisted.
-func InitStateStore(log logging.Logger, dataDir string) (ret storage.StateStorer, err error) {
+// initialize an in-memory state store that will not be persisted. The backend
+// of an existing state store is detected, while a new state store is created
+// with the given backend, or with LevelDB if the backend is empty.
+func InitStateStore(log logging.Logger, dataDir, backend string) (ret storage.StateStorer, err error) {
 	if dataDir == "" {
 		ret = mock.NewStateStore()
 		log.Warning("using in-mem state store, no node state will be persisted")
 		return ret, nil
 	}
-	return leveldb.NewStateStore(filepath.Join(dataDir, "statestore"), log)
+	existing, err := DetectStateStoreBackend(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case existing == "":
+		if backend == "" {
+			backend = StateStoreLevelDB
+		}
+	case backend == "" || backend == existing:
+		backend = existing
+	default:
+		return nil, fmt.Errorf("statestore uses the %s backend, migrate it to the %s backend first", existing, backend)
+	}
+	return OpenStateStore(log, dataDir, backend)
+}
+
+// OpenStateStore opens or creates the state store of the backend
+// in the data directory.
+func OpenStateStore(log logging.Logger, dataDir, backend string) (storage.StateStorer, error) {
+	path, err := StateStorePath(dataDir, backend)
+	if err != nil {
+		return nil, err
+	}
+	switch backend {
+	case StateStoreBolt:
+		return bolt.NewStateStore(path, log)
+	default:
+		return leveldb.NewStateStore(path, log)
+	}
+}
+
+// StateStorePath returns the path of the state store
+// of the backend in the data directory.
+func StateStorePath(dataDir, backend string) (string, error) {
+	switch backend {
+	case StateStoreLevelDB:
+		return filepath.Join(dataDir, "statestore"), nil
+	case StateStoreBolt:
+		return filepath.Join(dataDir, "statestore.db"), nil
+	default:
+		return "", fmt.Errorf("unknown statestore backend %q", backend)
+	}
+}
+
+// DetectStateStoreBackend returns the backend of the state store in the
+// data directory, or an empty string if there is no state store.
+func DetectStateStoreBackend(dataDir string) (backend string, err error) {
+	for _, b := range []string{StateStoreLevelDB, StateStoreBolt} {
+		path, err := StateStorePath(dataDir, b)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", err
+		}
+		if backend != "" {
+			return "", fmt.Errorf("found both %s and %s statestores in %s", backend, b, dataDir)
+		}
+		backend = b
+	}
+	return backend, nil
+}
+
+// MigrateStateStore copies the state store in the data directory to a new
+// state store of the backend. The migrated state store is kept as a backup
+// at the returned path, so that the new one is used from now on.
+func MigrateStateStore(log logging.Logger, dataDir, backend string) (entries int, backupPath string, err error) {
+	from, err := DetectStateStoreBackend(dataDir)
+	if err != nil {
+		return 0, "", err
+	}
+	if from == "" {
+		return 0, "", fmt.Errorf("no statestore in %s", dataDir)
+	}
+	if from == backend {
+		return 0, "", fmt.Errorf("statestore already uses the %s backend", backend)
+	}
+	fromPath, err := StateStorePath(dataDir, from)
+	if err != nil {
+		return 0, "", err
+	}
+	toPath, err := StateStorePath(dataDir, backend)
+	if err != nil {
+		return 0, "", err
+	}
+
+	src, err := OpenStateStore(log, dataDir, from)
+	if err != nil {
+		return 0, "", fmt.Errorf("open %s statestore: %w", from, err)
+	}
+	dst, err := OpenStateStore(log, dataDir, backend)
+	if err != nil {
+		_ = src.Close()
+		return 0, "", fmt.Errorf("open %s statestore: %w", backend, err)
+	}
+	entries, err = statestore.Copy(dst, src)
+	if cerr := src.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.RemoveAll(toPath)
+		return 0, "", fmt.Errorf("copy statestore: %w", err)
+	}
+
+	backupPath = fmt.Sprintf("%s.%s-backup-%d", fromPath, from, time.Now().Unix())
+	if err := os.Rename(fromPath, backupPath); err != nil {
+		_ = os.RemoveAll(toPath)
+		return 0, "", fmt.Errorf("backup %s statestore: %w", from, err)
+	}
+	return entries, backupPath, nil
 }
 
 const overlayKey = "overlay"
@@ -46,3 +175,9 @@ func CheckOverlayWithStore(overlay penguin.Address, storer storage.StateStorer)
 	}
 	return nil
 }
+
+// ReplaceOverlayInStore replaces the overlay address recorded for the state
+// store. It is used when the node key is rotated and the overlay changes.
+func ReplaceOverlayInStore(overlay penguin.Address, storer storage.StateStorer) error {
+	return storer.Put(overlayKey, overlay)
+}
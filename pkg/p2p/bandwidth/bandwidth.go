@@ -0,0 +1,418 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bandwidth provides accounting and rate limiting of the bytes
+// transferred over p2p streams per protocol and per peer. Limits are
+// enforced with token buckets, one for every limited protocol and one
+// shared by all protocols. Low priority protocols yield the shared
+// bucket to the other protocols when they contend for it, so they are
+// prioritized only if the total limit is set.
+package bandwidth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"golang.org/x/time/rate"
+)
+
+// DefaultLowPriorityProtocols are the protocols which yield to the other
+// ones by default. Syncing can be delayed, while retrieval and push
+// syncing serve requests which are waited for.
+var DefaultLowPriorityProtocols = []string{"pullsync"}
+
+// ErrInvalidLimit is returned by ParseLimits for malformed limits.
+var ErrInvalidLimit = errors.New("invalid bandwidth limit")
+
+// Limit is a token bucket limit of the transferred bytes.
+type Limit struct {
+	// Rate is the number of bytes per second, zero means no limit.
+	Rate int `json:"rate"`
+	// Burst is the size of the bucket in bytes, if zero, Rate is used.
+	Burst int `json:"burst"`
+}
+
+func (l Limit) limiter() *rate.Limiter {
+	if l.Rate <= 0 {
+		return nil
+	}
+	burst := l.Burst
+	if burst <= 0 {
+		burst = l.Rate
+	}
+	return rate.NewLimiter(rate.Limit(l.Rate), burst)
+}
+
+// Options are the bandwidth limits.
+type Options struct {
+	// Total limits the traffic of all protocols together.
+	Total Limit
+	// Protocols limits the traffic of the protocols by their names.
+	Protocols map[string]Limit
+	// LowPriority are the names of the protocols which wait while the
+	// other protocols are waiting for the Total limit. They have no
+	// effect if the Total limit is not set, as nothing is waited for.
+	LowPriority []string
+}
+
+// ParseLimits parses the protocol limits in the form of
+// "protocol=rate" or "protocol=rate:burst" in bytes.
+func ParseLimits(limits []string) (map[string]Limit, error) {
+	m := make(map[string]Limit, len(limits))
+	for _, l := range limits {
+		i := strings.Index(l, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidLimit, l)
+		}
+		var (
+			limit      Limit
+			rateString = l[i+1:]
+			err        error
+		)
+		if j := strings.Index(rateString, ":"); j >= 0 {
+			if limit.Burst, err = strconv.Atoi(rateString[j+1:]); err != nil || limit.Burst < 0 {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidLimit, l)
+			}
+			rateString = rateString[:j]
+		}
+		if limit.Rate, err = strconv.Atoi(rateString); err != nil || limit.Rate < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidLimit, l)
+		}
+		m[l[:i]] = limit
+	}
+	return m, nil
+}
+
+// Stats are the numbers of transferred bytes.
+type Stats struct {
+	BytesIn  uint64 `json:"bytesIn"`
+	BytesOut uint64 `json:"bytesOut"`
+}
+
+// ProtocolStats are the transferred bytes of a protocol.
+type ProtocolStats struct {
+	Protocol    string `json:"protocol"`
+	Stats       Stats  `json:"stats"`
+	Limit       Limit  `json:"limit"`
+	LowPriority bool   `json:"lowPriority"`
+}
+
+// PeerStats are the transferred bytes of a connected peer.
+type PeerStats struct {
+	Address penguin.Address `json:"address"`
+	Stats   Stats           `json:"stats"`
+}
+
+// Snapshot holds the transferred bytes at one point in time.
+type Snapshot struct {
+	Total     Stats           `json:"total"`
+	Limit     Limit           `json:"limit"`
+	Protocols []ProtocolStats `json:"protocols"`
+	Peers     []PeerStats     `json:"peers"`
+}
+
+// Interface provides the bandwidth accounting.
+type Interface interface {
+	// Snapshot returns the transferred bytes per protocol and peer.
+	Snapshot() Snapshot
+}
+
+type counter struct {
+	in, out uint64
+}
+
+func (c *counter) add(direction string, n int) {
+	if direction == directionIn {
+		atomic.AddUint64(&c.in, uint64(n))
+	} else {
+		atomic.AddUint64(&c.out, uint64(n))
+	}
+}
+
+func (c *counter) stats() Stats {
+	return Stats{
+		BytesIn:  atomic.LoadUint64(&c.in),
+		BytesOut: atomic.LoadUint64(&c.out),
+	}
+}
+
+type protocol struct {
+	counter
+	limit       Limit
+	limiter     *rate.Limiter
+	lowPriority bool
+}
+
+const (
+	directionIn  = "in"
+	directionOut = "out"
+)
+
+// Accountant counts and limits the bytes transferred over the streams.
+type Accountant struct {
+	total      counter
+	totalLimit Limit
+	limiter    *rate.Limiter
+	limits     map[string]Limit
+	low        map[string]struct{}
+	gate       *gate
+
+	mu        sync.Mutex
+	protocols map[string]*protocol
+	peers     map[string]*counter
+
+	metrics metrics
+}
+
+var _ Interface = (*Accountant)(nil)
+
+// New creates a new bandwidth Accountant.
+func New(o Options) *Accountant {
+	a := &Accountant{
+		totalLimit: o.Total,
+		limiter:    o.Total.limiter(),
+		limits:     make(map[string]Limit, len(o.Protocols)),
+		low:        make(map[string]struct{}, len(o.LowPriority)),
+		gate:       newGate(),
+		protocols:  make(map[string]*protocol),
+		peers:      make(map[string]*counter),
+		metrics:    newMetrics(),
+	}
+	for name, l := range o.Protocols {
+		a.limits[name] = l
+	}
+	for _, name := range o.LowPriority {
+		a.low[name] = struct{}{}
+	}
+	return a
+}
+
+// Middleware returns the p2p.HandlerMiddleware which accounts the
+// streams handled by the protocol.
+func (a *Accountant) Middleware(protocolName string) p2p.HandlerMiddleware {
+	return func(h p2p.HandlerFunc) p2p.HandlerFunc {
+		return func(ctx context.Context, peer p2p.Peer, stream p2p.Stream) error {
+			return h(ctx, peer, a.Stream(protocolName, peer.Address, stream))
+		}
+	}
+}
+
+// Stream returns the stream which accounts the bytes transferred with the
+// peer over the protocol and waits for the protocol and total limits.
+func (a *Accountant) Stream(protocolName string, peer penguin.Address, s p2p.Stream) p2p.Stream {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &stream{
+		Stream:     s,
+		accountant: a,
+		name:       protocolName,
+		protocol:   a.protocol(protocolName),
+		peer:       a.peer(peer),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Disconnected removes the counters of the peer.
+func (a *Accountant) Disconnected(peer penguin.Address) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.peers, peer.ByteString())
+}
+
+// Snapshot returns the transferred bytes per protocol and peer.
+func (a *Accountant) Snapshot() Snapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := Snapshot{
+		Total:     a.total.stats(),
+		Limit:     a.totalLimit,
+		Protocols: make([]ProtocolStats, 0, len(a.protocols)),
+		Peers:     make([]PeerStats, 0, len(a.peers)),
+	}
+	for name, p := range a.protocols {
+		s.Protocols = append(s.Protocols, ProtocolStats{
+			Protocol:    name,
+			Stats:       p.stats(),
+			Limit:       p.limit,
+			LowPriority: p.lowPriority,
+		})
+	}
+	for key, c := range a.peers {
+		s.Peers = append(s.Peers, PeerStats{
+			Address: penguin.NewAddress([]byte(key)),
+			Stats:   c.stats(),
+		})
+	}
+	sort.Slice(s.Protocols, func(i, j int) bool {
+		return s.Protocols[i].Protocol < s.Protocols[j].Protocol
+	})
+	sort.Slice(s.Peers, func(i, j int) bool {
+		return s.Peers[i].Address.String() < s.Peers[j].Address.String()
+	})
+	return s
+}
+
+func (a *Accountant) protocol(name string) *protocol {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if p, ok := a.protocols[name]; ok {
+		return p
+	}
+	_, low := a.low[name]
+	p := &protocol{
+		limit:       a.limits[name],
+		limiter:     a.limits[name].limiter(),
+		lowPriority: low,
+	}
+	a.protocols[name] = p
+	return p
+}
+
+func (a *Accountant) peer(address penguin.Address) *counter {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := address.ByteString()
+	if c, ok := a.peers[key]; ok {
+		return c
+	}
+	c := new(counter)
+	a.peers[key] = c
+	return c
+}
+
+func (a *Accountant) account(s *stream, direction string, n int) {
+	a.total.add(direction, n)
+	s.protocol.add(direction, n)
+	s.peer.add(direction, n)
+	a.metrics.Bytes.WithLabelValues(s.name, direction).Add(float64(n))
+}
+
+// wait blocks until the protocol and the total limits allow the
+// transfer of n bytes. Low priority protocols wait for the total limit
+// only when no other protocol is waiting for it.
+func (a *Accountant) wait(s *stream, n int) error {
+	if s.protocol.limiter != nil {
+		if err := waitN(s.ctx, s.protocol.limiter, n); err != nil {
+			return err
+		}
+	}
+	if a.limiter == nil {
+		return nil
+	}
+	if s.protocol.lowPriority {
+		if err := a.gate.wait(s.ctx, func() {
+			a.metrics.YieldCount.WithLabelValues(s.name).Inc()
+		}); err != nil {
+			return err
+		}
+		return waitN(s.ctx, a.limiter, n)
+	}
+	a.gate.enter()
+	defer a.gate.leave()
+	return waitN(s.ctx, a.limiter, n)
+}
+
+// waitN waits for n tokens in the portions of the bucket size.
+func waitN(ctx context.Context, l *rate.Limiter, n int) error {
+	for n > 0 {
+		c := n
+		if b := l.Burst(); c > b {
+			c = b
+		}
+		if err := l.WaitN(ctx, c); err != nil {
+			return err
+		}
+		n -= c
+	}
+	return nil
+}
+
+// gate is open while no normal priority transfer waits for the total limit.
+type gate struct {
+	mu       sync.Mutex
+	waiting  int // normal priority transfers waiting for the total limit
+	yielding int // low priority transfers waiting for the gate to open
+	open     chan struct{}
+}
+
+func newGate() *gate {
+	open := make(chan struct{})
+	close(open)
+	return &gate{open: open}
+}
+
+func (g *gate) enter() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.waiting == 0 {
+		g.open = make(chan struct{})
+	}
+	g.waiting++
+}
+
+func (g *gate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.waiting--
+	if g.waiting == 0 {
+		close(g.open)
+	}
+}
+
+func (g *gate) contended() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.waiting > 0
+}
+
+func (g *gate) yielders() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.yielding
+}
+
+// wait blocks until the gate is open, calling yield
+// first if the transfer has to wait for it.
+func (g *gate) wait(ctx context.Context, yield func()) error {
+	g.mu.Lock()
+	open := g.open
+	contended := g.waiting > 0
+	if contended {
+		g.yielding++
+	}
+	g.mu.Unlock()
+
+	if !contended {
+		return nil
+	}
+	yield()
+	defer func() {
+		g.mu.Lock()
+		g.yielding--
+		g.mu.Unlock()
+	}()
+
+	select {
+	case <-open:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
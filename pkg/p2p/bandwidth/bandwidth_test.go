@@ -0,0 +1,260 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bandwidth_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/p2p/bandwidth"
+	"github.com/penguintop/penguin/pkg/p2p/streamtest"
+	"github.com/penguintop/penguin/pkg/penguin/test"
+)
+
+// testStream is a p2p.Stream which writes to and reads from a buffer.
+type testStream struct {
+	bytes.Buffer
+}
+
+func (s *testStream) Close() error                 { return nil }
+func (s *testStream) FullClose() error             { return nil }
+func (s *testStream) Reset() error                 { return nil }
+func (s *testStream) Headers() p2p.Headers         { return nil }
+func (s *testStream) ResponseHeaders() p2p.Headers { return nil }
+
+func TestAccounting(t *testing.T) {
+	accountant := bandwidth.New(bandwidth.Options{})
+
+	server := test.RandomAddress()
+	client := test.RandomAddress()
+
+	protocol := p2p.ProtocolSpec{
+		Name:    "test",
+		Version: "1.0.0",
+		StreamSpecs: []p2p.StreamSpec{
+			{
+				Name: "test",
+				Handler: func(_ context.Context, _ p2p.Peer, stream p2p.Stream) error {
+					defer stream.Close()
+					b := make([]byte, 10)
+					if _, err := stream.Read(b); err != nil {
+						return err
+					}
+					_, err := stream.Write(make([]byte, 20))
+					return err
+				},
+			},
+		},
+	}
+	recorder := streamtest.New(
+		streamtest.WithProtocols(protocol),
+		streamtest.WithMiddlewares(accountant.Middleware(protocol.Name)),
+		streamtest.WithBaseAddr(client),
+	)
+
+	stream, err := recorder.NewStream(context.Background(), server, nil, "test", "1.0.0", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream = accountant.Stream("other", server, stream)
+	if _, err := stream.Write(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(stream); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := accountant.Snapshot()
+
+	if want := (bandwidth.Stats{BytesIn: 30, BytesOut: 30}); snapshot.Total != want {
+		t.Errorf("got total %+v, want %+v", snapshot.Total, want)
+	}
+
+	wantProtocols := []bandwidth.ProtocolStats{
+		{Protocol: "other", Stats: bandwidth.Stats{BytesIn: 20, BytesOut: 10}},
+		{Protocol: "test", Stats: bandwidth.Stats{BytesIn: 10, BytesOut: 20}},
+	}
+	if !reflect.DeepEqual(snapshot.Protocols, wantProtocols) {
+		t.Errorf("got protocols %+v, want %+v", snapshot.Protocols, wantProtocols)
+	}
+
+	peers := make(map[string]bandwidth.Stats)
+	for _, p := range snapshot.Peers {
+		peers[p.Address.String()] = p.Stats
+	}
+	if want := (bandwidth.Stats{BytesIn: 20, BytesOut: 10}); peers[server.String()] != want {
+		t.Errorf("got server stats %+v, want %+v", peers[server.String()], want)
+	}
+	if want := (bandwidth.Stats{BytesIn: 10, BytesOut: 20}); peers[client.String()] != want {
+		t.Errorf("got client stats %+v, want %+v", peers[client.String()], want)
+	}
+
+	accountant.Disconnected(client)
+	if l := len(accountant.Snapshot().Peers); l != 1 {
+		t.Errorf("got %d peers, want 1", l)
+	}
+}
+
+func TestProtocolLimit(t *testing.T) {
+	accountant := bandwidth.New(bandwidth.Options{
+		Protocols: map[string]bandwidth.Limit{
+			"limited": {Rate: 1000, Burst: 100},
+		},
+	})
+	peer := test.RandomAddress()
+
+	// the bucket is full at the start, so the first 100 bytes are
+	// written immediately and the other 200 bytes take 200ms
+	limited := accountant.Stream("limited", peer, new(testStream))
+	start := time.Now()
+	if _, err := limited.Write(make([]byte, 300)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("write took %v, want at least 150ms", d)
+	}
+
+	unlimited := accountant.Stream("unlimited", peer, new(testStream))
+	start = time.Now()
+	if _, err := unlimited.Write(make([]byte, 10000)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("unlimited write took %v", d)
+	}
+
+	s := accountant.Snapshot()
+	if want := (bandwidth.Limit{Rate: 1000, Burst: 100}); s.Protocols[0].Limit != want {
+		t.Errorf("got limit %+v, want %+v", s.Protocols[0].Limit, want)
+	}
+}
+
+func TestLowPriorityYield(t *testing.T) {
+	accountant := bandwidth.New(bandwidth.Options{
+		Total:       bandwidth.Limit{Rate: 1000, Burst: 100},
+		LowPriority: []string{"pullsync"},
+	})
+	peer := test.RandomAddress()
+
+	retrieval := accountant.Stream("retrieval", peer, new(testStream))
+	pullsync := accountant.Stream("pullsync", peer, new(testStream))
+
+	// empty the bucket
+	if _, err := retrieval.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	retrievalDone := make(chan struct{})
+	go func() {
+		defer close(retrievalDone)
+		// a single portion of the bucket size is waited for
+		if _, err := retrieval.Write(make([]byte, 100)); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	waitFor(t, "retrieval is not waiting for the limit", accountant.Contended)
+
+	// the contended state may not change until the retrieval write
+	// completes, as no other normal priority transfer is started
+	pullsyncDone := make(chan bool)
+	go func() {
+		if _, err := pullsync.Write(make([]byte, 1)); err != nil {
+			t.Error(err)
+		}
+		pullsyncDone <- accountant.Contended()
+	}()
+
+	waitFor(t, "pullsync is not yielding", func() bool {
+		return accountant.Yielding() == 1
+	})
+
+	select {
+	case contended := <-pullsyncDone:
+		if contended {
+			t.Fatal("pullsync done while retrieval is waiting for the limit")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	select {
+	case <-retrievalDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	if y := accountant.Yielding(); y != 0 {
+		t.Fatalf("got %d yielding transfers, want none", y)
+	}
+}
+
+func waitFor(t *testing.T, msg string, f func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClosedStream(t *testing.T) {
+	accountant := bandwidth.New(bandwidth.Options{
+		Protocols: map[string]bandwidth.Limit{
+			"limited": {Rate: 1, Burst: 1},
+		},
+	})
+
+	stream := accountant.Stream("limited", test.RandomAddress(), new(testStream))
+	errc := make(chan error, 1)
+	go func() {
+		_, err := stream.Write(make([]byte, 100))
+		errc <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := stream.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got error %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write is not interrupted by reset")
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := bandwidth.ParseLimits([]string{"pullsync=1000", "retrieval=2000:500"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bandwidth.Limit{
+		"pullsync":  {Rate: 1000},
+		"retrieval": {Rate: 2000, Burst: 500},
+	}
+	if !reflect.DeepEqual(limits, want) {
+		t.Fatalf("got %+v, want %+v", limits, want)
+	}
+
+	for _, l := range []string{"pullsync", "=100", "pullsync=fast", "pullsync=-1", "pullsync=100:big"} {
+		if _, err := bandwidth.ParseLimits([]string{l}); !errors.Is(err, bandwidth.ErrInvalidLimit) {
+			t.Errorf("%q: got error %v, want %v", l, err, bandwidth.ErrInvalidLimit)
+		}
+	}
+}
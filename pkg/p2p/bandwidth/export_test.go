@@ -0,0 +1,17 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bandwidth
+
+// Contended reports whether a normal priority transfer
+// is waiting for the total limit.
+func (a *Accountant) Contended() bool {
+	return a.gate.contended()
+}
+
+// Yielding returns the number of low priority transfers
+// waiting for the normal priority ones.
+func (a *Accountant) Yielding() int {
+	return a.gate.yielders()
+}
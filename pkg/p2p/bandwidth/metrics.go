@@ -0,0 +1,47 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bandwidth
+
+import (
+	m "github.com/penguintop/penguin/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type metrics struct {
+	// all metrics fields must be exported
+	// to be able to return them by Metrics()
+	// using reflection
+	Bytes      *prometheus.CounterVec
+	YieldCount *prometheus.CounterVec
+}
+
+func newMetrics() metrics {
+	subsystem := "bandwidth"
+
+	return metrics{
+		Bytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: m.Namespace,
+				Subsystem: subsystem,
+				Name:      "bytes",
+				Help:      "Number of bytes transferred over p2p streams.",
+			},
+			[]string{"protocol", "direction"},
+		),
+		YieldCount: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: m.Namespace,
+				Subsystem: subsystem,
+				Name:      "yield_count",
+				Help:      "Number of transfers of low priority protocols delayed for other protocols.",
+			},
+			[]string{"protocol"},
+		),
+	}
+}
+
+func (a *Accountant) Metrics() []prometheus.Collector {
+	return m.PrometheusCollectorsFromFields(a.metrics)
+}
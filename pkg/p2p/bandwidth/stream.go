@@ -0,0 +1,86 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bandwidth
+
+import (
+	"context"
+
+	"github.com/penguintop/penguin/pkg/p2p"
+)
+
+var _ p2p.Stream = (*stream)(nil)
+
+// stream accounts the bytes transferred over the underlying stream.
+// The limits are applied after the read, as the size of the incoming
+// data is not known in advance, and before the write.
+type stream struct {
+	p2p.Stream
+	accountant *Accountant
+	name       string
+	protocol   *protocol
+	peer       *counter
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	if n > 0 {
+		s.accountant.account(s, directionIn, n)
+		// the wait fails only if the stream is closed,
+		// the read data is returned regardless
+		_ = s.accountant.wait(s, n)
+	}
+	return n, err
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	var written int
+	for written < len(p) {
+		c := len(p) - written
+		if b := s.burst(); b > 0 && c > b {
+			c = b
+		}
+		if err := s.accountant.wait(s, c); err != nil {
+			return written, err
+		}
+		n, err := s.Stream.Write(p[written : written+c])
+		if n > 0 {
+			s.accountant.account(s, directionOut, n)
+		}
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// burst returns the smallest bucket size of the limits of the stream,
+// so that the written data is not held back for longer than needed.
+func (s *stream) burst() (b int) {
+	if l := s.protocol.limiter; l != nil {
+		b = l.Burst()
+	}
+	if l := s.accountant.limiter; l != nil && (b == 0 || l.Burst() < b) {
+		b = l.Burst()
+	}
+	return b
+}
+
+func (s *stream) Close() error {
+	s.cancel()
+	return s.Stream.Close()
+}
+
+func (s *stream) FullClose() error {
+	s.cancel()
+	return s.Stream.FullClose()
+}
+
+func (s *stream) Reset() error {
+	s.cancel()
+	return s.Stream.Reset()
+}
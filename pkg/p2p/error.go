@@ -18,6 +18,12 @@ var (
 	ErrAlreadyConnected = errors.New("already connected")
 	// ErrDialLightNode is returned if connect was attempted to a light node.
 	ErrDialLightNode = errors.New("target peer is a light node")
+	// ErrNotBlocklisted is returned if a peer or a network
+	// which is not on the blocklist is removed from it.
+	ErrNotBlocklisted = errors.New("not blocklisted")
+	// ErrConnectionLimit is returned if the connection is not established
+	// as the connection limits are reached.
+	ErrConnectionLimit = errors.New("connection limit reached")
 )
 
 const (
@@ -73,6 +79,7 @@ func (e *DisconnectError) Error() string {
 
 type BlockPeerError struct {
 	duration time.Duration
+	reason   string
 	err      error
 }
 
@@ -85,6 +92,16 @@ func NewBlockPeerError(duration time.Duration, err error) error {
 	}
 }
 
+// NewBlockPeerReasonError is like NewBlockPeerError, but records the
+// provided reason on the blocklist instead of the underlying error.
+func NewBlockPeerReasonError(duration time.Duration, reason string, err error) error {
+	return &BlockPeerError{
+		duration: duration,
+		reason:   reason,
+		err:      err,
+	}
+}
+
 // Unwrap returns an underlying error.
 func (e *BlockPeerError) Unwrap() error { return e.err }
 
@@ -99,6 +116,14 @@ func (e *BlockPeerError) Duration() time.Duration {
 	return e.duration
 }
 
+// Reason describes why the peer is blocked.
+func (e *BlockPeerError) Reason() string {
+	if e.reason != "" {
+		return e.reason
+	}
+	return e.err.Error()
+}
+
 // IncompatibleStreamError is the error that should be returned by p2p service
 // NewStream method when the stream or its version is not supported.
 type IncompatibleStreamError struct {
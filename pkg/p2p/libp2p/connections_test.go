@@ -401,7 +401,7 @@ func TestBlocklisting(t *testing.T) {
 	expectPeers(t, s2, overlay1)
 	expectPeersEventually(t, s1, overlay2)
 
-	if err := s2.Blocklist(overlay1, 0); err != nil {
+	if err := s2.Blocklist(overlay1, 0, "test"); err != nil {
 		t.Fatal(err)
 	}
 
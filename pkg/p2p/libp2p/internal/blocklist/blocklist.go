@@ -5,7 +5,10 @@
 package blocklist
 
 import (
+	"net"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/penguintop/penguin/pkg/p2p"
@@ -13,13 +16,29 @@ import (
     "github.com/penguintop/penguin/pkg/penguin"
 )
 
-var keyPrefix = "blocklist-"
+var (
+	keyPrefix = "blocklist-"
+	// networkKeyPrefix must not start with keyPrefix
+	// as peer keys are iterated by the keyPrefix.
+	networkKeyPrefix = "blocklist_network-"
+)
 
 // timeNow is used to deterministically mock time.Now() in tests.
 var timeNow = time.Now
 
 type Blocklist struct {
 	store storage.StateStorer
+
+	// networks caches the blocklisted networks, which are
+	// checked for every incoming connection, by their keys.
+	networks   map[string]networkEntry
+	networksMu sync.Mutex
+}
+
+type networkEntry struct {
+	network  *net.IPNet
+	entry    *entry
+	duration time.Duration
 }
 
 func NewBlocklist(store storage.StateStorer) *Blocklist {
@@ -29,11 +48,18 @@ func NewBlocklist(store storage.StateStorer) *Blocklist {
 type entry struct {
 	Timestamp time.Time `json:"timestamp"`
 	Duration  string    `json:"duration"` // Duration is string because the time.Duration does not implement MarshalJSON/UnmarshalJSON methods.
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// expired reports whether the entry is no longer in effect.
+func (e *entry) expired(duration time.Duration) bool {
+	// using timeNow.Sub() so it can be mocked in unit tests
+	return timeNow().Sub(e.Timestamp) > duration && duration != 0
 }
 
 func (b *Blocklist) Exists(overlay penguin.Address) (bool, error) {
 	key := generateKey(overlay)
-	timestamp, duration, err := b.get(key)
+	e, duration, err := b.get(key)
 	if err != nil {
 		if err == storage.ErrNotFound {
 			return false, nil
@@ -42,8 +68,7 @@ func (b *Blocklist) Exists(overlay penguin.Address) (bool, error) {
 		return false, err
 	}
 
-	// using timeNow.Sub() so it can be mocked in unit tests
-	if timeNow().Sub(timestamp) > duration && duration != 0 {
+	if e.expired(duration) {
 		_ = b.store.Delete(key)
 		return false, nil
 	}
@@ -51,29 +76,18 @@ func (b *Blocklist) Exists(overlay penguin.Address) (bool, error) {
 	return true, nil
 }
 
-func (b *Blocklist) Add(overlay penguin.Address, duration time.Duration) (err error) {
-	key := generateKey(overlay)
-	_, d, err := b.get(key)
-	if err != nil {
-		if err != storage.ErrNotFound {
-			return err
-		}
-	}
-
-	// if peer is already blacklisted, blacklist it for the maximum amount of time
-	if duration < d && duration != 0 || d == 0 {
-		duration = d
-	}
+func (b *Blocklist) Add(overlay penguin.Address, duration time.Duration, reason string) (err error) {
+	return b.add(generateKey(overlay), duration, reason)
+}
 
-	return b.store.Put(key, &entry{
-		Timestamp: timeNow(),
-		Duration:  duration.String(),
-	})
+// Remove removes the peer from the blocklist.
+func (b *Blocklist) Remove(overlay penguin.Address) error {
+	return b.remove(generateKey(overlay))
 }
 
 // Peers returns all currently blocklisted peers.
-func (b *Blocklist) Peers() ([]p2p.Peer, error) {
-	var peers []p2p.Peer
+func (b *Blocklist) Peers() ([]p2p.BlocklistedPeer, error) {
+	var peers []p2p.BlocklistedPeer
 	if err := b.store.Iterate(keyPrefix, func(k, v []byte) (bool, error) {
 		if !strings.HasPrefix(string(k), keyPrefix) {
 			return true, nil
@@ -83,18 +97,21 @@ func (b *Blocklist) Peers() ([]p2p.Peer, error) {
 			return true, err
 		}
 
-		t, d, err := b.get(string(k))
+		e, d, err := b.get(string(k))
 		if err != nil {
 			return true, err
 		}
 
-		if timeNow().Sub(t) > d && d != 0 {
+		if e.expired(d) {
 			// skip to the next item
 			return false, nil
 		}
 
-		p := p2p.Peer{Address: addr}
-		peers = append(peers, p)
+		peers = append(peers, p2p.BlocklistedPeer{
+			Peer:     p2p.Peer{Address: addr},
+			Reason:   e.Reason,
+			Duration: d,
+		})
 		return false, nil
 	}); err != nil {
 		return nil, err
@@ -103,18 +120,173 @@ func (b *Blocklist) Peers() ([]p2p.Peer, error) {
 	return peers, nil
 }
 
-func (b *Blocklist) get(key string) (timestamp time.Time, duration time.Duration, err error) {
-	var e entry
-	if err := b.store.Get(key, &e); err != nil {
-		return time.Time{}, -1, err
+// AddNetwork blocks the IP network.
+func (b *Blocklist) AddNetwork(network *net.IPNet, duration time.Duration, reason string) error {
+	b.networksMu.Lock()
+	defer b.networksMu.Unlock()
+
+	if err := b.loadNetworks(); err != nil {
+		return err
+	}
+	key := generateNetworkKey(network)
+	if err := b.add(key, duration, reason); err != nil {
+		return err
+	}
+	e, d, err := b.get(key)
+	if err != nil {
+		return err
+	}
+	b.networks[key] = networkEntry{network: network, entry: e, duration: d}
+	return nil
+}
+
+// RemoveNetwork removes the IP network from the blocklist.
+func (b *Blocklist) RemoveNetwork(network *net.IPNet) error {
+	b.networksMu.Lock()
+	defer b.networksMu.Unlock()
+
+	if err := b.loadNetworks(); err != nil {
+		return err
+	}
+	key := generateNetworkKey(network)
+	delete(b.networks, key)
+	return b.remove(key)
+}
+
+// Networks returns all currently blocklisted IP networks.
+func (b *Blocklist) Networks() ([]p2p.BlocklistedNetwork, error) {
+	b.networksMu.Lock()
+	defer b.networksMu.Unlock()
+
+	if err := b.loadNetworks(); err != nil {
+		return nil, err
+	}
+	b.expireNetworks()
+
+	networks := make([]p2p.BlocklistedNetwork, 0, len(b.networks))
+	for _, n := range b.networks {
+		networks = append(networks, p2p.BlocklistedNetwork{
+			Network:  n.network,
+			Reason:   n.entry.Reason,
+			Duration: n.duration,
+		})
+	}
+	// keep the order of the keys in the store
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].Network.String() < networks[j].Network.String()
+	})
+	return networks, nil
+}
+
+// IPBlocked reports whether the IP is in any of the blocklisted networks.
+func (b *Blocklist) IPBlocked(ip net.IP) (bool, error) {
+	b.networksMu.Lock()
+	defer b.networksMu.Unlock()
+
+	if err := b.loadNetworks(); err != nil {
+		return false, err
+	}
+	b.expireNetworks()
+
+	for _, n := range b.networks {
+		if n.network.Contains(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// loadNetworks reads the blocklisted networks from the store into the
+// cache, if they were not read already. It must be called under the
+// networksMu lock.
+func (b *Blocklist) loadNetworks() error {
+	if b.networks != nil {
+		return nil
+	}
+	networks := make(map[string]networkEntry)
+	if err := b.store.Iterate(networkKeyPrefix, func(k, v []byte) (bool, error) {
+		if !strings.HasPrefix(string(k), networkKeyPrefix) {
+			return true, nil
+		}
+		_, network, err := net.ParseCIDR(strings.TrimPrefix(string(k), networkKeyPrefix))
+		if err != nil {
+			return true, err
+		}
+
+		e, d, err := b.get(string(k))
+		if err != nil {
+			return true, err
+		}
+
+		networks[string(k)] = networkEntry{network: network, entry: e, duration: d}
+		return false, nil
+	}); err != nil {
+		return err
+	}
+	b.networks = networks
+	return nil
+}
+
+// expireNetworks removes the expired networks from the cache and
+// the store. It must be called under the networksMu lock.
+func (b *Blocklist) expireNetworks() {
+	for key, n := range b.networks {
+		if n.entry.expired(n.duration) {
+			delete(b.networks, key)
+			_ = b.store.Delete(key)
+		}
+	}
+}
+
+func (b *Blocklist) add(key string, duration time.Duration, reason string) error {
+	e, d, err := b.get(key)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			return err
+		}
+	} else if e.expired(d) {
+		d = duration
+	}
+
+	// if peer is already blacklisted, blacklist it for the maximum amount of time
+	if duration < d && duration != 0 || d == 0 {
+		duration = d
+	}
+
+	return b.store.Put(key, &entry{
+		Timestamp: timeNow(),
+		Duration:  duration.String(),
+		Reason:    reason,
+	})
+}
+
+func (b *Blocklist) remove(key string) error {
+	e, d, err := b.get(key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return p2p.ErrNotBlocklisted
+		}
+		return err
+	}
+	if e.expired(d) {
+		_ = b.store.Delete(key)
+		return p2p.ErrNotBlocklisted
+	}
+	return b.store.Delete(key)
+}
+
+func (b *Blocklist) get(key string) (e *entry, duration time.Duration, err error) {
+	e = new(entry)
+	if err := b.store.Get(key, e); err != nil {
+		return nil, -1, err
 	}
 
 	duration, err = time.ParseDuration(e.Duration)
 	if err != nil {
-		return time.Time{}, -1, err
+		return nil, -1, err
 	}
 
-	return e.Timestamp, duration, nil
+	return e, duration, nil
 }
 
 func generateKey(overlay penguin.Address) string {
@@ -125,3 +297,7 @@ func unmarshalKey(s string) (penguin.Address, error) {
 	addr := strings.TrimPrefix(s, keyPrefix)
 	return penguin.ParseHexAddress(addr)
 }
+
+func generateNetworkKey(network *net.IPNet) string {
+	return networkKeyPrefix + network.String()
+}
@@ -5,12 +5,15 @@
 package blocklist_test
 
 import (
+	"errors"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/penguintop/penguin/pkg/p2p"
 	"github.com/penguintop/penguin/pkg/p2p/libp2p/internal/blocklist"
 	"github.com/penguintop/penguin/pkg/statestore/mock"
+	"github.com/penguintop/penguin/pkg/storage"
     "github.com/penguintop/penguin/pkg/penguin"
 )
 
@@ -30,12 +33,12 @@ func TestExist(t *testing.T) {
 	}
 
 	// add forever
-	if err := bl.Add(addr1, 0); err != nil {
+	if err := bl.Add(addr1, 0, "test"); err != nil {
 		t.Fatal(err)
 	}
 
 	// add for 50 miliseconds
-	if err := bl.Add(addr2, time.Millisecond*50); err != nil {
+	if err := bl.Add(addr2, time.Millisecond*50, "test"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -68,12 +71,12 @@ func TestPeers(t *testing.T) {
 	bl := blocklist.NewBlocklist(mock.NewStateStore())
 
 	// add forever
-	if err := bl.Add(addr1, 0); err != nil {
+	if err := bl.Add(addr1, 0, "test"); err != nil {
 		t.Fatal(err)
 	}
 
 	// add for 50 miliseconds
-	if err := bl.Add(addr2, time.Millisecond*50); err != nil {
+	if err := bl.Add(addr2, time.Millisecond*50, "test"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -106,7 +109,144 @@ func TestPeers(t *testing.T) {
 	}
 }
 
-func isIn(p penguin.Address, peers []p2p.Peer) bool {
+func TestReasonAndRemove(t *testing.T) {
+	addr := penguin.NewAddress([]byte{0, 1, 2, 3})
+
+	bl := blocklist.NewBlocklist(mock.NewStateStore())
+
+	if err := bl.Remove(addr); !errors.Is(err, p2p.ErrNotBlocklisted) {
+		t.Fatalf("got error %v, want %v", err, p2p.ErrNotBlocklisted)
+	}
+
+	if err := bl.Add(addr, time.Hour, "hive: rate limit exceeded"); err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err := bl.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 {
+		t.Fatalf("got %v peers, want 1", len(peers))
+	}
+	if got, want := peers[0].Reason, "hive: rate limit exceeded"; got != want {
+		t.Fatalf("got reason %q, want %q", got, want)
+	}
+	if got, want := peers[0].Duration, time.Hour; got != want {
+		t.Fatalf("got duration %v, want %v", got, want)
+	}
+
+	if err := bl.Remove(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := bl.Exists(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("got exists, expected not exists")
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	_, network1, err := net.ParseCIDR("10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, network2, err := net.ParseCIDR("2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &iterationsStore{StateStorer: mock.NewStateStore()}
+	bl := blocklist.NewBlocklist(store)
+
+	// a peer entry must not be listed as a network
+	if err := bl.Add(penguin.NewAddress([]byte{0, 1, 2, 3}), 0, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bl.AddNetwork(network1, 0, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bl.AddNetwork(network2, time.Millisecond*50, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	networks, err := bl.Networks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 2 {
+		t.Fatalf("got %v networks, want 2", len(networks))
+	}
+
+	for _, tc := range []struct {
+		ip      string
+		blocked bool
+	}{
+		{ip: "10.1.2.3", blocked: true},
+		{ip: "10.2.2.3", blocked: false},
+		{ip: "2001:db8::1", blocked: true},
+		{ip: "2001:db9::1", blocked: false},
+	} {
+		blocked, err := bl.IPBlocked(net.ParseIP(tc.ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blocked != tc.blocked {
+			t.Errorf("ip %s: got blocked %v, want %v", tc.ip, blocked, tc.blocked)
+		}
+	}
+
+	blocklist.SetTimeNow(func() time.Time { return time.Now().Add(100 * time.Millisecond) })
+	defer func() { blocklist.SetTimeNow(time.Now) }()
+
+	blocked, err := bl.IPBlocked(net.ParseIP("2001:db8::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocked {
+		t.Fatal("expected expired network not to be blocked")
+	}
+	if store.iterations != 1 {
+		t.Fatalf("got %v store iterations, want 1", store.iterations)
+	}
+
+	// the expired network is deleted from the store
+	networks, err = blocklist.NewBlocklist(store).Networks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 1 || networks[0].Network.String() != network1.String() {
+		t.Fatalf("got networks %v, want %v", networks, network1)
+	}
+
+	if err := bl.RemoveNetwork(network1); err != nil {
+		t.Fatal(err)
+	}
+	networks, err = bl.Networks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 0 {
+		t.Fatalf("got %v networks, want 0", len(networks))
+	}
+}
+
+// iterationsStore counts the iterations of the store.
+type iterationsStore struct {
+	storage.StateStorer
+	iterations int
+}
+
+func (s *iterationsStore) Iterate(prefix string, iterFunc storage.StateIterFunc) error {
+	s.iterations++
+	return s.StateStorer.Iterate(prefix, iterFunc)
+}
+
+func isIn(p penguin.Address, peers []p2p.BlocklistedPeer) bool {
 	for _, v := range peers {
 		if v.Address.Equal(p) {
 			return true
@@ -0,0 +1,345 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package connmanager keeps the number of inbound, outbound and total
+// connections within limits. When a limit is reached, a new connection
+// replaces the least valuable existing one if it is more valuable
+// itself. Connections in crowded proximity bins, with low peer scores
+// and the youngest ones are the least valuable. Connections to protected
+// peers, such as the neighbourhood peers, and connections within the
+// grace period after they are established are never pruned. Pruned
+// peers are not connected again until the prune backoff expires.
+package connmanager
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/penguin"
+)
+
+// DefaultGracePeriod is the default duration after the connection is
+// established in which it is not pruned.
+const DefaultGracePeriod = time.Minute
+
+// DefaultPruneBackoff is the default duration after the connection is
+// pruned in which the peer is not connected again.
+const DefaultPruneBackoff = 10 * time.Minute
+
+// neutralScore is the score of the peers if no scorer is set.
+const neutralScore = 0.5
+
+// ErrLimitReached is returned if the connection is not added as the
+// limits are reached and there are no less valuable connections to prune.
+var ErrLimitReached = errors.New("connection limit reached")
+
+// Direction is the direction of the connection.
+type Direction int
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+// Scorer provides peer scores in the range from 0 to 1.
+type Scorer interface {
+	Score(peer penguin.Address) float64
+}
+
+// Options are the connection manager limits, zero limits are unlimited.
+type Options struct {
+	MaxInbound   int
+	MaxOutbound  int
+	MaxTotal     int
+	GracePeriod  time.Duration
+	PruneBackoff time.Duration
+}
+
+type conn struct {
+	overlay   penguin.Address
+	direction Direction
+	po        uint8
+	connected time.Time
+}
+
+// Manager keeps track of the connections and selects the ones to prune.
+type Manager struct {
+	base         penguin.Address
+	maxInbound   int
+	maxOutbound  int
+	maxTotal     int
+	gracePeriod  time.Duration
+	pruneBackoff time.Duration
+	now          func() time.Time
+
+	mu        sync.Mutex
+	conns     map[string]*conn
+	prunedAt  map[string]time.Time // pruned peers in the backoff
+	protector func(penguin.Address) bool
+	scorer    Scorer
+	pruned    uint64
+	rejected  uint64
+}
+
+// New creates a new connection manager of the node with the base overlay address.
+func New(base penguin.Address, o Options) *Manager {
+	gracePeriod := o.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = DefaultGracePeriod
+	}
+	pruneBackoff := o.PruneBackoff
+	if pruneBackoff == 0 {
+		pruneBackoff = DefaultPruneBackoff
+	}
+	return &Manager{
+		base:         base,
+		maxInbound:   o.MaxInbound,
+		maxOutbound:  o.MaxOutbound,
+		maxTotal:     o.MaxTotal,
+		gracePeriod:  gracePeriod,
+		pruneBackoff: pruneBackoff,
+		now:          time.Now,
+		conns:        make(map[string]*conn),
+		prunedAt:     make(map[string]time.Time),
+	}
+}
+
+// SetProtector sets the function which reports the peers whose
+// connections are never pruned and are added over the limits.
+func (m *Manager) SetProtector(f func(penguin.Address) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.protector = f
+}
+
+// SetScorer sets the scorer of the peers used to rank the connections.
+func (m *Manager) SetScorer(s Scorer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.scorer = s
+}
+
+// Allow reports whether the connection to the peer in the direction
+// would be added by Add, without adding it. It is used to decide which
+// peers to dial and accept before the connection is established.
+func (m *Manager) Allow(overlay penguin.Address, d Direction) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.accept(m.newConn(overlay, d))
+	return ok
+}
+
+// Add registers the established connection. If the limits are exceeded,
+// the less valuable connections which should be pruned are returned.
+// ErrLimitReached is returned if the connection is not more valuable
+// than any of the existing ones, or the peer was pruned recently, and
+// it should be closed.
+func (m *Manager) Add(overlay penguin.Address, d Direction) ([]penguin.Address, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.conns[overlay.ByteString()]; ok {
+		return nil, nil
+	}
+
+	c := m.newConn(overlay, d)
+	victim, ok := m.accept(c)
+	if !ok {
+		m.rejected++
+		return nil, ErrLimitReached
+	}
+
+	m.conns[overlay.ByteString()] = c
+	delete(m.prunedAt, overlay.ByteString())
+	if victim == nil {
+		return nil, nil
+	}
+	m.prune(victim)
+	return []penguin.Address{victim.overlay}, nil
+}
+
+// Remove removes the closed connection.
+func (m *Manager) Remove(overlay penguin.Address) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.conns, overlay.ByteString())
+}
+
+// Trim returns the least valuable connections which exceed the limits.
+// The connections are removed from the manager and should be closed.
+func (m *Manager) Trim() (prune []penguin.Address) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for overlay, t := range m.prunedAt {
+		if now.Sub(t) >= m.pruneBackoff {
+			delete(m.prunedAt, overlay)
+		}
+	}
+
+	for _, d := range []Direction{Inbound, Outbound} {
+		for {
+			dirOver, totalOver := m.overLimits(d, 0)
+			if !dirOver && !totalOver {
+				break
+			}
+			victim := m.candidate(d, dirOver, m.bins())
+			if victim == nil {
+				break
+			}
+			m.prune(victim)
+			prune = append(prune, victim.overlay)
+		}
+	}
+	return prune
+}
+
+// Stats returns the connection counts and limits.
+func (m *Manager) Stats() p2p.ConnectionStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := p2p.ConnectionStats{
+		MaxInbound:  m.maxInbound,
+		MaxOutbound: m.maxOutbound,
+		MaxTotal:    m.maxTotal,
+		Pruned:      m.pruned,
+		Rejected:    m.rejected,
+	}
+	for _, c := range m.conns {
+		if c.direction == Inbound {
+			s.Inbound++
+		} else {
+			s.Outbound++
+		}
+		if m.protected(c.overlay) {
+			s.Protected++
+		}
+	}
+	return s
+}
+
+func (m *Manager) newConn(overlay penguin.Address, d Direction) *conn {
+	return &conn{
+		overlay:   overlay,
+		direction: d,
+		po:        penguin.Proximity(m.base.Bytes(), overlay.Bytes()),
+		connected: m.now(),
+	}
+}
+
+// accept reports whether the new connection is accepted and returns
+// the connection which should be pruned for it, if any. Recently
+// pruned peers are accepted only if they are protected.
+func (m *Manager) accept(c *conn) (victim *conn, ok bool) {
+	if _, ok := m.conns[c.overlay.ByteString()]; ok {
+		return nil, true
+	}
+
+	protected := m.protected(c.overlay)
+	if t, pruned := m.prunedAt[c.overlay.ByteString()]; pruned && !protected && m.now().Sub(t) < m.pruneBackoff {
+		return nil, false
+	}
+
+	dirOver, totalOver := m.overLimits(c.direction, 1)
+	if !dirOver && !totalOver {
+		return nil, true
+	}
+
+	bins := m.bins()
+	bins[c.po]++
+	victim = m.candidate(c.direction, dirOver, bins)
+	if victim == nil {
+		// protected peers are connected over the limits,
+		// the excess connections are pruned by Trim
+		return nil, protected
+	}
+	if !protected && !m.less(victim, c, bins) {
+		return nil, false
+	}
+	return victim, true
+}
+
+// prune removes the connection and starts the backoff of the peer.
+func (m *Manager) prune(c *conn) {
+	delete(m.conns, c.overlay.ByteString())
+	m.prunedAt[c.overlay.ByteString()] = m.now()
+	m.pruned++
+}
+
+// overLimits reports whether the direction and the total limits are
+// exceeded with the additional connections.
+func (m *Manager) overLimits(d Direction, additional int) (dirOver, totalOver bool) {
+	var inbound, outbound int
+	for _, c := range m.conns {
+		if c.direction == Inbound {
+			inbound++
+		} else {
+			outbound++
+		}
+	}
+	if d == Inbound {
+		dirOver = m.maxInbound > 0 && inbound+additional > m.maxInbound
+	} else {
+		dirOver = m.maxOutbound > 0 && outbound+additional > m.maxOutbound
+	}
+	totalOver = m.maxTotal > 0 && inbound+outbound+additional > m.maxTotal
+	return dirOver, totalOver
+}
+
+// candidate returns the least valuable connection which may be pruned.
+// If the direction limit is exceeded, only the connections in the same
+// direction are considered.
+func (m *Manager) candidate(d Direction, sameDirection bool, bins map[uint8]int) (victim *conn) {
+	now := m.now()
+	for _, c := range m.conns {
+		if sameDirection && c.direction != d {
+			continue
+		}
+		if now.Sub(c.connected) < m.gracePeriod || m.protected(c.overlay) {
+			continue
+		}
+		if victim == nil || m.less(c, victim, bins) {
+			victim = c
+		}
+	}
+	return victim
+}
+
+// less reports whether the connection a is less valuable than b.
+func (m *Manager) less(a, b *conn, bins map[uint8]int) bool {
+	if binA, binB := bins[a.po], bins[b.po]; binA != binB {
+		return binA > binB
+	}
+	if scoreA, scoreB := m.score(a.overlay), m.score(b.overlay); scoreA != scoreB {
+		return scoreA < scoreB
+	}
+	return a.connected.After(b.connected)
+}
+
+func (m *Manager) bins() map[uint8]int {
+	bins := make(map[uint8]int)
+	for _, c := range m.conns {
+		bins[c.po]++
+	}
+	return bins
+}
+
+func (m *Manager) protected(overlay penguin.Address) bool {
+	return m.protector != nil && m.protector(overlay)
+}
+
+func (m *Manager) score(overlay penguin.Address) float64 {
+	if m.scorer == nil {
+		return neutralScore
+	}
+	return m.scorer.Score(overlay)
+}
@@ -0,0 +1,273 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package connmanager_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/p2p/libp2p/internal/connmanager"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/penguin/test"
+)
+
+type scorer map[string]float64
+
+func (s scorer) Score(peer penguin.Address) float64 {
+	if score, ok := s[peer.ByteString()]; ok {
+		return score
+	}
+	return 0.5
+}
+
+// newManager returns the manager with the clock which
+// is advanced past the grace period by the returned function.
+func newManager(t *testing.T, o connmanager.Options) (*connmanager.Manager, penguin.Address, func()) {
+	t.Helper()
+
+	base := test.RandomAddress()
+	m := connmanager.New(base, o)
+	now := time.Now()
+	m.SetNow(func() time.Time { return now })
+	return m, base, func() { now = now.Add(connmanager.DefaultGracePeriod) }
+}
+
+func add(t *testing.T, m *connmanager.Manager, overlay penguin.Address, d connmanager.Direction) []penguin.Address {
+	t.Helper()
+
+	prune, err := m.Add(overlay, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prune
+}
+
+func expectPrune(t *testing.T, got []penguin.Address, want ...penguin.Address) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d pruned connections, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !got[i].Equal(want[i]) {
+			t.Fatalf("got pruned connection %s, want %s", got[i], want[i])
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	m, base, _ := newManager(t, connmanager.Options{MaxInbound: 2, MaxOutbound: 1, MaxTotal: 3})
+
+	add(t, m, test.RandomAddressAt(base, 1), connmanager.Inbound)
+	add(t, m, test.RandomAddressAt(base, 2), connmanager.Inbound)
+
+	// the connections are within the grace period
+	if _, err := m.Add(test.RandomAddressAt(base, 3), connmanager.Inbound); !errors.Is(err, connmanager.ErrLimitReached) {
+		t.Fatalf("got error %v, want %v", err, connmanager.ErrLimitReached)
+	}
+	if m.Allow(test.RandomAddressAt(base, 3), connmanager.Inbound) {
+		t.Fatal("inbound connection allowed")
+	}
+
+	outbound := test.RandomAddressAt(base, 4)
+	if !m.Allow(outbound, connmanager.Outbound) {
+		t.Fatal("outbound connection not allowed")
+	}
+	add(t, m, outbound, connmanager.Outbound)
+	if m.Allow(test.RandomAddressAt(base, 5), connmanager.Outbound) {
+		t.Fatal("outbound connection allowed")
+	}
+	if !m.Allow(outbound, connmanager.Outbound) {
+		t.Fatal("existing connection not allowed")
+	}
+
+	// an existing connection is not counted again
+	add(t, m, outbound, connmanager.Outbound)
+
+	m.Remove(outbound)
+	if !m.Allow(test.RandomAddressAt(base, 5), connmanager.Outbound) {
+		t.Fatal("outbound connection not allowed after removal")
+	}
+
+	want := p2p.ConnectionStats{
+		Inbound:     2,
+		MaxInbound:  2,
+		MaxOutbound: 1,
+		MaxTotal:    3,
+		Rejected:    1,
+	}
+	if got := m.Stats(); got != want {
+		t.Fatalf("got stats %+v, want %+v", got, want)
+	}
+}
+
+func TestPruneLeastValuable(t *testing.T) {
+	t.Run("crowded bin", func(t *testing.T) {
+		m, base, advance := newManager(t, connmanager.Options{MaxInbound: 3})
+
+		crowded := test.RandomAddressAt(base, 1)
+		add(t, m, crowded, connmanager.Inbound)
+		add(t, m, test.RandomAddressAt(base, 1), connmanager.Inbound)
+		add(t, m, test.RandomAddressAt(base, 2), connmanager.Inbound)
+		advance()
+
+		// a peer in the crowded bin does not replace any connection
+		if _, err := m.Add(test.RandomAddressAt(base, 1), connmanager.Inbound); !errors.Is(err, connmanager.ErrLimitReached) {
+			t.Fatalf("got error %v, want %v", err, connmanager.ErrLimitReached)
+		}
+
+		// a peer in an empty bin replaces the youngest connection
+		// of the crowded bin, which is the first one as all
+		// connections are established at the same time
+		prune := add(t, m, test.RandomAddressAt(base, 3), connmanager.Inbound)
+		if len(prune) != 1 || penguin.Proximity(base.Bytes(), prune[0].Bytes()) != 1 {
+			t.Fatalf("got pruned connections %v, want one from bin 1", prune)
+		}
+	})
+
+	t.Run("score", func(t *testing.T) {
+		m, base, advance := newManager(t, connmanager.Options{MaxOutbound: 2})
+
+		good := test.RandomAddressAt(base, 1)
+		bad := test.RandomAddressAt(base, 1)
+		m.SetScorer(scorer{good.ByteString(): 0.9, bad.ByteString(): 0.1})
+
+		add(t, m, good, connmanager.Outbound)
+		add(t, m, bad, connmanager.Outbound)
+		advance()
+
+		prune := add(t, m, test.RandomAddressAt(base, 1), connmanager.Outbound)
+		expectPrune(t, prune, bad)
+
+		if s := m.Stats(); s.Outbound != 2 || s.Pruned != 1 {
+			t.Fatalf("got stats %+v", s)
+		}
+	})
+
+	t.Run("total", func(t *testing.T) {
+		m, base, advance := newManager(t, connmanager.Options{MaxTotal: 3})
+
+		add(t, m, test.RandomAddressAt(base, 1), connmanager.Inbound)
+		add(t, m, test.RandomAddressAt(base, 1), connmanager.Outbound)
+		add(t, m, test.RandomAddressAt(base, 2), connmanager.Outbound)
+		advance()
+
+		// the connection in any direction is pruned
+		prune := add(t, m, test.RandomAddressAt(base, 3), connmanager.Inbound)
+		if len(prune) != 1 || penguin.Proximity(base.Bytes(), prune[0].Bytes()) != 1 {
+			t.Fatalf("got pruned connections %v, want one from bin 1", prune)
+		}
+	})
+}
+
+func TestAllowLessValuable(t *testing.T) {
+	m, base, advance := newManager(t, connmanager.Options{MaxOutbound: 2})
+
+	add(t, m, test.RandomAddressAt(base, 1), connmanager.Outbound)
+	add(t, m, test.RandomAddressAt(base, 1), connmanager.Outbound)
+	advance()
+
+	// a dial to a peer which would not replace any
+	// connection is not allowed
+	if m.Allow(test.RandomAddressAt(base, 1), connmanager.Outbound) {
+		t.Fatal("connection in the crowded bin allowed")
+	}
+	if !m.Allow(test.RandomAddressAt(base, 2), connmanager.Outbound) {
+		t.Fatal("connection in the empty bin not allowed")
+	}
+	if s := m.Stats(); s.Outbound != 2 || s.Pruned != 0 {
+		t.Fatalf("got stats %+v", s)
+	}
+}
+
+func TestPruneBackoff(t *testing.T) {
+	m, base, advance := newManager(t, connmanager.Options{MaxInbound: 1, PruneBackoff: 2 * connmanager.DefaultGracePeriod})
+
+	pruned := test.RandomAddressAt(base, 1)
+	other := test.RandomAddressAt(base, 1)
+	m.SetScorer(scorer{pruned.ByteString(): 0.1})
+	add(t, m, pruned, connmanager.Inbound)
+	advance()
+
+	expectPrune(t, add(t, m, other, connmanager.Inbound), pruned)
+
+	// the pruned peer is not connected again within the
+	// backoff, even if the limits are not reached
+	advance()
+	if m.Allow(pruned, connmanager.Outbound) {
+		t.Fatal("pruned peer allowed")
+	}
+	m.Remove(other)
+	if _, err := m.Add(pruned, connmanager.Outbound); !errors.Is(err, connmanager.ErrLimitReached) {
+		t.Fatalf("got error %v, want %v", err, connmanager.ErrLimitReached)
+	}
+
+	// the protected peers are connected regardless of the backoff
+	m.SetProtector(func(overlay penguin.Address) bool {
+		return overlay.Equal(pruned)
+	})
+	if !m.Allow(pruned, connmanager.Outbound) {
+		t.Fatal("protected peer not allowed")
+	}
+	m.SetProtector(nil)
+
+	advance()
+	expectPrune(t, m.Trim())
+	if !m.Allow(pruned, connmanager.Outbound) {
+		t.Fatal("pruned peer not allowed after the backoff")
+	}
+}
+
+func TestProtected(t *testing.T) {
+	m, base, advance := newManager(t, connmanager.Options{MaxInbound: 1})
+
+	neighbour := test.RandomAddressAt(base, 8)
+	other := test.RandomAddressAt(base, 8)
+	m.SetProtector(func(overlay penguin.Address) bool {
+		return overlay.Equal(neighbour)
+	})
+
+	add(t, m, neighbour, connmanager.Inbound)
+	advance()
+
+	// the protected connection is not pruned
+	if _, err := m.Add(test.RandomAddressAt(base, 1), connmanager.Inbound); !errors.Is(err, connmanager.ErrLimitReached) {
+		t.Fatalf("got error %v, want %v", err, connmanager.ErrLimitReached)
+	}
+
+	m.Remove(neighbour)
+	add(t, m, other, connmanager.Inbound)
+
+	// the protected peer is connected over the limits
+	expectPrune(t, add(t, m, neighbour, connmanager.Inbound))
+	if s := m.Stats(); s.Inbound != 2 || s.Protected != 1 {
+		t.Fatalf("got stats %+v", s)
+	}
+
+	// the excess connection is pruned after the grace period
+	expectPrune(t, m.Trim())
+	advance()
+	expectPrune(t, m.Trim(), other)
+	expectPrune(t, m.Trim())
+
+	if s := m.Stats(); s.Inbound != 1 || s.Pruned != 1 {
+		t.Fatalf("got stats %+v", s)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	m, base, _ := newManager(t, connmanager.Options{})
+
+	for i := 0; i < 100; i++ {
+		expectPrune(t, add(t, m, test.RandomAddressAt(base, i%8), connmanager.Direction(i%2)))
+	}
+	expectPrune(t, m.Trim())
+
+	if s := m.Stats(); s.Inbound != 50 || s.Outbound != 50 {
+		t.Fatalf("got stats %+v", s)
+	}
+}
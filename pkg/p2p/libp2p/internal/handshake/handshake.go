@@ -87,6 +87,8 @@ type Service struct {
 type Info struct {
 	PenAddress *pen.Address
 	FullNode   bool
+	// Advertised is the address of this node advertised to the peer.
+	Advertised *pen.Address
 }
 
 func (i *Info) LightString() string {
@@ -196,6 +198,7 @@ func (s *Service) Handshake(ctx context.Context, stream p2p.Stream, peerMultiadd
 	return &Info{
 		PenAddress: remotePenAddress,
 		FullNode:   resp.Ack.FullNode,
+		Advertised: penAddress,
 	}, nil
 }
 
@@ -296,6 +299,7 @@ func (s *Service) Handle(ctx context.Context, stream p2p.Stream, remoteMultiaddr
 	return &Info{
 		PenAddress: remotePenAddress,
 		FullNode:   ack.FullNode,
+		Advertised: penAddress,
 	}, nil
 }
 
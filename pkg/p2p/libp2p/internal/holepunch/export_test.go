@@ -0,0 +1,18 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package holepunch
+
+import (
+	libp2ppeer "github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func (s *Service) SetPunchable(f func(addr ma.Multiaddr) bool) {
+	s.punchable = f
+}
+
+func (s *Service) ParseAddrs(peerID libp2ppeer.ID, b [][]byte) []ma.Multiaddr {
+	return s.parseAddrs(peerID, b)
+}
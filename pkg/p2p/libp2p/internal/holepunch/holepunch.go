@@ -0,0 +1,478 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package holepunch coordinates the upgrade of relayed connections to
+// direct ones. Both peers exchange their direct addresses over the
+// relayed connection and probe each other at the same time, so that the
+// probes open the NAT mappings on both sides. Only QUIC addresses are
+// used, as the QUIC transport dials from the listening socket and every
+// dial is a connection of its own, while simultaneous TCP dials end up
+// in a single connection that this libp2p version can not secure.
+package holepunch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/p2p/libp2p/internal/holepunch/pb"
+	"github.com/penguintop/penguin/pkg/p2p/protobuf"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	libp2ppeer "github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/libp2p/go-libp2p-core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// ProtocolName is the text of the name of the hole punch protocol.
+	ProtocolName = "holepunch"
+	// ProtocolVersion is the current hole punch protocol version.
+	ProtocolVersion = "1.0.0"
+	// StreamName is the name of the stream used for the coordination.
+	StreamName = "connect"
+
+	punchTimeout = 15 * time.Second
+	dialTimeout  = 5 * time.Second
+	// handleInterval is the minimal interval between the hole punch
+	// coordinations started by the same peer, as each of them makes
+	// the node dial the addresses supplied by the peer.
+	handleInterval = time.Minute
+)
+
+var (
+	// ErrNotRelayed is returned if the peer is connected directly or
+	// not connected at all.
+	ErrNotRelayed = errors.New("connection is not relayed")
+	// ErrNoAddresses is returned if one of the peers has no direct addresses.
+	ErrNoAddresses = errors.New("no direct addresses")
+	// ErrInvalidMessage is returned if an unexpected message is received.
+	ErrInvalidMessage = errors.New("invalid message")
+	// ErrPunchFailed is returned if the direct connection could not be established.
+	ErrPunchFailed = errors.New("hole punch failed")
+	// ErrRateLimitExceeded is returned if the peer started the hole punch
+	// coordination again within the handle interval.
+	ErrRateLimitExceeded = errors.New("rate limit exceeded")
+)
+
+// ProtocolID is the libp2p protocol id of the hole punch stream.
+var ProtocolID = protocol.ID(p2p.NewPenguinStreamName(ProtocolName, ProtocolVersion, StreamName))
+
+// Keeper keeps a peer registered while its relayed connection is replaced.
+type Keeper interface {
+	// Keep keeps the peer registered when its last connection is closed,
+	// until the returned function is called.
+	Keep(peerID libp2ppeer.ID) (release func())
+}
+
+// transportDialer is implemented by the swarm.
+type transportDialer interface {
+	TransportForDialing(addr ma.Multiaddr) transport.Transport
+}
+
+// Service coordinates hole punching with the peers connected over a relay.
+type Service struct {
+	host      host.Host
+	keeper    Keeper
+	logger    logging.Logger
+	punchable func(addr ma.Multiaddr) bool
+	public    func(addr ma.Multiaddr) bool
+
+	handledMu sync.Mutex
+	handled   map[libp2ppeer.ID]time.Time // last coordination started by the peer
+}
+
+// New creates a new hole punch service for the host. The keeper may be nil.
+func New(h host.Host, keeper Keeper, logger logging.Logger) *Service {
+	return &Service{
+		host:      h,
+		keeper:    keeper,
+		logger:    logger,
+		punchable: IsQUIC,
+		public:    manet.IsPublicAddr,
+		handled:   make(map[libp2ppeer.ID]time.Time),
+	}
+}
+
+// Punch tries to replace the relayed connection to the peer with a
+// direct one. The round trip time of the address exchange is used to
+// time the probes so that both peers probe each other at the same time.
+// The relayed connection is closed only after a probe succeeded and the
+// peer is connected over the relay again if the direct dial fails.
+func (s *Service) Punch(ctx context.Context, peerID libp2ppeer.ID) error {
+	if !s.relayedOnly(peerID) {
+		return ErrNotRelayed
+	}
+
+	addrs, err := s.coordinate(ctx, peerID)
+	if err != nil {
+		return err
+	}
+
+	return s.replaceRelayed(ctx, peerID, addrs)
+}
+
+// coordinate exchanges the addresses with the peer and probes them.
+func (s *Service) coordinate(ctx context.Context, peerID libp2ppeer.ID) ([]ma.Multiaddr, error) {
+	ctx, cancel := context.WithTimeout(ctx, punchTimeout)
+	defer cancel()
+
+	own := s.directAddrs()
+	if len(own) == 0 {
+		return nil, ErrNoAddresses
+	}
+
+	stream, err := s.host.NewStream(ctx, peerID, ProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("new stream: %w", err)
+	}
+	defer stream.Close()
+
+	w, r := protobuf.NewWriter(stream), protobuf.NewReader(stream)
+
+	start := time.Now()
+	if err := w.WriteMsgWithContext(ctx, &pb.HolePunch{
+		Type:  pb.HolePunch_CONNECT,
+		Addrs: addrsToBytes(own),
+	}); err != nil {
+		_ = stream.Reset()
+		return nil, fmt.Errorf("write connect message: %w", err)
+	}
+
+	var resp pb.HolePunch
+	if err := r.ReadMsgWithContext(ctx, &resp); err != nil {
+		_ = stream.Reset()
+		return nil, fmt.Errorf("read connect message: %w", err)
+	}
+	rtt := time.Since(start)
+
+	if resp.Type != pb.HolePunch_CONNECT {
+		_ = stream.Reset()
+		return nil, ErrInvalidMessage
+	}
+	addrs := s.parseAddrs(peerID, resp.Addrs)
+	if len(addrs) == 0 {
+		_ = stream.Reset()
+		return nil, ErrNoAddresses
+	}
+
+	if err := w.WriteMsgWithContext(ctx, &pb.HolePunch{Type: pb.HolePunch_SYNC}); err != nil {
+		_ = stream.Reset()
+		return nil, fmt.Errorf("write sync message: %w", err)
+	}
+
+	// the remote peer starts probing when the sync message arrives,
+	// which is half of the round trip time from now
+	select {
+	case <-time.After(rtt / 2):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if err := s.probe(ctx, peerID, addrs); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+// Handle handles the hole punch coordination started by the remote peer.
+func (s *Service) Handle(stream network.Stream) {
+	peerID := stream.Conn().RemotePeer()
+	if !IsRelayed(stream.Conn().RemoteMultiaddr()) {
+		_ = stream.Reset()
+		return
+	}
+	if err := s.rateLimit(peerID); err != nil {
+		s.logger.Debugf("holepunch: peer %s: %v", peerID, err)
+		_ = stream.Reset()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), punchTimeout)
+	defer cancel()
+
+	w, r := protobuf.NewWriter(stream), protobuf.NewReader(stream)
+
+	var req pb.HolePunch
+	if err := r.ReadMsgWithContext(ctx, &req); err != nil {
+		s.logger.Debugf("holepunch: read connect message from peer %s: %v", peerID, err)
+		_ = stream.Reset()
+		return
+	}
+	if req.Type != pb.HolePunch_CONNECT {
+		s.logger.Debugf("holepunch: peer %s: %v", peerID, ErrInvalidMessage)
+		_ = stream.Reset()
+		return
+	}
+
+	if err := w.WriteMsgWithContext(ctx, &pb.HolePunch{
+		Type:  pb.HolePunch_CONNECT,
+		Addrs: addrsToBytes(s.directAddrs()),
+	}); err != nil {
+		s.logger.Debugf("holepunch: write connect message to peer %s: %v", peerID, err)
+		_ = stream.Reset()
+		return
+	}
+
+	var sync pb.HolePunch
+	if err := r.ReadMsgWithContext(ctx, &sync); err != nil {
+		s.logger.Debugf("holepunch: read sync message from peer %s: %v", peerID, err)
+		_ = stream.Reset()
+		return
+	}
+	_ = stream.Close()
+	if sync.Type != pb.HolePunch_SYNC {
+		s.logger.Debugf("holepunch: peer %s: %v", peerID, ErrInvalidMessage)
+		return
+	}
+
+	addrs := s.parseAddrs(peerID, req.Addrs)
+	if len(addrs) == 0 {
+		return
+	}
+
+	// the remote peer closes the relayed connection
+	// before it dials the direct addresses
+	release := s.keep(peerID)
+	defer release()
+
+	if err := s.probe(ctx, peerID, addrs); err != nil {
+		s.logger.Debugf("holepunch: peer %s: %v", peerID, err)
+		return
+	}
+
+	if err := s.waitDirect(ctx, peerID); err != nil {
+		s.logger.Debugf("holepunch: peer %s: %v", peerID, err)
+		return
+	}
+	s.logger.Tracef("holepunch: direct connection with peer %s established", peerID)
+}
+
+// rateLimit records the coordination started by the peer and returns
+// ErrRateLimitExceeded if the previous one is not older than the handle
+// interval.
+func (s *Service) rateLimit(peerID libp2ppeer.ID) error {
+	s.handledMu.Lock()
+	defer s.handledMu.Unlock()
+
+	now := time.Now()
+	for id, t := range s.handled {
+		if now.Sub(t) >= handleInterval {
+			delete(s.handled, id)
+		}
+	}
+
+	if _, ok := s.handled[peerID]; ok {
+		return ErrRateLimitExceeded
+	}
+	s.handled[peerID] = now
+	return nil
+}
+
+// probe dials the direct addresses of the peer with the transports,
+// bypassing the swarm which would reuse the relayed connection. The
+// dials open the NAT mappings for the addresses of the peer and a
+// successful one shows that the direct connection can be established.
+func (s *Service) probe(ctx context.Context, peerID libp2ppeer.ID, addrs []ma.Multiaddr) error {
+	dialer, ok := s.host.Network().(transportDialer)
+	if !ok {
+		return fmt.Errorf("%w: network does not dial transports", ErrPunchFailed)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	errs := make(chan error, len(addrs))
+	for _, addr := range addrs {
+		go func(addr ma.Multiaddr) {
+			t := dialer.TransportForDialing(addr)
+			if t == nil {
+				errs <- fmt.Errorf("no transport for %s", addr)
+				return
+			}
+			c, err := t.Dial(ctx, addr, peerID)
+			if err != nil {
+				errs <- err
+				return
+			}
+			errs <- c.Close()
+		}(addr)
+	}
+
+	var err error
+	for range addrs {
+		if err = <-errs; err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrPunchFailed, err)
+}
+
+// replaceRelayed closes the connections to the peer, as the swarm would
+// reuse them otherwise, and dials the direct addresses. The peer is
+// connected over the relay again if the direct dial fails.
+func (s *Service) replaceRelayed(ctx context.Context, peerID libp2ppeer.ID, addrs []ma.Multiaddr) error {
+	release := s.keep(peerID)
+	defer release()
+
+	ps := s.host.Peerstore()
+	relayAddrs := ps.Addrs(peerID)
+
+	// besides the relayed connections, only the short lived
+	// connections of the probes of the remote peer are closed
+	for _, c := range s.host.Network().ConnsToPeer(peerID) {
+		_ = c.Close()
+	}
+	ps.ClearAddrs(peerID)
+
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	err := s.host.Connect(dialCtx, libp2ppeer.AddrInfo{ID: peerID, Addrs: addrs})
+	cancel()
+
+	ps.AddAddrs(peerID, relayAddrs, peerstore.ConnectedAddrTTL)
+
+	if err == nil && s.directConnected(peerID) {
+		return nil
+	}
+	if err == nil {
+		err = errors.New("no direct connection")
+	}
+
+	dialCtx, cancel = context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	if rerr := s.host.Connect(dialCtx, libp2ppeer.AddrInfo{ID: peerID, Addrs: relayAddrs}); rerr != nil {
+		return fmt.Errorf("%w: %v, reconnect over relay: %v", ErrPunchFailed, err, rerr)
+	}
+	return fmt.Errorf("%w: %v", ErrPunchFailed, err)
+}
+
+// waitDirect waits until the relayed connection to the peer is
+// replaced by a direct one.
+func (s *Service) waitDirect(ctx context.Context, peerID libp2ppeer.ID) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if s.directConnected(peerID) && !s.relayed(peerID) {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrPunchFailed, ctx.Err())
+		}
+	}
+}
+
+func (s *Service) keep(peerID libp2ppeer.ID) (release func()) {
+	if s.keeper == nil {
+		return func() {}
+	}
+	return s.keeper.Keep(peerID)
+}
+
+// relayedOnly reports whether the peer is connected only over relays.
+func (s *Service) relayedOnly(peerID libp2ppeer.ID) bool {
+	conns := s.host.Network().ConnsToPeer(peerID)
+	if len(conns) == 0 {
+		return false
+	}
+	for _, c := range conns {
+		if !IsRelayed(c.RemoteMultiaddr()) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Service) relayed(peerID libp2ppeer.ID) bool {
+	for _, c := range s.host.Network().ConnsToPeer(peerID) {
+		if IsRelayed(c.RemoteMultiaddr()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) directConnected(peerID libp2ppeer.ID) bool {
+	for _, c := range s.host.Network().ConnsToPeer(peerID) {
+		if !IsRelayed(c.RemoteMultiaddr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// directAddrs returns the punchable addresses of the host. The host may
+// advertise only the relay addresses when it is not publicly reachable,
+// so all of its addresses are used if available.
+func (s *Service) directAddrs() (addrs []ma.Multiaddr) {
+	hostAddrs := s.host.Addrs()
+	if h, ok := s.host.(interface{ AllAddrs() []ma.Multiaddr }); ok {
+		hostAddrs = h.AllAddrs()
+	}
+	for _, addr := range hostAddrs {
+		if IsRelayed(addr) || !s.punchable(addr) {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// parseAddrs returns the punchable addresses supplied by the peer. Only
+// public addresses and the addresses already known for the peer are
+// accepted, so that the peer can not make the node dial arbitrary hosts
+// of the local networks.
+func (s *Service) parseAddrs(peerID libp2ppeer.ID, b [][]byte) (addrs []ma.Multiaddr) {
+	known := s.host.Peerstore().Addrs(peerID)
+	for _, a := range b {
+		addr, err := ma.NewMultiaddrBytes(a)
+		if err != nil || IsRelayed(addr) || !s.punchable(addr) {
+			continue
+		}
+		if !s.public(addr) && !containsAddr(known, addr) {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+func containsAddr(addrs []ma.Multiaddr, addr ma.Multiaddr) bool {
+	for _, a := range addrs {
+		if a.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsQUIC reports whether the address is a QUIC address.
+func IsQUIC(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_QUIC)
+	return err == nil
+}
+
+// IsRelayed reports whether the address is a circuit relay address.
+func IsRelayed(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+func addrsToBytes(addrs []ma.Multiaddr) [][]byte {
+	b := make([][]byte, 0, len(addrs))
+	for _, addr := range addrs {
+		b = append(b, addr.Bytes())
+	}
+	return b
+}
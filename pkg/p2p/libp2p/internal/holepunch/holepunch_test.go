@@ -0,0 +1,249 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package holepunch_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/p2p/libp2p/internal/holepunch"
+
+	"github.com/libp2p/go-libp2p"
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	"github.com/libp2p/go-libp2p-core/control"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	libp2ppeer "github.com/libp2p/go-libp2p-core/peer"
+	tptu "github.com/libp2p/go-libp2p-transport-upgrader"
+	tcp "github.com/libp2p/go-tcp-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func newHost(t *testing.T, opts ...libp2p.Option) host.Host {
+	t.Helper()
+
+	h, err := libp2p.New(context.Background(), append([]libp2p.Option{
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		// without the shared port the probes of both peers do
+		// not end up in a simultaneous open on the loopback
+		libp2p.Transport(func(u *tptu.Upgrader) *tcp.TcpTransport {
+			t := tcp.NewTCPTransport(u)
+			t.DisableReuseport = true
+			return t
+		}),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Close() })
+	return h
+}
+
+// newService creates the hole punch service which punches with the TCP
+// addresses of the test hosts.
+func newService(h host.Host) *holepunch.Service {
+	s := holepunch.New(h, nil, logging.New(ioutil.Discard, 0))
+	s.SetPunchable(func(ma.Multiaddr) bool { return true })
+	return s
+}
+
+// directGater rejects the direct inbound connections.
+type directGater struct{}
+
+func (directGater) InterceptPeerDial(libp2ppeer.ID) bool               { return true }
+func (directGater) InterceptAddrDial(libp2ppeer.ID, ma.Multiaddr) bool { return true }
+func (directGater) InterceptAccept(c network.ConnMultiaddrs) bool {
+	return holepunch.IsRelayed(c.RemoteMultiaddr())
+}
+func (directGater) InterceptSecured(d network.Direction, _ libp2ppeer.ID, c network.ConnMultiaddrs) bool {
+	return d == network.DirOutbound || holepunch.IsRelayed(c.RemoteMultiaddr())
+}
+func (directGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) { return true, 0 }
+
+func connectRelayed(t *testing.T, from, relay, to host.Host) {
+	t.Helper()
+
+	ctx := context.Background()
+	if err := to.Connect(ctx, libp2ppeer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := ma.NewMultiaddr(relay.Addrs()[0].String() + "/p2p/" + relay.ID().Pretty() + "/p2p-circuit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Connect(ctx, libp2ppeer.AddrInfo{ID: to.ID(), Addrs: []ma.Multiaddr{addr}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range from.Network().ConnsToPeer(to.ID()) {
+		if !holepunch.IsRelayed(c.RemoteMultiaddr()) {
+			t.Fatalf("got direct connection %s, want relayed", c.RemoteMultiaddr())
+		}
+	}
+}
+
+func TestPunch(t *testing.T) {
+	relay := newHost(t, libp2p.EnableRelay(circuit.OptHop))
+	client := newHost(t, libp2p.EnableRelay())
+	server := newHost(t, libp2p.EnableRelay())
+
+	server.SetStreamHandler(holepunch.ProtocolID, newService(server).Handle)
+	connectRelayed(t, client, relay, server)
+
+	if err := newService(client).Punch(context.Background(), server.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	conns := client.Network().ConnsToPeer(server.ID())
+	if len(conns) == 0 {
+		t.Fatal("no connection to the server")
+	}
+	for _, c := range conns {
+		if holepunch.IsRelayed(c.RemoteMultiaddr()) {
+			t.Fatalf("got relayed connection %s, want direct", c.RemoteMultiaddr())
+		}
+	}
+
+	// the server upgrades the connection on its side as well
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var relayed bool
+		for _, c := range server.Network().ConnsToPeer(client.ID()) {
+			if holepunch.IsRelayed(c.RemoteMultiaddr()) {
+				relayed = true
+			}
+		}
+		if !relayed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server connection is still relayed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestPunchNotRelayed(t *testing.T) {
+	client := newHost(t)
+	server := newHost(t)
+
+	server.SetStreamHandler(holepunch.ProtocolID, newService(server).Handle)
+
+	punch := newService(client)
+	if err := punch.Punch(context.Background(), server.ID()); !errors.Is(err, holepunch.ErrNotRelayed) {
+		t.Fatalf("got error %v, want %v", err, holepunch.ErrNotRelayed)
+	}
+
+	if err := client.Connect(context.Background(), libp2ppeer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := punch.Punch(context.Background(), server.ID()); !errors.Is(err, holepunch.ErrNotRelayed) {
+		t.Fatalf("got error %v, want %v", err, holepunch.ErrNotRelayed)
+	}
+}
+
+func TestPunchUnsupported(t *testing.T) {
+	relay := newHost(t, libp2p.EnableRelay(circuit.OptHop))
+	client := newHost(t, libp2p.EnableRelay())
+	server := newHost(t, libp2p.EnableRelay())
+
+	connectRelayed(t, client, relay, server)
+
+	// the relayed connection is kept if the peer does not support the protocol
+	if err := newService(client).Punch(context.Background(), server.ID()); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(client.Network().ConnsToPeer(server.ID())) == 0 {
+		t.Fatal("relayed connection is closed")
+	}
+}
+
+func TestPunchUnreachable(t *testing.T) {
+	relay := newHost(t, libp2p.EnableRelay(circuit.OptHop))
+	client := newHost(t, libp2p.EnableRelay())
+	server := newHost(t, libp2p.EnableRelay(), libp2p.ConnectionGater(directGater{}))
+
+	server.SetStreamHandler(holepunch.ProtocolID, newService(server).Handle)
+	connectRelayed(t, client, relay, server)
+
+	// the relayed connection is not touched if the probes fail
+	if err := newService(client).Punch(context.Background(), server.ID()); !errors.Is(err, holepunch.ErrPunchFailed) {
+		t.Fatalf("got error %v, want %v", err, holepunch.ErrPunchFailed)
+	}
+	conns := client.Network().ConnsToPeer(server.ID())
+	if len(conns) == 0 {
+		t.Fatal("relayed connection is closed")
+	}
+	for _, c := range conns {
+		if !holepunch.IsRelayed(c.RemoteMultiaddr()) {
+			t.Fatalf("got direct connection %s, want relayed", c.RemoteMultiaddr())
+		}
+	}
+}
+
+func TestPunchQUICOnly(t *testing.T) {
+	relay := newHost(t, libp2p.EnableRelay(circuit.OptHop))
+	client := newHost(t, libp2p.EnableRelay())
+	server := newHost(t, libp2p.EnableRelay())
+
+	server.SetStreamHandler(holepunch.ProtocolID, holepunch.New(server, nil, logging.New(ioutil.Discard, 0)).Handle)
+	connectRelayed(t, client, relay, server)
+
+	// the hosts have only TCP addresses
+	if err := holepunch.New(client, nil, logging.New(ioutil.Discard, 0)).Punch(context.Background(), server.ID()); !errors.Is(err, holepunch.ErrNoAddresses) {
+		t.Fatalf("got error %v, want %v", err, holepunch.ErrNoAddresses)
+	}
+	if len(client.Network().ConnsToPeer(server.ID())) == 0 {
+		t.Fatal("relayed connection is closed")
+	}
+}
+
+func TestHandleRateLimit(t *testing.T) {
+	relay := newHost(t, libp2p.EnableRelay(circuit.OptHop))
+	client := newHost(t, libp2p.EnableRelay())
+	server := newHost(t, libp2p.EnableRelay(), libp2p.ConnectionGater(directGater{}))
+
+	server.SetStreamHandler(holepunch.ProtocolID, newService(server).Handle)
+	connectRelayed(t, client, relay, server)
+
+	punch := newService(client)
+	if err := punch.Punch(context.Background(), server.ID()); !errors.Is(err, holepunch.ErrPunchFailed) {
+		t.Fatalf("got error %v, want %v", err, holepunch.ErrPunchFailed)
+	}
+
+	// the server does not coordinate with the peer again right away
+	err := punch.Punch(context.Background(), server.ID())
+	if err == nil || errors.Is(err, holepunch.ErrPunchFailed) {
+		t.Fatalf("got error %v, want the coordination to be refused", err)
+	}
+}
+
+func TestParseAddrs(t *testing.T) {
+	h := newHost(t)
+	peer := newHost(t)
+
+	observed := ma.StringCast("/ip4/192.168.0.1/udp/1634/quic")
+	unknown := ma.StringCast("/ip4/192.168.0.2/udp/1634/quic")
+	public := ma.StringCast("/ip4/1.1.1.1/udp/1634/quic")
+	h.Peerstore().AddAddr(peer.ID(), observed, time.Hour)
+
+	s := holepunch.New(h, nil, logging.New(ioutil.Discard, 0))
+	got := s.ParseAddrs(peer.ID(), [][]byte{observed.Bytes(), unknown.Bytes(), public.Bytes()})
+
+	want := []ma.Multiaddr{observed, public}
+	if len(got) != len(want) {
+		t.Fatalf("got addresses %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Fatalf("got addresses %v, want %v", got, want)
+		}
+	}
+}
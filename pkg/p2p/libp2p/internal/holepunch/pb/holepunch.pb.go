@@ -0,0 +1,383 @@
+// Code generated by protoc-gen-gogo. DO NOT EDIT.
+// source: holepunch.proto
+
+package pb
+
+import (
+	fmt "fmt"
+	proto "github.com/gogo/protobuf/proto"
+	io "io"
+	math "math"
+	math_bits "math/bits"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
+
+type HolePunch_MessageType int32
+
+const (
+	HolePunch_CONNECT HolePunch_MessageType = 0
+	HolePunch_SYNC    HolePunch_MessageType = 1
+)
+
+var HolePunch_MessageType_name = map[int32]string{
+	0: "CONNECT",
+	1: "SYNC",
+}
+
+var HolePunch_MessageType_value = map[string]int32{
+	"CONNECT": 0,
+	"SYNC":    1,
+}
+
+func (x HolePunch_MessageType) String() string {
+	return proto.EnumName(HolePunch_MessageType_name, int32(x))
+}
+
+func (HolePunch_MessageType) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_290ddea0f23ef64a, []int{0, 0}
+}
+
+type HolePunch struct {
+	Type  HolePunch_MessageType `protobuf:"varint,1,opt,name=Type,proto3,enum=holepunch.HolePunch_MessageType" json:"Type,omitempty"`
+	Addrs [][]byte              `protobuf:"bytes,2,rep,name=Addrs,proto3" json:"Addrs,omitempty"`
+}
+
+func (m *HolePunch) Reset()         { *m = HolePunch{} }
+func (m *HolePunch) String() string { return proto.CompactTextString(m) }
+func (*HolePunch) ProtoMessage()    {}
+func (*HolePunch) Descriptor() ([]byte, []int) {
+	return fileDescriptor_290ddea0f23ef64a, []int{0}
+}
+func (m *HolePunch) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *HolePunch) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_HolePunch.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *HolePunch) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HolePunch.Merge(m, src)
+}
+func (m *HolePunch) XXX_Size() int {
+	return m.Size()
+}
+func (m *HolePunch) XXX_DiscardUnknown() {
+	xxx_messageInfo_HolePunch.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HolePunch proto.InternalMessageInfo
+
+func (m *HolePunch) GetType() HolePunch_MessageType {
+	if m != nil {
+		return m.Type
+	}
+	return HolePunch_CONNECT
+}
+
+func (m *HolePunch) GetAddrs() [][]byte {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("holepunch.HolePunch_MessageType", HolePunch_MessageType_name, HolePunch_MessageType_value)
+	proto.RegisterType((*HolePunch)(nil), "holepunch.HolePunch")
+}
+
+func init() { proto.RegisterFile("holepunch.proto", fileDescriptor_290ddea0f23ef64a) }
+
+var fileDescriptor_290ddea0f23ef64a = []byte{
+	// 170 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0xcf, 0xc8, 0xcf, 0x49,
+	0x2d, 0x28, 0xcd, 0x4b, 0xce, 0xd0, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x84, 0x0b, 0x28,
+	0xd5, 0x72, 0x71, 0x7a, 0xe4, 0xe7, 0xa4, 0x06, 0x80, 0x38, 0x42, 0x26, 0x5c, 0x2c, 0x21, 0x95,
+	0x05, 0xa9, 0x12, 0x8c, 0x0a, 0x8c, 0x1a, 0x7c, 0x46, 0x0a, 0x7a, 0x08, 0x7d, 0x70, 0x35, 0x7a,
+	0xbe, 0xa9, 0xc5, 0xc5, 0x89, 0xe9, 0xa9, 0x20, 0x75, 0x41, 0x60, 0xd5, 0x42, 0x22, 0x5c, 0xac,
+	0x8e, 0x29, 0x29, 0x45, 0xc5, 0x12, 0x4c, 0x0a, 0xcc, 0x1a, 0x3c, 0x41, 0x10, 0x8e, 0x92, 0x0a,
+	0x17, 0x37, 0x92, 0x52, 0x21, 0x6e, 0x2e, 0x76, 0x67, 0x7f, 0x3f, 0x3f, 0x57, 0xe7, 0x10, 0x01,
+	0x06, 0x21, 0x0e, 0x2e, 0x96, 0xe0, 0x48, 0x3f, 0x67, 0x01, 0x46, 0x27, 0x99, 0x13, 0x8f, 0xe4,
+	0x18, 0x2f, 0x3c, 0x92, 0x63, 0x7c, 0xf0, 0x48, 0x8e, 0x71, 0xc2, 0x63, 0x39, 0x86, 0x0b, 0x8f,
+	0xe5, 0x18, 0x6e, 0x3c, 0x96, 0x63, 0x88, 0x62, 0x2a, 0x48, 0x4a, 0x62, 0x03, 0x3b, 0xd7, 0x18,
+	0x30, 0x00, 0x28, 0x50, 0x64, 0x6a, 0xc1, 0x00, 0x00, 0x00,
+}
+
+func (m *HolePunch) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *HolePunch) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *HolePunch) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.Addrs) > 0 {
+		for iNdEx := len(m.Addrs) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Addrs[iNdEx])
+			copy(dAtA[i:], m.Addrs[iNdEx])
+			i = encodeVarintHolepunch(dAtA, i, uint64(len(m.Addrs[iNdEx])))
+			i--
+			dAtA[i] = 0x12
+		}
+	}
+	if m.Type != 0 {
+		i = encodeVarintHolepunch(dAtA, i, uint64(m.Type))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func encodeVarintHolepunch(dAtA []byte, offset int, v uint64) int {
+	offset -= sovHolepunch(v)
+	base := offset
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return base
+}
+func (m *HolePunch) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Type != 0 {
+		n += 1 + sovHolepunch(uint64(m.Type))
+	}
+	if len(m.Addrs) > 0 {
+		for _, b := range m.Addrs {
+			l = len(b)
+			n += 1 + l + sovHolepunch(uint64(l))
+		}
+	}
+	return n
+}
+
+func sovHolepunch(x uint64) (n int) {
+	return (math_bits.Len64(x|1) + 6) / 7
+}
+func sozHolepunch(x uint64) (n int) {
+	return sovHolepunch(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *HolePunch) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowHolepunch
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: HolePunch: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: HolePunch: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Type", wireType)
+			}
+			m.Type = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHolepunch
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Type |= HolePunch_MessageType(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Addrs", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHolepunch
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthHolepunch
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthHolepunch
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Addrs = append(m.Addrs, make([]byte, postIndex-iNdEx))
+			copy(m.Addrs[len(m.Addrs)-1], dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipHolepunch(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthHolepunch
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthHolepunch
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func skipHolepunch(dAtA []byte) (n int, err error) {
+	l := len(dAtA)
+	iNdEx := 0
+	depth := 0
+	for iNdEx < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return 0, ErrIntOverflowHolepunch
+			}
+			if iNdEx >= l {
+				return 0, io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		wireType := int(wire & 0x7)
+		switch wireType {
+		case 0:
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowHolepunch
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				iNdEx++
+				if dAtA[iNdEx-1] < 0x80 {
+					break
+				}
+			}
+		case 1:
+			iNdEx += 8
+		case 2:
+			var length int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowHolepunch
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				length |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if length < 0 {
+				return 0, ErrInvalidLengthHolepunch
+			}
+			iNdEx += length
+		case 3:
+			depth++
+		case 4:
+			if depth == 0 {
+				return 0, ErrUnexpectedEndOfGroupHolepunch
+			}
+			depth--
+		case 5:
+			iNdEx += 4
+		default:
+			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
+		}
+		if iNdEx < 0 {
+			return 0, ErrInvalidLengthHolepunch
+		}
+		if depth == 0 {
+			return iNdEx, nil
+		}
+	}
+	return 0, io.ErrUnexpectedEOF
+}
+
+var (
+	ErrInvalidLengthHolepunch        = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowHolepunch          = fmt.Errorf("proto: integer overflow")
+	ErrUnexpectedEndOfGroupHolepunch = fmt.Errorf("proto: unexpected end of group")
+)
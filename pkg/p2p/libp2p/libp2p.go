@@ -17,9 +17,12 @@ import (
 	pencrypto "github.com/penguintop/penguin/pkg/crypto"
 	"github.com/penguintop/penguin/pkg/logging"
 	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/p2p/bandwidth"
 	"github.com/penguintop/penguin/pkg/p2p/libp2p/internal/blocklist"
 	"github.com/penguintop/penguin/pkg/p2p/libp2p/internal/breaker"
+	"github.com/penguintop/penguin/pkg/p2p/libp2p/internal/connmanager"
 	handshake "github.com/penguintop/penguin/pkg/p2p/libp2p/internal/handshake"
+	"github.com/penguintop/penguin/pkg/p2p/libp2p/internal/holepunch"
 	"github.com/penguintop/penguin/pkg/pen"
 	"github.com/penguintop/penguin/pkg/storage"
     "github.com/penguintop/penguin/pkg/penguin"
@@ -27,6 +30,7 @@ import (
 	"github.com/penguintop/penguin/pkg/tracing"
 	"github.com/libp2p/go-libp2p"
 	autonat "github.com/libp2p/go-libp2p-autonat"
+	circuit "github.com/libp2p/go-libp2p-circuit"
 	crypto "github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/network"
@@ -40,6 +44,7 @@ import (
 	"github.com/libp2p/go-tcp-transport"
 	ws "github.com/libp2p/go-ws-transport"
 	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/multiformats/go-multistream"
 )
 
@@ -60,6 +65,9 @@ type Service struct {
 	metrics           metrics
 	networkID         uint64
 	handshakeService  *handshake.Service
+	holePunchService  *holepunch.Service
+	bandwidth         *bandwidth.Accountant
+	connManager       *connmanager.Manager
 	addressbook       addressbook.Putter
 	peers             *peerRegistry
 	connectionBreaker breaker.Interface
@@ -92,6 +100,15 @@ type Options struct {
 	LightNodeLimit int
 	WelcomeMessage string
 	Transaction    []byte
+	EnableRelay    bool
+	RelayServer    bool
+	Relays         []string
+	Bandwidth      *bandwidth.Accountant
+	// MaxInboundConnections, MaxOutboundConnections and MaxConnections
+	// limit the number of connections to full nodes, zero is unlimited.
+	MaxInboundConnections  int
+	MaxOutboundConnections int
+	MaxConnections         int
 }
 
 func New(ctx context.Context, signer pencrypto.Signer, networkID uint64, overlay penguin.Address, addr string, ab addressbook.Putter, storer storage.StateStorer, lightNodes *lightnode.Container, swapBackend handshake.SenderMatcher, logger logging.Logger, tracer *tracing.Tracer, o Options) (*Service, error) {
@@ -162,6 +179,32 @@ func New(ctx context.Context, signer pencrypto.Signer, networkID uint64, overlay
 		)
 	}
 
+	if o.EnableRelay {
+		var relayOpts []circuit.RelayOpt
+		if o.RelayServer {
+			relayOpts = append(relayOpts, circuit.OptHop)
+		}
+		opts = append(opts, libp2p.EnableRelay(relayOpts...))
+
+		// Relay servers are publicly reachable and do not need
+		// to reserve the relayed addresses on other relays.
+		if !o.RelayServer && len(o.Relays) > 0 {
+			staticRelays := make([]libp2ppeer.AddrInfo, 0, len(o.Relays))
+			for _, r := range o.Relays {
+				relayAddr, err := ma.NewMultiaddr(r)
+				if err != nil {
+					return nil, fmt.Errorf("relay address %s: %w", r, err)
+				}
+				info, err := libp2ppeer.AddrInfoFromP2pAddr(relayAddr)
+				if err != nil {
+					return nil, fmt.Errorf("relay address %s: %w", r, err)
+				}
+				staticRelays = append(staticRelays, *info)
+			}
+			opts = append(opts, libp2p.EnableAutoRelay(), libp2p.StaticRelays(staticRelays))
+		}
+	}
+
 	transports := []libp2p.Option{
 		libp2p.Transport(func(u *tptu.Upgrader) *tcp.TcpTransport {
 			t := tcp.NewTCPTransport(u)
@@ -199,7 +242,8 @@ func New(ctx context.Context, signer pencrypto.Signer, networkID uint64, overlay
 	// If you want to help other peers to figure out if they are behind
 	// NATs, you can launch the server-side of AutoNAT too (AutoRelay
 	// already runs the client)
-	if _, err = autonat.New(ctx, h, autonat.EnableService(dialer.Network())); err != nil {
+	autoNAT, err := autonat.New(ctx, h, autonat.EnableService(dialer.Network()))
+	if err != nil {
 		return nil, fmt.Errorf("autonat: %w", err)
 	}
 
@@ -217,12 +261,23 @@ func New(ctx context.Context, signer pencrypto.Signer, networkID uint64, overlay
 		advertisableAddresser = natAddrResolver
 	}
 
+	if o.EnableRelay {
+		advertisableAddresser = newRelayAddressResolver(h, autoNAT, advertisableAddresser)
+	}
+
 	handshakeService, err := handshake.New(signer, advertisableAddresser, swapBackend, overlay, networkID, o.FullNode, o.Transaction, o.WelcomeMessage, logger)
 	if err != nil {
 		return nil, fmt.Errorf("handshake service: %w", err)
 	}
 
 	peerRegistry := newPeerRegistry()
+
+	// Hole punching is done over QUIC only.
+	var holePunchService *holepunch.Service
+	if o.EnableRelay && o.EnableQUIC {
+		holePunchService = holepunch.New(h, peerRegistry, logger)
+	}
+
 	s := &Service{
 		ctx:               ctx,
 		host:              h,
@@ -230,6 +285,13 @@ func New(ctx context.Context, signer pencrypto.Signer, networkID uint64, overlay
 		natAddrResolver:   natAddrResolver,
 		autonatDialer:     dialer,
 		handshakeService:  handshakeService,
+		holePunchService:  holePunchService,
+		bandwidth:         o.Bandwidth,
+		connManager: connmanager.New(overlay, connmanager.Options{
+			MaxInbound:  o.MaxInboundConnections,
+			MaxOutbound: o.MaxOutboundConnections,
+			MaxTotal:    o.MaxConnections,
+		}),
 		libp2pPeerstore:   libp2pPeerstore,
 		metrics:           newMetrics(),
 		networkID:         networkID,
@@ -260,12 +322,21 @@ func New(ctx context.Context, signer pencrypto.Signer, networkID uint64, overlay
 
 	s.host.SetStreamHandlerMatch(id, matcher, s.handleIncoming)
 
+	if s.holePunchService != nil {
+		s.host.SetStreamHandler(holepunch.ProtocolID, s.handleHolePunch)
+	}
+
 	h.Network().SetConnHandler(func(_ network.Conn) {
 		s.metrics.HandledConnectionCount.Inc()
 	})
 
 	h.Network().Notify(peerRegistry)       // update peer registry on network events
 	h.Network().Notify(s.handshakeService) // update handshake service on network events
+
+	if o.MaxInboundConnections > 0 || o.MaxOutboundConnections > 0 || o.MaxConnections > 0 {
+		go s.trimConnections()
+	}
+
 	return s, nil
 }
 
@@ -281,6 +352,18 @@ func (s *Service) handleIncoming(stream network.Stream) {
 	}
 
 	peerID := stream.Conn().RemotePeer()
+	if blocked, err := s.networkBlocked(stream.Conn().RemoteMultiaddr()); err != nil || blocked {
+		if err != nil {
+			s.logger.Debugf("stream handler: blocklisting: network of %s: %v", peerID, err)
+		} else {
+			s.logger.Debugf("stream handler: blocked connection from blocklisted network of peer id %s", peerID)
+			s.metrics.BlocklistEvents.WithLabelValues(blocklistTypeNetwork, blocklistActionReject).Inc()
+		}
+		_ = stream.Reset()
+		_ = s.host.Network().ClosePeer(peerID)
+		return
+	}
+
 	handshakeStream := NewStream(stream)
 	i, err := s.handshakeService.Handle(s.ctx, handshakeStream, stream.Conn().RemoteMultiaddr(), peerID)
 	if err != nil {
@@ -304,6 +387,7 @@ func (s *Service) handleIncoming(stream network.Stream) {
 
 	if blocked {
 		s.logger.Errorf("stream handler: blocked connection from blocklisted peer %s", overlay)
+		s.metrics.BlocklistEvents.WithLabelValues(blocklistTypePeer, blocklistActionReject).Inc()
 		_ = handshakeStream.Reset()
 		_ = s.host.Network().ClosePeer(peerID)
 		return
@@ -318,7 +402,19 @@ func (s *Service) handleIncoming(stream network.Stream) {
 		}
 	}
 
-	if exists := s.peers.addIfNotExists(stream.Conn(), overlay, i.FullNode); exists {
+	if i.FullNode {
+		prune, err := s.connManager.Add(overlay, connmanager.Inbound)
+		if err != nil {
+			s.logger.Debugf("stream handler: connection limit: peer %s: %v", overlay, err)
+			s.metrics.ConnectionRejectCount.Inc()
+			_ = handshakeStream.Reset()
+			_ = s.host.Network().ClosePeer(peerID)
+			return
+		}
+		s.pruneConnections(prune)
+	}
+
+	if exists := s.peers.addIfNotExists(stream.Conn(), overlay, i.FullNode, i.Advertised.Underlay); exists {
 		s.logger.Debugf("stream handler: peer %s already exists", overlay)
 		if err = handshakeStream.FullClose(); err != nil {
 			s.logger.Debugf("stream handler: could not close stream %s: %v", overlay, err)
@@ -336,7 +432,7 @@ func (s *Service) handleIncoming(stream network.Stream) {
 	}
 
 	if i.FullNode {
-		err = s.addressbook.Put(i.PenAddress.Overlay, *i.PenAddress)
+		err = s.addressbook.PutWithSource(i.PenAddress.Overlay, *i.PenAddress, addressbook.SourceHandshake)
 		if err != nil {
 			s.logger.Debugf("stream handler: addressbook put error %s: %v", peerID, err)
 			s.logger.Errorf("stream handler: unable to persist peer %v", peerID)
@@ -416,6 +512,10 @@ func (s *Service) SetPickyNotifier(n p2p.PickyNotifier) {
 func (s *Service) AddProtocol(p p2p.ProtocolSpec) (err error) {
 	for _, ss := range p.StreamSpecs {
 		ss := ss
+		handler := ss.Handler
+		if s.bandwidth != nil {
+			handler = s.bandwidth.Middleware(p.Name)(handler)
+		}
 		id := protocol.ID(p2p.NewPenguinStreamName(p.Name, p.Version, ss.Name))
 		matcher, err := s.protocolSemverMatcher(id)
 		if err != nil {
@@ -463,7 +563,7 @@ func (s *Service) AddProtocol(p p2p.ProtocolSpec) (err error) {
 			logger := tracing.NewLoggerWithTraceID(ctx, s.logger)
 
 			s.metrics.HandledStreamCount.Inc()
-			if err := ss.Handler(ctx, p2p.Peer{Address: overlay, FullNode: full}, stream); err != nil {
+			if err := handler(ctx, p2p.Peer{Address: overlay, FullNode: full}, stream); err != nil {
 				var de *p2p.DisconnectError
 				if errors.As(err, &de) {
 					_ = stream.Reset()
@@ -473,7 +573,7 @@ func (s *Service) AddProtocol(p p2p.ProtocolSpec) (err error) {
 				var bpe *p2p.BlockPeerError
 				if errors.As(err, &bpe) {
 					_ = stream.Reset()
-					if err := s.Blocklist(overlay, bpe.Duration()); err != nil {
+					if err := s.Blocklist(overlay, bpe.Duration(), p.Name+": "+bpe.Reason()); err != nil {
 						logger.Debugf("blocklist: could not blocklist peer %s: %v", peerID, err)
 						logger.Errorf("unable to blocklist peer %v", peerID)
 					}
@@ -495,6 +595,12 @@ func (s *Service) AddProtocol(p p2p.ProtocolSpec) (err error) {
 	return nil
 }
 
+// AdvertisedUnderlay returns the underlay address of this node which was
+// advertised to the connected peer in the handshake.
+func (s *Service) AdvertisedUnderlay(peer penguin.Address) (ma.Multiaddr, bool) {
+	return s.peers.advertisedUnderlay(peer)
+}
+
 func (s *Service) Addresses() (addreses []ma.Multiaddr, err error) {
 	for _, addr := range s.host.Addrs() {
 		a, err := buildUnderlayAddress(addr, s.host.ID())
@@ -519,18 +625,76 @@ func (s *Service) NATManager() basichost.NATManager {
 	return s.natManager
 }
 
-func (s *Service) Blocklist(overlay penguin.Address, duration time.Duration) error {
-	if err := s.blocklist.Add(overlay, duration); err != nil {
+func (s *Service) Blocklist(overlay penguin.Address, duration time.Duration, reason string) error {
+	if err := s.blocklist.Add(overlay, duration, reason); err != nil {
 		s.metrics.BlocklistedPeerErrCount.Inc()
 		_ = s.Disconnect(overlay)
 		return fmt.Errorf("blocklist peer %s: %v", overlay, err)
 	}
 	s.metrics.BlocklistedPeerCount.Inc()
+	s.metrics.BlocklistEvents.WithLabelValues(blocklistTypePeer, blocklistActionAdd).Inc()
+	s.logger.Debugf("blocklisted peer %s for %s: %s", overlay, duration, reason)
 
 	_ = s.Disconnect(overlay)
 	return nil
 }
 
+// Unblocklist removes the peer from the blocklist.
+func (s *Service) Unblocklist(overlay penguin.Address) error {
+	if err := s.blocklist.Remove(overlay); err != nil {
+		return err
+	}
+	s.metrics.BlocklistEvents.WithLabelValues(blocklistTypePeer, blocklistActionRemove).Inc()
+	return nil
+}
+
+// BlocklistNetwork disconnects the peers from the IP network
+// and blocks connections with it for the duration.
+func (s *Service) BlocklistNetwork(network *net.IPNet, duration time.Duration, reason string) error {
+	if err := s.blocklist.AddNetwork(network, duration, reason); err != nil {
+		return fmt.Errorf("blocklist network %s: %w", network, err)
+	}
+	s.metrics.BlocklistEvents.WithLabelValues(blocklistTypeNetwork, blocklistActionAdd).Inc()
+	s.logger.Debugf("blocklisted network %s for %s: %s", network, duration, reason)
+
+	for _, c := range s.host.Network().Conns() {
+		ip, err := manet.ToIP(c.RemoteMultiaddr())
+		if err != nil || !network.Contains(ip) {
+			continue
+		}
+		if overlay, found := s.peers.overlay(c.RemotePeer()); found {
+			_ = s.Disconnect(overlay)
+		} else {
+			_ = s.host.Network().ClosePeer(c.RemotePeer())
+		}
+	}
+	return nil
+}
+
+// UnblocklistNetwork removes the IP network from the blocklist.
+func (s *Service) UnblocklistNetwork(network *net.IPNet) error {
+	if err := s.blocklist.RemoveNetwork(network); err != nil {
+		return err
+	}
+	s.metrics.BlocklistEvents.WithLabelValues(blocklistTypeNetwork, blocklistActionRemove).Inc()
+	return nil
+}
+
+// BlocklistedNetworks returns the blocked IP networks.
+func (s *Service) BlocklistedNetworks() ([]p2p.BlocklistedNetwork, error) {
+	return s.blocklist.Networks()
+}
+
+// networkBlocked reports whether the IP of the address is in a blocklisted
+// network. Addresses without an IP, like DNS addresses, are not blocked.
+func (s *Service) networkBlocked(addr ma.Multiaddr) (bool, error) {
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return false, nil
+	}
+	return s.blocklist.IPBlocked(ip)
+}
+
 func buildHostAddress(peerID libp2ppeer.ID) (ma.Multiaddr, error) {
 	return ma.NewMultiaddr(fmt.Sprintf("/p2p/%s", peerID.Pretty()))
 }
@@ -545,6 +709,33 @@ func buildUnderlayAddress(addr ma.Multiaddr, peerID libp2ppeer.ID) (ma.Multiaddr
 	return addr.Encapsulate(hostAddr), nil
 }
 
+// upgradeRelayedConnection tries to replace the relayed connection to
+// the connected peer with a direct one. The peer stays connected over
+// the relay if the hole punching fails.
+func (s *Service) upgradeRelayedConnection(peerID libp2ppeer.ID) {
+	s.metrics.HolePunchCount.Inc()
+	if err := s.holePunchService.Punch(s.ctx, peerID); err != nil {
+		s.logger.Debugf("hole punch %s: %v", peerID, err)
+		s.metrics.HolePunchErrCount.Inc()
+		return
+	}
+	s.logger.Tracef("hole punch %s: direct connection established", peerID)
+}
+
+func (s *Service) handleHolePunch(stream network.Stream) {
+	select {
+	case <-s.ready:
+	case <-s.halt:
+		go func() { _ = stream.Reset() }()
+		return
+	case <-s.ctx.Done():
+		go func() { _ = stream.Reset() }()
+		return
+	}
+
+	s.holePunchService.Handle(stream)
+}
+
 func (s *Service) Connect(ctx context.Context, addr ma.Multiaddr) (address *pen.Address, err error) {
 	// Extract the peer ID from the multiaddr.
 	info, err := libp2ppeer.AddrInfoFromP2pAddr(addr)
@@ -559,6 +750,15 @@ func (s *Service) Connect(ctx context.Context, addr ma.Multiaddr) (address *pen.
 
 	remoteAddr := addr.Decapsulate(hostAddr)
 
+	blocked, err := s.networkBlocked(remoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("blocklisting: %w", err)
+	}
+	if blocked {
+		s.metrics.BlocklistEvents.WithLabelValues(blocklistTypeNetwork, blocklistActionReject).Inc()
+		return nil, fmt.Errorf("network blocklisted")
+	}
+
 	if overlay, found := s.peers.isConnected(info.ID, remoteAddr); found {
 		address = &pen.Address{
 			Overlay:  overlay,
@@ -597,7 +797,7 @@ func (s *Service) Connect(ctx context.Context, addr ma.Multiaddr) (address *pen.
 
 	overlay := i.PenAddress.Overlay
 
-	blocked, err := s.blocklist.Exists(overlay)
+	blocked, err = s.blocklist.Exists(overlay)
 	if err != nil {
 		s.logger.Debugf("blocklisting: exists %s: %v", info.ID, err)
 		s.logger.Errorf("internal error while connecting with peer %s", info.ID)
@@ -608,12 +808,24 @@ func (s *Service) Connect(ctx context.Context, addr ma.Multiaddr) (address *pen.
 
 	if blocked {
 		s.logger.Errorf("blocked connection to blocklisted peer %s", info.ID)
+		s.metrics.BlocklistEvents.WithLabelValues(blocklistTypePeer, blocklistActionReject).Inc()
 		_ = handshakeStream.Reset()
 		_ = s.host.Network().ClosePeer(info.ID)
 		return nil, fmt.Errorf("peer blocklisted")
 	}
 
-	if exists := s.peers.addIfNotExists(stream.Conn(), overlay, i.FullNode); exists {
+	if i.FullNode {
+		prune, err := s.connManager.Add(overlay, connmanager.Outbound)
+		if err != nil {
+			s.metrics.ConnectionRejectCount.Inc()
+			_ = handshakeStream.Reset()
+			_ = s.host.Network().ClosePeer(info.ID)
+			return nil, p2p.ErrConnectionLimit
+		}
+		s.pruneConnections(prune)
+	}
+
+	if exists := s.peers.addIfNotExists(stream.Conn(), overlay, i.FullNode, i.Advertised.Underlay); exists {
 		if err := handshakeStream.FullClose(); err != nil {
 			_ = s.Disconnect(overlay)
 			return nil, fmt.Errorf("peer exists, full close: %w", err)
@@ -628,7 +840,7 @@ func (s *Service) Connect(ctx context.Context, addr ma.Multiaddr) (address *pen.
 	}
 
 	if i.FullNode {
-		err = s.addressbook.Put(overlay, *i.PenAddress)
+		err = s.addressbook.PutWithSource(overlay, *i.PenAddress, addressbook.SourceHandshake)
 		if err != nil {
 			_ = s.Disconnect(overlay)
 			return nil, fmt.Errorf("storing pen address: %w", err)
@@ -655,6 +867,10 @@ func (s *Service) Connect(ctx context.Context, addr ma.Multiaddr) (address *pen.
 
 	s.metrics.CreatedConnectionCount.Inc()
 
+	if s.holePunchService != nil && holepunch.IsRelayed(remoteAddr) {
+		go s.upgradeRelayedConnection(info.ID)
+	}
+
 	s.logger.Debugf("successfully connected to peer %s%s (outbound)", i.PenAddress.ShortString(), i.LightString())
 	s.logger.Infof("successfully connected to peer %s%s (outbound)", overlay, i.LightString())
 	return i.PenAddress, nil
@@ -667,6 +883,7 @@ func (s *Service) Disconnect(overlay penguin.Address) error {
 
 	// found is checked at the bottom of the function
 	found, full, peerID := s.peers.remove(overlay)
+	s.connManager.Remove(overlay)
 
 	_ = s.host.Network().ClosePeer(peerID)
 
@@ -726,13 +943,72 @@ func (s *Service) disconnected(address penguin.Address) {
 	if s.lightNodes != nil {
 		s.lightNodes.Disconnected(peer)
 	}
+	if s.bandwidth != nil {
+		s.bandwidth.Disconnected(address)
+	}
+	s.connManager.Remove(address)
+}
+
+// SetConnectionProtector sets the function which reports the peers whose
+// connections are never pruned, such as the neighbourhood peers.
+func (s *Service) SetConnectionProtector(f func(penguin.Address) bool) {
+	s.connManager.SetProtector(f)
+}
+
+// SetConnectionScorer sets the scorer used to rank the connections to prune.
+func (s *Service) SetConnectionScorer(scorer connmanager.Scorer) {
+	s.connManager.SetScorer(scorer)
+}
+
+// ConnectionAllowed reports whether the connection to the full node
+// with the overlay address would be accepted by the connection manager.
+// The recently pruned peers and the peers which are not more valuable
+// than the existing connections are not allowed over the limits.
+func (s *Service) ConnectionAllowed(overlay penguin.Address, inbound bool) bool {
+	d := connmanager.Outbound
+	if inbound {
+		d = connmanager.Inbound
+	}
+	return s.connManager.Allow(overlay, d)
+}
+
+// ConnectionStats returns the connection counts and limits.
+func (s *Service) ConnectionStats() p2p.ConnectionStats {
+	return s.connManager.Stats()
+}
+
+// pruneConnections disconnects the peers selected by the connection manager.
+func (s *Service) pruneConnections(peers []penguin.Address) {
+	for _, peer := range peers {
+		s.logger.Debugf("connection manager: pruning connection to peer %s", peer)
+		s.metrics.ConnectionPruneCount.Inc()
+		_ = s.Disconnect(peer)
+	}
+}
+
+// trimConnections periodically prunes the connections which exceed the
+// limits, as the connections to protected peers are added over them.
+func (s *Service) trimConnections() {
+	ticker := time.NewTicker(connmanager.DefaultGracePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.halt:
+			return
+		case <-ticker.C:
+			s.pruneConnections(s.connManager.Trim())
+		}
+	}
 }
 
 func (s *Service) Peers() []p2p.Peer {
 	return s.peers.peers()
 }
 
-func (s *Service) BlocklistedPeers() ([]p2p.Peer, error) {
+func (s *Service) BlocklistedPeers() ([]p2p.BlocklistedPeer, error) {
 	return s.blocklist.Peers()
 }
 
@@ -763,6 +1039,10 @@ func (s *Service) NewStream(ctx context.Context, overlay penguin.Address, header
 		return nil, fmt.Errorf("send headers: %w", err)
 	}
 
+	if s.bandwidth != nil {
+		return s.bandwidth.Stream(protocolName, overlay, stream), nil
+	}
+
 	return stream, nil
 }
 
@@ -22,8 +22,23 @@ type metrics struct {
 	DisconnectCount            prometheus.Counter
 	ConnectBreakerCount        prometheus.Counter
 	UnexpectedProtocolReqCount prometheus.Counter
+	HolePunchCount             prometheus.Counter
+	HolePunchErrCount          prometheus.Counter
+	ConnectionPruneCount       prometheus.Counter
+	ConnectionRejectCount      prometheus.Counter
+	BlocklistEvents            *prometheus.CounterVec
 }
 
+// Label values of the blocklist events.
+const (
+	blocklistTypePeer    = "peer"
+	blocklistTypeNetwork = "network"
+
+	blocklistActionAdd    = "add"
+	blocklistActionRemove = "remove"
+	blocklistActionReject = "reject"
+)
+
 func newMetrics() metrics {
 	subsystem := "libp2p"
 
@@ -82,6 +97,39 @@ func newMetrics() metrics {
 			Name:      "unexpected_protocol_request_count",
 			Help:      "Number of requests the peer is not expecting.",
 		}),
+		HolePunchCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "hole_punch_count",
+			Help:      "Number of hole punch attempts initiated by the node.",
+		}),
+		HolePunchErrCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "hole_punch_err_count",
+			Help:      "Number of failed hole punch attempts initiated by the node.",
+		}),
+		ConnectionPruneCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "connection_prune_count",
+			Help:      "Number of connections pruned by the connection manager.",
+		}),
+		ConnectionRejectCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "connection_reject_count",
+			Help:      "Number of connections rejected as the connection limits are reached.",
+		}),
+		BlocklistEvents: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: m.Namespace,
+				Subsystem: subsystem,
+				Name:      "blocklist_events",
+				Help:      "Number of blocklist additions, removals and rejected connections by peer or network.",
+			},
+			[]string{"type", "action"},
+		),
 	}
 }
 
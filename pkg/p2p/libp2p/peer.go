@@ -21,8 +21,10 @@ type peerRegistry struct {
 	underlays   map[string]libp2ppeer.ID                    // map overlay address to underlay peer id
 	overlays    map[libp2ppeer.ID]penguin.Address           // map underlay peer id to overlay address
 	full        map[libp2ppeer.ID]bool                      // map to track whether a node is full or light node (true=full)
+	advertised  map[libp2ppeer.ID]ma.Multiaddr              // underlay of this node advertised to the peer in the handshake
 	connections map[libp2ppeer.ID]map[network.Conn]struct{} // list of connections for safe removal on Disconnect notification
 	streams     map[libp2ppeer.ID]map[network.Stream]context.CancelFunc
+	kept        map[libp2ppeer.ID]int // peers kept registered without connections while their connections are replaced
 	mu          sync.RWMutex
 
 	//nolint:misspell
@@ -39,8 +41,10 @@ func newPeerRegistry() *peerRegistry {
 		underlays:   make(map[string]libp2ppeer.ID),
 		overlays:    make(map[libp2ppeer.ID]penguin.Address),
 		full:        make(map[libp2ppeer.ID]bool),
+		advertised:  make(map[libp2ppeer.ID]ma.Multiaddr),
 		connections: make(map[libp2ppeer.ID]map[network.Conn]struct{}),
 		streams:     make(map[libp2ppeer.ID]map[network.Stream]context.CancelFunc),
+		kept:        make(map[libp2ppeer.ID]int),
 
 		Notifiee: new(network.NoopNotifiee),
 	}
@@ -67,11 +71,67 @@ func (r *peerRegistry) Disconnected(_ network.Network, c network.Conn) {
 
 	// if there are multiple libp2p connections, consider the node disconnected only when the last connection is disconnected
 	delete(r.connections[peerID], c)
-	if len(r.connections[peerID]) > 0 {
+	if len(r.connections[peerID]) > 0 || r.kept[peerID] > 0 {
 		r.mu.Unlock()
 		return
 	}
 
+	overlay := r.disconnect(peerID)
+	r.mu.Unlock()
+	r.disconnecter.disconnected(overlay)
+
+}
+
+// Connected adds the connection to an already registered peer, so that
+// the peer is disconnected only when its last connection is closed.
+func (r *peerRegistry) Connected(_ network.Network, c network.Conn) {
+	peerID := c.RemotePeer()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.overlays[peerID]; !ok {
+		return
+	}
+	if _, ok := r.connections[peerID]; !ok {
+		r.connections[peerID] = make(map[network.Conn]struct{})
+	}
+	r.connections[peerID][c] = struct{}{}
+}
+
+// Keep keeps the peer registered when its last connection is closed,
+// until the returned function is called. The peer is disconnected on
+// the release if it has no connections left by then.
+func (r *peerRegistry) Keep(peerID libp2ppeer.ID) (release func()) {
+	r.mu.Lock()
+	r.kept[peerID]++
+	r.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			if r.kept[peerID]--; r.kept[peerID] > 0 {
+				r.mu.Unlock()
+				return
+			}
+			delete(r.kept, peerID)
+
+			_, registered := r.overlays[peerID]
+			if !registered || len(r.connections[peerID]) > 0 {
+				r.mu.Unlock()
+				return
+			}
+			overlay := r.disconnect(peerID)
+			r.mu.Unlock()
+			r.disconnecter.disconnected(overlay)
+		})
+	}
+}
+
+// disconnect removes the peer from the registry and returns its overlay.
+// It must be called with the lock held.
+func (r *peerRegistry) disconnect(peerID libp2ppeer.ID) penguin.Address {
 	delete(r.connections, peerID)
 	overlay := r.overlays[peerID]
 	delete(r.overlays, peerID)
@@ -81,9 +141,8 @@ func (r *peerRegistry) Disconnected(_ network.Network, c network.Conn) {
 	}
 	delete(r.streams, peerID)
 	delete(r.full, peerID)
-	r.mu.Unlock()
-	r.disconnecter.disconnected(overlay)
-
+	delete(r.advertised, peerID)
+	return overlay
 }
 
 func (r *peerRegistry) addStream(peerID libp2ppeer.ID, stream network.Stream, cancel context.CancelFunc) {
@@ -131,7 +190,7 @@ func (r *peerRegistry) peers() []p2p.Peer {
 	return peers
 }
 
-func (r *peerRegistry) addIfNotExists(c network.Conn, overlay penguin.Address, full bool) (exists bool) {
+func (r *peerRegistry) addIfNotExists(c network.Conn, overlay penguin.Address, full bool, advertised ma.Multiaddr) (exists bool) {
 	peerID := c.RemotePeer()
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -151,6 +210,7 @@ func (r *peerRegistry) addIfNotExists(c network.Conn, overlay penguin.Address, f
 	r.underlays[overlay.ByteString()] = peerID
 	r.overlays[peerID] = overlay
 	r.full[peerID] = full
+	r.advertised[peerID] = advertised
 	return false
 
 }
@@ -176,6 +236,20 @@ func (r *peerRegistry) fullnode(peerID libp2ppeer.ID) (bool, bool) {
 	return full, found
 }
 
+// advertisedUnderlay returns the underlay of this
+// node which was advertised to the connected peer.
+func (r *peerRegistry) advertisedUnderlay(overlay penguin.Address) (ma.Multiaddr, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	peerID, found := r.underlays[overlay.ByteString()]
+	if !found {
+		return nil, false
+	}
+	underlay, found := r.advertised[peerID]
+	return underlay, found && underlay != nil
+}
+
 func (r *peerRegistry) isConnected(peerID libp2ppeer.ID, remoteAddr ma.Multiaddr) (penguin.Address, bool) {
 	if remoteAddr == nil {
 		return penguin.ZeroAddress, false
@@ -217,6 +291,7 @@ func (r *peerRegistry) remove(overlay penguin.Address) (found, full bool, peerID
 	delete(r.streams, peerID)
 	full = r.full[peerID]
 	delete(r.full, peerID)
+	delete(r.advertised, peerID)
 	r.mu.Unlock()
 
 	return found, full, peerID
@@ -0,0 +1,56 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libp2p
+
+import (
+	"github.com/penguintop/penguin/pkg/p2p/libp2p/internal/handshake"
+	"github.com/penguintop/penguin/pkg/p2p/libp2p/internal/holepunch"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	libp2ppeer "github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+type reachabilityStatuser interface {
+	Status() network.Reachability
+}
+
+// relayAddressResolver advertises the relayed address of the host
+// when the host is not publicly reachable, so that the peers behind
+// NATs can still be dialed through the relay.
+type relayAddressResolver struct {
+	host     host.Host
+	status   reachabilityStatuser
+	resolver handshake.AdvertisableAddressResolver
+}
+
+func newRelayAddressResolver(h host.Host, status reachabilityStatuser, resolver handshake.AdvertisableAddressResolver) *relayAddressResolver {
+	return &relayAddressResolver{
+		host:     h,
+		status:   status,
+		resolver: resolver,
+	}
+}
+
+func (r *relayAddressResolver) Resolve(observedAddress ma.Multiaddr) (ma.Multiaddr, error) {
+	if r.status.Status() != network.ReachabilityPrivate {
+		return r.resolver.Resolve(observedAddress)
+	}
+
+	observableAddrInfo, err := libp2ppeer.AddrInfoFromP2pAddr(observedAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range r.host.Addrs() {
+		if !holepunch.IsRelayed(a) {
+			continue
+		}
+		return buildUnderlayAddress(a, observableAddrInfo.ID)
+	}
+
+	return r.resolver.Resolve(observedAddress)
+}
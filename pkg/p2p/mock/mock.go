@@ -7,6 +7,7 @@ package mock
 import (
 	"context"
 	"errors"
+	"net"
 	"time"
 
 	"github.com/penguintop/penguin/pkg/p2p"
@@ -21,12 +22,16 @@ type Service struct {
 	connectFunc           func(ctx context.Context, addr ma.Multiaddr) (address *pen.Address, err error)
 	disconnectFunc        func(overlay penguin.Address) error
 	peersFunc             func() []p2p.Peer
-	blocklistedPeersFunc  func() ([]p2p.Peer, error)
+	blocklistedPeersFunc  func() ([]p2p.BlocklistedPeer, error)
 	addressesFunc         func() ([]ma.Multiaddr, error)
 	setNotifierFunc       func(p2p.PickyNotifier)
 	setWelcomeMessageFunc func(string) error
 	getWelcomeMessageFunc func() string
-	blocklistFunc         func(penguin.Address, time.Duration) error
+	blocklistFunc         func(penguin.Address, time.Duration, string) error
+	unblocklistFunc       func(penguin.Address) error
+	blocklistNetworkFunc  func(*net.IPNet, time.Duration, string) error
+	unblocklistNetFunc    func(*net.IPNet) error
+	blocklistedNetsFunc   func() ([]p2p.BlocklistedNetwork, error)
 	welcomeMessage        string
 }
 
@@ -66,7 +71,7 @@ func WithPeersFunc(f func() []p2p.Peer) Option {
 }
 
 // WithBlocklistedPeersFunc sets the mock implementation of the BlocklistedPeers function
-func WithBlocklistedPeersFunc(f func() ([]p2p.Peer, error)) Option {
+func WithBlocklistedPeersFunc(f func() ([]p2p.BlocklistedPeer, error)) Option {
 	return optionFunc(func(s *Service) {
 		s.blocklistedPeersFunc = f
 	})
@@ -93,12 +98,40 @@ func WithSetWelcomeMessageFunc(f func(string) error) Option {
 	})
 }
 
-func WithBlocklistFunc(f func(penguin.Address, time.Duration) error) Option {
+func WithBlocklistFunc(f func(penguin.Address, time.Duration, string) error) Option {
 	return optionFunc(func(s *Service) {
 		s.blocklistFunc = f
 	})
 }
 
+// WithUnblocklistFunc sets the mock implementation of the Unblocklist function
+func WithUnblocklistFunc(f func(penguin.Address) error) Option {
+	return optionFunc(func(s *Service) {
+		s.unblocklistFunc = f
+	})
+}
+
+// WithBlocklistNetworkFunc sets the mock implementation of the BlocklistNetwork function
+func WithBlocklistNetworkFunc(f func(*net.IPNet, time.Duration, string) error) Option {
+	return optionFunc(func(s *Service) {
+		s.blocklistNetworkFunc = f
+	})
+}
+
+// WithUnblocklistNetworkFunc sets the mock implementation of the UnblocklistNetwork function
+func WithUnblocklistNetworkFunc(f func(*net.IPNet) error) Option {
+	return optionFunc(func(s *Service) {
+		s.unblocklistNetFunc = f
+	})
+}
+
+// WithBlocklistedNetworksFunc sets the mock implementation of the BlocklistedNetworks function
+func WithBlocklistedNetworksFunc(f func() ([]p2p.BlocklistedNetwork, error)) Option {
+	return optionFunc(func(s *Service) {
+		s.blocklistedNetsFunc = f
+	})
+}
+
 // New will create a new mock P2P Service with the given options
 func New(opts ...Option) *Service {
 	s := new(Service)
@@ -143,7 +176,7 @@ func (s *Service) Peers() []p2p.Peer {
 	return s.peersFunc()
 }
 
-func (s *Service) BlocklistedPeers() ([]p2p.Peer, error) {
+func (s *Service) BlocklistedPeers() ([]p2p.BlocklistedPeer, error) {
 	if s.blocklistedPeersFunc == nil {
 		return nil, nil
 	}
@@ -168,11 +201,39 @@ func (s *Service) GetWelcomeMessage() string {
 
 func (s *Service) Halt() {}
 
-func (s *Service) Blocklist(overlay penguin.Address, duration time.Duration) error {
+func (s *Service) Blocklist(overlay penguin.Address, duration time.Duration, reason string) error {
 	if s.blocklistFunc == nil {
 		return errors.New("function blocklist not configured")
 	}
-	return s.blocklistFunc(overlay, duration)
+	return s.blocklistFunc(overlay, duration, reason)
+}
+
+func (s *Service) Unblocklist(overlay penguin.Address) error {
+	if s.unblocklistFunc == nil {
+		return errors.New("function unblocklist not configured")
+	}
+	return s.unblocklistFunc(overlay)
+}
+
+func (s *Service) BlocklistNetwork(network *net.IPNet, duration time.Duration, reason string) error {
+	if s.blocklistNetworkFunc == nil {
+		return errors.New("function blocklist network not configured")
+	}
+	return s.blocklistNetworkFunc(network, duration, reason)
+}
+
+func (s *Service) UnblocklistNetwork(network *net.IPNet) error {
+	if s.unblocklistNetFunc == nil {
+		return errors.New("function unblocklist network not configured")
+	}
+	return s.unblocklistNetFunc(network)
+}
+
+func (s *Service) BlocklistedNetworks() ([]p2p.BlocklistedNetwork, error) {
+	if s.blocklistedNetsFunc == nil {
+		return nil, nil
+	}
+	return s.blocklistedNetsFunc()
 }
 
 func (s *Service) SetPickyNotifier(f p2p.PickyNotifier) {
@@ -9,6 +9,7 @@ package p2p
 import (
 	"context"
 	"io"
+	"net"
 	"time"
 
 	"github.com/penguintop/penguin/pkg/pen"
@@ -23,7 +24,7 @@ type Service interface {
 	Connect(ctx context.Context, addr ma.Multiaddr) (address *pen.Address, err error)
 	Disconnecter
 	Peers() []Peer
-	BlocklistedPeers() ([]Peer, error)
+	BlocklistedPeers() ([]BlocklistedPeer, error)
 	Addresses() ([]ma.Multiaddr, error)
 	SetPickyNotifier(PickyNotifier)
 	Halter
@@ -32,8 +33,8 @@ type Service interface {
 type Disconnecter interface {
 	Disconnect(overlay penguin.Address) error
 	// Blocklist will disconnect a peer and put it on a blocklist (blocking in & out connections) for provided duration
-	// duration 0 is treated as an infinite duration
-	Blocklist(overlay penguin.Address, duration time.Duration) error
+	// duration 0 is treated as an infinite duration, reason describes why the peer is blocked
+	Blocklist(overlay penguin.Address, duration time.Duration, reason string) error
 }
 
 type Halter interface {
@@ -58,6 +59,36 @@ type DebugService interface {
 	Service
 	SetWelcomeMessage(val string) error
 	GetWelcomeMessage() string
+	BlocklistManager
+}
+
+// BlocklistManager manages the blocklist entries of peers and networks.
+type BlocklistManager interface {
+	// Unblocklist removes the peer from the blocklist.
+	// ErrNotBlocklisted is returned if the peer is not on the blocklist.
+	Unblocklist(overlay penguin.Address) error
+	// BlocklistNetwork will disconnect the peers from the IP network and block
+	// in & out connections with it for provided duration, duration 0 is
+	// treated as an infinite duration.
+	BlocklistNetwork(network *net.IPNet, duration time.Duration, reason string) error
+	// UnblocklistNetwork removes the IP network from the blocklist.
+	// ErrNotBlocklisted is returned if the network is not on the blocklist.
+	UnblocklistNetwork(network *net.IPNet) error
+	// BlocklistedNetworks returns the blocked IP networks.
+	BlocklistedNetworks() ([]BlocklistedNetwork, error)
+}
+
+// ConnectionStats holds the connection counts and limits of the
+// connection manager, zero limits are unlimited.
+type ConnectionStats struct {
+	Inbound     int    `json:"inbound"`
+	Outbound    int    `json:"outbound"`
+	Protected   int    `json:"protected"`
+	MaxInbound  int    `json:"maxInbound"`
+	MaxOutbound int    `json:"maxOutbound"`
+	MaxTotal    int    `json:"maxTotal"`
+	Pruned      uint64 `json:"pruned"`
+	Rejected    uint64 `json:"rejected"`
 }
 
 // Streamer is able to create a new Stream.
@@ -104,6 +135,24 @@ type Peer struct {
 	FullNode bool            `json:"fullNode"`
 }
 
+// BlocklistedPeer is a peer on the blocklist.
+type BlocklistedPeer struct {
+	Peer
+	// Reason describes why the peer is blocked.
+	Reason string `json:"reason"`
+	// Duration of the block, 0 means that the peer is blocked permanently.
+	Duration time.Duration `json:"duration"`
+}
+
+// BlocklistedNetwork is an IP network on the blocklist.
+type BlocklistedNetwork struct {
+	Network *net.IPNet
+	// Reason describes why the network is blocked.
+	Reason string
+	// Duration of the block, 0 means that the network is blocked permanently.
+	Duration time.Duration
+}
+
 // HandlerFunc handles a received Stream from a Peer.
 type HandlerFunc func(context.Context, Peer, Stream) error
 
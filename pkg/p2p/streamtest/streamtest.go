@@ -123,7 +123,7 @@ func (r *Recorder) NewStream(ctx context.Context, addr penguin.Address, h p2p.He
 		}
 	}
 	if handler == nil {
-		return nil, ErrStreamNotSupported
+		return nil, p2p.NewIncompatibleStreamError(ErrStreamNotSupported)
 	}
 	for i := len(r.middlewares) - 1; i >= 0; i-- {
 		handler = r.middlewares[i](handler)
@@ -391,7 +391,7 @@ func (r *RecorderDisconnecter) Disconnect(overlay penguin.Address) error {
 	return nil
 }
 
-func (r *RecorderDisconnecter) Blocklist(overlay penguin.Address, d time.Duration) error {
+func (r *RecorderDisconnecter) Blocklist(overlay penguin.Address, d time.Duration, _ string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
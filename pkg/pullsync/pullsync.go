@@ -23,6 +23,7 @@ import (
 	"github.com/penguintop/penguin/pkg/p2p/protobuf"
 	"github.com/penguintop/penguin/pkg/pullsync/pb"
 	"github.com/penguintop/penguin/pkg/pullsync/pullstorage"
+	"github.com/penguintop/penguin/pkg/reputation"
 	"github.com/penguintop/penguin/pkg/soc"
 	"github.com/penguintop/penguin/pkg/storage"
     "github.com/penguintop/penguin/pkg/penguin"
@@ -68,6 +69,7 @@ type Syncer struct {
 	wg         sync.WaitGroup
 	unwrap     func(penguin.Chunk)
 	validStamp func(penguin.Chunk, []byte) (penguin.Chunk, error)
+	reputation reputation.Recorder
 
 	ruidMtx sync.Mutex
 	ruidCtx map[uint32]func()
@@ -87,9 +89,15 @@ func New(streamer p2p.Streamer, storage pullstorage.Storer, unwrap func(penguin.
 		ruidCtx:    make(map[uint32]func()),
 		wg:         sync.WaitGroup{},
 		quit:       make(chan struct{}),
+		reputation: reputation.Noop,
 	}
 }
 
+// SetReputation sets the recorder of the peer interaction outcomes.
+func (s *Syncer) SetReputation(r reputation.Recorder) {
+	s.reputation = r
+}
+
 func (s *Syncer) Protocol() p2p.ProtocolSpec {
 	return p2p.ProtocolSpec{
 		Name:    protocolName,
@@ -116,6 +124,19 @@ func (s *Syncer) Protocol() p2p.ProtocolSpec {
 // If the requested interval is too large, the downstream peer has the liberty to
 // provide less chunks than requested.
 func (s *Syncer) SyncInterval(ctx context.Context, peer penguin.Address, bin uint8, from, to uint64) (topmost uint64, ruid uint32, err error) {
+	start := time.Now()
+	defer func() {
+		// canceled syncing is not a fault of the peer
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if err != nil {
+			s.reputation.Failure(peer, reputation.ProtocolPullSync)
+		} else {
+			s.reputation.Success(peer, reputation.ProtocolPullSync, time.Since(start))
+		}
+	}()
+
 	stream, err := s.streamer.NewStream(ctx, peer, nil, protocolName, protocolVersion, streamName)
 	if err != nil {
 		return 0, 0, fmt.Errorf("new stream: %w", err)
@@ -21,6 +21,7 @@ import (
 	"github.com/penguintop/penguin/pkg/p2p/protobuf"
 	"github.com/penguintop/penguin/pkg/pricer"
 	"github.com/penguintop/penguin/pkg/pushsync/pb"
+	"github.com/penguintop/penguin/pkg/reputation"
 	"github.com/penguintop/penguin/pkg/soc"
 	"github.com/penguintop/penguin/pkg/storage"
     "github.com/penguintop/penguin/pkg/penguin"
@@ -72,6 +73,7 @@ type PushSync struct {
 	signer         crypto.Signer
 	isFullNode     bool
 	failedRequests *failedRequestCache
+	reputation     reputation.Recorder
 }
 
 var defaultTTL = 20 * time.Second                     // request time to live
@@ -95,10 +97,16 @@ func New(address penguin.Address, streamer p2p.StreamerDisconnecter, storer stor
 		validStamp:     validStamp,
 		signer:         signer,
 		failedRequests: newFailedRequestCache(),
+		reputation:     reputation.Noop,
 	}
 	return ps
 }
 
+// SetReputation sets the recorder of the peer interaction outcomes.
+func (ps *PushSync) SetReputation(r reputation.Recorder) {
+	ps.reputation = r
+}
+
 func (s *PushSync) Protocol() p2p.ProtocolSpec {
 	return p2p.ProtocolSpec{
 		Name:    protocolName,
@@ -374,12 +382,18 @@ func (ps *PushSync) pushToClosest(ctx context.Context, ch penguin.Chunk, retryAl
 			ctxd, canceld := context.WithTimeout(ctx, defaultTTL)
 			defer canceld()
 
+			start := time.Now()
 			r, attempted, err := ps.pushPeer(ctxd, peer, ch)
 			// attempted is true if we get past accounting and actually attempt
 			// to send the request to the peer. If we dont get past accounting, we
 			// should not count the retry and try with a different peer again
 			if attempted {
 				allowedRetries--
+				if err != nil {
+					ps.reputation.Failure(peer, reputation.ProtocolPushSync)
+				} else {
+					ps.reputation.Success(peer, reputation.ProtocolPushSync, time.Since(start))
+				}
 			}
 			if err != nil {
 				logger.Debugf("could not push to peer %s: %v", peer, err)
@@ -0,0 +1,364 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package reputation provides a service which scores peers by the outcomes
+// of the interactions with them across protocols. Successes and failures
+// decay over time, so that the score reflects the recent peer behaviour.
+package reputation
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/storage"
+)
+
+// Protocols which report events.
+const (
+	ProtocolRetrieval  = "retrieval"
+	ProtocolPushSync   = "pushsync"
+	ProtocolPullSync   = "pullsync"
+	ProtocolHive       = "hive"
+	ProtocolSettlement = "settlement"
+)
+
+const (
+	keyPrefix = "reputation_"
+
+	// DefaultHalfLife is the default duration after which
+	// the weight of an event is halved.
+	DefaultHalfLife = 30 * time.Minute
+	// DefaultFlushInterval is the default interval of
+	// persisting changed scores.
+	DefaultFlushInterval = time.Minute
+
+	// NeutralScore is the score of peers without events.
+	NeutralScore = 0.5
+	// LowScore is the score below which peers are considered unreliable.
+	LowScore = 0.25
+
+	// latencyWeight is the weight of the last latency sample in the average.
+	latencyWeight = 0.2
+	// pruneWeight is the total event weight below which the
+	// record of a peer is removed as it does not affect the score.
+	pruneWeight = 0.01
+)
+
+// ErrNotFound is returned when there are no events recorded for a peer.
+var ErrNotFound = errors.New("reputation: peer not found")
+
+// Recorder records the outcomes of interactions with peers.
+type Recorder interface {
+	// Success records a successful interaction with the peer over the
+	// protocol. Zero latency is not taken into account.
+	Success(peer penguin.Address, protocol string, latency time.Duration)
+	// Failure records a failed interaction with the peer over the protocol.
+	Failure(peer penguin.Address, protocol string)
+}
+
+// Scorer provides peer scores.
+type Scorer interface {
+	// Score returns the score of the peer in the range from 0 to 1.
+	// Peers without events have the NeutralScore.
+	Score(peer penguin.Address) float64
+}
+
+// Interface is the reputation service interface.
+type Interface interface {
+	Recorder
+	Scorer
+	// PeerScore returns the score details of the peer.
+	PeerScore(peer penguin.Address) (*PeerScore, error)
+}
+
+// Noop is a Recorder which discards all events.
+var Noop Recorder = noop{}
+
+type noop struct{}
+
+func (noop) Success(penguin.Address, string, time.Duration) {}
+func (noop) Failure(penguin.Address, string)                {}
+
+// timeNow is used to deterministically mock time.Now() in tests.
+var timeNow = time.Now
+
+// Counters are the decayed event counts.
+type Counters struct {
+	Successes float64 `json:"successes"`
+	Failures  float64 `json:"failures"`
+}
+
+func (c *Counters) decay(f float64) {
+	c.Successes *= f
+	c.Failures *= f
+}
+
+// PeerScore is a view of the peer reputation.
+type PeerScore struct {
+	Score     float64             `json:"score"`
+	Successes float64             `json:"successes"`
+	Failures  float64             `json:"failures"`
+	Latency   time.Duration       `json:"latency"`
+	Protocols map[string]Counters `json:"protocols"`
+	Updated   time.Time           `json:"updated"`
+}
+
+// record is the persisted peer reputation.
+type record struct {
+	Counters
+	Latency   time.Duration        `json:"latency"`
+	Protocols map[string]*Counters `json:"protocols"`
+	Updated   time.Time            `json:"updated"`
+}
+
+// decay reduces the counters by the time elapsed since the last update.
+func (r *record) decay(now time.Time, halfLife time.Duration) {
+	if elapsed := now.Sub(r.Updated); elapsed > 0 {
+		f := math.Pow(0.5, float64(elapsed)/float64(halfLife))
+		r.Counters.decay(f)
+		for _, c := range r.Protocols {
+			c.decay(f)
+		}
+	}
+	r.Updated = now
+}
+
+func (r *record) score() float64 {
+	return score(r.Successes, r.Failures)
+}
+
+// score is the estimate of the success probability, which is
+// smoothed so that peers with few events stay near the neutral score.
+func score(successes, failures float64) float64 {
+	return (successes + 1) / (successes + failures + 2)
+}
+
+// Options are optional parameters of the Service.
+type Options struct {
+	// HalfLife is the duration after which the weight of an event is halved.
+	HalfLife time.Duration
+	// FlushInterval is the interval of persisting changed scores.
+	FlushInterval time.Duration
+}
+
+// Service keeps decaying peer scores which are persisted in the state store.
+type Service struct {
+	store    storage.StateStorer
+	logger   logging.Logger
+	halfLife time.Duration
+
+	mu    sync.Mutex
+	peers map[string]*record
+	dirty map[string]struct{}
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+var _ Interface = (*Service)(nil)
+
+// New loads the persisted scores and starts persisting the changes periodically.
+func New(store storage.StateStorer, logger logging.Logger, o Options) (*Service, error) {
+	if o.HalfLife <= 0 {
+		o.HalfLife = DefaultHalfLife
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = DefaultFlushInterval
+	}
+
+	s := &Service{
+		store:    store,
+		logger:   logger,
+		halfLife: o.HalfLife,
+		peers:    make(map[string]*record),
+		dirty:    make(map[string]struct{}),
+		quit:     make(chan struct{}),
+	}
+
+	if err := store.Iterate(keyPrefix, func(key, _ []byte) (bool, error) {
+		k := string(key)
+		if !strings.HasPrefix(k, keyPrefix) {
+			return true, nil
+		}
+		addr, err := penguin.ParseHexAddress(strings.TrimPrefix(k, keyPrefix))
+		if err != nil {
+			return true, err
+		}
+		var r record
+		if err := store.Get(k, &r); err != nil {
+			return true, err
+		}
+		s.peers[addr.ByteString()] = &r
+		return false, nil
+	}); err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.flushLoop(o.FlushInterval)
+
+	return s, nil
+}
+
+// Success implements the Recorder interface.
+func (s *Service) Success(peer penguin.Address, protocol string, latency time.Duration) {
+	s.record(peer, protocol, func(r *record, c *Counters) {
+		r.Successes++
+		c.Successes++
+		if latency > 0 {
+			if r.Latency == 0 {
+				r.Latency = latency
+			} else {
+				r.Latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(r.Latency))
+			}
+		}
+	})
+}
+
+// Failure implements the Recorder interface.
+func (s *Service) Failure(peer penguin.Address, protocol string) {
+	s.record(peer, protocol, func(r *record, c *Counters) {
+		r.Failures++
+		c.Failures++
+	})
+}
+
+func (s *Service) record(peer penguin.Address, protocol string, f func(r *record, c *Counters)) {
+	key := peer.ByteString()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.peers[key]
+	if !ok {
+		r = &record{Protocols: make(map[string]*Counters)}
+		s.peers[key] = r
+	}
+	r.decay(timeNow(), s.halfLife)
+	if r.Protocols == nil {
+		r.Protocols = make(map[string]*Counters)
+	}
+	c, ok := r.Protocols[protocol]
+	if !ok {
+		c = new(Counters)
+		r.Protocols[protocol] = c
+	}
+	f(r, c)
+	s.dirty[key] = struct{}{}
+}
+
+// Score implements the Scorer interface.
+func (s *Service) Score(peer penguin.Address) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.peers[peer.ByteString()]
+	if !ok {
+		return NeutralScore
+	}
+	r.decay(timeNow(), s.halfLife)
+	return r.score()
+}
+
+// PeerScore returns the score details of the peer.
+func (s *Service) PeerScore(peer penguin.Address) (*PeerScore, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.peers[peer.ByteString()]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	r.decay(timeNow(), s.halfLife)
+
+	protocols := make(map[string]Counters, len(r.Protocols))
+	for p, c := range r.Protocols {
+		protocols[p] = *c
+	}
+	return &PeerScore{
+		Score:     r.score(),
+		Successes: r.Successes,
+		Failures:  r.Failures,
+		Latency:   r.Latency,
+		Protocols: protocols,
+		Updated:   r.Updated,
+	}, nil
+}
+
+func (s *Service) flushLoop(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				s.logger.Debugf("reputation: flush: %v", err)
+			}
+		}
+	}
+}
+
+// flush persists the changed records in a single batch. Records of all
+// peers which decayed to a negligible weight are removed, also those
+// which did not change since they were loaded.
+func (s *Service) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := timeNow()
+	var pruned []string
+	for key, r := range s.peers {
+		r.decay(now, s.halfLife)
+		if r.Successes+r.Failures < pruneWeight {
+			pruned = append(pruned, key)
+		}
+	}
+
+	if len(s.dirty) == 0 && len(pruned) == 0 {
+		return nil
+	}
+
+	err := s.store.Batch(func(b storage.StateBatch) error {
+		for _, key := range pruned {
+			if err := b.Delete(keyPrefix + penguin.NewAddress([]byte(key)).String()); err != nil {
+				return err
+			}
+		}
+		for key := range s.dirty {
+			r, ok := s.peers[key]
+			if !ok || r.Successes+r.Failures < pruneWeight {
+				continue
+			}
+			if err := b.Put(keyPrefix+penguin.NewAddress([]byte(key)).String(), r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range pruned {
+		delete(s.peers, key)
+	}
+	s.dirty = make(map[string]struct{})
+	return nil
+}
+
+// Close stops the periodic persisting and persists the pending changes.
+func (s *Service) Close() error {
+	close(s.quit)
+	s.wg.Wait()
+	return s.flush()
+}
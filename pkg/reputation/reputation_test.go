@@ -0,0 +1,170 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reputation_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/reputation"
+	"github.com/penguintop/penguin/pkg/statestore/mock"
+	"github.com/penguintop/penguin/pkg/storage"
+)
+
+var (
+	peer1 = penguin.MustParseHexAddress("0100000000000000000000000000000000000000000000000000000000000000")
+	peer2 = penguin.MustParseHexAddress("0200000000000000000000000000000000000000000000000000000000000000")
+)
+
+func TestScore(t *testing.T) {
+	now := time.Unix(1000, 0)
+	reputation.SetTimeNow(func() time.Time { return now })
+	defer reputation.SetTimeNow(time.Now)
+
+	s := newTestService(t, mock.NewStateStore())
+
+	if got := s.Score(peer1); got != reputation.NeutralScore {
+		t.Fatalf("got score %v of unknown peer, want %v", got, reputation.NeutralScore)
+	}
+	if _, err := s.PeerScore(peer1); !errors.Is(err, reputation.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, reputation.ErrNotFound)
+	}
+
+	for i := 0; i < 8; i++ {
+		s.Success(peer1, reputation.ProtocolRetrieval, 100*time.Millisecond)
+		s.Failure(peer2, reputation.ProtocolPushSync)
+	}
+	s.Success(peer1, reputation.ProtocolPushSync, 200*time.Millisecond)
+	s.Failure(peer1, reputation.ProtocolPushSync)
+
+	if got, want := s.Score(peer1), 10.0/12; !equal(got, want) {
+		t.Fatalf("got score %v, want %v", got, want)
+	}
+	if got := s.Score(peer2); got >= reputation.LowScore {
+		t.Fatalf("got score %v of failing peer, want less than %v", got, reputation.LowScore)
+	}
+
+	ps, err := s.PeerScore(peer1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Latency != 120*time.Millisecond {
+		t.Fatalf("got latency %v, want %v", ps.Latency, 120*time.Millisecond)
+	}
+	if c := ps.Protocols[reputation.ProtocolPushSync]; c.Successes != 1 || c.Failures != 1 {
+		t.Fatalf("got pushsync counters %+v", c)
+	}
+	if c := ps.Protocols[reputation.ProtocolRetrieval]; c.Successes != 8 || c.Failures != 0 {
+		t.Fatalf("got retrieval counters %+v", c)
+	}
+
+	// after a half-life the weight of the events is halved
+	now = now.Add(reputation.DefaultHalfLife)
+	ps, err = s.PeerScore(peer1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(ps.Successes, 4.5) || !equal(ps.Failures, 0.5) {
+		t.Fatalf("got decayed counters %v/%v, want 4.5/0.5", ps.Successes, ps.Failures)
+	}
+	if got, want := ps.Score, 5.5/7; !equal(got, want) {
+		t.Fatalf("got score %v, want %v", got, want)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	now := time.Unix(1000, 0)
+	reputation.SetTimeNow(func() time.Time { return now })
+	defer reputation.SetTimeNow(time.Now)
+
+	store := mock.NewStateStore()
+	s, err := reputation.New(store, logging.New(ioutil.Discard, 0), reputation.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Success(peer1, reputation.ProtocolHive, 0)
+	s.Failure(peer2, reputation.ProtocolSettlement)
+	want := s.Score(peer1)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = newTestService(t, store)
+	if got := s.Score(peer1); !equal(got, want) {
+		t.Fatalf("got score %v after restart, want %v", got, want)
+	}
+
+	// decayed records are removed from the store
+	now = now.Add(20 * reputation.DefaultHalfLife)
+	s.Success(peer1, reputation.ProtocolHive, 0)
+	s.Failure(peer2, reputation.ProtocolSettlement)
+	now = now.Add(20 * reputation.DefaultHalfLife)
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	if err := store.Get("reputation_"+peer1.String(), &v); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+	if got := s.Score(peer1); got != reputation.NeutralScore {
+		t.Fatalf("got score %v of pruned peer, want %v", got, reputation.NeutralScore)
+	}
+}
+
+// TestPruneUnchanged tests that decayed records, which did not
+// change since they were loaded, are removed as well.
+func TestPruneUnchanged(t *testing.T) {
+	now := time.Unix(1000, 0)
+	reputation.SetTimeNow(func() time.Time { return now })
+	defer reputation.SetTimeNow(time.Now)
+
+	store := mock.NewStateStore()
+	s, err := reputation.New(store, logging.New(ioutil.Discard, 0), reputation.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Success(peer1, reputation.ProtocolHive, 0)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = newTestService(t, store)
+	now = now.Add(20 * reputation.DefaultHalfLife)
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var v interface{}
+	if err := store.Get("reputation_"+peer1.String(), &v); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+	if _, err := s.PeerScore(peer1); !errors.Is(err, reputation.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, reputation.ErrNotFound)
+	}
+}
+
+func newTestService(t *testing.T, store storage.StateStorer) *reputation.Service {
+	t.Helper()
+
+	s, err := reputation.New(store, logging.New(ioutil.Discard, 0), reputation.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return s
+}
+
+func equal(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
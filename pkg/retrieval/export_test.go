@@ -8,8 +8,13 @@ import (
 	"context"
 
 	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/penguin"
 )
 
 func (s *Service) Handler(ctx context.Context, p p2p.Peer, stream p2p.Stream) error {
 	return s.handler(ctx, p, stream)
 }
+
+func (s *Service) ClosestPeer(addr penguin.Address, skipPeers []penguin.Address, allowUpstream bool) (penguin.Address, error) {
+	return s.closestPeer(addr, skipPeers, allowUpstream)
+}
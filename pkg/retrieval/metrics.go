@@ -23,6 +23,7 @@ type metrics struct {
 	RetrieveChunkPOGainCounter prometheus.CounterVec
 	ChunkPrice                 prometheus.Summary
 	TotalErrors                prometheus.Counter
+	LatencySelectedPeerCounter prometheus.Counter
 }
 
 func newMetrics() metrics {
@@ -84,6 +85,12 @@ func newMetrics() metrics {
 			Name:      "total_errors",
 			Help:      "Total number of errors while retrieving chunk.",
 		}),
+		LatencySelectedPeerCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "latency_selected_peer_count",
+			Help:      "Number of requests to a peer selected over a closer one for its lower latency.",
+		}),
 	}
 }
 
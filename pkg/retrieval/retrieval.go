@@ -12,6 +12,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"time"
 
@@ -22,6 +23,7 @@ import (
 	"github.com/penguintop/penguin/pkg/p2p/protobuf"
 	"github.com/penguintop/penguin/pkg/postage"
 	"github.com/penguintop/penguin/pkg/pricer"
+	"github.com/penguintop/penguin/pkg/reputation"
 	pb "github.com/penguintop/penguin/pkg/retrieval/pb"
 	"github.com/penguintop/penguin/pkg/soc"
 	"github.com/penguintop/penguin/pkg/storage"
@@ -64,6 +66,9 @@ type Service struct {
 	metrics       metrics
 	pricer        pricer.Interface
 	tracer        *tracing.Tracer
+	reputation    reputation.Recorder
+	latencies     topology.LatencyReporter
+	candidates    int
 }
 
 func New(addr penguin.Address, storer storage.Storer, streamer p2p.Streamer, chunkPeerer topology.EachPeerer, logger logging.Logger, accounting accounting.Interface, pricer pricer.Interface, tracer *tracing.Tracer) *Service {
@@ -77,9 +82,24 @@ func New(addr penguin.Address, storer storage.Storer, streamer p2p.Streamer, chu
 		pricer:        pricer,
 		metrics:       newMetrics(),
 		tracer:        tracer,
+		reputation:    reputation.Noop,
 	}
 }
 
+// SetReputation sets the recorder of the peer interaction outcomes.
+func (s *Service) SetReputation(r reputation.Recorder) {
+	s.reputation = r
+}
+
+// SetPeerLatencies enables the latency aware selection of peers. The peer
+// with the lowest latency is selected among the given number of candidates
+// which are the closest to the chunk. Peers without the measured latency
+// are selected only if the latencies of no candidates are measured.
+func (s *Service) SetPeerLatencies(l topology.LatencyReporter, candidates int) {
+	s.latencies = l
+	s.candidates = candidates
+}
+
 func (s *Service) Protocol() p2p.ProtocolSpec {
 	return p2p.ProtocolSpec{
 		Name:    protocolName,
@@ -276,6 +296,14 @@ func (s *Service) retrieveChunk(ctx context.Context, addr penguin.Address, sp *s
 
 	sp.Add(peer)
 
+	defer func() {
+		if err != nil {
+			s.reputation.Failure(peer, reputation.ProtocolRetrieval)
+		} else {
+			s.reputation.Success(peer, reputation.ProtocolRetrieval, time.Since(startTimer))
+		}
+	}()
+
 	s.logger.Tracef("retrieval: requesting chunk %s from peer %s", addr, peer)
 
 	stream, err := s.streamer.NewStream(ctx, peer, nil, protocolName, protocolVersion, streamName)
@@ -339,6 +367,10 @@ func (s *Service) retrieveChunk(ctx context.Context, addr penguin.Address, sp *s
 // the chunk than this node is, could also be returned, allowing the upstream
 // retrieve request.
 func (s *Service) closestPeer(addr penguin.Address, skipPeers []penguin.Address, allowUpstream bool) (penguin.Address, error) {
+	if s.latencies != nil && s.candidates > 1 {
+		return s.lowestLatencyPeer(addr, skipPeers, allowUpstream)
+	}
+
 	closest := penguin.Address{}
 	err := s.peerSuggester.EachPeerRev(func(peer penguin.Address, po uint8) (bool, bool, error) {
 		for _, a := range skipPeers {
@@ -389,6 +421,75 @@ func (s *Service) closestPeer(addr penguin.Address, skipPeers []penguin.Address,
 	return closest, nil
 }
 
+// lowestLatencyPeer returns address of the peer that is closest to the chunk
+// with provided address addr, like closestPeer. The latency is only used to
+// break the ties between the closest candidate peers which are in the same
+// proximity order to the chunk, a closer peer is always preferred to a
+// faster one. The skipPeers and allowUpstream arguments are the same as in
+// closestPeer.
+func (s *Service) lowestLatencyPeer(addr penguin.Address, skipPeers []penguin.Address, allowUpstream bool) (penguin.Address, error) {
+	var (
+		candidates []penguin.Address
+		closestPO  uint8
+	)
+	err := s.peerSuggester.EachPeerRev(func(peer penguin.Address, _ uint8) (bool, bool, error) {
+		for _, a := range skipPeers {
+			if a.Equal(peer) {
+				return false, false, nil
+			}
+		}
+		if !allowUpstream {
+			dcmp, err := penguin.DistanceCmp(addr.Bytes(), peer.Bytes(), s.addr.Bytes())
+			if err != nil {
+				return false, false, fmt.Errorf("distance compare addr %s peer %s base address %s: %w", addr.String(), peer.String(), s.addr.String(), err)
+			}
+			if dcmp != 1 {
+				return false, false, nil
+			}
+		}
+		po := penguin.Proximity(addr.Bytes(), peer.Bytes())
+		switch {
+		case len(candidates) == 0 || po > closestPO:
+			candidates, closestPO = []penguin.Address{peer}, po
+		case po == closestPO:
+			candidates = append(candidates, peer)
+		}
+		return false, false, nil
+	})
+	if err != nil {
+		return penguin.Address{}, err
+	}
+
+	if len(candidates) == 0 {
+		return penguin.Address{}, topology.ErrNotFound
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		dcmp, _ := penguin.DistanceCmp(addr.Bytes(), candidates[i].Bytes(), candidates[j].Bytes())
+		return dcmp == 1
+	})
+	if len(candidates) > s.candidates {
+		candidates = candidates[:s.candidates]
+	}
+
+	selected := candidates[0]
+	var lowest time.Duration
+	for _, peer := range candidates {
+		latency, ok := s.latencies.PeerLatency(peer)
+		if !ok {
+			continue
+		}
+		if lowest == 0 || latency < lowest {
+			selected, lowest = peer, latency
+		}
+	}
+
+	if !selected.Equal(candidates[0]) {
+		s.metrics.LatencySelectedPeerCounter.Inc()
+	}
+	return selected, nil
+}
+
 func (s *Service) handler(ctx context.Context, p p2p.Peer, stream p2p.Stream) (err error) {
 	w, r := protobuf.NewWriterAndReader(stream)
 	defer func() {
@@ -409,6 +510,7 @@ func (s *Service) handler(ctx context.Context, p p2p.Peer, stream p2p.Stream) (e
 	ctx = context.WithValue(ctx, requestSourceContextKey{}, p.Address.String())
 	addr := penguin.NewAddress(req.Addr)
 	chunk, err := s.storer.Get(ctx, storage.ModeGetRequest, addr)
+	local := err == nil
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
 			// forward the request
@@ -441,5 +543,20 @@ func (s *Service) handler(ctx context.Context, p p2p.Peer, stream p2p.Stream) (e
 	s.logger.Tracef("retrieval protocol debiting peer %s", p.Address.String())
 
 	// debit price from p's balance
-	return debit.Apply()
+	if err := debit.Apply(); err != nil {
+		return err
+	}
+
+	if r, ok := s.storer.(revenueRecorder); ok && local {
+		if err := r.RecordRevenue(addr, chunkPrice); err != nil {
+			s.logger.Debugf("retrieval: record revenue of chunk %s: %v", addr, err)
+		}
+	}
+	return nil
+}
+
+// revenueRecorder is implemented by stores which take the revenue earned
+// from serving chunks into account.
+type revenueRecorder interface {
+	RecordRevenue(addr penguin.Address, amount uint64) error
 }
@@ -505,3 +505,102 @@ func (s mockPeerSuggester) EachPeer(topology.EachPeerFunc) error {
 func (s mockPeerSuggester) EachPeerRev(f topology.EachPeerFunc) error {
 	return s.eachPeerRevFunc(f)
 }
+
+type latencyReporter map[string]time.Duration
+
+func (l latencyReporter) PeerLatency(peer penguin.Address) (time.Duration, bool) {
+	latency, ok := l[peer.ByteString()]
+	return latency, ok
+}
+
+func TestClosestPeerLatency(t *testing.T) {
+	var (
+		chunk    = penguin.MustParseHexAddress("f000")
+		base     = penguin.MustParseHexAddress("0000")
+		p1       = penguin.MustParseHexAddress("f100") // proximity order 7
+		p2       = penguin.MustParseHexAddress("f180") // proximity order 7
+		p3       = penguin.MustParseHexAddress("f1c0") // proximity order 7
+		p4       = penguin.MustParseHexAddress("f300") // proximity order 6
+		upstream = penguin.MustParseHexAddress("0100")
+		logger   = logging.New(ioutil.Discard, 0)
+	)
+
+	suggester := func(peers ...penguin.Address) topology.EachPeerer {
+		return mockPeerSuggester{eachPeerRevFunc: func(f topology.EachPeerFunc) error {
+			for _, peer := range peers {
+				if stop, _, err := f(peer, 0); err != nil || stop {
+					return err
+				}
+			}
+			return nil
+		}}
+	}
+
+	latencies := latencyReporter{
+		p1.ByteString(): 100 * time.Millisecond,
+		p3.ByteString(): 20 * time.Millisecond,
+		p4.ByteString(): 5 * time.Millisecond,
+	}
+
+	for _, tc := range []struct {
+		name          string
+		peers         []penguin.Address
+		latencies     latencyReporter
+		skipPeers     []penguin.Address
+		allowUpstream bool
+		want          penguin.Address
+		wantErr       error
+	}{
+		{
+			name:      "lowest latency of candidates",
+			peers:     []penguin.Address{p4, upstream, p2, p1, p3},
+			latencies: latencies,
+			want:      p3,
+		},
+		{
+			name:      "skip peers",
+			peers:     []penguin.Address{p4, upstream, p2, p1, p3},
+			latencies: latencies,
+			skipPeers: []penguin.Address{p3},
+			want:      p1,
+		},
+		{
+			name:      "closer peer before lower latency",
+			peers:     []penguin.Address{p4, p2},
+			latencies: latencies,
+			want:      p2,
+		},
+		{
+			name:      "no measured latencies",
+			peers:     []penguin.Address{p4, p2, p1, p3},
+			latencies: latencyReporter{},
+			want:      p1,
+		},
+		{
+			name:      "upstream not allowed",
+			peers:     []penguin.Address{upstream},
+			latencies: latencies,
+			wantErr:   topology.ErrNotFound,
+		},
+		{
+			name:          "upstream allowed",
+			peers:         []penguin.Address{upstream},
+			latencies:     latencies,
+			allowUpstream: true,
+			want:          upstream,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := retrieval.New(base, nil, nil, suggester(tc.peers...), logger, accountingmock.NewAccounting(), pricermock.NewMockService(defaultPrice, defaultPrice), nil)
+			s.SetPeerLatencies(tc.latencies, 3)
+
+			got, err := s.ClosestPeer(chunk, tc.skipPeers, tc.allowUpstream)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if !got.Equal(tc.want) {
+				t.Fatalf("got peer %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"math/big"
 
+	"github.com/penguintop/penguin/pkg/storage"
     "github.com/penguintop/penguin/pkg/penguin"
 )
 
@@ -31,5 +32,9 @@ type Accounting interface {
 	PeerDebt(peer penguin.Address) (*big.Int, error)
 	NotifyPaymentReceived(peer penguin.Address, amount *big.Int) error
 	NotifyPaymentSent(peer penguin.Address, amount *big.Int, receivedError error)
+	// PersistPaymentSent returns the function which records the payment in
+	// the batch of the payment itself, before NotifyPaymentSent is called.
+	PersistPaymentSent(peer penguin.Address, amount *big.Int) func(b storage.StateBatch) error
 	NotifyRefreshmentReceived(peer penguin.Address, amount *big.Int) error
+	SetPaymentTolerance(peer penguin.Address, paymentTolerance *big.Int) error
 }
@@ -28,3 +28,7 @@ func (s *Service) Init(ctx context.Context, peer p2p.Peer) error {
 func (s *Service) Terminate(peer p2p.Peer) error {
 	return s.terminate(peer)
 }
+
+func (s *Service) ConnectOut(ctx context.Context, peer p2p.Peer) error {
+	return s.connectOut(ctx, peer)
+}
@@ -15,6 +15,7 @@ type metrics struct {
 	// using reflection
 	TotalReceivedPseudoSettlements prometheus.Counter
 	TotalSentPseudoSettlements     prometheus.Counter
+	TotalRefusedPseudoSettlements  prometheus.Counter
 }
 
 func newMetrics() metrics {
@@ -33,6 +34,12 @@ func newMetrics() metrics {
 			Name:      "total_sent_pseudosettlements",
 			Help:      "Amount of pseudotokens sent to peers (costs paid by the node)",
 		}),
+		TotalRefusedPseudoSettlements: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "total_refused_pseudosettlements",
+			Help:      "Amount of pseudotokens refused from peers exceeding their allowance",
+		}),
 	}
 }
 
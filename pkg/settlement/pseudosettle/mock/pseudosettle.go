@@ -0,0 +1,103 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mock
+
+import (
+	"math/big"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/settlement/pseudosettle"
+)
+
+type Service struct {
+	settlementsSent map[string]*big.Int
+	settlementsRecv map[string]*big.Int
+
+	settlementsSentFunc func() (map[string]*big.Int, error)
+	settlementsRecvFunc func() (map[string]*big.Int, error)
+
+	peerAllowancesFunc func() ([]pseudosettle.PeerAllowance, error)
+}
+
+// WithSettlementsSentFunc sets the mock SettlementsSent function
+func WithSettlementsSentFunc(f func() (map[string]*big.Int, error)) Option {
+	return optionFunc(func(s *Service) {
+		s.settlementsSentFunc = f
+	})
+}
+
+// WithSettlementsRecvFunc sets the mock SettlementsReceived function
+func WithSettlementsRecvFunc(f func() (map[string]*big.Int, error)) Option {
+	return optionFunc(func(s *Service) {
+		s.settlementsRecvFunc = f
+	})
+}
+
+// WithPeerAllowancesFunc sets the mock PeerAllowances function
+func WithPeerAllowancesFunc(f func() ([]pseudosettle.PeerAllowance, error)) Option {
+	return optionFunc(func(s *Service) {
+		s.peerAllowancesFunc = f
+	})
+}
+
+// New creates the mock pseudosettle implementation
+func New(opts ...Option) pseudosettle.Interface {
+	mock := new(Service)
+	mock.settlementsSent = make(map[string]*big.Int)
+	mock.settlementsRecv = make(map[string]*big.Int)
+	for _, o := range opts {
+		o.apply(mock)
+	}
+	return mock
+}
+
+// TotalSent is the mock TotalSent function of pseudosettle.
+func (s *Service) TotalSent(peer penguin.Address) (totalSent *big.Int, err error) {
+	if v, ok := s.settlementsSent[peer.String()]; ok {
+		return v, nil
+	}
+	return big.NewInt(0), nil
+}
+
+// TotalReceived is the mock TotalReceived function of pseudosettle.
+func (s *Service) TotalReceived(peer penguin.Address) (totalReceived *big.Int, err error) {
+	if v, ok := s.settlementsRecv[peer.String()]; ok {
+		return v, nil
+	}
+	return big.NewInt(0), nil
+}
+
+// SettlementsSent is the mock SettlementsSent function of pseudosettle.
+func (s *Service) SettlementsSent() (map[string]*big.Int, error) {
+	if s.settlementsSentFunc != nil {
+		return s.settlementsSentFunc()
+	}
+	return s.settlementsSent, nil
+}
+
+// SettlementsReceived is the mock SettlementsReceived function of pseudosettle.
+func (s *Service) SettlementsReceived() (map[string]*big.Int, error) {
+	if s.settlementsRecvFunc != nil {
+		return s.settlementsRecvFunc()
+	}
+	return s.settlementsRecv, nil
+}
+
+// PeerAllowances is the mock PeerAllowances function of pseudosettle.
+func (s *Service) PeerAllowances() ([]pseudosettle.PeerAllowance, error) {
+	if s.peerAllowancesFunc != nil {
+		return s.peerAllowancesFunc()
+	}
+	return nil, nil
+}
+
+// Option is the option passed to the mock pseudosettle service
+type Option interface {
+	apply(*Service)
+}
+
+type optionFunc func(*Service)
+
+func (f optionFunc) apply(r *Service) { f(r) }
@@ -16,6 +16,7 @@ import (
 	"github.com/penguintop/penguin/pkg/logging"
 	"github.com/penguintop/penguin/pkg/p2p"
 	"github.com/penguintop/penguin/pkg/p2p/protobuf"
+	"github.com/penguintop/penguin/pkg/reputation"
 	"github.com/penguintop/penguin/pkg/settlement"
 	pb "github.com/penguintop/penguin/pkg/settlement/pseudosettle/pb"
 	"github.com/penguintop/penguin/pkg/storage"
@@ -24,20 +25,47 @@ import (
 
 const (
 	protocolName    = "pseudosettle"
-	protocolVersion = "1.0.0"
+	protocolVersion = "1.1.0"
 	streamName      = "pseudosettle"
+	initStreamName  = "init" // stream for refresh rate negotiation
 )
 
 var (
 	SettlementReceivedPrefix = "pseudosettle_total_received_"
 	SettlementSentPrefix     = "pseudosettle_total_sent_"
+	RefreshRatePrefix        = "pseudosettle_refresh_rate_"
+
+	// lowReputationRefreshRateDivisor divides the refresh rate granted to
+	// peers with a reputation score below reputation.LowScore.
+	lowReputationRefreshRateDivisor = big.NewInt(4)
 
 	ErrSettlementTooSoon              = errors.New("settlement too soon")
 	ErrNoPseudoSettlePeer             = errors.New("settlement peer not found")
 	ErrDisconnectAllowanceCheckFailed = errors.New("settlement allowance below enforced amount")
 	ErrTimeOutOfSync                  = errors.New("settlement allowance timestamps differ beyond tolerance")
+	ErrInvalidRefreshRate             = errors.New("invalid refresh rate")
 )
 
+// Interface is the time based settlement service as seen by its users.
+type Interface interface {
+	settlement.Interface
+	// PeerAllowances returns the refresh allowance state of all known peers.
+	PeerAllowances() ([]PeerAllowance, error)
+}
+
+// PeerAllowance describes the time based allowance granted to a peer.
+type PeerAllowance struct {
+	Peer penguin.Address
+	// RefreshRate is the negotiated amount the peer may refresh per second.
+	RefreshRate *big.Int
+	// LastRefresh is the unix timestamp of the last refreshment received
+	// from the peer, zero if it never refreshed.
+	LastRefresh int64
+	// TotalRefused is the total amount of attempted refreshments that
+	// exceeded the allowance and were not accepted.
+	TotalRefused *big.Int
+}
+
 type Service struct {
 	streamer    p2p.Streamer
 	logger      logging.Logger
@@ -49,16 +77,33 @@ type Service struct {
 	timeNow     func() time.Time
 	peersMu     sync.Mutex
 	peers       map[string]*pseudoSettlePeer
+	reputation  reputation.Recorder
+	scorer      reputation.Scorer
 }
 
 type pseudoSettlePeer struct {
-	lock sync.Mutex // lock to be held during receiving a payment from this peer
+	lock        sync.Mutex // lock to be held during receiving a payment from this peer
+	refreshRate *big.Int   // negotiated refresh rate, guarded by Service.peersMu
 }
 
 type lastPayment struct {
 	Timestamp      int64
 	CheckTimestamp int64
 	Total          *big.Int
+	Refused        *big.Int
+}
+
+// peerRefreshRate is the stored refresh rate state of a peer.
+type peerRefreshRate struct {
+	// Limit caps the refresh rate offered to the peer below the node-wide
+	// rate, nil if the node-wide rate applies.
+	Limit *big.Int
+	// ReputationLimit caps the refresh rate offered to the peer while its
+	// reputation score is low, nil otherwise. It is kept apart from Limit
+	// so that a limit set explicitly outlives a recovered score.
+	ReputationLimit *big.Int
+	// Agreed is the refresh rate last negotiated with the peer.
+	Agreed *big.Int
 }
 
 func New(streamer p2p.Streamer, logger logging.Logger, store storage.StateStorer, accounting settlement.Accounting, refreshRate *big.Int, p2pService p2p.Service) *Service {
@@ -72,6 +117,7 @@ func New(streamer p2p.Streamer, logger logging.Logger, store storage.StateStorer
 		refreshRate: refreshRate,
 		timeNow:     time.Now,
 		peers:       make(map[string]*pseudoSettlePeer),
+		reputation:  reputation.Noop,
 	}
 }
 
@@ -84,27 +130,278 @@ func (s *Service) Protocol() p2p.ProtocolSpec {
 				Name:    streamName,
 				Handler: s.handler,
 			},
+			{
+				Name:    initStreamName,
+				Handler: s.initHandler,
+			},
 		},
 		ConnectIn:     s.init,
-		ConnectOut:    s.init,
+		ConnectOut:    s.connectOut,
 		DisconnectIn:  s.terminate,
 		DisconnectOut: s.terminate,
 	}
 }
 
+// init registers the peer with the refresh rate remembered from a previous
+// negotiation, or the rate we offer to it if there is none. The remembered
+// rate is capped by the rate we offer now.
 func (s *Service) init(ctx context.Context, p p2p.Peer) error {
+	if err := s.applyReputation(ctx, p.Address); err != nil {
+		return err
+	}
+
+	stored, err := s.storedRefreshRate(p.Address)
+	if err != nil {
+		return err
+	}
+
+	refreshRate := s.grantedRefreshRate(stored)
+
 	s.peersMu.Lock()
 	defer s.peersMu.Unlock()
 
 	_, ok := s.peers[p.Address.String()]
 	if !ok {
-		peerData := &pseudoSettlePeer{}
+		peerData := &pseudoSettlePeer{
+			refreshRate: refreshRate,
+		}
 		s.peers[p.Address.String()] = peerData
 	}
 
 	return nil
 }
 
+// connectOut is called on outgoing connections and negotiates the refresh
+// rate with the peer.
+func (s *Service) connectOut(ctx context.Context, p p2p.Peer) error {
+	if err := s.init(ctx, p); err != nil {
+		return err
+	}
+
+	if err := s.negotiate(ctx, p.Address); err != nil {
+		// peers running an older protocol version do not negotiate,
+		// the remembered or offered rate stays in place for them
+		s.logger.Debugf("pseudosettle: negotiate refresh rate with peer %v: %v", p.Address, err)
+	}
+
+	return nil
+}
+
+// negotiate sends the refresh rate we offer to the peer and agrees on the
+// lower of the two offered rates.
+func (s *Service) negotiate(ctx context.Context, peer penguin.Address) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	stored, err := s.storedRefreshRate(peer)
+	if err != nil {
+		return err
+	}
+	offered := s.offeredRefreshRate(stored)
+
+	stream, err := s.streamer.NewStream(ctx, peer, nil, protocolName, protocolVersion, initStreamName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = stream.Reset()
+		} else {
+			_ = stream.FullClose()
+		}
+	}()
+
+	w, r := protobuf.NewWriterAndReader(stream)
+	err = w.WriteMsgWithContext(ctx, &pb.Handshake{
+		RefreshRate: offered.Bytes(),
+	})
+	if err != nil {
+		return err
+	}
+
+	var resp pb.Handshake
+	if err = r.ReadMsgWithContext(ctx, &resp); err != nil {
+		return fmt.Errorf("read handshake from peer %v: %w", peer, err)
+	}
+
+	return s.agreeRefreshRate(peer, offered, new(big.Int).SetBytes(resp.RefreshRate))
+}
+
+func (s *Service) initHandler(ctx context.Context, p p2p.Peer, stream p2p.Stream) (err error) {
+	w, r := protobuf.NewWriterAndReader(stream)
+	defer func() {
+		if err != nil {
+			_ = stream.Reset()
+		} else {
+			_ = stream.FullClose()
+		}
+	}()
+
+	var req pb.Handshake
+	if err := r.ReadMsgWithContext(ctx, &req); err != nil {
+		return fmt.Errorf("read handshake from peer %v: %w", p.Address, err)
+	}
+
+	stored, err := s.storedRefreshRate(p.Address)
+	if err != nil {
+		return err
+	}
+	offered := s.offeredRefreshRate(stored)
+
+	err = w.WriteMsgWithContext(ctx, &pb.Handshake{
+		RefreshRate: offered.Bytes(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.agreeRefreshRate(p.Address, offered, new(big.Int).SetBytes(req.RefreshRate))
+}
+
+// agreeRefreshRate settles on the lower of the two offered refresh rates
+// and remembers it for the peer.
+func (s *Service) agreeRefreshRate(peer penguin.Address, offered, peerOffered *big.Int) error {
+	agreed := new(big.Int).Set(offered)
+	if peerOffered.Cmp(agreed) < 0 {
+		agreed.Set(peerOffered)
+	}
+
+	stored, err := s.storedRefreshRate(peer)
+	if err != nil {
+		return err
+	}
+	stored.Agreed = agreed
+
+	err = s.store.Put(refreshRateKey(peer), stored)
+	if err != nil {
+		return err
+	}
+
+	s.peersMu.Lock()
+	if peerData, ok := s.peers[peer.String()]; ok {
+		peerData.refreshRate = agreed
+	}
+	s.peersMu.Unlock()
+
+	s.logger.Tracef("pseudosettle agreed refresh rate %d with peer %v", agreed, peer)
+	return nil
+}
+
+// storedRefreshRate returns the stored refresh rate state of a peer.
+func (s *Service) storedRefreshRate(peer penguin.Address) (stored peerRefreshRate, err error) {
+	err = s.store.Get(refreshRateKey(peer), &stored)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return peerRefreshRate{}, err
+	}
+	return stored, nil
+}
+
+// offeredRefreshRate returns the refresh rate we are willing to grant a peer.
+func (s *Service) offeredRefreshRate(stored peerRefreshRate) *big.Int {
+	offered := s.refreshRate
+	for _, limit := range []*big.Int{stored.Limit, stored.ReputationLimit} {
+		if limit != nil && limit.Cmp(offered) < 0 {
+			offered = limit
+		}
+	}
+	return offered
+}
+
+// grantedRefreshRate returns the refresh rate last agreed with a peer, capped
+// by the rate we offer now, or the offered rate if none was agreed.
+func (s *Service) grantedRefreshRate(stored peerRefreshRate) *big.Int {
+	offered := s.offeredRefreshRate(stored)
+	if stored.Agreed != nil && stored.Agreed.Cmp(offered) < 0 {
+		return stored.Agreed
+	}
+	return offered
+}
+
+// peerRefreshRate returns the refresh rate agreed with a peer. For peers
+// that are not connected the remembered or offered rate is returned.
+func (s *Service) peerRefreshRate(peer penguin.Address) (*big.Int, error) {
+	s.peersMu.Lock()
+	peerData, ok := s.peers[peer.String()]
+	s.peersMu.Unlock()
+	if ok {
+		return peerData.refreshRate, nil
+	}
+
+	stored, err := s.storedRefreshRate(peer)
+	if err != nil {
+		return nil, err
+	}
+	return s.grantedRefreshRate(stored), nil
+}
+
+// SetPeerRefreshRate limits the refresh rate granted to a peer, for example
+// based on its reputation. The limit is remembered and the rate is
+// renegotiated with the peer if it is connected. A nil rate removes the
+// limit so that the node-wide refresh rate applies again.
+func (s *Service) SetPeerRefreshRate(ctx context.Context, peer penguin.Address, rate *big.Int) error {
+	if rate != nil && rate.Sign() < 0 {
+		return ErrInvalidRefreshRate
+	}
+
+	stored, err := s.storedRefreshRate(peer)
+	if err != nil {
+		return err
+	}
+	stored.Limit = rate
+
+	err = s.store.Put(refreshRateKey(peer), stored)
+	if err != nil {
+		return err
+	}
+
+	return s.renegotiate(ctx, peer)
+}
+
+// renegotiate negotiates the refresh rate with the peer if it is connected.
+// Otherwise the rate is negotiated on the next connection.
+func (s *Service) renegotiate(ctx context.Context, peer penguin.Address) error {
+	s.peersMu.Lock()
+	_, connected := s.peers[peer.String()]
+	s.peersMu.Unlock()
+	if !connected {
+		return nil
+	}
+
+	return s.negotiate(ctx, peer)
+}
+
+// applyReputation limits the refresh rate granted to a peer with a low
+// reputation score and lifts the limit once the score recovered. The rate is
+// only renegotiated if the limit changes. A limit set with SetPeerRefreshRate
+// is left in place.
+func (s *Service) applyReputation(ctx context.Context, peer penguin.Address) error {
+	if s.scorer == nil {
+		return nil
+	}
+
+	var limit *big.Int
+	if s.scorer.Score(peer) < reputation.LowScore {
+		limit = new(big.Int).Div(s.refreshRate, lowReputationRefreshRateDivisor)
+	}
+
+	stored, err := s.storedRefreshRate(peer)
+	if err != nil {
+		return err
+	}
+	if (limit == nil && stored.ReputationLimit == nil) || (limit != nil && stored.ReputationLimit != nil && limit.Cmp(stored.ReputationLimit) == 0) {
+		return nil
+	}
+	stored.ReputationLimit = limit
+
+	err = s.store.Put(refreshRateKey(peer), stored)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Debugf("pseudosettle: peer %v reputation changed, limiting refresh rate to %v", peer, limit)
+	return s.renegotiate(ctx, peer)
+}
+
 func (s *Service) terminate(p p2p.Peer) error {
 	s.peersMu.Lock()
 	defer s.peersMu.Unlock()
@@ -117,6 +414,10 @@ func totalKey(peer penguin.Address, prefix string) string {
 	return fmt.Sprintf("%v%v", prefix, peer.String())
 }
 
+func refreshRateKey(peer penguin.Address) string {
+	return fmt.Sprintf("%v%v", RefreshRatePrefix, peer.String())
+}
+
 func totalKeyPeer(key []byte, prefix string) (peer penguin.Address, err error) {
 	k := string(key)
 
@@ -144,7 +445,12 @@ func (s *Service) peerAllowance(peer penguin.Address) (limit *big.Int, stamp int
 		return nil, 0, ErrSettlementTooSoon
 	}
 
-	maxAllowance := new(big.Int).Mul(big.NewInt(currentTime-lastTime.Timestamp), s.refreshRate)
+	refreshRate, err := s.peerRefreshRate(peer)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	maxAllowance := new(big.Int).Mul(big.NewInt(currentTime-lastTime.Timestamp), refreshRate)
 
 	peerDebt, err := s.accounting.PeerDebt(peer)
 	if err != nil {
@@ -184,6 +490,18 @@ func (s *Service) handler(ctx context.Context, p p2p.Peer, stream p2p.Stream) (e
 	}
 	s.peersMu.Unlock()
 
+	// the reputation of the peer may have changed since the refresh rate
+	// was negotiated on connect, the rate is renegotiated after the peer
+	// lock is released as negotiation waits for the peer
+	defer func() {
+		if err != nil {
+			return
+		}
+		if err := s.applyReputation(ctx, p.Address); err != nil {
+			s.logger.Debugf("pseudosettle: apply reputation of peer %v: %v", p.Address, err)
+		}
+	}()
+
 	pseudoSettlePeer.lock.Lock()
 	defer pseudoSettlePeer.lock.Unlock()
 
@@ -219,6 +537,16 @@ func (s *Service) handler(ctx context.Context, p p2p.Peer, stream p2p.Stream) (e
 		}
 		lastTime.Total = big.NewInt(0)
 	}
+	if lastTime.Refused == nil {
+		lastTime.Refused = big.NewInt(0)
+	}
+
+	refusedAmount := new(big.Int).Sub(attemptedAmount, paymentAmount)
+	if refusedAmount.Sign() > 0 {
+		lastTime.Refused = lastTime.Refused.Add(lastTime.Refused, refusedAmount)
+		refusedAmountF64, _ := big.NewFloat(0).SetInt(refusedAmount).Float64()
+		s.metrics.TotalRefusedPseudoSettlements.Add(refusedAmountF64)
+	}
 
 	lastTime.Total = lastTime.Total.Add(lastTime.Total, paymentAmount)
 	lastTime.Timestamp = timestamp
@@ -234,11 +562,23 @@ func (s *Service) handler(ctx context.Context, p p2p.Peer, stream p2p.Stream) (e
 }
 
 // Pay initiates a payment to the given peer
-func (s *Service) Pay(ctx context.Context, peer penguin.Address, amount *big.Int, checkAllowance *big.Int) (*big.Int, int64, error) {
+func (s *Service) Pay(ctx context.Context, peer penguin.Address, amount *big.Int, checkAllowance *big.Int) (accepted *big.Int, timestamp int64, err error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var err error
+	// underpaid is set when the peer accepts less than the allowance
+	var underpaid bool
+	start := time.Now()
+	defer func() {
+		if errors.Is(err, ErrSettlementTooSoon) {
+			return
+		}
+		if err != nil || underpaid {
+			s.reputation.Failure(peer, reputation.ProtocolSettlement)
+		} else {
+			s.reputation.Success(peer, reputation.ProtocolSettlement, time.Since(start))
+		}
+	}()
 
 	var lastTime lastPayment
 	err = s.store.Get(totalKey(peer, SettlementSentPrefix), &lastTime)
@@ -313,16 +653,22 @@ func (s *Service) Pay(ctx context.Context, peer penguin.Address, amount *big.Int
 		return nil, 0, ErrTimeOutOfSync
 	}
 
+	refreshRate, err := s.peerRefreshRate(peer)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	// enforce allowance
 	// check if value is appropriate
-	expectedAllowance := new(big.Int).Mul(big.NewInt(allegedInterval), s.refreshRate)
+	expectedAllowance := new(big.Int).Mul(big.NewInt(allegedInterval), refreshRate)
 	if expectedAllowance.Cmp(checkAllowance) > 0 {
 		expectedAllowance = new(big.Int).Set(checkAllowance)
 	}
 
 	if expectedAllowance.Cmp(acceptedAmount) > 0 {
+		underpaid = true
 		// disconnect peer
-		err = s.p2pService.Blocklist(peer, 1*time.Hour)
+		err = s.p2pService.Blocklist(peer, 1*time.Hour, "pseudosettle: payment accepted below allowance")
 		if err != nil {
 			return nil, 0, err
 		}
@@ -347,6 +693,17 @@ func (s *Service) SetAccounting(accounting settlement.Accounting) {
 	s.accounting = accounting
 }
 
+// SetReputation sets the recorder of the peer interaction outcomes.
+func (s *Service) SetReputation(r reputation.Recorder) {
+	s.reputation = r
+}
+
+// SetScorer sets the peer scores by which the refresh rate granted to
+// peers is limited.
+func (s *Service) SetScorer(scorer reputation.Scorer) {
+	s.scorer = scorer
+}
+
 // TotalSent returns the total amount sent to a peer
 func (s *Service) TotalSent(peer penguin.Address) (totalSent *big.Int, err error) {
 	var lastTime lastPayment
@@ -426,3 +783,65 @@ func (s *Service) SettlementsReceived() (map[string]*big.Int, error) {
 	}
 	return received, nil
 }
+
+// PeerAllowances returns the refresh allowance state of all connected peers
+// and all peers that refreshed with us in the past.
+func (s *Service) PeerAllowances() ([]PeerAllowance, error) {
+	allowances := make(map[string]PeerAllowance)
+
+	s.peersMu.Lock()
+	for k, peerData := range s.peers {
+		peer, err := penguin.ParseHexAddress(k)
+		if err != nil {
+			s.peersMu.Unlock()
+			return nil, fmt.Errorf("parse peer address %s: %w", k, err)
+		}
+		allowances[k] = PeerAllowance{
+			Peer:         peer,
+			RefreshRate:  peerData.refreshRate,
+			TotalRefused: big.NewInt(0),
+		}
+	}
+	s.peersMu.Unlock()
+
+	err := s.store.Iterate(SettlementReceivedPrefix, func(key, val []byte) (stop bool, err error) {
+		addr, err := totalKeyPeer(key, SettlementReceivedPrefix)
+		if err != nil {
+			return false, fmt.Errorf("parse address from key: %s: %w", string(key), err)
+		}
+
+		var storevalue lastPayment
+		err = s.store.Get(totalKey(addr, SettlementReceivedPrefix), &storevalue)
+		if err != nil {
+			return false, fmt.Errorf("get peer %s settlement balance: %w", addr.String(), err)
+		}
+
+		allowance, ok := allowances[addr.String()]
+		if !ok {
+			stored, err := s.storedRefreshRate(addr)
+			if err != nil {
+				return false, fmt.Errorf("get peer %s refresh rate: %w", addr.String(), err)
+			}
+			allowance = PeerAllowance{
+				Peer:        addr,
+				RefreshRate: s.grantedRefreshRate(stored),
+			}
+		}
+		allowance.LastRefresh = storevalue.Timestamp
+		allowance.TotalRefused = storevalue.Refused
+		if allowance.TotalRefused == nil {
+			allowance.TotalRefused = big.NewInt(0)
+		}
+		allowances[addr.String()] = allowance
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]PeerAllowance, 0, len(allowances))
+	for _, allowance := range allowances {
+		result = append(result, allowance)
+	}
+	return result, nil
+}
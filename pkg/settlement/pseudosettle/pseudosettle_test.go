@@ -10,6 +10,7 @@ import (
 	"errors"
 	"io/ioutil"
 	"math/big"
+	"sync"
 	"testing"
 	"time"
 
@@ -18,9 +19,11 @@ import (
 	mockp2p "github.com/penguintop/penguin/pkg/p2p/mock"
 	"github.com/penguintop/penguin/pkg/p2p/protobuf"
 	"github.com/penguintop/penguin/pkg/p2p/streamtest"
+	"github.com/penguintop/penguin/pkg/reputation"
 	"github.com/penguintop/penguin/pkg/settlement/pseudosettle"
 	"github.com/penguintop/penguin/pkg/settlement/pseudosettle/pb"
 	"github.com/penguintop/penguin/pkg/statestore/mock"
+	"github.com/penguintop/penguin/pkg/storage"
     "github.com/penguintop/penguin/pkg/penguin"
 )
 
@@ -73,6 +76,16 @@ func (t *testObserver) NotifyPaymentReceived(peer penguin.Address, amount *big.I
 	return nil
 }
 
+func (t *testObserver) SetPaymentTolerance(peer penguin.Address, paymentTolerance *big.Int) error {
+	return nil
+}
+
+func (t *testObserver) PersistPaymentSent(peer penguin.Address, amount *big.Int) func(b storage.StateBatch) error {
+	return func(b storage.StateBatch) error {
+		return nil
+	}
+}
+
 func (t *testObserver) NotifyPaymentSent(peer penguin.Address, amount *big.Int, err error) {
 	t.sentCalled <- notifyPaymentSentCall{
 		peer:   peer,
@@ -132,7 +145,7 @@ func TestPayment(t *testing.T) {
 		t.Fatalf("full amount not accepted. wanted %d, got %d", amount, acceptedAmount)
 	}
 
-	records, err := recorder.Records(peerID, "pseudosettle", "1.0.0", "pseudosettle")
+	records, err := recorder.Records(peerID, "pseudosettle", "1.1.0", "pseudosettle")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -255,7 +268,7 @@ func TestTimeLimitedPayment(t *testing.T) {
 		t.Fatalf("full amount not accepted. wanted %d, got %d", amount, acceptedAmount)
 	}
 
-	records, err := recorder.Records(peerID, "pseudosettle", "1.0.0", "pseudosettle")
+	records, err := recorder.Records(peerID, "pseudosettle", "1.1.0", "pseudosettle")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -355,7 +368,7 @@ func TestTimeLimitedPayment(t *testing.T) {
 
 	sentSum = sentSum.Add(sentSum, amount)
 
-	records, err = recorder.Records(peerID, "pseudosettle", "1.0.0", "pseudosettle")
+	records, err = recorder.Records(peerID, "pseudosettle", "1.1.0", "pseudosettle")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -453,7 +466,7 @@ func TestTimeLimitedPayment(t *testing.T) {
 
 	sentSum = sentSum.Add(sentSum, testRefreshRateBigInt)
 
-	records, err = recorder.Records(peerID, "pseudosettle", "1.0.0", "pseudosettle")
+	records, err = recorder.Records(peerID, "pseudosettle", "1.1.0", "pseudosettle")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -542,7 +555,7 @@ func TestTimeLimitedPayment(t *testing.T) {
 		t.Fatal("sent settlement too soon")
 	}
 
-	records, err = recorder.Records(peerID, "pseudosettle", "1.0.0", "pseudosettle")
+	records, err = recorder.Records(peerID, "pseudosettle", "1.1.0", "pseudosettle")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -574,7 +587,7 @@ func TestTimeLimitedPayment(t *testing.T) {
 		t.Fatal("expected error")
 	}
 
-	records, err = recorder.Records(peerID, "pseudosettle", "1.0.0", "pseudosettle")
+	records, err = recorder.Records(peerID, "pseudosettle", "1.1.0", "pseudosettle")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -614,7 +627,7 @@ func TestTimeLimitedPayment(t *testing.T) {
 
 	sentSum = sentSum.Add(sentSum, big.NewInt(6*testRefreshRate))
 
-	records, err = recorder.Records(peerID, "pseudosettle", "1.0.0", "pseudosettle")
+	records, err = recorder.Records(peerID, "pseudosettle", "1.1.0", "pseudosettle")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -714,7 +727,7 @@ func TestTimeLimitedPayment(t *testing.T) {
 
 	sentSum = sentSum.Add(sentSum, big.NewInt(5*testRefreshRate))
 
-	records, err = recorder.Records(peerID, "pseudosettle", "1.0.0", "pseudosettle")
+	records, err = recorder.Records(peerID, "pseudosettle", "1.1.0", "pseudosettle")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -778,3 +791,391 @@ func TestTimeLimitedPayment(t *testing.T) {
 		t.Fatalf("stored wrong totalReceived. got %d, want %d", totalReceived, sentSum)
 	}
 }
+
+func TestRefreshRateNegotiation(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+
+	storeRecipient := mock.NewStateStore()
+	defer storeRecipient.Close()
+
+	peerID := penguin.MustParseHexAddress("9ee7add7")
+	peer := p2p.Peer{Address: peerID}
+
+	debt := int64(1000000)
+	recipientRefreshRate := int64(100)
+
+	observer := newTestObserver(map[string]*big.Int{peerID.String(): big.NewInt(debt)}, map[string]*big.Int{})
+	recipient := pseudosettle.New(nil, logger, storeRecipient, observer, big.NewInt(recipientRefreshRate), mockp2p.New())
+	recipient.SetAccounting(observer)
+	err := recipient.Init(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := streamtest.New(
+		streamtest.WithProtocols(recipient.Protocol()),
+		streamtest.WithBaseAddr(peerID),
+	)
+
+	storePayer := mock.NewStateStore()
+	defer storePayer.Close()
+
+	observer2 := newTestObserver(map[string]*big.Int{}, map[string]*big.Int{peerID.String(): big.NewInt(debt)})
+	payer := pseudosettle.New(recorder, logger, storePayer, observer2, big.NewInt(testRefreshRate), mockp2p.New())
+	payer.SetAccounting(observer2)
+
+	err = payer.ConnectOut(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := recorder.Records(peerID, "pseudosettle", "1.1.0", "init")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(records); l != 1 {
+		t.Fatalf("got %v records, want %v", l, 1)
+	}
+
+	checkRefreshRate(t, payer, peerID, recipientRefreshRate)
+	checkRefreshRate(t, recipient, peerID, recipientRefreshRate)
+
+	payer.SetTime(int64(10000))
+	recipient.SetTime(int64(10000))
+
+	amount := big.NewInt(testRefreshRate)
+
+	acceptedAmount, _, err := payer.Pay(context.Background(), peerID, amount, amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if acceptedAmount.Cmp(amount) != 0 {
+		t.Fatalf("full amount not accepted. wanted %d, got %d", amount, acceptedAmount)
+	}
+
+	<-observer.receivedCalled
+
+	payer.SetTime(int64(10001))
+	recipient.SetTime(int64(10001))
+
+	acceptedAmount, _, err = payer.Pay(context.Background(), peerID, amount, amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if acceptedAmount.Cmp(big.NewInt(recipientRefreshRate)) != 0 {
+		t.Fatalf("wrong amount accepted. wanted %d, got %d", recipientRefreshRate, acceptedAmount)
+	}
+
+	<-observer.receivedCalled
+
+	allowances, err := recipient.PeerAllowances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(allowances) != 1 {
+		t.Fatalf("got %v allowances, want %v", len(allowances), 1)
+	}
+
+	if allowances[0].LastRefresh != 10001 {
+		t.Fatalf("got last refresh %v, want %v", allowances[0].LastRefresh, 10001)
+	}
+
+	wantRefused := big.NewInt(testRefreshRate - recipientRefreshRate)
+	if allowances[0].TotalRefused.Cmp(wantRefused) != 0 {
+		t.Fatalf("got refused amount %v, want %v", allowances[0].TotalRefused, wantRefused)
+	}
+
+	limitedRefreshRate := int64(50)
+	err = payer.SetPeerRefreshRate(context.Background(), peerID, big.NewInt(limitedRefreshRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkRefreshRate(t, payer, peerID, limitedRefreshRate)
+	checkRefreshRate(t, recipient, peerID, limitedRefreshRate)
+
+	err = payer.SetPeerRefreshRate(context.Background(), peerID, big.NewInt(-1))
+	if !errors.Is(err, pseudosettle.ErrInvalidRefreshRate) {
+		t.Fatalf("got error %v, want %v", err, pseudosettle.ErrInvalidRefreshRate)
+	}
+}
+
+type scorerMock struct {
+	mu    sync.Mutex
+	score float64
+}
+
+func (s *scorerMock) Score(penguin.Address) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.score
+}
+
+func (s *scorerMock) setScore(score float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.score = score
+}
+
+func TestRefreshRateReputation(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+
+	storeRecipient := mock.NewStateStore()
+	defer storeRecipient.Close()
+
+	peerID := penguin.MustParseHexAddress("9ee7add7")
+	peer := p2p.Peer{Address: peerID}
+
+	debt := int64(1000000)
+
+	observer := newTestObserver(map[string]*big.Int{peerID.String(): big.NewInt(debt)}, map[string]*big.Int{})
+	recipient := pseudosettle.New(nil, logger, storeRecipient, observer, big.NewInt(testRefreshRate), mockp2p.New())
+	recipient.SetAccounting(observer)
+	err := recipient.Init(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := streamtest.New(
+		streamtest.WithProtocols(recipient.Protocol()),
+		streamtest.WithBaseAddr(peerID),
+	)
+
+	storePayer := mock.NewStateStore()
+	defer storePayer.Close()
+
+	observer2 := newTestObserver(map[string]*big.Int{}, map[string]*big.Int{peerID.String(): big.NewInt(debt)})
+	payer := pseudosettle.New(recorder, logger, storePayer, observer2, big.NewInt(testRefreshRate), mockp2p.New())
+	payer.SetAccounting(observer2)
+
+	scorer := &scorerMock{score: reputation.LowScore / 2}
+	payer.SetScorer(scorer)
+
+	// a peer with a low score is granted a limited refresh rate
+	err = payer.ConnectOut(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limitedRefreshRate := testRefreshRate / 4
+	checkRefreshRate(t, payer, peerID, limitedRefreshRate)
+	checkRefreshRate(t, recipient, peerID, limitedRefreshRate)
+
+	// the limit is lifted once the score recovered
+	scorer.setScore(reputation.NeutralScore)
+
+	err = payer.Terminate(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = payer.ConnectOut(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkRefreshRate(t, payer, peerID, testRefreshRate)
+	checkRefreshRate(t, recipient, peerID, testRefreshRate)
+}
+
+func TestRefreshRateReputationKeepsLimit(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+
+	storeRecipient := mock.NewStateStore()
+	defer storeRecipient.Close()
+
+	peerID := penguin.MustParseHexAddress("9ee7add7")
+	peer := p2p.Peer{Address: peerID}
+
+	observer := newTestObserver(map[string]*big.Int{}, map[string]*big.Int{})
+	recipient := pseudosettle.New(nil, logger, storeRecipient, observer, big.NewInt(testRefreshRate), mockp2p.New())
+	err := recipient.Init(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := streamtest.New(
+		streamtest.WithProtocols(recipient.Protocol()),
+		streamtest.WithBaseAddr(peerID),
+	)
+
+	storePayer := mock.NewStateStore()
+	defer storePayer.Close()
+
+	payer := pseudosettle.New(recorder, logger, storePayer, observer, big.NewInt(testRefreshRate), mockp2p.New())
+	scorer := &scorerMock{score: reputation.LowScore / 2}
+	payer.SetScorer(scorer)
+
+	limitedRefreshRate := int64(testRefreshRate / 2)
+	err = payer.SetPeerRefreshRate(context.Background(), peerID, big.NewInt(limitedRefreshRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the lower of both limits applies while the score is low
+	err = payer.ConnectOut(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRefreshRate(t, payer, peerID, testRefreshRate/4)
+
+	// the explicit limit stays in place once the score recovered
+	scorer.setScore(reputation.NeutralScore)
+
+	err = payer.Terminate(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = payer.ConnectOut(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRefreshRate(t, payer, peerID, limitedRefreshRate)
+	checkRefreshRate(t, recipient, peerID, limitedRefreshRate)
+}
+
+func TestRefreshRateStoredAboveLimit(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+
+	storeRecipient := mock.NewStateStore()
+	defer storeRecipient.Close()
+
+	peerID := penguin.MustParseHexAddress("9ee7add7")
+	peer := p2p.Peer{Address: peerID}
+
+	observer := newTestObserver(map[string]*big.Int{}, map[string]*big.Int{})
+	recipient := pseudosettle.New(nil, logger, storeRecipient, observer, big.NewInt(testRefreshRate), mockp2p.New())
+	err := recipient.Init(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := streamtest.New(
+		streamtest.WithProtocols(recipient.Protocol()),
+		streamtest.WithBaseAddr(peerID),
+	)
+
+	storePayer := mock.NewStateStore()
+	defer storePayer.Close()
+
+	payer := pseudosettle.New(recorder, logger, storePayer, observer, big.NewInt(testRefreshRate), mockp2p.New())
+	err = payer.ConnectOut(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRefreshRate(t, payer, peerID, testRefreshRate)
+
+	err = payer.Terminate(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer is limited while it is not connected, so the
+	// remembered agreed rate is above the limit
+	limitedRefreshRate := int64(testRefreshRate / 2)
+	err = payer.SetPeerRefreshRate(context.Background(), peerID, big.NewInt(limitedRefreshRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = payer.Init(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRefreshRate(t, payer, peerID, limitedRefreshRate)
+}
+
+func TestRefreshRateReputationOnRefreshment(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+
+	peerID := penguin.MustParseHexAddress("9ee7add7")
+	peer := p2p.Peer{Address: peerID}
+
+	debt := int64(1000000)
+
+	// the recipient renegotiates with the payer on its own streams
+	storePayerInit := mock.NewStateStore()
+	defer storePayerInit.Close()
+	payerInit := pseudosettle.New(nil, logger, storePayerInit, newTestObserver(map[string]*big.Int{}, map[string]*big.Int{}), big.NewInt(testRefreshRate), mockp2p.New())
+	recipientRecorder := streamtest.New(
+		streamtest.WithProtocols(payerInit.Protocol()),
+	)
+
+	storeRecipient := mock.NewStateStore()
+	defer storeRecipient.Close()
+
+	observer := newTestObserver(map[string]*big.Int{peerID.String(): big.NewInt(debt)}, map[string]*big.Int{})
+	recipient := pseudosettle.New(recipientRecorder, logger, storeRecipient, observer, big.NewInt(testRefreshRate), mockp2p.New())
+	recipient.SetAccounting(observer)
+	scorer := &scorerMock{score: reputation.NeutralScore}
+	recipient.SetScorer(scorer)
+	err := recipient.Init(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRefreshRate(t, recipient, peerID, testRefreshRate)
+
+	recorder := streamtest.New(
+		streamtest.WithProtocols(recipient.Protocol()),
+		streamtest.WithBaseAddr(peerID),
+	)
+
+	storePayer := mock.NewStateStore()
+	defer storePayer.Close()
+
+	observer2 := newTestObserver(map[string]*big.Int{}, map[string]*big.Int{peerID.String(): big.NewInt(debt)})
+	payer := pseudosettle.New(recorder, logger, storePayer, observer2, big.NewInt(testRefreshRate), mockp2p.New())
+	payer.SetAccounting(observer2)
+
+	payer.SetTime(int64(10000))
+	recipient.SetTime(int64(10000))
+
+	// the score of the peer drops while it is connected
+	scorer.setScore(reputation.LowScore / 2)
+
+	amount := big.NewInt(testRefreshRate)
+	_, _, err = payer.Pay(context.Background(), peerID, amount, amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-observer.receivedCalled
+
+	// the rate is renegotiated after the refreshment was accounted
+	limitedRefreshRate := big.NewInt(testRefreshRate / 4)
+	for i := 0; ; i++ {
+		allowances, err := recipient.PeerAllowances()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(allowances) == 1 && allowances[0].RefreshRate.Cmp(limitedRefreshRate) == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("refresh rate not limited to %v", limitedRefreshRate)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func checkRefreshRate(t *testing.T, s *pseudosettle.Service, peer penguin.Address, want int64) {
+	t.Helper()
+
+	allowances, err := s.PeerAllowances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, allowance := range allowances {
+		if allowance.Peer.Equal(peer) {
+			if allowance.RefreshRate.Cmp(big.NewInt(want)) != 0 {
+				t.Fatalf("got refresh rate %v, want %v", allowance.RefreshRate, want)
+			}
+			return
+		}
+	}
+
+	t.Fatalf("peer %v has no allowance", peer)
+}
@@ -17,9 +17,10 @@ var (
 	peerChequebookPrefix  = "swap_peer_chequebook_"
 	beneficiaryPeerPrefix = "swap_beneficiary_peer_"
 	peerBeneficiaryPrefix = "swap_peer_beneficiary_"
+	peerTokenPrefix       = "swap_peer_token_"
 )
 
-// Addressbook maps peers to beneficaries, chequebooks and in reverse.
+// Addressbook maps peers to beneficaries, chequebooks, settlement tokens and in reverse.
 type Addressbook interface {
 	// Beneficiary returns the beneficiary for the given peer.
 	Beneficiary(peer penguin.Address) (beneficiary common.Address, known bool, err error)
@@ -33,6 +34,10 @@ type Addressbook interface {
 	PutBeneficiary(peer penguin.Address, beneficiary common.Address) error
 	// PutChequebook stores the chequebook for the given peer.
 	PutChequebook(peer penguin.Address, chequebook common.Address) error
+	// Token returns the token we settle in with the given peer.
+	Token(peer penguin.Address) (token common.Address, known bool, err error)
+	// PutToken stores the token we settle in with the given peer.
+	PutToken(peer penguin.Address, token common.Address) error
 }
 
 type addressbook struct {
@@ -112,6 +117,23 @@ func (a *addressbook) PutChequebook(peer penguin.Address, chequebook common.Addr
 	return a.store.Put(chequebookPeerKey(chequebook), peer)
 }
 
+// Token returns the token we settle in with the given peer.
+func (a *addressbook) Token(peer penguin.Address) (token common.Address, known bool, err error) {
+	err = a.store.Get(peerTokenKey(peer), &token)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			return common.Address{}, false, err
+		}
+		return common.Address{}, false, nil
+	}
+	return token, true, nil
+}
+
+// PutToken stores the token we settle in with the given peer.
+func (a *addressbook) PutToken(peer penguin.Address, token common.Address) error {
+	return a.store.Put(peerTokenKey(peer), token)
+}
+
 // peerKey computes the key where to store the chequebook from a peer.
 func peerKey(peer penguin.Address) string {
 	return fmt.Sprintf("%s%s", peerPrefix, peer)
@@ -131,3 +153,8 @@ func peerBeneficiaryKey(peer penguin.Address) string {
 func beneficiaryPeerKey(peer common.Address) string {
 	return fmt.Sprintf("%s%s", beneficiaryPeerPrefix, peer)
 }
+
+// peerTokenKey computes the key where to store the settlement token for a peer.
+func peerTokenKey(peer penguin.Address) string {
+	return fmt.Sprintf("%s%s", peerTokenPrefix, peer)
+}
@@ -315,7 +315,10 @@ func (s *cashoutService) CashoutStatus(ctx context.Context, chequebookAddress co
 // parseCashChequeBeneficiaryReceipt processes the receipt from a CashChequeBeneficiary transaction
 func (s *cashoutService) parseCashChequeBeneficiaryReceipt(chequebookAddress common.Address, receipt *xwctypes.RpcTransactionReceipt) (*CashChequeResult, error) {
 	result := &CashChequeResult{
-		Bounced: false,
+		TotalPayout:      new(big.Int),
+		CumulativePayout: new(big.Int),
+		CallerPayout:     new(big.Int),
+		Bounced:          false,
 	}
 
 	//err := transaction.FindSingleEvent(&chequebookABI, receipt, chequebookAddress, chequeCashedEventType, &cashedEvent)
@@ -6,26 +6,24 @@ package chequebook_test
 
 import (
 	"context"
-	"github.com/penguintop/penguin/pkg/xwctypes"
+	"encoding/hex"
+	"encoding/json"
 	"math/big"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook"
 	chequestoremock "github.com/penguintop/penguin/pkg/settlement/swap/chequestore/mock"
 	storemock "github.com/penguintop/penguin/pkg/statestore/mock"
 	"github.com/penguintop/penguin/pkg/transaction"
 	"github.com/penguintop/penguin/pkg/transaction/backendmock"
 	transactionmock "github.com/penguintop/penguin/pkg/transaction/mock"
+	"github.com/penguintop/penguin/pkg/xwcfmt"
+	"github.com/penguintop/penguin/pkg/xwctypes"
 	"github.com/ethersphere/go-sw3-abi/sw3abi"
 )
 
-var (
-	chequebookABI          = transaction.ParseABIUnchecked(sw3abi.ERC20SimpleSwapABIv0_3_1)
-	chequeCashedEventType  = chequebookABI.Events["ChequeCashed"]
-	chequeBouncedEventType = chequebookABI.Events["ChequeBounced"]
-)
+var chequebookABI = transaction.ParseABIUnchecked(sw3abi.ERC20SimpleSwapABIv0_3_1)
 
 func TestCashout(t *testing.T) {
 	chequebookAddress := common.HexToAddress("abcd")
@@ -40,43 +38,34 @@ func TestCashout(t *testing.T) {
 			CumulativePayout: cumulativePayout,
 			Chequebook:       chequebookAddress,
 		},
-		Signature: []byte{},
+		Signature: make([]byte, 65),
 	}
 
 	store := storemock.NewStateStore()
 	cashoutService := chequebook.NewCashoutService(
 		store,
 		backendmock.New(
-			backendmock.WithTransactionByHashFunc(func(ctx context.Context, hash common.Hash) (tx *xwctypes.Transaction, isPending bool, err error) {
+			backendmock.WithTransactionByHashFunc(func(ctx context.Context, hash common.Hash) (tx *xwctypes.RpcTransaction, isPending bool, err error) {
 				if hash != txHash {
 					t.Fatalf("fetching wrong transaction. wanted %v, got %v", txHash, hash)
 				}
 				return nil, false, nil
 			}),
-			backendmock.WithTransactionReceiptFunc(func(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+			backendmock.WithTransactionReceiptFunc(func(ctx context.Context, hash common.Hash) (*xwctypes.RpcTransactionReceipt, error) {
 				if hash != txHash {
 					t.Fatalf("fetching receipt for transaction. wanted %v, got %v", txHash, hash)
 				}
 
-				logData, err := chequeCashedEventType.Inputs.NonIndexed().Pack(totalPayout, cumulativePayout, big.NewInt(0))
-				if err != nil {
-					t.Fatal(err)
-				}
-
-				return &types.Receipt{
-					Status: types.ReceiptStatusSuccessful,
-					Logs: []*types.Log{
-						{
-							Address: chequebookAddress,
-							Topics:  []common.Hash{chequeCashedEventType.ID, cheque.Beneficiary.Hash(), recipientAddress.Hash(), cheque.Beneficiary.Hash()},
-							Data:    logData,
-						},
+				return &xwctypes.RpcTransactionReceipt{
+					ExecSucceed: true,
+					Events: []xwctypes.RpcEvent{
+						chequeCashedEvent(t, chequebookAddress, cheque.Beneficiary, recipientAddress, cheque.Beneficiary, totalPayout, cumulativePayout, big.NewInt(0)),
 					},
 				}, nil
 			}),
 		),
 		transactionmock.New(
-			transactionmock.WithABISend(&chequebookABI, txHash, chequebookAddress, big.NewInt(0), "cashChequeBeneficiary", recipientAddress, cheque.CumulativePayout, cheque.Signature),
+			withCashChequeSend(t, txHash, chequebookAddress),
 		),
 		chequestoremock.NewChequeStore(
 			chequestoremock.WithLastChequeFunc(func(c common.Address) (*chequebook.SignedCheque, error) {
@@ -134,47 +123,38 @@ func TestCashoutBounced(t *testing.T) {
 			CumulativePayout: cumulativePayout,
 			Chequebook:       chequebookAddress,
 		},
-		Signature: []byte{},
+		Signature: make([]byte, 65),
 	}
 
 	store := storemock.NewStateStore()
 	cashoutService := chequebook.NewCashoutService(
 		store,
 		backendmock.New(
-			backendmock.WithTransactionByHashFunc(func(ctx context.Context, hash common.Hash) (*xwctypes.Transaction, bool, error) {
+			backendmock.WithTransactionByHashFunc(func(ctx context.Context, hash common.Hash) (*xwctypes.RpcTransaction, bool, error) {
 				if hash != txHash {
 					t.Fatalf("fetching wrong transaction. wanted %v, got %v", txHash, hash)
 				}
 				return nil, false, nil
 			}),
-			backendmock.WithTransactionReceiptFunc(func(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+			backendmock.WithTransactionReceiptFunc(func(ctx context.Context, hash common.Hash) (*xwctypes.RpcTransactionReceipt, error) {
 				if hash != txHash {
 					t.Fatalf("fetching receipt for transaction. wanted %v, got %v", txHash, hash)
 				}
 
-				chequeCashedLogData, err := chequeCashedEventType.Inputs.NonIndexed().Pack(totalPayout, cumulativePayout, big.NewInt(0))
-				if err != nil {
-					t.Fatal(err)
-				}
-
-				return &types.Receipt{
-					Status: types.ReceiptStatusSuccessful,
-					Logs: []*types.Log{
+				return &xwctypes.RpcTransactionReceipt{
+					ExecSucceed: true,
+					Events: []xwctypes.RpcEvent{
+						chequeCashedEvent(t, chequebookAddress, cheque.Beneficiary, recipientAddress, cheque.Beneficiary, totalPayout, cumulativePayout, big.NewInt(0)),
 						{
-							Address: chequebookAddress,
-							Topics:  []common.Hash{chequeCashedEventType.ID, cheque.Beneficiary.Hash(), recipientAddress.Hash(), cheque.Beneficiary.Hash()},
-							Data:    chequeCashedLogData,
-						},
-						{
-							Address: chequebookAddress,
-							Topics:  []common.Hash{chequeBouncedEventType.ID},
+							ContractAddress: chequebookAddress,
+							EventName:       "ChequeBounced",
 						},
 					},
 				}, nil
 			}),
 		),
 		transactionmock.New(
-			transactionmock.WithABISend(&chequebookABI, txHash, chequebookAddress, big.NewInt(0), "cashChequeBeneficiary", recipientAddress, cheque.CumulativePayout, cheque.Signature),
+			withCashChequeSend(t, txHash, chequebookAddress),
 		),
 		chequestoremock.NewChequeStore(
 			chequestoremock.WithLastChequeFunc(func(c common.Address) (*chequebook.SignedCheque, error) {
@@ -233,31 +213,31 @@ func TestCashoutStatusReverted(t *testing.T) {
 			CumulativePayout: cumulativePayout,
 			Chequebook:       chequebookAddress,
 		},
-		Signature: []byte{},
+		Signature: make([]byte, 65),
 	}
 
 	store := storemock.NewStateStore()
 	cashoutService := chequebook.NewCashoutService(
 		store,
 		backendmock.New(
-			backendmock.WithTransactionByHashFunc(func(ctx context.Context, hash common.Hash) (tx *xwctypes.Transaction, isPending bool, err error) {
+			backendmock.WithTransactionByHashFunc(func(ctx context.Context, hash common.Hash) (tx *xwctypes.RpcTransaction, isPending bool, err error) {
 				if hash != txHash {
 					t.Fatalf("fetching wrong transaction. wanted %v, got %v", txHash, hash)
 				}
 				return nil, false, nil
 			}),
-			backendmock.WithTransactionReceiptFunc(func(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+			backendmock.WithTransactionReceiptFunc(func(ctx context.Context, hash common.Hash) (*xwctypes.RpcTransactionReceipt, error) {
 				if hash != txHash {
 					t.Fatalf("fetching receipt for transaction. wanted %v, got %v", txHash, hash)
 				}
-				return &types.Receipt{
-					Status: types.ReceiptStatusFailed,
+				return &xwctypes.RpcTransactionReceipt{
+					ExecSucceed: false,
 				}, nil
 			}),
 		),
 		transactionmock.New(
-			transactionmock.WithABISend(&chequebookABI, txHash, chequebookAddress, big.NewInt(0), "cashChequeBeneficiary", recipientAddress, cheque.CumulativePayout, cheque.Signature),
-			transactionmock.WithABICall(&chequebookABI, chequebookAddress, onChainPaidOut.Bytes(), "paidOut", beneficiary),
+			withCashChequeSend(t, txHash, chequebookAddress),
+			withPaidOutCall(t, chequebookAddress, onChainPaidOut),
 		),
 		chequestoremock.NewChequeStore(
 			chequestoremock.WithLastChequeFunc(func(c common.Address) (*chequebook.SignedCheque, error) {
@@ -305,14 +285,14 @@ func TestCashoutStatusPending(t *testing.T) {
 			CumulativePayout: cumulativePayout,
 			Chequebook:       chequebookAddress,
 		},
-		Signature: []byte{},
+		Signature: make([]byte, 65),
 	}
 
 	store := storemock.NewStateStore()
 	cashoutService := chequebook.NewCashoutService(
 		store,
 		backendmock.New(
-			backendmock.WithTransactionByHashFunc(func(ctx context.Context, hash common.Hash) (tx *xwctypes.Transaction, isPending bool, err error) {
+			backendmock.WithTransactionByHashFunc(func(ctx context.Context, hash common.Hash) (tx *xwctypes.RpcTransaction, isPending bool, err error) {
 				if hash != txHash {
 					t.Fatalf("fetching wrong transaction. wanted %v, got %v", txHash, hash)
 				}
@@ -320,7 +300,7 @@ func TestCashoutStatusPending(t *testing.T) {
 			}),
 		),
 		transactionmock.New(
-			transactionmock.WithABISend(&chequebookABI, txHash, chequebookAddress, big.NewInt(0), "cashChequeBeneficiary", recipientAddress, cheque.CumulativePayout, cheque.Signature),
+			withCashChequeSend(t, txHash, chequebookAddress),
 		),
 		chequestoremock.NewChequeStore(
 			chequestoremock.WithLastChequeFunc(func(c common.Address) (*chequebook.SignedCheque, error) {
@@ -388,3 +368,58 @@ func verifyStatus(t *testing.T, status *chequebook.CashoutStatus, expected chequ
 		t.Fatalf("wrong uncashed amount. wanted %d, got %d", expected.UncashedAmount, status.UncashedAmount)
 	}
 }
+
+func xwcAddress(t *testing.T, address common.Address) string {
+	t.Helper()
+
+	addr, err := xwcfmt.HexAddrToXwcAddr(hex.EncodeToString(address[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+// withCashChequeSend expects the cashout of the last cheque of the chequebook.
+func withCashChequeSend(t *testing.T, txHash common.Hash, chequebookAddress common.Address) transactionmock.Option {
+	return transactionmock.WithSendFunc(func(ctx context.Context, request *transaction.TxRequest) (common.Hash, error) {
+		if request.To == nil || *request.To != chequebookAddress {
+			t.Fatalf("sending to wrong contract. wanted %v, got %v", chequebookAddress, request.To)
+		}
+		if request.InvokeApi != "cashChequeBeneficiary" {
+			t.Fatalf("invoking wrong api. wanted cashChequeBeneficiary, got %s", request.InvokeApi)
+		}
+		return txHash, nil
+	})
+}
+
+// withPaidOutCall returns the on-chain paid out amount of the chequebook.
+func withPaidOutCall(t *testing.T, chequebookAddress common.Address, paidOut *big.Int) transactionmock.Option {
+	return transactionmock.WithCallFunc(func(ctx context.Context, request *transaction.TxRequest) ([]byte, error) {
+		if request.To == nil || *request.To != chequebookAddress {
+			t.Fatalf("calling wrong contract. wanted %v, got %v", chequebookAddress, request.To)
+		}
+		return []byte(paidOut.String()), nil
+	})
+}
+
+func chequeCashedEvent(t *testing.T, chequebookAddress, beneficiary, recipient, caller common.Address, totalPayout, cumulativePayout, callerPayout *big.Int) xwctypes.RpcEvent {
+	t.Helper()
+
+	arg, err := json.Marshal(map[string]interface{}{
+		"beneficiary":      xwcAddress(t, beneficiary),
+		"recipient":        xwcAddress(t, recipient),
+		"msg_sender":       xwcAddress(t, caller),
+		"totalPayout":      totalPayout.Uint64(),
+		"cumulativePayout": cumulativePayout.Uint64(),
+		"callerPayout":     callerPayout.Uint64(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return xwctypes.RpcEvent{
+		ContractAddress: chequebookAddress,
+		EventName:       "ChequeCashed",
+		EventArg:        string(arg),
+	}
+}
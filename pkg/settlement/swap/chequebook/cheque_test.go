@@ -6,19 +6,59 @@ package chequebook_test
 
 import (
 	"bytes"
-
 	"encoding/hex"
 	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/penguintop/penguin/pkg/crypto"
 	"github.com/penguintop/penguin/pkg/crypto/eip712"
 	signermock "github.com/penguintop/penguin/pkg/crypto/mock"
+	"github.com/penguintop/penguin/pkg/property"
 	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook"
+	"github.com/penguintop/penguin/pkg/xwcfmt"
 )
 
 func TestSignCheque(t *testing.T) {
+	chequebookAddress := common.HexToAddress("0x8d3766440f0d7b949a5e32995d09619a7f86e632")
+	beneficiaryAddress := common.HexToAddress("0xb8d424e9662fe0837fb1d728f1ac97cebb1085fe")
+	cumulativePayout := big.NewInt(10)
+	chainId := int64(1)
+	cheque := &chequebook.Cheque{
+		Chequebook:       chequebookAddress,
+		Beneficiary:      beneficiaryAddress,
+		CumulativePayout: cumulativePayout,
+	}
+
+	chequebookXwcAddress, err := xwcfmt.HexAddrToXwcConAddr(hex.EncodeToString(chequebookAddress[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chequeSigner := chequebook.NewChequeSigner(signermock.New(), chainId)
+
+	result, err := chequeSigner.Sign(cheque)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cheque data is signed by the chequebook contract format
+	// of the domain, the cheque type and the cheque fields
+	expected := strings.Join([]string{
+		property.Domain(),
+		"Cheque(address chequebook,address beneficiary,uint256 cumulativePayout)",
+		chequebookXwcAddress,
+		xwcAddress(t, beneficiaryAddress),
+		cumulativePayout.String(),
+	}, ",")
+
+	if !bytes.Equal(result, []byte(expected)) {
+		t.Fatalf("returned wrong signature data. wanted %s, got %s", expected, result)
+	}
+}
+
+func TestSignChequeTypedData(t *testing.T) {
 	chequebookAddress := common.HexToAddress("0x8d3766440f0d7b949a5e32995d09619a7f86e632")
 	beneficiaryAddress := common.HexToAddress("0xb8d424e9662fe0837fb1d728f1ac97cebb1085fe")
 	signature := common.Hex2Bytes("abcd")
@@ -49,9 +89,7 @@ func TestSignCheque(t *testing.T) {
 		}),
 	)
 
-	chequeSigner := chequebook.NewChequeSigner(signer, chainId)
-
-	result, err := chequeSigner.Sign(cheque)
+	result, err := signer.SignTypedData(chequebook.ChequeTypedData(cheque, chainId))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -61,7 +99,7 @@ func TestSignCheque(t *testing.T) {
 	}
 }
 
-func TestSignChequeIntegration(t *testing.T) {
+func TestSignChequeTypedDataIntegration(t *testing.T) {
 	chequebookAddress := common.HexToAddress("0xfa02D396842E6e1D319E8E3D4D870338F791AA25")
 	beneficiaryAddress := common.HexToAddress("0x98E6C644aFeB94BBfB9FF60EB26fc9D83BBEcA79")
 	cumulativePayout := big.NewInt(500)
@@ -85,9 +123,7 @@ func TestSignChequeIntegration(t *testing.T) {
 		CumulativePayout: cumulativePayout,
 	}
 
-	chequeSigner := chequebook.NewChequeSigner(signer, chainId)
-
-	result, err := chequeSigner.Sign(cheque)
+	result, err := signer.SignTypedData(chequebook.ChequeTypedData(cheque, chainId))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -22,9 +22,12 @@ import (
 // SendChequeFunc is a function to send cheques.
 type SendChequeFunc func(cheque *SignedCheque) error
 
+// PersistFunc is a function to persist further state
+// in the batch which saves an issued cheque.
+type PersistFunc func(b storage.StateBatch) error
+
 const (
-	lastIssuedChequeKeyPrefix = "swap_chequebook_last_issued_cheque_"
-	totalIssuedKey            = "swap_chequebook_total_issued_"
+	chequebookKeyPrefix = "swap_chequebook_"
 )
 
 var (
@@ -55,12 +58,21 @@ type Service interface {
 	AvailableBalance(ctx context.Context) (*big.Int, error)
 	// Address returns the address of the used chequebook contract.
 	Address() common.Address
+	// Token returns the address of the token the chequebook holds.
+	Token() common.Address
 	// Issue a new cheque for the beneficiary with an cumulativePayout amount higher than the last.
-	Issue(ctx context.Context, beneficiary common.Address, amount *big.Int, sendChequeFunc SendChequeFunc) (*big.Int, error)
+	// The persistFunc, if not nil, is called in the batch which saves the cheque.
+	Issue(ctx context.Context, beneficiary common.Address, amount *big.Int, sendChequeFunc SendChequeFunc, persistFunc PersistFunc) (*big.Int, error)
 	// LastCheque returns the last cheque we issued for the beneficiary.
 	LastCheque(beneficiary common.Address) (*SignedCheque, error)
 	// LastCheque returns the last cheques for all beneficiaries.
 	LastCheques() (map[common.Address]*SignedCheque, error)
+	// TransferOwnership transfers the chequebook to a new owner and re-signs the last issued cheques with its signer.
+	TransferOwnership(ctx context.Context, newOwner common.Address, newSigner ChequeSigner, dryRun bool) (*OwnershipTransfer, error)
+	// ReissuedCheque returns the cheque re-signed for the beneficiary by an ownership transfer if it was not delivered yet.
+	ReissuedCheque(beneficiary common.Address) (*SignedCheque, error)
+	// ReissuedChequeDelivered marks the re-signed cheque for the beneficiary as delivered.
+	ReissuedChequeDelivered(beneficiary common.Address) error
 }
 
 type service struct {
@@ -76,20 +88,47 @@ type service struct {
 	store               storage.StateStorer
 	chequeSigner        ChequeSigner
 	totalIssuedReserved *big.Int
+
+	keyPrefix                 string
+	lastIssuedChequeKeyPrefix string
+	reissuedChequeKeyPrefix   string
+	totalIssuedKey            string
 }
 
 // New creates a new chequebook service for the provided chequebook contract.
 func New(transactionService transaction.Service, address, ownerAddress common.Address, store storage.StateStorer, chequeSigner ChequeSigner, erc20Service erc20.Service) (Service, error) {
+	return newService(transactionService, address, ownerAddress, store, chequeSigner, erc20Service, chequebookKeyPrefix), nil
+}
+
+// NewForToken creates a new chequebook service for a chequebook contract
+// holding a token other than the default one. Its state is kept under keys
+// namespaced by the token address so it can share the state store with the
+// chequebook of the default token.
+func NewForToken(transactionService transaction.Service, address, ownerAddress common.Address, store storage.StateStorer, chequeSigner ChequeSigner, erc20Service erc20.Service) (Service, error) {
+	return newService(transactionService, address, ownerAddress, store, chequeSigner, erc20Service, tokenKeyPrefix(erc20Service.Address())), nil
+}
+
+func newService(transactionService transaction.Service, address, ownerAddress common.Address, store storage.StateStorer, chequeSigner ChequeSigner, erc20Service erc20.Service, keyPrefix string) *service {
 	return &service{
-		transactionService:  transactionService,
-		address:             address,
-		contract:            newChequebookContract(address, transactionService),
-		ownerAddress:        ownerAddress,
-		erc20Service:        erc20Service,
-		store:               store,
-		chequeSigner:        chequeSigner,
-		totalIssuedReserved: big.NewInt(0),
-	}, nil
+		transactionService:        transactionService,
+		address:                   address,
+		contract:                  newChequebookContract(address, transactionService),
+		ownerAddress:              ownerAddress,
+		erc20Service:              erc20Service,
+		store:                     store,
+		chequeSigner:              chequeSigner,
+		totalIssuedReserved:       big.NewInt(0),
+		keyPrefix:                 keyPrefix,
+		lastIssuedChequeKeyPrefix: keyPrefix + "last_issued_cheque_",
+		reissuedChequeKeyPrefix:   keyPrefix + "reissued_cheque_",
+		totalIssuedKey:            keyPrefix + "total_issued_",
+	}
+}
+
+// tokenKeyPrefix computes the prefix of the keys under which the state of a
+// chequebook for a token other than the default one is stored.
+func tokenKeyPrefix(token common.Address) string {
+	return fmt.Sprintf("%s%x_", chequebookKeyPrefix, token)
 }
 
 // Address returns the address of the used chequebook contract.
@@ -97,6 +136,11 @@ func (s *service) Address() common.Address {
 	return s.address
 }
 
+// Token returns the address of the token the chequebook holds.
+func (s *service) Token() common.Address {
+	return s.erc20Service.Address()
+}
+
 // Deposit starts depositing erc20 token into the chequebook. This returns once the transactions has been broadcast.
 func (s *service) Deposit(ctx context.Context, amount *big.Int) (hash common.Hash, err error) {
 	balance, err := s.erc20Service.BalanceOf(ctx, s.ownerAddress)
@@ -143,19 +187,19 @@ func (s *service) AvailableBalance(ctx context.Context) (*big.Int, error) {
 
 // WaitForDeposit waits for the deposit transaction to confirm and verifies the result.
 func (s *service) WaitForDeposit(ctx context.Context, txHash common.Hash) error {
-	//receipt, err := s.transactionService.WaitForReceipt(ctx, txHash)
-	//if err != nil {
-	//	return err
-	//}
-	//if receipt.Status != 1 {
-	//	return transaction.ErrTransactionReverted
-	//}
+	receipt, err := s.transactionService.WaitForReceipt(ctx, txHash)
+	if err != nil {
+		return err
+	}
+	if !receipt.ExecSucceed {
+		return transaction.ErrTransactionReverted
+	}
 	return nil
 }
 
 // lastIssuedChequeKey computes the key where to store the last cheque for a beneficiary.
-func lastIssuedChequeKey(beneficiary common.Address) string {
-	return fmt.Sprintf("%s%x", lastIssuedChequeKeyPrefix, beneficiary)
+func (s *service) lastIssuedChequeKey(beneficiary common.Address) string {
+	return fmt.Sprintf("%s%x", s.lastIssuedChequeKeyPrefix, beneficiary)
 }
 
 func (s *service) reserveTotalIssued(ctx context.Context, amount *big.Int) (*big.Int, error) {
@@ -185,7 +229,9 @@ func (s *service) unreserveTotalIssued(amount *big.Int) {
 // The cheque is considered sent and saved when sendChequeFunc succeeds.
 // The available balance which is available after sending the cheque is passed
 // to the caller for it to be communicated over metrics.
-func (s *service) Issue(ctx context.Context, beneficiary common.Address, amount *big.Int, sendChequeFunc SendChequeFunc) (*big.Int, error) {
+// The persistFunc, if not nil, is called in the batch which saves the cheque,
+// so that the state of the caller is consistent with the issued cheques.
+func (s *service) Issue(ctx context.Context, beneficiary common.Address, amount *big.Int, sendChequeFunc SendChequeFunc, persistFunc PersistFunc) (*big.Int, error) {
 	availableBalance, err := s.reserveTotalIssued(ctx, amount)
 	if err != nil {
 		return nil, err
@@ -232,11 +278,6 @@ func (s *service) Issue(ctx context.Context, beneficiary common.Address, amount
 		return nil, err
 	}
 
-	err = s.store.Put(lastIssuedChequeKey(beneficiary), cheque)
-	if err != nil {
-		return nil, err
-	}
-
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
@@ -245,12 +286,29 @@ func (s *service) Issue(ctx context.Context, beneficiary common.Address, amount
 		return nil, err
 	}
 	totalIssued = totalIssued.Add(totalIssued, amount)
-	return availableBalance, s.store.Put(totalIssuedKey, totalIssued)
+
+	// the cheque and the total issued amount are saved together
+	// so that they are consistent after a crash
+	return availableBalance, s.store.Batch(func(b storage.StateBatch) error {
+		if err := b.Put(s.lastIssuedChequeKey(beneficiary), cheque); err != nil {
+			return err
+		}
+		// a new cheque supersedes a re-signed one which was not delivered yet
+		if err := b.Delete(s.reissuedChequeKey(beneficiary)); err != nil {
+			return err
+		}
+		if persistFunc != nil {
+			if err := persistFunc(b); err != nil {
+				return err
+			}
+		}
+		return b.Put(s.totalIssuedKey, totalIssued)
+	})
 }
 
 // returns the total amount in cheques issued so far
 func (s *service) totalIssued() (totalIssued *big.Int, err error) {
-	err = s.store.Get(totalIssuedKey, &totalIssued)
+	err = s.store.Get(s.totalIssuedKey, &totalIssued)
 	if err != nil {
 		if err != storage.ErrNotFound {
 			return nil, err
@@ -263,7 +321,7 @@ func (s *service) totalIssued() (totalIssued *big.Int, err error) {
 // LastCheque returns the last cheque we issued for the beneficiary.
 func (s *service) LastCheque(beneficiary common.Address) (*SignedCheque, error) {
 	var lastCheque *SignedCheque
-	err := s.store.Get(lastIssuedChequeKey(beneficiary), &lastCheque)
+	err := s.store.Get(s.lastIssuedChequeKey(beneficiary), &lastCheque)
 	if err != nil {
 		if err != storage.ErrNotFound {
 			return nil, err
@@ -286,8 +344,8 @@ func keyBeneficiary(key []byte, prefix string) (beneficiary common.Address, err
 // LastCheque returns the last cheques for all beneficiaries.
 func (s *service) LastCheques() (map[common.Address]*SignedCheque, error) {
 	result := make(map[common.Address]*SignedCheque)
-	err := s.store.Iterate(lastIssuedChequeKeyPrefix, func(key, val []byte) (stop bool, err error) {
-		addr, err := keyBeneficiary(key, lastIssuedChequeKeyPrefix)
+	err := s.store.Iterate(s.lastIssuedChequeKeyPrefix, func(key, val []byte) (stop bool, err error) {
+		addr, err := keyBeneficiary(key, s.lastIssuedChequeKeyPrefix)
 		if err != nil {
 			return false, fmt.Errorf("parse address from key: %s: %w", string(key), err)
 		}
@@ -12,12 +12,13 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook"
 	erc20mock "github.com/penguintop/penguin/pkg/settlement/swap/erc20/mock"
 	storemock "github.com/penguintop/penguin/pkg/statestore/mock"
+	"github.com/penguintop/penguin/pkg/storage"
 	"github.com/penguintop/penguin/pkg/transaction"
 	transactionmock "github.com/penguintop/penguin/pkg/transaction/mock"
+	"github.com/penguintop/penguin/pkg/xwctypes"
 )
 
 func TestChequebookAddress(t *testing.T) {
@@ -46,7 +47,7 @@ func TestChequebookBalance(t *testing.T) {
 	balance := big.NewInt(10)
 	chequebookService, err := chequebook.New(
 		transactionmock.New(
-			transactionmock.WithABICall(&chequebookABI, address, balance.Bytes(), "balance"),
+			transactionmock.WithXwcCall(address, []byte(balance.String()), "balance", ""),
 		),
 		address,
 		ownerAdress,
@@ -87,7 +88,7 @@ func TestChequebookDeposit(t *testing.T) {
 				}
 				return balance, nil
 			}),
-			erc20mock.WithTransferFunc(func(ctx context.Context, to common.Address, value *big.Int) (common.Hash, error) {
+			erc20mock.WithTransferToContractFunc(func(ctx context.Context, to common.Address, value *big.Int) (common.Hash, error) {
 				if to != address {
 					return common.Hash{}, fmt.Errorf("sending to wrong address. wanted %x, got %x", address, to)
 				}
@@ -118,12 +119,12 @@ func TestChequebookWaitForDeposit(t *testing.T) {
 	txHash := common.HexToHash("0xdddd")
 	chequebookService, err := chequebook.New(
 		transactionmock.New(
-			transactionmock.WithWaitForReceiptFunc(func(ctx context.Context, tx common.Hash) (*types.Receipt, error) {
+			transactionmock.WithWaitForReceiptFunc(func(ctx context.Context, tx common.Hash) (*xwctypes.RpcTransactionReceipt, error) {
 				if tx != txHash {
 					t.Fatalf("waiting for wrong transaction. wanted %x, got %x", txHash, tx)
 				}
-				return &types.Receipt{
-					Status: 1,
+				return &xwctypes.RpcTransactionReceipt{
+					ExecSucceed: true,
 				}, nil
 			}),
 		),
@@ -149,12 +150,12 @@ func TestChequebookWaitForDepositReverted(t *testing.T) {
 	txHash := common.HexToHash("0xdddd")
 	chequebookService, err := chequebook.New(
 		transactionmock.New(
-			transactionmock.WithWaitForReceiptFunc(func(ctx context.Context, tx common.Hash) (*types.Receipt, error) {
+			transactionmock.WithWaitForReceiptFunc(func(ctx context.Context, tx common.Hash) (*xwctypes.RpcTransactionReceipt, error) {
 				if tx != txHash {
 					t.Fatalf("waiting for wrong transaction. wanted %x, got %x", txHash, tx)
 				}
-				return &types.Receipt{
-					Status: 0,
+				return &xwctypes.RpcTransactionReceipt{
+					ExecSucceed: false,
 				}, nil
 			}),
 		),
@@ -190,13 +191,13 @@ func TestChequebookIssue(t *testing.T) {
 
 	chequebookService, err := chequebook.New(
 		transactionmock.New(
-			transactionmock.WithABICallSequence(
-				transactionmock.ABICall(&chequebookABI, address, big.NewInt(100).Bytes(), "balance"),
-				transactionmock.ABICall(&chequebookABI, address, big.NewInt(0).Bytes(), "totalPaidOut"),
-				transactionmock.ABICall(&chequebookABI, address, big.NewInt(100).Bytes(), "balance"),
-				transactionmock.ABICall(&chequebookABI, address, big.NewInt(0).Bytes(), "totalPaidOut"),
-				transactionmock.ABICall(&chequebookABI, address, big.NewInt(100).Bytes(), "balance"),
-				transactionmock.ABICall(&chequebookABI, address, big.NewInt(0).Bytes(), "totalPaidOut"),
+			transactionmock.WithXwcCallSequence(
+				transactionmock.NewXwcCall(address, []byte("100"), "balance", ""),
+				transactionmock.NewXwcCall(address, []byte("0"), "totalPaidOut", ""),
+				transactionmock.NewXwcCall(address, []byte("100"), "balance", ""),
+				transactionmock.NewXwcCall(address, []byte("0"), "totalPaidOut", ""),
+				transactionmock.NewXwcCall(address, []byte("100"), "balance", ""),
+				transactionmock.NewXwcCall(address, []byte("0"), "totalPaidOut", ""),
 			),
 		),
 		address,
@@ -231,6 +232,8 @@ func TestChequebookIssue(t *testing.T) {
 			t.Fatalf("wrong cheque. wanted %v got %v", expectedCheque, cheque)
 		}
 		return nil
+	}, func(b storage.StateBatch) error {
+		return b.Put("payment", amount)
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -245,6 +248,14 @@ func TestChequebookIssue(t *testing.T) {
 		t.Fatalf("wrong cheque stored. wanted %v got %v", expectedCheque, lastCheque)
 	}
 
+	var payment *big.Int
+	if err := store.Get("payment", &payment); err != nil {
+		t.Fatal(err)
+	}
+	if payment.Cmp(amount) != 0 {
+		t.Fatalf("wrong payment persisted with the cheque. wanted %d got %d", amount, payment)
+	}
+
 	// issue another cheque for the same beneficiary
 	expectedCheque = &chequebook.SignedCheque{
 		Cheque: chequebook.Cheque{
@@ -267,7 +278,7 @@ func TestChequebookIssue(t *testing.T) {
 			t.Fatalf("wrong cheque. wanted %v got %v", expectedCheque, cheque)
 		}
 		return nil
-	})
+	}, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -303,7 +314,7 @@ func TestChequebookIssue(t *testing.T) {
 			t.Fatalf("wrong cheque. wanted %v got %v", expectedChequeOwner, cheque)
 		}
 		return nil
-	})
+	}, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -355,7 +366,7 @@ func TestChequebookIssueErrorSend(t *testing.T) {
 
 	_, err = chequebookService.Issue(context.Background(), beneficiary, amount, func(cheque *chequebook.SignedCheque) error {
 		return errors.New("err")
-	})
+	}, nil)
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -376,9 +387,9 @@ func TestChequebookIssueOutOfFunds(t *testing.T) {
 
 	chequebookService, err := chequebook.New(
 		transactionmock.New(
-			transactionmock.WithABICallSequence(
-				transactionmock.ABICall(&chequebookABI, address, big.NewInt(0).Bytes(), "balance"),
-				transactionmock.ABICall(&chequebookABI, address, big.NewInt(0).Bytes(), "totalPaidOut"),
+			transactionmock.WithXwcCallSequence(
+				transactionmock.NewXwcCall(address, []byte("0"), "balance", ""),
+				transactionmock.NewXwcCall(address, []byte("0"), "totalPaidOut", ""),
 			),
 		),
 		address,
@@ -393,7 +404,7 @@ func TestChequebookIssueOutOfFunds(t *testing.T) {
 
 	_, err = chequebookService.Issue(context.Background(), beneficiary, amount, func(cheque *chequebook.SignedCheque) error {
 		return nil
-	})
+	}, nil)
 	if !errors.Is(err, chequebook.ErrOutOfFunds) {
 		t.Fatalf("wrong error. wanted %v, got %v", chequebook.ErrOutOfFunds, err)
 	}
@@ -415,11 +426,11 @@ func TestChequebookWithdraw(t *testing.T) {
 	store := storemock.NewStateStore()
 	chequebookService, err := chequebook.New(
 		transactionmock.New(
-			transactionmock.WithABICallSequence(
-				transactionmock.ABICall(&chequebookABI, address, balance.Bytes(), "balance"),
-				transactionmock.ABICall(&chequebookABI, address, big.NewInt(0).Bytes(), "totalPaidOut"),
+			transactionmock.WithXwcCallSequence(
+				transactionmock.NewXwcCall(address, []byte(balance.String()), "balance", ""),
+				transactionmock.NewXwcCall(address, []byte("0"), "totalPaidOut", ""),
 			),
-			transactionmock.WithABISend(&chequebookABI, txHash, address, big.NewInt(0), "withdraw", withdrawAmount),
+			transactionmock.WithXwcInvoke(txHash, address, "withdraw", withdrawAmount.String()),
 		),
 		address,
 		ownerAdress,
@@ -449,10 +460,10 @@ func TestChequebookWithdrawInsufficientFunds(t *testing.T) {
 	store := storemock.NewStateStore()
 	chequebookService, err := chequebook.New(
 		transactionmock.New(
-			transactionmock.WithABISend(&chequebookABI, txHash, address, big.NewInt(0), "withdraw", withdrawAmount),
-			transactionmock.WithABICallSequence(
-				transactionmock.ABICall(&chequebookABI, address, big.NewInt(0).Bytes(), "balance"),
-				transactionmock.ABICall(&chequebookABI, address, big.NewInt(0).Bytes(), "totalPaidOut"),
+			transactionmock.WithXwcInvoke(txHash, address, "withdraw", withdrawAmount.String()),
+			transactionmock.WithXwcCallSequence(
+				transactionmock.NewXwcCall(address, []byte("0"), "balance", ""),
+				transactionmock.NewXwcCall(address, []byte("0"), "totalPaidOut", ""),
 			),
 		),
 		address,
@@ -0,0 +1,76 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chequebook
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	// ErrUnsupportedToken is the error when there is no chequebook for a token.
+	ErrUnsupportedToken = errors.New("unsupported token")
+	// ErrDuplicateToken is the error when two chequebooks hold the same token.
+	ErrDuplicateToken = errors.New("duplicate chequebook token")
+)
+
+// Chequebooks gives access to the chequebooks of the node, one per token.
+// Accounting is not aware of tokens, so amounts in all tokens are treated at
+// face value.
+type Chequebooks interface {
+	// Default returns the chequebook of the default token.
+	Default() Service
+	// Chequebook returns the chequebook for the given token.
+	Chequebook(token common.Address) (Service, error)
+	// Tokens returns the supported tokens, the default token first.
+	Tokens() []common.Address
+}
+
+type chequebooks struct {
+	defaultChequebook Service
+	tokens            []common.Address
+	chequebooks       map[common.Address]Service
+}
+
+// NewChequebooks creates the chequebooks from the chequebook of the default
+// token and the chequebooks of any additional tokens.
+func NewChequebooks(defaultChequebook Service, additional ...Service) (Chequebooks, error) {
+	c := &chequebooks{
+		defaultChequebook: defaultChequebook,
+		chequebooks:       make(map[common.Address]Service),
+	}
+	for _, chequebook := range append([]Service{defaultChequebook}, additional...) {
+		token := chequebook.Token()
+		if _, ok := c.chequebooks[token]; ok {
+			return nil, fmt.Errorf("%w: %x", ErrDuplicateToken, token)
+		}
+		c.chequebooks[token] = chequebook
+		c.tokens = append(c.tokens, token)
+	}
+	return c, nil
+}
+
+// Default returns the chequebook of the default token.
+func (c *chequebooks) Default() Service {
+	return c.defaultChequebook
+}
+
+// Chequebook returns the chequebook for the given token.
+func (c *chequebooks) Chequebook(token common.Address) (Service, error) {
+	chequebook, ok := c.chequebooks[token]
+	if !ok {
+		return nil, ErrUnsupportedToken
+	}
+	return chequebook, nil
+}
+
+// Tokens returns the supported tokens, the default token first.
+func (c *chequebooks) Tokens() []common.Address {
+	tokens := make([]common.Address, len(c.tokens))
+	copy(tokens, c.tokens)
+	return tokens
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chequebook_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook"
+	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook/mock"
+)
+
+func TestChequebooks(t *testing.T) {
+	defaultToken := common.HexToAddress("0xaa")
+	otherToken := common.HexToAddress("0xbb")
+
+	defaultChequebook := mock.NewChequebook(
+		mock.WithChequebookTokenFunc(func() common.Address {
+			return defaultToken
+		}),
+	)
+	otherChequebook := mock.NewChequebook(
+		mock.WithChequebookTokenFunc(func() common.Address {
+			return otherToken
+		}),
+	)
+
+	chequebooks, err := chequebook.NewChequebooks(defaultChequebook, otherChequebook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if chequebooks.Default() != defaultChequebook {
+		t.Fatal("wrong default chequebook")
+	}
+
+	tokens := chequebooks.Tokens()
+	if len(tokens) != 2 || tokens[0] != defaultToken || tokens[1] != otherToken {
+		t.Fatalf("wrong tokens. got %v", tokens)
+	}
+
+	c, err := chequebooks.Chequebook(otherToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != otherChequebook {
+		t.Fatal("wrong chequebook for token")
+	}
+
+	_, err = chequebooks.Chequebook(common.HexToAddress("0xcc"))
+	if !errors.Is(err, chequebook.ErrUnsupportedToken) {
+		t.Fatalf("wrong error. wanted %v, got %v", chequebook.ErrUnsupportedToken, err)
+	}
+
+	_, err = chequebook.NewChequebooks(defaultChequebook, defaultChequebook)
+	if !errors.Is(err, chequebook.ErrDuplicateToken) {
+		t.Fatalf("wrong error. wanted %v, got %v", chequebook.ErrDuplicateToken, err)
+	}
+}
@@ -5,6 +5,7 @@
 package chequebook
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -35,7 +36,10 @@ var (
 // ChequeStore handles the verification and storage of received cheques
 type ChequeStore interface {
 	// ReceiveCheque verifies and stores a cheque. It returns the total amount earned.
-	ReceiveCheque(ctx context.Context, cheque *SignedCheque) (*big.Int, error)
+	// If check is not nil, the verified cheque is only stored if check accepts it.
+	ReceiveCheque(ctx context.Context, cheque *SignedCheque, check ChequeCheckFunc) (*big.Int, error)
+	// ChequebookToken returns the token held by the chequebook, as reported by the factory which deployed it.
+	ChequebookToken(ctx context.Context, chequebook common.Address) (common.Address, error)
 	// LastCheque returns the last cheque we received from a specific chequebook.
 	LastCheque(chequebook common.Address) (*SignedCheque, error)
 	// LastCheques returns the last received cheques from every known chequebook.
@@ -46,6 +50,7 @@ type chequeStore struct {
 	lock               sync.Mutex
 	store              storage.StateStorer
 	factory            Factory
+	tokenFactories     []Factory
 	chaindID           int64
 	transactionService transaction.Service
 	beneficiary        common.Address // the beneficiary we expect in cheques sent to us
@@ -54,17 +59,25 @@ type chequeStore struct {
 
 type RecoverChequeFunc func(cheque *SignedCheque, chainID int64) (common.Address, error)
 
-// NewChequeStore creates new ChequeStore
+// ChequeCheckFunc is called with a verified cheque and the amount it earns
+// before the cheque is stored. The cheque is rejected if it returns an error.
+type ChequeCheckFunc func(cheque *SignedCheque, amount *big.Int) error
+
+// NewChequeStore creates new ChequeStore. Cheques from chequebooks deployed by
+// any of the tokenFactories are accepted in addition to the ones deployed by
+// the factory of the default token.
 func NewChequeStore(
 	store storage.StateStorer,
 	factory Factory,
 	chainID int64,
 	beneficiary common.Address,
 	transactionService transaction.Service,
-	recoverChequeFunc RecoverChequeFunc) ChequeStore {
+	recoverChequeFunc RecoverChequeFunc,
+	tokenFactories ...Factory) ChequeStore {
 	return &chequeStore{
 		store:              store,
 		factory:            factory,
+		tokenFactories:     tokenFactories,
 		chaindID:           chainID,
 		transactionService: transactionService,
 		beneficiary:        beneficiary,
@@ -72,6 +85,36 @@ func NewChequeStore(
 	}
 }
 
+// verifyChequebook checks that the chequebook was deployed by the factory of
+// the default token or by the factory of one of the additional tokens.
+func (s *chequeStore) verifyChequebook(ctx context.Context, chequebook common.Address) error {
+	_, err := s.chequebookFactory(ctx, chequebook)
+	return err
+}
+
+// chequebookFactory returns the factory which deployed the chequebook.
+func (s *chequeStore) chequebookFactory(ctx context.Context, chequebook common.Address) (Factory, error) {
+	err := s.factory.VerifyChequebook(ctx, chequebook)
+	if err == nil || !errors.Is(err, ErrNotDeployedByFactory) {
+		return s.factory, err
+	}
+	for _, factory := range s.tokenFactories {
+		if factory.VerifyChequebook(ctx, chequebook) == nil {
+			return factory, nil
+		}
+	}
+	return nil, err
+}
+
+// ChequebookToken returns the token held by the chequebook, as reported by the factory which deployed it.
+func (s *chequeStore) ChequebookToken(ctx context.Context, chequebook common.Address) (common.Address, error) {
+	factory, err := s.chequebookFactory(ctx, chequebook)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return factory.ERC20Address(ctx)
+}
+
 // lastReceivedChequeKey computes the key where to store the last cheque received from a chequebook.
 func lastReceivedChequeKey(chequebook common.Address) string {
 	return fmt.Sprintf("%s_%x", lastReceivedChequePrefix, chequebook)
@@ -92,17 +135,40 @@ func (s *chequeStore) LastCheque(chequebook common.Address) (*SignedCheque, erro
 }
 
 // ReceiveCheque verifies and stores a cheque. It returns the totam amount earned.
-func (s *chequeStore) ReceiveCheque(ctx context.Context, cheque *SignedCheque) (*big.Int, error) {
-	// verify we are the beneficiary
-	if cheque.Beneficiary != s.beneficiary {
-		return nil, ErrWrongBeneficiary
-	}
-
+func (s *chequeStore) ReceiveCheque(ctx context.Context, cheque *SignedCheque, check ChequeCheckFunc) (*big.Int, error) {
 	// don't allow concurrent processing of cheques
 	// this would be sufficient on a per chequebook basis
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
+	amount, err := s.verifyCheque(ctx, cheque)
+	if err != nil {
+		return nil, err
+	}
+
+	if check != nil {
+		if err := check(cheque, amount); err != nil {
+			return nil, err
+		}
+	}
+
+	// store the accepted cheque
+	err = s.store.Put(lastReceivedChequeKey(cheque.Chequebook), cheque)
+	if err != nil {
+		return nil, err
+	}
+
+	return amount, nil
+}
+
+// verifyCheque verifies a cheque against the last received one. It must be
+// called with the lock held.
+func (s *chequeStore) verifyCheque(ctx context.Context, cheque *SignedCheque) (*big.Int, error) {
+	// verify we are the beneficiary
+	if cheque.Beneficiary != s.beneficiary {
+		return nil, ErrWrongBeneficiary
+	}
+
 	// load the lastCumulativePayout for the cheques chequebook
 	var lastCumulativePayout *big.Int
 	var lastReceivedCheque *SignedCheque
@@ -113,7 +179,7 @@ func (s *chequeStore) ReceiveCheque(ctx context.Context, cheque *SignedCheque) (
 		}
 
 		// if this is the first cheque from this chequebook, verify with the factory.
-		err = s.factory.VerifyChequebook(ctx, cheque.Chequebook)
+		err = s.verifyChequebook(ctx, cheque.Chequebook)
 		if err != nil {
 			return nil, err
 		}
@@ -123,17 +189,24 @@ func (s *chequeStore) ReceiveCheque(ctx context.Context, cheque *SignedCheque) (
 		lastCumulativePayout = lastReceivedCheque.CumulativePayout
 	}
 
-	// check this cheque is actually increasing in value
+	// check this cheque is actually increasing in value. A cheque for the same
+	// cumulative payout is only accepted with a new signature, as it is sent
+	// after the chequebook was transferred to a new owner.
 	amount := big.NewInt(0).Sub(cheque.CumulativePayout, lastCumulativePayout)
 
-	if amount.Cmp(big.NewInt(0)) <= 0 {
+	switch amount.Cmp(big.NewInt(0)) {
+	case -1:
 		return nil, ErrChequeNotIncreasing
+	case 0:
+		if lastReceivedCheque == nil || bytes.Equal(cheque.Signature, lastReceivedCheque.Signature) {
+			return nil, ErrChequeNotIncreasing
+		}
 	}
 
 	// blockchain calls below
 	contract := newChequebookContract(cheque.Chequebook, s.transactionService)
 
-	// this does not change for the same chequebook
+	// this only changes when the chequebook is transferred to a new owner
 	expectedIssuer, err := contract.Issuer(ctx)
 	if err != nil {
 		return nil, err
@@ -165,12 +238,6 @@ func (s *chequeStore) ReceiveCheque(ctx context.Context, cheque *SignedCheque) (
 		return nil, ErrBouncingCheque
 	}
 
-	// store the accepted cheque
-	err = s.store.Put(lastReceivedChequeKey(cheque.Chequebook), cheque)
-	if err != nil {
-		return nil, err
-	}
-
 	return amount, nil
 }
 
@@ -5,6 +5,7 @@
 package chequebook_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"math/big"
@@ -52,13 +53,13 @@ func TestReceiveCheque(t *testing.T) {
 		chainID,
 		beneficiary,
 		transactionmock.New(
-			transactionmock.WithABICallSequence(
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, issuer.Hash().Bytes(), "issuer"),
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, cumulativePayout2.Bytes(), "balance"),
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, big.NewInt(0).Bytes(), "paidOut", beneficiary),
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, issuer.Hash().Bytes(), "issuer"),
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, cumulativePayout2.Bytes(), "balance"),
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, big.NewInt(0).Bytes(), "paidOut", beneficiary),
+			transactionmock.WithXwcCallSequence(
+				transactionmock.NewXwcCall(chequebookAddress, []byte(xwcAddress(t, issuer)), "issuer", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte(cumulativePayout2.String()), "balance", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte("0"), "paidOut", xwcAddress(t, beneficiary)),
+				transactionmock.NewXwcCall(chequebookAddress, []byte(xwcAddress(t, issuer)), "issuer", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte(cumulativePayout2.String()), "balance", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte("0"), "paidOut", xwcAddress(t, beneficiary)),
 			),
 		),
 		func(c *chequebook.SignedCheque, cid int64) (common.Address, error) {
@@ -71,7 +72,7 @@ func TestReceiveCheque(t *testing.T) {
 			return issuer, nil
 		})
 
-	received, err := chequestore.ReceiveCheque(context.Background(), cheque)
+	received, err := chequestore.ReceiveCheque(context.Background(), cheque, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -103,7 +104,7 @@ func TestReceiveCheque(t *testing.T) {
 	}
 
 	verifiedWithFactory = false
-	received, err = chequestore.ReceiveCheque(context.Background(), cheque)
+	received, err = chequestore.ReceiveCheque(context.Background(), cheque, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -118,6 +119,113 @@ func TestReceiveCheque(t *testing.T) {
 	}
 }
 
+func TestReceiveChequeCheck(t *testing.T) {
+	store := storemock.NewStateStore()
+	beneficiary := common.HexToAddress("0xffff")
+	issuer := common.HexToAddress("0xbeee")
+	cumulativePayout := big.NewInt(10)
+	chequebookAddress := common.HexToAddress("0xeeee")
+	chainID := int64(1)
+
+	cheque := &chequebook.SignedCheque{
+		Cheque: chequebook.Cheque{
+			Beneficiary:      beneficiary,
+			CumulativePayout: cumulativePayout,
+			Chequebook:       chequebookAddress,
+		},
+		Signature: make([]byte, 65),
+	}
+
+	chequestore := chequebook.NewChequeStore(
+		store,
+		&factoryMock{
+			verifyChequebook: func(ctx context.Context, address common.Address) error {
+				return nil
+			},
+		},
+		chainID,
+		beneficiary,
+		transactionmock.New(
+			transactionmock.WithXwcCallSequence(
+				transactionmock.NewXwcCall(chequebookAddress, []byte(xwcAddress(t, issuer)), "issuer", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte(cumulativePayout.String()), "balance", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte("0"), "paidOut", xwcAddress(t, beneficiary)),
+			),
+		),
+		func(c *chequebook.SignedCheque, cid int64) (common.Address, error) {
+			return issuer, nil
+		})
+
+	// the check is called once the cheque was verified
+	errCheck := errors.New("check failed")
+	_, err := chequestore.ReceiveCheque(context.Background(), cheque, func(c *chequebook.SignedCheque, amount *big.Int) error {
+		if amount.Cmp(cumulativePayout) != 0 {
+			t.Fatalf("got amount %d, wanted %d", amount, cumulativePayout)
+		}
+		return errCheck
+	})
+	if !errors.Is(err, errCheck) {
+		t.Fatalf("got error %v, wanted %v", err, errCheck)
+	}
+
+	// the rejected cheque is not stored
+	_, err = chequestore.LastCheque(chequebookAddress)
+	if !errors.Is(err, chequebook.ErrNoCheque) {
+		t.Fatalf("got error %v, wanted %v", err, chequebook.ErrNoCheque)
+	}
+}
+
+func TestChequebookToken(t *testing.T) {
+	defaultToken := common.HexToAddress("0xaaaa")
+	otherToken := common.HexToAddress("0xbbbb")
+	defaultChequebook := common.HexToAddress("0xeeee")
+	otherChequebook := common.HexToAddress("0xdddd")
+
+	newFactory := func(token, deployed common.Address) chequebook.Factory {
+		return &factoryMock{
+			erc20Address: func(ctx context.Context) (common.Address, error) {
+				return token, nil
+			},
+			verifyChequebook: func(ctx context.Context, address common.Address) error {
+				if address != deployed {
+					return chequebook.ErrNotDeployedByFactory
+				}
+				return nil
+			},
+		}
+	}
+
+	chequestore := chequebook.NewChequeStore(
+		storemock.NewStateStore(),
+		newFactory(defaultToken, defaultChequebook),
+		1,
+		common.HexToAddress("0xffff"),
+		transactionmock.New(),
+		func(c *chequebook.SignedCheque, cid int64) (common.Address, error) {
+			return common.Address{}, nil
+		},
+		newFactory(otherToken, otherChequebook),
+	)
+
+	for chequebookAddress, want := range map[common.Address]common.Address{
+		defaultChequebook: defaultToken,
+		otherChequebook:   otherToken,
+	} {
+		token, err := chequestore.ChequebookToken(context.Background(), chequebookAddress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != want {
+			t.Fatalf("got token %x for chequebook %x, want %x", token, chequebookAddress, want)
+		}
+	}
+
+	_, err := chequestore.ChequebookToken(context.Background(), common.HexToAddress("0xcccc"))
+	if !errors.Is(err, chequebook.ErrNotDeployedByFactory) {
+		t.Fatalf("got error %v, wanted %v", err, chequebook.ErrNotDeployedByFactory)
+	}
+}
+
 func TestReceiveChequeInvalidBeneficiary(t *testing.T) {
 	store := storemock.NewStateStore()
 	beneficiary := common.HexToAddress("0xffff")
@@ -145,7 +253,7 @@ func TestReceiveChequeInvalidBeneficiary(t *testing.T) {
 		nil,
 	)
 
-	_, err := chequestore.ReceiveCheque(context.Background(), cheque)
+	_, err := chequestore.ReceiveCheque(context.Background(), cheque, nil)
 	if err == nil {
 		t.Fatal("accepted cheque with wrong beneficiary")
 	}
@@ -174,10 +282,10 @@ func TestReceiveChequeInvalidAmount(t *testing.T) {
 		chainID,
 		beneficiary,
 		transactionmock.New(
-			transactionmock.WithABICallSequence(
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, issuer.Hash().Bytes(), "issuer"),
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, cumulativePayout.Bytes(), "balance"),
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, big.NewInt(0).Bytes(), "paidOut", beneficiary),
+			transactionmock.WithXwcCallSequence(
+				transactionmock.NewXwcCall(chequebookAddress, []byte(xwcAddress(t, issuer)), "issuer", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte(cumulativePayout.String()), "balance", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte("0"), "paidOut", xwcAddress(t, beneficiary)),
 			),
 		),
 		func(c *chequebook.SignedCheque, cid int64) (common.Address, error) {
@@ -191,7 +299,7 @@ func TestReceiveChequeInvalidAmount(t *testing.T) {
 			Chequebook:       chequebookAddress,
 		},
 		Signature: sig,
-	})
+	}, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -203,7 +311,7 @@ func TestReceiveChequeInvalidAmount(t *testing.T) {
 			Chequebook:       chequebookAddress,
 		},
 		Signature: sig,
-	})
+	}, nil)
 	if err == nil {
 		t.Fatal("accepted lower amount cheque")
 	}
@@ -212,6 +320,85 @@ func TestReceiveChequeInvalidAmount(t *testing.T) {
 	}
 }
 
+func TestReceiveChequeReissued(t *testing.T) {
+	store := storemock.NewStateStore()
+	beneficiary := common.HexToAddress("0xffff")
+	issuer := common.HexToAddress("0xbeee")
+	newIssuer := common.HexToAddress("0xbeef")
+	cumulativePayout := big.NewInt(10)
+	chequebookAddress := common.HexToAddress("0xeeee")
+	sig := make([]byte, 65)
+	newSig := bytes.Repeat([]byte{1}, 65)
+	chainID := int64(1)
+
+	chequestore := chequebook.NewChequeStore(
+		store,
+		&factoryMock{
+			verifyChequebook: func(ctx context.Context, address common.Address) error {
+				return nil
+			},
+		},
+		chainID,
+		beneficiary,
+		transactionmock.New(
+			transactionmock.WithXwcCallSequence(
+				transactionmock.NewXwcCall(chequebookAddress, []byte(xwcAddress(t, issuer)), "issuer", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte(cumulativePayout.String()), "balance", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte("0"), "paidOut", xwcAddress(t, beneficiary)),
+				transactionmock.NewXwcCall(chequebookAddress, []byte(xwcAddress(t, newIssuer)), "issuer", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte(cumulativePayout.String()), "balance", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte("0"), "paidOut", xwcAddress(t, beneficiary)),
+			),
+		),
+		func(c *chequebook.SignedCheque, cid int64) (common.Address, error) {
+			if bytes.Equal(c.Signature, newSig) {
+				return newIssuer, nil
+			}
+			return issuer, nil
+		})
+
+	cheque := &chequebook.SignedCheque{
+		Cheque: chequebook.Cheque{
+			Beneficiary:      beneficiary,
+			CumulativePayout: cumulativePayout,
+			Chequebook:       chequebookAddress,
+		},
+		Signature: sig,
+	}
+
+	_, err := chequestore.ReceiveCheque(context.Background(), cheque, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same cheque again is not accepted
+	_, err = chequestore.ReceiveCheque(context.Background(), cheque, nil)
+	if !errors.Is(err, chequebook.ErrChequeNotIncreasing) {
+		t.Fatalf("wrong error. wanted %v, got %v", chequebook.ErrChequeNotIncreasing, err)
+	}
+
+	reissuedCheque := &chequebook.SignedCheque{
+		Cheque:    cheque.Cheque,
+		Signature: newSig,
+	}
+
+	amount, err := chequestore.ReceiveCheque(context.Background(), reissuedCheque, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amount.Sign() != 0 {
+		t.Fatalf("wrong amount for reissued cheque. wanted 0, got %d", amount)
+	}
+
+	lastCheque, err := chequestore.LastCheque(chequebookAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lastCheque.Equal(reissuedCheque) {
+		t.Fatalf("stored wrong cheque. wanted %v, got %v", reissuedCheque, lastCheque)
+	}
+}
+
 func TestReceiveChequeInvalidChequebook(t *testing.T) {
 	store := storemock.NewStateStore()
 	beneficiary := common.HexToAddress("0xffff")
@@ -231,9 +418,9 @@ func TestReceiveChequeInvalidChequebook(t *testing.T) {
 		chainID,
 		beneficiary,
 		transactionmock.New(
-			transactionmock.WithABICallSequence(
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, issuer.Bytes(), "issuer"),
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, cumulativePayout.Bytes(), "balance"),
+			transactionmock.WithXwcCallSequence(
+				transactionmock.NewXwcCall(chequebookAddress, []byte(xwcAddress(t, issuer)), "issuer", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte(cumulativePayout.String()), "balance", ""),
 			),
 		),
 		func(c *chequebook.SignedCheque, cid int64) (common.Address, error) {
@@ -247,7 +434,7 @@ func TestReceiveChequeInvalidChequebook(t *testing.T) {
 			Chequebook:       chequebookAddress,
 		},
 		Signature: sig,
-	})
+	}, nil)
 	if !errors.Is(err, chequebook.ErrNotDeployedByFactory) {
 		t.Fatalf("wrong error. wanted %v, got %v", chequebook.ErrNotDeployedByFactory, err)
 	}
@@ -272,8 +459,8 @@ func TestReceiveChequeInvalidSignature(t *testing.T) {
 		chainID,
 		beneficiary,
 		transactionmock.New(
-			transactionmock.WithABICallSequence(
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, issuer.Hash().Bytes(), "issuer"),
+			transactionmock.WithXwcCallSequence(
+				transactionmock.NewXwcCall(chequebookAddress, []byte(xwcAddress(t, issuer)), "issuer", ""),
 			),
 		),
 		func(c *chequebook.SignedCheque, cid int64) (common.Address, error) {
@@ -287,7 +474,7 @@ func TestReceiveChequeInvalidSignature(t *testing.T) {
 			Chequebook:       chequebookAddress,
 		},
 		Signature: sig,
-	})
+	}, nil)
 	if !errors.Is(err, chequebook.ErrChequeInvalid) {
 		t.Fatalf("wrong error. wanted %v, got %v", chequebook.ErrChequeInvalid, err)
 	}
@@ -312,10 +499,10 @@ func TestReceiveChequeInsufficientBalance(t *testing.T) {
 		chainID,
 		beneficiary,
 		transactionmock.New(
-			transactionmock.WithABICallSequence(
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, issuer.Hash().Bytes(), "issuer"),
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, new(big.Int).Sub(cumulativePayout, big.NewInt(1)).Bytes(), "balance"),
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, big.NewInt(0).Bytes(), "paidOut", beneficiary),
+			transactionmock.WithXwcCallSequence(
+				transactionmock.NewXwcCall(chequebookAddress, []byte(xwcAddress(t, issuer)), "issuer", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte(new(big.Int).Sub(cumulativePayout, big.NewInt(1)).String()), "balance", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte("0"), "paidOut", xwcAddress(t, beneficiary)),
 			),
 		),
 		func(c *chequebook.SignedCheque, cid int64) (common.Address, error) {
@@ -329,7 +516,7 @@ func TestReceiveChequeInsufficientBalance(t *testing.T) {
 			Chequebook:       chequebookAddress,
 		},
 		Signature: sig,
-	})
+	}, nil)
 	if !errors.Is(err, chequebook.ErrBouncingCheque) {
 		t.Fatalf("wrong error. wanted %v, got %v", chequebook.ErrBouncingCheque, err)
 	}
@@ -354,10 +541,10 @@ func TestReceiveChequeSufficientBalancePaidOut(t *testing.T) {
 		chainID,
 		beneficiary,
 		transactionmock.New(
-			transactionmock.WithABICallSequence(
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, issuer.Hash().Bytes(), "issuer"),
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, new(big.Int).Sub(cumulativePayout, big.NewInt(100)).Bytes(), "balance"),
-				transactionmock.ABICall(&chequebookABI, chequebookAddress, big.NewInt(0).Bytes(), "paidOut", beneficiary),
+			transactionmock.WithXwcCallSequence(
+				transactionmock.NewXwcCall(chequebookAddress, []byte(xwcAddress(t, issuer)), "issuer", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte(new(big.Int).Sub(cumulativePayout, big.NewInt(100)).String()), "balance", ""),
+				transactionmock.NewXwcCall(chequebookAddress, []byte("100"), "paidOut", xwcAddress(t, beneficiary)),
 			),
 		),
 		func(c *chequebook.SignedCheque, cid int64) (common.Address, error) {
@@ -371,7 +558,7 @@ func TestReceiveChequeSufficientBalancePaidOut(t *testing.T) {
 			Chequebook:       chequebookAddress,
 		},
 		Signature: sig,
-	})
+	}, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,14 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chequebook
+
+import "time"
+
+// ChequeTypedData returns the eip712 typed data of a cheque.
+var ChequeTypedData = eip712DataForCheque
+
+func SetRiskEngineNow(r RiskEngine, now func() time.Time) {
+	r.(*riskEngine).now = now
+}
@@ -105,6 +105,40 @@ func Init(
 	chainId int64,
 	overlayXwcAddress common.Address,
 	chequeSigner ChequeSigner,
+) (chequebookService Service, err error) {
+	return initChequebook(ctx, chequebookFactory, stateStore, logger, swapInitialDeposit, transactionService, swapBackend, chainId, overlayXwcAddress, chequeSigner, true)
+}
+
+// InitForToken will initialize the chequebook service for the token of a
+// factory other than the default one. The state of the chequebook is kept
+// apart from the chequebook of the default token.
+func InitForToken(
+	ctx context.Context,
+	chequebookFactory Factory,
+	stateStore storage.StateStorer,
+	logger logging.Logger,
+	swapInitialDeposit *big.Int,
+	transactionService transaction.Service,
+	swapBackend transaction.Backend,
+	chainId int64,
+	overlayXwcAddress common.Address,
+	chequeSigner ChequeSigner,
+) (chequebookService Service, err error) {
+	return initChequebook(ctx, chequebookFactory, stateStore, logger, swapInitialDeposit, transactionService, swapBackend, chainId, overlayXwcAddress, chequeSigner, false)
+}
+
+func initChequebook(
+	ctx context.Context,
+	chequebookFactory Factory,
+	stateStore storage.StateStorer,
+	logger logging.Logger,
+	swapInitialDeposit *big.Int,
+	transactionService transaction.Service,
+	swapBackend transaction.Backend,
+	chainId int64,
+	overlayXwcAddress common.Address,
+	chequeSigner ChequeSigner,
+	defaultToken bool,
 ) (chequebookService Service, err error) {
 	// verify that the supplied factory is valid
 	err = chequebookFactory.VerifyBytecode(ctx)
@@ -122,6 +156,15 @@ func Init(
 
 	erc20Service := erc20.New(swapBackend, transactionService, erc20Address)
 
+	newService := New
+	keyPrefix := chequebookKeyPrefix
+	deploymentKey := ChequebookDeploymentKey
+	if !defaultToken {
+		newService = NewForToken
+		keyPrefix = tokenKeyPrefix(erc20Address)
+		deploymentKey = keyPrefix + "transaction_deployment"
+	}
+
 	/////////////////////////////////////////////////////////////
 	//var chequebookAddress common.Address
 	//err = stateStore.Get(chequebookKey, &chequebookAddress)
@@ -215,7 +258,7 @@ func Init(
 	//}
 	///////////////////////////////////////////////////////////////
 
-	p, err := chequebookFactory.QueryUserChequeBook(ctx, overlayXwcAddress)
+	p, err := findChequebook(ctx, chequebookFactory, stateStore, keyPrefix, overlayXwcAddress)
 	if err != nil {
 		return nil, err
 	}
@@ -223,7 +266,7 @@ func Init(
 	if p == nil {
 		// chequebook contract not exist
 		var txHash common.Hash
-		err = stateStore.Get(ChequebookDeploymentKey, &txHash)
+		err = stateStore.Get(deploymentKey, &txHash)
 		if err != nil && err != storage.ErrNotFound {
 			return nil, err
 		}
@@ -257,7 +300,7 @@ func Init(
 				}
 				logger.Infof("deploying new chequebook in transaction %x", txHash[12:])
 
-				err = stateStore.Put(ChequebookDeploymentKey, txHash)
+				err = stateStore.Put(deploymentKey, txHash)
 				if err != nil {
 					return nil, err
 				}
@@ -283,7 +326,7 @@ func Init(
 				}
 			} else {
 				chequebookAddress := *p
-				chequebookService, err = New(transactionService, chequebookAddress, overlayXwcAddress, stateStore, chequeSigner, erc20Service)
+				chequebookService, err = newService(transactionService, chequebookAddress, overlayXwcAddress, stateStore, chequeSigner, erc20Service)
 				if err != nil {
 					return nil, err
 				}
@@ -297,7 +340,7 @@ func Init(
 	} else {
 		// chequebook contract exist
 		chequebookAddress := *p
-		chequebookService, err = New(transactionService, chequebookAddress, overlayXwcAddress, stateStore, chequeSigner, erc20Service)
+		chequebookService, err = newService(transactionService, chequebookAddress, overlayXwcAddress, stateStore, chequeSigner, erc20Service)
 		if err != nil {
 			return nil, err
 		}
@@ -319,3 +362,88 @@ func Init(
 
 	return chequebookService, nil
 }
+
+// findChequebook looks up the chequebook of the owner through the factory and
+// falls back to a chequebook transferred to the owner. It returns nil if
+// neither exists.
+func findChequebook(ctx context.Context, chequebookFactory Factory, stateStore storage.StateStorer, keyPrefix string, owner common.Address) (*common.Address, error) {
+	p, err := chequebookFactory.QueryUserChequeBook(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		return p, nil
+	}
+	return transferredChequebook(stateStore, keyPrefix)
+}
+
+// Open returns the service of the existing chequebook of the owner for the
+// default token. Unlike Init it never deploys a new chequebook.
+func Open(
+	ctx context.Context,
+	chequebookFactory Factory,
+	stateStore storage.StateStorer,
+	transactionService transaction.Service,
+	swapBackend transaction.Backend,
+	overlayXwcAddress common.Address,
+	chequeSigner ChequeSigner,
+) (chequebookService Service, err error) {
+	return openChequebook(ctx, chequebookFactory, stateStore, transactionService, swapBackend, overlayXwcAddress, chequeSigner, true)
+}
+
+// OpenForToken returns the service of the existing chequebook of the owner
+// for the token of a factory other than the default one.
+func OpenForToken(
+	ctx context.Context,
+	chequebookFactory Factory,
+	stateStore storage.StateStorer,
+	transactionService transaction.Service,
+	swapBackend transaction.Backend,
+	overlayXwcAddress common.Address,
+	chequeSigner ChequeSigner,
+) (chequebookService Service, err error) {
+	return openChequebook(ctx, chequebookFactory, stateStore, transactionService, swapBackend, overlayXwcAddress, chequeSigner, false)
+}
+
+func openChequebook(
+	ctx context.Context,
+	chequebookFactory Factory,
+	stateStore storage.StateStorer,
+	transactionService transaction.Service,
+	swapBackend transaction.Backend,
+	overlayXwcAddress common.Address,
+	chequeSigner ChequeSigner,
+	defaultToken bool,
+) (chequebookService Service, err error) {
+	err = chequebookFactory.VerifyBytecode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	erc20Address, err := chequebookFactory.ERC20Address(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	newService := New
+	keyPrefix := chequebookKeyPrefix
+	if !defaultToken {
+		newService = NewForToken
+		keyPrefix = tokenKeyPrefix(erc20Address)
+	}
+
+	p, err := findChequebook(ctx, chequebookFactory, stateStore, keyPrefix, overlayXwcAddress)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrNoChequebook
+	}
+
+	err = chequebookFactory.VerifyChequebookOwner(ctx, *p, overlayXwcAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return newService(transactionService, *p, overlayXwcAddress, stateStore, chequeSigner, erc20.New(swapBackend, transactionService, erc20Address))
+}
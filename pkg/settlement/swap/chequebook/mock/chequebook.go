@@ -15,12 +15,16 @@ import (
 
 // Service is the mock chequebook service.
 type Service struct {
-	chequebookBalanceFunc          func(context.Context) (*big.Int, error)
-	chequebookAvailableBalanceFunc func(context.Context) (*big.Int, error)
-	chequebookAddressFunc          func() common.Address
-	chequebookIssueFunc            func(ctx context.Context, beneficiary common.Address, amount *big.Int, sendChequeFunc chequebook.SendChequeFunc) (*big.Int, error)
-	chequebookWithdrawFunc         func(ctx context.Context, amount *big.Int) (hash common.Hash, err error)
-	chequebookDepositFunc          func(ctx context.Context, amount *big.Int) (hash common.Hash, err error)
+	chequebookBalanceFunc           func(context.Context) (*big.Int, error)
+	chequebookAvailableBalanceFunc  func(context.Context) (*big.Int, error)
+	chequebookAddressFunc           func() common.Address
+	chequebookTokenFunc             func() common.Address
+	chequebookIssueFunc             func(ctx context.Context, beneficiary common.Address, amount *big.Int, sendChequeFunc chequebook.SendChequeFunc, persistFunc chequebook.PersistFunc) (*big.Int, error)
+	chequebookWithdrawFunc          func(ctx context.Context, amount *big.Int) (hash common.Hash, err error)
+	chequebookDepositFunc           func(ctx context.Context, amount *big.Int) (hash common.Hash, err error)
+	chequebookTransferOwnershipFunc func(ctx context.Context, newOwner common.Address, newSigner chequebook.ChequeSigner, dryRun bool) (*chequebook.OwnershipTransfer, error)
+	chequebookReissuedChequeFunc    func(beneficiary common.Address) (*chequebook.SignedCheque, error)
+	chequebookReissuedDeliveredFunc func(beneficiary common.Address) error
 }
 
 // WithChequebook*Functions set the mock chequebook functions
@@ -42,13 +46,19 @@ func WithChequebookAddressFunc(f func() common.Address) Option {
 	})
 }
 
+func WithChequebookTokenFunc(f func() common.Address) Option {
+	return optionFunc(func(s *Service) {
+		s.chequebookTokenFunc = f
+	})
+}
+
 func WithChequebookDepositFunc(f func(ctx context.Context, amount *big.Int) (hash common.Hash, err error)) Option {
 	return optionFunc(func(s *Service) {
 		s.chequebookDepositFunc = f
 	})
 }
 
-func WithChequebookIssueFunc(f func(ctx context.Context, beneficiary common.Address, amount *big.Int, sendChequeFunc chequebook.SendChequeFunc) (*big.Int, error)) Option {
+func WithChequebookIssueFunc(f func(ctx context.Context, beneficiary common.Address, amount *big.Int, sendChequeFunc chequebook.SendChequeFunc, persistFunc chequebook.PersistFunc) (*big.Int, error)) Option {
 	return optionFunc(func(s *Service) {
 		s.chequebookIssueFunc = f
 	})
@@ -60,6 +70,24 @@ func WithChequebookWithdrawFunc(f func(ctx context.Context, amount *big.Int) (ha
 	})
 }
 
+func WithChequebookTransferOwnershipFunc(f func(ctx context.Context, newOwner common.Address, newSigner chequebook.ChequeSigner, dryRun bool) (*chequebook.OwnershipTransfer, error)) Option {
+	return optionFunc(func(s *Service) {
+		s.chequebookTransferOwnershipFunc = f
+	})
+}
+
+func WithChequebookReissuedChequeFunc(f func(beneficiary common.Address) (*chequebook.SignedCheque, error)) Option {
+	return optionFunc(func(s *Service) {
+		s.chequebookReissuedChequeFunc = f
+	})
+}
+
+func WithChequebookReissuedChequeDeliveredFunc(f func(beneficiary common.Address) error) Option {
+	return optionFunc(func(s *Service) {
+		s.chequebookReissuedDeliveredFunc = f
+	})
+}
+
 // NewChequebook creates the mock chequebook implementation
 func NewChequebook(opts ...Option) chequebook.Service {
 	mock := new(Service)
@@ -105,9 +133,17 @@ func (s *Service) Address() common.Address {
 	return common.Address{}
 }
 
-func (s *Service) Issue(ctx context.Context, beneficiary common.Address, amount *big.Int, sendChequeFunc chequebook.SendChequeFunc) (*big.Int, error) {
+// Token mocks the chequebook .Token function
+func (s *Service) Token() common.Address {
+	if s.chequebookTokenFunc != nil {
+		return s.chequebookTokenFunc()
+	}
+	return common.Address{}
+}
+
+func (s *Service) Issue(ctx context.Context, beneficiary common.Address, amount *big.Int, sendChequeFunc chequebook.SendChequeFunc, persistFunc chequebook.PersistFunc) (*big.Int, error) {
 	if s.chequebookIssueFunc != nil {
-		return s.chequebookIssueFunc(ctx, beneficiary, amount, sendChequeFunc)
+		return s.chequebookIssueFunc(ctx, beneficiary, amount, sendChequeFunc, persistFunc)
 	}
 	return big.NewInt(0), nil
 }
@@ -124,6 +160,27 @@ func (s *Service) Withdraw(ctx context.Context, amount *big.Int) (hash common.Ha
 	return s.chequebookWithdrawFunc(ctx, amount)
 }
 
+func (s *Service) TransferOwnership(ctx context.Context, newOwner common.Address, newSigner chequebook.ChequeSigner, dryRun bool) (*chequebook.OwnershipTransfer, error) {
+	if s.chequebookTransferOwnershipFunc != nil {
+		return s.chequebookTransferOwnershipFunc(ctx, newOwner, newSigner, dryRun)
+	}
+	return nil, errors.New("Error")
+}
+
+func (s *Service) ReissuedCheque(beneficiary common.Address) (*chequebook.SignedCheque, error) {
+	if s.chequebookReissuedChequeFunc != nil {
+		return s.chequebookReissuedChequeFunc(beneficiary)
+	}
+	return nil, chequebook.ErrNoCheque
+}
+
+func (s *Service) ReissuedChequeDelivered(beneficiary common.Address) error {
+	if s.chequebookReissuedDeliveredFunc != nil {
+		return s.chequebookReissuedDeliveredFunc(beneficiary)
+	}
+	return nil
+}
+
 // Option is the option passed to the mock Chequebook service
 type Option interface {
 	apply(*Service)
@@ -0,0 +1,80 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mock
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook"
+)
+
+// RiskEngine is the mock RiskEngine. Without options every cheque is
+// assessed as low risk and no cashout bounces.
+type RiskEngine struct {
+	assess        func(ctx context.Context, cheque *chequebook.SignedCheque) (*chequebook.RiskAssessment, error)
+	recordCheque  func(assessment *chequebook.RiskAssessment) error
+	notifyCashout func(chequebook common.Address, txHash common.Hash, result *chequebook.CashChequeResult) (bool, error)
+}
+
+func WithAssessFunc(f func(ctx context.Context, cheque *chequebook.SignedCheque) (*chequebook.RiskAssessment, error)) RiskOption {
+	return riskOptionFunc(func(r *RiskEngine) {
+		r.assess = f
+	})
+}
+
+func WithRecordChequeFunc(f func(assessment *chequebook.RiskAssessment) error) RiskOption {
+	return riskOptionFunc(func(r *RiskEngine) {
+		r.recordCheque = f
+	})
+}
+
+func WithNotifyCashoutFunc(f func(chequebook common.Address, txHash common.Hash, result *chequebook.CashChequeResult) (bool, error)) RiskOption {
+	return riskOptionFunc(func(r *RiskEngine) {
+		r.notifyCashout = f
+	})
+}
+
+// NewRiskEngine creates the mock RiskEngine implementation
+func NewRiskEngine(opts ...RiskOption) chequebook.RiskEngine {
+	mock := new(RiskEngine)
+	for _, o := range opts {
+		o.apply(mock)
+	}
+	return mock
+}
+
+func (r *RiskEngine) Assess(ctx context.Context, cheque *chequebook.SignedCheque) (*chequebook.RiskAssessment, error) {
+	if r.assess != nil {
+		return r.assess(ctx, cheque)
+	}
+	return &chequebook.RiskAssessment{
+		Chequebook: cheque.Chequebook,
+		Level:      chequebook.RiskLow,
+	}, nil
+}
+
+func (r *RiskEngine) RecordCheque(assessment *chequebook.RiskAssessment) error {
+	if r.recordCheque != nil {
+		return r.recordCheque(assessment)
+	}
+	return nil
+}
+
+func (r *RiskEngine) NotifyCashout(chequebook common.Address, txHash common.Hash, result *chequebook.CashChequeResult) (bool, error) {
+	if r.notifyCashout != nil {
+		return r.notifyCashout(chequebook, txHash, result)
+	}
+	return false, nil
+}
+
+// RiskOption is the option passed to the mock RiskEngine
+type RiskOption interface {
+	apply(*RiskEngine)
+}
+
+type riskOptionFunc func(*RiskEngine)
+
+func (f riskOptionFunc) apply(r *RiskEngine) { f(r) }
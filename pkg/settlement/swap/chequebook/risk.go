@@ -0,0 +1,331 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chequebook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/penguintop/penguin/pkg/storage"
+	"github.com/penguintop/penguin/pkg/transaction"
+)
+
+const (
+	riskExposurePrefix = "swap_risk_exposure_"
+	riskBouncesPrefix  = "swap_risk_bounces_"
+	riskCashoutPrefix  = "swap_risk_cashout_"
+
+	// DefaultCollateralFactor is the factor by which the balance of a
+	// chequebook should exceed our uncashed exposure to it.
+	DefaultCollateralFactor = 2
+	// BounceDecayPeriod is the time after which one bounce of a chequebook
+	// is no longer counted.
+	BounceDecayPeriod = 30 * 24 * time.Hour
+)
+
+// ErrExposureLimit is returned if accepting a cheque would raise our total
+// uncashed exposure above the limit of the risk engine.
+var ErrExposureLimit = errors.New("total exposure limit exceeded")
+
+// RiskLevel classifies how likely cheques of a chequebook are to bounce.
+type RiskLevel int
+
+const (
+	// RiskLow is the level of chequebooks that comfortably cover our exposure.
+	RiskLow RiskLevel = iota
+	// RiskElevated is the level of chequebooks that cover our exposure but
+	// not by the collateral factor.
+	RiskElevated
+	// RiskHigh is the level of chequebooks that can not cover our exposure or
+	// have bounced cheques before.
+	RiskHigh
+)
+
+func (l RiskLevel) String() string {
+	switch l {
+	case RiskLow:
+		return "low"
+	case RiskElevated:
+		return "elevated"
+	case RiskHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("RiskLevel(%d)", int(l))
+	}
+}
+
+// RiskAssessment is the evaluation of a received cheque.
+type RiskAssessment struct {
+	Chequebook common.Address
+	// Balance is the on-chain balance of the chequebook.
+	Balance *big.Int
+	// Exposure is the amount of the chequebook we could not cash yet,
+	// including the assessed cheque.
+	Exposure *big.Int
+	// TotalExposure is the amount of all chequebooks we could not cash yet,
+	// including the assessed cheque.
+	TotalExposure *big.Int
+	// Bounces is the number of our recent cashouts of the chequebook that
+	// bounced. Bounces are forgiven one per BounceDecayPeriod.
+	Bounces uint64
+	Level   RiskLevel
+}
+
+// RiskEngine evaluates the risk of received cheques.
+type RiskEngine interface {
+	// Assess evaluates the risk of accepting the cheque. It does not record
+	// anything. ErrExposureLimit is returned if the cheque would raise the
+	// total exposure above the limit.
+	Assess(ctx context.Context, cheque *SignedCheque) (*RiskAssessment, error)
+	// RecordCheque records the exposure of an accepted cheque.
+	RecordCheque(assessment *RiskAssessment) error
+	// NotifyCashout records the result of a cashout of the chequebook. It
+	// reports whether the cashout bounced and was not seen before.
+	NotifyCashout(chequebook common.Address, txHash common.Hash, result *CashChequeResult) (bounced bool, err error)
+}
+
+type riskEngine struct {
+	lock               sync.Mutex
+	store              storage.StateStorer
+	transactionService transaction.Service
+	beneficiary        common.Address
+	collateralFactor   *big.Int
+	maxTotalExposure   *big.Int
+	totalExposure      *big.Int // sum of the recorded exposures, loaded on first use
+	now                func() time.Time
+}
+
+// riskBounces is the stored bounce count of a chequebook.
+type riskBounces struct {
+	Count     uint64
+	Timestamp int64 // unix time of the last bounce
+}
+
+// NewRiskEngine creates a new RiskEngine for cheques paid to the beneficiary.
+// Chequebooks are considered at elevated risk unless their balance exceeds
+// our exposure by the collateral factor. Cheques which would raise the total
+// exposure over all chequebooks above maxTotalExposure are not accepted, a nil
+// maxTotalExposure does not limit it.
+func NewRiskEngine(store storage.StateStorer, transactionService transaction.Service, beneficiary common.Address, collateralFactor int64, maxTotalExposure *big.Int) RiskEngine {
+	return &riskEngine{
+		store:              store,
+		transactionService: transactionService,
+		beneficiary:        beneficiary,
+		collateralFactor:   big.NewInt(collateralFactor),
+		maxTotalExposure:   maxTotalExposure,
+		now:                time.Now,
+	}
+}
+
+func riskExposureKey(chequebook common.Address) string {
+	return fmt.Sprintf("%s%x", riskExposurePrefix, chequebook)
+}
+
+func riskBouncesKey(chequebook common.Address) string {
+	return fmt.Sprintf("%s%x", riskBouncesPrefix, chequebook)
+}
+
+func riskCashoutKey(chequebook common.Address) string {
+	return fmt.Sprintf("%s%x", riskCashoutPrefix, chequebook)
+}
+
+// exposure returns the recorded exposure of the chequebook.
+func (r *riskEngine) exposure(chequebook common.Address) (*big.Int, error) {
+	var exposure *big.Int
+	err := r.store.Get(riskExposureKey(chequebook), &exposure)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return big.NewInt(0), nil
+		}
+		return nil, err
+	}
+	return exposure, nil
+}
+
+// total returns the sum of the recorded exposures of all chequebooks. It is
+// summed up from the store on first use and kept up to date afterwards.
+func (r *riskEngine) total() (*big.Int, error) {
+	if r.totalExposure != nil {
+		return r.totalExposure, nil
+	}
+
+	total := big.NewInt(0)
+	err := r.store.Iterate(riskExposurePrefix, func(key, _ []byte) (stop bool, err error) {
+		var exposure *big.Int
+		if err := r.store.Get(string(key), &exposure); err != nil {
+			return true, fmt.Errorf("get exposure %s: %w", string(key), err)
+		}
+		total.Add(total, exposure)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.totalExposure = total
+	return total, nil
+}
+
+// putExposure records the exposure of the chequebook and updates the total.
+func (r *riskEngine) putExposure(chequebook common.Address, exposure *big.Int) error {
+	total, err := r.total()
+	if err != nil {
+		return err
+	}
+	previous, err := r.exposure(chequebook)
+	if err != nil {
+		return err
+	}
+
+	err = r.store.Put(riskExposureKey(chequebook), exposure)
+	if err != nil {
+		return err
+	}
+
+	total.Sub(total, previous)
+	total.Add(total, exposure)
+	return nil
+}
+
+// bounces returns the number of bounces of the chequebook which have not
+// decayed yet.
+func (r *riskEngine) bounces(chequebook common.Address) (uint64, error) {
+	var b riskBounces
+	err := r.store.Get(riskBouncesKey(chequebook), &b)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	decayed := uint64(r.now().Sub(time.Unix(b.Timestamp, 0)) / BounceDecayPeriod)
+	if decayed >= b.Count {
+		return 0, nil
+	}
+	return b.Count - decayed, nil
+}
+
+// Assess evaluates the risk of accepting the cheque.
+func (r *riskEngine) Assess(ctx context.Context, cheque *SignedCheque) (*RiskAssessment, error) {
+	contract := newChequebookContract(cheque.Chequebook, r.transactionService)
+
+	balance, err := contract.Balance(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	paidOut, err := contract.PaidOut(ctx, r.beneficiary)
+	if err != nil {
+		return nil, err
+	}
+
+	exposure := new(big.Int).Sub(cheque.CumulativePayout, paidOut)
+	if exposure.Sign() < 0 {
+		exposure.SetInt64(0)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	bounces, err := r.bounces(cheque.Chequebook)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := r.total()
+	if err != nil {
+		return nil, err
+	}
+	previous, err := r.exposure(cheque.Chequebook)
+	if err != nil {
+		return nil, err
+	}
+	totalExposure := new(big.Int).Sub(total, previous)
+	totalExposure.Add(totalExposure, exposure)
+	if r.maxTotalExposure != nil && totalExposure.Cmp(r.maxTotalExposure) > 0 {
+		return nil, fmt.Errorf("total exposure %d above %d: %w", totalExposure, r.maxTotalExposure, ErrExposureLimit)
+	}
+
+	level := RiskLow
+	switch {
+	case bounces > 0 || balance.Cmp(exposure) < 0:
+		level = RiskHigh
+	case balance.Cmp(new(big.Int).Mul(exposure, r.collateralFactor)) < 0:
+		level = RiskElevated
+	}
+
+	return &RiskAssessment{
+		Chequebook:    cheque.Chequebook,
+		Balance:       balance,
+		Exposure:      exposure,
+		TotalExposure: totalExposure,
+		Bounces:       bounces,
+		Level:         level,
+	}, nil
+}
+
+// RecordCheque records the exposure of an accepted cheque.
+func (r *riskEngine) RecordCheque(assessment *RiskAssessment) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.putExposure(assessment.Chequebook, assessment.Exposure)
+}
+
+// NotifyCashout records the result of a cashout of the chequebook.
+func (r *riskEngine) NotifyCashout(chequebook common.Address, txHash common.Hash, result *CashChequeResult) (bounced bool, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var lastTxHash common.Hash
+	err = r.store.Get(riskCashoutKey(chequebook), &lastTxHash)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return false, err
+	}
+	if err == nil && lastTxHash == txHash {
+		return false, nil
+	}
+
+	exposure, err := r.exposure(chequebook)
+	if err != nil {
+		return false, err
+	}
+	if exposure.Sign() > 0 && result.TotalPayout != nil {
+		exposure = new(big.Int).Sub(exposure, result.TotalPayout)
+		if exposure.Sign() < 0 {
+			exposure.SetInt64(0)
+		}
+		err = r.putExposure(chequebook, exposure)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if result.Bounced {
+		bounces, err := r.bounces(chequebook)
+		if err != nil {
+			return false, err
+		}
+		err = r.store.Put(riskBouncesKey(chequebook), riskBounces{
+			Count:     bounces + 1,
+			Timestamp: r.now().Unix(),
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = r.store.Put(riskCashoutKey(chequebook), txHash)
+	if err != nil {
+		return false, err
+	}
+
+	return result.Bounced, nil
+}
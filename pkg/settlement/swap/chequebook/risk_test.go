@@ -0,0 +1,266 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chequebook_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook"
+	storemock "github.com/penguintop/penguin/pkg/statestore/mock"
+	"github.com/penguintop/penguin/pkg/transaction"
+	transactionmock "github.com/penguintop/penguin/pkg/transaction/mock"
+)
+
+func riskTransactionService(t *testing.T, balance, paidOut *big.Int) transaction.Service {
+	t.Helper()
+	return transactionmock.New(
+		transactionmock.WithCallFunc(func(ctx context.Context, request *transaction.TxRequest) ([]byte, error) {
+			var callData struct {
+				CallApi string `json:"CallApi"`
+			}
+			if err := json.Unmarshal(request.Data, &callData); err != nil {
+				t.Fatal(err)
+			}
+			switch callData.CallApi {
+			case "balance":
+				return []byte(balance.String()), nil
+			case "paidOut":
+				return []byte(paidOut.String()), nil
+			}
+			return nil, fmt.Errorf("unexpected call %s", callData.CallApi)
+		}),
+	)
+}
+
+func TestRiskEngineAssess(t *testing.T) {
+	chequebookAddress := common.HexToAddress("0xabcd")
+	beneficiary := common.HexToAddress("0xdddd")
+
+	for _, tc := range []struct {
+		name    string
+		balance int64
+		paidOut int64
+		payout  int64
+		level   chequebook.RiskLevel
+	}{
+		{name: "covered", balance: 1000, paidOut: 0, payout: 500, level: chequebook.RiskLow},
+		{name: "partially cashed", balance: 100, paidOut: 450, payout: 500, level: chequebook.RiskLow},
+		{name: "under-collateralised", balance: 800, paidOut: 0, payout: 500, level: chequebook.RiskElevated},
+		{name: "uncovered", balance: 400, paidOut: 0, payout: 500, level: chequebook.RiskHigh},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			risk := chequebook.NewRiskEngine(
+				storemock.NewStateStore(),
+				riskTransactionService(t, big.NewInt(tc.balance), big.NewInt(tc.paidOut)),
+				beneficiary,
+				chequebook.DefaultCollateralFactor,
+				nil,
+			)
+
+			assessment, err := risk.Assess(context.Background(), &chequebook.SignedCheque{
+				Cheque: chequebook.Cheque{
+					Chequebook:       chequebookAddress,
+					Beneficiary:      beneficiary,
+					CumulativePayout: big.NewInt(tc.payout),
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if assessment.Level != tc.level {
+				t.Fatalf("got risk level %v, wanted %v", assessment.Level, tc.level)
+			}
+			exposure := big.NewInt(tc.payout - tc.paidOut)
+			if assessment.Exposure.Cmp(exposure) != 0 {
+				t.Fatalf("got exposure %d, wanted %d", assessment.Exposure, exposure)
+			}
+		})
+	}
+}
+
+func TestRiskEngineBounce(t *testing.T) {
+	chequebookAddress := common.HexToAddress("0xabcd")
+	beneficiary := common.HexToAddress("0xdddd")
+	txHash := common.HexToHash("0xffff")
+
+	risk := chequebook.NewRiskEngine(
+		storemock.NewStateStore(),
+		riskTransactionService(t, big.NewInt(10000), big.NewInt(0)),
+		beneficiary,
+		chequebook.DefaultCollateralFactor,
+		nil,
+	)
+
+	result := &chequebook.CashChequeResult{
+		Beneficiary:      beneficiary,
+		TotalPayout:      big.NewInt(0),
+		CumulativePayout: big.NewInt(500),
+		Bounced:          true,
+	}
+
+	bounced, err := risk.NotifyCashout(chequebookAddress, txHash, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bounced {
+		t.Fatal("expected bounce")
+	}
+
+	// the same cashout must only be counted once
+	bounced, err = risk.NotifyCashout(chequebookAddress, txHash, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounced {
+		t.Fatal("bounce reported twice")
+	}
+
+	assessment, err := risk.Assess(context.Background(), &chequebook.SignedCheque{
+		Cheque: chequebook.Cheque{
+			Chequebook:       chequebookAddress,
+			Beneficiary:      beneficiary,
+			CumulativePayout: big.NewInt(500),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if assessment.Bounces != 1 {
+		t.Fatalf("got %d bounces, wanted 1", assessment.Bounces)
+	}
+	if assessment.Level != chequebook.RiskHigh {
+		t.Fatalf("got risk level %v, wanted %v", assessment.Level, chequebook.RiskHigh)
+	}
+}
+
+func TestRiskEngineBounceDecay(t *testing.T) {
+	chequebookAddress := common.HexToAddress("0xabcd")
+	beneficiary := common.HexToAddress("0xdddd")
+
+	risk := chequebook.NewRiskEngine(
+		storemock.NewStateStore(),
+		riskTransactionService(t, big.NewInt(10000), big.NewInt(0)),
+		beneficiary,
+		chequebook.DefaultCollateralFactor,
+		nil,
+	)
+	now := time.Unix(1000000, 0)
+	chequebook.SetRiskEngineNow(risk, func() time.Time { return now })
+
+	for i := 0; i < 2; i++ {
+		_, err := risk.NotifyCashout(chequebookAddress, common.BigToHash(big.NewInt(int64(i))), &chequebook.CashChequeResult{
+			Beneficiary:      beneficiary,
+			TotalPayout:      big.NewInt(0),
+			CumulativePayout: big.NewInt(500),
+			Bounced:          true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		elapsed time.Duration
+		bounces uint64
+		level   chequebook.RiskLevel
+	}{
+		{elapsed: 0, bounces: 2, level: chequebook.RiskHigh},
+		{elapsed: chequebook.BounceDecayPeriod, bounces: 1, level: chequebook.RiskHigh},
+		{elapsed: 2 * chequebook.BounceDecayPeriod, bounces: 0, level: chequebook.RiskLow},
+	} {
+		now = time.Unix(1000000, 0).Add(tc.elapsed)
+
+		assessment, err := risk.Assess(context.Background(), &chequebook.SignedCheque{
+			Cheque: chequebook.Cheque{
+				Chequebook:       chequebookAddress,
+				Beneficiary:      beneficiary,
+				CumulativePayout: big.NewInt(500),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if assessment.Bounces != tc.bounces {
+			t.Fatalf("after %v got %d bounces, wanted %d", tc.elapsed, assessment.Bounces, tc.bounces)
+		}
+		if assessment.Level != tc.level {
+			t.Fatalf("after %v got risk level %v, wanted %v", tc.elapsed, assessment.Level, tc.level)
+		}
+	}
+}
+
+func TestRiskEngineTotalExposure(t *testing.T) {
+	chequebookA := common.HexToAddress("0xabcd")
+	chequebookB := common.HexToAddress("0xbcde")
+	beneficiary := common.HexToAddress("0xdddd")
+
+	risk := chequebook.NewRiskEngine(
+		storemock.NewStateStore(),
+		riskTransactionService(t, big.NewInt(10000), big.NewInt(0)),
+		beneficiary,
+		chequebook.DefaultCollateralFactor,
+		big.NewInt(1000),
+	)
+
+	assess := func(chequebookAddress common.Address, cumulativePayout int64) (*chequebook.RiskAssessment, error) {
+		return risk.Assess(context.Background(), &chequebook.SignedCheque{
+			Cheque: chequebook.Cheque{
+				Chequebook:       chequebookAddress,
+				Beneficiary:      beneficiary,
+				CumulativePayout: big.NewInt(cumulativePayout),
+			},
+		})
+	}
+
+	assessment, err := assess(chequebookA, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = risk.RecordCheque(assessment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = assess(chequebookB, 500)
+	if !errors.Is(err, chequebook.ErrExposureLimit) {
+		t.Fatalf("got error %v, wanted %v", err, chequebook.ErrExposureLimit)
+	}
+
+	// a later cheque of the same chequebook replaces its exposure
+	assessment, err = assess(chequebookA, 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assessment.TotalExposure.Cmp(big.NewInt(900)) != 0 {
+		t.Fatalf("got total exposure %d, wanted 900", assessment.TotalExposure)
+	}
+
+	_, err = risk.NotifyCashout(chequebookA, common.HexToHash("0xffff"), &chequebook.CashChequeResult{
+		Beneficiary:      beneficiary,
+		TotalPayout:      big.NewInt(600),
+		CumulativePayout: big.NewInt(600),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assessment, err = assess(chequebookB, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assessment.TotalExposure.Cmp(big.NewInt(500)) != 0 {
+		t.Fatalf("got total exposure %d, wanted 500", assessment.TotalExposure)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chequebook
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/penguintop/penguin/pkg/storage"
+	"github.com/penguintop/penguin/pkg/transaction"
+	"github.com/penguintop/penguin/pkg/xwcfmt"
+)
+
+var (
+	// ErrNoChequebook is returned if the owner has no chequebook.
+	ErrNoChequebook = errors.New("no chequebook found")
+	// ErrNotChequebookOwner is returned if the chequebook is not issued by the
+	// owner the service was created for.
+	ErrNotChequebookOwner = errors.New("not the chequebook owner")
+	// ErrSameOwner is returned if the ownership is transferred to the current owner.
+	ErrSameOwner = errors.New("chequebook already owned by new owner")
+	// ErrAlreadyTransferred is returned if the chequebook was transferred to
+	// the new owner by a previous transfer.
+	ErrAlreadyTransferred = errors.New("chequebook already transferred")
+)
+
+// OwnershipTransfer describes the changes made by a chequebook ownership transfer.
+type OwnershipTransfer struct {
+	Chequebook common.Address
+	OldOwner   common.Address
+	NewOwner   common.Address
+	// Cheques are the last issued cheques re-signed by the new owner.
+	Cheques []*SignedCheque
+	// TxHash is the hash of the transfer transaction. It is empty in a dry run
+	// and if the transaction was confirmed for an interrupted transfer.
+	TxHash common.Hash
+}
+
+// chequebookAddressKey computes the key under which the address of a
+// transferred chequebook is stored. The factory only knows the chequebook by
+// the address of the owner which deployed it.
+func chequebookAddressKey(keyPrefix string) string {
+	return keyPrefix + "address"
+}
+
+// transferredChequebook returns the chequebook transferred to this node, or
+// nil if there is none.
+func transferredChequebook(store storage.StateStorer, keyPrefix string) (*common.Address, error) {
+	var address common.Address
+	err := store.Get(chequebookAddressKey(keyPrefix), &address)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &address, nil
+}
+
+// pendingTransferKey computes the key under which the new owner of a
+// chequebook is stored while its ownership transfer is not completed.
+func pendingTransferKey(keyPrefix string) string {
+	return keyPrefix + "pending_transfer"
+}
+
+// reissuedChequeKey computes the key under which a re-signed cheque is kept
+// until it is delivered to the beneficiary.
+func (s *service) reissuedChequeKey(beneficiary common.Address) string {
+	return fmt.Sprintf("%s%x", s.reissuedChequeKeyPrefix, beneficiary)
+}
+
+// TransferOwnership transfers the chequebook to newOwner. The last issued
+// cheques are re-signed with newSigner so that the cumulative payouts remain
+// valid for the new issuer. The re-signed cheques are kept as reissued until
+// they are delivered to the beneficiaries. In a dry run the contract and the
+// state store are left untouched.
+//
+// The new owner is recorded before the transaction is sent, so that a transfer
+// interrupted after the transaction was confirmed is completed by the next
+// call without sending it again. ErrAlreadyTransferred is returned if the
+// chequebook was transferred to newOwner before.
+func (s *service) TransferOwnership(ctx context.Context, newOwner common.Address, newSigner ChequeSigner, dryRun bool) (*OwnershipTransfer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if newOwner == s.ownerAddress {
+		return nil, ErrSameOwner
+	}
+
+	var pendingOwner common.Address
+	err := s.store.Get(pendingTransferKey(s.keyPrefix), &pendingOwner)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	pending := err == nil && pendingOwner == newOwner
+
+	issuer, err := s.contract.Issuer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the transaction of an interrupted transfer was confirmed
+	confirmed := issuer == newOwner
+	if confirmed && !pending {
+		return nil, ErrAlreadyTransferred
+	}
+	if issuer != s.ownerAddress && !confirmed {
+		return nil, ErrNotChequebookOwner
+	}
+
+	lastCheques, err := s.LastCheques()
+	if err != nil {
+		return nil, err
+	}
+
+	cheques := make([]*SignedCheque, 0, len(lastCheques))
+	for beneficiary, lastCheque := range lastCheques {
+		cheque := Cheque{
+			Chequebook:       s.address,
+			Beneficiary:      beneficiary,
+			CumulativePayout: new(big.Int).Set(lastCheque.CumulativePayout),
+		}
+		sig, err := newSigner.Sign(&cheque)
+		if err != nil {
+			return nil, fmt.Errorf("re-sign cheque for %x: %w", beneficiary, err)
+		}
+		cheques = append(cheques, &SignedCheque{
+			Cheque:    cheque,
+			Signature: sig,
+		})
+	}
+	sort.Slice(cheques, func(i, j int) bool {
+		return bytes.Compare(cheques[i].Beneficiary.Bytes(), cheques[j].Beneficiary.Bytes()) < 0
+	})
+
+	transfer := &OwnershipTransfer{
+		Chequebook: s.address,
+		OldOwner:   s.ownerAddress,
+		NewOwner:   newOwner,
+		Cheques:    cheques,
+	}
+	if dryRun {
+		return transfer, nil
+	}
+
+	if !confirmed {
+		txHash, err := s.sendTransferOwnership(ctx, newOwner)
+		if err != nil {
+			return nil, err
+		}
+		transfer.TxHash = txHash
+	}
+
+	err = s.store.Batch(func(b storage.StateBatch) error {
+		for _, cheque := range cheques {
+			if err := b.Put(s.lastIssuedChequeKey(cheque.Beneficiary), cheque); err != nil {
+				return err
+			}
+			if err := b.Put(s.reissuedChequeKey(cheque.Beneficiary), cheque); err != nil {
+				return err
+			}
+		}
+		if err := b.Delete(pendingTransferKey(s.keyPrefix)); err != nil {
+			return err
+		}
+		return b.Put(chequebookAddressKey(s.keyPrefix), s.address)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.ownerAddress = newOwner
+	s.chequeSigner = newSigner
+
+	return transfer, nil
+}
+
+// sendTransferOwnership records newOwner as pending and sends the transaction
+// which transfers the chequebook to it.
+func (s *service) sendTransferOwnership(ctx context.Context, newOwner common.Address) (common.Hash, error) {
+	newOwnerXwcAddr, err := xwcfmt.HexAddrToXwcAddr(hex.EncodeToString(newOwner[:]))
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	err = s.store.Put(pendingTransferKey(s.keyPrefix), newOwner)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	txHash, err := s.transactionService.Send(ctx, &transaction.TxRequest{
+		To:       &s.address,
+		GasPrice: big.NewInt(10),
+		GasLimit: 100000,
+		Value:    big.NewInt(0),
+
+		TxType:     transaction.TxTypeInvokeContract,
+		InvokeApi:  "transferOwnership",
+		InvokeArgs: newOwnerXwcAddr,
+	})
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	receipt, err := s.transactionService.WaitForReceipt(ctx, txHash)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if !receipt.ExecSucceed {
+		return common.Hash{}, transaction.ErrTransactionReverted
+	}
+
+	return txHash, nil
+}
+
+// ReissuedCheque returns the cheque re-signed for the beneficiary by an
+// ownership transfer if it was not delivered yet.
+func (s *service) ReissuedCheque(beneficiary common.Address) (*SignedCheque, error) {
+	var cheque *SignedCheque
+	err := s.store.Get(s.reissuedChequeKey(beneficiary), &cheque)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+		return nil, ErrNoCheque
+	}
+	return cheque, nil
+}
+
+// ReissuedChequeDelivered marks the re-signed cheque for the beneficiary as
+// delivered.
+func (s *service) ReissuedChequeDelivered(beneficiary common.Address) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.store.Delete(s.reissuedChequeKey(beneficiary))
+}
@@ -0,0 +1,274 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chequebook_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook"
+	erc20mock "github.com/penguintop/penguin/pkg/settlement/swap/erc20/mock"
+	storemock "github.com/penguintop/penguin/pkg/statestore/mock"
+	"github.com/penguintop/penguin/pkg/transaction"
+	transactionmock "github.com/penguintop/penguin/pkg/transaction/mock"
+	"github.com/penguintop/penguin/pkg/xwcfmt"
+	"github.com/penguintop/penguin/pkg/xwctypes"
+)
+
+func TestChequebookTransferOwnership(t *testing.T) {
+	address := common.HexToAddress("0xabcd")
+	beneficiary := common.HexToAddress("0xdddd")
+	ownerAddress := common.HexToAddress("0xfff")
+	newOwnerAddress := common.HexToAddress("0xeee")
+	txHash := common.HexToHash("0xaaaa")
+	oldSig := common.Hex2Bytes("0xffff")
+	newSig := common.Hex2Bytes("0xeeee")
+	cumulativePayout := big.NewInt(50)
+
+	ownerXwcAddr, err := xwcfmt.HexAddrToXwcAddr(hex.EncodeToString(ownerAddress[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		t.Run(fmt.Sprintf("dry run %v", dryRun), func(t *testing.T) {
+			store := storemock.NewStateStore()
+			err := store.Put(fmt.Sprintf("swap_chequebook_last_issued_cheque_%x", beneficiary), &chequebook.SignedCheque{
+				Cheque: chequebook.Cheque{
+					Chequebook:       address,
+					Beneficiary:      beneficiary,
+					CumulativePayout: cumulativePayout,
+				},
+				Signature: oldSig,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sent := false
+			chequebookService, err := chequebook.New(
+				transactionmock.New(
+					transactionmock.WithCallFunc(func(ctx context.Context, request *transaction.TxRequest) ([]byte, error) {
+						return []byte(ownerXwcAddr), nil
+					}),
+					transactionmock.WithSendFunc(func(ctx context.Context, request *transaction.TxRequest) (common.Hash, error) {
+						if request.InvokeApi != "transferOwnership" {
+							t.Fatalf("wrong invoke api. wanted transferOwnership, got %s", request.InvokeApi)
+						}
+						sent = true
+						return txHash, nil
+					}),
+					transactionmock.WithWaitForReceiptFunc(func(ctx context.Context, hash common.Hash) (*xwctypes.RpcTransactionReceipt, error) {
+						return &xwctypes.RpcTransactionReceipt{ExecSucceed: true}, nil
+					}),
+				),
+				address,
+				ownerAddress,
+				store,
+				&chequeSignerMock{},
+				erc20mock.New(),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			newSigner := &chequeSignerMock{
+				sign: func(cheque *chequebook.Cheque) ([]byte, error) {
+					return newSig, nil
+				},
+			}
+
+			transfer, err := chequebookService.TransferOwnership(context.Background(), newOwnerAddress, newSigner, dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if transfer.OldOwner != ownerAddress || transfer.NewOwner != newOwnerAddress {
+				t.Fatalf("wrong owners. wanted %x -> %x, got %x -> %x", ownerAddress, newOwnerAddress, transfer.OldOwner, transfer.NewOwner)
+			}
+			if len(transfer.Cheques) != 1 {
+				t.Fatalf("got %d re-signed cheques, wanted 1", len(transfer.Cheques))
+			}
+			if !bytes.Equal(transfer.Cheques[0].Signature, newSig) {
+				t.Fatalf("wrong signature. wanted %x, got %x", newSig, transfer.Cheques[0].Signature)
+			}
+			if transfer.Cheques[0].CumulativePayout.Cmp(cumulativePayout) != 0 {
+				t.Fatalf("wrong cumulative payout. wanted %d, got %d", cumulativePayout, transfer.Cheques[0].CumulativePayout)
+			}
+			if sent == dryRun {
+				t.Fatalf("transaction sent %v in dry run %v", sent, dryRun)
+			}
+
+			lastCheque, err := chequebookService.LastCheque(beneficiary)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantSig := newSig
+			if dryRun {
+				wantSig = oldSig
+			}
+			if !bytes.Equal(lastCheque.Signature, wantSig) {
+				t.Fatalf("wrong stored signature. wanted %x, got %x", wantSig, lastCheque.Signature)
+			}
+
+			reissuedCheque, err := chequebookService.ReissuedCheque(beneficiary)
+			if dryRun {
+				if !errors.Is(err, chequebook.ErrNoCheque) {
+					t.Fatalf("got error %v for reissued cheque in dry run, wanted %v", err, chequebook.ErrNoCheque)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(reissuedCheque.Signature, newSig) {
+				t.Fatalf("wrong reissued signature. wanted %x, got %x", newSig, reissuedCheque.Signature)
+			}
+
+			err = chequebookService.ReissuedChequeDelivered(beneficiary)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = chequebookService.ReissuedCheque(beneficiary)
+			if !errors.Is(err, chequebook.ErrNoCheque) {
+				t.Fatalf("got error %v for delivered reissued cheque, wanted %v", err, chequebook.ErrNoCheque)
+			}
+		})
+	}
+}
+
+func TestChequebookTransferOwnershipNotOwner(t *testing.T) {
+	address := common.HexToAddress("0xabcd")
+	ownerAddress := common.HexToAddress("0xfff")
+	otherAddress := common.HexToAddress("0xccc")
+
+	otherXwcAddr, err := xwcfmt.HexAddrToXwcAddr(hex.EncodeToString(otherAddress[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chequebookService, err := chequebook.New(
+		transactionmock.New(
+			transactionmock.WithCallFunc(func(ctx context.Context, request *transaction.TxRequest) ([]byte, error) {
+				return []byte(otherXwcAddr), nil
+			}),
+		),
+		address,
+		ownerAddress,
+		storemock.NewStateStore(),
+		&chequeSignerMock{},
+		erc20mock.New(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = chequebookService.TransferOwnership(context.Background(), common.HexToAddress("0xeee"), &chequeSignerMock{}, false)
+	if !errors.Is(err, chequebook.ErrNotChequebookOwner) {
+		t.Fatalf("got error %v, wanted %v", err, chequebook.ErrNotChequebookOwner)
+	}
+}
+
+func TestChequebookTransferOwnershipResume(t *testing.T) {
+	address := common.HexToAddress("0xabcd")
+	beneficiary := common.HexToAddress("0xdddd")
+	ownerAddress := common.HexToAddress("0xfff")
+	newOwnerAddress := common.HexToAddress("0xeee")
+	newSig := common.Hex2Bytes("0xeeee")
+
+	newOwnerXwcAddr, err := xwcfmt.HexAddrToXwcAddr(hex.EncodeToString(newOwnerAddress[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the transfer was interrupted after its transaction was confirmed
+	store := storemock.NewStateStore()
+	err = store.Put(fmt.Sprintf("swap_chequebook_last_issued_cheque_%x", beneficiary), &chequebook.SignedCheque{
+		Cheque: chequebook.Cheque{
+			Chequebook:       address,
+			Beneficiary:      beneficiary,
+			CumulativePayout: big.NewInt(50),
+		},
+		Signature: common.Hex2Bytes("0xffff"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put("swap_chequebook_pending_transfer", newOwnerAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chequebookService, err := chequebook.New(
+		transactionmock.New(
+			transactionmock.WithCallFunc(func(ctx context.Context, request *transaction.TxRequest) ([]byte, error) {
+				return []byte(newOwnerXwcAddr), nil
+			}),
+			transactionmock.WithSendFunc(func(ctx context.Context, request *transaction.TxRequest) (common.Hash, error) {
+				t.Fatal("transaction sent for a confirmed transfer")
+				return common.Hash{}, nil
+			}),
+		),
+		address,
+		ownerAddress,
+		store,
+		&chequeSignerMock{},
+		erc20mock.New(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSigner := &chequeSignerMock{
+		sign: func(cheque *chequebook.Cheque) ([]byte, error) {
+			return newSig, nil
+		},
+	}
+
+	transfer, err := chequebookService.TransferOwnership(context.Background(), newOwnerAddress, newSigner, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.TxHash != (common.Hash{}) {
+		t.Fatalf("got transaction hash %x for a confirmed transfer", transfer.TxHash)
+	}
+
+	reissuedCheque, err := chequebookService.ReissuedCheque(beneficiary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reissuedCheque.Signature, newSig) {
+		t.Fatalf("wrong reissued signature. wanted %x, got %x", newSig, reissuedCheque.Signature)
+	}
+
+	// a completed transfer is not repeated
+	otherService, err := chequebook.New(
+		transactionmock.New(
+			transactionmock.WithCallFunc(func(ctx context.Context, request *transaction.TxRequest) ([]byte, error) {
+				return []byte(newOwnerXwcAddr), nil
+			}),
+		),
+		address,
+		ownerAddress,
+		store,
+		&chequeSignerMock{},
+		erc20mock.New(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = otherService.TransferOwnership(context.Background(), newOwnerAddress, newSigner, false)
+	if !errors.Is(err, chequebook.ErrAlreadyTransferred) {
+		t.Fatalf("got error %v, wanted %v", err, chequebook.ErrAlreadyTransferred)
+	}
+}
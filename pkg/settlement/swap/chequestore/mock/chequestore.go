@@ -14,17 +14,24 @@ import (
 
 // Service is the mock chequeStore service.
 type Service struct {
-	receiveCheque func(ctx context.Context, cheque *chequebook.SignedCheque) (*big.Int, error)
+	receiveCheque func(ctx context.Context, cheque *chequebook.SignedCheque, check chequebook.ChequeCheckFunc) (*big.Int, error)
+	token         func(ctx context.Context, chequebook common.Address) (common.Address, error)
 	lastCheque    func(chequebook common.Address) (*chequebook.SignedCheque, error)
 	lastCheques   func() (map[common.Address]*chequebook.SignedCheque, error)
 }
 
-func WithRetrieveChequeFunc(f func(ctx context.Context, cheque *chequebook.SignedCheque) (*big.Int, error)) Option {
+func WithRetrieveChequeFunc(f func(ctx context.Context, cheque *chequebook.SignedCheque, check chequebook.ChequeCheckFunc) (*big.Int, error)) Option {
 	return optionFunc(func(s *Service) {
 		s.receiveCheque = f
 	})
 }
 
+func WithChequebookTokenFunc(f func(ctx context.Context, chequebook common.Address) (common.Address, error)) Option {
+	return optionFunc(func(s *Service) {
+		s.token = f
+	})
+}
+
 func WithLastChequeFunc(f func(chequebook common.Address) (*chequebook.SignedCheque, error)) Option {
 	return optionFunc(func(s *Service) {
 		s.lastCheque = f
@@ -46,8 +53,17 @@ func NewChequeStore(opts ...Option) chequebook.ChequeStore {
 	return mock
 }
 
-func (s *Service) ReceiveCheque(ctx context.Context, cheque *chequebook.SignedCheque) (*big.Int, error) {
-	return s.receiveCheque(ctx, cheque)
+func (s *Service) ReceiveCheque(ctx context.Context, cheque *chequebook.SignedCheque, check chequebook.ChequeCheckFunc) (*big.Int, error) {
+	return s.receiveCheque(ctx, cheque, check)
+}
+
+// ChequebookToken returns the zero address, the token of the mock
+// chequebooks, unless a token function is set.
+func (s *Service) ChequebookToken(ctx context.Context, chequebook common.Address) (common.Address, error) {
+	if s.token != nil {
+		return s.token(ctx, chequebook)
+	}
+	return common.Address{}, nil
 }
 
 func (s *Service) LastCheque(chequebook common.Address) (*chequebook.SignedCheque, error) {
@@ -23,6 +23,8 @@ var (
 )
 
 type Service interface {
+	// Address returns the address of the token contract.
+	Address() common.Address
 	BalanceOf(ctx context.Context, address common.Address) (*big.Int, error)
 	Transfer(ctx context.Context, address common.Address, value *big.Int) (common.Hash, error)
 	TransferToContract(ctx context.Context, address common.Address, value *big.Int) (common.Hash, error)
@@ -42,6 +44,10 @@ func New(backend transaction.Backend, transactionService transaction.Service, ad
 	}
 }
 
+func (c *erc20Service) Address() common.Address {
+	return c.address
+}
+
 func (c *erc20Service) BalanceOf(ctx context.Context, address common.Address) (*big.Int, error) {
 	//callData, err := erc20ABI.Pack("balanceOf", address)
 	//if err != nil {
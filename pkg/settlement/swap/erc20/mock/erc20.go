@@ -14,12 +14,17 @@ import (
 )
 
 type Service struct {
+	address       common.Address
 	balanceOfFunc func(ctx context.Context, address common.Address) (*big.Int, error)
 	transferFunc  func(ctx context.Context, address common.Address, value *big.Int) (common.Hash, error)
+
+	transferToContractFunc func(ctx context.Context, address common.Address, value *big.Int) (common.Hash, error)
 }
 
-func (s *Service) TransferToContract(ctx context.Context, address common.Address, value *big.Int) (common.Hash, error) {
-	panic("implement me")
+func WithAddress(address common.Address) Option {
+	return optionFunc(func(s *Service) {
+		s.address = address
+	})
 }
 
 func WithBalanceOfFunc(f func(ctx context.Context, address common.Address) (*big.Int, error)) Option {
@@ -34,6 +39,12 @@ func WithTransferFunc(f func(ctx context.Context, address common.Address, value
 	})
 }
 
+func WithTransferToContractFunc(f func(ctx context.Context, address common.Address, value *big.Int) (common.Hash, error)) Option {
+	return optionFunc(func(s *Service) {
+		s.transferToContractFunc = f
+	})
+}
+
 func New(opts ...Option) erc20.Service {
 	mock := new(Service)
 	for _, o := range opts {
@@ -42,6 +53,10 @@ func New(opts ...Option) erc20.Service {
 	return mock
 }
 
+func (s *Service) Address() common.Address {
+	return s.address
+}
+
 func (s *Service) BalanceOf(ctx context.Context, address common.Address) (*big.Int, error) {
 	if s.balanceOfFunc != nil {
 		return s.balanceOfFunc(ctx, address)
@@ -56,6 +71,13 @@ func (s *Service) Transfer(ctx context.Context, address common.Address, value *b
 	return common.Hash{}, errors.New("Error")
 }
 
+func (s *Service) TransferToContract(ctx context.Context, address common.Address, value *big.Int) (common.Hash, error) {
+	if s.transferToContractFunc != nil {
+		return s.transferToContractFunc(ctx, address, value)
+	}
+	return common.Hash{}, errors.New("Error")
+}
+
 // Option is the option passed to the mock Chequebook service
 type Option interface {
 	apply(*Service)
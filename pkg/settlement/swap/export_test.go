@@ -0,0 +1,13 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package swap
+
+import "time"
+
+func SetCashoutPollInterval(d time.Duration) (reset func()) {
+	prev := cashoutPollInterval
+	cashoutPollInterval = d
+	return func() { cashoutPollInterval = prev }
+}
@@ -15,6 +15,8 @@ type metrics struct {
 	ChequesReceived  prometheus.Counter
 	ChequesSent      prometheus.Counter
 	ChequesRejected  prometheus.Counter
+	ChequesBounced   prometheus.Counter
+	RiskyChequebooks prometheus.Counter
 	AvailableBalance prometheus.Gauge
 }
 
@@ -52,6 +54,18 @@ func newMetrics() metrics {
 			Name:      "cheques_rejected",
 			Help:      "Number of cheques rejected",
 		}),
+		ChequesBounced: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "cheques_bounced",
+			Help:      "Number of cashouts of received cheques that bounced",
+		}),
+		RiskyChequebooks: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "risky_chequebooks",
+			Help:      "Number of cheques rejected because the chequebook is likely to bounce",
+		}),
 		AvailableBalance: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: m.Namespace,
 			Subsystem: subsystem,
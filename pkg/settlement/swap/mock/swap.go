@@ -27,7 +27,7 @@ type Service struct {
 
 	receiveChequeFunc   func(context.Context, penguin.Address, *chequebook.SignedCheque) error
 	payFunc             func(context.Context, penguin.Address, *big.Int)
-	handshakeFunc       func(penguin.Address, common.Address) error
+	handshakeFunc       func(penguin.Address, common.Address, common.Address) error
 	lastSentChequeFunc  func(penguin.Address) (*chequebook.SignedCheque, error)
 	lastSentChequesFunc func() (map[string]*chequebook.SignedCheque, error)
 
@@ -36,6 +36,7 @@ type Service struct {
 
 	cashChequeFunc    func(ctx context.Context, peer penguin.Address) (common.Hash, error)
 	cashoutStatusFunc func(ctx context.Context, peer penguin.Address) (*chequebook.CashoutStatus, error)
+	peerTokenFunc     func(peer penguin.Address) (common.Address, bool, error)
 }
 
 // WithsettlementFunc sets the mock settlement function
@@ -76,7 +77,7 @@ func WithPayFunc(f func(context.Context, penguin.Address, *big.Int)) Option {
 	})
 }
 
-func WithHandshakeFunc(f func(penguin.Address, common.Address) error) Option {
+func WithHandshakeFunc(f func(penguin.Address, common.Address, common.Address) error) Option {
 	return optionFunc(func(s *Service) {
 		s.handshakeFunc = f
 	})
@@ -118,6 +119,12 @@ func WithCashoutStatusFunc(f func(ctx context.Context, peer penguin.Address) (*c
 	})
 }
 
+func WithPeerTokenFunc(f func(peer penguin.Address) (common.Address, bool, error)) Option {
+	return optionFunc(func(s *Service) {
+		s.peerTokenFunc = f
+	})
+}
+
 // New creates the mock swap implementation
 func New(opts ...Option) swap.Interface {
 	mock := new(Service)
@@ -189,9 +196,9 @@ func (s *Service) SettlementsReceived() (map[string]*big.Int, error) {
 }
 
 // Handshake is called by the swap protocol when a handshake is received.
-func (s *Service) Handshake(peer penguin.Address, beneficiary common.Address) error {
+func (s *Service) Handshake(peer penguin.Address, beneficiary common.Address, token common.Address) error {
 	if s.handshakeFunc != nil {
-		return s.handshakeFunc(peer, beneficiary)
+		return s.handshakeFunc(peer, beneficiary, token)
 	}
 	return nil
 }
@@ -238,6 +245,13 @@ func (s *Service) CashoutStatus(ctx context.Context, peer penguin.Address) (*che
 	return nil, nil
 }
 
+func (s *Service) PeerToken(peer penguin.Address) (common.Address, bool, error) {
+	if s.peerTokenFunc != nil {
+		return s.peerTokenFunc(peer)
+	}
+	return common.Address{}, false, nil
+}
+
 // Option is the option passed to the mock settlement service
 type Option interface {
 	apply(*Service)
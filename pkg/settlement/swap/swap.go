@@ -9,11 +9,14 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/penguintop/penguin/pkg/crypto"
 	"github.com/penguintop/penguin/pkg/logging"
 	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/reputation"
 	"github.com/penguintop/penguin/pkg/settlement"
 	"github.com/penguintop/penguin/pkg/settlement/swap/chequebook"
 	"github.com/penguintop/penguin/pkg/settlement/swap/swapprotocol"
@@ -28,6 +31,23 @@ var (
 	ErrWrongBeneficiary = errors.New("wrong beneficiary")
 	// ErrUnknownBeneficary is the error if a peer has never announced a beneficiary.
 	ErrUnknownBeneficary = errors.New("unknown beneficiary for peer")
+	// ErrWrongToken is the error if a peer settles in a different token than before.
+	ErrWrongToken = errors.New("wrong settlement token")
+	// ErrRiskyChequebook is the error if a peer pays with a chequebook that is likely to bounce.
+	ErrRiskyChequebook = errors.New("risky chequebook")
+)
+
+// riskBlocklistDuration is the duration for which peers paying with risky
+// chequebooks are blocklisted.
+const riskBlocklistDuration = 24 * time.Hour
+
+var (
+	// cashoutPollInterval is the interval in which the status of a sent
+	// cashout transaction is checked until it is mined.
+	cashoutPollInterval = time.Minute
+	// cashoutFollowTimeout is the time after which a cashout transaction
+	// that is still pending is no longer followed.
+	cashoutFollowTimeout = 24 * time.Hour
 )
 
 type Interface interface {
@@ -44,6 +64,8 @@ type Interface interface {
 	CashCheque(ctx context.Context, peer penguin.Address) (common.Hash, error)
 	// CashoutStatus gets the status of the latest cashout transaction for the peers chequebook
 	CashoutStatus(ctx context.Context, peer penguin.Address) (*chequebook.CashoutStatus, error)
+	// PeerToken returns the token we settle in with the peer
+	PeerToken(peer penguin.Address) (token common.Address, known bool, err error)
 }
 
 // Service is the implementation of the swap settlement layer.
@@ -53,28 +75,34 @@ type Service struct {
 	store       storage.StateStorer
 	accounting  settlement.Accounting
 	metrics     metrics
-	chequebook  chequebook.Service
+	chequebooks chequebook.Chequebooks
 	chequeStore chequebook.ChequeStore
 	cashout     chequebook.CashoutService
 	p2pService  p2p.Service
 	addressbook Addressbook
 	networkID   uint64
+	risk        chequebook.RiskEngine
+	reputation  reputation.Recorder
+	quit        chan struct{}
+	wg          sync.WaitGroup
 }
 
 // New creates a new swap Service.
-func New(proto swapprotocol.Interface, logger logging.Logger, store storage.StateStorer, chequebook chequebook.Service, chequeStore chequebook.ChequeStore, addressbook Addressbook, networkID uint64, cashout chequebook.CashoutService, p2pService p2p.Service, accounting settlement.Accounting) *Service {
+func New(proto swapprotocol.Interface, logger logging.Logger, store storage.StateStorer, chequebooks chequebook.Chequebooks, chequeStore chequebook.ChequeStore, addressbook Addressbook, networkID uint64, cashout chequebook.CashoutService, p2pService p2p.Service, accounting settlement.Accounting) *Service {
 	return &Service{
 		proto:       proto,
 		logger:      logger,
 		store:       store,
 		metrics:     newMetrics(),
-		chequebook:  chequebook,
+		chequebooks: chequebooks,
 		chequeStore: chequeStore,
 		addressbook: addressbook,
 		networkID:   networkID,
 		cashout:     cashout,
 		p2pService:  p2pService,
 		accounting:  accounting,
+		reputation:  reputation.Noop,
+		quit:        make(chan struct{}),
 	}
 }
 
@@ -86,14 +114,54 @@ func (s *Service) ReceiveCheque(ctx context.Context, peer penguin.Address, chequ
 		return err
 	}
 	if known && expectedChequebook != cheque.Chequebook {
+		s.reputation.Failure(peer, reputation.ProtocolSettlement)
 		return ErrWrongChequebook
 	}
+	if !known {
+		// the first cheque fixes the chequebook of the peer,
+		// it must hold the token agreed on in the handshake
+		err = s.verifyChequeToken(ctx, peer, cheque.Chequebook)
+		if err != nil {
+			s.metrics.ChequesRejected.Inc()
+			s.reputation.Failure(peer, reputation.ProtocolSettlement)
+			return err
+		}
+	}
 
-	amount, err := s.chequeStore.ReceiveCheque(ctx, cheque)
+	// only valid cheques may affect the risk state of the peer, they are
+	// assessed after the cheque store verified them and their exposure is
+	// recorded before the next cheque is assessed against the total
+	var check chequebook.ChequeCheckFunc
+	if s.risk != nil {
+		check = func(cheque *chequebook.SignedCheque, _ *big.Int) error {
+			assessment, err := s.assessCheque(ctx, peer, cheque)
+			if err != nil {
+				return err
+			}
+			return s.risk.RecordCheque(assessment)
+		}
+	}
+
+	amount, err := s.chequeStore.ReceiveCheque(ctx, cheque, check)
 	if err != nil {
 		s.metrics.ChequesRejected.Inc()
+		// our total exposure is not the fault of the peer
+		if !errors.Is(err, chequebook.ErrExposureLimit) {
+			s.reputation.Failure(peer, reputation.ProtocolSettlement)
+		}
 		return fmt.Errorf("rejecting cheque: %w", err)
 	}
+	s.reputation.Success(peer, reputation.ProtocolSettlement, 0)
+
+	// a cheque re-signed after an ownership transfer of the chequebook
+	// replaces the last one without paying anything
+	if amount.Sign() == 0 {
+		s.logger.Debugf("swap: received re-signed cheque from peer %v for chequebook %x", peer, cheque.Chequebook)
+		if !known {
+			return s.addressbook.PutChequebook(peer, cheque.Chequebook)
+		}
+		return nil
+	}
 
 	if !known {
 		err = s.addressbook.PutChequebook(peer, cheque.Chequebook)
@@ -130,9 +198,14 @@ func (s *Service) Pay(ctx context.Context, peer penguin.Address, amount *big.Int
 		err = ErrUnknownBeneficary
 		return
 	}
-	balance, err := s.chequebook.Issue(ctx, beneficiary, amount, func(signedCheque *chequebook.SignedCheque) error {
+	peerChequebook, err := s.peerChequebook(peer)
+	if err != nil {
+		return
+	}
+	// the payment is recorded in the accounting together with the cheque
+	balance, err := peerChequebook.Issue(ctx, beneficiary, amount, func(signedCheque *chequebook.SignedCheque) error {
 		return s.proto.EmitCheque(ctx, peer, signedCheque)
-	})
+	}, s.accounting.PersistPaymentSent(peer, amount))
 	if err != nil {
 		return
 	}
@@ -148,6 +221,99 @@ func (s *Service) SetAccounting(accounting settlement.Accounting) {
 	s.accounting = accounting
 }
 
+// SetReputation sets the recorder of the peer interaction outcomes.
+func (s *Service) SetReputation(r reputation.Recorder) {
+	s.reputation = r
+}
+
+// SetRiskEngine sets the engine used to assess received cheques.
+func (s *Service) SetRiskEngine(risk chequebook.RiskEngine) {
+	s.risk = risk
+}
+
+// assessCheque evaluates the risk of a received cheque. Cheques of high risk
+// are rejected and the peer is blocklisted, for chequebooks at elevated risk
+// the payment tolerance of the peer is lowered.
+func (s *Service) assessCheque(ctx context.Context, peer penguin.Address, cheque *chequebook.SignedCheque) (*chequebook.RiskAssessment, error) {
+	assessment, err := s.risk.Assess(ctx, cheque)
+	if err != nil {
+		return nil, err
+	}
+
+	switch assessment.Level {
+	case chequebook.RiskHigh:
+		s.metrics.RiskyChequebooks.Inc()
+		s.logger.Warningf("swap: blocklisting peer %v: chequebook %x balance %d exposure %d bounces %d", peer, cheque.Chequebook, assessment.Balance, assessment.Exposure, assessment.Bounces)
+		if err := s.p2pService.Blocklist(peer, riskBlocklistDuration, "swap: cheque from high risk chequebook"); err != nil {
+			s.logger.Errorf("swap: blocklist peer %v: %v", peer, err)
+		}
+		return nil, ErrRiskyChequebook
+	case chequebook.RiskElevated:
+		s.logger.Debugf("swap: lowering tolerance for peer %v: chequebook %x balance %d exposure %d", peer, cheque.Chequebook, assessment.Balance, assessment.Exposure)
+		err = s.accounting.SetPaymentTolerance(peer, big.NewInt(0))
+	default:
+		err = s.accounting.SetPaymentTolerance(peer, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return assessment, nil
+}
+
+// peerChequebook returns our chequebook in the token we settle in with the
+// peer. Peers without a known token settle in the default token.
+func (s *Service) peerChequebook(peer penguin.Address) (chequebook.Service, error) {
+	token, known, err := s.addressbook.Token(peer)
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		return s.chequebooks.Default(), nil
+	}
+	return s.chequebooks.Chequebook(token)
+}
+
+// verifyChequeToken checks that the chequebook of the peer holds the token we
+// settle in with the peer.
+func (s *Service) verifyChequeToken(ctx context.Context, peer penguin.Address, chequebookAddress common.Address) error {
+	peerChequebook, err := s.peerChequebook(peer)
+	if err != nil {
+		return err
+	}
+	token, err := s.chequeStore.ChequebookToken(ctx, chequebookAddress)
+	if err != nil {
+		return fmt.Errorf("rejecting cheque: %w", err)
+	}
+	if token != peerChequebook.Token() {
+		return ErrWrongToken
+	}
+	return nil
+}
+
+// lastIssuedCheques returns the last cheques issued from all of our
+// chequebooks. Since the token of a peer does not change, every beneficiary
+// only ever receives cheques from one of them.
+func (s *Service) lastIssuedCheques() (map[common.Address]*chequebook.SignedCheque, error) {
+	result := make(map[common.Address]*chequebook.SignedCheque)
+	for _, token := range s.chequebooks.Tokens() {
+		tokenChequebook, err := s.chequebooks.Chequebook(token)
+		if err != nil {
+			return nil, err
+		}
+		cheques, err := tokenChequebook.LastCheques()
+		if err != nil {
+			return nil, err
+		}
+		for beneficiary, cheque := range cheques {
+			if _, ok := result[beneficiary]; !ok {
+				result[beneficiary] = cheque
+			}
+		}
+	}
+	return result, nil
+}
+
 // TotalSent returns the total amount sent to a peer
 func (s *Service) TotalSent(peer penguin.Address) (totalSent *big.Int, err error) {
 	beneficiary, known, err := s.addressbook.Beneficiary(peer)
@@ -157,7 +323,11 @@ func (s *Service) TotalSent(peer penguin.Address) (totalSent *big.Int, err error
 	if !known {
 		return nil, settlement.ErrPeerNoSettlements
 	}
-	cheque, err := s.chequebook.LastCheque(beneficiary)
+	peerChequebook, err := s.peerChequebook(peer)
+	if err != nil {
+		return nil, err
+	}
+	cheque, err := peerChequebook.LastCheque(beneficiary)
 	if err != nil {
 		if err == chequebook.ErrNoCheque {
 			return nil, settlement.ErrPeerNoSettlements
@@ -190,7 +360,7 @@ func (s *Service) TotalReceived(peer penguin.Address) (totalReceived *big.Int, e
 // SettlementsSent returns sent settlements for each individual known peer
 func (s *Service) SettlementsSent() (map[string]*big.Int, error) {
 	result := make(map[string]*big.Int)
-	cheques, err := s.chequebook.LastCheques()
+	cheques, err := s.lastIssuedCheques()
 	if err != nil {
 		return nil, err
 	}
@@ -231,7 +401,7 @@ func (s *Service) SettlementsReceived() (map[string]*big.Int, error) {
 }
 
 // Handshake is called by the swap protocol when a handshake is received.
-func (s *Service) Handshake(peer penguin.Address, beneficiary common.Address) error {
+func (s *Service) Handshake(peer penguin.Address, beneficiary common.Address, token common.Address) error {
 	// check that the overlay address was derived from the beneficiary (implying they have the same private key)
 	// while this is not strictly necessary for correct functionality we need to ensure no two peers use the same beneficiary
 	// as long as we enforce this we might not need the handshake message if the p2p layer exposed the overlay public key
@@ -242,6 +412,11 @@ func (s *Service) Handshake(peer penguin.Address, beneficiary common.Address) er
 		return ErrWrongBeneficiary
 	}
 
+	err := s.handshakeToken(peer, token)
+	if err != nil {
+		return err
+	}
+
 	storedBeneficiary, known, err := s.addressbook.Beneficiary(peer)
 	if err != nil {
 		return err
@@ -253,9 +428,104 @@ func (s *Service) Handshake(peer penguin.Address, beneficiary common.Address) er
 	if storedBeneficiary != beneficiary {
 		return ErrWrongBeneficiary
 	}
+
+	// deliver a cheque re-signed by an ownership transfer of our chequebook
+	peerChequebook, err := s.peerChequebook(peer)
+	if err != nil {
+		return err
+	}
+	cheque, err := peerChequebook.ReissuedCheque(beneficiary)
+	if err != nil {
+		if !errors.Is(err, chequebook.ErrNoCheque) {
+			s.logger.Debugf("swap: reissued cheque for peer %v: %v", peer, err)
+		}
+		return nil
+	}
+	s.wg.Add(1)
+	go s.sendReissuedCheque(peer, peerChequebook, cheque)
 	return nil
 }
 
+// sendReissuedCheque sends a cheque re-signed by an ownership transfer of the
+// chequebook to the peer. It is kept as reissued until it was sent, so that it
+// is sent again on the next handshake if this fails.
+func (s *Service) sendReissuedCheque(peer penguin.Address, peerChequebook chequebook.Service, cheque *chequebook.SignedCheque) {
+	defer s.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := s.proto.EmitCheque(ctx, peer, cheque)
+	if err != nil {
+		s.logger.Debugf("swap: send reissued cheque to peer %v: %v", peer, err)
+		return
+	}
+
+	err = peerChequebook.ReissuedChequeDelivered(cheque.Beneficiary)
+	if err != nil {
+		s.logger.Errorf("swap: reissued cheque for peer %v delivered: %v", peer, err)
+	}
+}
+
+// handshakeToken checks the token agreed with the peer is the one we settled
+// in before, or remembers it if this is the first handshake.
+func (s *Service) handshakeToken(peer penguin.Address, token common.Address) error {
+	if _, err := s.chequebooks.Chequebook(token); err != nil {
+		return err
+	}
+	storedToken, known, err := s.addressbook.Token(peer)
+	if err != nil {
+		return err
+	}
+	if !known {
+		// peers without a stored token settled in the default token so far
+		if token != s.chequebooks.Default().Token() {
+			settled, err := s.settledInDefaultToken(peer)
+			if err != nil {
+				return err
+			}
+			if settled {
+				return ErrWrongToken
+			}
+		}
+		s.logger.Tracef("initial swap handshake peer: %v token: %x", peer, token)
+		return s.addressbook.PutToken(peer, token)
+	}
+	if storedToken != token {
+		return ErrWrongToken
+	}
+	return nil
+}
+
+// settledInDefaultToken checks whether cheques were already exchanged with a
+// peer for which no token is stored.
+func (s *Service) settledInDefaultToken(peer penguin.Address) (bool, error) {
+	_, known, err := s.addressbook.Chequebook(peer)
+	if err != nil || known {
+		return known, err
+	}
+	_, err = s.TotalSent(peer)
+	if err != nil {
+		if errors.Is(err, settlement.ErrPeerNoSettlements) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// PeerToken returns the token we settle in with the peer.
+func (s *Service) PeerToken(peer penguin.Address) (token common.Address, known bool, err error) {
+	return s.addressbook.Token(peer)
+}
+
 // LastSentCheque returns the last sent cheque for the peer
 func (s *Service) LastSentCheque(peer penguin.Address) (*chequebook.SignedCheque, error) {
 
@@ -269,7 +539,12 @@ func (s *Service) LastSentCheque(peer penguin.Address) (*chequebook.SignedCheque
 		return nil, chequebook.ErrNoCheque
 	}
 
-	return s.chequebook.LastCheque(common)
+	peerChequebook, err := s.peerChequebook(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	return peerChequebook.LastCheque(common)
 }
 
 // LastReceivedCheque returns the last received cheque for the peer
@@ -290,7 +565,7 @@ func (s *Service) LastReceivedCheque(peer penguin.Address) (*chequebook.SignedCh
 
 // LastSentCheques returns the list of last sent cheques for all peers
 func (s *Service) LastSentCheques() (map[string]*chequebook.SignedCheque, error) {
-	lastcheques, err := s.chequebook.LastCheques()
+	lastcheques, err := s.lastIssuedCheques()
 	if err != nil {
 		return nil, err
 	}
@@ -335,7 +610,66 @@ func (s *Service) CashCheque(ctx context.Context, peer penguin.Address) (common.
 	if !known {
 		return common.Hash{}, chequebook.ErrNoCheque
 	}
-	return s.cashout.CashCheque(ctx, chequebookAddress, s.chequebook.Address())
+	peerChequebook, err := s.peerChequebook(peer)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	txHash, err := s.cashout.CashCheque(ctx, chequebookAddress, peerChequebook.Address())
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	if s.risk != nil {
+		s.wg.Add(1)
+		go s.followCashout(peer, chequebookAddress, txHash)
+	}
+
+	return txHash, nil
+}
+
+// followCashout checks the status of the cashout transaction until it is
+// mined, so that a bounce is noticed without the status being requested.
+func (s *Service) followCashout(peer penguin.Address, chequebookAddress common.Address, txHash common.Hash) {
+	defer s.wg.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cashoutFollowTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(cashoutPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		status, err := s.cashout.CashoutStatus(ctx, chequebookAddress)
+		if err != nil {
+			s.logger.Debugf("swap: cashout status of chequebook %x: %v", chequebookAddress, err)
+			continue
+		}
+		if status.Last == nil || status.Last.TxHash != txHash {
+			// a later cashout is followed on its own
+			return
+		}
+		if status.Last.Result == nil && !status.Last.Reverted {
+			continue
+		}
+
+		if err := s.notifyCashout(peer, chequebookAddress, status); err != nil {
+			s.logger.Errorf("swap: cashout of chequebook %x: %v", chequebookAddress, err)
+		}
+		return
+	}
 }
 
 // CashoutStatus gets the status of the latest cashout transaction for the peers chequebook
@@ -347,5 +681,47 @@ func (s *Service) CashoutStatus(ctx context.Context, peer penguin.Address) (*che
 	if !known {
 		return nil, chequebook.ErrNoCheque
 	}
-	return s.cashout.CashoutStatus(ctx, chequebookAddress)
+	status, err := s.cashout.CashoutStatus(ctx, chequebookAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.notifyCashout(peer, chequebookAddress, status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// notifyCashout records the result of a mined cashout with the risk engine
+// and penalises the peer if the cashout bounced.
+func (s *Service) notifyCashout(peer penguin.Address, chequebookAddress common.Address, status *chequebook.CashoutStatus) error {
+	if s.risk == nil || status.Last == nil || status.Last.Result == nil {
+		return nil
+	}
+
+	bounced, err := s.risk.NotifyCashout(chequebookAddress, status.Last.TxHash, status.Last.Result)
+	if err != nil {
+		return err
+	}
+	if !bounced {
+		return nil
+	}
+
+	s.metrics.ChequesBounced.Inc()
+	s.logger.Warningf("swap: cashout of chequebook %x of peer %v bounced", chequebookAddress, peer)
+	if err := s.accounting.SetPaymentTolerance(peer, big.NewInt(0)); err != nil {
+		return err
+	}
+	if err := s.p2pService.Blocklist(peer, riskBlocklistDuration, "swap: cheque cashout bounced"); err != nil {
+		s.logger.Errorf("swap: blocklist peer %v: %v", peer, err)
+	}
+	return nil
+}
+
+// Close stops following the sent cashout transactions.
+func (s *Service) Close() error {
+	close(s.quit)
+	s.wg.Wait()
+	return nil
 }
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"io/ioutil"
 	"math/big"
+	"sync"
 	"testing"
 	"time"
 
@@ -21,6 +22,7 @@ import (
 	mockchequebook "github.com/penguintop/penguin/pkg/settlement/swap/chequebook/mock"
 	mockchequestore "github.com/penguintop/penguin/pkg/settlement/swap/chequestore/mock"
 	mockstore "github.com/penguintop/penguin/pkg/statestore/mock"
+	"github.com/penguintop/penguin/pkg/storage"
     "github.com/penguintop/penguin/pkg/penguin"
 )
 
@@ -36,8 +38,10 @@ func (m *swapProtocolMock) EmitCheque(ctx context.Context, peer penguin.Address,
 }
 
 type testObserver struct {
-	receivedCalled chan notifyPaymentReceivedCall
-	sentCalled     chan notifyPaymentSentCall
+	receivedCalled    chan notifyPaymentReceivedCall
+	sentCalled        chan notifyPaymentSentCall
+	toleranceMu       sync.Mutex
+	paymentTolerances map[string]*big.Int
 }
 
 type notifyPaymentReceivedCall struct {
@@ -53,8 +57,9 @@ type notifyPaymentSentCall struct {
 
 func newTestObserver() *testObserver {
 	return &testObserver{
-		receivedCalled: make(chan notifyPaymentReceivedCall, 1),
-		sentCalled:     make(chan notifyPaymentSentCall, 1),
+		receivedCalled:    make(chan notifyPaymentReceivedCall, 1),
+		sentCalled:        make(chan notifyPaymentSentCall, 1),
+		paymentTolerances: make(map[string]*big.Int),
 	}
 }
 
@@ -74,6 +79,26 @@ func (t *testObserver) NotifyRefreshmentReceived(peer penguin.Address, amount *b
 	return nil
 }
 
+func (t *testObserver) SetPaymentTolerance(peer penguin.Address, paymentTolerance *big.Int) error {
+	t.toleranceMu.Lock()
+	defer t.toleranceMu.Unlock()
+	t.paymentTolerances[peer.String()] = paymentTolerance
+	return nil
+}
+
+func (t *testObserver) paymentTolerance(peer penguin.Address) (*big.Int, bool) {
+	t.toleranceMu.Lock()
+	defer t.toleranceMu.Unlock()
+	tolerance, ok := t.paymentTolerances[peer.String()]
+	return tolerance, ok
+}
+
+func (t *testObserver) PersistPaymentSent(peer penguin.Address, amount *big.Int) func(b storage.StateBatch) error {
+	return func(b storage.StateBatch) error {
+		return nil
+	}
+}
+
 func (t *testObserver) NotifyPaymentSent(peer penguin.Address, amount *big.Int, err error) {
 	t.sentCalled <- notifyPaymentSentCall{
 		peer:   peer,
@@ -89,6 +114,8 @@ type addressbookMock struct {
 	chequebookPeer  func(chequebook common.Address) (peer penguin.Address, known bool, err error)
 	putBeneficiary  func(peer penguin.Address, beneficiary common.Address) error
 	putChequebook   func(peer penguin.Address, chequebook common.Address) error
+	token           func(peer penguin.Address) (token common.Address, known bool, err error)
+	putToken        func(peer penguin.Address, token common.Address) error
 }
 
 func (m *addressbookMock) Beneficiary(peer penguin.Address) (beneficiary common.Address, known bool, err error) {
@@ -109,6 +136,27 @@ func (m *addressbookMock) PutBeneficiary(peer penguin.Address, beneficiary commo
 func (m *addressbookMock) PutChequebook(peer penguin.Address, chequebook common.Address) error {
 	return m.putChequebook(peer, chequebook)
 }
+func (m *addressbookMock) Token(peer penguin.Address) (token common.Address, known bool, err error) {
+	if m.token == nil {
+		return common.Address{}, false, nil
+	}
+	return m.token(peer)
+}
+func (m *addressbookMock) PutToken(peer penguin.Address, token common.Address) error {
+	if m.putToken == nil {
+		return nil
+	}
+	return m.putToken(peer, token)
+}
+
+func newChequebooks(t *testing.T, defaultChequebook chequebook.Service, additional ...chequebook.Service) chequebook.Chequebooks {
+	t.Helper()
+	chequebooks, err := chequebook.NewChequebooks(defaultChequebook, additional...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chequebooks
+}
 
 type cashoutMock struct {
 	cashCheque    func(ctx context.Context, chequebook common.Address, recipient common.Address) (common.Hash, error)
@@ -140,7 +188,7 @@ func TestReceiveCheque(t *testing.T) {
 	}
 
 	chequeStore := mockchequestore.NewChequeStore(
-		mockchequestore.WithRetrieveChequeFunc(func(ctx context.Context, c *chequebook.SignedCheque) (*big.Int, error) {
+		mockchequestore.WithRetrieveChequeFunc(func(ctx context.Context, c *chequebook.SignedCheque, check chequebook.ChequeCheckFunc) (*big.Int, error) {
 			if !cheque.Equal(c) {
 				t.Fatalf("passed wrong cheque to store. wanted %v, got %v", cheque, c)
 			}
@@ -172,7 +220,7 @@ func TestReceiveCheque(t *testing.T) {
 		&swapProtocolMock{},
 		logger,
 		store,
-		chequebookService,
+		newChequebooks(t, chequebookService),
 		chequeStore,
 		addressbook,
 		networkID,
@@ -202,6 +250,65 @@ func TestReceiveCheque(t *testing.T) {
 
 }
 
+func TestReceiveChequeReissued(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+	store := mockstore.NewStateStore()
+	chequebookAddress := common.HexToAddress("0xcd")
+
+	peer := penguin.MustParseHexAddress("abcd")
+	cheque := &chequebook.SignedCheque{
+		Cheque: chequebook.Cheque{
+			Beneficiary:      common.HexToAddress("0xab"),
+			CumulativePayout: big.NewInt(10),
+			Chequebook:       chequebookAddress,
+		},
+		Signature: []byte{1},
+	}
+
+	var stored bool
+	chequeStore := mockchequestore.NewChequeStore(
+		mockchequestore.WithRetrieveChequeFunc(func(ctx context.Context, c *chequebook.SignedCheque, check chequebook.ChequeCheckFunc) (*big.Int, error) {
+			stored = true
+			return big.NewInt(0), nil
+		}),
+	)
+	addressbook := &addressbookMock{
+		chequebook: func(p penguin.Address) (common.Address, bool, error) {
+			return chequebookAddress, true, nil
+		},
+	}
+
+	observer := newTestObserver()
+
+	swap := swap.New(
+		&swapProtocolMock{},
+		logger,
+		store,
+		newChequebooks(t, mockchequebook.NewChequebook()),
+		chequeStore,
+		addressbook,
+		uint64(1),
+		&cashoutMock{},
+		mockp2p.New(),
+		observer,
+	)
+
+	err := swap.ReceiveCheque(context.Background(), peer, cheque)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stored {
+		t.Fatal("re-signed cheque was not stored")
+	}
+
+	select {
+	case call := <-observer.receivedCalled:
+		t.Fatalf("observer called for re-signed cheque with amount %d", call.amount)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 func TestReceiveChequeReject(t *testing.T) {
 	logger := logging.New(ioutil.Discard, 0)
 	store := mockstore.NewStateStore()
@@ -221,7 +328,7 @@ func TestReceiveChequeReject(t *testing.T) {
 	var errReject = errors.New("reject")
 
 	chequeStore := mockchequestore.NewChequeStore(
-		mockchequestore.WithRetrieveChequeFunc(func(ctx context.Context, c *chequebook.SignedCheque) (*big.Int, error) {
+		mockchequestore.WithRetrieveChequeFunc(func(ctx context.Context, c *chequebook.SignedCheque, check chequebook.ChequeCheckFunc) (*big.Int, error) {
 			return nil, errReject
 		}),
 	)
@@ -238,7 +345,7 @@ func TestReceiveChequeReject(t *testing.T) {
 		&swapProtocolMock{},
 		logger,
 		store,
-		chequebookService,
+		newChequebooks(t, chequebookService),
 		chequeStore,
 		addressbook,
 		networkID,
@@ -292,7 +399,7 @@ func TestReceiveChequeWrongChequebook(t *testing.T) {
 		&swapProtocolMock{},
 		logger,
 		store,
-		chequebookService,
+		newChequebooks(t, chequebookService),
 		chequeStore,
 		addressbook,
 		networkID,
@@ -317,6 +424,72 @@ func TestReceiveChequeWrongChequebook(t *testing.T) {
 
 }
 
+func TestReceiveChequeWrongToken(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+	store := mockstore.NewStateStore()
+	chequebookService := mockchequebook.NewChequebook()
+	chequebookAddress := common.HexToAddress("0xcd")
+
+	peer := penguin.MustParseHexAddress("abcd")
+	cheque := &chequebook.SignedCheque{
+		Cheque: chequebook.Cheque{
+			Beneficiary:      common.HexToAddress("0xab"),
+			CumulativePayout: big.NewInt(10),
+			Chequebook:       chequebookAddress,
+		},
+		Signature: []byte{},
+	}
+
+	// the chequebook of the peer holds another token
+	// than the default one we settle in with the peer
+	chequeStore := mockchequestore.NewChequeStore(
+		mockchequestore.WithChequebookTokenFunc(func(_ context.Context, c common.Address) (common.Address, error) {
+			if c != chequebookAddress {
+				t.Fatalf("got token of chequebook %x, want %x", c, chequebookAddress)
+			}
+			return common.HexToAddress("0xee"), nil
+		}),
+		mockchequestore.WithRetrieveChequeFunc(func(context.Context, *chequebook.SignedCheque, chequebook.ChequeCheckFunc) (*big.Int, error) {
+			t.Fatal("cheque of the wrong token stored")
+			return nil, nil
+		}),
+	)
+	networkID := uint64(1)
+	addressbook := &addressbookMock{
+		chequebook: func(p penguin.Address) (common.Address, bool, error) {
+			return common.Address{}, false, nil
+		},
+		token: func(p penguin.Address) (common.Address, bool, error) {
+			return common.Address{}, false, nil
+		},
+	}
+
+	observer := newTestObserver()
+	swapService := swap.New(
+		&swapProtocolMock{},
+		logger,
+		store,
+		newChequebooks(t, chequebookService),
+		chequeStore,
+		addressbook,
+		networkID,
+		&cashoutMock{},
+		mockp2p.New(),
+		observer,
+	)
+
+	err := swapService.ReceiveCheque(context.Background(), peer, cheque)
+	if !errors.Is(err, swap.ErrWrongToken) {
+		t.Fatalf("wrong error. wanted %v, got %v", swap.ErrWrongToken, err)
+	}
+
+	select {
+	case <-observer.receivedCalled:
+		t.Fatalf("observer called by error.")
+	default:
+	}
+}
+
 func TestPay(t *testing.T) {
 	logger := logging.New(ioutil.Discard, 0)
 	store := mockstore.NewStateStore()
@@ -328,7 +501,7 @@ func TestPay(t *testing.T) {
 	peer := penguin.MustParseHexAddress("abcd")
 	var chequebookCalled bool
 	chequebookService := mockchequebook.NewChequebook(
-		mockchequebook.WithChequebookIssueFunc(func(ctx context.Context, b common.Address, a *big.Int, sendChequeFunc chequebook.SendChequeFunc) (*big.Int, error) {
+		mockchequebook.WithChequebookIssueFunc(func(ctx context.Context, b common.Address, a *big.Int, sendChequeFunc chequebook.SendChequeFunc, persistFunc chequebook.PersistFunc) (*big.Int, error) {
 			if b != beneficiary {
 				t.Fatalf("issuing cheque for wrong beneficiary. wanted %v, got %v", beneficiary, b)
 			}
@@ -368,7 +541,7 @@ func TestPay(t *testing.T) {
 		},
 		logger,
 		store,
-		chequebookService,
+		newChequebooks(t, chequebookService),
 		mockchequestore.NewChequeStore(),
 		addressbook,
 		networkID,
@@ -398,7 +571,7 @@ func TestPayIssueError(t *testing.T) {
 	peer := penguin.MustParseHexAddress("abcd")
 	errReject := errors.New("reject")
 	chequebookService := mockchequebook.NewChequebook(
-		mockchequebook.WithChequebookIssueFunc(func(ctx context.Context, b common.Address, a *big.Int, sendChequeFunc chequebook.SendChequeFunc) (*big.Int, error) {
+		mockchequebook.WithChequebookIssueFunc(func(ctx context.Context, b common.Address, a *big.Int, sendChequeFunc chequebook.SendChequeFunc, persistFunc chequebook.PersistFunc) (*big.Int, error) {
 			return big.NewInt(0), errReject
 		}),
 	)
@@ -417,7 +590,7 @@ func TestPayIssueError(t *testing.T) {
 		&swapProtocolMock{},
 		logger,
 		store,
-		chequebookService,
+		newChequebooks(t, chequebookService),
 		mockchequestore.NewChequeStore(),
 		addressbook,
 		networkID,
@@ -469,7 +642,7 @@ func TestPayUnknownBeneficiary(t *testing.T) {
 		&swapProtocolMock{},
 		logger,
 		store,
-		mockchequebook.NewChequebook(),
+		newChequebooks(t, mockchequebook.NewChequebook()),
 		mockchequestore.NewChequeStore(),
 		addressbook,
 		networkID,
@@ -520,7 +693,7 @@ func TestHandshake(t *testing.T) {
 		&swapProtocolMock{},
 		logger,
 		store,
-		mockchequebook.NewChequebook(),
+		newChequebooks(t, mockchequebook.NewChequebook()),
 		mockchequestore.NewChequeStore(),
 		&addressbookMock{
 			beneficiary: func(p penguin.Address) (common.Address, bool, error) {
@@ -537,7 +710,7 @@ func TestHandshake(t *testing.T) {
 		nil,
 	)
 
-	err := swapService.Handshake(peer, beneficiary)
+	err := swapService.Handshake(peer, beneficiary, common.Address{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -547,6 +720,47 @@ func TestHandshake(t *testing.T) {
 	}
 }
 
+func TestHandshakePeerChequebookError(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+	store := mockstore.NewStateStore()
+
+	beneficiary := common.HexToAddress("0xcd")
+	networkID := uint64(1)
+	peer := crypto.NewOverlayFromEthereumAddress(beneficiary[:], networkID)
+
+	// the token lookup fails when the chequebook of the peer is looked up
+	tokenErr := errors.New("token lookup error")
+	tokenCalls := 0
+	swapService := swap.New(
+		&swapProtocolMock{},
+		logger,
+		store,
+		newChequebooks(t, mockchequebook.NewChequebook()),
+		mockchequestore.NewChequeStore(),
+		&addressbookMock{
+			beneficiary: func(p penguin.Address) (common.Address, bool, error) {
+				return beneficiary, true, nil
+			},
+			token: func(p penguin.Address) (common.Address, bool, error) {
+				tokenCalls++
+				if tokenCalls > 1 {
+					return common.Address{}, false, tokenErr
+				}
+				return common.Address{}, true, nil
+			},
+		},
+		networkID,
+		&cashoutMock{},
+		mockp2p.New(),
+		nil,
+	)
+
+	err := swapService.Handshake(peer, beneficiary, common.Address{})
+	if !errors.Is(err, tokenErr) {
+		t.Fatalf("got error %v, wanted %v", err, tokenErr)
+	}
+}
+
 func TestHandshakeNewPeer(t *testing.T) {
 	logger := logging.New(ioutil.Discard, 0)
 	store := mockstore.NewStateStore()
@@ -560,7 +774,7 @@ func TestHandshakeNewPeer(t *testing.T) {
 		&swapProtocolMock{},
 		logger,
 		store,
-		mockchequebook.NewChequebook(),
+		newChequebooks(t, mockchequebook.NewChequebook()),
 		mockchequestore.NewChequeStore(),
 		&addressbookMock{
 			beneficiary: func(p penguin.Address) (common.Address, bool, error) {
@@ -577,7 +791,7 @@ func TestHandshakeNewPeer(t *testing.T) {
 		nil,
 	)
 
-	err := swapService.Handshake(peer, beneficiary)
+	err := swapService.Handshake(peer, beneficiary, common.Address{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -599,7 +813,7 @@ func TestHandshakeWrongBeneficiary(t *testing.T) {
 		&swapProtocolMock{},
 		logger,
 		store,
-		mockchequebook.NewChequebook(),
+		newChequebooks(t, mockchequebook.NewChequebook()),
 		mockchequestore.NewChequeStore(),
 		&addressbookMock{},
 		networkID,
@@ -608,12 +822,92 @@ func TestHandshakeWrongBeneficiary(t *testing.T) {
 		nil,
 	)
 
-	err := swapService.Handshake(peer, beneficiary)
+	err := swapService.Handshake(peer, beneficiary, common.Address{})
 	if !errors.Is(err, swap.ErrWrongBeneficiary) {
 		t.Fatalf("wrong error. wanted %v, got %v", swap.ErrWrongBeneficiary, err)
 	}
 }
 
+func TestHandshakeReissuedCheque(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+	store := mockstore.NewStateStore()
+
+	beneficiary := common.HexToAddress("0xcd")
+	networkID := uint64(1)
+	peer := crypto.NewOverlayFromEthereumAddress(beneficiary[:], networkID)
+
+	cheque := &chequebook.SignedCheque{
+		Cheque: chequebook.Cheque{
+			Beneficiary:      beneficiary,
+			CumulativePayout: big.NewInt(10),
+			Chequebook:       common.HexToAddress("0xab"),
+		},
+		Signature: []byte{1},
+	}
+
+	sent := make(chan *chequebook.SignedCheque, 1)
+	delivered := make(chan common.Address, 1)
+	swapService := swap.New(
+		&swapProtocolMock{
+			emitCheque: func(ctx context.Context, p penguin.Address, c *chequebook.SignedCheque) error {
+				if !p.Equal(peer) {
+					t.Errorf("cheque sent to wrong peer. got %v, want %v", p, peer)
+				}
+				sent <- c
+				return nil
+			},
+		},
+		logger,
+		store,
+		newChequebooks(t, mockchequebook.NewChequebook(
+			mockchequebook.WithChequebookReissuedChequeFunc(func(b common.Address) (*chequebook.SignedCheque, error) {
+				if b != beneficiary {
+					return nil, chequebook.ErrNoCheque
+				}
+				return cheque, nil
+			}),
+			mockchequebook.WithChequebookReissuedChequeDeliveredFunc(func(b common.Address) error {
+				delivered <- b
+				return nil
+			}),
+		)),
+		mockchequestore.NewChequeStore(),
+		&addressbookMock{
+			beneficiary: func(p penguin.Address) (common.Address, bool, error) {
+				return beneficiary, true, nil
+			},
+		},
+		networkID,
+		&cashoutMock{},
+		mockp2p.New(),
+		nil,
+	)
+	defer swapService.Close()
+
+	err := swapService.Handshake(peer, beneficiary, common.Address{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case c := <-sent:
+		if !c.Equal(cheque) {
+			t.Fatalf("sent wrong cheque. got %v, want %v", c, cheque)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reissued cheque not sent")
+	}
+
+	select {
+	case b := <-delivered:
+		if b != beneficiary {
+			t.Fatalf("delivered for wrong beneficiary. got %x, want %x", b, beneficiary)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reissued cheque not marked as delivered")
+	}
+}
+
 func TestCashout(t *testing.T) {
 	logger := logging.New(ioutil.Discard, 0)
 	store := mockstore.NewStateStore()
@@ -635,11 +929,11 @@ func TestCashout(t *testing.T) {
 		&swapProtocolMock{},
 		logger,
 		store,
-		mockchequebook.NewChequebook(
+		newChequebooks(t, mockchequebook.NewChequebook(
 			mockchequebook.WithChequebookAddressFunc(func() common.Address {
 				return ourChequebookAddress
 			}),
-		),
+		)),
 		mockchequestore.NewChequeStore(),
 		addressbook,
 		uint64(1),
@@ -689,7 +983,7 @@ func TestCashoutStatus(t *testing.T) {
 		&swapProtocolMock{},
 		logger,
 		store,
-		mockchequebook.NewChequebook(),
+		newChequebooks(t, mockchequebook.NewChequebook()),
 		mockchequestore.NewChequeStore(),
 		addressbook,
 		uint64(1),
@@ -714,3 +1008,455 @@ func TestCashoutStatus(t *testing.T) {
 		t.Fatalf("go wrong status. wanted %v, got %v", expectedStatus, returnedStatus)
 	}
 }
+
+func TestHandshakeNewPeerToken(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+	store := mockstore.NewStateStore()
+
+	beneficiary := common.HexToAddress("0xcd")
+	networkID := uint64(1)
+	peer := crypto.NewOverlayFromXwcAddress(beneficiary[:], networkID)
+	token := common.HexToAddress("0xee")
+
+	var storedToken common.Address
+	swapService := swap.New(
+		&swapProtocolMock{},
+		logger,
+		store,
+		newChequebooks(t,
+			mockchequebook.NewChequebook(),
+			mockchequebook.NewChequebook(
+				mockchequebook.WithChequebookTokenFunc(func() common.Address {
+					return token
+				}),
+			),
+		),
+		mockchequestore.NewChequeStore(),
+		&addressbookMock{
+			beneficiary: func(p penguin.Address) (common.Address, bool, error) {
+				return common.Address{}, false, nil
+			},
+			chequebook: func(p penguin.Address) (common.Address, bool, error) {
+				return common.Address{}, false, nil
+			},
+			putBeneficiary: func(p penguin.Address, b common.Address) error {
+				return nil
+			},
+			putToken: func(p penguin.Address, t common.Address) error {
+				storedToken = t
+				return nil
+			},
+		},
+		networkID,
+		&cashoutMock{},
+		mockp2p.New(),
+		nil,
+	)
+
+	err := swapService.Handshake(peer, beneficiary, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if storedToken != token {
+		t.Fatalf("stored wrong token. wanted %x, got %x", token, storedToken)
+	}
+}
+
+func TestHandshakeWrongToken(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+	store := mockstore.NewStateStore()
+
+	beneficiary := common.HexToAddress("0xcd")
+	networkID := uint64(1)
+	peer := crypto.NewOverlayFromXwcAddress(beneficiary[:], networkID)
+	token := common.HexToAddress("0xee")
+	unsupportedToken := common.HexToAddress("0xef")
+
+	swapService := swap.New(
+		&swapProtocolMock{},
+		logger,
+		store,
+		newChequebooks(t,
+			mockchequebook.NewChequebook(),
+			mockchequebook.NewChequebook(
+				mockchequebook.WithChequebookTokenFunc(func() common.Address {
+					return token
+				}),
+			),
+		),
+		mockchequestore.NewChequeStore(),
+		&addressbookMock{
+			beneficiary: func(p penguin.Address) (common.Address, bool, error) {
+				return beneficiary, true, nil
+			},
+			token: func(p penguin.Address) (common.Address, bool, error) {
+				return common.Address{}, true, nil
+			},
+		},
+		networkID,
+		&cashoutMock{},
+		mockp2p.New(),
+		nil,
+	)
+
+	err := swapService.Handshake(peer, beneficiary, token)
+	if !errors.Is(err, swap.ErrWrongToken) {
+		t.Fatalf("wrong error. wanted %v, got %v", swap.ErrWrongToken, err)
+	}
+
+	err = swapService.Handshake(peer, beneficiary, unsupportedToken)
+	if !errors.Is(err, chequebook.ErrUnsupportedToken) {
+		t.Fatalf("wrong error. wanted %v, got %v", chequebook.ErrUnsupportedToken, err)
+	}
+}
+
+func TestPayPeerToken(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+	store := mockstore.NewStateStore()
+
+	amount := big.NewInt(50)
+	beneficiary := common.HexToAddress("0xcd")
+	peer := penguin.MustParseHexAddress("abcd")
+	token := common.HexToAddress("0xee")
+
+	var defaultCalled, tokenCalled bool
+	defaultChequebook := mockchequebook.NewChequebook(
+		mockchequebook.WithChequebookIssueFunc(func(ctx context.Context, b common.Address, a *big.Int, sendChequeFunc chequebook.SendChequeFunc, persistFunc chequebook.PersistFunc) (*big.Int, error) {
+			defaultCalled = true
+			return big.NewInt(0), nil
+		}),
+	)
+	tokenChequebook := mockchequebook.NewChequebook(
+		mockchequebook.WithChequebookTokenFunc(func() common.Address {
+			return token
+		}),
+		mockchequebook.WithChequebookIssueFunc(func(ctx context.Context, b common.Address, a *big.Int, sendChequeFunc chequebook.SendChequeFunc, persistFunc chequebook.PersistFunc) (*big.Int, error) {
+			tokenCalled = true
+			return big.NewInt(0), nil
+		}),
+	)
+
+	observer := newTestObserver()
+
+	swapService := swap.New(
+		&swapProtocolMock{},
+		logger,
+		store,
+		newChequebooks(t, defaultChequebook, tokenChequebook),
+		mockchequestore.NewChequeStore(),
+		&addressbookMock{
+			beneficiary: func(p penguin.Address) (common.Address, bool, error) {
+				return beneficiary, true, nil
+			},
+			token: func(p penguin.Address) (common.Address, bool, error) {
+				return token, true, nil
+			},
+		},
+		uint64(1),
+		&cashoutMock{},
+		mockp2p.New(),
+		observer,
+	)
+
+	swapService.Pay(context.Background(), peer, amount)
+
+	select {
+	case call := <-observer.sentCalled:
+		if call.err != nil {
+			t.Fatalf("observer called with error. got %v", call.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected observer to be called")
+	}
+
+	if defaultCalled {
+		t.Fatal("paid from the chequebook of the default token")
+	}
+	if !tokenCalled {
+		t.Fatal("did not pay from the chequebook of the peer token")
+	}
+}
+
+func TestReceiveChequeRisk(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+	chequebookAddress := common.HexToAddress("0xcd")
+	peer := penguin.MustParseHexAddress("abcd")
+	cheque := &chequebook.SignedCheque{
+		Cheque: chequebook.Cheque{
+			Beneficiary:      common.HexToAddress("0xab"),
+			CumulativePayout: big.NewInt(10),
+			Chequebook:       chequebookAddress,
+		},
+		Signature: []byte{},
+	}
+
+	for _, tc := range []struct {
+		name        string
+		invalid     bool
+		level       chequebook.RiskLevel
+		tolerance   *big.Int
+		blocklisted bool
+	}{
+		{name: "low", level: chequebook.RiskLow, tolerance: nil},
+		{name: "elevated", level: chequebook.RiskElevated, tolerance: big.NewInt(0)},
+		{name: "high", level: chequebook.RiskHigh, blocklisted: true},
+		{name: "invalid", invalid: true, level: chequebook.RiskHigh},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stored := false
+			assessed := false
+			recorded := false
+			blocklisted := false
+
+			observer := newTestObserver()
+			swapService := swap.New(
+				&swapProtocolMock{},
+				logger,
+				mockstore.NewStateStore(),
+				newChequebooks(t, mockchequebook.NewChequebook()),
+				mockchequestore.NewChequeStore(
+					mockchequestore.WithRetrieveChequeFunc(func(ctx context.Context, c *chequebook.SignedCheque, check chequebook.ChequeCheckFunc) (*big.Int, error) {
+						if tc.invalid {
+							return nil, chequebook.ErrChequeInvalid
+						}
+						if err := check(c, big.NewInt(10)); err != nil {
+							return nil, err
+						}
+						stored = true
+						return big.NewInt(10), nil
+					}),
+				),
+				&addressbookMock{
+					chequebook: func(p penguin.Address) (common.Address, bool, error) {
+						return chequebookAddress, true, nil
+					},
+				},
+				uint64(1),
+				&cashoutMock{},
+				mockp2p.New(
+					mockp2p.WithBlocklistFunc(func(p penguin.Address, d time.Duration, _ string) error {
+						if !peer.Equal(p) {
+							t.Fatal("blocklisting wrong peer")
+						}
+						blocklisted = true
+						return nil
+					}),
+				),
+				observer,
+			)
+			swapService.SetRiskEngine(mockchequebook.NewRiskEngine(
+				mockchequebook.WithAssessFunc(func(ctx context.Context, c *chequebook.SignedCheque) (*chequebook.RiskAssessment, error) {
+					assessed = true
+					return &chequebook.RiskAssessment{
+						Chequebook: c.Chequebook,
+						Balance:    big.NewInt(0),
+						Exposure:   big.NewInt(0),
+						Level:      tc.level,
+					}, nil
+				}),
+				mockchequebook.WithRecordChequeFunc(func(assessment *chequebook.RiskAssessment) error {
+					recorded = true
+					return nil
+				}),
+			))
+
+			err := swapService.ReceiveCheque(context.Background(), peer, cheque)
+			if blocklisted != tc.blocklisted {
+				t.Fatalf("got blocklisted %v, wanted %v", blocklisted, tc.blocklisted)
+			}
+
+			if tc.invalid {
+				if !errors.Is(err, chequebook.ErrChequeInvalid) {
+					t.Fatalf("got error %v, wanted %v", err, chequebook.ErrChequeInvalid)
+				}
+				if assessed || stored {
+					t.Fatal("invalid cheque was assessed")
+				}
+				if _, ok := observer.paymentTolerance(peer); ok {
+					t.Fatal("payment tolerance changed for invalid cheque")
+				}
+				return
+			}
+			if tc.level == chequebook.RiskHigh {
+				if !errors.Is(err, swap.ErrRiskyChequebook) {
+					t.Fatalf("got error %v, wanted %v", err, swap.ErrRiskyChequebook)
+				}
+				if stored || recorded {
+					t.Fatal("risky cheque was accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !stored || !recorded {
+				t.Fatal("cheque was not accepted")
+			}
+
+			tolerance, ok := observer.paymentTolerance(peer)
+			if !ok {
+				t.Fatal("payment tolerance not set")
+			}
+			if (tolerance == nil) != (tc.tolerance == nil) || (tolerance != nil && tolerance.Cmp(tc.tolerance) != 0) {
+				t.Fatalf("got payment tolerance %v, wanted %v", tolerance, tc.tolerance)
+			}
+		})
+	}
+}
+
+func TestCashoutStatusBounced(t *testing.T) {
+	logger := logging.New(ioutil.Discard, 0)
+	theirChequebookAddress := common.HexToAddress("ffff")
+	txHash := common.HexToHash("0xaaaa")
+	peer := penguin.MustParseHexAddress("abcd")
+
+	status := &chequebook.CashoutStatus{
+		Last: &chequebook.LastCashout{
+			TxHash: txHash,
+			Result: &chequebook.CashChequeResult{
+				TotalPayout: big.NewInt(0),
+				Bounced:     true,
+			},
+		},
+	}
+
+	blocklisted := false
+	observer := newTestObserver()
+	swapService := swap.New(
+		&swapProtocolMock{},
+		logger,
+		mockstore.NewStateStore(),
+		newChequebooks(t, mockchequebook.NewChequebook()),
+		mockchequestore.NewChequeStore(),
+		&addressbookMock{
+			chequebook: func(p penguin.Address) (common.Address, bool, error) {
+				return theirChequebookAddress, true, nil
+			},
+		},
+		uint64(1),
+		&cashoutMock{
+			cashoutStatus: func(ctx context.Context, c common.Address) (*chequebook.CashoutStatus, error) {
+				return status, nil
+			},
+		},
+		mockp2p.New(
+			mockp2p.WithBlocklistFunc(func(p penguin.Address, d time.Duration, _ string) error {
+				blocklisted = true
+				return nil
+			}),
+		),
+		observer,
+	)
+	swapService.SetRiskEngine(mockchequebook.NewRiskEngine(
+		mockchequebook.WithNotifyCashoutFunc(func(c common.Address, hash common.Hash, result *chequebook.CashChequeResult) (bool, error) {
+			if c != theirChequebookAddress || hash != txHash {
+				t.Fatalf("notified wrong cashout %x of chequebook %x", hash, c)
+			}
+			return result.Bounced, nil
+		}),
+	))
+
+	_, err := swapService.CashoutStatus(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !blocklisted {
+		t.Fatal("expected peer to be blocklisted")
+	}
+	tolerance, ok := observer.paymentTolerance(peer)
+	if !ok || tolerance == nil || tolerance.Sign() != 0 {
+		t.Fatalf("got payment tolerance %v, wanted 0", tolerance)
+	}
+}
+
+func TestCashoutFollowed(t *testing.T) {
+	defer swap.SetCashoutPollInterval(10 * time.Millisecond)()
+
+	logger := logging.New(ioutil.Discard, 0)
+	theirChequebookAddress := common.HexToAddress("ffff")
+	txHash := common.HexToHash("0xaaaa")
+	peer := penguin.MustParseHexAddress("abcd")
+
+	var (
+		mu     sync.Mutex
+		polls  int
+		notify = make(chan *chequebook.CashChequeResult, 1)
+	)
+	blocklisted := make(chan struct{})
+	swapService := swap.New(
+		&swapProtocolMock{},
+		logger,
+		mockstore.NewStateStore(),
+		newChequebooks(t, mockchequebook.NewChequebook()),
+		mockchequestore.NewChequeStore(),
+		&addressbookMock{
+			chequebook: func(p penguin.Address) (common.Address, bool, error) {
+				return theirChequebookAddress, true, nil
+			},
+		},
+		uint64(1),
+		&cashoutMock{
+			cashCheque: func(ctx context.Context, c common.Address, r common.Address) (common.Hash, error) {
+				return txHash, nil
+			},
+			cashoutStatus: func(ctx context.Context, c common.Address) (*chequebook.CashoutStatus, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				polls++
+				last := &chequebook.LastCashout{TxHash: txHash}
+				// the transaction is pending for the first polls
+				if polls > 2 {
+					last.Result = &chequebook.CashChequeResult{
+						TotalPayout: big.NewInt(0),
+						Bounced:     true,
+					}
+				}
+				return &chequebook.CashoutStatus{Last: last}, nil
+			},
+		},
+		mockp2p.New(
+			mockp2p.WithBlocklistFunc(func(p penguin.Address, d time.Duration, _ string) error {
+				close(blocklisted)
+				return nil
+			}),
+		),
+		newTestObserver(),
+	)
+	swapService.SetRiskEngine(mockchequebook.NewRiskEngine(
+		mockchequebook.WithNotifyCashoutFunc(func(c common.Address, hash common.Hash, result *chequebook.CashChequeResult) (bool, error) {
+			notify <- result
+			return result.Bounced, nil
+		}),
+	))
+
+	if _, err := swapService.CashCheque(context.Background(), peer); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case result := <-notify:
+		if !result.Bounced {
+			t.Fatal("expected bounced cashout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cashout result not notified")
+	}
+	select {
+	case <-blocklisted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer not blocklisted")
+	}
+
+	if err := swapService.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cashout is no longer followed once its result is known
+	mu.Lock()
+	defer mu.Unlock()
+	if polls != 3 {
+		t.Fatalf("got %d status polls, wanted 3", polls)
+	}
+}
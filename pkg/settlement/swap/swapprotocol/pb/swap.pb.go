@@ -67,7 +67,8 @@ func (m *EmitCheque) GetCheque() []byte {
 }
 
 type Handshake struct {
-	Beneficiary []byte `protobuf:"bytes,1,opt,name=Beneficiary,proto3" json:"Beneficiary,omitempty"`
+	Beneficiary []byte   `protobuf:"bytes,1,opt,name=Beneficiary,proto3" json:"Beneficiary,omitempty"`
+	Tokens      [][]byte `protobuf:"bytes,2,rep,name=Tokens,proto3" json:"Tokens,omitempty"`
 }
 
 func (m *Handshake) Reset()         { *m = Handshake{} }
@@ -110,6 +111,13 @@ func (m *Handshake) GetBeneficiary() []byte {
 	return nil
 }
 
+func (m *Handshake) GetTokens() [][]byte {
+	if m != nil {
+		return m.Tokens
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*EmitCheque)(nil), "swapprotocol.EmitCheque")
 	proto.RegisterType((*Handshake)(nil), "swapprotocol.Handshake")
@@ -118,16 +126,17 @@ func init() {
 func init() { proto.RegisterFile("swap.proto", fileDescriptor_c35a3890a6e60fb7) }
 
 var fileDescriptor_c35a3890a6e60fb7 = []byte{
-	// 142 bytes of a gzipped FileDescriptorProto
+	// 155 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x2a, 0x2e, 0x4f, 0x2c,
 	0xd0, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x01, 0xb1, 0xc1, 0xcc, 0xe4, 0xfc, 0x1c, 0x25,
 	0x15, 0x2e, 0x2e, 0xd7, 0xdc, 0xcc, 0x12, 0xe7, 0x8c, 0xd4, 0xc2, 0xd2, 0x54, 0x21, 0x31, 0x2e,
-	0x36, 0x08, 0x4b, 0x82, 0x51, 0x81, 0x51, 0x83, 0x27, 0x08, 0xca, 0x53, 0xd2, 0xe5, 0xe2, 0xf4,
+	0x36, 0x08, 0x4b, 0x82, 0x51, 0x81, 0x51, 0x83, 0x27, 0x08, 0xca, 0x53, 0x72, 0xe5, 0xe2, 0xf4,
 	0x48, 0xcc, 0x4b, 0x29, 0xce, 0x48, 0xcc, 0x4e, 0x15, 0x52, 0xe0, 0xe2, 0x76, 0x4a, 0xcd, 0x4b,
-	0x4d, 0xcb, 0x4c, 0xce, 0x4c, 0x2c, 0xaa, 0x84, 0xaa, 0x44, 0x16, 0x72, 0x92, 0x39, 0xf1, 0x48,
+	0x4d, 0xcb, 0x4c, 0xce, 0x4c, 0x2c, 0xaa, 0x84, 0xaa, 0x44, 0x16, 0x02, 0x19, 0x13, 0x92, 0x9f,
+	0x9d, 0x9a, 0x57, 0x2c, 0xc1, 0xa4, 0xc0, 0x0c, 0x32, 0x06, 0xc2, 0x73, 0x92, 0x39, 0xf1, 0x48,
 	0x8e, 0xf1, 0xc2, 0x23, 0x39, 0xc6, 0x07, 0x8f, 0xe4, 0x18, 0x27, 0x3c, 0x96, 0x63, 0xb8, 0xf0,
-	0x58, 0x8e, 0xe1, 0xc6, 0x63, 0x39, 0x86, 0x28, 0xa6, 0x82, 0xa4, 0x24, 0x36, 0xb0, 0xe5, 0xc6,
-	0x80, 0x00, 0x00, 0x00, 0xff, 0xff, 0x7a, 0xdd, 0x22, 0xd8, 0x95, 0x00, 0x00, 0x00,
+	0x58, 0x8e, 0xe1, 0xc6, 0x63, 0x39, 0x86, 0x28, 0xa6, 0x82, 0xa4, 0x24, 0x36, 0xb0, 0xa3, 0x8c,
+	0x01, 0x03, 0x00, 0x5b, 0x91, 0x53, 0xe7, 0xad, 0x00, 0x00, 0x00,
 }
 
 func (m *EmitCheque) Marshal() (dAtA []byte, err error) {
@@ -180,6 +189,15 @@ func (m *Handshake) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.Tokens) > 0 {
+		for iNdEx := len(m.Tokens) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Tokens[iNdEx])
+			copy(dAtA[i:], m.Tokens[iNdEx])
+			i = encodeVarintSwap(dAtA, i, uint64(len(m.Tokens[iNdEx])))
+			i--
+			dAtA[i] = 0x12
+		}
+	}
 	if len(m.Beneficiary) > 0 {
 		i -= len(m.Beneficiary)
 		copy(dAtA[i:], m.Beneficiary)
@@ -224,6 +242,12 @@ func (m *Handshake) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovSwap(uint64(l))
 	}
+	if len(m.Tokens) > 0 {
+		for _, b := range m.Tokens {
+			l = len(b)
+			n += 1 + l + sovSwap(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -383,6 +407,38 @@ func (m *Handshake) Unmarshal(dAtA []byte) error {
 				m.Beneficiary = []byte{}
 			}
 			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Tokens", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSwap
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthSwap
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthSwap
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Tokens = append(m.Tokens, make([]byte, postIndex-iNdEx))
+			copy(m.Tokens[len(m.Tokens)-1], dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipSwap(dAtA[iNdEx:])
@@ -38,23 +38,32 @@ type Swap interface {
 	// ReceiveCheque is called by the swap protocol if a cheque is received.
 	ReceiveCheque(ctx context.Context, peer penguin.Address, cheque *chequebook.SignedCheque) error
 	// Handshake is called by the swap protocol when a handshake is received.
-	Handshake(peer penguin.Address, beneficiary common.Address) error
+	// The token is the one both peers agreed to settle in.
+	Handshake(peer penguin.Address, beneficiary common.Address, token common.Address) error
 }
 
+var (
+	// ErrNoCommonToken is the error when the peers have no token in common to settle in.
+	ErrNoCommonToken = errors.New("no common settlement token")
+)
+
 // Service is the main implementation of the swap protocol.
 type Service struct {
 	streamer    p2p.Streamer
 	logger      logging.Logger
 	swap        Swap
 	beneficiary common.Address
+	tokens      []common.Address // supported tokens in order of preference, the default token first
 }
 
-// New creates a new swap protocol Service.
-func New(streamer p2p.Streamer, logger logging.Logger, beneficiary common.Address) *Service {
+// New creates a new swap protocol Service. The tokens are the ones the node
+// has a chequebook for, with the default token first.
+func New(streamer p2p.Streamer, logger logging.Logger, beneficiary common.Address, tokens []common.Address) *Service {
 	return &Service{
 		streamer:    streamer,
 		logger:      logger,
 		beneficiary: beneficiary,
+		tokens:      tokens,
 	}
 }
 
@@ -99,15 +108,68 @@ func (s *Service) initHandler(ctx context.Context, p p2p.Peer, stream p2p.Stream
 		return errors.New("malformed beneficiary address")
 	}
 
+	offered, err := parseTokens(req.Tokens)
+	if err != nil {
+		return err
+	}
+
+	token, err := s.selectToken(offered)
+	if err != nil {
+		return fmt.Errorf("peer %v: %w", p.Address, err)
+	}
+
 	err = w.WriteMsgWithContext(ctx, &pb.Handshake{
 		Beneficiary: s.beneficiary.Bytes(),
+		Tokens:      [][]byte{token.Bytes()},
 	})
 	if err != nil {
 		return err
 	}
 
 	beneficiary := common.BytesToAddress(req.Beneficiary)
-	return s.swap.Handshake(p.Address, beneficiary)
+	return s.swap.Handshake(p.Address, beneficiary, token)
+}
+
+// selectToken picks the token to settle in from the tokens offered by the
+// peer. Our order of preference decides. Peers which do not offer any token
+// only know about the default token.
+func (s *Service) selectToken(offered []common.Address) (common.Address, error) {
+	if len(s.tokens) == 0 {
+		return common.Address{}, ErrNoCommonToken
+	}
+	if len(offered) == 0 {
+		return s.tokens[0], nil
+	}
+	for _, token := range s.tokens {
+		for _, o := range offered {
+			if token == o {
+				return token, nil
+			}
+		}
+	}
+	return common.Address{}, ErrNoCommonToken
+}
+
+// supportsToken checks whether we have a chequebook in the given token.
+func (s *Service) supportsToken(token common.Address) bool {
+	for _, t := range s.tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTokens parses the token addresses of a handshake message.
+func parseTokens(tokens [][]byte) ([]common.Address, error) {
+	addresses := make([]common.Address, 0, len(tokens))
+	for _, token := range tokens {
+		if len(token) != 20 {
+			return nil, errors.New("malformed token address")
+		}
+		addresses = append(addresses, common.BytesToAddress(token))
+	}
+	return addresses, nil
 }
 
 // init is called on outgoing connections and triggers handshake exchange
@@ -127,9 +189,15 @@ func (s *Service) init(ctx context.Context, p p2p.Peer) error {
 		}
 	}()
 
+	tokens := make([][]byte, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token.Bytes())
+	}
+
 	w, r := protobuf.NewWriterAndReader(stream)
 	err = w.WriteMsgWithContext(ctx, &pb.Handshake{
 		Beneficiary: s.beneficiary.Bytes(),
+		Tokens:      tokens,
 	})
 	if err != nil {
 		return err
@@ -147,7 +215,23 @@ func (s *Service) init(ctx context.Context, p p2p.Peer) error {
 
 	beneficiary := common.BytesToAddress(req.Beneficiary)
 
-	return s.swap.Handshake(p.Address, beneficiary)
+	selected, err := parseTokens(req.Tokens)
+	if err != nil {
+		return err
+	}
+
+	// peers not aware of tokens settle in the default token
+	var token common.Address
+	switch {
+	case len(selected) == 0 && len(s.tokens) > 0:
+		token = s.tokens[0]
+	case len(selected) == 1 && s.supportsToken(selected[0]):
+		token = selected[0]
+	default:
+		return fmt.Errorf("peer %v: %w", p.Address, ErrNoCommonToken)
+	}
+
+	return s.swap.Handshake(p.Address, beneficiary, token)
 }
 
 func (s *Service) handler(ctx context.Context, p p2p.Peer, stream p2p.Stream) (err error) {
@@ -43,6 +43,9 @@ type Options struct {
 	WriteBufferSize        uint64
 	OpenFilesLimit         uint64
 	DisableSeeksCompaction bool
+	// NoSync disables all fsync calls of LevelDB, including the synced
+	// writes. Data may be lost or corrupted if the system crashes.
+	NoSync bool
 }
 
 // DB provides abstractions over LevelDB in order to
@@ -76,6 +79,7 @@ func NewDB(path string, o *Options) (db *DB, err error) {
 			BlockCacheCapacity:     int(o.BlockCacheCapacity),
 			WriteBuffer:            int(o.WriteBufferSize),
 			DisableSeeksCompaction: o.DisableSeeksCompaction,
+			NoSync:                 o.NoSync,
 		})
 	}
 
@@ -183,6 +187,19 @@ func (db *DB) WriteBatch(batch *leveldb.Batch) (err error) {
 	return nil
 }
 
+// WriteBatchSync writes the batch and syncs the LevelDB journal to the
+// disk before returning, so that the batch and all previously written
+// ones are durable.
+func (db *DB) WriteBatchSync(batch *leveldb.Batch) (err error) {
+	err = db.ldb.Write(batch, &opt.WriteOptions{Sync: true})
+	if err != nil {
+		db.metrics.WriteBatchFailCounter.Inc()
+		return err
+	}
+	db.metrics.WriteBatchCounter.Inc()
+	return nil
+}
+
 // Close closes LevelDB database.
 func (db *DB) Close() (err error) {
 	close(db.quit)
@@ -18,6 +18,7 @@ package shed
 
 import (
 	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 
@@ -49,6 +50,8 @@ type Item struct {
 	Sig             []byte // postage stamp
 	Depth           uint8  // postage batch depth
 	Radius          uint8  // postage batch reserve radius, po upto and excluding which chunks are unpinned
+	AccessCount     uint64 // number of times the chunk was requested
+	Revenue         uint64 // amount earned from serving the chunk
 }
 
 // Merge is a helper method to construct a new
@@ -88,6 +91,12 @@ func (i Item) Merge(i2 Item) Item {
 	if i.Radius == 0 {
 		i.Radius = i2.Radius
 	}
+	if i.AccessCount == 0 {
+		i.AccessCount = i2.AccessCount
+	}
+	if i.Revenue == 0 {
+		i.Revenue = i2.Revenue
+	}
 	return i
 }
 
@@ -307,23 +316,81 @@ type IterateOptions struct {
 	Prefix []byte
 	// Iterate over items in reverse order.
 	Reverse bool
+	// Cursor resumes the iteration after the item at the cursor
+	// position. It takes precedence over StartFrom.
+	Cursor Cursor
+	// Until stops the iteration before the Item, so that only
+	// items with keys lower than the Until key are iterated on,
+	// or with greater keys in reverse order.
+	Until *Item
+}
+
+// ErrInvalidCursor is returned by Iterate functions if the
+// Cursor option is not a position in the iterated range of keys.
+var ErrInvalidCursor = errors.New("shed: invalid cursor")
+
+// Cursor is a position of an item in the Index. Cursors are returned
+// by IterateCursor to resume the iteration in a later call and remain
+// valid when the database is reopened.
+type Cursor []byte
+
+// String returns the hex encoding of the cursor.
+func (c Cursor) String() string {
+	return hex.EncodeToString(c)
+}
+
+// ParseCursor decodes a cursor from its string representation.
+func ParseCursor(s string) (Cursor, error) {
+	c, err := hex.DecodeString(s)
+	if err != nil || len(c) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
 }
 
 // Iterate function iterates over keys of the Index.
 // If IterateOptions is nil, the iterations is over all keys.
 func (f Index) Iterate(fn IndexIterFunc, options *IterateOptions) (err error) {
+	_, err = f.IterateCursor(fn, options)
+	return err
+}
+
+// IterateCursor iterates over keys of the Index in the same way as Iterate,
+// and returns the cursor of the item on which the iteration was stopped by
+// the fn. The returned cursor can be set as the Cursor option to continue
+// the iteration with the next item. If all items are iterated on, the
+// returned cursor is nil.
+func (f Index) IterateCursor(fn IndexIterFunc, options *IterateOptions) (next Cursor, err error) {
 	if options == nil {
 		options = new(IterateOptions)
 	}
 	// construct a prefix with Index prefix and optional common key prefix
-	prefix := append(f.prefix, options.Prefix...)
+	prefix := append(append(make([]byte, 0, len(f.prefix)+len(options.Prefix)), f.prefix...), options.Prefix...)
 	// start from the prefix
 	startKey := prefix
-	if options.StartFrom != nil {
+	skipStartKey := options.SkipStartFromItem
+	switch {
+	case options.Cursor != nil:
+		if !bytes.HasPrefix(options.Cursor, prefix) {
+			return nil, ErrInvalidCursor
+		}
+		startKey = options.Cursor
+		skipStartKey = true
+	case options.StartFrom != nil:
 		// start from the provided StartFrom Item key value
 		startKey, err = f.encodeKeyFunc(*options.StartFrom)
 		if err != nil {
-			return fmt.Errorf("encode key: %w", err)
+			return nil, fmt.Errorf("encode key: %w", err)
+		}
+	case options.Reverse:
+		// start from the last key with the prefix
+		startKey = nil
+	}
+	var untilKey []byte
+	if options.Until != nil {
+		untilKey, err = f.encodeKeyFunc(*options.Until)
+		if err != nil {
+			return nil, fmt.Errorf("encode key: %w", err)
 		}
 	}
 
@@ -331,78 +398,68 @@ func (f Index) Iterate(fn IndexIterFunc, options *IterateOptions) (err error) {
 	defer it.Release()
 
 	var ok bool
-
-	// move the cursor to the start key
-	ok = it.Seek(startKey)
-
-	if !options.Reverse {
-		if !ok {
-			// stop iterator if seek has failed
-			return it.Error()
-		}
-	} else {
-		// reverse seeker
-		if options.StartFrom != nil {
-			if !ok {
-				return it.Error()
-			}
-		} else {
-			// find last key for this index (and prefix)
-
-			// move cursor to last key
-			ok = it.Last()
-			if !ok {
-				return it.Error()
-			}
-
-			if lastKeyHasPrefix := bytes.HasPrefix(it.Key(), prefix); !lastKeyHasPrefix {
-				// increment last prefix byte (that is not 0xFF) to try to find last key
-				incrementedPrefix := bytesIncrement(prefix)
-				if incrementedPrefix == nil {
-					return fmt.Errorf("index iterator invalid prefix: %v -> %v", prefix, string(prefix))
-				}
-
-				// should find first key after prefix (same or different index)
-				ok = it.Seek(incrementedPrefix)
-				if !ok {
-					return it.Error()
-				}
-
-				// previous key should have proper prefix
-				ok = it.Prev()
-				if !ok {
-					return it.Error()
-				}
-			}
-		}
-	}
-
 	itSeekerFn := it.Next
 	if options.Reverse {
 		itSeekerFn = it.Prev
+		if startKey == nil {
+			ok = seekBefore(it, bytesIncrement(prefix))
+		} else {
+			ok = seekAtOrBefore(it, startKey)
+		}
+	} else {
+		// move the cursor to the start key
+		ok = it.Seek(startKey)
 	}
-	if options.SkipStartFromItem && bytes.Equal(startKey, it.Key()) {
-		// skip the start from Item if it is the first key
+	if ok && skipStartKey && bytes.Equal(startKey, it.Key()) {
+		// skip the start key if it is the first key
 		// and it is explicitly configured to skip it
 		ok = itSeekerFn()
 	}
 	for ; ok; ok = itSeekerFn() {
+		if untilKey != nil {
+			c := bytes.Compare(it.Key(), untilKey)
+			if (!options.Reverse && c >= 0) || (options.Reverse && c <= 0) {
+				break
+			}
+		}
 		item, err := f.itemFromIterator(it, prefix)
 		if err != nil {
 			if errors.Is(err, leveldb.ErrNotFound) {
 				break
 			}
-			return fmt.Errorf("get item from iterator: %w", err)
+			return nil, fmt.Errorf("get item from iterator: %w", err)
 		}
 		stop, err := fn(item)
 		if err != nil {
-			return fmt.Errorf("index iterator function: %w", err)
+			return nil, fmt.Errorf("index iterator function: %w", err)
 		}
 		if stop {
+			next = append(Cursor(nil), it.Key()...)
 			break
 		}
 	}
-	return it.Error()
+	return next, it.Error()
+}
+
+// seekAtOrBefore moves the iterator to the last key
+// that is lower than or equal to the key.
+func seekAtOrBefore(it iterator.Iterator, key []byte) bool {
+	if !it.Seek(key) {
+		return it.Last()
+	}
+	if bytes.Equal(it.Key(), key) {
+		return true
+	}
+	return it.Prev()
+}
+
+// seekBefore moves the iterator to the last key that is lower than the
+// key. If the key is nil, the iterator is moved to the last key.
+func seekBefore(it iterator.Iterator, key []byte) bool {
+	if key == nil || !it.Seek(key) {
+		return it.Last()
+	}
+	return it.Prev()
 }
 
 // bytesIncrement increments the last byte that is not 0xFF, and returns
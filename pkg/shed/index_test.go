@@ -1544,3 +1544,159 @@ func TestIndex_HasMulti(t *testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
+
+// TestIndex_IterateCursor validates that iteration is resumed
+// from cursors returned by IterateCursor in both orders.
+func TestIndex_IterateCursor(t *testing.T) {
+	db := newTestDB(t)
+
+	index, err := db.NewIndex("retrieval", retrievalIndexFuncs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := make([]Item, 10)
+	for i := range items {
+		items[i] = Item{
+			Address: []byte(fmt.Sprintf("iterate-hash-%02d", i)),
+			Data:    []byte(fmt.Sprintf("data%d", i)),
+		}
+		if err := index.Put(items[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, reverse := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reverse %v", reverse), func(t *testing.T) {
+			const pageSize = 3
+			var got []Item
+			var cursor Cursor
+			for pages := 0; ; pages++ {
+				if pages > len(items) {
+					t.Fatal("iteration did not end")
+				}
+				var page int
+				next, err := index.IterateCursor(func(item Item) (stop bool, err error) {
+					got = append(got, item)
+					page++
+					return page == pageSize, nil
+				}, &IterateOptions{
+					Cursor:  cursor,
+					Reverse: reverse,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if next == nil {
+					break
+				}
+				// cursors survive encoding
+				cursor, err = ParseCursor(next.String())
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(got) != len(items) {
+				t.Fatalf("got %v items, expected %v", len(got), len(items))
+			}
+			for i, item := range got {
+				want := items[i]
+				if reverse {
+					want = items[len(items)-1-i]
+				}
+				checkItem(t, item, want)
+			}
+		})
+	}
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, err := index.IterateCursor(func(item Item) (stop bool, err error) {
+			return false, nil
+		}, &IterateOptions{
+			Cursor: Cursor("invalid"),
+		})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("got error %v, want %v", err, ErrInvalidCursor)
+		}
+		if _, err := ParseCursor("not hex"); !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("got error %v, want %v", err, ErrInvalidCursor)
+		}
+	})
+}
+
+// TestIndex_IterateUntil validates that iteration
+// stops before the Until item in both orders.
+func TestIndex_IterateUntil(t *testing.T) {
+	db := newTestDB(t)
+
+	index, err := db.NewIndex("retrieval", retrievalIndexFuncs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only items with even numbers are stored
+	items := make([]Item, 5)
+	for i := range items {
+		items[i] = Item{
+			Address: []byte(fmt.Sprintf("iterate-hash-%02d", 2*i)),
+			Data:    []byte(fmt.Sprintf("data%d", i)),
+		}
+		if err := index.Put(items[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	item := func(n int) *Item {
+		return &Item{Address: []byte(fmt.Sprintf("iterate-hash-%02d", n))}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		options *IterateOptions
+		want    []Item
+	}{
+		{
+			name:    "forward",
+			options: &IterateOptions{StartFrom: item(2), Until: item(6)},
+			want:    items[1:3],
+		},
+		{
+			name:    "forward missing bounds",
+			options: &IterateOptions{StartFrom: item(1), Until: item(5)},
+			want:    items[1:3],
+		},
+		{
+			name:    "reverse",
+			options: &IterateOptions{StartFrom: item(6), Until: item(2), Reverse: true},
+			want:    []Item{items[3], items[2]},
+		},
+		{
+			name:    "reverse missing bounds",
+			options: &IterateOptions{StartFrom: item(7), Until: item(3), Reverse: true},
+			want:    []Item{items[3], items[2]},
+		},
+		{
+			name:    "reverse from after the last item",
+			options: &IterateOptions{StartFrom: item(99), Until: item(5), Reverse: true},
+			want:    []Item{items[4], items[3]},
+		},
+		{
+			name:    "empty range",
+			options: &IterateOptions{StartFrom: item(4), Until: item(4)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []Item
+			err := index.Iterate(func(item Item) (stop bool, err error) {
+				got = append(got, item)
+				return false, nil
+			}, tc.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %v items, expected %v", len(got), len(tc.want))
+			}
+			for i := range got {
+				checkItem(t, got[i], tc.want[i])
+			}
+		})
+	}
+}
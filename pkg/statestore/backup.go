@@ -0,0 +1,135 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package statestore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/penguintop/penguin/pkg/storage"
+)
+
+// SchemaKey is the key of the schema name which every store backend
+// manages by itself. It is not copied between stores.
+const SchemaKey = "statestore_schema"
+
+// copyBatchSize is the number of entries written in a single batch.
+const copyBatchSize = 1000
+
+// rawValue is a value which is stored without encoding.
+type rawValue []byte
+
+func (v rawValue) MarshalBinary() ([]byte, error) {
+	return v, nil
+}
+
+// Copy copies all entries, except the schema name, from the src to the
+// dst store and returns the number of copied entries.
+func Copy(dst, src storage.StateStorer) (n int, err error) {
+	var batch []entry
+	err = src.Iterate("", func(key, value []byte) (stop bool, err error) {
+		if string(key) == SchemaKey {
+			return false, nil
+		}
+		// iterated values may be reused by the store
+		batch = append(batch, entry{Key: string(key), Value: append([]byte(nil), value...)})
+		if len(batch) == copyBatchSize {
+			if err := putEntries(dst, batch); err != nil {
+				return true, err
+			}
+			n += len(batch)
+			batch = batch[:0]
+		}
+		return false, nil
+	})
+	if err != nil {
+		return n, err
+	}
+	if err := putEntries(dst, batch); err != nil {
+		return n, err
+	}
+	return n + len(batch), nil
+}
+
+func putEntries(s storage.StateStorer, entries []entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return s.Batch(func(b storage.StateBatch) error {
+		for _, e := range entries {
+			if err := b.Put(e.Key, rawValue(e.Value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// entry is a dumped state entry.
+type entry struct {
+	Key string `json:"key"`
+	// Value is a raw value which is not valid JSON.
+	Value []byte `json:"value,omitempty"`
+	// JSON is a value which is valid JSON, so that
+	// the dump of JSON values is human readable.
+	JSON json.RawMessage `json:"json,omitempty"`
+}
+
+// Dump writes all entries of the store as JSON objects separated by
+// newlines and returns the number of written entries.
+func Dump(w io.Writer, s storage.StateStorer) (n int, err error) {
+	enc := json.NewEncoder(w)
+	err = s.Iterate("", func(key, value []byte) (stop bool, err error) {
+		e := entry{Key: string(key)}
+		if len(value) > 0 && json.Valid(value) {
+			e.JSON = value
+		} else {
+			e.Value = value
+		}
+		if err := enc.Encode(e); err != nil {
+			return true, err
+		}
+		n++
+		return false, nil
+	})
+	return n, err
+}
+
+// Restore writes the entries of a dump, except the schema name, to the
+// store and returns the number of restored entries.
+func Restore(r io.Reader, s storage.StateStorer) (n int, err error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var batch []entry
+	for {
+		var e entry
+		if err := dec.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return n, fmt.Errorf("decode entry %d: %w", n+len(batch)+1, err)
+		}
+		if e.Key == SchemaKey {
+			continue
+		}
+		if e.JSON != nil {
+			e.Value = e.JSON
+		}
+		batch = append(batch, e)
+		if len(batch) == copyBatchSize {
+			if err := putEntries(s, batch); err != nil {
+				return n, err
+			}
+			n += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := putEntries(s, batch); err != nil {
+		return n, err
+	}
+	return n + len(batch), nil
+}
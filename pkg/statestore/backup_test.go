@@ -0,0 +1,137 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package statestore_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/statestore"
+	"github.com/penguintop/penguin/pkg/statestore/bolt"
+	"github.com/penguintop/penguin/pkg/statestore/leveldb"
+	"github.com/penguintop/penguin/pkg/statestore/mock"
+	"github.com/penguintop/penguin/pkg/storage"
+)
+
+// TestCopy validates that entries are copied between store backends
+// without overwriting the schema name of the destination store.
+func TestCopy(t *testing.T) {
+	dir := t.TempDir()
+	logger := logging.New(&bytes.Buffer{}, 0)
+
+	src, err := leveldb.NewStateStore(filepath.Join(dir, "leveldb"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := bolt.NewStateStore(filepath.Join(dir, "statestore.db"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	const count = 2500
+	putEntries(t, src, count)
+
+	var schema, dstSchema []byte
+	if err := dst.Iterate(statestore.SchemaKey, func(key, value []byte) (bool, error) {
+		dstSchema = value
+		return true, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := statestore.Copy(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != count {
+		t.Fatalf("got %d copied entries, want %d", n, count)
+	}
+	checkEntries(t, dst, count)
+
+	if err := dst.Iterate(statestore.SchemaKey, func(key, value []byte) (bool, error) {
+		schema = value
+		return true, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(schema, dstSchema) {
+		t.Fatalf("got schema %q, want %q", schema, dstSchema)
+	}
+}
+
+// TestDumpRestore validates that a dump
+// is restored with values of all encodings.
+func TestDumpRestore(t *testing.T) {
+	src := mock.NewStateStore()
+	const count = 1500
+	putEntries(t, src, count)
+	addr := penguin.MustParseHexAddress("ca1e9f3938cc1425c6061b96ad9eb93e134dfe8734ad490164ef20af9d1cf59c")
+	if err := src.Put("binary", addr); err != nil {
+		t.Fatal(err)
+	}
+
+	var dump bytes.Buffer
+	n, err := statestore.Dump(&dump, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the mock store has a schema name entry
+	if n != count+2 {
+		t.Fatalf("got %d dumped entries, want %d", n, count+2)
+	}
+
+	dst := mock.NewStateStore()
+	n, err = statestore.Restore(&dump, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != count+2 {
+		t.Fatalf("got %d restored entries, want %d", n, count+2)
+	}
+	checkEntries(t, dst, count)
+
+	var got penguin.Address
+	if err := dst.Get("binary", &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(addr) {
+		t.Fatalf("got address %s, want %s", got, addr)
+	}
+
+	if _, err := statestore.Restore(bytes.NewBufferString("{invalid"), dst); err == nil {
+		t.Fatal("invalid dump restored")
+	}
+}
+
+func putEntries(t *testing.T, s storage.StateStorer, count int) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		if err := s.Put(fmt.Sprintf("entry_%d", i), big.NewInt(int64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkEntries(t *testing.T, s storage.StateStorer, count int) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		var v big.Int
+		if err := s.Get(fmt.Sprintf("entry_%d", i), &v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Int64() != int64(i) {
+			t.Fatalf("got value %v of entry %d", &v, i)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bolt provides a statestore implementation backed by BoltDB,
+// which writes every change in a transaction synced to the disk before
+// the call returns.
+package bolt
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/statestore/leveldb"
+	"github.com/penguintop/penguin/pkg/storage"
+	ldb "github.com/syndtr/goleveldb/leveldb"
+	bbolt "go.etcd.io/bbolt"
+)
+
+var _ storage.StateStorer = (*store)(nil)
+
+// openTimeout limits the wait for the lock of a
+// database file which is opened by another process.
+const openTimeout = 5 * time.Second
+
+// stateBucket holds all state entries.
+var stateBucket = []byte("state")
+
+// store uses BoltDB to store values.
+type store struct {
+	db     *bbolt.DB
+	logger logging.Logger
+}
+
+// NewStateStore creates a new persistent state storage in the database file.
+// The statestore schema migrations are the ones of the LevelDB statestore.
+func NewStateStore(path string, l logging.Logger) (storage.StateStorer, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+	s := &store{
+		db:     db,
+		logger: l,
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(stateBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("init bucket: %w", err)
+	}
+
+	if err := leveldb.Migrate(s, l); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	return s, nil
+}
+
+// Get retrieves a value of the requested key. If no results are found,
+// storage.ErrNotFound will be returned.
+func (s *store) Get(key string, i interface{}) error {
+	var data []byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(stateBucket).Get([]byte(key))
+		if v == nil {
+			return storage.ErrNotFound
+		}
+		// values are valid only during the transaction
+		data = append([]byte(nil), v...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if unmarshaler, ok := i.(encoding.BinaryUnmarshaler); ok {
+		return unmarshaler.UnmarshalBinary(data)
+	}
+
+	return json.Unmarshal(data, i)
+}
+
+// Put stores a value for an arbitrary key. BinaryMarshaler
+// interface method will be called on the provided value
+// with fallback to JSON serialization.
+func (s *store) Put(key string, i interface{}) (err error) {
+	bytes, err := marshal(i)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(stateBucket).Put([]byte(key), bytes)
+	})
+}
+
+// Delete removes entries stored under a specific key.
+func (s *store) Delete(key string) (err error) {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(stateBucket).Delete([]byte(key))
+	})
+}
+
+// Iterate entries that match the supplied prefix.
+func (s *store) Iterate(prefix string, iterFunc storage.StateIterFunc) (err error) {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(stateBucket).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			stop, err := iterFunc(append([]byte(nil), k...), append([]byte(nil), v...))
+			if err != nil {
+				return err
+			}
+			if stop {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// Batch writes the changes of the batch in a single transaction. The
+// transaction holds the write lock of the database until the batch
+// function returns, so the function must not call the methods of the
+// store, which would deadlock.
+func (s *store) Batch(fn func(b storage.StateBatch) error) (err error) {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return fn(&batch{bucket: tx.Bucket(stateBucket)})
+	})
+}
+
+// batch writes changes to the bucket of a transaction.
+type batch struct {
+	bucket *bbolt.Bucket
+}
+
+func (b *batch) Put(key string, i interface{}) (err error) {
+	bytes, err := marshal(i)
+	if err != nil {
+		return err
+	}
+	return b.bucket.Put([]byte(key), bytes)
+}
+
+func (b *batch) Delete(key string) (err error) {
+	return b.bucket.Delete([]byte(key))
+}
+
+// marshal encodes the value with the BinaryMarshaler
+// interface method or with JSON serialization.
+func marshal(i interface{}) (bytes []byte, err error) {
+	if marshaler, ok := i.(encoding.BinaryMarshaler); ok {
+		bytes, err = marshaler.MarshalBinary()
+	} else {
+		bytes, err = json.Marshal(i)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bytes == nil {
+		// BoltDB does not store nil values
+		bytes = []byte{}
+	}
+	return bytes, nil
+}
+
+// DB implements StateStorer.DB method. The store is not backed by
+// leveldb, so nil is returned.
+func (s *store) DB() *ldb.DB {
+	return nil
+}
+
+// Close releases the resources used by the store.
+func (s *store) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bolt_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/statestore"
+	"github.com/penguintop/penguin/pkg/statestore/bolt"
+	"github.com/penguintop/penguin/pkg/statestore/test"
+	"github.com/penguintop/penguin/pkg/storage"
+	bbolt "go.etcd.io/bbolt"
+)
+
+func TestPersistentStateStore(t *testing.T) {
+	test.Run(t, func(t *testing.T) storage.StateStorer {
+		store, err := bolt.NewStateStore(filepath.Join(t.TempDir(), "statestore.db"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		return store
+	})
+
+	test.RunPersist(t, func(t *testing.T, dir string) storage.StateStorer {
+		store, err := bolt.NewStateStore(filepath.Join(dir, "statestore.db"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return store
+	})
+}
+
+// TestMigrate tests that the statestore schema migrations run on an
+// existing store.
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statestore.db")
+
+	// a key which is deleted by the migration from the grace schema
+	staleKey := "stale|0123456789abcdef0123456789abcdef"
+
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("state"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(statestore.SchemaKey), []byte("grace")); err != nil {
+			return err
+		}
+		return b.Put([]byte(staleKey), []byte("{}"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := bolt.NewStateStore(path, logging.New(ioutil.Discard, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var v struct{}
+	if err := store.Get(staleKey, &v); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+
+	var schema []byte
+	if err := store.Iterate(statestore.SchemaKey, func(_, value []byte) (bool, error) {
+		schema = value
+		return true, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(schema) == 0 || string(schema) == "grace" {
+		t.Fatalf("schema %q not migrated", schema)
+	}
+}
@@ -2,6 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package statestore provides statestore implementation
-// and mock. It uses leveldb under the hood.
+// Package statestore provides statestore implementations backed by
+// leveldb and bolt, a mock, and the copy, dump and restore of entries
+// between statestores.
 package statestore
@@ -90,18 +90,23 @@ func (s *store) Get(key string, i interface{}) error {
 // interface method will be called on the provided value
 // with fallback to JSON serialization.
 func (s *store) Put(key string, i interface{}) (err error) {
-	var bytes []byte
-	if marshaler, ok := i.(encoding.BinaryMarshaler); ok {
-		if bytes, err = marshaler.MarshalBinary(); err != nil {
-			return err
-		}
-	} else if bytes, err = json.Marshal(i); err != nil {
+	bytes, err := marshal(i)
+	if err != nil {
 		return err
 	}
 
 	return s.db.Put([]byte(key), bytes, nil)
 }
 
+// marshal encodes the value with the BinaryMarshaler
+// interface method or with JSON serialization.
+func marshal(i interface{}) (bytes []byte, err error) {
+	if marshaler, ok := i.(encoding.BinaryMarshaler); ok {
+		return marshaler.MarshalBinary()
+	}
+	return json.Marshal(i)
+}
+
 // Delete removes entries stored under a specific key.
 func (s *store) Delete(key string) (err error) {
 	return s.db.Delete([]byte(key), nil)
@@ -123,6 +128,34 @@ func (s *store) Iterate(prefix string, iterFunc storage.StateIterFunc) (err erro
 	return iter.Error()
 }
 
+// Batch writes the changes of the batch in a single LevelDB batch.
+func (s *store) Batch(fn func(b storage.StateBatch) error) (err error) {
+	b := &batch{batch: new(leveldb.Batch)}
+	if err := fn(b); err != nil {
+		return err
+	}
+	return s.db.Write(b.batch, nil)
+}
+
+// batch collects changes in a LevelDB batch.
+type batch struct {
+	batch *leveldb.Batch
+}
+
+func (b *batch) Put(key string, i interface{}) (err error) {
+	bytes, err := marshal(i)
+	if err != nil {
+		return err
+	}
+	b.batch.Put([]byte(key), bytes)
+	return nil
+}
+
+func (b *batch) Delete(key string) (err error) {
+	b.batch.Delete([]byte(key))
+	return nil
+}
+
 func (s *store) getSchemaName() (string, error) {
 	name, err := s.db.Get([]byte(dbSchemaKey), nil)
 	if err != nil {
@@ -20,6 +20,9 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/storage"
 )
 
 var (
@@ -40,19 +43,19 @@ var (
 )
 
 type migration struct {
-	name string               // name of the schema
-	fn   func(s *store) error // the migration function that needs to be performed in order to get to the current schema name
+	name string                                                   // name of the schema
+	fn   func(s storage.StateStorer, logger logging.Logger) error // the migration function that needs to be performed in order to get to the current schema name
 }
 
 // schemaMigrations contains an ordered list of the database schemes, that is
 // in order to run data migrations in the correct sequence
 var schemaMigrations = []migration{
-	{name: dbSchemaGrace, fn: func(s *store) error { return nil }},
+	{name: dbSchemaGrace, fn: func(storage.StateStorer, logging.Logger) error { return nil }},
 	{name: dbSchemaDrain, fn: migrateGrace},
 	{name: dbSchemaCleanInterval, fn: migrateGrace},
 }
 
-func migrateGrace(s *store) error {
+func migrateGrace(s storage.StateStorer, logger logging.Logger) error {
 	var collectedKeys []string
 	mgfn := func(k, v []byte) (bool, error) {
 		stk := string(k)
@@ -60,7 +63,7 @@ func migrateGrace(s *store) error {
 			len(k) > 32 &&
 			!strings.Contains(stk, "swap") &&
 			!strings.Contains(stk, "peer") {
-			s.logger.Debugf("found key designated to deletion %s", k)
+			logger.Debugf("found key designated to deletion %s", k)
 			collectedKeys = append(collectedKeys, stk)
 		}
 
@@ -72,20 +75,54 @@ func migrateGrace(s *store) error {
 	for _, v := range collectedKeys {
 		err := s.Delete(v)
 		if err != nil {
-			s.logger.Debugf("error deleting key %s", v)
+			logger.Debugf("error deleting key %s", v)
 			continue
 		}
-		s.logger.Debugf("deleted key %s", v)
+		logger.Debugf("deleted key %s", v)
 	}
-	s.logger.Debugf("deleted keys: %d", len(collectedKeys))
+	logger.Debugf("deleted keys: %d", len(collectedKeys))
 
 	return nil
 }
 
+// Migrate brings a statestore of another backend, which keeps the schema
+// name under the same key, to the current schema with the same migrations
+// as the LevelDB statestore. The current schema name is put into a new
+// statestore.
+func Migrate(s storage.StateStorer, logger logging.Logger) error {
+	var sn schemaName
+	if err := s.Get(dbSchemaKey, &sn); err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("get schema name: %w", err)
+		}
+		if err := s.Put(dbSchemaKey, schemaName(dbSchemaCurrent)); err != nil {
+			return fmt.Errorf("put schema name: %w", err)
+		}
+		return nil
+	}
+	return migrate(s, logger, string(sn))
+}
+
+// schemaName is the schema name which is stored without encoding.
+type schemaName string
+
+func (n schemaName) MarshalBinary() ([]byte, error) {
+	return []byte(n), nil
+}
+
+func (n *schemaName) UnmarshalBinary(data []byte) error {
+	*n = schemaName(data)
+	return nil
+}
+
 func (s *store) migrate(schemaName string) error {
-	migrations, err := getMigrations(schemaName, dbSchemaCurrent, schemaMigrations, s)
+	return migrate(s, s.logger, schemaName)
+}
+
+func migrate(s storage.StateStorer, logger logging.Logger, current string) error {
+	migrations, err := getMigrations(current, dbSchemaCurrent, schemaMigrations, logger)
 	if err != nil {
-		return fmt.Errorf("error getting migrations for current schema (%s): %w", schemaName, err)
+		return fmt.Errorf("error getting migrations for current schema (%s): %w", current, err)
 	}
 
 	// no migrations to run
@@ -93,21 +130,23 @@ func (s *store) migrate(schemaName string) error {
 		return nil
 	}
 
-	s.logger.Debugf("statestore: need to run %d data migrations to schema %s", len(migrations), schemaName)
+	logger.Debugf("statestore: need to run %d data migrations to schema %s", len(migrations), current)
 	for i := 0; i < len(migrations); i++ {
-		err := migrations[i].fn(s)
+		err := migrations[i].fn(s, logger)
 		if err != nil {
 			return err
 		}
-		err = s.putSchemaName(migrations[i].name) // put the name of the current schema
+		err = s.Put(dbSchemaKey, schemaName(migrations[i].name)) // put the name of the current schema
 		if err != nil {
 			return err
 		}
-		schemaName, err = s.getSchemaName()
+		var sn schemaName
+		err = s.Get(dbSchemaKey, &sn)
 		if err != nil {
 			return err
 		}
-		s.logger.Debugf("statestore: successfully ran migration: id %d current schema: %s", i, schemaName)
+		current = string(sn)
+		logger.Debugf("statestore: successfully ran migration: id %d current schema: %s", i, current)
 	}
 	return nil
 }
@@ -115,7 +154,7 @@ func (s *store) migrate(schemaName string) error {
 // getMigrations returns an ordered list of migrations that need be executed
 // with no errors in order to bring the statestore to the most up-to-date
 // schema definition
-func getMigrations(currentSchema, targetSchema string, allSchemeMigrations []migration, store *store) (migrations []migration, err error) {
+func getMigrations(currentSchema, targetSchema string, allSchemeMigrations []migration, logger logging.Logger) (migrations []migration, err error) {
 	foundCurrent := false
 	foundTarget := false
 	if currentSchema == dbSchemaCurrent {
@@ -128,7 +167,7 @@ func getMigrations(currentSchema, targetSchema string, allSchemeMigrations []mig
 				return nil, errors.New("found schema name for the second time when looking for migrations")
 			}
 			foundCurrent = true
-			store.logger.Debugf("statestore migration: found current schema %s, migrate to %s, total migrations %d", currentSchema, dbSchemaCurrent, len(allSchemeMigrations)-i)
+			logger.Debugf("statestore migration: found current schema %s, migrate to %s, total migrations %d", currentSchema, dbSchemaCurrent, len(allSchemeMigrations)-i)
 			continue // current schema migration should not be executed (already has been when schema was migrated to)
 		case targetSchema:
 			foundTarget = true
@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/storage"
 )
 
 func TestOneMigration(t *testing.T) {
@@ -37,11 +38,11 @@ func TestOneMigration(t *testing.T) {
 	ran := false
 	shouldNotRun := false
 	schemaMigrations = []migration{
-		{name: dbSchemaCode, fn: func(db *store) error {
+		{name: dbSchemaCode, fn: func(storage.StateStorer, logging.Logger) error {
 			shouldNotRun = true // this should not be executed
 			return nil
 		}},
-		{name: dbSchemaNext, fn: func(db *store) error {
+		{name: dbSchemaNext, fn: func(storage.StateStorer, logging.Logger) error {
 			ran = true
 			return nil
 		}},
@@ -108,23 +109,23 @@ func TestManyMigrations(t *testing.T) {
 	executionOrder := []int{-1, -1, -1, -1}
 
 	schemaMigrations = []migration{
-		{name: dbSchemaCode, fn: func(db *store) error {
+		{name: dbSchemaCode, fn: func(storage.StateStorer, logging.Logger) error {
 			shouldNotRun = true // this should not be executed
 			return nil
 		}},
-		{name: "keju", fn: func(db *store) error {
+		{name: "keju", fn: func(storage.StateStorer, logging.Logger) error {
 			executionOrder[0] = 0
 			return nil
 		}},
-		{name: "coconut", fn: func(db *store) error {
+		{name: "coconut", fn: func(storage.StateStorer, logging.Logger) error {
 			executionOrder[1] = 1
 			return nil
 		}},
-		{name: "mango", fn: func(db *store) error {
+		{name: "mango", fn: func(storage.StateStorer, logging.Logger) error {
 			executionOrder[2] = 2
 			return nil
 		}},
-		{name: "salvation", fn: func(db *store) error {
+		{name: "salvation", fn: func(storage.StateStorer, logging.Logger) error {
 			executionOrder[3] = 3
 			return nil
 		}},
@@ -192,15 +193,15 @@ func TestMigrationErrorFrom(t *testing.T) {
 
 	shouldNotRun := false
 	schemaMigrations = []migration{
-		{name: "langur", fn: func(db *store) error {
+		{name: "langur", fn: func(storage.StateStorer, logging.Logger) error {
 			shouldNotRun = true
 			return nil
 		}},
-		{name: "coconut", fn: func(db *store) error {
+		{name: "coconut", fn: func(storage.StateStorer, logging.Logger) error {
 			shouldNotRun = true
 			return nil
 		}},
-		{name: "chutney", fn: func(db *store) error {
+		{name: "chutney", fn: func(storage.StateStorer, logging.Logger) error {
 			shouldNotRun = true
 			return nil
 		}},
@@ -243,15 +244,15 @@ func TestMigrationErrorTo(t *testing.T) {
 
 	shouldNotRun := false
 	schemaMigrations = []migration{
-		{name: "langur", fn: func(db *store) error {
+		{name: "langur", fn: func(storage.StateStorer, logging.Logger) error {
 			shouldNotRun = true
 			return nil
 		}},
-		{name: "coconut", fn: func(db *store) error {
+		{name: "coconut", fn: func(storage.StateStorer, logging.Logger) error {
 			shouldNotRun = true
 			return nil
 		}},
-		{name: "chutney", fn: func(db *store) error {
+		{name: "chutney", fn: func(storage.StateStorer, logging.Logger) error {
 			shouldNotRun = true
 			return nil
 		}},
@@ -56,12 +56,8 @@ func (s *store) Put(key string, i interface{}) (err error) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
-	var bytes []byte
-	if marshaler, ok := i.(encoding.BinaryMarshaler); ok {
-		if bytes, err = marshaler.MarshalBinary(); err != nil {
-			return err
-		}
-	} else if bytes, err = json.Marshal(i); err != nil {
+	bytes, err := marshal(i)
+	if err != nil {
 		return err
 	}
 
@@ -69,6 +65,13 @@ func (s *store) Put(key string, i interface{}) (err error) {
 	return nil
 }
 
+func marshal(i interface{}) (bytes []byte, err error) {
+	if marshaler, ok := i.(encoding.BinaryMarshaler); ok {
+		return marshaler.MarshalBinary()
+	}
+	return json.Marshal(i)
+}
+
 func (s *store) Delete(key string) (err error) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
@@ -100,6 +103,49 @@ func (s *store) Iterate(prefix string, iterFunc storage.StateIterFunc) (err erro
 	return nil
 }
 
+// Batch applies the changes of the batch under a single lock.
+func (s *store) Batch(fn func(b storage.StateBatch) error) (err error) {
+	b := &batch{changes: make(map[string][]byte)}
+	if err := fn(b); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for k, v := range b.changes {
+		if v == nil {
+			delete(s.store, k)
+			continue
+		}
+		s.store[k] = v
+	}
+	return nil
+}
+
+// batch collects the last change of every key,
+// with nil values for deleted keys.
+type batch struct {
+	changes map[string][]byte
+}
+
+func (b *batch) Put(key string, i interface{}) (err error) {
+	bytes, err := marshal(i)
+	if err != nil {
+		return err
+	}
+	if bytes == nil {
+		bytes = []byte{}
+	}
+	b.changes[key] = bytes
+	return nil
+}
+
+func (b *batch) Delete(key string) (err error) {
+	b.changes[key] = nil
+	return nil
+}
+
 // DB implements StateStorer.DB method.
 func (s *store) DB() *leveldb.DB {
 	return nil
@@ -5,6 +5,7 @@
 package test
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -86,6 +87,7 @@ func Run(t *testing.T, f func(t *testing.T) storage.StateStorer) {
 	t.Run("test_put_get", func(t *testing.T) { testPutGet(t, f) })
 	t.Run("test_delete", func(t *testing.T) { testDelete(t, f) })
 	t.Run("test_iterator", func(t *testing.T) { testIterator(t, f) })
+	t.Run("test_batch", func(t *testing.T) { testBatch(t, f) })
 }
 
 func testDelete(t *testing.T, f func(t *testing.T) storage.StateStorer) {
@@ -113,6 +115,57 @@ func testDelete(t *testing.T, f func(t *testing.T) storage.StateStorer) {
 	testEmpty(t, store)
 }
 
+func testBatch(t *testing.T, f func(t *testing.T) storage.StateStorer) {
+	t.Helper()
+
+	// create a store
+	store := f(t)
+
+	// a failed batch writes no changes
+	errBatch := errors.New("batch error")
+	err := store.Batch(func(b storage.StateBatch) error {
+		if err := b.Put(key1, value1); err != nil {
+			return err
+		}
+		return errBatch
+	})
+	if !errors.Is(err, errBatch) {
+		t.Fatalf("got error %v, want %v", err, errBatch)
+	}
+	testEmpty(t, store)
+
+	// insert values in a batch
+	err = store.Batch(func(b storage.StateBatch) error {
+		if err := b.Put(key1, value1); err != nil {
+			return err
+		}
+		if err := b.Put("deleted", 1); err != nil {
+			return err
+		}
+		if err := b.Delete("deleted"); err != nil {
+			return err
+		}
+		return b.Put(key2, value2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPersistedValues(t, store, key1, key2, value1, value2)
+	testStoreIterator(t, store, "deleted", 0)
+
+	// delete values in a batch
+	err = store.Batch(func(b storage.StateBatch) error {
+		if err := b.Delete(key1); err != nil {
+			return err
+		}
+		return b.Delete(key2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEmpty(t, store)
+}
+
 func testPutGet(t *testing.T, f func(t *testing.T) storage.StateStorer) {
 	t.Helper()
 
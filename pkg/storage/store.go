@@ -20,6 +20,7 @@ var (
 	ErrNotFound        = errors.New("storage: not found")
 	ErrInvalidChunk    = errors.New("storage: invalid chunk")
 	ErrReferenceLength = errors.New("invalid reference length")
+	ErrQuotaExceeded   = errors.New("storage: quota exceeded")
 )
 
 // ModeGet enumerates different Getter modes.
@@ -174,10 +175,20 @@ type StateStorer interface {
 	Put(key string, i interface{}) (err error)
 	Delete(key string) (err error)
 	Iterate(prefix string, iterFunc StateIterFunc) (err error)
-	// DB returns the underlying DB storage.
+	// Batch calls fn with a batch and atomically writes the batch
+	// changes if fn returns no error.
+	Batch(fn func(b StateBatch) error) (err error)
+	// DB returns the underlying DB storage or nil
+	// if the store is not backed by leveldb.
 	DB() *leveldb.DB
 	io.Closer
 }
 
+// StateBatch collects changes of a StateStorer which are written atomically.
+type StateBatch interface {
+	Put(key string, i interface{}) (err error)
+	Delete(key string) (err error)
+}
+
 // StateIterFunc is used when iterating through StateStorer key/value pairs
 type StateIterFunc func(key, value []byte) (stop bool, err error)
@@ -10,4 +10,10 @@ var (
 	SaturationPeers             = &saturationPeers
 	OverSaturationPeers         = &overSaturationPeers
 	BootnodeOverSaturationPeers = &bootnodeOverSaturationPeers
+	PeerLatencyInterval         = &peerLatencyInterval
+	WarmRestartTimeout          = &warmRestartTimeout
 )
+
+func (k *Kad) PruneAddressBook() error {
+	return k.pruneAddressBook()
+}
@@ -23,6 +23,10 @@ const (
 	peerTotalConnectionDuration string = "peer-total-connection-duration"
 )
 
+// latencyEWMASmoothing is the weight of the latest latency
+// sample in the exponentially weighted moving average.
+const latencyEWMASmoothing = 0.1
+
 // PeerConnectionDirection represents peer connection direction.
 type PeerConnectionDirection string
 
@@ -118,6 +122,23 @@ func IncSessionConnectionRetry() RecordOp {
 	}
 }
 
+// PeerLatency records the given round trip time t in the exponentially
+// weighted moving average of the peer latency. The first sample is taken
+// as the average.
+func PeerLatency(t time.Duration) RecordOp {
+	return func(cs *Counters) {
+		cs.Lock()
+		defer cs.Unlock()
+
+		if cs.latencyEWMA == 0 {
+			cs.latencyEWMA = t
+			return
+		}
+		v := latencyEWMASmoothing*float64(t) + (1-latencyEWMASmoothing)*float64(cs.latencyEWMA)
+		cs.latencyEWMA = time.Duration(v)
+	}
+}
+
 // Snapshot represents a snapshot of peers' metrics counters.
 type Snapshot struct {
 	LastSeenTimestamp          int64
@@ -125,6 +146,7 @@ type Snapshot struct {
 	ConnectionTotalDuration    time.Duration
 	SessionConnectionDuration  time.Duration
 	SessionConnectionDirection PeerConnectionDirection
+	LatencyEWMA                time.Duration
 }
 
 // HasAtMaxOneConnectionAttempt returns true if the snapshot represents a new
@@ -156,6 +178,7 @@ type Counters struct {
 	sessionConnRetry     uint64
 	sessionConnDuration  time.Duration
 	sessionConnDirection PeerConnectionDirection
+	latencyEWMA          time.Duration
 
 	// Persistent counters.
 	persistentLastSeenTimestamp atomic.Value
@@ -225,6 +248,7 @@ func (cs *Counters) snapshot(t time.Time) *Snapshot {
 		ConnectionTotalDuration:    connTotalDuration,
 		SessionConnectionDuration:  sessionConnDuration,
 		SessionConnectionDirection: cs.sessionConnDirection,
+		LatencyEWMA:                cs.latencyEWMA,
 	}
 }
 
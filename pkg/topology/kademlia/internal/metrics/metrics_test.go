@@ -94,6 +94,18 @@ func TestPeerMetricsCollector(t *testing.T) {
 		t.Fatalf("Snapshot(%q, ...): session connection retry counter mismatch: have %d; want %d", addr, have, want)
 	}
 
+	// Latency.
+	mc.Record(addr, metrics.PeerLatency(100*time.Millisecond))
+	ss = snapshot(t, mc, t2, addr)
+	if have, want := ss.LatencyEWMA, 100*time.Millisecond; have != want {
+		t.Fatalf("Snapshot(%q, ...): latency EWMA mismatch: have %s; want %s", addr, have, want)
+	}
+	mc.Record(addr, metrics.PeerLatency(200*time.Millisecond))
+	ss = snapshot(t, mc, t2, addr)
+	if have, want := ss.LatencyEWMA, 110*time.Millisecond; have != want {
+		t.Fatalf("Snapshot(%q, ...): latency EWMA mismatch: have %s; want %s", addr, have, want)
+	}
+
 	// Logout.
 	mc.Record(addr, metrics.PeerLogOut(t3))
 	ss = snapshot(t, mc, t2, addr)
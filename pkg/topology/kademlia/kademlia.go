@@ -20,7 +20,10 @@ import (
 	"github.com/penguintop/penguin/pkg/discovery"
 	"github.com/penguintop/penguin/pkg/logging"
 	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/pingpong"
+	"github.com/penguintop/penguin/pkg/reputation"
 	"github.com/penguintop/penguin/pkg/shed"
+	"github.com/penguintop/penguin/pkg/storage"
     "github.com/penguintop/penguin/pkg/penguin"
 	"github.com/penguintop/penguin/pkg/topology"
 	"github.com/penguintop/penguin/pkg/topology/kademlia/internal/metrics"
@@ -66,6 +69,20 @@ type Options struct {
 	StandaloneMode  bool
 	BootnodeMode    bool
 	BitSuffixLength int
+	PeerScorer      reputation.Scorer
+	// StateStore persists the connected peers for the warm restart,
+	// the routing table is not persisted if it is nil.
+	StateStore storage.StateStorer
+	// ConnectionStats reports the connection manager state
+	// in the snapshot, it is not reported if it is nil.
+	ConnectionStats func() p2p.ConnectionStats
+	// ConnectionAllowed reports whether the connection to the peer
+	// would be accepted within the connection limits, the peers over
+	// the limits are neither dialed nor picked if it is set.
+	ConnectionAllowed func(peer penguin.Address, inbound bool) bool
+	// Pinger measures the latencies of the connected
+	// peers, they are not measured if it is nil.
+	Pinger pingpong.Interface
 }
 
 // Kad is the Penguin forwarding kademlia implementation.
@@ -95,6 +112,11 @@ type Kad struct {
 	done              chan struct{} // signal that `manage` has quit
 	wg                sync.WaitGroup
 	waitNext          *waitnext.WaitNext
+	scorer            reputation.Scorer   // scores of peers, nil if peers are not scored
+	store             storage.StateStorer // persists the routing table, nil if it is not persisted
+	connectionStats   func() p2p.ConnectionStats
+	connectionAllowed func(peer penguin.Address, inbound bool) bool // nil if the connections are not limited
+	pinger            pingpong.Interface // measures the latencies of peers, nil if they are not measured
 }
 
 // New returns a new Kademlia.
@@ -139,6 +161,11 @@ func New(
 		halt:              make(chan struct{}),
 		done:              make(chan struct{}),
 		wg:                sync.WaitGroup{},
+		scorer:            o.PeerScorer,
+		store:             o.StateStore,
+		connectionStats:   o.ConnectionStats,
+		connectionAllowed: o.ConnectionAllowed,
+		pinger:            o.Pinger,
 	}
 
 	if k.bitSuffixLength > 0 {
@@ -234,8 +261,12 @@ type peerConnInfo struct {
 
 // connectBalanced attempts to connect to the balanced peers first.
 func (k *Kad) connectBalanced(wg *sync.WaitGroup, peerConnChan chan<- *peerConnInfo) {
+	// Peers with a low score are skipped until their score
+	// decays back, so that more reliable peers are preferred.
+	// Peers over the connection limits, such as the recently
+	// pruned ones, are skipped as they would be rejected.
 	skipPeers := func(peer penguin.Address) bool {
-		return k.waitNext.Waiting(peer)
+		return k.waitNext.Waiting(peer) || k.lowScore(peer) || !k.allowed(peer, false)
 	}
 
 	for i := range k.commonBinPrefixes {
@@ -308,7 +339,7 @@ func (k *Kad) connectNeighbours(wg *sync.WaitGroup, peerConnChan chan<- *peerCon
 			return false, false, nil
 		}
 
-		if k.waitNext.Waiting(addr) {
+		if k.waitNext.Waiting(addr) || !k.allowed(addr, false) {
 			return false, false, nil
 		}
 
@@ -367,19 +398,9 @@ func (k *Kad) connectionAttemptsHandler(ctx context.Context, wg *sync.WaitGroup,
 			return
 		}
 
-		k.waitNext.Set(peer.addr, time.Now().Add(shortRetry), 0)
-
-		k.connectedPeers.Add(peer.addr)
-
-		k.collector.Record(peer.addr, metrics.PeerLogIn(time.Now(), metrics.PeerConnectionDirectionOutbound))
-
-		k.depthMu.Lock()
-		k.depth = recalcDepth(k.connectedPeers, k.radius)
-		k.depthMu.Unlock()
+		k.outboundConnected(peer.addr)
 
 		k.logger.Debugf("kademlia: connected to peer: %q in bin: %d", peer.addr, peer.po)
-		k.notifyManageLoop()
-		k.notifyPeerSig()
 	}
 
 	var (
@@ -439,6 +460,12 @@ func (k *Kad) manage() {
 		cancel()
 	}()
 
+	// reconnect to the peers from before the restart first, the
+	// bootnodes are tried only if no connection can be established
+	if !k.standalone && k.warmRestart(ctx) == 0 {
+		k.notifyManageLoop()
+	}
+
 	// The wg makes sure that we wait for all the connection attempts,
 	// spun up by goroutines, to finish before we try the boot-nodes.
 	var wg sync.WaitGroup
@@ -495,8 +522,15 @@ func (k *Kad) manage() {
 }
 
 func (k *Kad) Start(ctx context.Context) error {
-	k.wg.Add(1)
+	k.wg.Add(3)
 	go k.manage()
+	go k.routingTableLoop()
+	go k.addressBookPruneLoop()
+
+	if k.pinger != nil {
+		k.wg.Add(1)
+		go k.latencyLoop()
+	}
 
 	addresses, err := k.addressBook.Overlays()
 	if err != nil {
@@ -661,9 +695,16 @@ func (k *Kad) connect(ctx context.Context, peer penguin.Address, ma ma.Multiaddr
 		if !i.Overlay.Equal(peer) {
 			return errOverlayMismatch
 		}
+		k.recordDial(peer, true)
 		return nil
 	case errors.Is(err, context.Canceled):
 		return err
+	case errors.Is(err, p2p.ErrConnectionLimit):
+		// the peer is not at fault, retry it later without
+		// recording the failed attempt
+		k.logger.Debugf("could not connect to peer %q: %v", peer, err)
+		k.waitNext.SetTryAfter(peer, time.Now().Add(timeToRetry))
+		return err
 	case err != nil:
 		k.logger.Debugf("could not connect to peer %q: %v", peer, err)
 
@@ -675,6 +716,7 @@ func (k *Kad) connect(ctx context.Context, peer penguin.Address, ma ma.Multiaddr
 		} else {
 			failedAttempts = k.waitNext.Attempts(peer)
 			failedAttempts++
+			k.recordDial(peer, false)
 		}
 
 		k.collector.Record(peer, metrics.IncSessionConnectionRetry())
@@ -701,6 +743,8 @@ func (k *Kad) connect(ctx context.Context, peer penguin.Address, ma ma.Multiaddr
 		return errOverlayMismatch
 	}
 
+	k.recordDial(peer, true)
+
 	return k.Announce(ctx, peer, true)
 }
 
@@ -758,6 +802,9 @@ func (k *Kad) AddPeers(addrs ...penguin.Address) {
 }
 
 func (k *Kad) Pick(peer p2p.Peer) bool {
+	if peer.FullNode && !k.allowed(peer.Address, true) {
+		return false
+	}
 	if k.bootnode {
 		// shortcircuit for bootnode mode - always accept connections,
 		// at least until we find a better solution.
@@ -909,6 +956,8 @@ func (k *Kad) ClosestPeer(addr penguin.Address, includeSelf bool, skipPeers ...p
 	peers := k.p2p.Peers()
 	var peersToDisconnect []penguin.Address
 	var closest = penguin.ZeroAddress
+	// closestScored is the closest peer which does not have a low score
+	var closestScored = penguin.ZeroAddress
 
 	if includeSelf {
 		closest = k.base
@@ -946,6 +995,21 @@ func (k *Kad) ClosestPeer(addr penguin.Address, includeSelf bool, skipPeers ...p
 			// closest is already closer to chunk
 			// do nothing
 		}
+
+		if k.lowScore(peer) {
+			return false, false, nil
+		}
+		if closestScored.IsZero() {
+			closestScored = peer
+			return false, false, nil
+		}
+		dcmp, err = penguin.DistanceCmp(addr.Bytes(), closestScored.Bytes(), peer.Bytes())
+		if err != nil {
+			return false, false, err
+		}
+		if dcmp == -1 {
+			closestScored = peer
+		}
 		return false, false, nil
 	})
 	if err != nil {
@@ -956,6 +1020,25 @@ func (k *Kad) ClosestPeer(addr penguin.Address, includeSelf bool, skipPeers ...p
 		return penguin.Address{}, topology.ErrNotFound // only for light nodes
 	}
 
+	// a closest peer with a low score is replaced by the closest well
+	// scored peer, or by self if it is closer than that peer
+	if !closest.Equal(k.base) && k.lowScore(closest) {
+		selfCloser := includeSelf
+		if includeSelf && !closestScored.IsZero() {
+			dcmp, err := penguin.DistanceCmp(addr.Bytes(), closestScored.Bytes(), k.base.Bytes())
+			if err != nil {
+				return penguin.Address{}, err
+			}
+			selfCloser = dcmp == -1
+		}
+		switch {
+		case selfCloser:
+			closest = k.base
+		case !closestScored.IsZero():
+			closest = closestScored
+		}
+	}
+
 	for _, v := range peersToDisconnect {
 		k.Disconnected(p2p.Peer{Address: v})
 	}
@@ -968,6 +1051,17 @@ func (k *Kad) ClosestPeer(addr penguin.Address, includeSelf bool, skipPeers ...p
 	return closest, nil
 }
 
+// allowed reports whether the connection to the peer
+// in the direction is within the connection limits.
+func (k *Kad) allowed(peer penguin.Address, inbound bool) bool {
+	return k.connectionAllowed == nil || k.connectionAllowed(peer, inbound)
+}
+
+// lowScore reports whether the peer is considered unreliable by its score.
+func (k *Kad) lowScore(peer penguin.Address) bool {
+	return k.scorer != nil && k.scorer.Score(peer) < reputation.LowScore
+}
+
 // IsWithinDepth returns if an address is within the neighborhood depth of a node.
 func (k *Kad) IsWithinDepth(addr penguin.Address) bool {
 	return penguin.Proximity(k.base.Bytes(), addr.Bytes()) >= k.NeighborhoodDepth()
@@ -1124,7 +1218,7 @@ func (k *Kad) Snapshot() *topology.KadParams {
 		return false, false, nil
 	})
 
-	return &topology.KadParams{
+	params := &topology.KadParams{
 		Base:           k.base.String(),
 		Population:     k.knownPeers.Length(),
 		Connected:      k.connectedPeers.Length(),
@@ -1166,6 +1260,11 @@ func (k *Kad) Snapshot() *topology.KadParams {
 			Bin31: infos[31],
 		},
 	}
+	if k.connectionStats != nil {
+		cs := k.connectionStats()
+		params.Connections = &cs
+	}
+	return params
 }
 
 // String returns a string represenstation of Kademlia.
@@ -1183,6 +1282,11 @@ func (k *Kad) String() string {
 // This is needed while we shut down, so that further topology
 // changes do not happen while we shut down.
 func (k *Kad) Halt() {
+	// the routing table is persisted before the connections
+	// are closed on shutdown
+	if err := k.saveRoutingTable(); err != nil {
+		k.logger.Debugf("kademlia: save routing table: %v", err)
+	}
 	close(k.halt)
 }
 
@@ -1253,7 +1357,7 @@ func (k *Kad) randomPeer(bin uint8) (penguin.Address, error) {
 
 // createMetricsSnapshotView creates new topology.MetricSnapshotView from the
 // given metrics.Snapshot and rounds all the timestamps and durations to its
-// nearest second.
+// nearest second, except for the latency which is in milliseconds.
 func createMetricsSnapshotView(ss *metrics.Snapshot) *topology.MetricSnapshotView {
 	if ss == nil {
 		return nil
@@ -1264,5 +1368,6 @@ func createMetricsSnapshotView(ss *metrics.Snapshot) *topology.MetricSnapshotVie
 		ConnectionTotalDuration:    ss.ConnectionTotalDuration.Truncate(time.Second).Seconds(),
 		SessionConnectionDuration:  ss.SessionConnectionDuration.Truncate(time.Second).Seconds(),
 		SessionConnectionDirection: string(ss.SessionConnectionDirection),
+		LatencyEWMA:                ss.LatencyEWMA.Milliseconds(),
 	}
 }
@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"reflect"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -25,6 +26,7 @@ import (
 	"github.com/penguintop/penguin/pkg/p2p"
 	p2pmock "github.com/penguintop/penguin/pkg/p2p/mock"
 	"github.com/penguintop/penguin/pkg/pen"
+	pingpongmock "github.com/penguintop/penguin/pkg/pingpong/mock"
 	mockstate "github.com/penguintop/penguin/pkg/statestore/mock"
     "github.com/penguintop/penguin/pkg/penguin"
     "github.com/penguintop/penguin/pkg/penguin/test"
@@ -843,6 +845,111 @@ func TestAddressBookQuickPrune(t *testing.T) {
 	}
 }
 
+// TestConnectionLimit tests that the peers rejected by the
+// connection limits are retried and not pruned from the addressbook.
+func TestConnectionLimit(t *testing.T) {
+	defer func(t time.Duration) {
+		*kademlia.TimeToRetry = t
+	}(*kademlia.TimeToRetry)
+
+	*kademlia.TimeToRetry = 50 * time.Millisecond
+
+	metricsDB, err := shed.NewDB("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := metricsDB.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	var (
+		attempts int32
+		pk, _    = penCrypto.GenerateSecp256k1Key()
+		signer   = penCrypto.NewDefaultSigner(pk)
+		base     = test.RandomAddress()
+		ab       = addressbook.New(mockstate.NewStateStore())
+		p2ps     = p2pmock.New(p2pmock.WithConnectFunc(func(context.Context, ma.Multiaddr) (*pen.Address, error) {
+			_ = atomic.AddInt32(&attempts, 1)
+			return nil, p2p.ErrConnectionLimit
+		}))
+		kad = kademlia.New(base, ab, mock.NewDiscovery(), p2ps, metricsDB, logging.New(ioutil.Discard, 0), kademlia.Options{})
+	)
+
+	if err := kad.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer kad.Close()
+
+	addr := test.RandomAddressAt(base, 1)
+	addOne(t, signer, kad, ab, addr)
+
+	// more attempts than the failed ones after which the peer is pruned
+	for i := 0; i < 5; i++ {
+		waitCounter(t, &attempts, 1)
+		time.Sleep(50 * time.Millisecond)
+		kad.AddPeers(addr)
+	}
+
+	if _, err := ab.Get(addr); err != nil {
+		t.Fatalf("peer not in addressbook: %v", err)
+	}
+}
+
+// TestConnectionAllowed tests that the peers over the connection
+// limits are neither dialed nor picked.
+func TestConnectionAllowed(t *testing.T) {
+	metricsDB, err := shed.NewDB("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := metricsDB.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	var (
+		attempts int32
+		pk, _    = penCrypto.GenerateSecp256k1Key()
+		signer   = penCrypto.NewDefaultSigner(pk)
+		base     = test.RandomAddress()
+		ab       = addressbook.New(mockstate.NewStateStore())
+		p2ps     = p2pmock.New(p2pmock.WithConnectFunc(func(context.Context, ma.Multiaddr) (*pen.Address, error) {
+			_ = atomic.AddInt32(&attempts, 1)
+			return nil, errors.New("unexpected dial")
+		}))
+		rejected = test.RandomAddressAt(base, 1)
+		allowed  = func(peer penguin.Address, _ bool) bool {
+			return !peer.Equal(rejected)
+		}
+		kad = kademlia.New(base, ab, mock.NewDiscovery(), p2ps, metricsDB, logging.New(ioutil.Discard, 0), kademlia.Options{ConnectionAllowed: allowed})
+	)
+
+	if err := kad.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer kad.Close()
+
+	addOne(t, signer, kad, ab, rejected)
+	time.Sleep(100 * time.Millisecond)
+
+	if got := atomic.LoadInt32(&attempts); got != 0 {
+		t.Fatalf("got %d dial attempts, want none", got)
+	}
+
+	if kad.Pick(p2p.Peer{Address: rejected, FullNode: true}) {
+		t.Fatal("full node over the limits picked")
+	}
+	if !kad.Pick(p2p.Peer{Address: rejected}) {
+		t.Fatal("light node not picked")
+	}
+	if !kad.Pick(p2p.Peer{Address: test.RandomAddressAt(base, 1), FullNode: true}) {
+		t.Fatal("full node within the limits not picked")
+	}
+}
+
 // TestClosestPeer tests that ClosestPeer method returns closest connected peer to a given address.
 func TestClosestPeer(t *testing.T) {
 	metricsDB, err := shed.NewDB("", nil)
@@ -950,6 +1057,141 @@ func TestClosestPeer(t *testing.T) {
 	}
 }
 
+// TestClosestPeerScored validates that the closest well scored peer is
+// preferred to a closer peer with a low score, and that self is preferred
+// to a closer peer with a low score if it is closer than the well scored
+// peers.
+func TestClosestPeerScored(t *testing.T) {
+	metricsDB, err := shed.NewDB("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := metricsDB.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	base := penguin.MustParseHexAddress("0000000000000000000000000000000000000000000000000000000000000000")
+	connectedPeers := []p2p.Peer{
+		{Address: penguin.MustParseHexAddress("8000000000000000000000000000000000000000000000000000000000000000")}, // po 0 to base
+		{Address: penguin.MustParseHexAddress("4000000000000000000000000000000000000000000000000000000000000000")}, // po 1 to base
+		{Address: penguin.MustParseHexAddress("6000000000000000000000000000000000000000000000000000000000000000")}, // po 1 to base
+	}
+	scorer := new(scorerMock)
+
+	p2ps := p2pmock.New(p2pmock.WithPeersFunc(func() []p2p.Peer {
+		return connectedPeers
+	}))
+	kad := kademlia.New(base, addressbook.New(mockstate.NewStateStore()), mock.NewDiscovery(), p2ps, metricsDB, logging.New(ioutil.Discard, 0), kademlia.Options{PeerScorer: scorer})
+	if err := kad.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer kad.Close()
+
+	for _, p := range connectedPeers {
+		if err := kad.Connected(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name         string
+		chunkAddress penguin.Address
+		lowScored    []int // indexes of connectedPeers with a low score
+		expectedPeer int   // index of connectedPeers, -1 means self
+		includeSelf  bool
+	}{
+		{
+			name:         "closest peer",
+			chunkAddress: penguin.MustParseHexAddress("7000000000000000000000000000000000000000000000000000000000000000"),
+			expectedPeer: 2,
+			includeSelf:  true,
+		},
+		{
+			name:         "low scored peer avoided",
+			chunkAddress: penguin.MustParseHexAddress("7000000000000000000000000000000000000000000000000000000000000000"),
+			lowScored:    []int{2},
+			expectedPeer: 1,
+			includeSelf:  true,
+		},
+		{
+			name:         "self closer than well scored peer",
+			chunkAddress: penguin.MustParseHexAddress("7000000000000000000000000000000000000000000000000000000000000000"),
+			lowScored:    []int{1, 2},
+			expectedPeer: -1,
+			includeSelf:  true,
+		},
+		{
+			name:         "self without well scored peer",
+			chunkAddress: penguin.MustParseHexAddress("7000000000000000000000000000000000000000000000000000000000000000"),
+			lowScored:    []int{0, 1, 2},
+			expectedPeer: -1,
+			includeSelf:  true,
+		},
+		{
+			name:         "low scored peer avoided without self",
+			chunkAddress: penguin.MustParseHexAddress("7000000000000000000000000000000000000000000000000000000000000000"),
+			lowScored:    []int{1, 2},
+			expectedPeer: 0,
+			includeSelf:  false,
+		},
+		{
+			name:         "self",
+			chunkAddress: penguin.MustParseHexAddress("2000000000000000000000000000000000000000000000000000000000000000"),
+			expectedPeer: -1,
+			includeSelf:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var low []penguin.Address
+			for _, i := range tc.lowScored {
+				low = append(low, connectedPeers[i].Address)
+			}
+			scorer.setLow(low...)
+
+			peer, err := kad.ClosestPeer(tc.chunkAddress, tc.includeSelf)
+			if tc.expectedPeer == -1 {
+				if !errors.Is(err, topology.ErrWantSelf) {
+					t.Fatalf("got error %v, want %v", err, topology.ErrWantSelf)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := connectedPeers[tc.expectedPeer].Address; !peer.Equal(want) {
+				t.Fatalf("got peer %s, want %s", peer, want)
+			}
+		})
+	}
+}
+
+// scorerMock sets low scores to peers,
+// other peers have a neutral score.
+type scorerMock struct {
+	mu  sync.Mutex
+	low map[string]bool
+}
+
+func (s *scorerMock) setLow(peers ...penguin.Address) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.low = make(map[string]bool)
+	for _, p := range peers {
+		s.low[p.ByteString()] = true
+	}
+}
+
+func (s *scorerMock) Score(peer penguin.Address) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.low[peer.ByteString()] {
+		return 0.1
+	}
+	return 0.5
+}
+
 func TestKademlia_SubscribePeersChange(t *testing.T) {
 	testSignal := func(t *testing.T, k *kademlia.Kad, c <-chan struct{}) {
 		t.Helper()
@@ -1096,6 +1338,72 @@ func TestSnapshot(t *testing.T) {
 	}
 }
 
+func TestSnapshotConnections(t *testing.T) {
+	stats := p2p.ConnectionStats{Inbound: 2, Outbound: 3, MaxTotal: 5}
+	_, kad, _, _, _ := newTestKademlia(t, nil, nil, kademlia.Options{
+		ConnectionStats: func() p2p.ConnectionStats { return stats },
+	})
+
+	if snap := kad.Snapshot(); snap.Connections == nil || *snap.Connections != stats {
+		t.Fatalf("got connections %+v, want %+v", snap.Connections, stats)
+	}
+
+	_, kad, _, _, _ = newTestKademlia(t, nil, nil, kademlia.Options{})
+	if snap := kad.Snapshot(); snap.Connections != nil {
+		t.Fatalf("got connections %+v, want none", snap.Connections)
+	}
+}
+
+func TestPeerLatency(t *testing.T) {
+	defer func(d time.Duration) {
+		*kademlia.PeerLatencyInterval = d
+	}(*kademlia.PeerLatencyInterval)
+
+	*kademlia.PeerLatencyInterval = 10 * time.Millisecond
+
+	pinger := pingpongmock.New(func(context.Context, penguin.Address, ...string) (time.Duration, error) {
+		return 40 * time.Millisecond, nil
+	})
+
+	var conns int32
+	base, kad, ab, _, signer := newTestKademlia(t, &conns, nil, kademlia.Options{Pinger: pinger})
+	if err := kad.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer kad.Close()
+
+	peer := test.RandomAddress()
+	addOne(t, signer, kad, ab, peer)
+	waitConn(t, &conns)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if latency, ok := kad.PeerLatency(peer); ok {
+			if latency != 40*time.Millisecond {
+				t.Fatalf("got latency %s, want %s", latency, 40*time.Millisecond)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("peer latency not measured")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, ok := kad.PeerLatency(test.RandomAddress()); ok {
+		t.Fatal("latency of unknown peer measured")
+	}
+
+	snap := kad.Snapshot()
+	infos := getBinConnectedPeers(&snap.Bins, penguin.Proximity(base.Bytes(), peer.Bytes()))
+	if len(infos) != 1 || !infos[0].Address.Equal(peer) {
+		t.Fatalf("got connected peers %+v, want %s", infos, peer)
+	}
+	if m := infos[0].Metrics; m == nil || m.LatencyEWMA != 40 {
+		t.Fatalf("got metrics %+v, want latency of 40ms", m)
+	}
+}
+
 func getBinPopulation(bins *topology.KadBins, po uint8) uint64 {
 	rv := reflect.ValueOf(bins)
 	bin := fmt.Sprintf("Bin%d", po)
@@ -1104,6 +1412,13 @@ func getBinPopulation(bins *topology.KadBins, po uint8) uint64 {
 	return bp.Uint()
 }
 
+func getBinConnectedPeers(bins *topology.KadBins, po uint8) []*topology.PeerInfo {
+	rv := reflect.ValueOf(bins)
+	bin := fmt.Sprintf("Bin%d", po)
+	b0 := reflect.Indirect(rv).FieldByName(bin)
+	return b0.FieldByName("ConnectedPeers").Interface().([]*topology.PeerInfo)
+}
+
 func TestStart(t *testing.T) {
 	var bootnodes []ma.Multiaddr
 	for i := 0; i < 10; i++ {
@@ -1157,6 +1472,246 @@ func TestStart(t *testing.T) {
 	})
 }
 
+// TestAddressBookDialMetadata tests that the dial outcomes are recorded
+// in the address book and that the dead peers are pruned from it.
+func TestAddressBookDialMetadata(t *testing.T) {
+	var (
+		conns, failedConns       int32
+		base, kad, ab, _, signer = newTestKademlia(t, &conns, &failedConns, kademlia.Options{})
+	)
+
+	if err := kad.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer kad.Close()
+
+	nonConnPeer, err := pen.NewAddress(signer, nonConnectableAddress, test.RandomAddressAt(base, 1), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ab.Put(nonConnPeer.Overlay, *nonConnPeer); err != nil {
+		t.Fatal(err)
+	}
+
+	kad.AddPeers(nonConnPeer.Overlay)
+	waitCounter(t, &failedConns, 1)
+
+	addr := test.RandomAddressAt(base, 2)
+	addOne(t, signer, kad, ab, addr)
+	waitCounter(t, &conns, 1)
+
+	waitMetadata := func(t *testing.T, addr penguin.Address, f func(m *addressbook.Metadata) bool) {
+		t.Helper()
+		for i := 0; i < 50; i++ {
+			m, err := ab.Metadata(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f(m) {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for metadata of peer %s", addr)
+	}
+	waitMetadata(t, nonConnPeer.Overlay, func(m *addressbook.Metadata) bool {
+		return m.ConsecutiveFailures == 1 && m.LastSuccessfulDial.IsZero()
+	})
+	waitMetadata(t, addr, func(m *addressbook.Metadata) bool {
+		return m.ConsecutiveFailures == 0 && !m.LastSuccessfulDial.IsZero()
+	})
+
+	// the connected peers are not pruned regardless of their metadata
+	for i := 0; i < 10; i++ {
+		for _, a := range []penguin.Address{nonConnPeer.Overlay, addr} {
+			if err := ab.RecordDial(a, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := kad.PruneAddressBook(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ab.Get(nonConnPeer.Overlay); err != addressbook.ErrNotFound {
+		t.Fatalf("got error %v, want %v", err, addressbook.ErrNotFound)
+	}
+	if _, err := ab.Get(addr); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestWarmRestart tests that the connected peers are persisted on halt
+// and that they are reconnected on start without dialing the bootnodes.
+func TestWarmRestart(t *testing.T) {
+	var (
+		store       = mockstate.NewStateStore()
+		ab          = addressbook.New(store)
+		pk, _       = penCrypto.GenerateSecp256k1Key()
+		signer      = penCrypto.NewDefaultSigner(pk)
+		base        = test.RandomAddress()
+		logger      = logging.New(ioutil.Discard, 0)
+		conns       int32
+		failedConns int32
+	)
+
+	newKad := func(t *testing.T) *kademlia.Kad {
+		t.Helper()
+		metricsDB, err := shed.NewDB("", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := metricsDB.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+		return kademlia.New(base, ab, mock.NewDiscovery(), p2pMock(ab, signer, &conns, &failedConns), metricsDB, logger, kademlia.Options{
+			Bootnodes:  []ma.Multiaddr{nonConnectableAddress},
+			StateStore: store,
+		})
+	}
+
+	kad := newKad(t)
+	kad.SetRadius(penguin.MaxPO)
+
+	var peers []penguin.Address
+	for i := 0; i < 4; i++ {
+		peer := test.RandomAddressAt(base, i)
+		connectOne(t, signer, kad, ab, peer, nil)
+		peers = append(peers, peer)
+	}
+
+	kad.Halt()
+	if err := kad.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var rt struct {
+		Peers []struct {
+			Address penguin.Address `json:"address"`
+		} `json:"peers"`
+	}
+	if err := store.Get("kademlia_routing_table", &rt); err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.Peers) != len(peers) {
+		t.Fatalf("got %d persisted peers, want %d", len(rt.Peers), len(peers))
+	}
+
+	kad = newKad(t)
+	kad.SetRadius(penguin.MaxPO)
+	if err := kad.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer kad.Close()
+
+	waitPeers(t, kad, len(peers))
+	for _, p := range peers {
+		var found bool
+		_ = kad.EachPeer(func(addr penguin.Address, _ uint8) (bool, bool, error) {
+			if addr.Equal(p) {
+				found = true
+				return true, false, nil
+			}
+			return false, false, nil
+		})
+		if !found {
+			t.Fatalf("peer %s not reconnected", p)
+		}
+	}
+	waitCounter(t, &conns, int32(len(peers)))
+	waitCounter(t, &failedConns, 0)
+}
+
+// TestWarmRestartFallback tests that the bootnodes are
+// dialed when there are no persisted peers to connect to.
+func TestWarmRestartFallback(t *testing.T) {
+	var (
+		conns, failedConns int32
+		_, kad, _, _, _    = newTestKademlia(t, &conns, &failedConns, kademlia.Options{
+			Bootnodes:  []ma.Multiaddr{nonConnectableAddress},
+			StateStore: mockstate.NewStateStore(),
+		})
+	)
+
+	if err := kad.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer kad.Close()
+
+	waitCounter(t, &failedConns, 1)
+}
+
+// TestWarmRestartTimeout tests that the manage loop is not
+// held back by persisted peers which can not be reached.
+func TestWarmRestartTimeout(t *testing.T) {
+	defer func(d time.Duration) {
+		*kademlia.WarmRestartTimeout = d
+	}(*kademlia.WarmRestartTimeout)
+	*kademlia.WarmRestartTimeout = 100 * time.Millisecond
+
+	var (
+		store   = mockstate.NewStateStore()
+		ab      = addressbook.New(store)
+		pk, _   = penCrypto.GenerateSecp256k1Key()
+		signer  = penCrypto.NewDefaultSigner(pk)
+		base    = test.RandomAddress()
+		logger  = logging.New(ioutil.Discard, 0)
+		blocked = test.RandomAddressAt(base, 1)
+		peer    = test.RandomAddressAt(base, 2)
+	)
+
+	underlays := make(map[string]penguin.Address)
+	for _, addr := range []penguin.Address{blocked, peer} {
+		multiaddr, err := ma.NewMultiaddr(underlayBase + addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		penAddr, err := pen.NewAddress(signer, multiaddr, addr, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ab.Put(addr, *penAddr); err != nil {
+			t.Fatal(err)
+		}
+		underlays[multiaddr.String()] = addr
+	}
+
+	// only the unreachable peer was connected before the restart
+	rt := map[string]interface{}{
+		"peers": []map[string]interface{}{{"address": blocked}},
+	}
+	if err := store.Put("kademlia_routing_table", rt); err != nil {
+		t.Fatal(err)
+	}
+
+	p2ps := p2pmock.New(p2pmock.WithConnectFunc(func(ctx context.Context, addr ma.Multiaddr) (*pen.Address, error) {
+		overlay := underlays[addr.String()]
+		if overlay.Equal(blocked) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return ab.Get(overlay)
+	}))
+
+	metricsDB, err := shed.NewDB("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metricsDB.Close()
+
+	kad := kademlia.New(base, ab, mock.NewDiscovery(), p2ps, metricsDB, logger, kademlia.Options{
+		StateStore: store,
+	})
+	if err := kad.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer kad.Close()
+
+	waitPeers(t, kad, 1)
+}
+
 func newTestKademlia(t *testing.T, connCounter, failedConnCounter *int32, kadOpts kademlia.Options) (penguin.Address, *kademlia.Kad, addressbook.Interface, *mock.Discovery, penCrypto.Signer) {
 	t.Helper()
 
@@ -0,0 +1,97 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kademlia
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/topology/kademlia/internal/metrics"
+)
+
+const (
+	maxConcurrentPings = 8               // the number of connected peers pinged at the same time
+	peerPingTimeout    = 5 * time.Second // timeout of a single latency measurement
+)
+
+var peerLatencyInterval = time.Minute
+
+// PeerLatency returns the moving average of the round trip time of the
+// peer. It reports false if the latency of the peer is not measured yet.
+func (k *Kad) PeerLatency(peer penguin.Address) (latency time.Duration, ok bool) {
+	k.collector.Inspect(peer, func(ss *metrics.Snapshot) {
+		if ss != nil && ss.LatencyEWMA > 0 {
+			latency, ok = ss.LatencyEWMA, true
+		}
+	})
+	return latency, ok
+}
+
+// recordPeerLatencies pings all connected peers and
+// records the measured round trip times in the peer metrics.
+func (k *Kad) recordPeerLatencies(ctx context.Context) {
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, maxConcurrentPings)
+	)
+
+	_ = k.connectedPeers.EachBin(func(peer penguin.Address, _ uint8) (bool, bool, error) {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return true, false, nil
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			ctx, cancel := context.WithTimeout(ctx, peerPingTimeout)
+			defer cancel()
+
+			rtt, err := k.pinger.Ping(ctx, peer, "ping")
+			if err != nil {
+				k.logger.Debugf("kademlia: ping peer %q: %v", peer, err)
+				return
+			}
+			k.collector.Record(peer, metrics.PeerLatency(rtt))
+		}()
+		return false, false, nil
+	})
+
+	wg.Wait()
+}
+
+// latencyLoop periodically measures the latencies of the connected peers until halted.
+func (k *Kad) latencyLoop() {
+	defer k.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-k.quit:
+		case <-k.halt:
+		}
+		cancel()
+	}()
+
+	ticker := time.NewTicker(peerLatencyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			k.recordPeerLatencies(ctx)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kademlia
+
+import (
+	"errors"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/addressbook"
+	"github.com/penguintop/penguin/pkg/penguin"
+)
+
+const (
+	addressBookMaxFailures  = 10                 // consecutive failed dials after which the address book entry is pruned
+	addressBookMaxAge       = 7 * 24 * time.Hour // period without a successful dial after which the address book entry is pruned
+	addressBookPruneBackoff = 24 * time.Hour     // period in which the gossiped pruned entry is not added again
+)
+
+var addressBookPruneInterval = 30 * time.Minute
+
+// recordDial records the outcome of the dial to the peer in the address book.
+func (k *Kad) recordDial(peer penguin.Address, success bool) {
+	if err := k.addressBook.RecordDial(peer, success); err != nil && !errors.Is(err, addressbook.ErrNotFound) {
+		k.logger.Debugf("kademlia: record dial to peer %q: %v", peer, err)
+	}
+}
+
+// pruneAddressBook removes the dead peers from the address
+// book and from the known peers. Connected peers are kept.
+func (k *Kad) pruneAddressBook() error {
+	pruned, err := k.addressBook.Prune(addressbook.PruneOptions{
+		MaxFailures: addressBookMaxFailures,
+		MaxAge:      addressBookMaxAge,
+		Backoff:     addressBookPruneBackoff,
+		Keep:        k.connectedPeers.Exists,
+	})
+	for _, addr := range pruned {
+		k.waitNext.Remove(addr)
+		k.knownPeers.Remove(addr)
+	}
+	if len(pruned) > 0 {
+		k.logger.Debugf("kademlia: pruned %d peers from address book", len(pruned))
+	}
+	return err
+}
+
+// addressBookPruneLoop periodically prunes the address book until halted.
+func (k *Kad) addressBookPruneLoop() {
+	defer k.wg.Done()
+
+	ticker := time.NewTicker(addressBookPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.quit:
+			return
+		case <-k.halt:
+			return
+		case <-ticker.C:
+			if err := k.pruneAddressBook(); err != nil {
+				k.logger.Debugf("kademlia: prune address book: %v", err)
+			}
+		}
+	}
+}
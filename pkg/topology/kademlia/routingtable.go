@@ -0,0 +1,207 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kademlia
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/storage"
+	"github.com/penguintop/penguin/pkg/topology/kademlia/internal/metrics"
+)
+
+const (
+	routingTableKey = "kademlia_routing_table"
+
+	warmRestartWorkers = 16 // the number of parallel connection attempts on warm restart
+)
+
+var (
+	routingTableSaveInterval = 5 * time.Minute
+	warmRestartTimeout       = 15 * time.Second // the time limit of the warm restart
+)
+
+// routingPeer is a persisted connected peer with its quality indicators.
+type routingPeer struct {
+	Address                 penguin.Address `json:"address"`
+	Bin                     uint8           `json:"bin"`
+	LastSeen                int64           `json:"lastSeen"`
+	ConnectionTotalDuration time.Duration   `json:"connectionTotalDuration"`
+	Score                   float64         `json:"score,omitempty"`
+}
+
+// routingTable is the persisted set of connected peers.
+type routingTable struct {
+	Peers     []routingPeer `json:"peers"`
+	Timestamp int64         `json:"timestamp"`
+}
+
+// saveRoutingTable persists the connected peers. The previously saved
+// table is kept when there are no connected peers, so that a node which
+// lost its connections can still restart from the last known good peers.
+func (k *Kad) saveRoutingTable() error {
+	if k.store == nil {
+		return nil
+	}
+
+	var peers []routingPeer
+	_ = k.connectedPeers.EachBin(func(addr penguin.Address, po uint8) (bool, bool, error) {
+		peers = append(peers, routingPeer{Address: addr, Bin: po})
+		return false, false, nil
+	})
+	if len(peers) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	addrs := make([]penguin.Address, 0, len(peers))
+	for _, p := range peers {
+		addrs = append(addrs, p.Address)
+	}
+	snapshots := k.collector.Snapshot(now, addrs...)
+	for i, p := range peers {
+		peers[i].LastSeen = now.Unix()
+		if ss, ok := snapshots[p.Address.ByteString()]; ok {
+			peers[i].ConnectionTotalDuration = ss.ConnectionTotalDuration
+		}
+		if k.scorer != nil {
+			peers[i].Score = k.scorer.Score(p.Address)
+		}
+	}
+
+	return k.store.Put(routingTableKey, &routingTable{
+		Peers:     peers,
+		Timestamp: now.Unix(),
+	})
+}
+
+// loadRoutingTable returns the persisted peers, best quality first.
+func (k *Kad) loadRoutingTable() ([]routingPeer, error) {
+	if k.store == nil {
+		return nil, nil
+	}
+
+	var rt routingTable
+	if err := k.store.Get(routingTableKey, &rt); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	peers := rt.Peers
+	sort.SliceStable(peers, func(i, j int) bool {
+		if peers[i].Score != peers[j].Score {
+			return peers[i].Score > peers[j].Score
+		}
+		return peers[i].ConnectionTotalDuration > peers[j].ConnectionTotalDuration
+	})
+	return peers, nil
+}
+
+// routingTableLoop periodically persists the connected peers until halted.
+func (k *Kad) routingTableLoop() {
+	defer k.wg.Done()
+
+	ticker := time.NewTicker(routingTableSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.quit:
+			return
+		case <-k.halt:
+			return
+		case <-ticker.C:
+			if err := k.saveRoutingTable(); err != nil {
+				k.logger.Debugf("kademlia: save routing table: %v", err)
+			}
+		}
+	}
+}
+
+// warmRestart reconnects in parallel to the peers which were connected
+// before the restart. It gives up after warmRestartTimeout, so that the
+// manage loop is not held back by unreachable peers, and returns the
+// number of established connections.
+func (k *Kad) warmRestart(ctx context.Context) int {
+	ctx, cancel := context.WithTimeout(ctx, warmRestartTimeout)
+	defer cancel()
+
+	peers, err := k.loadRoutingTable()
+	if err != nil {
+		k.logger.Debugf("kademlia: load routing table: %v", err)
+		return 0
+	}
+	if len(peers) == 0 {
+		return 0
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		connected int
+		peersC    = make(chan routingPeer)
+	)
+	for i := 0; i < warmRestartWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range peersC {
+				penAddr, err := k.addressBook.Get(p.Address)
+				if err != nil {
+					k.logger.Debugf("kademlia: warm restart: address book entry for peer %q: %v", p.Address, err)
+					continue
+				}
+				if err := k.connect(ctx, p.Address, penAddr.Underlay); err != nil {
+					k.logger.Debugf("kademlia: warm restart: connect to peer %q: %v", p.Address, err)
+					continue
+				}
+				k.knownPeers.Add(p.Address)
+				k.outboundConnected(p.Address)
+
+				mu.Lock()
+				connected++
+				mu.Unlock()
+			}
+		}()
+	}
+
+loop:
+	for _, p := range peers {
+		select {
+		case peersC <- p:
+		case <-ctx.Done():
+			break loop
+		case <-k.halt:
+			break loop
+		}
+	}
+	close(peersC)
+	wg.Wait()
+
+	k.logger.Debugf("kademlia: warm restart connected to %d of %d persisted peers", connected, len(peers))
+	return connected
+}
+
+// outboundConnected updates the topology after an outbound connection is established.
+func (k *Kad) outboundConnected(addr penguin.Address) {
+	k.waitNext.Set(addr, time.Now().Add(shortRetry), 0)
+
+	k.connectedPeers.Add(addr)
+
+	k.collector.Record(addr, metrics.PeerLogIn(time.Now(), metrics.PeerConnectionDirectionOutbound))
+
+	k.depthMu.Lock()
+	k.depth = recalcDepth(k.connectedPeers, k.radius)
+	k.depthMu.Unlock()
+
+	k.notifyManageLoop()
+	k.notifyPeerSig()
+}
@@ -0,0 +1,288 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package simulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/penguintop/penguin/pkg/addressbook"
+	"github.com/penguintop/penguin/pkg/p2p"
+	"github.com/penguintop/penguin/pkg/pen"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/topology/kademlia"
+)
+
+var (
+	errUnreachable = errors.New("unreachable")
+	errRejected    = errors.New("connection rejected")
+)
+
+// node is a simulated network participant.
+type node struct {
+	address     pen.Address
+	addressBook addressbook.Interface
+	kad         *kademlia.Kad
+	up          bool // guarded by the network mutex
+}
+
+// network is an in-memory network connecting the simulated nodes. It
+// mirrors the libp2p behaviour which is relevant to the topology: the
+// dialed node may refuse the connection with the picky notifier, both
+// sides are notified about established and closed connections and the
+// address of the connected peer is stored in the address book.
+type network struct {
+	mu        sync.Mutex
+	underlays map[string]*node           // nodes by underlay
+	overlays  map[string]*node           // nodes by overlay
+	conns     map[string]map[string]bool // connected overlays by overlay
+
+	// activity is incremented on every topology related action,
+	// it is used to detect that the network has settled.
+	activity uint64
+}
+
+func newNetwork() *network {
+	return &network{
+		underlays: make(map[string]*node),
+		overlays:  make(map[string]*node),
+		conns:     make(map[string]map[string]bool),
+	}
+}
+
+func (n *network) touch() {
+	atomic.AddUint64(&n.activity, 1)
+}
+
+// settle blocks until there is no activity in the network for the
+// interval or until the timeout. It reports whether the network settled.
+func (n *network) settle(interval, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	last := atomic.LoadUint64(&n.activity)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		current := atomic.LoadUint64(&n.activity)
+		if current == last {
+			return true
+		}
+		last = current
+	}
+	return false
+}
+
+func (n *network) add(nd *node) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	nd.up = true
+	n.underlays[nd.address.Underlay.String()] = nd
+	n.overlays[nd.address.Overlay.ByteString()] = nd
+	n.conns[nd.address.Overlay.ByteString()] = make(map[string]bool)
+}
+
+// remove makes the node unreachable and closes all of its connections.
+func (n *network) remove(nd *node) {
+	n.mu.Lock()
+	nd.up = false
+	key := nd.address.Overlay.ByteString()
+	var peers []*node
+	for p := range n.conns[key] {
+		peers = append(peers, n.overlays[p])
+		delete(n.conns[p], key)
+	}
+	delete(n.conns, key)
+	delete(n.underlays, nd.address.Underlay.String())
+	delete(n.overlays, key)
+	n.mu.Unlock()
+
+	n.touch()
+	for _, p := range peers {
+		p.kad.Disconnected(p2p.Peer{Address: nd.address.Overlay, FullNode: true})
+	}
+}
+
+// node returns the live node with the overlay address.
+func (n *network) node(overlay penguin.Address) (*node, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	nd, ok := n.overlays[overlay.ByteString()]
+	return nd, ok
+}
+
+func (n *network) connected(a, b penguin.Address) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.conns[a.ByteString()][b.ByteString()]
+}
+
+func (n *network) connect(ctx context.Context, from *node, underlay ma.Multiaddr) (*pen.Address, error) {
+	n.touch()
+
+	n.mu.Lock()
+	to, ok := n.underlays[underlay.String()]
+	if !ok || !to.up || !from.up {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("dial %s: %w", underlay, errUnreachable)
+	}
+	fromKey, toKey := from.address.Overlay.ByteString(), to.address.Overlay.ByteString()
+	if fromKey == toKey {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("dial %s: %w", underlay, errRejected)
+	}
+	if n.conns[fromKey][toKey] {
+		n.mu.Unlock()
+		addr := to.address
+		return &addr, p2p.ErrAlreadyConnected
+	}
+	n.mu.Unlock()
+
+	inbound := p2p.Peer{Address: from.address.Overlay, FullNode: true}
+	if !to.kad.Pick(inbound) {
+		return nil, fmt.Errorf("dial %s: %w", underlay, errRejected)
+	}
+
+	if err := to.addressBook.PutWithSource(from.address.Overlay, from.address, addressbook.SourceHandshake); err != nil {
+		return nil, err
+	}
+	if err := from.addressBook.PutWithSource(to.address.Overlay, to.address, addressbook.SourceHandshake); err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	if !to.up || !from.up {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("dial %s: %w", underlay, errUnreachable)
+	}
+	n.conns[fromKey][toKey] = true
+	n.conns[toKey][fromKey] = true
+	n.mu.Unlock()
+
+	if err := to.kad.Connected(ctx, inbound); err != nil {
+		_ = n.disconnect(to, from.address.Overlay)
+		return nil, fmt.Errorf("dial %s: %v: %w", underlay, err, errRejected)
+	}
+
+	addr := to.address
+	return &addr, nil
+}
+
+func (n *network) disconnect(from *node, overlay penguin.Address) error {
+	n.touch()
+
+	n.mu.Lock()
+	fromKey, toKey := from.address.Overlay.ByteString(), overlay.ByteString()
+	if !n.conns[fromKey][toKey] {
+		n.mu.Unlock()
+		return p2p.ErrPeerNotFound
+	}
+	delete(n.conns[fromKey], toKey)
+	delete(n.conns[toKey], fromKey)
+	to := n.overlays[toKey]
+	n.mu.Unlock()
+
+	from.kad.Disconnected(p2p.Peer{Address: overlay, FullNode: true})
+	if to != nil {
+		to.kad.Disconnected(p2p.Peer{Address: from.address.Overlay, FullNode: true})
+	}
+	return nil
+}
+
+func (n *network) peers(nd *node) []p2p.Peer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	conns := n.conns[nd.address.Overlay.ByteString()]
+	peers := make([]p2p.Peer, 0, len(conns))
+	for k := range conns {
+		peers = append(peers, p2p.Peer{Address: penguin.NewAddress([]byte(k)), FullNode: true})
+	}
+	return peers
+}
+
+// broadcast delivers the addresses of the peers, known to the sender,
+// to the addressee in the same way as the hive protocol does.
+func (n *network) broadcast(from *node, addressee penguin.Address, peers ...penguin.Address) error {
+	n.touch()
+
+	to, ok := n.node(addressee)
+	if !ok || !n.connected(from.address.Overlay, addressee) {
+		return p2p.ErrPeerNotFound
+	}
+
+	var received []penguin.Address
+	for _, p := range peers {
+		addr, err := from.addressBook.Get(p)
+		if err != nil {
+			if errors.Is(err, addressbook.ErrNotFound) {
+				continue
+			}
+			return err
+		}
+		if err := to.addressBook.PutWithSource(p, *addr, addressbook.SourceHive); err != nil {
+			return err
+		}
+		received = append(received, p)
+	}
+	to.kad.AddPeers(received...)
+	return nil
+}
+
+// service is the p2p.Service of a simulated node.
+type service struct {
+	network *network
+	node    *node
+}
+
+var _ p2p.Service = (*service)(nil)
+
+func (s *service) AddProtocol(p2p.ProtocolSpec) error {
+	return nil
+}
+
+func (s *service) Connect(ctx context.Context, addr ma.Multiaddr) (*pen.Address, error) {
+	return s.network.connect(ctx, s.node, addr)
+}
+
+func (s *service) Disconnect(overlay penguin.Address) error {
+	return s.network.disconnect(s.node, overlay)
+}
+
+func (s *service) Blocklist(overlay penguin.Address, _ time.Duration, _ string) error {
+	return s.network.disconnect(s.node, overlay)
+}
+
+func (s *service) Peers() []p2p.Peer {
+	return s.network.peers(s.node)
+}
+
+func (s *service) BlocklistedPeers() ([]p2p.BlocklistedPeer, error) {
+	return nil, nil
+}
+
+func (s *service) Addresses() ([]ma.Multiaddr, error) {
+	return []ma.Multiaddr{s.node.address.Underlay}, nil
+}
+
+// SetPickyNotifier is a noop, as the network notifies the kademlia of the node directly.
+func (s *service) SetPickyNotifier(p2p.PickyNotifier) {}
+
+func (s *service) Halt() {}
+
+// discovery is the discovery.Driver of a simulated node.
+type discovery struct {
+	network *network
+	node    *node
+}
+
+func (d *discovery) BroadcastPeers(_ context.Context, addressee penguin.Address, peers ...penguin.Address) error {
+	return d.network.broadcast(d.node, addressee, peers...)
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package simulation
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Report is the result of a simulation run. It is serializable to JSON,
+// so that reports of different code revisions can be stored and compared.
+type Report struct {
+	Options      ReportOptions `json:"options"`
+	Measurements []Measurement `json:"measurements"`
+	Duration     time.Duration `json:"duration"`
+}
+
+// ReportOptions are the simulation parameters the report was created with.
+type ReportOptions struct {
+	Nodes           int     `json:"nodes"`
+	Bootnodes       int     `json:"bootnodes"`
+	Ticks           int     `json:"ticks"`
+	ChurnRate       float64 `json:"churnRate"`
+	MeasureInterval int     `json:"measureInterval"`
+	Lookups         int     `json:"lookups"`
+	MaxHops         int     `json:"maxHops"`
+	Seed            int64   `json:"seed"`
+	BitSuffixLength int     `json:"bitSuffixLength"`
+}
+
+func newReportOptions(o Options) ReportOptions {
+	return ReportOptions{
+		Nodes:           o.Nodes,
+		Bootnodes:       o.Bootnodes,
+		Ticks:           o.Ticks,
+		ChurnRate:       o.ChurnRate,
+		MeasureInterval: o.MeasureInterval,
+		Lookups:         o.Lookups,
+		MaxHops:         o.MaxHops,
+		Seed:            o.Seed,
+		BitSuffixLength: o.Kademlia.BitSuffixLength,
+	}
+}
+
+// Measurement is the state of the topology at a point of the virtual time.
+type Measurement struct {
+	Tick int `json:"tick"`
+	// Nodes is the number of live nodes.
+	Nodes int `json:"nodes"`
+	// Joined and Left are the numbers of nodes which joined
+	// and left the network since the previous measurement.
+	Joined int `json:"joined"`
+	Left   int `json:"left"`
+	// Settled reports whether the network settled before the measurement.
+	Settled bool `json:"settled"`
+	// Connections is the average number of connected peers of a node.
+	Connections float64 `json:"connections"`
+	// Depth and IdealDepth are the average depth of the nodes and the
+	// average depth the nodes would have if connected to all live peers.
+	Depth      float64 `json:"depth"`
+	IdealDepth float64 `json:"idealDepth"`
+	// DepthAccuracy is the fraction of nodes which are at the ideal depth.
+	DepthAccuracy float64 `json:"depthAccuracy"`
+	// DepthError is the average absolute difference from the ideal depth.
+	DepthError float64 `json:"depthError"`
+	// Saturation is the fraction of bins shallower than depth which are saturated.
+	Saturation float64 `json:"saturation"`
+	Routing    Routing `json:"routing"`
+}
+
+// Routing are the results of the routing lookups.
+type Routing struct {
+	Lookups int `json:"lookups"`
+	// Failed is the number of lookups which did not terminate
+	// at a node, because of no peers or too many hops.
+	Failed int `json:"failed"`
+	// Success is the fraction of lookups which terminated
+	// at the live node closest to the target address.
+	Success  float64 `json:"success"`
+	MeanHops float64 `json:"meanHops"`
+	MaxHops  int     `json:"maxHops"`
+	// Histogram is the number of terminated lookups by hop count.
+	Histogram []int `json:"histogram"`
+}
+
+// Final returns the last measurement.
+func (r *Report) Final() (Measurement, bool) {
+	if len(r.Measurements) == 0 {
+		return Measurement{}, false
+	}
+	return r.Measurements[len(r.Measurements)-1], true
+}
+
+// WriteText writes the report as a human readable table.
+func (r *Report) WriteText(w io.Writer) error {
+	o := r.Options
+	if _, err := fmt.Fprintf(w, "nodes %d, bootnodes %d, ticks %d, churn rate %.3f, lookups %d, seed %d, duration %s\n\n",
+		o.Nodes, o.Bootnodes, o.Ticks, o.ChurnRate, o.Lookups, o.Seed, r.Duration.Round(time.Millisecond)); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	if _, err := fmt.Fprintln(tw, "tick\tnodes\tjoined\tleft\tsettled\tconns\tdepth\tideal\taccuracy\tdepth err\tsaturation\tsuccess\tfailed\tmean hops\tmax hops\t"); err != nil {
+		return err
+	}
+	for _, m := range r.Measurements {
+		if _, err := fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%t\t%.2f\t%.2f\t%.2f\t%.3f\t%.3f\t%.3f\t%.3f\t%d\t%.2f\t%d\t\n",
+			m.Tick, m.Nodes, m.Joined, m.Left, m.Settled, m.Connections, m.Depth, m.IdealDepth,
+			m.DepthAccuracy, m.DepthError, m.Saturation,
+			m.Routing.Success, m.Routing.Failed, m.Routing.MeanHops, m.Routing.MaxHops); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteComparison writes the final measurements of the base
+// and the report side by side with the differences.
+func (r *Report) WriteComparison(w io.Writer, base *Report) error {
+	b, _ := base.Final()
+	m, _ := r.Final()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	if _, err := fmt.Fprintln(tw, "metric\tbase\tcurrent\tdiff\t"); err != nil {
+		return err
+	}
+	for _, row := range []struct {
+		name       string
+		base, curr float64
+	}{
+		{"connections", b.Connections, m.Connections},
+		{"depth", b.Depth, m.Depth},
+		{"ideal depth", b.IdealDepth, m.IdealDepth},
+		{"depth accuracy", b.DepthAccuracy, m.DepthAccuracy},
+		{"depth error", b.DepthError, m.DepthError},
+		{"saturation", b.Saturation, m.Saturation},
+		{"routing success", b.Routing.Success, m.Routing.Success},
+		{"routing failed", float64(b.Routing.Failed), float64(m.Routing.Failed)},
+		{"mean hops", b.Routing.MeanHops, m.Routing.MeanHops},
+		{"max hops", float64(b.Routing.MaxHops), float64(m.Routing.MaxHops)},
+	} {
+		if _, err := fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%+.3f\t\n", row.name, row.base, row.curr, row.curr-row.base); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,549 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package simulation runs many kademlia instances over an in-memory network
+// to evaluate the connection strategy without deploying it.
+//
+// The simulation is driven by a queue of discrete events on a virtual clock
+// measured in ticks. Node joins and leaves (churn) and measurements are
+// scheduled on the queue. All events of a tick are applied together, after
+// which the simulation waits for the kademlia instances, that run their
+// connection management concurrently, to settle before advancing the clock.
+// Measurements capture depth convergence, bin saturation and routing hop
+// counts of ClosestPeer lookups and are collected into a Report.
+package simulation
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/penguintop/penguin/pkg/addressbook"
+	"github.com/penguintop/penguin/pkg/logging"
+	"github.com/penguintop/penguin/pkg/pen"
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/shed"
+	mockstate "github.com/penguintop/penguin/pkg/statestore/mock"
+	"github.com/penguintop/penguin/pkg/topology"
+	"github.com/penguintop/penguin/pkg/topology/kademlia"
+)
+
+const (
+	defaultNodes           = 100
+	defaultBootnodes       = 1
+	defaultTicks           = 10
+	defaultMeasureInterval = 1
+	defaultLookups         = 100
+	defaultMaxHops         = 32
+	defaultSettleInterval  = 100 * time.Millisecond
+	defaultSettleTimeout   = time.Minute
+
+	// The following values mirror the kademlia connection strategy
+	// and are used to calculate the ideal topology of a node.
+	nnLowWatermark       = 2
+	quickSaturationPeers = 4
+	saturationPeers      = 8
+)
+
+// Options are the parameters of the simulation.
+type Options struct {
+	// Nodes is the number of nodes which join the network at the start.
+	Nodes int
+	// Bootnodes is the number of bootnodes, which do not churn.
+	Bootnodes int
+	// Ticks is the duration of the simulation after the initial join.
+	// A negative value ends the simulation after the initial join.
+	Ticks int
+	// ChurnRate is the fraction of nodes which leave the network in every
+	// tick. The same number of new nodes joins, keeping the size stable.
+	ChurnRate float64
+	// MeasureInterval is the number of ticks between measurements.
+	MeasureInterval int
+	// Lookups is the number of routing lookups in every measurement.
+	Lookups int
+	// MaxHops is the number of hops after which a lookup fails.
+	MaxHops int
+	// Seed makes overlay addresses, churn and lookups reproducible.
+	Seed int64
+	// SettleInterval is the duration without any topology activity
+	// after which the network is considered settled.
+	SettleInterval time.Duration
+	// SettleTimeout is the maximal duration of waiting for the network to settle.
+	SettleTimeout time.Duration
+	// Kademlia are the options of every kademlia instance. Bootnodes
+	// and BootnodeMode are set by the simulation.
+	Kademlia kademlia.Options
+	Logger   logging.Logger
+}
+
+func (o *Options) setDefaults() {
+	if o.Nodes <= 0 {
+		o.Nodes = defaultNodes
+	}
+	if o.Bootnodes <= 0 {
+		o.Bootnodes = defaultBootnodes
+	}
+	if o.Ticks < 0 {
+		o.Ticks = 0
+	} else if o.Ticks == 0 {
+		o.Ticks = defaultTicks
+	}
+	if o.MeasureInterval <= 0 {
+		o.MeasureInterval = defaultMeasureInterval
+	}
+	if o.Lookups <= 0 {
+		o.Lookups = defaultLookups
+	}
+	if o.MaxHops <= 0 {
+		o.MaxHops = defaultMaxHops
+	}
+	if o.SettleInterval <= 0 {
+		o.SettleInterval = defaultSettleInterval
+	}
+	if o.SettleTimeout <= 0 {
+		o.SettleTimeout = defaultSettleTimeout
+	}
+	if o.Logger == nil {
+		o.Logger = logging.New(ioutil.Discard, 0)
+	}
+}
+
+type eventType int
+
+const (
+	eventJoin eventType = iota
+	eventLeave
+	eventChurn
+	eventMeasure
+)
+
+type event struct {
+	tick int
+	seq  int // preserves the scheduling order of events in the same tick
+	typ  eventType
+}
+
+// eventQueue is a priority queue of events ordered by the virtual time.
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].tick != q[j].tick {
+		return q[i].tick < q[j].tick
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Simulation is a single simulation run.
+type Simulation struct {
+	o         Options
+	rand      *rand.Rand
+	network   *network
+	metricsDB *shed.DB
+	bootnodes []ma.Multiaddr
+	nodes     []*node // live nodes in the order of joining
+	count     int     // number of created nodes, used for underlay addresses
+
+	queue eventQueue
+	seq   int
+
+	joined, left int // since the last measurement
+	report       *Report
+
+	closing sync.WaitGroup // kademlia instances of the nodes which left
+}
+
+// New creates a new simulation.
+func New(o Options) *Simulation {
+	o.setDefaults()
+	return &Simulation{
+		o:       o,
+		rand:    rand.New(rand.NewSource(o.Seed)),
+		network: newNetwork(),
+		report:  &Report{Options: newReportOptions(o)},
+	}
+}
+
+func (s *Simulation) schedule(tick int, typ eventType) {
+	s.seq++
+	heap.Push(&s.queue, &event{tick: tick, seq: s.seq, typ: typ})
+}
+
+// Run runs the simulation and returns the report. All nodes
+// are shut down when the simulation ends.
+func (s *Simulation) Run(ctx context.Context) (*Report, error) {
+	start := time.Now()
+
+	// metrics are not a subject of the simulation, a single in-memory
+	// database is shared by all nodes to keep the memory footprint low
+	metricsDB, err := shed.NewDB("", nil)
+	if err != nil {
+		return nil, fmt.Errorf("metrics db: %w", err)
+	}
+	s.metricsDB = metricsDB
+	defer s.metricsDB.Close()
+	defer s.shutdown()
+
+	for i := 0; i < s.o.Bootnodes; i++ {
+		if err := s.join(ctx, true); err != nil {
+			return nil, err
+		}
+	}
+	s.settle()
+
+	for i := 0; i < s.o.Nodes; i++ {
+		s.schedule(0, eventJoin)
+	}
+	for t := 1; t <= s.o.Ticks; t++ {
+		if s.o.ChurnRate > 0 {
+			s.schedule(t, eventChurn)
+		}
+	}
+	for t := 0; t <= s.o.Ticks; t += s.o.MeasureInterval {
+		s.schedule(t, eventMeasure)
+	}
+
+	for s.queue.Len() > 0 {
+		tick := s.queue[0].tick
+		var measure bool
+		for s.queue.Len() > 0 && s.queue[0].tick == tick {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			e := heap.Pop(&s.queue).(*event)
+			switch e.typ {
+			case eventJoin:
+				if err := s.join(ctx, false); err != nil {
+					return nil, err
+				}
+			case eventLeave:
+				s.leave()
+			case eventChurn:
+				// churn expands into the individual events of the same tick
+				count := int(s.o.ChurnRate*float64(len(s.nodes)-s.o.Bootnodes) + s.rand.Float64())
+				for i := 0; i < count; i++ {
+					s.schedule(tick, eventLeave)
+					s.schedule(tick, eventJoin)
+				}
+			case eventMeasure:
+				// measurements are taken after all changes of the tick settle
+				measure = true
+			}
+		}
+
+		settled := s.settle()
+		if measure {
+			m := s.measure(tick)
+			m.Settled = settled
+			s.report.Measurements = append(s.report.Measurements, m)
+		}
+	}
+
+	s.report.Duration = time.Since(start)
+	return s.report, nil
+}
+
+func (s *Simulation) settle() bool {
+	settled := s.network.settle(s.o.SettleInterval, s.o.SettleTimeout)
+	if !settled {
+		s.o.Logger.Debugf("simulation: network did not settle in %s", s.o.SettleTimeout)
+	}
+	return settled
+}
+
+// join creates a new node and starts its kademlia.
+func (s *Simulation) join(ctx context.Context, bootnode bool) error {
+	s.count++
+	underlay, err := ma.NewMultiaddr(fmt.Sprintf("/ip4/10.%d.%d.%d/tcp/1634", byte(s.count>>16), byte(s.count>>8), byte(s.count)))
+	if err != nil {
+		return err
+	}
+	overlay := make([]byte, penguin.HashSize)
+	_, _ = s.rand.Read(overlay)
+
+	nd := &node{
+		address: pen.Address{
+			Underlay: underlay,
+			Overlay:  penguin.NewAddress(overlay),
+		},
+		addressBook: addressbook.New(mockstate.NewStateStore()),
+	}
+
+	o := s.o.Kademlia
+	o.Bootnodes = s.bootnodes
+	o.BootnodeMode = bootnode
+	nd.kad = kademlia.New(
+		nd.address.Overlay,
+		nd.addressBook,
+		&discovery{network: s.network, node: nd},
+		&service{network: s.network, node: nd},
+		s.metricsDB,
+		s.o.Logger,
+		o,
+	)
+	// depth is not limited by the storage radius in the simulation
+	nd.kad.SetRadius(penguin.MaxPO)
+
+	s.network.add(nd)
+	if err := nd.kad.Start(ctx); err != nil {
+		return fmt.Errorf("start node %s: %w", nd.address.Overlay, err)
+	}
+
+	if bootnode {
+		s.bootnodes = append(s.bootnodes, underlay)
+	}
+	s.nodes = append(s.nodes, nd)
+	s.joined++
+	return nil
+}
+
+// leave shuts down a random node which is not a bootnode.
+func (s *Simulation) leave() {
+	if len(s.nodes) <= s.o.Bootnodes {
+		return
+	}
+	// bootnodes are the first nodes and never leave
+	i := s.o.Bootnodes + s.rand.Intn(len(s.nodes)-s.o.Bootnodes)
+	nd := s.nodes[i]
+	s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+
+	s.network.remove(nd)
+	s.closing.Add(1)
+	go func() {
+		defer s.closing.Done()
+		// closing waits for the connection attempts to finish
+		_ = nd.kad.Close()
+	}()
+	s.left++
+}
+
+func (s *Simulation) shutdown() {
+	for _, nd := range s.nodes {
+		nd.kad.Halt()
+	}
+	for _, nd := range s.nodes {
+		s.network.remove(nd)
+	}
+	for _, nd := range s.nodes {
+		_ = nd.kad.Close()
+	}
+	s.nodes = nil
+	s.closing.Wait()
+}
+
+// measure captures the state of the topology.
+func (s *Simulation) measure(tick int) Measurement {
+	m := Measurement{
+		Tick:   tick,
+		Nodes:  len(s.nodes),
+		Joined: s.joined,
+		Left:   s.left,
+	}
+	s.joined, s.left = 0, 0
+
+	if len(s.nodes) == 0 {
+		return m
+	}
+
+	var (
+		connections                int
+		depthSum, idealSum, errSum int
+		accurate                   int
+		saturatedBins, shallowBins int
+		live                       = make([]int, penguin.MaxBins)
+		connected                  = make([]int, penguin.MaxBins)
+	)
+	for _, nd := range s.nodes {
+		for i := range live {
+			live[i], connected[i] = 0, 0
+		}
+		base := nd.address.Overlay.Bytes()
+		for _, other := range s.nodes {
+			if other == nd {
+				continue
+			}
+			live[penguin.Proximity(base, other.address.Overlay.Bytes())]++
+		}
+		_ = nd.kad.EachPeer(func(_ penguin.Address, po uint8) (bool, bool, error) {
+			connected[po]++
+			connections++
+			return false, false, nil
+		})
+
+		depth := int(nd.kad.NeighborhoodDepth())
+		ideal := int(idealDepth(live))
+		depthSum += depth
+		idealSum += ideal
+		if depth == ideal {
+			accurate++
+		}
+		if depth > ideal {
+			errSum += depth - ideal
+		} else {
+			errSum += ideal - depth
+		}
+
+		// bins shallower than depth are saturated when they have the
+		// saturation number of peers, or all live peers in that bin
+		for bin := 0; bin < depth; bin++ {
+			want := saturationPeers
+			if live[bin] < want {
+				want = live[bin]
+			}
+			shallowBins++
+			if connected[bin] >= want {
+				saturatedBins++
+			}
+		}
+	}
+
+	n := float64(len(s.nodes))
+	m.Connections = float64(connections) / n
+	m.Depth = float64(depthSum) / n
+	m.IdealDepth = float64(idealSum) / n
+	m.DepthAccuracy = float64(accurate) / n
+	m.DepthError = float64(errSum) / n
+	m.Saturation = 1
+	if shallowBins > 0 {
+		m.Saturation = float64(saturatedBins) / float64(shallowBins)
+	}
+	m.Routing = s.lookups()
+
+	return m
+}
+
+// lookups forwards requests for random addresses from random nodes
+// to the closest peer until a node considers itself the closest one.
+func (s *Simulation) lookups() Routing {
+	r := Routing{
+		Lookups:   s.o.Lookups,
+		Histogram: make([]int, s.o.MaxHops+1),
+	}
+
+	var hopsSum, succeeded int
+	for i := 0; i < s.o.Lookups; i++ {
+		target := make([]byte, penguin.HashSize)
+		_, _ = s.rand.Read(target)
+		origin := s.nodes[s.rand.Intn(len(s.nodes))]
+
+		dest, hops, err := s.route(origin, target)
+		if err != nil {
+			r.Failed++
+			continue
+		}
+		if hops > r.MaxHops {
+			r.MaxHops = hops
+		}
+		hopsSum += hops
+		r.Histogram[hops]++
+		if dest == s.closest(target) {
+			succeeded++
+		}
+	}
+
+	if routed := s.o.Lookups - r.Failed; routed > 0 {
+		r.MeanHops = float64(hopsSum) / float64(routed)
+	}
+	r.Success = float64(succeeded) / float64(s.o.Lookups)
+	return r
+}
+
+var errMaxHops = errors.New("max hops exceeded")
+
+func (s *Simulation) route(origin *node, target []byte) (*node, int, error) {
+	addr := penguin.NewAddress(target)
+	current := origin
+	for hops := 0; hops <= s.o.MaxHops; hops++ {
+		next, err := current.kad.ClosestPeer(addr, true)
+		if errors.Is(err, topology.ErrWantSelf) {
+			return current, hops, nil
+		}
+		if err != nil {
+			return nil, hops, err
+		}
+		nd, ok := s.network.node(next)
+		if !ok {
+			return nil, hops, errUnreachable
+		}
+		current = nd
+	}
+	return nil, s.o.MaxHops, errMaxHops
+}
+
+// closest returns the live node closest to the target.
+func (s *Simulation) closest(target []byte) *node {
+	closest := s.nodes[0]
+	for _, nd := range s.nodes[1:] {
+		if c, _ := penguin.DistanceCmp(target, closest.address.Overlay.Bytes(), nd.address.Overlay.Bytes()); c == -1 {
+			closest = nd
+		}
+	}
+	return closest
+}
+
+// idealDepth returns the depth of a node connected to all live peers,
+// given the number of live peers in every bin. The calculation mirrors
+// the kademlia depth calculation.
+func idealDepth(bins []int) uint8 {
+	var total int
+	for _, c := range bins {
+		total += c
+	}
+	if total <= nnLowWatermark {
+		return 0
+	}
+
+	// shallowest bin with less than quickSaturationPeers,
+	// considering only the bins up to the deepest non-empty one
+	var shallowestUnsaturated, binCount int
+	for bin, c := range bins {
+		if c == 0 {
+			continue
+		}
+		if bin == shallowestUnsaturated {
+			binCount += c
+			continue
+		}
+		if binCount < quickSaturationPeers {
+			break
+		}
+		shallowestUnsaturated, binCount = bin, c
+	}
+	for bin, c := range bins {
+		if c == 0 {
+			if bin < shallowestUnsaturated {
+				shallowestUnsaturated = bin
+			}
+			break
+		}
+	}
+
+	var candidate, count int
+	for bin := len(bins) - 1; bin >= 0; bin-- {
+		count += bins[bin]
+		if count >= nnLowWatermark {
+			candidate = bin
+			break
+		}
+	}
+
+	if shallowestUnsaturated > candidate {
+		return uint8(candidate)
+	}
+	return uint8(shallowestUnsaturated)
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 The Penguin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package simulation_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/penguintop/penguin/pkg/penguin"
+	"github.com/penguintop/penguin/pkg/topology/kademlia/simulation"
+)
+
+func TestSimulation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping simulation in short mode")
+	}
+
+	const nodes = 30
+
+	report, err := simulation.New(simulation.Options{
+		Nodes:          nodes,
+		Ticks:          2,
+		ChurnRate:      0.1,
+		Lookups:        30,
+		Seed:           1,
+		SettleInterval: 100 * time.Millisecond,
+	}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	t.Log("\n" + buf.String())
+
+	if got, want := len(report.Measurements), 3; got != want {
+		t.Fatalf("got %v measurements, want %v", got, want)
+	}
+
+	final, ok := report.Final()
+	if !ok {
+		t.Fatal("no final measurement")
+	}
+	// the bootnode is not included in the nodes option
+	if got, want := final.Nodes, nodes+1; got != want {
+		t.Errorf("got %v nodes, want %v", got, want)
+	}
+	if final.Left == 0 || final.Joined != final.Left {
+		t.Errorf("got %v joined and %v left nodes, want equal non zero churn", final.Joined, final.Left)
+	}
+	if final.Connections == 0 {
+		t.Error("no connections")
+	}
+	if final.Routing.Success < 0.5 {
+		t.Errorf("got routing success %v, want at least 0.5", final.Routing.Success)
+	}
+
+	var decoded simulation.Report
+	b, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Options != report.Options || len(decoded.Measurements) != len(report.Measurements) {
+		t.Fatalf("got decoded report %+v, want %+v", decoded, report)
+	}
+}
+
+func TestIdealDepth(t *testing.T) {
+	bins := func(counts map[int]int) []int {
+		b := make([]int, penguin.MaxBins)
+		for bin, c := range counts {
+			b[bin] = c
+		}
+		return b
+	}
+
+	for _, tc := range []struct {
+		name  string
+		bins  []int
+		depth uint8
+	}{
+		{
+			name:  "empty",
+			bins:  bins(nil),
+			depth: 0,
+		},
+		{
+			name:  "up to nn low watermark",
+			bins:  bins(map[int]int{0: 1, 3: 1}),
+			depth: 0,
+		},
+		{
+			name:  "empty shallow bin",
+			bins:  bins(map[int]int{0: 1, 2: 1, 3: 1}),
+			depth: 0,
+		},
+		{
+			name:  "shallowest unsaturated bin",
+			bins:  bins(map[int]int{0: 8, 1: 4, 2: 2, 3: 4, 4: 1}),
+			depth: 2,
+		},
+		{
+			name:  "nearest neighbours",
+			bins:  bins(map[int]int{0: 8, 1: 8, 2: 8, 3: 4, 4: 4, 5: 1, 6: 1}),
+			depth: 5,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := simulation.IdealDepth(tc.bins); got != tc.depth {
+				t.Fatalf("got depth %v, want %v", got, tc.depth)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"io"
 	"time"
 
+	"github.com/penguintop/penguin/pkg/p2p"
     "github.com/penguintop/penguin/pkg/penguin"
 )
 
@@ -45,6 +46,12 @@ type ClosestPeerer interface {
 	ClosestPeer(addr penguin.Address, includeSelf bool, skipPeers ...penguin.Address) (peerAddr penguin.Address, err error)
 }
 
+type LatencyReporter interface {
+	// PeerLatency returns the moving average of the round trip time of
+	// the peer. It reports false if the latency is not measured yet.
+	PeerLatency(addr penguin.Address) (latency time.Duration, ok bool)
+}
+
 type EachPeerer interface {
 	// EachPeer iterates from closest bin to farthest
 	EachPeer(EachPeerFunc) error
@@ -77,6 +84,7 @@ type MetricSnapshotView struct {
 	ConnectionTotalDuration    float64 `json:"connectionTotalDuration"`
 	SessionConnectionDuration  float64 `json:"sessionConnectionDuration"`
 	SessionConnectionDirection string  `json:"sessionConnectionDirection"`
+	LatencyEWMA                int64   `json:"latencyEWMA"`
 }
 
 type BinInfo struct {
@@ -130,6 +138,8 @@ type KadParams struct {
 	Depth          uint8     `json:"depth"`          // current depth
 	Bins           KadBins   `json:"bins"`           // individual bin info
 	LightNodes     BinInfo   `json:"lightNodes"`     // light nodes bin info
+
+	Connections *p2p.ConnectionStats `json:"connections,omitempty"` // connection manager state
 }
 
 type Halter interface {
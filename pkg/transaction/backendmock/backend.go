@@ -29,6 +29,8 @@ type backendMock struct {
 	headerByNumber     func(ctx context.Context, number *big.Int) (*types.Header, error)
 	balanceAt          func(ctx context.Context, address common.Address, block *big.Int) (*big.Int, error)
 	nonceAt            func(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+
+	invokeContractOffline func(ctx context.Context, account common.Address, api string, arg string) (string, error)
 }
 
 func (m *backendMock) RefBlockInfo(ctx context.Context) (uint16, uint32, error) {
@@ -40,6 +42,9 @@ func (m *backendMock) SendXwcTransaction(ctx context.Context, tx *xwcfmt.Transac
 }
 
 func (m *backendMock) InvokeContractOffline(ctx context.Context, account common.Address, api string, arg string) (string, error) {
+	if m.invokeContractOffline != nil {
+		return m.invokeContractOffline(ctx, account, api, arg)
+	}
 	return "", errors.New("not implemented")
 }
 
@@ -174,6 +179,12 @@ func WithCodeAtFunc(f func(ctx context.Context, contract common.Address, blockNu
 	})
 }
 
+func WithInvokeContractOfflineFunc(f func(ctx context.Context, account common.Address, api string, arg string) (string, error)) Option {
+	return optionFunc(func(s *backendMock) {
+		s.invokeContractOffline = f
+	})
+}
+
 func WithPendingNonceAtFunc(f func(ctx context.Context, account common.Address) (uint64, error)) Option {
 	return optionFunc(func(s *backendMock) {
 		s.pendingNonceAt = f
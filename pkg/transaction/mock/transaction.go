@@ -7,6 +7,7 @@ package mock
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/penguintop/penguin/pkg/xwctypes"
@@ -164,3 +165,80 @@ func WithABISend(abi *abi.ABI, txHash common.Hash, expectedAddress common.Addres
 		}
 	})
 }
+
+// XwcCall is an expected call of the contract api with the json encoded
+// call data used by the xwc chain.
+type XwcCall struct {
+	to     common.Address
+	result []byte
+	api    string
+	args   string
+}
+
+func NewXwcCall(to common.Address, result []byte, api string, args string) XwcCall {
+	return XwcCall{
+		to:     to,
+		result: result,
+		api:    api,
+		args:   args,
+	}
+}
+
+func WithXwcCallSequence(calls ...XwcCall) Option {
+	return optionFunc(func(s *transactionServiceMock) {
+		s.call = func(ctx context.Context, request *transaction.TxRequest) ([]byte, error) {
+			if len(calls) == 0 {
+				return nil, errors.New("unexpected call")
+			}
+
+			call := calls[0]
+
+			var callData struct {
+				CallApi  string `json:"CallApi"`
+				CallArgs string `json:"CallArgs"`
+			}
+			if err := json.Unmarshal(request.Data, &callData); err != nil {
+				return nil, err
+			}
+
+			if callData.CallApi != call.api || callData.CallArgs != call.args {
+				return nil, fmt.Errorf("wrong call. wanted %s(%s), got %s(%s)", call.api, call.args, callData.CallApi, callData.CallArgs)
+			}
+
+			if request.To == nil {
+				return nil, errors.New("call with no recipient")
+			}
+			if *request.To != call.to {
+				return nil, fmt.Errorf("wrong recipient. wanted %x, got %x", call.to, *request.To)
+			}
+
+			calls = calls[1:]
+
+			return call.result, nil
+		}
+	})
+}
+
+func WithXwcCall(to common.Address, result []byte, api string, args string) Option {
+	return WithXwcCallSequence(NewXwcCall(to, result, api, args))
+}
+
+func WithXwcInvoke(txHash common.Hash, expectedAddress common.Address, api string, args string) Option {
+	return optionFunc(func(s *transactionServiceMock) {
+		s.send = func(ctx context.Context, request *transaction.TxRequest) (common.Hash, error) {
+			if request.TxType != transaction.TxTypeInvokeContract {
+				return common.Hash{}, fmt.Errorf("wrong transaction type. wanted %d, got %d", transaction.TxTypeInvokeContract, request.TxType)
+			}
+
+			if request.InvokeApi != api || request.InvokeArgs != args {
+				return common.Hash{}, fmt.Errorf("wrong invocation. wanted %s(%s), got %s(%s)", api, args, request.InvokeApi, request.InvokeArgs)
+			}
+
+			if request.To == nil || *request.To != expectedAddress {
+				return common.Hash{}, fmt.Errorf("sending to wrong contract. wanted %x, got %x", expectedAddress, request.To)
+			}
+
+			return txHash, nil
+		}
+	})
+}